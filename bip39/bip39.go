@@ -0,0 +1,137 @@
+package bip39
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/text/unicode/norm"
+)
+
+/* BIP39 mnemonic sentences.  Entropy of 128 to 256 bits (in steps of 32) gets
+a checksum of entropy bits / 32 appended, taken from the front of its sha256.
+The result is split into 11 bit groups, each of which picks a word out of the
+2048 word list.  So 256 bits of entropy is 264 bits, 24 words.
+
+The seed is pbkdf2-hmac-sha512 over the (NFKD normalized) sentence, salted
+with "mnemonic" and an optional passphrase. */
+
+const (
+	MinEntropyBits = 128
+	MaxEntropyBits = 256
+)
+
+// wordIndex maps each word back to its 11 bit value
+var wordIndex map[string]int
+
+func init() {
+	wordIndex = make(map[string]int, len(englishWords))
+	for i, w := range englishWords {
+		wordIndex[w] = i
+	}
+}
+
+// NewEntropy returns bits/8 random bytes, suitable for NewMnemonic.
+func NewEntropy(bits int) ([]byte, error) {
+	err := checkEntropyBits(bits)
+	if err != nil {
+		return nil, err
+	}
+	entropy := make([]byte, bits/8)
+	_, err = rand.Read(entropy)
+	if err != nil {
+		return nil, err
+	}
+	return entropy, nil
+}
+
+func checkEntropyBits(bits int) error {
+	if bits < MinEntropyBits || bits > MaxEntropyBits || bits%32 != 0 {
+		return fmt.Errorf("invalid entropy length %d bits", bits)
+	}
+	return nil
+}
+
+// NewMnemonic encodes entropy as a space separated mnemonic sentence.
+func NewMnemonic(entropy []byte) (string, error) {
+	bits := len(entropy) * 8
+	err := checkEntropyBits(bits)
+	if err != nil {
+		return "", err
+	}
+	csBits := bits / 32
+	hash := sha256.Sum256(entropy)
+
+	// entropy followed by the checksum byte; only the top csBits of that
+	// byte get used
+	data := append(append([]byte{}, entropy...), hash[0])
+
+	nWords := (bits + csBits) / 11
+	words := make([]string, nWords)
+	for i := 0; i < nWords; i++ {
+		words[i] = englishWords[readBits(data, i*11, 11)]
+	}
+	return strings.Join(words, " "), nil
+}
+
+// EntropyFromMnemonic decodes a mnemonic sentence, checking the word count,
+// that every word is in the list, and the checksum.
+func EntropyFromMnemonic(mnemonic string) ([]byte, error) {
+	words := strings.Fields(mnemonic)
+	if len(words)%3 != 0 || len(words) < 12 || len(words) > 24 {
+		return nil, fmt.Errorf("invalid mnemonic length %d words", len(words))
+	}
+
+	totalBits := len(words) * 11
+	csBits := totalBits / 33
+	bits := totalBits - csBits
+
+	data := make([]byte, (totalBits+7)/8)
+	for i, w := range words {
+		idx, ok := wordIndex[strings.ToLower(w)]
+		if !ok {
+			return nil, fmt.Errorf("word %d (%s) not in word list", i+1, w)
+		}
+		writeBits(data, i*11, 11, idx)
+	}
+
+	entropy := data[:bits/8]
+	hash := sha256.Sum256(entropy)
+	mask := byte(0xff << uint(8-csBits))
+	if data[bits/8]&mask != hash[0]&mask {
+		return nil, fmt.Errorf("mnemonic checksum mismatch")
+	}
+	return entropy, nil
+}
+
+// NewSeed makes the 64 byte seed from a mnemonic sentence and passphrase.
+// It does not check the mnemonic; call EntropyFromMnemonic for that.
+func NewSeed(mnemonic, passphrase string) []byte {
+	sentence := norm.NFKD.String(strings.Join(strings.Fields(mnemonic), " "))
+	salt := norm.NFKD.String("mnemonic" + passphrase)
+	return pbkdf2.Key([]byte(sentence), []byte(salt), 2048, 64, sha512.New)
+}
+
+// readBits reads n bits starting at bit offset off, big endian
+func readBits(data []byte, off, n int) int {
+	v := 0
+	for i := off; i < off+n; i++ {
+		v <<= 1
+		if data[i/8]&(0x80>>uint(i%8)) != 0 {
+			v |= 1
+		}
+	}
+	return v
+}
+
+// writeBits writes the low n bits of v starting at bit offset off
+func writeBits(data []byte, off, n, v int) {
+	for i := 0; i < n; i++ {
+		if v&(1<<uint(n-1-i)) != 0 {
+			data[(off+i)/8] |= 0x80 >> uint((off+i)%8)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package bip39
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// vectors from the reference implementation, all with passphrase "TREZOR"
+var testVectors = []struct {
+	entropy  string
+	mnemonic string
+	seed     string
+}{
+	{
+		"00000000000000000000000000000000",
+		"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about",
+		"c55257c360c07c72029aebc1b53c05ed0362ada38ead3e3e9efa3708e53495531f09a6987599d18264c1e1c92f2cf141630c7a3c4ab7c81b2f001698e7463b04",
+	},
+	{
+		"7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f",
+		"legal winner thank year wave sausage worth useful legal winner thank yellow",
+		"2e8905819b8723fe2c1d161860e5ee1830318dbf49a83bd451cfb8440c28bd6fa457fe1296106559a3c80937a1c1069be3a3a5bd381ee6260e8d9739fce1f607",
+	},
+	{
+		"0000000000000000000000000000000000000000000000000000000000000000",
+		strings.Repeat("abandon ", 23) + "art",
+		"bda85446c68413707090a52022edd26a1c9462295029f2e60cd7c4f2bbd3097170af7a4d73245cafa9c3cca8d561a7c3de6f5d4a10be8ed2a5e608d68f92fcc8",
+	},
+	{
+		"ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff",
+		strings.Repeat("zoo ", 23) + "vote",
+		"dd48c104698c30cfe2b6142103248622fb7bb0ff692eebb00089b32d22484e1613912f0a5b694407be899ffd31ed3992c456cdf60f5d4564b8ba3f05a69890ad",
+	},
+}
+
+func TestVectors(t *testing.T) {
+	for i, v := range testVectors {
+		entropy, _ := hex.DecodeString(v.entropy)
+
+		mnemonic, err := NewMnemonic(entropy)
+		if err != nil {
+			t.Fatalf("vector %d: %s", i, err.Error())
+		}
+		if mnemonic != v.mnemonic {
+			t.Fatalf("vector %d: got mnemonic %s, expected %s", i, mnemonic, v.mnemonic)
+		}
+
+		decoded, err := EntropyFromMnemonic(mnemonic)
+		if err != nil {
+			t.Fatalf("vector %d: %s", i, err.Error())
+		}
+		if !bytes.Equal(decoded, entropy) {
+			t.Fatalf("vector %d: decoded entropy %x, expected %x", i, decoded, entropy)
+		}
+
+		seed := NewSeed(mnemonic, "TREZOR")
+		if hex.EncodeToString(seed) != v.seed {
+			t.Fatalf("vector %d: got seed %x, expected %s", i, seed, v.seed)
+		}
+	}
+}
+
+func TestBadMnemonic(t *testing.T) {
+	// last word changed, so the checksum is wrong
+	_, err := EntropyFromMnemonic(strings.Repeat("abandon ", 11) + "abandon")
+	if err == nil {
+		t.Fatalf("accepted mnemonic with bad checksum")
+	}
+	_, err = EntropyFromMnemonic(strings.Repeat("abandon ", 11) + "satoshis")
+	if err == nil {
+		t.Fatalf("accepted mnemonic with unknown word")
+	}
+	_, err = EntropyFromMnemonic(strings.Repeat("abandon ", 10) + "about")
+	if err == nil {
+		t.Fatalf("accepted 11 word mnemonic")
+	}
+}
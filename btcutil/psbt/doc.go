@@ -0,0 +1,19 @@
+/*
+Package psbt implements partially signed bitcoin transactions, as in BIP 174.
+
+A PSBT is an unsigned transaction along with what each signer needs to know
+to sign it: the outputs being spent, scripts, and which keys are involved.
+Signers add partial signatures; when there are enough, the inputs are
+finalized into scriptSigs and witnesses, and the signed transaction can be
+extracted.
+
+This lets transactions be put together by one program and signed by others,
+such as hardware wallets which never see the rest of the transaction's
+context.
+
+New and NewFromUnsignedTx make the creator's packet; the updater fills in
+PInput and POutput fields.  Signers add to PInput.PartialSigs.  Combine
+merges packets which have been signed separately, Finalize builds the final
+scripts, and Extract gives the transaction ready to broadcast.
+*/
+package psbt
@@ -0,0 +1,310 @@
+package psbt
+
+import (
+	"bytes"
+
+	"github.com/mit-dci/lit/btcutil/txscript"
+	"github.com/mit-dci/lit/wire"
+)
+
+// Combine merges packets for the same transaction, such as ones which went
+// to different signers.  Fields a already has are kept; b fills in the
+// rest, and partial sigs from both are kept.
+func Combine(a, b *Packet) (*Packet, error) {
+	if a.UnsignedTx.TxHash() != b.UnsignedTx.TxHash() {
+		return nil, ErrDifferentTx
+	}
+	err := a.SanityCheck()
+	if err != nil {
+		return nil, err
+	}
+	err = b.SanityCheck()
+	if err != nil {
+		return nil, err
+	}
+
+	a.Unknowns = mergeUnknowns(a.Unknowns, b.Unknowns)
+	for i := range a.Inputs {
+		ai, bi := &a.Inputs[i], &b.Inputs[i]
+		if ai.IsFinalized() {
+			continue
+		}
+		if bi.IsFinalized() {
+			a.Inputs[i] = *bi
+			continue
+		}
+		if ai.NonWitnessUtxo == nil {
+			ai.NonWitnessUtxo = bi.NonWitnessUtxo
+		}
+		if ai.WitnessUtxo == nil {
+			ai.WitnessUtxo = bi.WitnessUtxo
+		}
+		for _, ps := range bi.PartialSigs {
+			if ai.sigFor(ps.PubKey) == nil {
+				ai.PartialSigs = append(ai.PartialSigs, ps)
+			}
+		}
+		if ai.SighashType == 0 {
+			ai.SighashType = bi.SighashType
+		}
+		if ai.RedeemScript == nil {
+			ai.RedeemScript = bi.RedeemScript
+		}
+		if ai.WitnessScript == nil {
+			ai.WitnessScript = bi.WitnessScript
+		}
+		ai.Bip32Derivation = mergeDerivations(ai.Bip32Derivation, bi.Bip32Derivation)
+		ai.Unknowns = mergeUnknowns(ai.Unknowns, bi.Unknowns)
+	}
+	for i := range a.Outputs {
+		ao, bo := &a.Outputs[i], &b.Outputs[i]
+		if ao.RedeemScript == nil {
+			ao.RedeemScript = bo.RedeemScript
+		}
+		if ao.WitnessScript == nil {
+			ao.WitnessScript = bo.WitnessScript
+		}
+		ao.Bip32Derivation = mergeDerivations(ao.Bip32Derivation, bo.Bip32Derivation)
+		ao.Unknowns = mergeUnknowns(ao.Unknowns, bo.Unknowns)
+	}
+	return a, nil
+}
+
+// Finalize builds the final scriptSig and witness for input i from its
+// partial sigs.  Handles pubkey hash and multisig scripts, bare, in P2SH,
+// P2WSH, or P2WSH nested in P2SH.  Does nothing if it's already final.
+func Finalize(p *Packet, i int) error {
+	in := &p.Inputs[i]
+	if in.IsFinalized() {
+		return nil
+	}
+	prevOut := p.PrevOut(i)
+	if prevOut == nil {
+		return ErrNotFinalizable
+	}
+
+	script := prevOut.PkScript
+	var sigScript []byte
+	var witness wire.TxWitness
+	var err error
+
+	// P2SH: the redeem script takes the place of the pkscript, and goes
+	// last in the scriptSig
+	if txscript.IsPayToScriptHash(script) {
+		if in.RedeemScript == nil {
+			return ErrNotFinalizable
+		}
+		script = in.RedeemScript
+		sigScript, err = txscript.NewScriptBuilder().AddData(script).Script()
+		if err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case txscript.IsPayToWitnessPubKeyHash(script):
+		if len(in.PartialSigs) != 1 {
+			return ErrNotFinalizable
+		}
+		witness = wire.TxWitness{in.PartialSigs[0].Signature, in.PartialSigs[0].PubKey}
+
+	case txscript.IsPayToWitnessScriptHash(script):
+		if in.WitnessScript == nil {
+			return ErrNotFinalizable
+		}
+		stack, err := multiSigStack(in, in.WitnessScript)
+		if err != nil {
+			return err
+		}
+		witness = append(stack, in.WitnessScript)
+
+	case txscript.GetScriptClass(script) == txscript.PubKeyHashTy:
+		if len(in.PartialSigs) != 1 {
+			return ErrNotFinalizable
+		}
+		sigScript, err = txscript.NewScriptBuilder().
+			AddData(in.PartialSigs[0].Signature).
+			AddData(in.PartialSigs[0].PubKey).Script()
+		if err != nil {
+			return err
+		}
+
+	default:
+		stack, err := multiSigStack(in, script)
+		if err != nil {
+			return err
+		}
+		b := txscript.NewScriptBuilder()
+		for _, item := range stack {
+			b.AddData(item)
+		}
+		pre, err := b.Script()
+		if err != nil {
+			return err
+		}
+		sigScript = append(pre, sigScript...)
+	}
+
+	if witness != nil {
+		in.FinalScriptWitness, err = SerializeWitness(witness)
+		if err != nil {
+			return err
+		}
+	}
+	if sigScript != nil {
+		in.FinalScriptSig = sigScript
+	}
+	if !in.IsFinalized() {
+		return ErrNotFinalizable
+	}
+
+	// done with these
+	in.PartialSigs = nil
+	in.SighashType = 0
+	in.RedeemScript = nil
+	in.WitnessScript = nil
+	in.Bip32Derivation = nil
+	return nil
+}
+
+// MaybeFinalizeAll finalizes every input it can, and says whether they're
+// all final.
+func MaybeFinalizeAll(p *Packet) bool {
+	for i := range p.Inputs {
+		Finalize(p, i)
+	}
+	return p.IsComplete()
+}
+
+// Extract gives the signed transaction from a complete packet.
+func Extract(p *Packet) (*wire.MsgTx, error) {
+	if !p.IsComplete() {
+		return nil, ErrIncomplete
+	}
+	return ExtractPartial(p)
+}
+
+// ExtractPartial gives the transaction with the inputs which are final
+// signed, and the rest left empty, for when some signatures get added
+// outside of PSBTs.
+func ExtractPartial(p *Packet) (*wire.MsgTx, error) {
+	tx := p.UnsignedTx.Copy()
+	for i, in := range p.Inputs {
+		tx.TxIn[i].SignatureScript = in.FinalScriptSig
+		if in.FinalScriptWitness != nil {
+			wit, err := parseWitness(in.FinalScriptWitness)
+			if err != nil {
+				return nil, err
+			}
+			tx.TxIn[i].Witness = wit
+		}
+	}
+	return tx, nil
+}
+
+// NewFromPartialTx is NewFromUnsignedTx for a transaction with some inputs
+// signed already.  Those inputs are final in the packet.
+func NewFromPartialTx(tx *wire.MsgTx) (*Packet, error) {
+	unsigned := tx.Copy()
+	for _, txin := range unsigned.TxIn {
+		txin.SignatureScript = nil
+		txin.Witness = nil
+	}
+	p, err := NewFromUnsignedTx(unsigned)
+	if err != nil {
+		return nil, err
+	}
+	for i, txin := range tx.TxIn {
+		if len(txin.SignatureScript) != 0 {
+			p.Inputs[i].FinalScriptSig = txin.SignatureScript
+		}
+		if len(txin.Witness) != 0 {
+			p.Inputs[i].FinalScriptWitness, err = SerializeWitness(txin.Witness)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return p, nil
+}
+
+// multiSigStack gives the stack items to satisfy a multisig script: the
+// dummy empty item, then sigs in the order of the script's pubkeys.
+func multiSigStack(in *PInput, script []byte) (wire.TxWitness, error) {
+	if txscript.GetScriptClass(script) != txscript.MultiSigTy {
+		return nil, ErrNotFinalizable
+	}
+	_, nRequired, err := txscript.CalcMultiSigStats(script)
+	if err != nil {
+		return nil, err
+	}
+	pushes, err := txscript.PushedData(script)
+	if err != nil {
+		return nil, err
+	}
+	stack := wire.TxWitness{nil}
+	for _, pub := range pushes {
+		if len(stack)-1 == nRequired {
+			break
+		}
+		sig := in.sigFor(pub)
+		if sig != nil {
+			stack = append(stack, sig)
+		}
+	}
+	if len(stack)-1 != nRequired {
+		return nil, ErrNotFinalizable
+	}
+	return stack, nil
+}
+
+// SerializeWitness gives a witness stack as it goes in FinalScriptWitness.
+func SerializeWitness(wit wire.TxWitness) ([]byte, error) {
+	var buf bytes.Buffer
+	err := wire.WriteVarInt(&buf, 0, uint64(len(wit)))
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range wit {
+		err = wire.WriteVarBytes(&buf, 0, item)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func parseWitness(b []byte) (wire.TxWitness, error) {
+	r := bytes.NewReader(b)
+	n, err := wire.ReadVarInt(r, 0)
+	if err != nil {
+		return nil, err
+	}
+	if n > maxPsbtValue {
+		return nil, ErrInvalidPsbtFormat
+	}
+	wit := make(wire.TxWitness, n)
+	for i := range wit {
+		wit[i], err = wire.ReadVarBytes(r, 0, maxPsbtValue, "witness item")
+		if err != nil {
+			return nil, err
+		}
+	}
+	return wit, nil
+}
+
+func mergeDerivations(a, b []*Bip32Derivation) []*Bip32Derivation {
+	for _, db := range b {
+		found := false
+		for _, da := range a {
+			if bytes.Equal(da.PubKey, db.PubKey) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			a = append(a, db)
+		}
+	}
+	return a
+}
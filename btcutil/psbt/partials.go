@@ -0,0 +1,395 @@
+package psbt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/mit-dci/lit/btcutil/txscript"
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/lit/wire"
+)
+
+// key types in input maps
+const (
+	inNonWitnessUtxo     = 0x00
+	inWitnessUtxo        = 0x01
+	inPartialSig         = 0x02
+	inSighashType        = 0x03
+	inRedeemScript       = 0x04
+	inWitnessScript      = 0x05
+	inBip32Derivation    = 0x06
+	inFinalScriptSig     = 0x07
+	inFinalScriptWitness = 0x08
+)
+
+// key types in output maps
+const (
+	outRedeemScript    = 0x00
+	outWitnessScript   = 0x01
+	outBip32Derivation = 0x02
+)
+
+// PartialSig is one signer's signature for an input.  The sig is DER with
+// the sighash type byte on the end, as it goes in a script.
+type PartialSig struct {
+	PubKey    []byte
+	Signature []byte
+}
+
+// Bip32Derivation says which key a pubkey is, so a signer with the master
+// key can find it.
+type Bip32Derivation struct {
+	PubKey               []byte
+	MasterKeyFingerprint uint32
+	Bip32Path            []uint32
+}
+
+// PInput is what a signer needs to know about one input.
+type PInput struct {
+	NonWitnessUtxo     *wire.MsgTx
+	WitnessUtxo        *wire.TxOut
+	PartialSigs        []*PartialSig
+	SighashType        txscript.SigHashType
+	RedeemScript       []byte
+	WitnessScript      []byte
+	Bip32Derivation    []*Bip32Derivation
+	FinalScriptSig     []byte
+	FinalScriptWitness []byte
+	Unknowns           []Unknown
+}
+
+// POutput is what a signer can be told about an output, mostly so it can
+// tell which outputs are change.
+type POutput struct {
+	RedeemScript    []byte
+	WitnessScript   []byte
+	Bip32Derivation []*Bip32Derivation
+	Unknowns        []Unknown
+}
+
+// IsFinalized is true once the input has its final scriptSig or witness.
+func (pi *PInput) IsFinalized() bool {
+	return pi.FinalScriptSig != nil || pi.FinalScriptWitness != nil
+}
+
+// AddPartialSig adds a signature, checking the pubkey parses.  A second
+// signature from the same key replaces the first.
+func (pi *PInput) AddPartialSig(pub, sig []byte) error {
+	_, err := koblitz.ParsePubKey(pub, koblitz.S256())
+	if err != nil {
+		return err
+	}
+	if len(sig) < 2 {
+		return fmt.Errorf("psbt signature too short")
+	}
+	for _, ps := range pi.PartialSigs {
+		if bytes.Equal(ps.PubKey, pub) {
+			ps.Signature = sig
+			return nil
+		}
+	}
+	pi.PartialSigs = append(pi.PartialSigs, &PartialSig{PubKey: pub, Signature: sig})
+	return nil
+}
+
+// sigFor gives the partial sig from pub, or nil.
+func (pi *PInput) sigFor(pub []byte) []byte {
+	for _, ps := range pi.PartialSigs {
+		if bytes.Equal(ps.PubKey, pub) {
+			return ps.Signature
+		}
+	}
+	return nil
+}
+
+func (pi *PInput) deserialize(r io.Reader) error {
+	for {
+		key, value, err := readKV(r)
+		if err != nil {
+			return err
+		}
+		if key == nil {
+			return nil
+		}
+		// key types with no key data
+		single := func(set bool) error {
+			if len(key) != 1 {
+				return ErrInvalidPsbtFormat
+			}
+			if set {
+				return ErrDuplicateKey
+			}
+			return nil
+		}
+
+		switch key[0] {
+		case inNonWitnessUtxo:
+			err = single(pi.NonWitnessUtxo != nil)
+			if err != nil {
+				return err
+			}
+			tx := wire.NewMsgTx()
+			err = tx.Deserialize(bytes.NewReader(value))
+			if err != nil {
+				return err
+			}
+			pi.NonWitnessUtxo = tx
+		case inWitnessUtxo:
+			err = single(pi.WitnessUtxo != nil)
+			if err != nil {
+				return err
+			}
+			pi.WitnessUtxo, err = readTxOut(value)
+			if err != nil {
+				return err
+			}
+		case inPartialSig:
+			if pi.sigFor(key[1:]) != nil {
+				return ErrDuplicateKey
+			}
+			err = pi.AddPartialSig(key[1:], value)
+			if err != nil {
+				return err
+			}
+		case inSighashType:
+			err = single(pi.SighashType != 0)
+			if err != nil {
+				return err
+			}
+			if len(value) != 4 {
+				return ErrInvalidPsbtFormat
+			}
+			pi.SighashType = txscript.SigHashType(binary.LittleEndian.Uint32(value))
+		case inRedeemScript:
+			err = single(pi.RedeemScript != nil)
+			if err != nil {
+				return err
+			}
+			pi.RedeemScript = value
+		case inWitnessScript:
+			err = single(pi.WitnessScript != nil)
+			if err != nil {
+				return err
+			}
+			pi.WitnessScript = value
+		case inBip32Derivation:
+			pi.Bip32Derivation, err = addDerivation(pi.Bip32Derivation, key[1:], value)
+			if err != nil {
+				return err
+			}
+		case inFinalScriptSig:
+			err = single(pi.FinalScriptSig != nil)
+			if err != nil {
+				return err
+			}
+			pi.FinalScriptSig = value
+		case inFinalScriptWitness:
+			err = single(pi.FinalScriptWitness != nil)
+			if err != nil {
+				return err
+			}
+			pi.FinalScriptWitness = value
+		default:
+			pi.Unknowns, err = addUnknown(pi.Unknowns, key, value)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (pi *PInput) serialize(w io.Writer) error {
+	var err error
+	if pi.NonWitnessUtxo != nil {
+		var buf bytes.Buffer
+		err = pi.NonWitnessUtxo.Serialize(&buf)
+		if err != nil {
+			return err
+		}
+		err = writeKV(w, []byte{inNonWitnessUtxo}, buf.Bytes())
+		if err != nil {
+			return err
+		}
+	}
+	if pi.WitnessUtxo != nil {
+		var buf bytes.Buffer
+		err = wire.WriteTxOut(&buf, 0, 0, pi.WitnessUtxo)
+		if err != nil {
+			return err
+		}
+		err = writeKV(w, []byte{inWitnessUtxo}, buf.Bytes())
+		if err != nil {
+			return err
+		}
+	}
+	// partial sigs and such only matter until the input is final
+	if !pi.IsFinalized() {
+		for _, ps := range pi.PartialSigs {
+			err = writeKV(w, append([]byte{inPartialSig}, ps.PubKey...), ps.Signature)
+			if err != nil {
+				return err
+			}
+		}
+		if pi.SighashType != 0 {
+			var v [4]byte
+			binary.LittleEndian.PutUint32(v[:], uint32(pi.SighashType))
+			err = writeKV(w, []byte{inSighashType}, v[:])
+			if err != nil {
+				return err
+			}
+		}
+		if pi.RedeemScript != nil {
+			err = writeKV(w, []byte{inRedeemScript}, pi.RedeemScript)
+			if err != nil {
+				return err
+			}
+		}
+		if pi.WitnessScript != nil {
+			err = writeKV(w, []byte{inWitnessScript}, pi.WitnessScript)
+			if err != nil {
+				return err
+			}
+		}
+		err = writeDerivations(w, inBip32Derivation, pi.Bip32Derivation)
+		if err != nil {
+			return err
+		}
+	}
+	if pi.FinalScriptSig != nil {
+		err = writeKV(w, []byte{inFinalScriptSig}, pi.FinalScriptSig)
+		if err != nil {
+			return err
+		}
+	}
+	if pi.FinalScriptWitness != nil {
+		err = writeKV(w, []byte{inFinalScriptWitness}, pi.FinalScriptWitness)
+		if err != nil {
+			return err
+		}
+	}
+	err = writeUnknowns(w, pi.Unknowns)
+	if err != nil {
+		return err
+	}
+	return writeSeparator(w)
+}
+
+func (po *POutput) deserialize(r io.Reader) error {
+	for {
+		key, value, err := readKV(r)
+		if err != nil {
+			return err
+		}
+		if key == nil {
+			return nil
+		}
+		switch key[0] {
+		case outRedeemScript:
+			if len(key) != 1 {
+				return ErrInvalidPsbtFormat
+			}
+			if po.RedeemScript != nil {
+				return ErrDuplicateKey
+			}
+			po.RedeemScript = value
+		case outWitnessScript:
+			if len(key) != 1 {
+				return ErrInvalidPsbtFormat
+			}
+			if po.WitnessScript != nil {
+				return ErrDuplicateKey
+			}
+			po.WitnessScript = value
+		case outBip32Derivation:
+			po.Bip32Derivation, err = addDerivation(po.Bip32Derivation, key[1:], value)
+			if err != nil {
+				return err
+			}
+		default:
+			po.Unknowns, err = addUnknown(po.Unknowns, key, value)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (po *POutput) serialize(w io.Writer) error {
+	var err error
+	if po.RedeemScript != nil {
+		err = writeKV(w, []byte{outRedeemScript}, po.RedeemScript)
+		if err != nil {
+			return err
+		}
+	}
+	if po.WitnessScript != nil {
+		err = writeKV(w, []byte{outWitnessScript}, po.WitnessScript)
+		if err != nil {
+			return err
+		}
+	}
+	err = writeDerivations(w, outBip32Derivation, po.Bip32Derivation)
+	if err != nil {
+		return err
+	}
+	err = writeUnknowns(w, po.Unknowns)
+	if err != nil {
+		return err
+	}
+	return writeSeparator(w)
+}
+
+// readTxOut parses a txout on its own: 8 byte value, then the script.
+func readTxOut(b []byte) (*wire.TxOut, error) {
+	if len(b) < 9 {
+		return nil, ErrInvalidPsbtFormat
+	}
+	value := int64(binary.LittleEndian.Uint64(b[:8]))
+	script, err := wire.ReadVarBytes(bytes.NewReader(b[8:]), 0, maxPsbtValue, "pkscript")
+	if err != nil {
+		return nil, err
+	}
+	return wire.NewTxOut(value, script), nil
+}
+
+// addDerivation parses a bip32 derivation and adds it to a list.  The value
+// is the 4 byte fingerprint, then 4 bytes for each step in the path.
+func addDerivation(ds []*Bip32Derivation, pub, value []byte) ([]*Bip32Derivation, error) {
+	_, err := koblitz.ParsePubKey(pub, koblitz.S256())
+	if err != nil {
+		return nil, err
+	}
+	if len(value) < 4 || len(value)%4 != 0 {
+		return nil, ErrInvalidPsbtFormat
+	}
+	for _, d := range ds {
+		if bytes.Equal(d.PubKey, pub) {
+			return nil, ErrDuplicateKey
+		}
+	}
+	d := &Bip32Derivation{
+		PubKey:               pub,
+		MasterKeyFingerprint: binary.LittleEndian.Uint32(value[:4]),
+	}
+	for i := 4; i < len(value); i += 4 {
+		d.Bip32Path = append(d.Bip32Path, binary.LittleEndian.Uint32(value[i:i+4]))
+	}
+	return append(ds, d), nil
+}
+
+func writeDerivations(w io.Writer, keyType byte, ds []*Bip32Derivation) error {
+	for _, d := range ds {
+		value := make([]byte, 4+4*len(d.Bip32Path))
+		binary.LittleEndian.PutUint32(value[:4], d.MasterKeyFingerprint)
+		for i, step := range d.Bip32Path {
+			binary.LittleEndian.PutUint32(value[4+4*i:], step)
+		}
+		err := writeKV(w, append([]byte{keyType}, d.PubKey...), value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,374 @@
+package psbt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/mit-dci/lit/wire"
+)
+
+// magic is the bytes every serialized PSBT starts with: "psbt" and 0xff.
+var magic = []byte{0x70, 0x73, 0x62, 0x74, 0xff}
+
+// maxPsbtValue is the most a single value in a PSBT can be.  Big enough
+// for any previous transaction we'd want to include.
+const maxPsbtValue = 4000000
+
+// key types in the global map
+const (
+	globalUnsignedTx = 0x00
+	globalVersion    = 0xfb
+)
+
+var (
+	// ErrInvalidMagic means the bytes don't start like a PSBT.
+	ErrInvalidMagic = errors.New("invalid psbt magic bytes")
+	// ErrDuplicateKey means a key shows up twice in the same map.
+	ErrDuplicateKey = errors.New("duplicate key in psbt")
+	// ErrInvalidPsbtFormat means something didn't parse.
+	ErrInvalidPsbtFormat = errors.New("invalid psbt format")
+	// ErrNotFinalizable means an input doesn't have what's needed to finalize.
+	ErrNotFinalizable = errors.New("psbt input can't be finalized")
+	// ErrIncomplete means not all inputs are finalized.
+	ErrIncomplete = errors.New("psbt is not fully signed")
+	// ErrDifferentTx means two packets aren't for the same transaction.
+	ErrDifferentTx = errors.New("psbts are for different transactions")
+)
+
+// Unknown is a key-value pair we don't understand.  They're kept so they
+// make it back out when the packet is serialized.
+type Unknown struct {
+	Key   []byte
+	Value []byte
+}
+
+// Packet is a partially signed transaction.  Inputs and Outputs line up
+// with the inputs and outputs of UnsignedTx.
+type Packet struct {
+	UnsignedTx *wire.MsgTx
+	Inputs     []PInput
+	Outputs    []POutput
+	Unknowns   []Unknown
+}
+
+// NewFromUnsignedTx makes a packet for a transaction, which can't have any
+// scriptSigs or witnesses yet.
+func NewFromUnsignedTx(tx *wire.MsgTx) (*Packet, error) {
+	for _, in := range tx.TxIn {
+		if len(in.SignatureScript) != 0 || len(in.Witness) != 0 {
+			return nil, fmt.Errorf("psbt unsigned tx has signed input %s",
+				in.PreviousOutPoint.String())
+		}
+	}
+	return &Packet{
+		UnsignedTx: tx,
+		Inputs:     make([]PInput, len(tx.TxIn)),
+		Outputs:    make([]POutput, len(tx.TxOut)),
+	}, nil
+}
+
+// New makes an unsigned packet spending ins, paying outs.
+func New(ins []*wire.OutPoint, outs []*wire.TxOut, version int32,
+	nLockTime uint32, sequences []uint32) (*Packet, error) {
+
+	tx := wire.NewMsgTx()
+	tx.Version = version
+	tx.LockTime = nLockTime
+	for i, op := range ins {
+		in := wire.NewTxIn(op, nil, nil)
+		if i < len(sequences) {
+			in.Sequence = sequences[i]
+		}
+		tx.AddTxIn(in)
+	}
+	for _, out := range outs {
+		tx.AddTxOut(out)
+	}
+	return NewFromUnsignedTx(tx)
+}
+
+// NewFromRawBytes parses a serialized packet.  If b64 is true, it's base64
+// encoded, which is how PSBTs are usually passed around as text.
+func NewFromRawBytes(r io.Reader, b64 bool) (*Packet, error) {
+	if b64 {
+		r = base64.NewDecoder(base64.StdEncoding, r)
+	}
+
+	var m [5]byte
+	_, err := io.ReadFull(r, m[:])
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(m[:], magic) {
+		return nil, ErrInvalidMagic
+	}
+
+	p := new(Packet)
+	// global map
+	for {
+		key, value, err := readKV(r)
+		if err != nil {
+			return nil, err
+		}
+		if key == nil {
+			break
+		}
+		switch key[0] {
+		case globalUnsignedTx:
+			if len(key) != 1 {
+				return nil, ErrInvalidPsbtFormat
+			}
+			if p.UnsignedTx != nil {
+				return nil, ErrDuplicateKey
+			}
+			tx := wire.NewMsgTx()
+			err = tx.DeserializeNoWitness(bytes.NewReader(value))
+			if err != nil {
+				return nil, err
+			}
+			p.UnsignedTx = tx
+		case globalVersion:
+			if len(value) != 4 || value[0] != 0 || value[1] != 0 ||
+				value[2] != 0 || value[3] != 0 {
+				return nil, fmt.Errorf("unsupported psbt version %x", value)
+			}
+		default:
+			// xpubs and everything else are kept as they are
+			p.Unknowns, err = addUnknown(p.Unknowns, key, value)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if p.UnsignedTx == nil {
+		return nil, fmt.Errorf("psbt has no unsigned tx")
+	}
+	for _, in := range p.UnsignedTx.TxIn {
+		if len(in.SignatureScript) != 0 || len(in.Witness) != 0 {
+			return nil, ErrInvalidPsbtFormat
+		}
+	}
+
+	p.Inputs = make([]PInput, len(p.UnsignedTx.TxIn))
+	for i := range p.Inputs {
+		err = p.Inputs[i].deserialize(r)
+		if err != nil {
+			return nil, fmt.Errorf("psbt input %d: %s", i, err.Error())
+		}
+	}
+	p.Outputs = make([]POutput, len(p.UnsignedTx.TxOut))
+	for i := range p.Outputs {
+		err = p.Outputs[i].deserialize(r)
+		if err != nil {
+			return nil, fmt.Errorf("psbt output %d: %s", i, err.Error())
+		}
+	}
+
+	return p, p.SanityCheck()
+}
+
+// Serialize writes the packet out in binary.
+func (p *Packet) Serialize(w io.Writer) error {
+	err := p.SanityCheck()
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(magic)
+	if err != nil {
+		return err
+	}
+
+	var txBuf bytes.Buffer
+	err = p.UnsignedTx.SerializeNoWitness(&txBuf)
+	if err != nil {
+		return err
+	}
+	err = writeKV(w, []byte{globalUnsignedTx}, txBuf.Bytes())
+	if err != nil {
+		return err
+	}
+	err = writeUnknowns(w, p.Unknowns)
+	if err != nil {
+		return err
+	}
+	err = writeSeparator(w)
+	if err != nil {
+		return err
+	}
+
+	for i := range p.Inputs {
+		err = p.Inputs[i].serialize(w)
+		if err != nil {
+			return err
+		}
+	}
+	for i := range p.Outputs {
+		err = p.Outputs[i].serialize(w)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Bytes is the serialized packet.
+func (p *Packet) Bytes() ([]byte, error) {
+	var buf bytes.Buffer
+	err := p.Serialize(&buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// B64Encode is the serialized packet in base64.
+func (p *Packet) B64Encode() (string, error) {
+	b, err := p.Bytes()
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+// SanityCheck makes sure the inputs and outputs line up with the tx, and
+// that the utxo info given for each input is for the outpoint it spends.
+func (p *Packet) SanityCheck() error {
+	if p.UnsignedTx == nil {
+		return fmt.Errorf("psbt has no unsigned tx")
+	}
+	if len(p.Inputs) != len(p.UnsignedTx.TxIn) ||
+		len(p.Outputs) != len(p.UnsignedTx.TxOut) {
+		return fmt.Errorf("psbt has %d/%d inputs/outputs but tx has %d/%d",
+			len(p.Inputs), len(p.Outputs),
+			len(p.UnsignedTx.TxIn), len(p.UnsignedTx.TxOut))
+	}
+	for i, in := range p.Inputs {
+		if in.NonWitnessUtxo == nil {
+			continue
+		}
+		op := p.UnsignedTx.TxIn[i].PreviousOutPoint
+		if in.NonWitnessUtxo.TxHash() != op.Hash ||
+			int(op.Index) >= len(in.NonWitnessUtxo.TxOut) {
+			return fmt.Errorf("psbt input %d utxo tx doesn't match %s",
+				i, op.String())
+		}
+	}
+	return nil
+}
+
+// IsComplete is true if every input is finalized.
+func (p *Packet) IsComplete() bool {
+	for i := range p.Inputs {
+		if !p.Inputs[i].IsFinalized() {
+			return false
+		}
+	}
+	return true
+}
+
+// PrevOut gives the output spent by input i, from whichever utxo field has
+// it.  Nil if the packet doesn't say.
+func (p *Packet) PrevOut(i int) *wire.TxOut {
+	in := p.Inputs[i]
+	if in.WitnessUtxo != nil {
+		return in.WitnessUtxo
+	}
+	if in.NonWitnessUtxo != nil {
+		return in.NonWitnessUtxo.TxOut[p.UnsignedTx.TxIn[i].PreviousOutPoint.Index]
+	}
+	return nil
+}
+
+// SumInputs adds up the values of the outputs being spent.  Errors if any
+// input is missing its utxo.
+func (p *Packet) SumInputs() (int64, error) {
+	var sum int64
+	for i := range p.Inputs {
+		out := p.PrevOut(i)
+		if out == nil {
+			return 0, fmt.Errorf("psbt input %d (%s) has no utxo info", i,
+				p.UnsignedTx.TxIn[i].PreviousOutPoint.String())
+		}
+		sum += out.Value
+	}
+	return sum, nil
+}
+
+// readKV reads one key-value pair.  A nil key is the separator at the end
+// of a map.
+func readKV(r io.Reader) ([]byte, []byte, error) {
+	keyLen, err := wire.ReadVarInt(r, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	if keyLen == 0 {
+		return nil, nil, nil
+	}
+	if keyLen > maxPsbtValue {
+		return nil, nil, ErrInvalidPsbtFormat
+	}
+	key := make([]byte, keyLen)
+	_, err = io.ReadFull(r, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	value, err := wire.ReadVarBytes(r, 0, maxPsbtValue, "psbt value")
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, value, nil
+}
+
+func writeKV(w io.Writer, key, value []byte) error {
+	err := wire.WriteVarBytes(w, 0, key)
+	if err != nil {
+		return err
+	}
+	return wire.WriteVarBytes(w, 0, value)
+}
+
+func writeSeparator(w io.Writer) error {
+	_, err := w.Write([]byte{0x00})
+	return err
+}
+
+func writeUnknowns(w io.Writer, unknowns []Unknown) error {
+	for _, u := range unknowns {
+		err := writeKV(w, u.Key, u.Value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addUnknown adds a pair to a list, unless the key is already there.
+func addUnknown(unknowns []Unknown, key, value []byte) ([]Unknown, error) {
+	for _, u := range unknowns {
+		if bytes.Equal(u.Key, key) {
+			return nil, ErrDuplicateKey
+		}
+	}
+	return append(unknowns, Unknown{Key: key, Value: value}), nil
+}
+
+// mergeUnknowns adds pairs from b to a which a doesn't have.
+func mergeUnknowns(a, b []Unknown) []Unknown {
+	for _, ub := range b {
+		found := false
+		for _, ua := range a {
+			if bytes.Equal(ua.Key, ub.Key) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			a = append(a, ub)
+		}
+	}
+	return a
+}
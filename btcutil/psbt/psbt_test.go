@@ -0,0 +1,208 @@
+package psbt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"strings"
+	"testing"
+
+	"github.com/mit-dci/lit/btcutil"
+	"github.com/mit-dci/lit/btcutil/chaincfg/chainhash"
+	"github.com/mit-dci/lit/btcutil/txscript"
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/lit/wire"
+)
+
+func testKey(b byte) *koblitz.PrivateKey {
+	priv, _ := koblitz.PrivKeyFromBytes(koblitz.S256(), bytes.Repeat([]byte{b}, 32))
+	return priv
+}
+
+func wpkhScript(pub []byte) []byte {
+	s, _ := txscript.NewScriptBuilder().
+		AddOp(txscript.OP_0).AddData(btcutil.Hash160(pub)).Script()
+	return s
+}
+
+func wshScript(script []byte) []byte {
+	h := sha256.Sum256(script)
+	s, _ := txscript.NewScriptBuilder().AddOp(txscript.OP_0).AddData(h[:]).Script()
+	return s
+}
+
+// testPacket spends one output of value 100000 with pkScript, to a
+// P2WPKH output.
+func testPacket(t *testing.T, pkScript []byte) *Packet {
+	prev := chainhash.DoubleHashH([]byte("prev"))
+	p, err := New([]*wire.OutPoint{wire.NewOutPoint(&prev, 1)},
+		[]*wire.TxOut{wire.NewTxOut(90000, wpkhScript(testKey(9).PubKey().SerializeCompressed()))},
+		2, 0, []uint32{wire.MaxTxInSequenceNum})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Inputs[0].WitnessUtxo = wire.NewTxOut(100000, pkScript)
+	return p
+}
+
+// verify runs the script engine on input 0 of tx.
+func verify(t *testing.T, tx *wire.MsgTx, prevOut *wire.TxOut) {
+	vm, err := txscript.NewEngine(prevOut.PkScript, tx, 0,
+		txscript.StandardVerifyFlags, nil, txscript.NewTxSigHashes(tx), prevOut.Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = vm.Execute()
+	if err != nil {
+		t.Fatalf("signed tx doesn't verify: %s", err.Error())
+	}
+}
+
+func TestPsbtRoundTrip(t *testing.T) {
+	p := testPacket(t, wpkhScript(testKey(1).PubKey().SerializeCompressed()))
+	p.Inputs[0].Bip32Derivation = []*Bip32Derivation{{
+		PubKey:               testKey(1).PubKey().SerializeCompressed(),
+		MasterKeyFingerprint: 0xdeadbeef,
+		Bip32Path:            []uint32{84 | 1<<31, 1 << 31, 1 << 31, 0, 5},
+	}}
+	p.Outputs[0].Unknowns = []Unknown{{Key: []byte{0xfc, 1}, Value: []byte("x")}}
+
+	s, err := p.B64Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(s, "cHNidP8") {
+		t.Fatalf("base64 psbt should start with cHNidP8, got %s", s[:8])
+	}
+	p2, err := NewFromRawBytes(strings.NewReader(s), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s2, err := p2.B64Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s != s2 {
+		t.Fatalf("round trip changed psbt:\n%s\n%s", s, s2)
+	}
+	d := p2.Inputs[0].Bip32Derivation[0]
+	if d.MasterKeyFingerprint != 0xdeadbeef || len(d.Bip32Path) != 5 || d.Bip32Path[4] != 5 {
+		t.Fatalf("bad derivation after round trip: %+v", d)
+	}
+
+	_, err = NewFromRawBytes(bytes.NewReader([]byte("not a psbt")), false)
+	if err != ErrInvalidMagic {
+		t.Fatalf("expected ErrInvalidMagic, got %v", err)
+	}
+}
+
+func TestPsbtFinalizeWPKH(t *testing.T) {
+	priv := testKey(1)
+	pub := priv.PubKey().SerializeCompressed()
+	p := testPacket(t, wpkhScript(pub))
+
+	_, err := Extract(p)
+	if err != ErrIncomplete {
+		t.Fatalf("extracted unsigned psbt")
+	}
+
+	tx := p.UnsignedTx
+	sig, err := txscript.RawTxInWitnessSignature(tx, txscript.NewTxSigHashes(tx), 0,
+		100000, p.Inputs[0].WitnessUtxo.PkScript, txscript.SigHashAll, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Inputs[0].AddPartialSig(pub, sig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !MaybeFinalizeAll(p) {
+		t.Fatalf("couldn't finalize")
+	}
+	signed, err := Extract(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signed.TxHash() != p.UnsignedTx.TxHash() {
+		t.Fatalf("signing changed txid")
+	}
+	verify(t, signed, p.Inputs[0].WitnessUtxo)
+}
+
+func TestPsbtCombineMultisig(t *testing.T) {
+	privA, privB := testKey(1), testKey(2)
+	pubA := privA.PubKey().SerializeCompressed()
+	pubB := privB.PubKey().SerializeCompressed()
+	ms, err := txscript.NewScriptBuilder().AddOp(txscript.OP_2).
+		AddData(pubA).AddData(pubB).
+		AddOp(txscript.OP_2).AddOp(txscript.OP_CHECKMULTISIG).Script()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// each signer gets a copy and signs with one key
+	signed := make([]*Packet, 2)
+	for i, priv := range []*koblitz.PrivateKey{privB, privA} {
+		p := testPacket(t, wshScript(ms))
+		p.Inputs[0].WitnessScript = ms
+		tx := p.UnsignedTx
+		sig, err := txscript.RawTxInWitnessSignature(tx, txscript.NewTxSigHashes(tx),
+			0, 100000, ms, txscript.SigHashAll, priv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = p.Inputs[0].AddPartialSig(priv.PubKey().SerializeCompressed(), sig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if Finalize(p, 0) != ErrNotFinalizable {
+			t.Fatalf("finalized with one of two sigs")
+		}
+		signed[i] = p
+	}
+
+	other := testPacket(t, wshScript(ms))
+	other.UnsignedTx.LockTime = 5
+	_, err = Combine(signed[0], other)
+	if err != ErrDifferentTx {
+		t.Fatalf("combined psbts for different txs")
+	}
+
+	p, err := Combine(signed[0], signed[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Finalize(p, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := Extract(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify(t, tx, p.Inputs[0].WitnessUtxo)
+}
+
+func TestPsbtPartialTx(t *testing.T) {
+	p := testPacket(t, wpkhScript(testKey(1).PubKey().SerializeCompressed()))
+	tx := p.UnsignedTx.Copy()
+	prev := chainhash.DoubleHashH([]byte("other"))
+	tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&prev, 0), nil, wire.TxWitness{{1}, {2}}))
+
+	p2, err := NewFromPartialTx(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p2.Inputs[0].IsFinalized() || !p2.Inputs[1].IsFinalized() {
+		t.Fatalf("wrong inputs final")
+	}
+	if p2.UnsignedTx.TxIn[1].Witness != nil || tx.TxIn[1].Witness == nil {
+		t.Fatalf("witness not moved into the packet")
+	}
+	back, err := ExtractPartial(p2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if back.WitnessHash() != tx.WitnessHash() {
+		t.Fatalf("partial tx changed")
+	}
+}
@@ -3,7 +3,9 @@ package main
 import (
 	"encoding/hex"
 	"fmt"
+	"io/ioutil"
 	"strconv"
+	"strings"
 
 	"github.com/fatih/color"
 	"github.com/mit-dci/lit/litrpc"
@@ -90,6 +92,15 @@ var breakCommand = &Command{
 	ShortDescription: "Forcibly break the given channel.\n",
 }
 
+var importBackupCommand = &Command{
+	Format: fmt.Sprintf("%s%s\n", lnutil.White("importbackup"), lnutil.ReqColor("file")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n",
+		"Restore channels from a static channel backup (channels.backup).",
+		"Lit reconnects to each peer and asks it to break the channel,",
+		"then sweeps our outputs once the break tx confirms."),
+	ShortDescription: "Restore channels from a static channel backup.\n",
+}
+
 var historyCommand = &Command{
 	Format:           fmt.Sprintf("%s\n", lnutil.White("history")),
 	Description:      "Show all the metadata for justice txs",
@@ -120,15 +131,41 @@ var claimHTLCCommand = &Command{
 }
 
 var paymultihopCommand = &Command{
-	Format: fmt.Sprintf("%s%s\n", lnutil.White("paymultihop"), lnutil.ReqColor("dest cointype amount")),
-	Description: fmt.Sprintf("%s\n%s%s\n%s%s\n%s%s\n",
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("paymultihop"),
+		lnutil.ReqColor("dest", "destcointype", "origincointype", "amount"), lnutil.OptColor("keysend")),
+	Description: fmt.Sprintf("%s\n%s%s\n%s%s\n%s%s\n%s%s\n%s%s\n",
 		"Tries to pay using a multi-hop payment. Will fail if no route available",
 		lnutil.White("dest"), ": Destination address",
-		lnutil.White("cointype"), ": Coin type to pay",
-		lnutil.White("amount"), ": Amount to pay"),
+		lnutil.White("destcointype"), ": Coin type the destination gets",
+		lnutil.White("origincointype"), ": Coin type to pay",
+		lnutil.White("amount"), ": Amount to pay",
+		lnutil.White("keysend"), ": Pick the preimage ourselves instead of asking the destination for a hash"),
 	ShortDescription: "Pay via multi-hop.\n",
 }
 
+var keysendCommand = &Command{
+	Format: fmt.Sprintf("%s%s\n", lnutil.White("keysend"), lnutil.OptColor("on|off")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"Show or set whether we take keysend payments: ones we didn't give a hash for,",
+		"where the payer picks the preimage and sends it to us in the onion."),
+	ShortDescription: "Show or set whether we take keysend payments.\n",
+}
+
+var ratesCommand = &Command{
+	Format: fmt.Sprintf("%s%s\n", lnutil.White("rates"),
+		lnutil.OptColor("subcommand", "parameters...")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n%s\n%s\n",
+		"Show the exchange rates we forward payments between coins at, or set them.",
+		"Rates say how much of the coin sent on we give for each of the coin taken; 1/n is n taken for each.",
+		"Subcommand can be one of:",
+		fmt.Sprintf("%-20s %s",
+			lnutil.White("set"), "Set a rate over the providers': set cointype fromcointype rate (0 to go back to theirs)"),
+		fmt.Sprintf("%-20s %s",
+			lnutil.White("spread"), "Take millionths off the providers' rate: spread cointype fromcointype millionths"),
+	),
+	ShortDescription: "Show or set exchange rates.\n",
+}
+
 func (lc *litAfClient) History(textArgs []string) error {
 	if len(textArgs) > 0 && textArgs[0] == "-h" {
 		fmt.Fprintf(color.Output, historyCommand.Format)
@@ -352,6 +389,30 @@ func (lc *litAfClient) BreakChannel(textArgs []string) error {
 	return nil
 }
 
+// ImportBackup sends a local backup file to lit to restore channels from
+func (lc *litAfClient) ImportBackup(textArgs []string) error {
+	stopEx, err := CheckHelpCommand(importBackupCommand, textArgs, 1)
+	if err != nil || stopEx {
+		return err
+	}
+
+	args := new(litrpc.ImportChannelBackupArgs)
+	reply := new(litrpc.StatusReply)
+
+	args.Data, err = ioutil.ReadFile(textArgs[0])
+	if err != nil {
+		return err
+	}
+
+	err = lc.Call("LitRPC.ImportChannelBackup", args, reply)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(color.Output, "%s\n", reply.Status)
+	return nil
+}
+
 // Push is the shell command which calls PushChannel
 func (lc *litAfClient) Push(textArgs []string) error {
 	stopEx, err := CheckHelpCommand(pushCommand, textArgs, 2)
@@ -609,8 +670,8 @@ func (lc *litAfClient) PayMultihop(textArgs []string) error {
 	args := new(litrpc.PayMultihopArgs)
 	reply := new(litrpc.StatusReply)
 
-	if len(textArgs) < 3 {
-		return fmt.Errorf("need args: paymultihop dest destCoinType originCoinType amount")
+	if len(textArgs) < 4 {
+		return fmt.Errorf("need args: paymultihop dest destCoinType originCoinType amount [keysend]")
 	}
 
 	args.DestLNAdr = textArgs[0]
@@ -632,6 +693,13 @@ func (lc *litAfClient) PayMultihop(textArgs []string) error {
 	}
 	args.Amt = int64(amount)
 
+	if len(textArgs) > 4 {
+		if textArgs[4] != "keysend" {
+			return fmt.Errorf(paymultihopCommand.Format)
+		}
+		args.Keysend = true
+	}
+
 	err = lc.Call("LitRPC.PayMultihop", args, reply)
 	if err != nil {
 		return err
@@ -641,3 +709,255 @@ func (lc *litAfClient) PayMultihop(textArgs []string) error {
 
 	return nil
 }
+
+// Keysend shows or sets whether we take keysend payments
+func (lc *litAfClient) Keysend(textArgs []string) error {
+	if len(textArgs) > 0 && textArgs[0] == "-h" {
+		fmt.Fprintf(color.Output, keysendCommand.Format)
+		fmt.Fprintf(color.Output, keysendCommand.Description)
+		return nil
+	}
+
+	if len(textArgs) == 0 {
+		reply := new(litrpc.KeysendReply)
+		err := lc.Call("LitRPC.GetKeysend", new(litrpc.NoArgs), reply)
+		if err != nil {
+			return err
+		}
+		if reply.Accept {
+			fmt.Fprintf(color.Output, "taking keysend payments\n")
+		} else {
+			fmt.Fprintf(color.Output, "turning down keysend payments\n")
+		}
+		return nil
+	}
+
+	args := new(litrpc.KeysendArgs)
+	reply := new(litrpc.StatusReply)
+	switch textArgs[0] {
+	case "on":
+		args.Accept = true
+	case "off":
+		args.Accept = false
+	default:
+		return fmt.Errorf(keysendCommand.Format)
+	}
+
+	err := lc.Call("LitRPC.SetKeysend", args, reply)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(color.Output, "%s\n", reply.Status)
+	return nil
+}
+
+// Rates shows or sets the exchange rates we forward payments between coins at
+func (lc *litAfClient) Rates(textArgs []string) error {
+	if len(textArgs) > 0 && textArgs[0] == "-h" {
+		fmt.Fprintf(color.Output, ratesCommand.Format)
+		fmt.Fprintf(color.Output, ratesCommand.Description)
+		return nil
+	}
+
+	if len(textArgs) == 0 {
+		reply := new(litrpc.ExchangeRatesReply)
+		err := lc.Call("LitRPC.GetExchangeRates", new(litrpc.NoArgs), reply)
+		if err != nil {
+			return err
+		}
+		for _, r := range reply.Rates {
+			rate := fmt.Sprintf("%d", r.Rate)
+			if r.Reciprocal {
+				rate = fmt.Sprintf("1/%d", r.Rate)
+			}
+			fmt.Fprintf(color.Output, "%d -> %d\trate %s\t%s", r.FromCoinType,
+				r.CoinType, lnutil.White(rate), r.Source)
+			if r.Spread != 0 {
+				fmt.Fprintf(color.Output, "\tspread %d", r.Spread)
+			}
+			fmt.Fprintf(color.Output, "\n")
+		}
+		return nil
+	}
+
+	if len(textArgs) < 4 {
+		return fmt.Errorf(ratesCommand.Format)
+	}
+	var coins [2]uint32
+	for i := range coins {
+		n, err := strconv.ParseUint(textArgs[i+1], 10, 32)
+		if err != nil {
+			return err
+		}
+		coins[i] = uint32(n)
+	}
+
+	reply := new(litrpc.StatusReply)
+	switch textArgs[0] {
+	case "set":
+		args := new(litrpc.ExchangeRateArgs)
+		args.CoinType, args.FromCoinType = coins[0], coins[1]
+		rate := textArgs[3]
+		if strings.HasPrefix(rate, "1/") {
+			args.Reciprocal = true
+			rate = rate[2:]
+		}
+		var err error
+		args.Rate, err = strconv.ParseInt(rate, 10, 64)
+		if err != nil {
+			return err
+		}
+		err = lc.Call("LitRPC.SetExchangeRate", args, reply)
+		if err != nil {
+			return err
+		}
+	case "spread":
+		args := new(litrpc.RateSpreadArgs)
+		args.CoinType, args.FromCoinType = coins[0], coins[1]
+		spread, err := strconv.ParseUint(textArgs[3], 10, 32)
+		if err != nil {
+			return err
+		}
+		args.Spread = uint32(spread)
+		err = lc.Call("LitRPC.SetRateSpread", args, reply)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf(ratesCommand.Format)
+	}
+	fmt.Fprintf(color.Output, "%s\n", reply.Status)
+	return nil
+}
+
+var policyCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("policy"),
+		lnutil.ReqColor("subcommand"), lnutil.OptColor("parameters...")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n%s\n",
+		"Set or show what we ask to forward multihop payments over our channels.",
+		"Subcommand can be one of:",
+		fmt.Sprintf("%-20s %s",
+			lnutil.White("set"), "Set the fees, CLTV delta and HTLC limits of a channel"),
+		fmt.Sprintf("%-20s %s",
+			lnutil.White("get"), "Show the policy of a channel"),
+	),
+	ShortDescription: "Set or show channel forwarding policies.\n",
+}
+
+var policySetCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("policy set"),
+		lnutil.ReqColor("channel idx", "feeBase", "feeRate", "cltvDelta"),
+		lnutil.OptColor("minHTLC", "maxHTLC")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n",
+		"Set the forwarding policy of a channel, or with channel idx 0 the default for",
+		"all channels without their own.  feeRate is in millionths of the amount sent on.",
+		"A maxHTLC of 0 means no max."),
+	ShortDescription: "Set the forwarding policy of a channel.\n",
+}
+
+var policyGetCommand = &Command{
+	Format: fmt.Sprintf("%s%s\n", lnutil.White("policy get"),
+		lnutil.ReqColor("channel idx")),
+	Description:      "Show the forwarding policy of a channel, or with channel idx 0 the default.\n",
+	ShortDescription: "Show the forwarding policy of a channel.\n",
+}
+
+func (lc *litAfClient) Policy(textArgs []string) error {
+	if len(textArgs) == 0 || textArgs[0] == "-h" {
+		fmt.Fprintf(color.Output, policyCommand.Format)
+		fmt.Fprintf(color.Output, policyCommand.Description)
+		return nil
+	}
+
+	cmd := textArgs[0]
+	textArgs = textArgs[1:]
+	switch cmd {
+	case "set":
+		return lc.PolicySet(textArgs)
+	case "get":
+		return lc.PolicyGet(textArgs)
+	}
+	return fmt.Errorf(policyCommand.Format)
+}
+
+func (lc *litAfClient) PolicySet(textArgs []string) error {
+	stopEx, err := CheckHelpCommand(policySetCommand, textArgs, 4)
+	if err != nil || stopEx {
+		return err
+	}
+
+	args := new(litrpc.ChannelPolicyArgs)
+	reply := new(litrpc.StatusReply)
+
+	cIdx, err := strconv.ParseUint(textArgs[0], 10, 32)
+	if err != nil {
+		return err
+	}
+	args.ChanIdx = uint32(cIdx)
+	args.FeeBase, err = strconv.ParseInt(textArgs[1], 10, 64)
+	if err != nil {
+		return err
+	}
+	feeRate, err := strconv.ParseUint(textArgs[2], 10, 32)
+	if err != nil {
+		return err
+	}
+	args.FeeRate = uint32(feeRate)
+	cltvDelta, err := strconv.ParseUint(textArgs[3], 10, 32)
+	if err != nil {
+		return err
+	}
+	args.CLTVDelta = uint32(cltvDelta)
+	if len(textArgs) > 4 {
+		args.MinHTLC, err = strconv.ParseInt(textArgs[4], 10, 64)
+		if err != nil {
+			return err
+		}
+	}
+	if len(textArgs) > 5 {
+		args.MaxHTLC, err = strconv.ParseInt(textArgs[5], 10, 64)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = lc.Call("LitRPC.SetChannelPolicy", args, reply)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(color.Output, "%s\n", reply.Status)
+	return nil
+}
+
+func (lc *litAfClient) PolicyGet(textArgs []string) error {
+	stopEx, err := CheckHelpCommand(policyGetCommand, textArgs, 1)
+	if err != nil || stopEx {
+		return err
+	}
+
+	args := new(litrpc.ChanArgs)
+	reply := new(litrpc.ChannelPolicyReply)
+
+	cIdx, err := strconv.ParseUint(textArgs[0], 10, 32)
+	if err != nil {
+		return err
+	}
+	args.ChanIdx = uint32(cIdx)
+
+	err = lc.Call("LitRPC.GetChannelPolicy", args, reply)
+	if err != nil {
+		return err
+	}
+
+	maxHTLC := "none"
+	if reply.MaxHTLC != 0 {
+		maxHTLC = lnutil.SatoshiColor(reply.MaxHTLC)
+	}
+	fmt.Fprintf(color.Output, "%-20s : %s\n", lnutil.White("Base fee"), lnutil.SatoshiColor(reply.FeeBase))
+	fmt.Fprintf(color.Output, "%-20s : %d ppm\n", lnutil.White("Fee rate"), reply.FeeRate)
+	fmt.Fprintf(color.Output, "%-20s : %d blocks\n", lnutil.White("CLTV delta"), reply.CLTVDelta)
+	fmt.Fprintf(color.Output, "%-20s : %s\n", lnutil.White("Min HTLC"), lnutil.SatoshiColor(reply.MinHTLC))
+	fmt.Fprintf(color.Output, "%-20s : %s\n", lnutil.White("Max HTLC"), maxHTLC)
+	return nil
+}
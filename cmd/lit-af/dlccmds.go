@@ -2,12 +2,15 @@ package main
 
 import (
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/fatih/color"
+	"github.com/mit-dci/lit/dlc"
 	"github.com/mit-dci/lit/litrpc"
 	"github.com/mit-dci/lit/lnutil"
 	"github.com/mit-dci/lit/logging"
@@ -84,8 +87,8 @@ var addOracleCommand = &Command{
 var contractCommand = &Command{
 	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("dlc contract"),
 		lnutil.ReqColor("subcommand"), lnutil.OptColor("parameters...")),
-	Description: fmt.Sprintf("%s\n%s\n%s\n%s\n%s\n%s\n"+
-		"%s\n%s\n%s\n%s\n%s\n%s\n%s\n%s\n%s\n",
+	Description: fmt.Sprintf("%s\n%s\n%s\n%s\n%s\n%s\n%s\n%s\n%s\n%s\n%s\n"+
+		"%s\n%s\n%s\n%s\n%s\n%s\n%s\n%s\n%s\n%s\n%s\n%s\n",
 		"Command for managing contracts. Subcommand can be one of:",
 		fmt.Sprintf("%-20s %s",
 			lnutil.White("new"),
@@ -99,9 +102,21 @@ var contractCommand = &Command{
 		fmt.Sprintf("%-20s %s",
 			lnutil.White("setoracle"),
 			"Sets a contract to use a particular oracle"),
+		fmt.Sprintf("%-20s %s",
+			lnutil.White("addoracle"),
+			"Adds another oracle for a contract to settle on"),
+		fmt.Sprintf("%-20s %s",
+			lnutil.White("clearoracles"),
+			"Takes the added oracles off a contract"),
+		fmt.Sprintf("%-20s %s",
+			lnutil.White("setthreshold"),
+			"Sets how many oracles have to agree to settle"),
 		fmt.Sprintf("%-20s %s",
 			lnutil.White("settime"),
 			"Sets the settlement time of a contract"),
+		fmt.Sprintf("%-20s %s",
+			lnutil.White("setdigits"),
+			"Sets the contract to settle on a value signed digit by digit"),
 		fmt.Sprintf("%-20s %s",
 			lnutil.White("setdatafeed"),
 			"Sets the data feed to use, will fetch the R point"),
@@ -114,6 +129,12 @@ var contractCommand = &Command{
 		fmt.Sprintf("%-20s %s",
 			lnutil.White("setdivision"),
 			"Sets the settlement division of a contract"),
+		fmt.Sprintf("%-20s %s",
+			lnutil.White("setpayout"),
+			"Sets the division of a contract to a payout curve"),
+		fmt.Sprintf("%-20s %s",
+			lnutil.White("previewpayout"),
+			"Shows the division a payout curve would give"),
 		fmt.Sprintf("%-20s %s",
 			lnutil.White("setcointype"),
 			"Sets the cointype of a contract"),
@@ -123,6 +144,12 @@ var contractCommand = &Command{
 		fmt.Sprintf("%-20s %s",
 			lnutil.White("decline"),
 			"Decline a contract sent to you"),
+		fmt.Sprintf("%-20s %s",
+			lnutil.White("fundpsbt"),
+			"Shows the funding PSBT to sign outside lit"),
+		fmt.Sprintf("%-20s %s",
+			lnutil.White("signfunding"),
+			"Gives back the signed funding PSBT"),
 		fmt.Sprintf("%-20s %s",
 			lnutil.White("settle"),
 			"Settles the contract"),
@@ -194,6 +221,52 @@ var setContractOracleCommand = &Command{
 	ShortDescription: "Configures a contract for using a specific oracle\n",
 }
 
+var addContractOracleCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("dlc contract addoracle"),
+		lnutil.ReqColor("cid", "oid"), lnutil.OptColor("feed|rpoint...")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n%s\n%s\n",
+		"Adds another oracle for a contract to settle on. The contract settles",
+		"once as many of its oracles as its threshold sign the same value.",
+		fmt.Sprintf("%-10s %s",
+			lnutil.White("cid"),
+			"The ID of the contract"),
+		fmt.Sprintf("%-10s %s",
+			lnutil.White("oid"),
+			"The ID of the oracle"),
+		fmt.Sprintf("%-10s %s",
+			lnutil.White("feed|rpoint..."),
+			"The data feed to fetch the R point for, or the R point (33 byte in hex) or those of each digit"),
+	),
+	ShortDescription: "Adds another oracle for a contract to settle on\n",
+}
+
+var clearContractOraclesCommand = &Command{
+	Format: fmt.Sprintf("%s%s\n", lnutil.White("dlc contract clearoracles"),
+		lnutil.ReqColor("cid")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"Takes the oracles added with addoracle off a contract",
+		fmt.Sprintf("%-10s %s",
+			lnutil.White("cid"),
+			"The ID of the contract"),
+	),
+	ShortDescription: "Takes the added oracles off a contract\n",
+}
+
+var setContractThresholdCommand = &Command{
+	Format: fmt.Sprintf("%s%s\n", lnutil.White("dlc contract setthreshold"),
+		lnutil.ReqColor("cid", "m")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n",
+		"Sets how many of a contract's oracles have to sign the same value",
+		fmt.Sprintf("%-10s %s",
+			lnutil.White("cid"),
+			"The ID of the contract"),
+		fmt.Sprintf("%-10s %s",
+			lnutil.White("m"),
+			"The number of oracles (1 by default)"),
+	),
+	ShortDescription: "Sets how many oracles have to agree to settle\n",
+}
+
 var setContractDatafeedCommand = &Command{
 	Format: fmt.Sprintf("%s%s\n", lnutil.White("dlc contract setdatafeed"),
 		lnutil.ReqColor("cid", "feed")),
@@ -210,9 +283,9 @@ var setContractDatafeedCommand = &Command{
 }
 
 var setContractRPointCommand = &Command{
-	Format: fmt.Sprintf("%s%s\n", lnutil.White("dlc contract setrpoint"),
-		lnutil.ReqColor("cid", "rpoint")),
-	Description: fmt.Sprintf("%s\n%s\n%s\n",
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("dlc contract setrpoint"),
+		lnutil.ReqColor("cid", "rpoint"), lnutil.OptColor("rpoint...")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n%s\n",
 		"Sets the R point to use for the contract",
 		fmt.Sprintf("%-10s %s",
 			lnutil.White("cid"),
@@ -220,10 +293,32 @@ var setContractRPointCommand = &Command{
 		fmt.Sprintf("%-10s %s",
 			lnutil.White("rpoint"),
 			"The Rpoint of the publication to use (33 byte in hex)"),
+		fmt.Sprintf("%-10s %s",
+			lnutil.White("rpoint..."),
+			"For contracts on digits, the Rpoints of the rest of the digits"),
 	),
 	ShortDescription: "Sets the R point to use for the contract\n",
 }
 
+var setContractDigitsCommand = &Command{
+	Format: fmt.Sprintf("%s%s\n", lnutil.White("dlc contract setdigits"),
+		lnutil.ReqColor("cid", "base", "digits")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n%s\n%s\n",
+		"Sets the contract to settle on a value the oracle signs digit by digit,",
+		"with a settlement for each range paying the same, not each value",
+		fmt.Sprintf("%-10s %s",
+			lnutil.White("cid"),
+			"The ID of the contract"),
+		fmt.Sprintf("%-10s %s",
+			lnutil.White("base"),
+			"The base of the digits, usually 2 or 10"),
+		fmt.Sprintf("%-10s %s",
+			lnutil.White("digits"),
+			"The number of digits (0 for a value signed whole)"),
+	),
+	ShortDescription: "Sets the contract to settle on a value signed digit by digit\n",
+}
+
 var setContractSettlementTimeCommand = &Command{
 	Format: fmt.Sprintf("%s%s\n", lnutil.White("dlc contract settime"),
 		lnutil.ReqColor("cid", "time")),
@@ -275,6 +370,39 @@ var setContractDivisionCommand = &Command{
 	),
 	ShortDescription: "Sets the edge values for dividing the funds\n",
 }
+var setContractPayoutCommand = &Command{
+	Format: fmt.Sprintf("%s%s\n", lnutil.White("dlc contract setpayout"),
+		lnutil.ReqColor("cid", "curvefile")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n%s\n%s\n%s\n",
+		"Sets the division of a contract to what a payout curve pays us. The",
+		"curve is JSON with pieces, each of type linear, polynomial or step,",
+		"and optional rounding intervals, to cut the number of outcomes.",
+		"See litrpc/README.md for the format.",
+		fmt.Sprintf("%-10s %s",
+			lnutil.White("cid"),
+			"The ID of the contract"),
+		fmt.Sprintf("%-10s %s",
+			lnutil.White("curvefile"),
+			"The file with the payout curve"),
+	),
+	ShortDescription: "Sets the division of a contract to a payout curve\n",
+}
+
+var previewContractPayoutCommand = &Command{
+	Format: fmt.Sprintf("%s%s\n", lnutil.White("dlc contract previewpayout"),
+		lnutil.ReqColor("cid", "curvefile")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n",
+		"Shows the division a payout curve would give a contract, and how many outcomes need a settlement",
+		fmt.Sprintf("%-10s %s",
+			lnutil.White("cid"),
+			"The ID of the contract"),
+		fmt.Sprintf("%-10s %s",
+			lnutil.White("curvefile"),
+			"The file with the payout curve"),
+	),
+	ShortDescription: "Shows the division a payout curve would give\n",
+}
+
 var setContractCoinTypeCommand = &Command{
 	Format: fmt.Sprintf("%s%s\n", lnutil.White("dlc contract setcointype"),
 		lnutil.ReqColor("cid", "cointype")),
@@ -301,20 +429,23 @@ var declineContractCommand = &Command{
 	ShortDescription: "Declines a contract offered to you\n",
 }
 var acceptContractCommand = &Command{
-	Format: fmt.Sprintf("%s%s\n", lnutil.White("dlc contract accept"),
-		lnutil.ReqColor("cid")),
-	Description: fmt.Sprintf("%s\n%s\n",
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("dlc contract accept"),
+		lnutil.ReqColor("cid"), lnutil.OptColor("psbt")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n",
 		"Accepts a contract offered to you",
 		fmt.Sprintf("%-10s %s",
 			lnutil.White("cid"),
 			"The ID of the contract to accept"),
+		fmt.Sprintf("%-10s %s",
+			lnutil.White("psbt"),
+			"PSBT whose inputs fund our side, signed outside lit"),
 	),
 	ShortDescription: "Accepts a contract offered to you\n",
 }
 var offerContractCommand = &Command{
-	Format: fmt.Sprintf("%s%s\n", lnutil.White("dlc contract offer"),
-		lnutil.ReqColor("cid", "peer")),
-	Description: fmt.Sprintf("%s\n%s\n%s\n",
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("dlc contract offer"),
+		lnutil.ReqColor("cid", "peer"), lnutil.OptColor("psbt")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n%s\n",
 		"Offers a contract to one of your peers",
 		fmt.Sprintf("%-10s %s",
 			lnutil.White("cid"),
@@ -322,13 +453,42 @@ var offerContractCommand = &Command{
 		fmt.Sprintf("%-10s %s",
 			lnutil.White("cointype"),
 			"The ID of the peer to offer the contract to"),
+		fmt.Sprintf("%-10s %s",
+			lnutil.White("psbt"),
+			"PSBT whose inputs fund our side, signed outside lit"),
 	),
 	ShortDescription: "Offers a contract to one of your peers\n",
 }
+var fundingPsbtContractCommand = &Command{
+	Format: fmt.Sprintf("%s%s\n", lnutil.White("dlc contract fundpsbt"),
+		lnutil.ReqColor("cid")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"Shows the funding tx of a contract as a PSBT, for our inputs to be signed",
+		fmt.Sprintf("%-10s %s",
+			lnutil.White("cid"),
+			"The ID of the contract"),
+	),
+	ShortDescription: "Shows the funding PSBT of a contract\n",
+}
+var signFundingContractCommand = &Command{
+	Format: fmt.Sprintf("%s%s\n", lnutil.White("dlc contract signfunding"),
+		lnutil.ReqColor("cid", "psbt")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n",
+		"Gives back the funding PSBT of a contract with our inputs signed",
+		fmt.Sprintf("%-10s %s",
+			lnutil.White("cid"),
+			"The ID of the contract"),
+		fmt.Sprintf("%-10s %s",
+			lnutil.White("psbt"),
+			"The signed funding PSBT"),
+	),
+	ShortDescription: "Gives back the signed funding PSBT of a contract\n",
+}
 var settleContractCommand = &Command{
-	Format: fmt.Sprintf("%s%s\n", lnutil.White("dlc contract settle"),
-		lnutil.ReqColor("cid", "oracleValue", "oracleSig")),
-	Description: fmt.Sprintf("%s\n%s\n%s\n%s\n",
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("dlc contract settle"),
+		lnutil.ReqColor("cid", "oracleValue", "oracleSig"),
+		lnutil.OptColor("oracleSig...")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n%s\n%s\n%s\n",
 		"Settles the contract based on a value and signature from the oracle",
 		fmt.Sprintf("%-20s %s",
 			lnutil.White("cid"),
@@ -339,6 +499,12 @@ var settleContractCommand = &Command{
 		fmt.Sprintf("%-20s %s",
 			lnutil.White("oracleSig"),
 			"The signature from the oracle"),
+		fmt.Sprintf("%-20s %s",
+			lnutil.White("oracleSig..."),
+			"For contracts on several oracles, the signatures of each in order, - if it didn't sign"),
+		fmt.Sprintf("%-20s %s",
+			"",
+			"For contracts on digits, each is the signatures on the digits, separated by commas"),
 	),
 	ShortDescription: "Settles the contract\n",
 }
@@ -480,6 +646,22 @@ func (lc *litAfClient) DlcContract(textArgs []string) error {
 		return lc.DlcSetContractOracle(textArgs)
 	}
 
+	if cmd == "addoracle" {
+		return lc.DlcAddContractOracle(textArgs)
+	}
+
+	if cmd == "clearoracles" {
+		return lc.DlcClearContractOracles(textArgs)
+	}
+
+	if cmd == "setthreshold" {
+		return lc.DlcSetContractThreshold(textArgs)
+	}
+
+	if cmd == "setdigits" {
+		return lc.DlcSetContractDigits(textArgs)
+	}
+
 	if cmd == "setdatafeed" {
 		return lc.DlcSetContractDatafeed(textArgs)
 	}
@@ -500,6 +682,14 @@ func (lc *litAfClient) DlcContract(textArgs []string) error {
 		return lc.DlcSetContractDivision(textArgs)
 	}
 
+	if cmd == "setpayout" {
+		return lc.DlcSetContractPayout(textArgs)
+	}
+
+	if cmd == "previewpayout" {
+		return lc.DlcPreviewContractPayout(textArgs)
+	}
+
 	if cmd == "setcointype" {
 		return lc.DlcSetContractCoinType(textArgs)
 	}
@@ -516,6 +706,14 @@ func (lc *litAfClient) DlcContract(textArgs []string) error {
 		return lc.DlcAcceptContract(textArgs)
 	}
 
+	if cmd == "fundpsbt" {
+		return lc.DlcContractFundingPsbt(textArgs)
+	}
+
+	if cmd == "signfunding" {
+		return lc.DlcSignContractFunding(textArgs)
+	}
+
 	if cmd == "settle" {
 		return lc.DlcSettleContract(textArgs)
 	}
@@ -647,6 +845,104 @@ func (lc *litAfClient) DlcSetContractOracle(textArgs []string) error {
 	return nil
 }
 
+func (lc *litAfClient) DlcAddContractOracle(textArgs []string) error {
+	stopEx, err := CheckHelpCommand(addContractOracleCommand, textArgs, 2)
+	if err != nil || stopEx {
+		return err
+	}
+
+	args := new(litrpc.AddContractOracleArgs)
+	reply := new(litrpc.AddContractOracleReply)
+
+	cIdx, err := strconv.ParseUint(textArgs[0], 10, 64)
+	if err != nil {
+		return err
+	}
+	oIdx, err := strconv.ParseUint(textArgs[1], 10, 64)
+	if err != nil {
+		return err
+	}
+	args.CIdx = cIdx
+	args.OIdx = oIdx
+
+	if len(textArgs) > 2 {
+		if len(textArgs[2]) == 66 {
+			args.RPoints, err = parseRPoints(textArgs[2:])
+			if err != nil {
+				return err
+			}
+		} else {
+			args.Feed, err = strconv.ParseUint(textArgs[2], 10, 64)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err = lc.Call("LitRPC.AddContractOracle", args, reply)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprint(color.Output, "Oracle added successfully\n")
+
+	return nil
+}
+
+func (lc *litAfClient) DlcClearContractOracles(textArgs []string) error {
+	stopEx, err := CheckHelpCommand(clearContractOraclesCommand, textArgs, 1)
+	if err != nil || stopEx {
+		return err
+	}
+
+	args := new(litrpc.ClearContractOraclesArgs)
+	reply := new(litrpc.ClearContractOraclesReply)
+
+	args.CIdx, err = strconv.ParseUint(textArgs[0], 10, 64)
+	if err != nil {
+		return err
+	}
+
+	err = lc.Call("LitRPC.ClearContractOracles", args, reply)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprint(color.Output, "Oracles cleared successfully\n")
+
+	return nil
+}
+
+func (lc *litAfClient) DlcSetContractThreshold(textArgs []string) error {
+	stopEx, err := CheckHelpCommand(setContractThresholdCommand, textArgs, 2)
+	if err != nil || stopEx {
+		return err
+	}
+
+	args := new(litrpc.SetContractOracleThresholdArgs)
+	reply := new(litrpc.SetContractOracleThresholdReply)
+
+	cIdx, err := strconv.ParseUint(textArgs[0], 10, 64)
+	if err != nil {
+		return err
+	}
+	threshold, err := strconv.ParseUint(textArgs[1], 10, 32)
+	if err != nil {
+		return err
+	}
+	args.CIdx = cIdx
+	args.Threshold = uint32(threshold)
+
+	err = lc.Call("LitRPC.SetContractOracleThreshold", args, reply)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprint(color.Output, "Threshold set successfully\n")
+
+	return nil
+}
+
 func (lc *litAfClient) DlcSetContractDatafeed(textArgs []string) error {
 	stopEx, err := CheckHelpCommand(setContractDatafeedCommand, textArgs, 2)
 	if err != nil || stopEx {
@@ -690,12 +986,13 @@ func (lc *litAfClient) DlcSetContractRPoint(textArgs []string) error {
 	if err != nil {
 		return err
 	}
-	rPoint, err := hex.DecodeString(textArgs[1])
+	rPoints, err := parseRPoints(textArgs[1:])
 	if err != nil {
 		return err
 	}
 	args.CIdx = cIdx
-	copy(args.RPoint[:], rPoint[:])
+	args.RPoint = rPoints[0]
+	args.RPoints = rPoints
 
 	err = lc.Call("LitRPC.SetContractRPoint", args, reply)
 	if err != nil {
@@ -707,6 +1004,53 @@ func (lc *litAfClient) DlcSetContractRPoint(textArgs []string) error {
 	return nil
 }
 
+// parseRPoints parses R-points given in hex
+func parseRPoints(textArgs []string) ([][33]byte, error) {
+	rPoints := make([][33]byte, len(textArgs))
+	for i, s := range textArgs {
+		rPoint, err := hex.DecodeString(s)
+		if err != nil {
+			return nil, err
+		}
+		copy(rPoints[i][:], rPoint)
+	}
+	return rPoints, nil
+}
+
+func (lc *litAfClient) DlcSetContractDigits(textArgs []string) error {
+	stopEx, err := CheckHelpCommand(setContractDigitsCommand, textArgs, 3)
+	if err != nil || stopEx {
+		return err
+	}
+
+	args := new(litrpc.SetContractDigitsArgs)
+	reply := new(litrpc.SetContractDigitsReply)
+
+	args.CIdx, err = strconv.ParseUint(textArgs[0], 10, 64)
+	if err != nil {
+		return err
+	}
+	base, err := strconv.ParseUint(textArgs[1], 10, 32)
+	if err != nil {
+		return err
+	}
+	digits, err := strconv.ParseUint(textArgs[2], 10, 32)
+	if err != nil {
+		return err
+	}
+	args.Base = uint32(base)
+	args.Digits = uint32(digits)
+
+	err = lc.Call("LitRPC.SetContractDigits", args, reply)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprint(color.Output, "Digits set successfully\n")
+
+	return nil
+}
+
 func (lc *litAfClient) DlcSetContractSettlementTime(textArgs []string) error {
 	stopEx, err := CheckHelpCommand(setContractSettlementTimeCommand, textArgs, 2)
 	if err != nil || stopEx {
@@ -838,6 +1182,88 @@ func (lc *litAfClient) DlcSetContractDivision(textArgs []string) error {
 	return nil
 }
 
+// readPayoutCurve reads a payout curve from a JSON file
+func readPayoutCurve(name string) (dlc.PayoutCurve, error) {
+	var curve dlc.PayoutCurve
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return curve, err
+	}
+	err = json.Unmarshal(b, &curve)
+	return curve, err
+}
+
+func (lc *litAfClient) DlcSetContractPayout(textArgs []string) error {
+	stopEx, err := CheckHelpCommand(setContractPayoutCommand, textArgs, 2)
+	if err != nil || stopEx {
+		return err
+	}
+
+	args := new(litrpc.SetContractPayoutCurveArgs)
+	reply := new(litrpc.SetContractPayoutCurveReply)
+
+	args.CIdx, err = strconv.ParseUint(textArgs[0], 10, 64)
+	if err != nil {
+		return err
+	}
+	args.Curve, err = readPayoutCurve(textArgs[1])
+	if err != nil {
+		return err
+	}
+
+	err = lc.Call("LitRPC.SetContractPayoutCurve", args, reply)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprint(color.Output, "Payout set successfully\n")
+
+	return nil
+}
+
+func (lc *litAfClient) DlcPreviewContractPayout(textArgs []string) error {
+	stopEx, err := CheckHelpCommand(previewContractPayoutCommand, textArgs, 2)
+	if err != nil || stopEx {
+		return err
+	}
+
+	args := new(litrpc.PreviewContractPayoutCurveArgs)
+	reply := new(litrpc.PreviewContractPayoutCurveReply)
+
+	args.CIdx, err = strconv.ParseUint(textArgs[0], 10, 64)
+	if err != nil {
+		return err
+	}
+	args.Curve, err = readPayoutCurve(textArgs[1])
+	if err != nil {
+		return err
+	}
+
+	err = lc.Call("LitRPC.PreviewContractPayoutCurve", args, reply)
+	if err != nil {
+		return err
+	}
+
+	// the contract's funding gives what the peer gets
+	cArgs := new(litrpc.GetContractArgs)
+	cReply := new(litrpc.GetContractReply)
+	cArgs.Idx = args.CIdx
+	err = lc.Call("LitRPC.GetContract", cArgs, cReply)
+	if err != nil {
+		return err
+	}
+	c := cReply.Contract
+	c.Division = reply.Division
+
+	fmt.Fprintf(color.Output, "%-30s : %d\n",
+		lnutil.White("Divisions"), len(reply.Division))
+	fmt.Fprintf(color.Output, "%-30s : %d\n\n",
+		lnutil.White("Settlement outcomes"), reply.Outcomes)
+	PrintPayout(c, 0, int64(len(c.Division)), payoutIncrement(c))
+
+	return nil
+}
+
 func (lc *litAfClient) DlcOfferContract(textArgs []string) error {
 	stopEx, err := CheckHelpCommand(offerContractCommand, textArgs, 2)
 	if err != nil || stopEx {
@@ -858,6 +1284,9 @@ func (lc *litAfClient) DlcOfferContract(textArgs []string) error {
 
 	args.CIdx = cIdx
 	args.PeerIdx = uint32(peerIdx)
+	if len(textArgs) > 2 {
+		args.FundingPsbt = textArgs[2]
+	}
 
 	err = lc.Call("LitRPC.OfferContract", args, reply)
 	if err != nil {
@@ -880,6 +1309,9 @@ func (lc *litAfClient) dlcContractRespond(textArgs []string, aor bool) error {
 
 	args.CIdx = cIdx
 	args.AcceptOrDecline = aor
+	if aor && len(textArgs) > 1 {
+		args.FundingPsbt = textArgs[1]
+	}
 
 	err = lc.Call("LitRPC.ContractRespond", args, reply)
 	if err != nil {
@@ -913,6 +1345,59 @@ func (lc *litAfClient) DlcAcceptContract(textArgs []string) error {
 	return lc.dlcContractRespond(textArgs, true)
 }
 
+func (lc *litAfClient) DlcContractFundingPsbt(textArgs []string) error {
+	stopEx, err := CheckHelpCommand(fundingPsbtContractCommand, textArgs, 1)
+	if err != nil || stopEx {
+		return err
+	}
+
+	args := new(litrpc.GetContractArgs)
+	reply := new(litrpc.PsbtReply)
+
+	cIdx, err := strconv.ParseUint(textArgs[0], 10, 64)
+	if err != nil {
+		return err
+	}
+
+	args.Idx = cIdx
+
+	err = lc.Call("LitRPC.GetContractFundingPsbt", args, reply)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(color.Output, "%s\n", reply.Psbt)
+
+	return nil
+}
+
+func (lc *litAfClient) DlcSignContractFunding(textArgs []string) error {
+	stopEx, err := CheckHelpCommand(signFundingContractCommand, textArgs, 2)
+	if err != nil || stopEx {
+		return err
+	}
+
+	args := new(litrpc.SignContractFundingArgs)
+	reply := new(litrpc.StatusReply)
+
+	cIdx, err := strconv.ParseUint(textArgs[0], 10, 64)
+	if err != nil {
+		return err
+	}
+
+	args.CIdx = cIdx
+	args.Psbt = textArgs[1]
+
+	err = lc.Call("LitRPC.SignContractFunding", args, reply)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(color.Output, "%s\n", reply.Status)
+
+	return nil
+}
+
 func (lc *litAfClient) DlcSettleContract(textArgs []string) error {
 	stopEx, err := CheckHelpCommand(settleContractCommand, textArgs, 3)
 	if err != nil || stopEx {
@@ -935,13 +1420,22 @@ func (lc *litAfClient) DlcSettleContract(textArgs []string) error {
 	}
 
 	args.OracleValue = oracleValue
-	oracleSigBytes, err := hex.DecodeString(textArgs[2])
-	if err != nil {
-		return err
+	for _, s := range textArgs[2:] {
+		var sigs [][32]byte
+		if s != "-" {
+			for _, digitSig := range strings.Split(s, ",") {
+				oracleSigBytes, err := hex.DecodeString(digitSig)
+				if err != nil {
+					return err
+				}
+				var sig [32]byte
+				copy(sig[:], oracleSigBytes)
+				sigs = append(sigs, sig)
+			}
+		}
+		args.DigitSigs = append(args.DigitSigs, sigs)
 	}
 
-	copy(args.OracleSig[:], oracleSigBytes)
-
 	err = lc.Call("LitRPC.SettleContract", args, reply)
 	if err != nil {
 		return err
@@ -960,9 +1454,33 @@ func PrintContract(c *lnutil.DlcContract) {
 	fmt.Fprintf(color.Output, "%-30s : [%x...%x...%x]\n",
 		lnutil.White("Oracle R-point"), c.OracleR[:2],
 		c.OracleR[15:16], c.OracleR[31:])
+	for i, o := range c.Oracles {
+		fmt.Fprintf(color.Output, "%-30s : [%x...%x...%x]\n",
+			lnutil.White(fmt.Sprintf("Oracle %d public key", i+2)),
+			o.A[:2], o.A[15:16], o.A[31:])
+		fmt.Fprintf(color.Output, "%-30s : [%x...%x...%x]\n",
+			lnutil.White(fmt.Sprintf("Oracle %d R-point", i+2)),
+			o.R[:2], o.R[15:16], o.R[31:])
+	}
+	if c.OracleDigits > 0 {
+		fmt.Fprintf(color.Output, "%-30s : %d in base %d\n",
+			lnutil.White("Digits signed"), c.OracleDigits, c.OracleBase)
+		fmt.Fprintf(color.Output, "%-30s : %d\n",
+			lnutil.White("Settlement outcomes"), len(c.Outcomes()))
+	}
+	if len(c.Oracles) > 0 {
+		fmt.Fprintf(color.Output, "%-30s : %d of %d\n",
+			lnutil.White("Oracles to agree"), c.Threshold(),
+			len(c.AllOracles()))
+	}
 	fmt.Fprintf(color.Output, "%-30s : %s\n",
 		lnutil.White("Settlement time"),
 		time.Unix(int64(c.OracleTimestamp), 0).UTC().Format(time.UnixDate))
+	if c.RefundLockTime != 0 {
+		fmt.Fprintf(color.Output, "%-30s : %s\n",
+			lnutil.White("Refund time"),
+			time.Unix(int64(c.RefundLockTime), 0).UTC().Format(time.UnixDate))
+	}
 	fmt.Fprintf(color.Output, "%-30s : %d\n",
 		lnutil.White("Funded by us"), c.OurFundingAmount)
 	fmt.Fprintf(color.Output, "%-30s : %d\n",
@@ -997,12 +1515,29 @@ func PrintContract(c *lnutil.DlcContract) {
 		status = "Error"
 	case lnutil.ContractStatusDeclined:
 		status = "Declined"
+	case lnutil.ContractStatusFundingPsbt:
+		status = "Awaiting funding PSBT signatures"
+	case lnutil.ContractStatusRefunding:
+		status = "Refunding, the oracle didn't sign"
+	case lnutil.ContractStatusAwaitingOracle:
+		status = "Waiting for the oracle to publish"
+	case lnutil.ContractStatusSettling:
+		status = "Settling"
 	}
 
 	fmt.Fprintf(color.Output, "%-30s : %s\n\n", lnutil.White("Status"), status)
 
+	PrintPayout(c, 0, int64(len(c.Division)), payoutIncrement(c))
+}
+
+// payoutIncrement gives how many divisions to step by to show about ten of
+// a contract's.
+func payoutIncrement(c *lnutil.DlcContract) int64 {
 	increment := int64(len(c.Division) / 10)
-	PrintPayout(c, 0, int64(len(c.Division)), increment)
+	if increment < 1 {
+		increment = 1
+	}
+	return increment
 }
 
 func PrintPayout(c *lnutil.DlcContract, start, end, increment int64) {
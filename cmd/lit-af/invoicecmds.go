@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/mit-dci/lit/litrpc"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+var invoiceCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("invoice"),
+		lnutil.ReqColor("subcommand"), lnutil.OptColor("parameters...")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n%s\n%s\n%s\n",
+		"Work with invoices, signed requests for multihop payments.",
+		"Subcommand can be one of:",
+		fmt.Sprintf("%-20s %s",
+			lnutil.White("create"), "Make an invoice to be paid"),
+		fmt.Sprintf("%-20s %s",
+			lnutil.White("decode"), "Check and show an invoice"),
+		fmt.Sprintf("%-20s %s",
+			lnutil.White("pay"), "Pay an invoice"),
+		fmt.Sprintf("%-20s %s",
+			lnutil.White("ls"), "Show the invoices we've made"),
+	),
+	ShortDescription: "Create, decode and pay invoices.\n",
+}
+
+var invoiceCreateCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("invoice create"),
+		lnutil.ReqColor("coinType", "amount"), lnutil.OptColor("expiry", "description...")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n",
+		"Make a signed invoice for a payment of amount to us in coinType.",
+		"An amount of 0 lets the payer pick.  expiry is in seconds, default an hour.",
+		"The rest of the line is the description."),
+	ShortDescription: "Make an invoice to be paid.\n",
+}
+
+var invoiceDecodeCommand = &Command{
+	Format:           fmt.Sprintf("%s%s\n", lnutil.White("invoice decode"), lnutil.ReqColor("invoice")),
+	Description:      "Check the signature on an invoice and show what's in it.\n",
+	ShortDescription: "Check and show an invoice.\n",
+}
+
+var invoicePayCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("invoice pay"),
+		lnutil.ReqColor("invoice"), lnutil.OptColor("originCoinType", "amount")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"Pay an invoice along a multihop route.  The payee doesn't have to be online.",
+		"amount is only for invoices without one."),
+	ShortDescription: "Pay an invoice.\n",
+}
+
+var invoiceListCommand = &Command{
+	Format:           fmt.Sprintf("%s\n", lnutil.White("invoice ls")),
+	Description:      "Show the invoices we've made, and whether they've been paid.\n",
+	ShortDescription: "Show the invoices we've made.\n",
+}
+
+func (lc *litAfClient) Invoice(textArgs []string) error {
+	if len(textArgs) == 0 || textArgs[0] == "-h" {
+		fmt.Fprintf(color.Output, invoiceCommand.Format)
+		fmt.Fprintf(color.Output, invoiceCommand.Description)
+		return nil
+	}
+
+	cmd := textArgs[0]
+	textArgs = textArgs[1:]
+	switch cmd {
+	case "create":
+		return lc.InvoiceCreate(textArgs)
+	case "decode":
+		return lc.InvoiceDecode(textArgs)
+	case "pay":
+		return lc.InvoicePay(textArgs)
+	case "ls":
+		return lc.InvoiceList(textArgs)
+	}
+	return fmt.Errorf(invoiceCommand.Format)
+}
+
+func printInvoice(i litrpc.InvoiceInfo) {
+	fmt.Fprintf(color.Output, "%-20s : %s\n", lnutil.White("Payee"), lnutil.Address(i.Payee))
+	amt := "any"
+	if i.Amt != 0 {
+		amt = lnutil.SatoshiColor(i.Amt)
+	}
+	fmt.Fprintf(color.Output, "%-20s : %s (coin type %d)\n", lnutil.White("Amount"), amt, i.CoinType)
+	fmt.Fprintf(color.Output, "%-20s : %s\n", lnutil.White("Payment hash"), i.PaymentHash)
+	fmt.Fprintf(color.Output, "%-20s : %s\n", lnutil.White("Description"), i.Description)
+	fmt.Fprintf(color.Output, "%-20s : %s\n", lnutil.White("Created"),
+		time.Unix(i.Timestamp, 0).UTC().Format(time.UnixDate))
+	expires := time.Unix(i.Timestamp+int64(i.Expiry), 0).UTC().Format(time.UnixDate)
+	if i.Expired {
+		expires += lnutil.Red(" (expired)")
+	}
+	fmt.Fprintf(color.Output, "%-20s : %s\n", lnutil.White("Expires"), expires)
+	for _, h := range i.RouteHints {
+		fmt.Fprintf(color.Output, "%-20s : %s\n", lnutil.White("Route hint"), h)
+	}
+}
+
+func (lc *litAfClient) InvoiceCreate(textArgs []string) error {
+	stopEx, err := CheckHelpCommand(invoiceCreateCommand, textArgs, 2)
+	if err != nil || stopEx {
+		return err
+	}
+
+	args := new(litrpc.CreateInvoiceArgs)
+	reply := new(litrpc.CreateInvoiceReply)
+
+	coinType, err := strconv.Atoi(textArgs[0])
+	if err != nil {
+		return err
+	}
+	amt, err := strconv.ParseInt(textArgs[1], 10, 64)
+	if err != nil {
+		return err
+	}
+	args.CoinType = uint32(coinType)
+	args.Amt = amt
+	if len(textArgs) > 2 {
+		expiry, err := strconv.ParseUint(textArgs[2], 10, 32)
+		if err != nil {
+			return err
+		}
+		args.Expiry = uint32(expiry)
+	}
+	if len(textArgs) > 3 {
+		args.Description = strings.Join(textArgs[3:], " ")
+	}
+
+	err = lc.Call("LitRPC.CreateInvoice", args, reply)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(color.Output, "%s\n", reply.Invoice)
+	fmt.Fprintf(color.Output, "payment hash %s\n", reply.PaymentHash)
+	return nil
+}
+
+func (lc *litAfClient) InvoiceDecode(textArgs []string) error {
+	stopEx, err := CheckHelpCommand(invoiceDecodeCommand, textArgs, 1)
+	if err != nil || stopEx {
+		return err
+	}
+
+	args := new(litrpc.DecodeInvoiceArgs)
+	reply := new(litrpc.DecodeInvoiceReply)
+	args.Invoice = textArgs[0]
+
+	err = lc.Call("LitRPC.DecodeInvoice", args, reply)
+	if err != nil {
+		return err
+	}
+
+	printInvoice(reply.Invoice)
+	return nil
+}
+
+func (lc *litAfClient) InvoicePay(textArgs []string) error {
+	stopEx, err := CheckHelpCommand(invoicePayCommand, textArgs, 1)
+	if err != nil || stopEx {
+		return err
+	}
+
+	args := new(litrpc.PayInvoiceArgs)
+	reply := new(litrpc.StatusReply)
+	args.Invoice = textArgs[0]
+	if len(textArgs) > 1 {
+		coinType, err := strconv.Atoi(textArgs[1])
+		if err != nil {
+			return err
+		}
+		args.OriginCoinType = uint32(coinType)
+	}
+	if len(textArgs) > 2 {
+		args.Amt, err = strconv.ParseInt(textArgs[2], 10, 64)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = lc.Call("LitRPC.PayInvoice", args, reply)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(color.Output, "%s\n", reply.Status)
+	return nil
+}
+
+func (lc *litAfClient) InvoiceList(textArgs []string) error {
+	stopEx, err := CheckHelpCommand(invoiceListCommand, textArgs, 0)
+	if err != nil || stopEx {
+		return err
+	}
+
+	reply := new(litrpc.ListInvoicesReply)
+	err = lc.Call("LitRPC.ListInvoices", nil, reply)
+	if err != nil {
+		return err
+	}
+
+	if len(reply.Invoices) == 0 {
+		fmt.Println("No invoices found")
+	}
+	for _, i := range reply.Invoices {
+		printInvoice(i)
+		paid := lnutil.Red("no")
+		if i.Paid {
+			paid = lnutil.Green("yes")
+		}
+		fmt.Fprintf(color.Output, "%-20s : %s\n", lnutil.White("Paid"), paid)
+		fmt.Fprintf(color.Output, "%-20s : %s\n\n", lnutil.White("Preimage"), i.PreImage)
+	}
+	return nil
+}
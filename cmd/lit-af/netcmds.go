@@ -2,12 +2,14 @@ package main
 
 import (
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"strconv"
 
 	"github.com/mit-dci/lit/qln"
 
 	"github.com/fatih/color"
+	"github.com/howeyc/gopass"
 	"github.com/mit-dci/lit/litrpc"
 	"github.com/mit-dci/lit/lnutil"
 )
@@ -34,9 +36,23 @@ var conCommand = &Command{
 }
 
 var graphCommand = &Command{
-	Format:           fmt.Sprintf("%s\n", lnutil.White("graph")),
-	Description:      fmt.Sprintf("Dump the channel graph in graphviz DOT format\n"),
-	ShortDescription: "Shows the channel map\n",
+	Format: fmt.Sprintf("%s%s\n", lnutil.White("graph"),
+		lnutil.OptColor("subcommand", "parameters...")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n%s\n%s\n%s\n%s\n",
+		"Dump the channel graph in graphviz DOT format, or query it in JSON.",
+		"Subcommand can be one of:",
+		fmt.Sprintf("%-20s %s",
+			lnutil.White("nodes"), "Show the nodes in the graph"),
+		fmt.Sprintf("%-20s %s",
+			lnutil.White("edges"), "Show the links in the graph, or to and from one node: edges [lnadr]"),
+		fmt.Sprintf("%-20s %s",
+			lnutil.White("routes"), "Show routes to pay a node: routes lnadr amount [destCoinType] [originCoinType] [count]"),
+		fmt.Sprintf("%-20s %s",
+			lnutil.White("prune"), "Show or set when links are pruned: prune [maxAge] [minCapacity]"),
+		fmt.Sprintf("%-20s %s",
+			lnutil.White("failures"), "Show the links payments have failed over lately, or forget them: failures [reset]"),
+	),
+	ShortDescription: "Shows or queries the channel map\n",
 }
 
 var rcAuthCommand = &Command{
@@ -52,6 +68,18 @@ var rcRequestCommand = &Command{
 	ShortDescription: "Requests remote control authorization\n",
 }
 
+var passwdCommand = &Command{
+	Format:           fmt.Sprintf("%s%s\n", lnutil.White("passwd"), lnutil.OptColor("plain")),
+	Description:      "Change the passphrase the node's key file is encrypted with. With plain, the key is stored unencrypted instead.\n",
+	ShortDescription: "Change the key file passphrase\n",
+}
+
+var seedCommand = &Command{
+	Format:           fmt.Sprintf("%s\n", lnutil.White("seed")),
+	Description:      "Show the BIP39 seed words the node key was made from. Asks for the key file passphrase.\n",
+	ShortDescription: "Show the node's seed words\n",
+}
+
 // graph gets the channel map
 func (lc *litAfClient) Graph(textArgs []string) error {
 	if len(textArgs) > 0 && textArgs[0] == "-h" {
@@ -60,6 +88,52 @@ func (lc *litAfClient) Graph(textArgs []string) error {
 		return nil
 	}
 
+	if len(textArgs) > 0 {
+		cmd := textArgs[0]
+		textArgs = textArgs[1:]
+		switch cmd {
+		case "nodes":
+			reply := new(litrpc.GraphNodesReply)
+			err := lc.Call("LitRPC.GetGraphNodes", new(litrpc.NoArgs), reply)
+			if err != nil {
+				return err
+			}
+			return printJSON(reply.Nodes)
+		case "edges":
+			args := new(litrpc.GraphEdgesArgs)
+			reply := new(litrpc.GraphEdgesReply)
+			if len(textArgs) > 0 {
+				args.LNAdr = textArgs[0]
+			}
+			err := lc.Call("LitRPC.GetGraphEdges", args, reply)
+			if err != nil {
+				return err
+			}
+			return printJSON(reply.Edges)
+		case "routes":
+			return lc.GraphRoutes(textArgs)
+		case "prune":
+			return lc.GraphPrune(textArgs)
+		case "failures":
+			if len(textArgs) > 0 && textArgs[0] == "reset" {
+				reply := new(litrpc.StatusReply)
+				err := lc.Call("LitRPC.ResetMissionControl", new(litrpc.NoArgs), reply)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(color.Output, "%s\n", reply.Status)
+				return nil
+			}
+			reply := new(litrpc.MissionControlReply)
+			err := lc.Call("LitRPC.GetMissionControl", new(litrpc.NoArgs), reply)
+			if err != nil {
+				return err
+			}
+			return printJSON(reply.Edges)
+		}
+		return fmt.Errorf(graphCommand.Format)
+	}
+
 	args := new(litrpc.NoArgs)
 	reply := new(litrpc.ChannelGraphReply)
 
@@ -73,6 +147,83 @@ func (lc *litAfClient) Graph(textArgs []string) error {
 	return nil
 }
 
+// printJSON prints a graph query reply as indented JSON.
+func printJSON(v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(color.Output, "%s\n", b)
+	return nil
+}
+
+func (lc *litAfClient) GraphRoutes(textArgs []string) error {
+	if len(textArgs) < 2 {
+		return fmt.Errorf("need lnadr and amount")
+	}
+
+	args := new(litrpc.QueryRoutesArgs)
+	reply := new(litrpc.QueryRoutesReply)
+
+	args.DestLNAdr = textArgs[0]
+	amt, err := strconv.ParseInt(textArgs[1], 10, 64)
+	if err != nil {
+		return err
+	}
+	args.Amt = amt
+
+	nums := make([]uint32, 3)
+	for i := 0; i < len(nums) && i+2 < len(textArgs); i++ {
+		n, err := strconv.ParseUint(textArgs[i+2], 10, 32)
+		if err != nil {
+			return err
+		}
+		nums[i] = uint32(n)
+	}
+	args.DestCoinType = nums[0]
+	args.OriginCoinType = nums[1]
+	args.Count = nums[2]
+
+	err = lc.Call("LitRPC.QueryRoutes", args, reply)
+	if err != nil {
+		return err
+	}
+	return printJSON(reply.Routes)
+}
+
+func (lc *litAfClient) GraphPrune(textArgs []string) error {
+	if len(textArgs) == 0 {
+		reply := new(litrpc.GraphPruneRulesReply)
+		err := lc.Call("LitRPC.GetGraphPruneRules", new(litrpc.NoArgs), reply)
+		if err != nil {
+			return err
+		}
+		return printJSON(reply)
+	}
+
+	args := new(litrpc.GraphPruneRulesArgs)
+	reply := new(litrpc.StatusReply)
+
+	var err error
+	args.MaxAge, err = strconv.ParseInt(textArgs[0], 10, 64)
+	if err != nil {
+		return err
+	}
+	if len(textArgs) > 1 {
+		args.MinCapacity, err = strconv.ParseInt(textArgs[1], 10, 64)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = lc.Call("LitRPC.SetGraphPruneRules", args, reply)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(color.Output, "%s\n", reply.Status)
+	return nil
+}
+
 // Lis starts listening.  Takes args of port to listen on.
 func (lc *litAfClient) Lis(textArgs []string) error {
 	var err error
@@ -198,3 +349,84 @@ func (lc *litAfClient) RemoteControlRequest(textArgs []string) error {
 	fmt.Fprintf(color.Output, "%s\n", reply.Status)
 	return nil
 }
+
+// Passwd prompts for the old and new key file passphrases and asks the node
+// to re-encrypt its key file
+func (lc *litAfClient) Passwd(textArgs []string) error {
+	stopEx, err := CheckHelpCommand(passwdCommand, textArgs, 0)
+	if err != nil || stopEx {
+		return err
+	}
+
+	fmt.Printf("old passphrase: ")
+	oldPass, err := gopass.GetPasswd()
+	if err != nil {
+		return err
+	}
+
+	args := new(litrpc.ChangePassphraseArgs)
+	args.OldPassphrase = string(oldPass)
+	reply := new(litrpc.StatusReply)
+
+	if len(textArgs) > 0 && textArgs[0] == "plain" {
+		args.RemoveEncryption = true
+		err = lc.Call("LitRPC.ChangePassphrase", args, reply)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(color.Output, "%s\n", reply.Status)
+		return nil
+	}
+
+	fmt.Printf("new passphrase: ")
+	newPass, err := gopass.GetPasswd()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("repeat new passphrase: ")
+	newPass2, err := gopass.GetPasswd()
+	if err != nil {
+		return err
+	}
+	if string(newPass) != string(newPass2) {
+		return fmt.Errorf("new passphrases don't match")
+	}
+
+	if len(newPass) == 0 {
+		return fmt.Errorf("empty new passphrase; use passwd plain to" +
+			" store the key unencrypted")
+	}
+	args.NewPassphrase = string(newPass)
+
+	err = lc.Call("LitRPC.ChangePassphrase", args, reply)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(color.Output, "%s\n", reply.Status)
+	return nil
+}
+
+// Seed shows the node's BIP39 seed words
+func (lc *litAfClient) Seed(textArgs []string) error {
+	stopEx, err := CheckHelpCommand(seedCommand, textArgs, 0)
+	if err != nil || stopEx {
+		return err
+	}
+
+	fmt.Printf("passphrase: ")
+	pass, err := gopass.GetPasswd()
+	if err != nil {
+		return err
+	}
+
+	args := new(litrpc.ShowSeedArgs)
+	args.Passphrase = string(pass)
+	reply := new(litrpc.ShowSeedReply)
+
+	err = lc.Call("LitRPC.ShowSeed", args, reply)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(color.Output, "%s\n", reply.Mnemonic)
+	return nil
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+
+	"github.com/fatih/color"
+	"github.com/mit-dci/lit/litrpc"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+var psbtCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("psbt"),
+		lnutil.ReqColor("subcommand"), lnutil.OptColor("parameters...")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n%s\n%s\n%s\n%s\n%s\n%s\n",
+		"Work with partially signed transactions (BIP 174), in base64.",
+		"Subcommand can be one of:",
+		fmt.Sprintf("%-20s %s",
+			lnutil.White("create"), "Make an unsigned PSBT from the wallet"),
+		fmt.Sprintf("%-20s %s",
+			lnutil.White("sign"), "Sign the inputs of a PSBT which are ours"),
+		fmt.Sprintf("%-20s %s",
+			lnutil.White("combine"), "Merge PSBTs signed separately"),
+		fmt.Sprintf("%-20s %s",
+			lnutil.White("finalize"), "Finalize a PSBT, and maybe broadcast it"),
+		fmt.Sprintf("%-20s %s",
+			lnutil.White("fund"), "Start a channel funded outside lit"),
+		fmt.Sprintf("%-20s %s",
+			lnutil.White("fundfinish"), "Give the signed funding tx for that channel"),
+		fmt.Sprintf("%-20s %s",
+			lnutil.White("fundcancel"), "Give up on that channel"),
+	),
+	ShortDescription: "Create, sign, combine and finalize PSBTs.\n",
+}
+
+var psbtCreateCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("psbt create"),
+		lnutil.ReqColor("address", "amount"), lnutil.OptColor("address amount...")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"Make an unsigned PSBT paying the given addresses, with change back to us.",
+		"The inputs are locked until spent; unlock them if the PSBT is dropped."),
+	ShortDescription: "Make an unsigned PSBT from the wallet.\n",
+}
+
+var psbtSignCommand = &Command{
+	Format:           fmt.Sprintf("%s%s\n", lnutil.White("psbt sign"), lnutil.ReqColor("psbt")),
+	Description:      "Sign and finalize the inputs of a PSBT which are in the wallet.\n",
+	ShortDescription: "Sign our inputs of a PSBT.\n",
+}
+
+var psbtCombineCommand = &Command{
+	Format: fmt.Sprintf("%s%s\n", lnutil.White("psbt combine"),
+		lnutil.ReqColor("psbt", "psbt...")),
+	Description:      "Merge PSBTs for the same tx which were signed separately.\n",
+	ShortDescription: "Merge PSBTs signed separately.\n",
+}
+
+var psbtFinalizeCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("psbt finalize"),
+		lnutil.ReqColor("psbt"), lnutil.OptColor("broadcast")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"Finalize the inputs of a PSBT which have enough signatures.",
+		"If they all do, show the signed tx; add broadcast to send it out."),
+	ShortDescription: "Finalize a PSBT.\n",
+}
+
+var psbtFundCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("psbt fund"),
+		lnutil.ReqColor("peer", "coinType", "capacity", "initialSend"), lnutil.OptColor("data")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n",
+		"Start a channel with the given peer, funded by a tx signed outside lit.",
+		"Shows the address and amount the funding tx has to pay.  Every input",
+		"has to be segwit.  Give the signed PSBT to psbt fundfinish."),
+	ShortDescription: "Start a channel funded outside lit.\n",
+}
+
+var psbtFundFinishCommand = &Command{
+	Format: fmt.Sprintf("%s%s\n", lnutil.White("psbt fundfinish"),
+		lnutil.ReqColor("psbt")),
+	Description:      "Open the channel started with psbt fund, with its signed funding tx.\n",
+	ShortDescription: "Give the signed funding tx for a channel.\n",
+}
+
+var psbtFundCancelCommand = &Command{
+	Format:           fmt.Sprintf("%s\n", lnutil.White("psbt fundcancel")),
+	Description:      "Give up on the channel started with psbt fund.\n",
+	ShortDescription: "Give up on a channel funded outside lit.\n",
+}
+
+func (lc *litAfClient) Psbt(textArgs []string) error {
+	if len(textArgs) == 0 || textArgs[0] == "-h" {
+		fmt.Fprintf(color.Output, psbtCommand.Format)
+		fmt.Fprintf(color.Output, psbtCommand.Description)
+		return nil
+	}
+
+	cmd := textArgs[0]
+	textArgs = textArgs[1:]
+	switch cmd {
+	case "create":
+		return lc.PsbtCreate(textArgs)
+	case "sign":
+		return lc.PsbtSign(textArgs)
+	case "combine":
+		return lc.PsbtCombine(textArgs)
+	case "finalize":
+		return lc.PsbtFinalize(textArgs)
+	case "fund":
+		return lc.PsbtFund(textArgs)
+	case "fundfinish":
+		return lc.PsbtFundFinish(textArgs)
+	case "fundcancel":
+		return lc.PsbtFundCancel(textArgs)
+	}
+	return fmt.Errorf(psbtCommand.Format)
+}
+
+func printPsbt(reply *litrpc.PsbtReply) {
+	fmt.Fprintf(color.Output, "%s\n", reply.Psbt)
+	if reply.Complete {
+		fmt.Fprintf(color.Output, "complete; finalize to get the tx\n")
+	}
+}
+
+func (lc *litAfClient) PsbtCreate(textArgs []string) error {
+	stopEx, err := CheckHelpCommand(psbtCreateCommand, textArgs, 2)
+	if err != nil || stopEx {
+		return err
+	}
+	if len(textArgs)%2 != 0 {
+		return fmt.Errorf("need an amount for each address")
+	}
+
+	args := new(litrpc.CreatePsbtArgs)
+	reply := new(litrpc.PsbtReply)
+	for i := 0; i < len(textArgs); i += 2 {
+		amt, err := strconv.ParseInt(textArgs[i+1], 10, 64)
+		if err != nil {
+			return err
+		}
+		args.DestAddrs = append(args.DestAddrs, textArgs[i])
+		args.Amts = append(args.Amts, amt)
+	}
+
+	err = lc.Call("LitRPC.CreatePsbt", args, reply)
+	if err != nil {
+		return err
+	}
+	printPsbt(reply)
+	return nil
+}
+
+func (lc *litAfClient) PsbtSign(textArgs []string) error {
+	stopEx, err := CheckHelpCommand(psbtSignCommand, textArgs, 1)
+	if err != nil || stopEx {
+		return err
+	}
+
+	args := new(litrpc.PsbtArgs)
+	reply := new(litrpc.PsbtReply)
+	args.Psbt = textArgs[0]
+
+	err = lc.Call("LitRPC.SignPsbt", args, reply)
+	if err != nil {
+		return err
+	}
+	printPsbt(reply)
+	return nil
+}
+
+func (lc *litAfClient) PsbtCombine(textArgs []string) error {
+	stopEx, err := CheckHelpCommand(psbtCombineCommand, textArgs, 2)
+	if err != nil || stopEx {
+		return err
+	}
+
+	args := new(litrpc.CombinePsbtArgs)
+	reply := new(litrpc.PsbtReply)
+	args.Psbts = textArgs
+
+	err = lc.Call("LitRPC.CombinePsbt", args, reply)
+	if err != nil {
+		return err
+	}
+	printPsbt(reply)
+	return nil
+}
+
+func (lc *litAfClient) PsbtFinalize(textArgs []string) error {
+	stopEx, err := CheckHelpCommand(psbtFinalizeCommand, textArgs, 1)
+	if err != nil || stopEx {
+		return err
+	}
+
+	args := new(litrpc.FinalizePsbtArgs)
+	reply := new(litrpc.FinalizePsbtReply)
+	args.Psbt = textArgs[0]
+	if len(textArgs) > 1 {
+		if textArgs[1] != "broadcast" {
+			return fmt.Errorf(psbtFinalizeCommand.Format)
+		}
+		args.Broadcast = true
+	}
+
+	err = lc.Call("LitRPC.FinalizePsbt", args, reply)
+	if err != nil {
+		return err
+	}
+	if !reply.Complete {
+		fmt.Fprintf(color.Output, "not fully signed yet:\n%s\n", reply.Psbt)
+		return nil
+	}
+	fmt.Fprintf(color.Output, "%s\n", reply.Tx)
+	if args.Broadcast {
+		fmt.Fprintf(color.Output, "sent txid %s\n", reply.Txid)
+	} else {
+		fmt.Fprintf(color.Output, "txid %s\n", reply.Txid)
+	}
+	return nil
+}
+
+func (lc *litAfClient) PsbtFund(textArgs []string) error {
+	stopEx, err := CheckHelpCommand(psbtFundCommand, textArgs, 4)
+	if err != nil || stopEx {
+		return err
+	}
+
+	args := new(litrpc.FundPsbtArgs)
+	reply := new(litrpc.FundPsbtReply)
+
+	peer, err := strconv.Atoi(textArgs[0])
+	if err != nil {
+		return err
+	}
+	coinType, err := strconv.Atoi(textArgs[1])
+	if err != nil {
+		return err
+	}
+	cCap, err := strconv.Atoi(textArgs[2])
+	if err != nil {
+		return err
+	}
+	iSend, err := strconv.Atoi(textArgs[3])
+	if err != nil {
+		return err
+	}
+	if len(textArgs) > 4 {
+		data, err := hex.DecodeString(textArgs[4])
+		if err != nil {
+			// Wasn't valid hex, copy directly and truncate
+			copy(args.Data[:], textArgs[4])
+		} else {
+			copy(args.Data[:], data[:])
+		}
+	}
+
+	args.Peer = uint32(peer)
+	args.CoinType = uint32(coinType)
+	args.Capacity = int64(cCap)
+	args.InitialSend = int64(iSend)
+
+	err = lc.Call("LitRPC.FundChannelPsbt", args, reply)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(color.Output, "funding tx has to pay %s to %s\n",
+		lnutil.SatoshiColor(reply.Amount), lnutil.Address(reply.Address))
+	fmt.Fprintf(color.Output, "then: psbt fundfinish <signed psbt>\n")
+	return nil
+}
+
+func (lc *litAfClient) PsbtFundFinish(textArgs []string) error {
+	stopEx, err := CheckHelpCommand(psbtFundFinishCommand, textArgs, 1)
+	if err != nil || stopEx {
+		return err
+	}
+
+	args := new(litrpc.PsbtArgs)
+	reply := new(litrpc.FundReply)
+	args.Psbt = textArgs[0]
+
+	err = lc.Call("LitRPC.FinishFundChannelPsbt", args, reply)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(color.Output, "%s\n", reply.Status)
+	return nil
+}
+
+func (lc *litAfClient) PsbtFundCancel(textArgs []string) error {
+	stopEx, err := CheckHelpCommand(psbtFundCancelCommand, textArgs, 0)
+	if err != nil || stopEx {
+		return err
+	}
+
+	reply := new(litrpc.StatusReply)
+	err = lc.Call("LitRPC.CancelFundChannelPsbt", nil, reply)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(color.Output, "%s\n", reply.Status)
+	return nil
+}
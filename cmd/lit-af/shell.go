@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"sort"
 	"strconv"
+	"strings"
+	"time"
 
 	"io/ioutil"
 	"net/http"
@@ -173,6 +175,16 @@ func (lc *litAfClient) Shellparse(cmdslice []string) error {
 		return parseErr(err, "rcreq")
 	}
 
+	if cmd == "passwd" {
+		err = lc.Passwd(args)
+		return parseErr(err, "passwd")
+	}
+
+	if cmd == "seed" {
+		err = lc.Seed(args)
+		return parseErr(err, "seed")
+	}
+
 	// fund and create a new channel
 	if cmd == "fund" {
 		err = lc.FundChannel(args)
@@ -209,6 +221,10 @@ func (lc *litAfClient) Shellparse(cmdslice []string) error {
 		err = lc.BreakChannel(args)
 		return parseErr(err, "break")
 	}
+	if cmd == "importbackup" {
+		err = lc.ImportBackup(args)
+		return parseErr(err, "importbackup")
+	}
 	if cmd == "say" {
 		err = lc.Say(args)
 		return parseErr(err, "say")
@@ -218,6 +234,26 @@ func (lc *litAfClient) Shellparse(cmdslice []string) error {
 		err = lc.Fan(args)
 		return parseErr(err, "fan")
 	}
+	if cmd == "lock" { // keep utxos out of coin selection
+		err = lc.Lock(args)
+		return parseErr(err, "lock")
+	}
+	if cmd == "unlock" {
+		err = lc.Unlock(args)
+		return parseErr(err, "unlock")
+	}
+	if cmd == "label" { // label a utxo or address
+		err = lc.Label(args)
+		return parseErr(err, "label")
+	}
+	if cmd == "bump" { // bump the fee of an unconfirmed tx
+		err = lc.Bump(args)
+		return parseErr(err, "bump")
+	}
+	if cmd == "psbt" { // partially signed txs
+		err = lc.Psbt(args)
+		return parseErr(err, "psbt")
+	}
 	if cmd == "fee" { // get fee rate for a wallet
 		err = lc.Fee(args)
 		return parseErr(err, "fee")
@@ -234,6 +270,22 @@ func (lc *litAfClient) Shellparse(cmdslice []string) error {
 		err = lc.Graph(args)
 		return parseErr(err, "grpah")
 	}
+	if cmd == "invoice" { // signed payment requests
+		err = lc.Invoice(args)
+		return parseErr(err, "invoice")
+	}
+	if cmd == "policy" { // channel forwarding policies
+		err = lc.Policy(args)
+		return parseErr(err, "policy")
+	}
+	if cmd == "keysend" { // take spontaneous payments
+		err = lc.Keysend(args)
+		return parseErr(err, "keysend")
+	}
+	if cmd == "rates" { // cross-coin exchange rates
+		err = lc.Rates(args)
+		return parseErr(err, "rates")
+	}
 	if cmd == "paymultihop" { // pay via multi-hop
 		err = lc.PayMultihop(args)
 		if err != nil {
@@ -500,6 +552,12 @@ func (lc *litAfClient) Ls(textArgs []string) error {
 				if !t.Witty {
 					fmt.Fprintf(color.Output, " non-witness")
 				}
+				if t.Locked {
+					fmt.Fprintf(color.Output, " %s", lnutil.Red("locked"))
+				}
+				if t.Label != "" {
+					fmt.Fprintf(color.Output, " [%s]", t.Label)
+				}
 				fmt.Fprintf(color.Output, "\n")
 			}
 		}
@@ -545,8 +603,12 @@ func (lc *litAfClient) Ls(textArgs []string) error {
 				fmt.Fprintf(color.Output, "\t%s\n", lnutil.Header("Addresses:"))
 			}
 			for i, a := range aReply.WitAddresses {
-				fmt.Fprintf(color.Output, "%d %s (%s)\n", i+1,
+				fmt.Fprintf(color.Output, "%d %s (%s)", i+1,
 					lnutil.Address(a), lnutil.Address(aReply.LegacyAddresses[i]))
+				if i < len(aReply.Labels) && aReply.Labels[i] != "" {
+					fmt.Fprintf(color.Output, " [%s]", aReply.Labels[i])
+				}
+				fmt.Fprintf(color.Output, "\n")
 			}
 		}
 
@@ -582,9 +644,14 @@ func (lc *litAfClient) Ls(textArgs []string) error {
 		}
 
 		for _, p := range mhReply.Payments {
-			if p.Succeeded {
+			switch p.State {
+			case "succeeded":
 				fmt.Fprintf(color.Output, lnutil.Green("Completed: "))
-			} else {
+			case "failed":
+				fmt.Fprintf(color.Output, lnutil.Red("Failed:    "))
+			case "timed out":
+				fmt.Fprintf(color.Output, lnutil.Red("Timed out: "))
+			default:
 				c := color.New(color.FgYellow)
 				c.Printf("Pending:   ")
 			}
@@ -599,6 +666,27 @@ func (lc *litAfClient) Ls(textArgs []string) error {
 				lnutil.SatoshiColor(p.Amt), p.RHash,
 				p.R,
 				path)
+			if p.TotalAmt != 0 {
+				fmt.Fprintf(color.Output, "\tpart %d of a split payment of %s\n",
+					p.PartIdx, lnutil.SatoshiColor(p.TotalAmt))
+			}
+			if p.Failure != "" {
+				fmt.Fprintf(color.Output, "\t%s\n", p.Failure)
+			}
+			// only worth listing the routes tried if there's been more than one
+			if len(p.Attempts) > 1 {
+				for i, a := range p.Attempts {
+					result := a.Failure
+					if result == "" && a.End != 0 {
+						result = "ok"
+					} else if result == "" {
+						result = "in flight"
+					}
+					fmt.Fprintf(color.Output, "\tattempt %d at %s: sent %s over %s: %s\n",
+						i+1, time.Unix(a.Start, 0).Format(time.Stamp),
+						lnutil.SatoshiColor(a.Amt), strings.Join(a.Path, " -> "), result)
+				}
+			}
 		}
 	}
 
@@ -642,7 +730,7 @@ func (lc *litAfClient) Help(textArgs []string) error {
 	if len(textArgs) == 0 {
 
 		fmt.Fprintf(color.Output, lnutil.Header("Commands:\n"))
-		listofCommands := []*Command{helpCommand, sayCommand, lsCommand, addressCommand, sendCommand, fanCommand, sweepCommand, lisCommand, conCommand, dlcCommand, fundCommand, dualFundCommand, watchCommand, pushCommand, closeCommand, breakCommand, addHTLCCommand, clearHTLCCommand, rcAuthCommand, rcRequestCommand, historyCommand, offCommand, exitCommand}
+		listofCommands := []*Command{helpCommand, sayCommand, lsCommand, addressCommand, sendCommand, bumpCommand, lockCommand, unlockCommand, labelCommand, psbtCommand, invoiceCommand, fanCommand, sweepCommand, lisCommand, conCommand, dlcCommand, fundCommand, dualFundCommand, watchCommand, pushCommand, closeCommand, breakCommand, policyCommand, keysendCommand, ratesCommand, importBackupCommand, addHTLCCommand, clearHTLCCommand, rcAuthCommand, rcRequestCommand, passwdCommand, seedCommand, historyCommand, offCommand, exitCommand}
 		printHelp(listofCommands)
 		fmt.Fprintf(color.Output, "\n\n")
 		fmt.Fprintf(color.Output, lnutil.Header("Coins:\n"))
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"strconv"
+	"strings"
 
 	"github.com/fatih/color"
 	"github.com/mit-dci/lit/litrpc"
@@ -12,8 +13,11 @@ import (
 
 var sendCommand = &Command{
 	Format: fmt.Sprintf(
-		"%s%s\n", lnutil.White("send"), lnutil.ReqColor("address", "amount")),
-	Description:      "Send the given amount of satoshis to the given address.\n",
+		"%s%s%s\n", lnutil.White("send"), lnutil.ReqColor("address", "amount"),
+		lnutil.OptColor("conftarget")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"Send the given amount of satoshis to the given address.",
+		"The fee is estimated to confirm within conftarget blocks (default 6)."),
 	ShortDescription: "Send the given amount of satoshis to the given address.\n",
 }
 
@@ -41,6 +45,40 @@ var sweepCommand = &Command{
 	ShortDescription: "Move UTXOs with many 1-in-1-out txs.\n",
 }
 
+var bumpCommand = &Command{
+	Format: fmt.Sprintf(
+		"%s%s%s\n", lnutil.White("bump"), lnutil.ReqColor("txid"),
+		lnutil.OptColor("feerate", "cpfp")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n",
+		"Speed up an unconfirmed tx of ours by paying more fee.",
+		"Replaces the tx if possible, otherwise spends one of its outputs.",
+		"feerate is in sat/byte (0 or default: estimate); add cpfp to force a child tx."),
+	ShortDescription: "Bump the fee of an unconfirmed tx.\n",
+}
+
+var lockCommand = &Command{
+	Format: fmt.Sprintf("%s%s\n", lnutil.White("lock"), lnutil.ReqColor("outpoint...")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"Keep utxos out of automatic coin selection.",
+		"They're only spent when given as inputs explicitly."),
+	ShortDescription: "Lock utxos so they aren't picked for sends.\n",
+}
+
+var unlockCommand = &Command{
+	Format:           fmt.Sprintf("%s%s\n", lnutil.White("unlock"), lnutil.ReqColor("outpoint...")),
+	Description:      "Let coin selection use locked utxos again.\n",
+	ShortDescription: "Unlock utxos.\n",
+}
+
+var labelCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("label"),
+		lnutil.ReqColor("outpoint|address"), lnutil.OptColor("text")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"Label a utxo or address; labels are shown in ls.",
+		"Without text, removes the label."),
+	ShortDescription: "Label a utxo or address.\n",
+}
+
 // Send sends coins somewhere
 func (lc *litAfClient) Send(textArgs []string) error {
 	stopEx, err := CheckHelpCommand(sendCommand, textArgs, 2)
@@ -68,6 +106,14 @@ func (lc *litAfClient) Send(textArgs []string) error {
 	args.DestAddrs = []string{textArgs[0]}
 	args.Amts = []int64{int64(amt)}
 
+	if len(textArgs) > 2 {
+		target, err := strconv.Atoi(textArgs[2])
+		if err != nil {
+			return err
+		}
+		args.ConfTarget = uint32(target)
+	}
+
 	err = lc.Call("LitRPC.Send", args, reply)
 	if err != nil {
 		return err
@@ -111,6 +157,95 @@ func (lc *litAfClient) Sweep(textArgs []string) error {
 	return nil
 }
 
+// Bump raises the fee of one of our unconfirmed txs
+func (lc *litAfClient) Bump(textArgs []string) error {
+	stopEx, err := CheckHelpCommand(bumpCommand, textArgs, 1)
+	if err != nil || stopEx {
+		return err
+	}
+
+	args := new(litrpc.BumpFeeArgs)
+	reply := new(litrpc.TxidsReply)
+
+	args.Txid = textArgs[0]
+	if len(textArgs) > 1 {
+		rate, err := strconv.Atoi(textArgs[1])
+		if err != nil {
+			return err
+		}
+		args.FeePerByte = int64(rate)
+	}
+	if len(textArgs) > 2 {
+		if textArgs[2] != "cpfp" {
+			return fmt.Errorf("expected cpfp, got %s", textArgs[2])
+		}
+		args.CPFP = true
+	}
+
+	err = lc.Call("LitRPC.BumpFee", args, reply)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(color.Output, "bumped by txid:\n")
+	for i, t := range reply.Txids {
+		fmt.Fprintf(color.Output, "\t%d %s\n", i, t)
+	}
+	return nil
+}
+
+// Lock locks utxos
+func (lc *litAfClient) Lock(textArgs []string) error {
+	stopEx, err := CheckHelpCommand(lockCommand, textArgs, 1)
+	if err != nil || stopEx {
+		return err
+	}
+	return lc.lockUtxos("LitRPC.LockUtxos", textArgs)
+}
+
+// Unlock unlocks utxos
+func (lc *litAfClient) Unlock(textArgs []string) error {
+	stopEx, err := CheckHelpCommand(unlockCommand, textArgs, 1)
+	if err != nil || stopEx {
+		return err
+	}
+	return lc.lockUtxos("LitRPC.UnlockUtxos", textArgs)
+}
+
+func (lc *litAfClient) lockUtxos(method string, textArgs []string) error {
+	args := new(litrpc.OutPointsArgs)
+	reply := new(litrpc.StatusReply)
+
+	args.OutPoints = textArgs
+
+	err := lc.Call(method, args, reply)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(color.Output, "%s\n", reply.Status)
+	return nil
+}
+
+// Label sets or clears the label of a utxo or address
+func (lc *litAfClient) Label(textArgs []string) error {
+	stopEx, err := CheckHelpCommand(labelCommand, textArgs, 1)
+	if err != nil || stopEx {
+		return err
+	}
+
+	args := new(litrpc.SetLabelArgs)
+	reply := new(litrpc.StatusReply)
+
+	args.Target = textArgs[0]
+	args.Label = strings.Join(textArgs[1:], " ")
+
+	err = lc.Call("LitRPC.SetLabel", args, reply)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(color.Output, "%s\n", reply.Status)
+	return nil
+}
+
 //// ------------------------- fanout
 //type FanArgs struct {
 //	DestAdr      string
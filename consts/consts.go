@@ -3,7 +3,7 @@ package consts
 // commonly used constants that can be used anywhere, without ambiguity
 const (
 	ChannelTimeout         = 60               // channel operation timeout in seconds before failing the channel
-	ChannelAdvTimeout      = 60               // max allowed seconds between channel advertisements before deletion
+	ChannelAdvTimeout      = 3600             // default max seconds between a link's adverts before it's pruned from the graph
 	MaxChanCapacity        = int64(100000000) // Maximum Channel Capacity (at 1 coin now)
 	MinChanCapacity        = int64(1000000)   // Minimum Channel Capacity
 	SafeFee                = int64(50000)     // safeFee while initializing a chan
@@ -13,7 +13,7 @@ const (
 	MinOutput              = 100000           // minOutput is the minimum output amt, post fee. This (plus fees) is also the minimum channel balance
 	MinSendAmt             = 10000            // minimum amount that can be sent through a chan
 	MaxTxLen               = 100000           // maximum number of tx's that can be ingested at once
-	JusticeFee             = int64(5000)      // if someone spends the com tx, pay a high justice fee
+	JusticeFee             = int64(5000)      // justice fee for channels made before fee estimation
 	BitcoinRegtestBHeight  = 120              // height at which you want regtest sync to start
 	BitcoinTestnet3BHeight = 1256000          // height at which testnet3 sync starts
 	VertcoinTestnetBHeight = 25000            // height at which vertcoin testnet sync starts
@@ -23,5 +23,36 @@ const (
 	JusticeTxBump          = 100     // fix justicetx fee 10 times the normal fee
 	QcStateFee             = 10      // fixqcstatefee
 	DefaultLockTime        = 500     //default lock time
-	DlcSettlementTxFee     = 1000
+	DlcSettlementTxFee     = 1000    // settlement fee for contracts made before fee estimation
+	DlcSettlementTxSize    = 200     // vbytes, rounded up, of a settlement tx
+	DlcClaimTxSize         = 150     // vbytes, rounded up, of a settlement claim tx
+	DefaultConfTarget      = 6       // blocks to confirm in, when not specified
+	JusticeConfTarget      = 2       // justice txs have to confirm before the timeout
+	DlcSettleConfTarget    = 6       // blocks to confirm a DLC settlement in
+	DlcRefundDelay         = 604800  // seconds after the oracle's publish time a contract can be refunded
+	MaxDlcRefundDelay      = 2592000 // most seconds after the oracle's publish time we take an offer's refund at
+	OraclePollInterval     = 60      // seconds between polls of an oracle for its publication
+	MaxDlcOracles          = 5       // most oracles a contract can settle on
+	MaxDlcOracleDigits     = 32      // most digits an oracle can sign a contract's outcome in
+	MaxDlcDivisions        = 100000  // most divisions a payout curve can give a contract
+	MaxDlcFeePerByte       = 1000    // highest fee rate, in sat/vbyte, a contract's settlement can be at
+	MaxDlcSettlements      = 10000   // most settlement txs, one per outcome and set of oracles, a contract can have
+	BumpConfTarget         = 2       // default target when bumping a stuck tx
+	DefaultInvoiceExpiry   = 3600    // seconds an invoice is good for, when not specified
+	DefaultFeeBase         = 0       // flat fee for forwarding a multihop payment, until set
+	DefaultFeeRate         = 0       // forwarding fee in millionths, until set
+	MaxFeeRate             = 100000  // highest forwarding fee rate, in millionths, a policy can have
+	DefaultCLTVDelta       = 500     // blocks kept between incoming and outgoing HTLCs, until set
+	MinCLTVDelta           = 40      // least CLTV delta a channel can ask for; covers HTLCClaimMargin and HTLCTimeoutGrace
+	MaxPaymentParts        = 8       // most routes a multihop payment is split over
+	MultiPathHoldTime      = 60      // seconds to wait for all the parts of a payment to us
+	MaxPaymentAttempts     = 5       // most routes we try a payment (or a part of one) over
+	PaymentTimeout         = 600     // seconds we wait to hear how a payment went before giving up on it
+	EdgePenaltyTime        = 600     // seconds routing avoids a link for after a payment fails over it
+	HTLCClaimMargin        = 20      // blocks before an incoming HTLC we can claim expires that we go to chain for it
+	HTLCTimeoutGrace       = 6       // blocks past an outgoing HTLC's expiry we give the peer to time it out with us
+	MaxRouteCLTV           = 5000    // most blocks a multihop payment's HTLCs can be locked for
+	RatesFileInterval      = 5       // seconds between looks at rates.json for changes
+	RateFeedInterval       = 60      // seconds between polls of an exchange rate feed, until set
+	PsbtLockTime           = 86400   // seconds a PSBT's inputs stay locked for, unless spent or unlocked first
 )
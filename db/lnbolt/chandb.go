@@ -0,0 +1,197 @@
+package lnbolt
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/lncore"
+)
+
+var (
+	chansLabel        = []byte(`channels`)
+	chansArchiveLabel = []byte(`channelsarchive`)
+	cdbbuckets        = [][]byte{
+		chansLabel,
+		chansArchiveLabel,
+	}
+)
+
+type chanboltdb struct {
+	db *bolt.DB
+}
+
+func (cdb *chanboltdb) init() error {
+	err := cdb.db.Update(func(tx *bolt.Tx) error {
+		for _, n := range cdbbuckets {
+			_, err := tx.CreateBucketIfNotExists(n)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (cdb *chanboltdb) GetChannel(handle lncore.ChannelHandle) (*lncore.ChannelInfo, error) {
+
+	var raw []byte
+	var err error
+
+	// Look in the active channels first, then in the archive.
+	err = cdb.db.View(func(tx *bolt.Tx) error {
+		raw = tx.Bucket(chansLabel).Get(handle[:])
+		if raw == nil {
+			raw = tx.Bucket(chansArchiveLabel).Get(handle[:])
+		}
+
+		// Bolt only guarantees the slice while the tx is open.
+		if raw != nil {
+			raw = append([]byte{}, raw...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if raw == nil {
+		return nil, nil
+	}
+
+	var ci lncore.ChannelInfo
+	err = json.Unmarshal(raw, &ci)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ci, nil
+
+}
+
+func (cdb *chanboltdb) GetChannelHandles() ([]lncore.ChannelHandle, error) {
+	return cdb.getHandles(chansLabel)
+}
+
+func (cdb *chanboltdb) GetArchivedChannelHandles() ([]lncore.ChannelHandle, error) {
+	return cdb.getHandles(chansArchiveLabel)
+}
+
+func (cdb *chanboltdb) getHandles(bucket []byte) ([]lncore.ChannelHandle, error) {
+
+	hs := make([]lncore.ChannelHandle, 0)
+
+	err := cdb.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucket)
+		return b.ForEach(func(k, _ []byte) error {
+			if len(k) != len(lncore.ChannelHandle{}) {
+				return fmt.Errorf("lnbolt/chandb: found key with bad length %d", len(k))
+			}
+
+			var h lncore.ChannelHandle
+			copy(h[:], k)
+			hs = append(hs, h)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return hs, nil
+
+}
+
+func (cdb *chanboltdb) GetChannels() ([]lncore.ChannelInfo, error) {
+
+	raws := make([][]byte, 0)
+
+	err := cdb.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(chansLabel)
+		return b.ForEach(func(_, v []byte) error {
+			raws = append(raws, append([]byte{}, v...))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]lncore.ChannelInfo, len(raws))
+	for i, raw := range raws {
+		err = json.Unmarshal(raw, &out[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return out, nil
+
+}
+
+func (cdb *chanboltdb) AddChannel(handle lncore.ChannelHandle, info lncore.ChannelInfo) error {
+
+	raw, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+
+	return cdb.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(chansLabel)
+
+		if b.Get(handle[:]) != nil || tx.Bucket(chansArchiveLabel).Get(handle[:]) != nil {
+			return fmt.Errorf("channel %x already exists", handle[:])
+		}
+
+		return b.Put(handle[:], raw)
+	})
+
+}
+
+func (cdb *chanboltdb) UpdateChannel(handle lncore.ChannelHandle, info lncore.ChannelInfo) error {
+
+	raw, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+
+	return cdb.db.Update(func(tx *bolt.Tx) error {
+
+		// Archived channels can still get updated (close heights and such), so
+		// write it back wherever it currently lives.
+		for _, n := range cdbbuckets {
+			b := tx.Bucket(n)
+			if b.Get(handle[:]) != nil {
+				return b.Put(handle[:], raw)
+			}
+		}
+
+		return fmt.Errorf("channel %x not found", handle[:])
+	})
+
+}
+
+func (cdb *chanboltdb) ArchiveChannel(handle lncore.ChannelHandle) error {
+
+	return cdb.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(chansLabel)
+
+		raw := b.Get(handle[:])
+		if raw == nil {
+			return fmt.Errorf("channel %x not found", handle[:])
+		}
+		raw = append([]byte{}, raw...)
+
+		err := tx.Bucket(chansArchiveLabel).Put(handle[:], raw)
+		if err != nil {
+			return err
+		}
+
+		return b.Delete(handle[:])
+	})
+
+}
@@ -0,0 +1,95 @@
+package lnbolt
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/mit-dci/lit/lncore"
+)
+
+func TestChannelStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lnbolt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var db LitBoltDB
+	err = db.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	cdb := db.GetChannelDB()
+
+	var h lncore.ChannelHandle
+	h[0] = 0x42
+
+	info := lncore.ChannelInfo{CoinType: 257, State: lncore.CstateInit}
+	info.Commitment.MyAmt = 1000
+	info.Commitment.HTLCs = []lncore.ChannelHTLC{{Idx: 1, Amt: 10}}
+
+	err = cdb.AddChannel(h, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// adding it twice isn't allowed
+	if cdb.AddChannel(h, info) == nil {
+		t.Fatal("added the same channel twice")
+	}
+
+	info.State = lncore.CstateOK
+	info.Commitment.MyAmt = 900
+	err = cdb.UpdateChannel(h, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := cdb.GetChannel(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || got.State != lncore.CstateOK || got.Commitment.MyAmt != 900 ||
+		len(got.Commitment.HTLCs) != 1 || got.Commitment.HTLCs[0].Amt != 10 {
+		t.Fatalf("got wrong channel back: %+v", got)
+	}
+
+	err = cdb.ArchiveChannel(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hs, err := cdb.GetChannelHandles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ahs, err := cdb.GetArchivedChannelHandles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hs) != 0 || len(ahs) != 1 || ahs[0] != h {
+		t.Fatalf("archive didn't move channel: %d active %d archived", len(hs), len(ahs))
+	}
+
+	// archived channels can still be read and updated
+	info.State = lncore.CstateClosed
+	err = cdb.UpdateChannel(h, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = cdb.GetChannel(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || got.State != lncore.CstateClosed {
+		t.Fatalf("archived channel not updated: %+v", got)
+	}
+
+	var missing lncore.ChannelHandle
+	if cdb.UpdateChannel(missing, info) == nil {
+		t.Fatal("updated a channel that doesn't exist")
+	}
+}
@@ -117,7 +117,18 @@ func (db *LitBoltDB) Check() error {
 
 // GetWalletDB .
 func (db *LitBoltDB) GetWalletDB(cointype uint32) lncore.LitWalletStorage {
-	return nil
+	w := walletboltdb{}
+	w.db = db.walletdb
+	w.cointype = cointype
+
+	err := w.init()
+	if err != nil {
+		panic(err)
+	}
+
+	var w2 lncore.LitWalletStorage
+	w2 = &w
+	return w2
 }
 
 // GetPeerDB .
@@ -137,5 +148,15 @@ func (db *LitBoltDB) GetPeerDB() lncore.LitPeerStorage {
 
 // GetChannelDB .
 func (db *LitBoltDB) GetChannelDB() lncore.LitChannelStorage {
-	return nil
+	w := chanboltdb{}
+	w.db = db.chandb
+
+	err := w.init()
+	if err != nil {
+		panic(err)
+	}
+
+	var w2 lncore.LitChannelStorage
+	w2 = &w
+	return w2
 }
@@ -0,0 +1,650 @@
+package lnbolt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/btcutil/chaincfg/chainhash"
+	"github.com/mit-dci/lit/lncore"
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/portxo"
+	"github.com/mit-dci/lit/wire"
+)
+
+/*
+Each coin type gets its own bucket in the wallet db, with these in it:
+
+coin-<cointype>
+|
+|- utxos   : outpoint(36) : rest of serialized portxo
+|- watch   : outpoint(36) : nothing
+|- locked  : outpoint(36) : nothing, or expiry(8)
+|- labels  : outpoint(36) or pkh(20) : label
+|- stxos   : outpoint(36) : rest of serialized stxo
+|- adrs    : pkh(20) : keygen(53)
+|- frozen  : txid(32) : json frozen tx
+|- bumps   : new txid(32) : json replacement
+|- txns    : txid(32) : serialized tx
+|- state   : numkeys, syncheight
+*/
+
+var (
+	wutxosLabel  = []byte(`utxos`)
+	wwatchLabel  = []byte(`watch`)
+	wlockedLabel = []byte(`locked`)
+	wlabelsLabel = []byte(`labels`)
+	wstxosLabel  = []byte(`stxos`)
+	wadrsLabel   = []byte(`adrs`)
+	wfrozenLabel = []byte(`frozen`)
+	wbumpsLabel  = []byte(`bumps`)
+	wtxnsLabel   = []byte(`txns`)
+	wstateLabel  = []byte(`state`)
+	wdbbuckets   = [][]byte{
+		wutxosLabel,
+		wwatchLabel,
+		wlockedLabel,
+		wlabelsLabel,
+		wstxosLabel,
+		wadrsLabel,
+		wfrozenLabel,
+		wbumpsLabel,
+		wtxnsLabel,
+		wstateLabel,
+	}
+
+	wNumKeys    = []byte(`numkeys`)
+	wSyncHeight = []byte(`syncheight`)
+)
+
+type walletboltdb struct {
+	db       *bolt.DB
+	cointype uint32
+
+	// set if we're inside a Batch
+	btx *bolt.Tx
+}
+
+func (wdb *walletboltdb) coinLabel() []byte {
+	return []byte(fmt.Sprintf("coin-%d", wdb.cointype))
+}
+
+func (wdb *walletboltdb) init() error {
+	err := wdb.db.Update(func(tx *bolt.Tx) error {
+		cb, err := tx.CreateBucketIfNotExists(wdb.coinLabel())
+		if err != nil {
+			return err
+		}
+		for _, n := range wdbbuckets {
+			_, err := cb.CreateBucketIfNotExists(n)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// view runs f in a read tx, or in the batch tx if we're in one.
+func (wdb *walletboltdb) view(f func(*bolt.Tx) error) error {
+	if wdb.btx != nil {
+		return f(wdb.btx)
+	}
+	return wdb.db.View(f)
+}
+
+// update runs f in a write tx, or in the batch tx if we're in one.
+func (wdb *walletboltdb) update(f func(*bolt.Tx) error) error {
+	if wdb.btx != nil {
+		return f(wdb.btx)
+	}
+	return wdb.db.Update(f)
+}
+
+func (wdb *walletboltdb) bucket(tx *bolt.Tx, name []byte) *bolt.Bucket {
+	return tx.Bucket(wdb.coinLabel()).Bucket(name)
+}
+
+func (wdb *walletboltdb) GetCoinTypeId() int32 {
+	return int32(wdb.cointype)
+}
+
+func (wdb *walletboltdb) Bytes() []byte {
+	return lnutil.U32tB(wdb.cointype)
+}
+
+func (wdb *walletboltdb) Batch(f func(lncore.LitWalletStorage) error) error {
+	if wdb.btx != nil {
+		// already in a batch, just keep going in this one
+		return f(wdb)
+	}
+	return wdb.db.Update(func(tx *bolt.Tx) error {
+		inner := &walletboltdb{db: wdb.db, cointype: wdb.cointype, btx: tx}
+		return f(inner)
+	})
+}
+
+func (wdb *walletboltdb) GetAddresses() ([]lncore.CoinAddress, error) {
+
+	addrs := make([]lncore.CoinAddress, 0)
+
+	err := wdb.view(func(tx *bolt.Tx) error {
+		return wdb.bucket(tx, wadrsLabel).ForEach(func(k, v []byte) error {
+			a, err := wdb.coinAddressFromKV(k, v)
+			if err != nil {
+				return err
+			}
+			addrs = append(addrs, *a)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return addrs, nil
+
+}
+
+func (wdb *walletboltdb) GetAddress(pkh [20]byte) (*lncore.CoinAddress, error) {
+
+	var a *lncore.CoinAddress
+
+	err := wdb.view(func(tx *bolt.Tx) error {
+		v := wdb.bucket(tx, wadrsLabel).Get(pkh[:])
+		if v == nil {
+			return nil
+		}
+		var err error
+		a, err = wdb.coinAddressFromKV(pkh[:], v)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return a, nil
+
+}
+
+func (wdb *walletboltdb) coinAddressFromKV(k, v []byte) (*lncore.CoinAddress, error) {
+	if len(k) != 20 || len(v) != 53 {
+		return nil, fmt.Errorf("lnbolt/walletdb: bad address entry %x", k)
+	}
+
+	var kgarr [53]byte
+	copy(kgarr[:], v)
+
+	a := &lncore.CoinAddress{
+		CoinType: int32(wdb.cointype),
+		KeyGen:   portxo.KeyGenFromBytes(kgarr),
+	}
+	copy(a.PKH[:], k)
+	return a, nil
+}
+
+func (wdb *walletboltdb) AddAddress(a lncore.CoinAddress) error {
+	return wdb.update(func(tx *bolt.Tx) error {
+		return wdb.bucket(tx, wadrsLabel).Put(a.PKH[:], a.KeyGen.Bytes())
+	})
+}
+
+func (wdb *walletboltdb) GetNumKeys() (uint32, error) {
+	var n uint32
+	err := wdb.view(func(tx *bolt.Tx) error {
+		v := wdb.bucket(tx, wstateLabel).Get(wNumKeys)
+		if v != nil {
+			n = lnutil.BtU32(v)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (wdb *walletboltdb) SetNumKeys(n uint32) error {
+	return wdb.update(func(tx *bolt.Tx) error {
+		return wdb.bucket(tx, wstateLabel).Put(wNumKeys, lnutil.U32tB(n))
+	})
+}
+
+func (wdb *walletboltdb) GetUtxos() ([]lncore.Utxo, error) {
+
+	utxos := make([]lncore.Utxo, 0)
+
+	err := wdb.view(func(tx *bolt.Tx) error {
+		return wdb.bucket(tx, wutxosLabel).ForEach(func(k, v []byte) error {
+			u, err := utxoFromKV(k, v)
+			if err != nil {
+				return err
+			}
+			utxos = append(utxos, *u)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return utxos, nil
+
+}
+
+func (wdb *walletboltdb) GetUtxo(op wire.OutPoint) (*lncore.Utxo, error) {
+
+	var u *lncore.Utxo
+	opArr := lnutil.OutPointToBytes(op)
+
+	err := wdb.view(func(tx *bolt.Tx) error {
+		v := wdb.bucket(tx, wutxosLabel).Get(opArr[:])
+		if v == nil {
+			return nil
+		}
+		var err error
+		u, err = utxoFromKV(opArr[:], v)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return u, nil
+
+}
+
+// utxos are stored like serialized portxos split into k:op, v:the rest
+func utxoFromKV(k, v []byte) (*lncore.Utxo, error) {
+	x := make([]byte, len(k)+len(v))
+	copy(x, k)
+	copy(x[len(k):], v)
+	ptxo, err := portxo.PorTxoFromBytes(x)
+	if err != nil {
+		return nil, err
+	}
+	return &lncore.Utxo{PorTxo: *ptxo}, nil
+}
+
+func (wdb *walletboltdb) AddUtxo(u lncore.Utxo) error {
+	b, err := u.PorTxo.Bytes()
+	if err != nil {
+		return err
+	}
+	return wdb.update(func(tx *bolt.Tx) error {
+		return wdb.bucket(tx, wutxosLabel).Put(b[:36], b[36:])
+	})
+}
+
+func (wdb *walletboltdb) RemoveUtxo(u lncore.Utxo) error {
+	opArr := lnutil.OutPointToBytes(u.Op)
+	return wdb.update(func(tx *bolt.Tx) error {
+		// no point keeping it locked once it's gone
+		err := wdb.bucket(tx, wlockedLabel).Delete(opArr[:])
+		if err != nil {
+			return err
+		}
+		return wdb.bucket(tx, wutxosLabel).Delete(opArr[:])
+	})
+}
+
+func (wdb *walletboltdb) GetWatchedOutPoints() ([]wire.OutPoint, error) {
+
+	ops := make([]wire.OutPoint, 0)
+
+	err := wdb.view(func(tx *bolt.Tx) error {
+		return wdb.bucket(tx, wwatchLabel).ForEach(func(k, _ []byte) error {
+			if len(k) != 36 {
+				return fmt.Errorf("lnbolt/walletdb: bad watched outpoint %x", k)
+			}
+			var opArr [36]byte
+			copy(opArr[:], k)
+			ops = append(ops, *lnutil.OutPointFromBytes(opArr))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ops, nil
+
+}
+
+func (wdb *walletboltdb) IsWatchedOutPoint(op wire.OutPoint) (bool, error) {
+	var watched bool
+	opArr := lnutil.OutPointToBytes(op)
+	err := wdb.view(func(tx *bolt.Tx) error {
+		watched = wdb.bucket(tx, wwatchLabel).Get(opArr[:]) != nil
+		return nil
+	})
+	return watched, err
+}
+
+func (wdb *walletboltdb) AddWatchedOutPoint(op wire.OutPoint) error {
+	opArr := lnutil.OutPointToBytes(op)
+	return wdb.update(func(tx *bolt.Tx) error {
+		return wdb.bucket(tx, wwatchLabel).Put(opArr[:], []byte{})
+	})
+}
+
+func (wdb *walletboltdb) RemoveWatchedOutPoint(op wire.OutPoint) error {
+	opArr := lnutil.OutPointToBytes(op)
+	return wdb.update(func(tx *bolt.Tx) error {
+		return wdb.bucket(tx, wwatchLabel).Delete(opArr[:])
+	})
+}
+
+func (wdb *walletboltdb) GetLockedOutPoints() ([]wire.OutPoint, error) {
+
+	ops := make([]wire.OutPoint, 0)
+
+	err := wdb.view(func(tx *bolt.Tx) error {
+		now := time.Now().Unix()
+		return wdb.bucket(tx, wlockedLabel).ForEach(func(k, v []byte) error {
+			if len(k) != 36 {
+				return fmt.Errorf("lnbolt/walletdb: bad locked outpoint %x", k)
+			}
+			if lockExpired(v, now) {
+				return nil
+			}
+			var opArr [36]byte
+			copy(opArr[:], k)
+			ops = append(ops, *lnutil.OutPointFromBytes(opArr))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ops, nil
+
+}
+
+func (wdb *walletboltdb) IsLockedOutPoint(op wire.OutPoint) (bool, error) {
+	var locked bool
+	opArr := lnutil.OutPointToBytes(op)
+	err := wdb.view(func(tx *bolt.Tx) error {
+		v := wdb.bucket(tx, wlockedLabel).Get(opArr[:])
+		locked = v != nil && !lockExpired(v, time.Now().Unix())
+		return nil
+	})
+	return locked, err
+}
+
+func (wdb *walletboltdb) AddLockedOutPoint(op wire.OutPoint) error {
+	opArr := lnutil.OutPointToBytes(op)
+	return wdb.update(func(tx *bolt.Tx) error {
+		return wdb.bucket(tx, wlockedLabel).Put(opArr[:], []byte{})
+	})
+}
+
+func (wdb *walletboltdb) AddLockedOutPointUntil(op wire.OutPoint, expiry int64) error {
+	opArr := lnutil.OutPointToBytes(op)
+	var v [8]byte
+	binary.BigEndian.PutUint64(v[:], uint64(expiry))
+	return wdb.update(func(tx *bolt.Tx) error {
+		return wdb.bucket(tx, wlockedLabel).Put(opArr[:], v[:])
+	})
+}
+
+// lockExpired says if a lock with value v has lapsed by now.  Locks without
+// an expiry never do.
+func lockExpired(v []byte, now int64) bool {
+	return len(v) == 8 && int64(binary.BigEndian.Uint64(v)) <= now
+}
+
+func (wdb *walletboltdb) RemoveLockedOutPoint(op wire.OutPoint) error {
+	opArr := lnutil.OutPointToBytes(op)
+	return wdb.update(func(tx *bolt.Tx) error {
+		return wdb.bucket(tx, wlockedLabel).Delete(opArr[:])
+	})
+}
+
+// outpoints and pkhs share the labels bucket; their keys differ in length
+func (wdb *walletboltdb) getLabel(k []byte) (string, error) {
+	var label string
+	err := wdb.view(func(tx *bolt.Tx) error {
+		label = string(wdb.bucket(tx, wlabelsLabel).Get(k))
+		return nil
+	})
+	return label, err
+}
+
+func (wdb *walletboltdb) setLabel(k []byte, label string) error {
+	return wdb.update(func(tx *bolt.Tx) error {
+		if label == "" {
+			return wdb.bucket(tx, wlabelsLabel).Delete(k)
+		}
+		return wdb.bucket(tx, wlabelsLabel).Put(k, []byte(label))
+	})
+}
+
+func (wdb *walletboltdb) GetOutPointLabel(op wire.OutPoint) (string, error) {
+	opArr := lnutil.OutPointToBytes(op)
+	return wdb.getLabel(opArr[:])
+}
+
+func (wdb *walletboltdb) SetOutPointLabel(op wire.OutPoint, label string) error {
+	opArr := lnutil.OutPointToBytes(op)
+	return wdb.setLabel(opArr[:], label)
+}
+
+func (wdb *walletboltdb) GetAddressLabel(pkh [20]byte) (string, error) {
+	return wdb.getLabel(pkh[:])
+}
+
+func (wdb *walletboltdb) SetAddressLabel(pkh [20]byte, label string) error {
+	return wdb.setLabel(pkh[:], label)
+}
+
+func (wdb *walletboltdb) GetStxos() ([]lncore.Stxo, error) {
+
+	stxos := make([]lncore.Stxo, 0)
+
+	err := wdb.view(func(tx *bolt.Tx) error {
+		return wdb.bucket(tx, wstxosLabel).ForEach(func(k, v []byte) error {
+			st, err := lncore.StxoFromBytes(append(append([]byte{}, k...), v...))
+			if err != nil {
+				return err
+			}
+			stxos = append(stxos, st)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return stxos, nil
+
+}
+
+func (wdb *walletboltdb) GetStxo(op wire.OutPoint) (*lncore.Stxo, error) {
+
+	var st *lncore.Stxo
+	opArr := lnutil.OutPointToBytes(op)
+
+	err := wdb.view(func(tx *bolt.Tx) error {
+		v := wdb.bucket(tx, wstxosLabel).Get(opArr[:])
+		if v == nil {
+			return nil
+		}
+		s, err := lncore.StxoFromBytes(append(opArr[:], v...))
+		if err != nil {
+			return err
+		}
+		st = &s
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return st, nil
+
+}
+
+func (wdb *walletboltdb) AddStxo(st lncore.Stxo) error {
+	b, err := st.ToBytes()
+	if err != nil {
+		return err
+	}
+	// stxos are saved in the DB like portxos, with k:op, v:the rest
+	return wdb.update(func(tx *bolt.Tx) error {
+		return wdb.bucket(tx, wstxosLabel).Put(b[:36], b[36:])
+	})
+}
+
+func (wdb *walletboltdb) GetFrozenTxs() ([]lncore.FrozenTx, error) {
+
+	raws := make([][]byte, 0)
+
+	err := wdb.view(func(tx *bolt.Tx) error {
+		return wdb.bucket(tx, wfrozenLabel).ForEach(func(_, v []byte) error {
+			raws = append(raws, append([]byte{}, v...))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]lncore.FrozenTx, len(raws))
+	for i, raw := range raws {
+		err = json.Unmarshal(raw, &out[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return out, nil
+
+}
+
+func (wdb *walletboltdb) AddFrozenTx(ftx lncore.FrozenTx) error {
+	raw, err := json.Marshal(ftx)
+	if err != nil {
+		return err
+	}
+	return wdb.update(func(tx *bolt.Tx) error {
+		return wdb.bucket(tx, wfrozenLabel).Put(ftx.Txid[:], raw)
+	})
+}
+
+func (wdb *walletboltdb) RemoveFrozenTx(txid chainhash.Hash) error {
+	return wdb.update(func(tx *bolt.Tx) error {
+		return wdb.bucket(tx, wfrozenLabel).Delete(txid[:])
+	})
+}
+
+func (wdb *walletboltdb) GetReplacements() ([]lncore.Replacement, error) {
+
+	reps := make([]lncore.Replacement, 0)
+
+	err := wdb.view(func(tx *bolt.Tx) error {
+		return wdb.bucket(tx, wbumpsLabel).ForEach(func(_, v []byte) error {
+			var r lncore.Replacement
+			err := json.Unmarshal(v, &r)
+			if err != nil {
+				return err
+			}
+			reps = append(reps, r)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return reps, nil
+
+}
+
+func (wdb *walletboltdb) AddReplacement(r lncore.Replacement) error {
+	raw, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return wdb.update(func(tx *bolt.Tx) error {
+		return wdb.bucket(tx, wbumpsLabel).Put(r.New[:], raw)
+	})
+}
+
+func (wdb *walletboltdb) RemoveReplacement(newTxid chainhash.Hash) error {
+	return wdb.update(func(tx *bolt.Tx) error {
+		return wdb.bucket(tx, wbumpsLabel).Delete(newTxid[:])
+	})
+}
+
+func (wdb *walletboltdb) GetTx(txid chainhash.Hash) (*wire.MsgTx, error) {
+
+	var raw []byte
+
+	err := wdb.view(func(tx *bolt.Tx) error {
+		v := wdb.bucket(tx, wtxnsLabel).Get(txid[:])
+		if v != nil {
+			raw = append([]byte{}, v...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if raw == nil {
+		return nil, nil
+	}
+
+	mtx := wire.NewMsgTx()
+	err = mtx.Deserialize(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+
+	return mtx, nil
+
+}
+
+func (wdb *walletboltdb) AddTx(mtx *wire.MsgTx) error {
+	var buf bytes.Buffer
+	err := mtx.Serialize(&buf) // always store witness version
+	if err != nil {
+		return err
+	}
+	txid := mtx.TxHash()
+	return wdb.update(func(tx *bolt.Tx) error {
+		return wdb.bucket(tx, wtxnsLabel).Put(txid[:], buf.Bytes())
+	})
+}
+
+func (wdb *walletboltdb) GetSyncHeight() (int32, error) {
+	var n int32
+	err := wdb.view(func(tx *bolt.Tx) error {
+		v := wdb.bucket(tx, wstateLabel).Get(wSyncHeight)
+		if v == nil { // no height written, so 0
+			return nil
+		}
+		return binary.Read(bytes.NewBuffer(v), binary.BigEndian, &n)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (wdb *walletboltdb) SetSyncHeight(n int32) error {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, n)
+	return wdb.update(func(tx *bolt.Tx) error {
+		return wdb.bucket(tx, wstateLabel).Put(wSyncHeight, buf.Bytes())
+	})
+}
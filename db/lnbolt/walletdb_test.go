@@ -0,0 +1,281 @@
+package lnbolt
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mit-dci/lit/lncore"
+	"github.com/mit-dci/lit/portxo"
+	"github.com/mit-dci/lit/wire"
+)
+
+func TestWalletStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lnbolt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var db LitBoltDB
+	err = db.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	wdb := db.GetWalletDB(257)
+	other := db.GetWalletDB(1)
+
+	var u lncore.Utxo
+	u.Op.Hash[0] = 0xaa
+	u.Op.Index = 1
+	u.Value = 50000
+	u.Height = 100
+	u.Mode = portxo.TxoP2WPKHComp
+	u.KeyGen.Depth = 5
+	u.KeyGen.Step[0] = 44 | 1<<31
+
+	err = wdb.AddUtxo(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := wdb.GetUtxo(u.Op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || !got.Equal(&u.PorTxo) {
+		t.Fatalf("utxo mismatch: %v", got)
+	}
+
+	// coin types don't see each other's stuff
+	us, err := other.GetUtxos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(us) != 0 {
+		t.Fatalf("other coin has %d utxos", len(us))
+	}
+
+	// a failed batch shouldn't leave anything behind
+	err = wdb.Batch(func(b lncore.LitWalletStorage) error {
+		err := b.RemoveUtxo(u)
+		if err != nil {
+			return err
+		}
+		err = b.SetSyncHeight(500)
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("nope")
+	})
+	if err == nil {
+		t.Fatal("batch should have failed")
+	}
+	got, err = wdb.GetUtxo(u.Op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := wdb.GetSyncHeight()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || h != 0 {
+		t.Fatalf("failed batch wrote stuff: utxo %v height %d", got, h)
+	}
+
+	// spend it for real
+	err = wdb.Batch(func(b lncore.LitWalletStorage) error {
+		err := b.AddStxo(lncore.Stxo{PorTxo: u.PorTxo, SpendHeight: 101})
+		if err != nil {
+			return err
+		}
+		return b.RemoveUtxo(u)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := wdb.GetStxo(u.Op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st == nil || st.SpendHeight != 101 || st.PorTxo.Value != u.Value {
+		t.Fatalf("stxo mismatch: %v", st)
+	}
+	got, err = wdb.GetUtxo(u.Op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != nil {
+		t.Fatal("spent utxo still there")
+	}
+}
+
+func TestWalletReplacements(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lnbolt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var db LitBoltDB
+	err = db.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	wdb := db.GetWalletDB(257)
+
+	var r lncore.Replacement
+	r.Orig[0] = 0x01
+	r.New[0] = 0x02
+	r.CPFP = true
+	r.FeePerByte = 40
+
+	err = wdb.AddReplacement(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reps, err := wdb.GetReplacements()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reps) != 1 || reps[0] != r {
+		t.Fatalf("replacements mismatch: %v", reps)
+	}
+
+	err = wdb.RemoveReplacement(r.New)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reps, err = wdb.GetReplacements()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reps) != 0 {
+		t.Fatalf("%d replacements left after remove", len(reps))
+	}
+}
+
+func TestWalletLocksAndLabels(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lnbolt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var db LitBoltDB
+	err = db.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	wdb := db.GetWalletDB(257)
+
+	var op wire.OutPoint
+	op.Hash[0] = 0xbb
+	op.Index = 2
+
+	err = wdb.AddLockedOutPoint(op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	locked, err := wdb.IsLockedOutPoint(op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops, err := wdb.GetLockedOutPoints()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !locked || len(ops) != 1 || ops[0] != op {
+		t.Fatalf("locked %t, %v", locked, ops)
+	}
+	err = wdb.RemoveLockedOutPoint(op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	locked, err = wdb.IsLockedOutPoint(op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if locked {
+		t.Fatalf("still locked after remove")
+	}
+
+	// expired locks don't count
+	err = wdb.AddLockedOutPointUntil(op, time.Now().Unix()-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	locked, err = wdb.IsLockedOutPoint(op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops, err = wdb.GetLockedOutPoints()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if locked || len(ops) != 0 {
+		t.Fatalf("expired lock: locked %t, %v", locked, ops)
+	}
+
+	// spending a utxo unlocks it
+	err = wdb.AddLockedOutPointUntil(op, time.Now().Unix()+3600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var u lncore.Utxo
+	u.Op = op
+	err = wdb.RemoveUtxo(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	locked, err = wdb.IsLockedOutPoint(op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if locked {
+		t.Fatalf("still locked after utxo removed")
+	}
+
+	var pkh [20]byte
+	pkh[0] = 0xbb
+	err = wdb.SetOutPointLabel(op, "treasury")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = wdb.SetAddressLabel(pkh, "cold")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opLabel, err := wdb.GetOutPointLabel(op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adrLabel, err := wdb.GetAddressLabel(pkh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opLabel != "treasury" || adrLabel != "cold" {
+		t.Fatalf("labels %q %q", opLabel, adrLabel)
+	}
+
+	err = wdb.SetOutPointLabel(op, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opLabel, err = wdb.GetOutPointLabel(op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opLabel != "" {
+		t.Fatalf("label %q left after clearing", opLabel)
+	}
+}
@@ -3,6 +3,7 @@ package dlc
 import (
 	"fmt"
 
+	"github.com/mit-dci/lit/consts"
 	"github.com/mit-dci/lit/lnutil"
 )
 
@@ -47,6 +48,7 @@ func (mgr *DlcManager) SetContractOracle(cIdx, oIdx uint64) error {
 
 	// Reset the R point when changing the oracle
 	c.OracleR = [33]byte{}
+	c.OracleDigitR = nil
 
 	mgr.SaveContract(c)
 
@@ -68,8 +70,8 @@ func (mgr *DlcManager) SetContractSettlementTime(cIdx, time uint64) error {
 
 	c.OracleTimestamp = time
 
-	// Reset the R point
-	c.OracleR = [33]byte{}
+	// Reset the R points
+	resetRPoints(c)
 
 	mgr.SaveContract(c)
 
@@ -100,10 +102,11 @@ func (mgr *DlcManager) SetContractDatafeed(cIdx, feed uint64) error {
 		return err
 	}
 
-	c.OracleR, err = o.FetchRPoint(feed, c.OracleTimestamp)
+	co, err := contractOracle(c, o, feed, nil)
 	if err != nil {
 		return err
 	}
+	c.OracleR, c.OracleDigitR = co.R, co.DigitR
 
 	err = mgr.SaveContract(c)
 	if err != nil {
@@ -135,6 +138,213 @@ func (mgr *DlcManager) SetContractRPoint(cIdx uint64, rPoint [33]byte) error {
 	return nil
 }
 
+// SetContractRPoints allows you to manually set the R-point, or those of each
+// digit for a contract on digits, if an oracle is not imported from a REST API
+func (mgr *DlcManager) SetContractRPoints(cIdx uint64,
+	rPoints [][33]byte) error {
+
+	c, err := mgr.LoadContract(cIdx)
+	if err != nil {
+		return err
+	}
+
+	if c.Status != lnutil.ContractStatusDraft {
+		return fmt.Errorf("You cannot change or set the R-point unless the" +
+			" contract is in Draft state")
+	}
+
+	if len(rPoints) == 0 {
+		return fmt.Errorf("No R-points given")
+	}
+
+	co, err := contractOracle(c, &DlcOracle{A: c.OracleA}, 0, rPoints)
+	if err != nil {
+		return err
+	}
+	c.OracleR, c.OracleDigitR = co.R, co.DigitR
+
+	return mgr.SaveContract(c)
+}
+
+// SetContractDigits sets a contract to settle on a value the oracles sign
+// digit by digit, digits of them in base, so that there's a settlement for
+// each range of values paying the same rather than each value.  0 digits
+// sets it back to a value signed whole.  As the R-points and the division
+// differ, they need to be set again.
+func (mgr *DlcManager) SetContractDigits(cIdx uint64, base,
+	digits uint32) error {
+
+	c, err := mgr.LoadContract(cIdx)
+	if err != nil {
+		return err
+	}
+
+	if c.Status != lnutil.ContractStatusDraft {
+		return fmt.Errorf("You cannot change or set the digits unless the" +
+			" contract is in Draft state")
+	}
+
+	if digits > consts.MaxDlcOracleDigits {
+		return fmt.Errorf("A contract can't settle on more than %d digits",
+			consts.MaxDlcOracleDigits)
+	}
+
+	c.OracleDigits = digits
+	c.OracleBase = base
+	if digits == 0 {
+		c.OracleBase = 0
+	} else if c.MaxOracleValue() < 0 {
+		return fmt.Errorf("%d digits in base %d don't fit in 64 bits",
+			digits, base)
+	}
+
+	resetRPoints(c)
+	c.Division = nil
+
+	return mgr.SaveContract(c)
+}
+
+// resetRPoints clears the R-points of each of a contract's oracles, for when
+// what they'd sign changes.
+func resetRPoints(c *lnutil.DlcContract) {
+	c.OracleR = [33]byte{}
+	c.OracleDigitR = nil
+	for i := range c.Oracles {
+		c.Oracles[i].R = [33]byte{}
+		c.Oracles[i].DigitR = nil
+	}
+}
+
+// contractOracle gives oracle o as one of contract c's, with the R-point it
+// signs with, or for contracts on digits, the R-points of each digit.  They're
+// rPoints if given, or fetched for data feed feed from the oracle's REST API,
+// which needs the settlement time set first.
+func contractOracle(c *lnutil.DlcContract, o *DlcOracle, feed uint64,
+	rPoints [][33]byte) (lnutil.DlcContractOracle, error) {
+
+	var err error
+	co := lnutil.DlcContractOracle{A: o.A}
+
+	if len(rPoints) == 0 {
+		if c.OracleTimestamp == 0 {
+			return co, fmt.Errorf("You need to set the settlement timestamp" +
+				" first, otherwise no R point can be retrieved for the feed")
+		}
+		if c.OracleDigits > 0 {
+			co.DigitR, err = o.FetchDigitRPoints(feed, c.OracleTimestamp,
+				c.OracleDigits)
+		} else {
+			co.R, err = o.FetchRPoint(feed, c.OracleTimestamp)
+		}
+		return co, err
+	}
+
+	if c.OracleDigits > 0 {
+		if len(rPoints) != int(c.OracleDigits) {
+			return co, fmt.Errorf("The contract is on %d digits, got %d"+
+				" R-points", c.OracleDigits, len(rPoints))
+		}
+		co.DigitR = rPoints
+		return co, nil
+	}
+
+	if len(rPoints) != 1 {
+		return co, fmt.Errorf("The contract is on a whole value, got %d"+
+			" R-points", len(rPoints))
+	}
+	co.R = rPoints[0]
+	return co, nil
+}
+
+// AddContractOracle adds another oracle for a contract to settle on, for
+// contracts settled by several oracles signing the same outcome.  Its R-point
+// (or those of each digit) is rPoints, or if that's not given, it's fetched
+// for data feed feed from the oracle's REST API, which needs the settlement
+// time set first.
+func (mgr *DlcManager) AddContractOracle(cIdx, oIdx, feed uint64,
+	rPoints [][33]byte) error {
+
+	c, err := mgr.LoadContract(cIdx)
+	if err != nil {
+		return err
+	}
+
+	if c.Status != lnutil.ContractStatusDraft {
+		return fmt.Errorf("You cannot add oracles unless the contract is" +
+			" in Draft state")
+	}
+
+	if len(c.Oracles)+1 >= consts.MaxDlcOracles {
+		return fmt.Errorf("A contract can't settle on more than %d oracles",
+			consts.MaxDlcOracles)
+	}
+
+	o, err := mgr.LoadOracle(oIdx)
+	if err != nil {
+		return err
+	}
+
+	for _, co := range c.AllOracles() {
+		if co.A == o.A {
+			return fmt.Errorf("The contract already settles on oracle %s",
+				o.Name)
+		}
+	}
+
+	co, err := contractOracle(c, o, feed, rPoints)
+	if err != nil {
+		return err
+	}
+
+	c.Oracles = append(c.Oracles, co)
+
+	return mgr.SaveContract(c)
+}
+
+// ClearContractOracles takes off the oracles added with AddContractOracle,
+// leaving the contract to settle on just the one set with SetContractOracle.
+func (mgr *DlcManager) ClearContractOracles(cIdx uint64) error {
+	c, err := mgr.LoadContract(cIdx)
+	if err != nil {
+		return err
+	}
+
+	if c.Status != lnutil.ContractStatusDraft {
+		return fmt.Errorf("You cannot remove oracles unless the contract is" +
+			" in Draft state")
+	}
+
+	c.Oracles = nil
+	c.OracleThreshold = 0
+
+	return mgr.SaveContract(c)
+}
+
+// SetContractOracleThreshold sets how many of a contract's oracles have to
+// sign the same outcome for it to settle on it.
+func (mgr *DlcManager) SetContractOracleThreshold(cIdx uint64,
+	threshold uint32) error {
+
+	c, err := mgr.LoadContract(cIdx)
+	if err != nil {
+		return err
+	}
+
+	if c.Status != lnutil.ContractStatusDraft {
+		return fmt.Errorf("You cannot change or set the threshold unless" +
+			" the contract is in Draft state")
+	}
+
+	if threshold < 1 || int(threshold) > len(c.Oracles)+1 {
+		return fmt.Errorf("Threshold has to be from 1 to the %d oracles of"+
+			" the contract", len(c.Oracles)+1)
+	}
+
+	c.OracleThreshold = threshold
+
+	return mgr.SaveContract(c)
+}
+
 // SetContractFunding sets the funding to the contract. It will specify how much
 // we (the offering party) are funding, as well as
 func (mgr *DlcManager) SetContractFunding(cIdx uint64, our, their int64) error {
@@ -221,6 +431,17 @@ func (mgr *DlcManager) SetContractDivision(cIdx uint64,
 		}
 
 	}
+
+	// contracts on digits pay in steps, so each run paying the same is one
+	if c.OracleDigits > 0 && len(c.Division) > 0 {
+		steps := c.Division[:1]
+		for _, d := range c.Division[1:] {
+			if d.ValueOurs != steps[len(steps)-1].ValueOurs {
+				steps = append(steps, d)
+			}
+		}
+		c.Division = steps
+	}
 	mgr.SaveContract(c)
 
 	return nil
@@ -11,8 +11,9 @@ import (
 
 // const strings for db usage
 var (
-	BKTOracles   = []byte("Oracles")
-	BKTContracts = []byte("Contracts")
+	BKTOracles      = []byte("Oracles")
+	BKTContracts    = []byte("Contracts")
+	BKTFundingPsbts = []byte("FundingPsbts")
 )
 
 // InitDB initializes the database for Discreet Log Contract storage
@@ -31,6 +32,10 @@ func (mgr *DlcManager) InitDB(dbPath string) error {
 			return err
 		}
 		_, err = tx.CreateBucketIfNotExists(BKTContracts)
+		if err != nil {
+			return err
+		}
+		_, err = tx.CreateBucketIfNotExists(BKTFundingPsbts)
 		return err
 	})
 
@@ -204,3 +209,49 @@ func (mgr *DlcManager) ListContracts() ([]*lnutil.DlcContract, error) {
 
 	return contracts, nil
 }
+
+// SaveFundingPsbt saves the PSBT for a contract whose funding is signed
+// outside lit.  It's kept apart from the contract since it never goes to
+// the peer.
+func (mgr *DlcManager) SaveFundingPsbt(idx uint64, p []byte) error {
+	return mgr.DLCDB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(BKTFundingPsbts)
+
+		var wb bytes.Buffer
+		binary.Write(&wb, binary.BigEndian, idx)
+		return b.Put(wb.Bytes(), p)
+	})
+}
+
+// LoadFundingPsbt loads the funding PSBT for a contract, or nil if it's
+// funded from the wallet.
+func (mgr *DlcManager) LoadFundingPsbt(idx uint64) ([]byte, error) {
+	var p []byte
+	err := mgr.DLCDB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(BKTFundingPsbts)
+
+		var wb bytes.Buffer
+		binary.Write(&wb, binary.BigEndian, idx)
+
+		v := b.Get(wb.Bytes())
+		if v != nil {
+			p = append([]byte{}, v...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// DeleteFundingPsbt removes the funding PSBT for a contract, once it's signed.
+func (mgr *DlcManager) DeleteFundingPsbt(idx uint64) error {
+	return mgr.DLCDB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(BKTFundingPsbts)
+
+		var wb bytes.Buffer
+		binary.Write(&wb, binary.BigEndian, idx)
+		return b.Delete(wb.Bytes())
+	})
+}
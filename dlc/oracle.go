@@ -7,8 +7,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"time"
 )
 
+// oracleClient is what oracles' REST APIs are fetched with.  An oracle which
+// doesn't answer can't hang us.
+var oracleClient = &http.Client{Timeout: 30 * time.Second}
+
 // DlcOracle contains the identifying data of an Oracle
 type DlcOracle struct {
 	Idx  uint64   // Index of the oracle for refencing in commands
@@ -63,8 +68,7 @@ func (mgr *DlcManager) ImportOracle(url string, name string) (*DlcOracle, error)
 	if err != nil {
 		return nil, err
 	}
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := oracleClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -115,8 +119,7 @@ func (o *DlcOracle) FetchRPoint(datafeedId, timestamp uint64) ([33]byte, error)
 	if err != nil {
 		return rPoint, err
 	}
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := oracleClient.Do(req)
 	if err != nil {
 		return rPoint, err
 	}
@@ -132,12 +135,123 @@ func (o *DlcOracle) FetchRPoint(datafeedId, timestamp uint64) ([33]byte, error)
 	if err != nil {
 		return rPoint, err
 	}
+	if len(R) != 33 {
+		return rPoint, fmt.Errorf("R point is %d bytes, not 33", len(R))
+	}
 
 	copy(rPoint[:], R[:])
 	return rPoint, nil
 
 }
 
+// DlcOracleRPointsResponse is the response format for the REST API that
+// returns the R-points of each digit of a value
+type DlcOracleRPointsResponse struct {
+	RHex []string `json:"R"`
+}
+
+// FetchDigitRPoints retrieves the R-points the oracle signs each of digits
+// digits of the value of datafeedId at timestamp (unix epoch) with, most
+// significant first, from the REST API of the oracle.
+func (o *DlcOracle) FetchDigitRPoints(datafeedId, timestamp uint64,
+	digits uint32) ([][33]byte, error) {
+
+	if len(o.Url) == 0 {
+		return nil, fmt.Errorf("Oracle was not imported from the web -" +
+			" cannot fetch R points. Enter manually using the" +
+			" [dlc contract setrpoint] command")
+	}
+
+	url := fmt.Sprintf("%s/api/rpoints/%d/%d/%d", o.Url, datafeedId,
+		timestamp, digits)
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := oracleClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var response DlcOracleRPointsResponse
+
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, err
+	}
+
+	if len(response.RHex) != int(digits) {
+		return nil, fmt.Errorf("got %d R points for %d digits",
+			len(response.RHex), digits)
+	}
+	rPoints := make([][33]byte, digits)
+	for i, rHex := range response.RHex {
+		R, err := hex.DecodeString(rHex)
+		if err != nil {
+			return nil, err
+		}
+		if len(R) != 33 {
+			return nil, fmt.Errorf("R point of digit %d is %d bytes, not 33",
+				i, len(R))
+		}
+		copy(rPoints[i][:], R)
+	}
+	return rPoints, nil
+}
+
+// DlcOracleRestPublicationResponse is the response format for the REST API
+// that returns the value an oracle published, and its signature
+type DlcOracleRestPublicationResponse struct {
+	Value  int64  `json:"value"`
+	SigHex string `json:"signature"`
+}
+
+// FetchPublication retrieves the value the oracle published using R-point
+// rPoint, and its signature on it, from the REST API of the oracle.  It's an
+// error until the oracle publishes.
+func (o *DlcOracle) FetchPublication(rPoint [33]byte) (int64, [32]byte, error) {
+	var sig [32]byte
+	if len(o.Url) == 0 {
+		return 0, sig, fmt.Errorf("Oracle was not imported from the web -" +
+			" cannot fetch its publication. Settle manually using the" +
+			" [dlc contract settle] command")
+	}
+
+	url := fmt.Sprintf("%s/api/publication/%x", o.Url, rPoint)
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return 0, sig, err
+	}
+	resp, err := oracleClient.Do(req)
+	if err != nil {
+		return 0, sig, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, sig, fmt.Errorf("no publication for R-point %x: %s",
+			rPoint, resp.Status)
+	}
+
+	var response DlcOracleRestPublicationResponse
+
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return 0, sig, err
+	}
+
+	s, err := hex.DecodeString(response.SigHex)
+	if err != nil {
+		return 0, sig, err
+	}
+	if len(s) != 32 {
+		return 0, sig, fmt.Errorf("got %d byte signature, expect 32", len(s))
+	}
+
+	copy(sig[:], s)
+	return response.Value, sig, nil
+}
+
 // DlcOracleFromBytes parses a byte array that was serialized using
 // DlcOracle.Bytes() back into a DlcOracle struct
 func DlcOracleFromBytes(b []byte) (*DlcOracle, error) {
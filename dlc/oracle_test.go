@@ -0,0 +1,42 @@
+package dlc
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFetchDigitRPoints(t *testing.T) {
+	good := strings.Repeat("02", 33)
+	var rHex []string
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, `{"R": ["%s"]}`, strings.Join(rHex, `", "`))
+		}))
+	defer srv.Close()
+	o := &DlcOracle{Url: srv.URL}
+
+	rHex = []string{good, good}
+	rPoints, err := o.FetchDigitRPoints(1, 1500000000, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rPoints) != 2 || rPoints[1][32] != 2 {
+		t.Fatalf("got R points %x", rPoints)
+	}
+
+	// too few, or ones too short or long to be points
+	for _, bad := range [][]string{
+		{good},
+		{good, good[:64]},
+		{good, good + "02"},
+	} {
+		rHex = bad
+		_, err = o.FetchDigitRPoints(1, 1500000000, 2)
+		if err == nil {
+			t.Fatalf("R points %v taken", bad)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package dlc
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/mit-dci/lit/consts"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+// Types of the pieces of a payout curve
+const (
+	PayoutLinear     = "linear"
+	PayoutPolynomial = "polynomial"
+	PayoutStep       = "step"
+)
+
+// PayoutCurve describes what we receive for each value the oracle can
+// publish, as pieces each covering a range of values.  Payouts are in
+// satoshis, and capped at what the contract is funded with.
+type PayoutCurve struct {
+	Pieces []PayoutPiece `json:"pieces"`
+	// Payouts are rounded to cut the number of distinct outcomes, which
+	// contracts on digits have a settlement for each of
+	Rounding []RoundingInterval `json:"rounding,omitempty"`
+}
+
+// PayoutPiece is a piece of a payout curve, covering the values From to To.
+// Linear pieces pay FromPayout at From to ToPayout at To, and in between on
+// the line through them.  Polynomial pieces pay the polynomial with
+// Coefficients, lowest order first, of the value.  Step pieces pay Payout
+// all the way.
+type PayoutPiece struct {
+	Type         string    `json:"type"`
+	From         int64     `json:"from"`
+	To           int64     `json:"to"`
+	FromPayout   int64     `json:"fromPayout,omitempty"`
+	ToPayout     int64     `json:"toPayout,omitempty"`
+	Coefficients []float64 `json:"coefficients,omitempty"`
+	Payout       int64     `json:"payout,omitempty"`
+}
+
+// RoundingInterval rounds the payouts for values from From on to the nearest
+// multiple of Mod, until the next interval.
+type RoundingInterval struct {
+	From int64 `json:"from"`
+	Mod  int64 `json:"mod"`
+}
+
+// check says what's wrong with a payout curve, if anything.  The pieces have
+// to follow on from each other.
+func (pc PayoutCurve) check() error {
+	if len(pc.Pieces) == 0 {
+		return fmt.Errorf("payout curve has no pieces")
+	}
+	for i, p := range pc.Pieces {
+		if p.To < p.From || p.From < 0 {
+			return fmt.Errorf("piece %d is from %d to %d", i, p.From, p.To)
+		}
+		if i > 0 && p.From != pc.Pieces[i-1].To+1 {
+			return fmt.Errorf("piece %d starts at %d, not after piece %d ends"+
+				" at %d", i, p.From, i-1, pc.Pieces[i-1].To)
+		}
+		switch p.Type {
+		case PayoutLinear, PayoutStep:
+		case PayoutPolynomial:
+			if len(p.Coefficients) == 0 {
+				return fmt.Errorf("piece %d has no coefficients", i)
+			}
+			// worked out at every value, so can't be too wide
+			if p.To-p.From >= consts.MaxDlcDivisions {
+				return fmt.Errorf("polynomial piece %d spans %d values, can't"+
+					" be more than %d", i, p.To-p.From+1, consts.MaxDlcDivisions)
+			}
+		default:
+			return fmt.Errorf("piece %d is of unknown type %s", i, p.Type)
+		}
+	}
+	for i, r := range pc.Rounding {
+		if r.Mod < 1 {
+			return fmt.Errorf("rounding interval %d is to multiples of %d",
+				i, r.Mod)
+		}
+		if i > 0 && r.From <= pc.Rounding[i-1].From {
+			return fmt.Errorf("rounding interval %d doesn't start after"+
+				" interval %d", i, i-1)
+		}
+	}
+	return nil
+}
+
+// payout gives what piece p pays for value.
+func (p PayoutPiece) payout(value int64) float64 {
+	switch p.Type {
+	case PayoutLinear:
+		if p.To == p.From {
+			return float64(p.FromPayout)
+		}
+		return float64(p.FromPayout) + float64(p.ToPayout-p.FromPayout)*
+			float64(value-p.From)/float64(p.To-p.From)
+	case PayoutPolynomial:
+		var y float64
+		for i := len(p.Coefficients) - 1; i >= 0; i-- {
+			y = y*float64(value) + p.Coefficients[i]
+		}
+		return y
+	}
+	return float64(p.Payout)
+}
+
+// rounding gives the multiple payouts for value are rounded to, and the
+// value the next rounding interval starts at.
+func (pc PayoutCurve) rounding(value int64) (int64, int64) {
+	mod := int64(1)
+	for _, r := range pc.Rounding {
+		if value < r.From {
+			return mod, r.From
+		}
+		mod = r.Mod
+	}
+	return mod, math.MaxInt64
+}
+
+// round rounds payout y for value to the rounding interval it's in, and
+// between 0 and total.
+func (pc PayoutCurve) round(value int64, y float64, total int64) (int64, error) {
+	if math.IsNaN(y) || math.IsInf(y, 0) {
+		return 0, fmt.Errorf("payout at %d is %v", value, y)
+	}
+	// capped before rounding, so far off payouts don't overflow
+	y = math.Max(0, math.Min(float64(total), y))
+	mod, _ := pc.rounding(value)
+	ours := int64(math.Floor(y/float64(mod)+0.5)) * mod
+	if ours > total {
+		ours = total
+	}
+	return ours, nil
+}
+
+// Division gives the payout division of a contract funded with total, from
+// the first value the curve covers to the last.  With steps, for contracts
+// on digits, a division is only started where the payout changes, and pays up
+// to the next one.
+func (pc PayoutCurve) Division(total int64,
+	steps bool) ([]lnutil.DlcContractDivision, error) {
+
+	err := pc.check()
+	if err != nil {
+		return nil, err
+	}
+
+	var division []lnutil.DlcContractDivision
+	for i, p := range pc.Pieces {
+		pay := func(v int64) (int64, error) {
+			return pc.round(v, p.payout(v), total)
+		}
+		// linear and step pieces only go one way, so with steps we can
+		// skip to where the payout next changes rather than try each value
+		skip := steps && p.Type != PayoutPolynomial
+		for v := p.From; ; {
+			ours, err := pay(v)
+			if err != nil {
+				return nil, fmt.Errorf("piece %d: %s", i, err.Error())
+			}
+			if !steps || len(division) == 0 ||
+				division[len(division)-1].ValueOurs != ours {
+
+				if len(division) >= consts.MaxDlcDivisions {
+					return nil, fmt.Errorf("payout curve gives more than %d"+
+						" divisions, round it more", consts.MaxDlcDivisions)
+				}
+				division = append(division,
+					lnutil.DlcContractDivision{OracleValue: v, ValueOurs: ours})
+			}
+
+			end := v
+			if skip {
+				// up to where the rounding changes, the last value paying
+				// the same
+				_, next := pc.rounding(v)
+				hi := p.To
+				if next-1 < hi {
+					hi = next - 1
+				}
+				for end < hi {
+					mid := end + (hi-end+1)/2
+					y, err := pay(mid)
+					if err == nil && y == ours {
+						end = mid
+					} else {
+						hi = mid - 1
+					}
+				}
+			}
+			if end >= p.To {
+				break
+			}
+			v = end + 1
+		}
+	}
+	return division, nil
+}
+
+// contractDivision gives the division of contract c paying what the curve
+// does.
+func (pc PayoutCurve) contractDivision(
+	c *lnutil.DlcContract) ([]lnutil.DlcContractDivision, error) {
+
+	total := c.OurFundingAmount + c.TheirFundingAmount
+	if total == 0 {
+		return nil, fmt.Errorf("You need to set the funding of the" +
+			" contract first, the payouts can't be more than it")
+	}
+	return pc.Division(total, c.OracleDigits > 0)
+}
+
+// PreviewContractPayoutCurve gives the division a contract would have with
+// its payout set to a curve, and how many outcomes there'd be a settlement
+// for.  The contract is left as it is.
+func (mgr *DlcManager) PreviewContractPayoutCurve(cIdx uint64,
+	curve PayoutCurve) ([]lnutil.DlcContractDivision, int, error) {
+
+	c, err := mgr.LoadContract(cIdx)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	c.Division, err = curve.contractDivision(c)
+	if err != nil {
+		return nil, 0, err
+	}
+	return c.Division, len(c.Outcomes()), nil
+}
+
+// SetContractPayoutCurve sets the division of the contract settlement to what
+// a payout curve pays.
+func (mgr *DlcManager) SetContractPayoutCurve(cIdx uint64,
+	curve PayoutCurve) error {
+
+	c, err := mgr.LoadContract(cIdx)
+	if err != nil {
+		return err
+	}
+
+	if c.Status != lnutil.ContractStatusDraft {
+		return fmt.Errorf("You cannot change or set the division unless" +
+			" the contract is in Draft state")
+	}
+
+	c.Division, err = curve.contractDivision(c)
+	if err != nil {
+		return err
+	}
+
+	return mgr.SaveContract(c)
+}
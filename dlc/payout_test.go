@@ -0,0 +1,196 @@
+package dlc
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mit-dci/lit/consts"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+func checkDivision(t *testing.T, got []lnutil.DlcContractDivision,
+	want ...int64) {
+
+	t.Helper()
+	if len(got) != len(want)/2 {
+		t.Fatalf("got %d divisions %v, expect %d", len(got), got, len(want)/2)
+	}
+	for i := range got {
+		if got[i].OracleValue != want[2*i] || got[i].ValueOurs != want[2*i+1] {
+			t.Fatalf("division %d is %d at %d, expect %d at %d", i,
+				got[i].ValueOurs, got[i].OracleValue, want[2*i+1], want[2*i])
+		}
+	}
+}
+
+func TestPayoutCurvePieces(t *testing.T) {
+	pc := PayoutCurve{Pieces: []PayoutPiece{
+		{Type: PayoutLinear, From: 0, To: 4, FromPayout: 0, ToPayout: 100},
+		{Type: PayoutPolynomial, From: 5, To: 8, Coefficients: []float64{1, 0, 1}},
+		{Type: PayoutStep, From: 9, To: 10, Payout: 7},
+	}}
+	d, err := pc.Division(50, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the polynomial's 1 + v^2, capped at the total
+	checkDivision(t, d, 0, 0, 1, 25, 2, 50, 3, 50, 4, 50,
+		5, 26, 6, 37, 7, 50, 8, 50, 9, 7, 10, 7)
+
+	// with steps, only where the payout changes
+	d, err = pc.Division(50, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkDivision(t, d, 0, 0, 1, 25, 2, 50, 5, 26, 6, 37, 7, 50, 9, 7)
+
+	for _, bad := range []PayoutCurve{
+		{},
+		{Pieces: []PayoutPiece{{Type: PayoutStep, From: 5, To: 4}}},
+		{Pieces: []PayoutPiece{{Type: PayoutStep, From: 0, To: 4},
+			{Type: PayoutStep, From: 6, To: 8}}},
+		{Pieces: []PayoutPiece{{Type: "cubic", From: 0, To: 4}}},
+		{Pieces: []PayoutPiece{{Type: PayoutPolynomial, From: 0, To: 4}}},
+		{Pieces: []PayoutPiece{{Type: PayoutPolynomial, From: 0,
+			To: consts.MaxDlcDivisions, Coefficients: []float64{1}}}},
+		{Pieces: []PayoutPiece{{Type: PayoutStep, From: 0, To: 4}},
+			Rounding: []RoundingInterval{{From: 0, Mod: 0}}},
+		{Pieces: []PayoutPiece{{Type: PayoutStep, From: 0, To: 4}},
+			Rounding: []RoundingInterval{{From: 2, Mod: 10}, {From: 2, Mod: 100}}},
+	} {
+		_, err = bad.Division(50, false)
+		if err == nil {
+			t.Fatalf("curve %v allowed", bad)
+		}
+	}
+}
+
+func TestPayoutCurveRounding(t *testing.T) {
+	pc := PayoutCurve{Rounding: []RoundingInterval{{From: 10, Mod: 100}}}
+	for _, tc := range []struct {
+		value int64
+		y     float64
+		total int64
+		ours  int64
+	}{
+		{5, 149.4, 1000, 149},
+		{5, 149.6, 1000, 150},
+		{10, 149, 1000, 100},
+		{10, 150, 1000, 200},
+		{10, -30, 1000, 0},
+		{10, 1e300, 1000, 1000},
+		// rounded up past the total
+		{10, 1050, 1050, 1050},
+	} {
+		ours, err := pc.round(tc.value, tc.y, tc.total)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ours != tc.ours {
+			t.Fatalf("%v at %d rounds to %d, expect %d",
+				tc.y, tc.value, ours, tc.ours)
+		}
+	}
+
+	for _, y := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		_, err := pc.round(10, y, 1000)
+		if err == nil {
+			t.Fatalf("%v rounded", y)
+		}
+		bad := PayoutCurve{Pieces: []PayoutPiece{{Type: PayoutPolynomial,
+			From: 0, To: 4, Coefficients: []float64{y}}}}
+		_, err = bad.Division(1000, true)
+		if err == nil {
+			t.Fatalf("curve paying %v allowed", y)
+		}
+	}
+}
+
+func TestPayoutCurveSteps(t *testing.T) {
+	// a billion values, but only 11 payouts once rounded
+	pc := PayoutCurve{
+		Pieces: []PayoutPiece{{Type: PayoutLinear, From: 0, To: 1000000000,
+			FromPayout: 0, ToPayout: 1000}},
+		Rounding: []RoundingInterval{{From: 0, Mod: 100}},
+	}
+	d, err := pc.Division(1000, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []int64{0, 0}
+	for k := int64(1); k <= 10; k++ {
+		want = append(want, (2*k-1)*50000000, k*100)
+	}
+	checkDivision(t, d, want...)
+
+	// a division for each value is too many
+	_, err = pc.Division(1000, false)
+	if err == nil {
+		t.Fatalf("%d divisions allowed", pc.Pieces[0].To+1)
+	}
+}
+
+func TestPreviewContractPayoutCurve(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dlc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mgr, err := NewManager(filepath.Join(dir, "dlc.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.DLCDB.Close()
+
+	c, err := mgr.AddContract()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = mgr.SetContractDigits(c.Idx, 2, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a capped forward over all 20 bit values
+	pc := PayoutCurve{
+		Pieces: []PayoutPiece{
+			{Type: PayoutStep, From: 0, To: 29999, Payout: 0},
+			{Type: PayoutLinear, From: 30000, To: 50000, ToPayout: 100000},
+			{Type: PayoutStep, From: 50001, To: 1<<20 - 1, Payout: 100000},
+		},
+		Rounding: []RoundingInterval{{From: 0, Mod: 10000}},
+	}
+	_, _, err = mgr.PreviewContractPayoutCurve(c.Idx, pc)
+	if err == nil {
+		t.Fatalf("previewed a curve with no funding")
+	}
+
+	err = mgr.SetContractFunding(c.Idx, 50000, 50000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, n, err := mgr.PreviewContractPayoutCurve(c.Idx, pc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []int64{0, 0}
+	for k := int64(1); k <= 10; k++ {
+		want = append(want, 30000+2000*k-1000, k*10000)
+	}
+	checkDivision(t, d, want...)
+	if n < len(d) || n > consts.MaxDlcSettlements {
+		t.Fatalf("%d outcomes for %d divisions", n, len(d))
+	}
+
+	// and the contract's left as it was
+	c, err = mgr.LoadContract(c.Idx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Division) != 0 {
+		t.Fatalf("preview set %d divisions", len(c.Division))
+	}
+}
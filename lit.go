@@ -3,6 +3,7 @@ package main
 import (
 	"os"
 	"os/signal"
+	"path/filepath"
 	"runtime"
 	"syscall"
 	"time"
@@ -34,6 +35,8 @@ type litConfig struct { // define a struct for usage with go-flags
 	TrackerURL string `long:"tracker" description:"LN address tracker URL http|https://host:port"`
 	ConfigFile string
 	UnauthRPC  bool `long:"unauthrpc" description:"Enables unauthenticated Websocket RPC"`
+	Unlock     bool `long:"unlock" description:"Start locked and wait for the key file passphrase over RPC (LitRPC.Unlock)"`
+	Restore    bool `long:"restore" description:"Create the key file from existing BIP39 seed words and rescan all linked coins"`
 
 	// proxy
 	ProxyURL      string `long:"proxy" description:"SOCKS5 proxy to use for communicating with the network"`
@@ -54,6 +57,14 @@ type litConfig struct { // define a struct for usage with go-flags
 	// rpc server config
 	Rpcport uint16 `short:"p" long:"rpcport" description:"Set RPC port to connect to"`
 	Rpchost string `long:"rpchost" description:"Set RPC host to listen to"`
+	// channel graph pruning
+	GraphPruneAge    int64 `long:"graphpruneage" description:"Seconds without an advert before a link is pruned from the channel graph"`
+	GraphMinCapacity int64 `long:"graphmincap" description:"Prune links with less capacity than this from the channel graph"`
+	// payments
+	Keysend bool `long:"keysend" description:"Take spontaneous (keysend) payments, which the payer picks the preimage of"`
+	// exchange rates
+	RateFeed         string `long:"ratefeed" description:"URL of a JSON price feed to set cross-coin exchange rates from"`
+	RateFeedInterval int64  `long:"ratefeedinterval" description:"Seconds between polls of the exchange rate feed"`
 	// auto config
 	AutoReconnect                   bool  `long:"autoReconnect" description:"Attempts to automatically reconnect to known peers periodically."`
 	AutoReconnectInterval           int64 `long:"autoReconnectInterval" description:"The interval (in seconds) the reconnect logic should be executed"`
@@ -67,6 +78,7 @@ var (
 	defaultLitHomeDirName                  = os.Getenv("HOME") + "/.lit"
 	defaultTrackerURL                      = "http://hubris.media.mit.edu:46580"
 	defaultKeyFileName                     = "privkey.hex"
+	defaultSeedFileName                    = "seed.hex"
 	defaultConfigFilename                  = "lit.conf"
 	defaultHomeDir                         = os.Getenv("HOME")
 	defaultRpcport                         = uint16(8001)
@@ -95,6 +107,13 @@ func newConfigParser(conf *litConfig, options flags.Options) *flags.Parser {
 	parser := flags.NewParser(conf, options)
 	return parser
 }
+
+// resyncCoin says whether the wallet for the given --resync name should be
+// rescanned.  "all" (set by --restore) rescans every linked coin.
+func resyncCoin(conf *litConfig, name string) bool {
+	return conf.Resync == name || conf.Resync == "all"
+}
+
 func linkWallets(node *qln.LitNode, key *[32]byte, conf *litConfig) error {
 	// for now, wallets are linked to the litnode on startup, and
 	// can't appear / disappear while it's running.  Later
@@ -109,7 +128,7 @@ func linkWallets(node *qln.LitNode, key *[32]byte, conf *litConfig) error {
 		logging.Infof("reg: %s\n", conf.Reghost)
 		resync := false
 		conf.Tip = consts.BitcoinRegtestBHeight
-		if conf.Resync == "reg" {
+		if resyncCoin(conf, "reg") {
 			if conf.Tip < consts.BitcoinRegtestBHeight {
 				conf.Tip = consts.BitcoinRegtestBHeight
 			}
@@ -126,7 +145,7 @@ func linkWallets(node *qln.LitNode, key *[32]byte, conf *litConfig) error {
 		p := &coinparam.TestNet3Params
 		resync := false
 		conf.Tip = consts.BitcoinTestnet3BHeight
-		if conf.Resync == "tn3" {
+		if resyncCoin(conf, "tn3") {
 			if conf.Tip < consts.BitcoinTestnet3BHeight {
 				conf.Tip = consts.BitcoinTestnet3BHeight
 			}
@@ -144,7 +163,7 @@ func linkWallets(node *qln.LitNode, key *[32]byte, conf *litConfig) error {
 		p := &coinparam.LiteRegNetParams
 		resync := false
 		conf.Tip = consts.BitcoinRegtestBHeight
-		if conf.Resync == "ltcreg" {
+		if resyncCoin(conf, "ltcreg") {
 			if conf.Tip < consts.BitcoinRegtestBHeight {
 				conf.Tip = consts.BitcoinRegtestBHeight // birth heights are the same for btc and ltc regtests
 			}
@@ -161,7 +180,7 @@ func linkWallets(node *qln.LitNode, key *[32]byte, conf *litConfig) error {
 		p := &coinparam.LiteCoinTestNet4Params
 		resync := false
 		conf.Tip = p.StartHeight
-		if conf.Resync == "ltctn" {
+		if resyncCoin(conf, "ltctn") {
 			if conf.Tip < 1 {
 				conf.Tip = 1
 			}
@@ -178,7 +197,7 @@ func linkWallets(node *qln.LitNode, key *[32]byte, conf *litConfig) error {
 	if !lnutil.NopeString(conf.Tvtchost) {
 		p := &coinparam.VertcoinTestNetParams
 		resync := false
-		if conf.Resync == "vtctn" {
+		if resyncCoin(conf, "vtctn") {
 			resync = true
 		}
 		err = node.LinkBaseWallet(
@@ -193,7 +212,7 @@ func linkWallets(node *qln.LitNode, key *[32]byte, conf *litConfig) error {
 		p := &coinparam.VertcoinParams
 		resync := false
 		conf.Tip = p.StartHeight
-		if conf.Resync == "ltctn" {
+		if resyncCoin(conf, "ltctn") {
 			if conf.Tip < 1 {
 				conf.Tip = 1
 			}
@@ -212,7 +231,7 @@ func linkWallets(node *qln.LitNode, key *[32]byte, conf *litConfig) error {
 		logging.Infof("Dummyusd: %s\n", conf.Dummyusdhost)
 		resync := false
 		conf.Tip = p.StartHeight
-		if conf.Resync == "dusd" {
+		if resyncCoin(conf, "dusd") {
 			if conf.Tip < 1 {
 				conf.Tip = 1
 			}
@@ -230,7 +249,7 @@ func linkWallets(node *qln.LitNode, key *[32]byte, conf *litConfig) error {
 		p := &coinparam.VertcoinRegTestParams
 		resync := false
 		conf.Tip = p.StartHeight
-		if conf.Resync == "rtvtc" {
+		if resyncCoin(conf, "rtvtc") {
 			if conf.Tip < 1 {
 				conf.Tip = 1
 			}
@@ -263,6 +282,15 @@ func main() {
 	}
 
 	key := litSetup(&conf)
+	keyFilePath := filepath.Join(conf.LitHomeDir, defaultKeyFileName)
+	if key == nil {
+		// started with --unlock; nothing comes up until we get the passphrase
+		var err error
+		key, err = litrpc.UnlockListen(keyFilePath, conf.Rpcport)
+		if err != nil {
+			logging.Fatal(err)
+		}
+	}
 	if conf.ProxyURL != "" {
 		conf.LitProxyURL = conf.ProxyURL
 		conf.ChainProxyURL = conf.ProxyURL
@@ -287,6 +315,31 @@ func main() {
 		logging.Fatal(err)
 	}
 
+	if conf.GraphPruneAge != 0 || conf.GraphMinCapacity != 0 {
+		rules := node.GetGraphPruneRules()
+		if conf.GraphPruneAge != 0 {
+			rules.MaxAge = conf.GraphPruneAge
+		}
+		rules.MinCapacity = conf.GraphMinCapacity
+		err = node.SetGraphPruneRules(rules)
+		if err != nil {
+			logging.Fatal(err)
+		}
+	}
+
+	node.SetAcceptKeysend(conf.Keysend)
+
+	if conf.RateFeed != "" {
+		interval := conf.RateFeedInterval
+		if interval <= 0 {
+			interval = consts.RateFeedInterval
+		}
+		node.AddRateProvider(&qln.HTTPRateProvider{
+			URL:      conf.RateFeed,
+			Interval: time.Duration(interval) * time.Second,
+		})
+	}
+
 	// node is up; link wallets based on args
 	err = linkWallets(node, key, &conf)
 	if err != nil {
@@ -297,6 +350,8 @@ func main() {
 	rpcl := new(litrpc.LitRPC)
 	rpcl.Node = node
 	rpcl.OffButton = make(chan bool, 1)
+	rpcl.KeyFile = keyFilePath
+	rpcl.SeedFile = filepath.Join(conf.LitHomeDir, defaultSeedFileName)
 	node.RPC = rpcl
 
 	// "conf.UnauthRPC" enables unauthenticated Websocket RPC. Default - false.
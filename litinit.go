@@ -34,7 +34,8 @@ func createDefaultConfigFile(destinationPath string) error {
 
 // litSetup performs most of the setup when lit is run, such as setting
 // configuration variables, reading in key data, reading and creating files if
-// they're not yet there.  It takes in a config, and returns a key.
+// they're not yet there.  It takes in a config, and returns a key.  With
+// --unlock and an encrypted key file it returns nil instead of prompting.
 // (maybe add the key to the config?
 func litSetup(conf *litConfig) *[32]byte {
 	// Pre-parse the command line options to see if an alternative config
@@ -113,8 +114,37 @@ func litSetup(conf *litConfig) *[32]byte {
 
 	keyFilePath := filepath.Join(conf.LitHomeDir, defaultKeyFileName)
 
-	// read key file (generate if not found)
-	key, err := lnutil.ReadKeyFile(keyFilePath)
+	if conf.Unlock {
+		// the key file has to exist already; we can't ask for a new
+		// passphrase over RPC
+		encrypted, err := lnutil.KeyFileEncrypted(keyFilePath)
+		if err != nil {
+			logging.Fatalf("--unlock needs an existing key file: %s", err.Error())
+		}
+		if encrypted {
+			// caller waits for LitRPC.Unlock
+			return nil
+		}
+	}
+
+	seedFilePath := filepath.Join(conf.LitHomeDir, defaultSeedFileName)
+
+	if conf.Restore {
+		if fileExists(keyFilePath) {
+			logging.Fatalf("--restore: key file %s already exists, move it away first",
+				keyFilePath)
+		}
+		key, err := lnutil.RestoreSeedInteractive(keyFilePath, seedFilePath)
+		if err != nil {
+			logging.Fatal(err)
+		}
+		// funds could be anywhere after the birth height; rescan everything
+		conf.Resync = "all"
+		return key
+	}
+
+	// read key file (generate from new seed words if not found)
+	key, err := lnutil.ReadOrCreateSeedKeyFile(keyFilePath, seedFilePath)
 	if err != nil {
 		logging.Fatal(err)
 	}
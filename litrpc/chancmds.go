@@ -2,11 +2,13 @@ package litrpc
 
 import (
 	"fmt"
+	"io/ioutil"
 
 	"github.com/mit-dci/lit/logging"
 
 	"github.com/mit-dci/lit/btcutil"
 	"github.com/mit-dci/lit/consts"
+	"github.com/mit-dci/lit/lnutil"
 	"github.com/mit-dci/lit/portxo"
 	"github.com/mit-dci/lit/qln"
 )
@@ -134,11 +136,13 @@ func (r *LitRPC) ChannelList(args ChanArgs, reply *ChannelListReply) error {
 
 // ------------------------- fund
 type FundArgs struct {
-	Peer        uint32 // who to make the channel with
-	CoinType    uint32 // what coin to use
-	Capacity    int64  // later can be minimum capacity
-	Roundup     int64  // ignore for now; can be used to round-up capacity
-	InitialSend int64  // Initial send of -1 means "ALL"
+	Peer        uint32   // who to make the channel with
+	CoinType    uint32   // what coin to use
+	Capacity    int64    // later can be minimum capacity
+	Roundup     int64    // ignore for now; can be used to round-up capacity
+	InitialSend int64    // Initial send of -1 means "ALL"
+	ConfTarget  uint32   // blocks for the funding tx to confirm in; 0 for default
+	Inputs      []string // outpoints to fund from; empty lets the wallet pick
 	Data        [32]byte
 }
 
@@ -183,13 +187,23 @@ func (r *LitRPC) FundChannel(args FundArgs, reply *FundReply) error {
 
 	spendable := allPorTxos.SumWitness(nowHeight)
 
-	if args.Capacity > spendable-wal.Fee()*consts.JusticeTxBump {
+	if args.ConfTarget == 0 {
+		args.ConfTarget = consts.DefaultConfTarget
+	}
+	feePerByte := wal.EstimateFee(args.ConfTarget)
+
+	if args.Capacity > spendable-feePerByte*consts.JusticeTxBump {
 		return fmt.Errorf("Wanted %d but %d available for channel creation",
-			args.Capacity, spendable-wal.Fee()*consts.JusticeTxBump)
+			args.Capacity, spendable-feePerByte*consts.JusticeTxBump)
 	}
 
-	idx, err := r.Node.FundChannel(
-		args.Peer, args.CoinType, args.Capacity, args.InitialSend, args.Data)
+	ins, err := parseOutPoints(args.Inputs)
+	if err != nil {
+		return err
+	}
+
+	idx, err := r.Node.FundChannel(args.Peer, args.CoinType, args.Capacity,
+		args.InitialSend, args.ConfTarget, ins, args.Data)
 	if err != nil {
 		return err
 	}
@@ -433,6 +447,38 @@ func (r *LitRPC) BreakChannel(args ChanArgs, reply *StatusReply) error {
 	return r.Node.BreakChannel(qc)
 }
 
+// ------------------------- importbackup
+type ImportChannelBackupArgs struct {
+	Data []byte // contents of a channels.backup file
+	Path string // or, if no Data, a backup file on the node's machine
+}
+
+// ImportChannelBackup restores channels from a static channel backup and
+// asks their peers to break them, so we can sweep our outputs.
+func (r *LitRPC) ImportChannelBackup(
+	args ImportChannelBackupArgs, reply *StatusReply) error {
+
+	data := args.Data
+	if len(data) == 0 {
+		if args.Path == "" {
+			return fmt.Errorf("no backup data or path given")
+		}
+		var err error
+		data, err = ioutil.ReadFile(args.Path)
+		if err != nil {
+			return err
+		}
+	}
+
+	n, err := r.Node.ImportChannelBackup(data)
+	if err != nil {
+		return err
+	}
+	reply.Status = fmt.Sprintf(
+		"Restored %d channels, asking peers to break them", n)
+	return nil
+}
+
 // ------------------------- dumpPriv
 type PrivInfo struct {
 	OutPoint string
@@ -658,10 +704,107 @@ type PayMultihopArgs struct {
 	DestCoinType   uint32
 	OriginCoinType uint32
 	Amt            int64
+	Keysend        bool // pick the preimage ourselves, without asking the destination
 }
 
 // PayMultihop tries to find a multi-hop path to send the payment along
 func (r *LitRPC) PayMultihop(args PayMultihopArgs, reply *StatusReply) error {
-	_, err := r.Node.PayMultihop(args.DestLNAdr, args.OriginCoinType, args.DestCoinType, args.Amt)
+	_, err := r.Node.PayMultihop(args.DestLNAdr, args.OriginCoinType, args.DestCoinType, args.Amt, args.Keysend)
 	return err
 }
+
+type KeysendArgs struct {
+	Accept bool
+}
+
+// SetKeysend sets whether we take spontaneous (keysend) payments, which
+// the payer picks the preimage of.
+func (r *LitRPC) SetKeysend(args KeysendArgs, reply *StatusReply) error {
+	r.Node.SetAcceptKeysend(args.Accept)
+	if args.Accept {
+		reply.Status = "taking keysend payments"
+	} else {
+		reply.Status = "turning down keysend payments"
+	}
+	return nil
+}
+
+type KeysendReply struct {
+	Accept bool
+}
+
+// GetKeysend says whether we take spontaneous (keysend) payments.
+func (r *LitRPC) GetKeysend(args NoArgs, reply *KeysendReply) error {
+	reply.Accept = r.Node.AcceptsKeysend()
+	return nil
+}
+
+// ------------------------- policy
+type ChannelPolicyArgs struct {
+	ChanIdx   uint32 // channel to set, or 0 for the default for all channels
+	FeeBase   int64  // flat fee for each payment forwarded
+	FeeRate   uint32 // fee in millionths of the amount forwarded
+	CLTVDelta uint32 // blocks kept between incoming and outgoing HTLCs
+	MinHTLC   int64  // smallest amount to forward
+	MaxHTLC   int64  // biggest amount to forward; 0 for no max
+}
+
+// SetChannelPolicy sets what we ask to forward multihop payments over a
+// channel, or over all channels which haven't had their own set.
+func (r *LitRPC) SetChannelPolicy(args ChannelPolicyArgs, reply *StatusReply) error {
+	policy := lnutil.ChanPolicy{
+		FeeBase:   args.FeeBase,
+		FeeRate:   args.FeeRate,
+		CLTVDelta: args.CLTVDelta,
+		MinHTLC:   args.MinHTLC,
+		MaxHTLC:   args.MaxHTLC,
+	}
+
+	if args.ChanIdx == 0 {
+		err := r.Node.SetChanPolicy(nil, policy)
+		if err != nil {
+			return err
+		}
+		reply.Status = "set default channel policy"
+		return nil
+	}
+
+	qc, err := r.Node.GetQchanByIdx(args.ChanIdx)
+	if err != nil {
+		return err
+	}
+	err = r.Node.SetChanPolicy(&qc.Op, policy)
+	if err != nil {
+		return err
+	}
+	reply.Status = fmt.Sprintf("set policy for channel %d", args.ChanIdx)
+	return nil
+}
+
+type ChannelPolicyReply struct {
+	FeeBase   int64
+	FeeRate   uint32
+	CLTVDelta uint32
+	MinHTLC   int64
+	MaxHTLC   int64
+}
+
+// GetChannelPolicy gives the forwarding policy of a channel, or the default
+// if ChanIdx is 0.
+func (r *LitRPC) GetChannelPolicy(args ChanArgs, reply *ChannelPolicyReply) error {
+	policy := r.Node.DefaultChanPolicy()
+	if args.ChanIdx != 0 {
+		qc, err := r.Node.GetQchanByIdx(args.ChanIdx)
+		if err != nil {
+			return err
+		}
+		policy = r.Node.ChanPolicy(qc.Op)
+	}
+
+	reply.FeeBase = policy.FeeBase
+	reply.FeeRate = policy.FeeRate
+	reply.CLTVDelta = policy.CLTVDelta
+	reply.MinHTLC = policy.MinHTLC
+	reply.MaxHTLC = policy.MaxHTLC
+	return nil
+}
@@ -2,9 +2,12 @@ package litrpc
 
 import (
 	"encoding/hex"
+	"fmt"
 
+	"github.com/mit-dci/lit/btcutil/psbt"
 	"github.com/mit-dci/lit/dlc"
 	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/wire"
 )
 
 type ListOraclesArgs struct {
@@ -163,6 +166,88 @@ func (r *LitRPC) SetContractOracle(args SetContractOracleArgs,
 	return nil
 }
 
+type AddContractOracleArgs struct {
+	CIdx    uint64
+	OIdx    uint64
+	Feed    uint64
+	RPoint  [33]byte
+	RPoints [][33]byte
+}
+
+type AddContractOracleReply struct {
+	Success bool
+}
+
+// AddContractOracle adds another known oracle for a (new) contract to settle
+// on.  Its R-point is RPoint (or RPoints, for each digit of contracts on
+// digits), or fetched for Feed if that's not given.
+func (r *LitRPC) AddContractOracle(args AddContractOracleArgs,
+	reply *AddContractOracleReply) error {
+	var err error
+
+	rPoints := args.RPoints
+	if len(rPoints) == 0 && args.RPoint != [33]byte{} {
+		rPoints = [][33]byte{args.RPoint}
+	}
+
+	err = r.Node.DlcManager.AddContractOracle(args.CIdx, args.OIdx, args.Feed,
+		rPoints)
+	if err != nil {
+		return err
+	}
+
+	reply.Success = true
+	return nil
+}
+
+type ClearContractOraclesArgs struct {
+	CIdx uint64
+}
+
+type ClearContractOraclesReply struct {
+	Success bool
+}
+
+// ClearContractOracles takes the oracles added with AddContractOracle off a
+// (new) contract
+func (r *LitRPC) ClearContractOracles(args ClearContractOraclesArgs,
+	reply *ClearContractOraclesReply) error {
+	var err error
+
+	err = r.Node.DlcManager.ClearContractOracles(args.CIdx)
+	if err != nil {
+		return err
+	}
+
+	reply.Success = true
+	return nil
+}
+
+type SetContractOracleThresholdArgs struct {
+	CIdx      uint64
+	Threshold uint32
+}
+
+type SetContractOracleThresholdReply struct {
+	Success bool
+}
+
+// SetContractOracleThreshold sets how many of a (new) contract's oracles have
+// to sign the same value for it to settle
+func (r *LitRPC) SetContractOracleThreshold(args SetContractOracleThresholdArgs,
+	reply *SetContractOracleThresholdReply) error {
+	var err error
+
+	err = r.Node.DlcManager.SetContractOracleThreshold(args.CIdx,
+		args.Threshold)
+	if err != nil {
+		return err
+	}
+
+	reply.Success = true
+	return nil
+}
+
 type SetContractDatafeedArgs struct {
 	CIdx uint64
 	Feed uint64
@@ -188,20 +273,53 @@ func (r *LitRPC) SetContractDatafeed(args SetContractDatafeedArgs,
 }
 
 type SetContractRPointArgs struct {
-	CIdx   uint64
-	RPoint [33]byte
+	CIdx    uint64
+	RPoint  [33]byte
+	RPoints [][33]byte
 }
 
 type SetContractRPointReply struct {
 	Success bool
 }
 
-// SetContractRPoint manually sets the R-point for the contract using a pubkey
+// SetContractRPoint manually sets the R-point for the contract using a pubkey,
+// or for contracts on digits, the R-points of each digit in RPoints
 func (r *LitRPC) SetContractRPoint(args SetContractRPointArgs,
 	reply *SetContractRPointReply) error {
 	var err error
 
-	err = r.Node.DlcManager.SetContractRPoint(args.CIdx, args.RPoint)
+	if len(args.RPoints) > 0 {
+		err = r.Node.DlcManager.SetContractRPoints(args.CIdx, args.RPoints)
+	} else {
+		err = r.Node.DlcManager.SetContractRPoint(args.CIdx, args.RPoint)
+	}
+	if err != nil {
+		return err
+	}
+
+	reply.Success = true
+	return nil
+}
+
+type SetContractDigitsArgs struct {
+	CIdx   uint64
+	Base   uint32
+	Digits uint32
+}
+
+type SetContractDigitsReply struct {
+	Success bool
+}
+
+// SetContractDigits sets the contract to settle on a value the oracles sign
+// digit by digit, so it has a settlement for each range of values paying the
+// same rather than for each value
+func (r *LitRPC) SetContractDigits(args SetContractDigitsArgs,
+	reply *SetContractDigitsReply) error {
+	var err error
+
+	err = r.Node.DlcManager.SetContractDigits(args.CIdx, args.Base,
+		args.Digits)
 	if err != nil {
 		return err
 	}
@@ -289,6 +407,53 @@ func (r *LitRPC) SetContractDivision(args SetContractDivisionArgs,
 	return nil
 }
 
+type SetContractPayoutCurveArgs struct {
+	CIdx  uint64
+	Curve dlc.PayoutCurve
+}
+
+type SetContractPayoutCurveReply struct {
+	Success bool
+}
+
+// SetContractPayoutCurve sets how the contract is settled to what a payout
+// curve of linear, polynomial and step pieces pays us for each value
+func (r *LitRPC) SetContractPayoutCurve(args SetContractPayoutCurveArgs,
+	reply *SetContractPayoutCurveReply) error {
+	var err error
+
+	err = r.Node.DlcManager.SetContractPayoutCurve(args.CIdx, args.Curve)
+	if err != nil {
+		return err
+	}
+
+	reply.Success = true
+	return nil
+}
+
+type PreviewContractPayoutCurveArgs struct {
+	CIdx  uint64
+	Curve dlc.PayoutCurve
+}
+
+type PreviewContractPayoutCurveReply struct {
+	Division []lnutil.DlcContractDivision
+	Outcomes int
+}
+
+// PreviewContractPayoutCurve gives the division the contract would have with
+// a payout curve, and how many outcomes would need a settlement transaction,
+// without changing the contract
+func (r *LitRPC) PreviewContractPayoutCurve(
+	args PreviewContractPayoutCurveArgs,
+	reply *PreviewContractPayoutCurveReply) error {
+	var err error
+
+	reply.Division, reply.Outcomes, err =
+		r.Node.DlcManager.PreviewContractPayoutCurve(args.CIdx, args.Curve)
+	return err
+}
+
 type SetContractCoinTypeArgs struct {
 	CIdx     uint64
 	CoinType uint32
@@ -315,8 +480,10 @@ func (r *LitRPC) SetContractCoinType(args SetContractCoinTypeArgs,
 }
 
 type OfferContractArgs struct {
-	CIdx    uint64
-	PeerIdx uint32
+	CIdx        uint64
+	PeerIdx     uint32
+	Inputs      []string // outpoints to fund our side from; empty lets the wallet pick
+	FundingPsbt string   // or a PSBT whose inputs fund our side, signed outside lit
 }
 
 type OfferContractReply struct {
@@ -326,9 +493,16 @@ type OfferContractReply struct {
 // OfferContract offers a contract to a (connected) peer
 func (r *LitRPC) OfferContract(args OfferContractArgs,
 	reply *OfferContractReply) error {
-	var err error
+	ins, err := parseOutPoints(args.Inputs)
+	if err != nil {
+		return err
+	}
+	fundPsbt, err := parseFundingPsbt(args.FundingPsbt, ins)
+	if err != nil {
+		return err
+	}
 
-	err = r.Node.OfferDlc(args.PeerIdx, args.CIdx)
+	err = r.Node.OfferDlc(args.PeerIdx, args.CIdx, ins, fundPsbt)
 	if err != nil {
 		return err
 	}
@@ -341,6 +515,8 @@ type ContractRespondArgs struct {
 	// True for accept, false for decline.
 	AcceptOrDecline bool
 	CIdx            uint64
+	Inputs          []string // when accepting, outpoints to fund our side from
+	FundingPsbt     string   // or a PSBT to fund our side from, signed outside lit
 }
 
 type ContractRespondReply struct {
@@ -352,7 +528,17 @@ func (r *LitRPC) ContractRespond(args ContractRespondArgs, reply *ContractRespon
 	var err error
 
 	if args.AcceptOrDecline {
-		err = r.Node.AcceptDlc(args.CIdx)
+		var ins []wire.OutPoint
+		ins, err = parseOutPoints(args.Inputs)
+		if err != nil {
+			return err
+		}
+		var fundPsbt *psbt.Packet
+		fundPsbt, err = parseFundingPsbt(args.FundingPsbt, ins)
+		if err != nil {
+			return err
+		}
+		err = r.Node.AcceptDlc(args.CIdx, ins, fundPsbt)
 	} else {
 		err = r.Node.DeclineDlc(args.CIdx, 0x01)
 	}
@@ -369,6 +555,8 @@ type SettleContractArgs struct {
 	CIdx        uint64
 	OracleValue int64
 	OracleSig   [32]byte
+	OracleSigs  [][32]byte
+	DigitSigs   [][][32]byte
 }
 
 type SettleContractReply struct {
@@ -379,13 +567,30 @@ type SettleContractReply struct {
 
 // SettleContract uses the value and signature from the oracle to settle the
 // contract and send the equivalent settlement transaction to the blockchain.
-// It will subsequently claim the contract output back to our wallet
+// It will subsequently claim the contract output back to our wallet.
+// Contracts on several oracles take their signatures in OracleSigs, in the
+// order of the contract's oracles, with empty ones for oracles that didn't
+// sign.  Contracts on digits take them in DigitSigs, those of each oracle on
+// each digit.
 func (r *LitRPC) SettleContract(args SettleContractArgs,
 	reply *SettleContractReply) error {
 	var err error
 
+	sigs := args.DigitSigs
+	if len(sigs) == 0 {
+		if len(args.OracleSigs) == 0 {
+			args.OracleSigs = [][32]byte{args.OracleSig}
+		}
+		sigs = make([][][32]byte, len(args.OracleSigs))
+		for i, sig := range args.OracleSigs {
+			if sig != [32]byte{} {
+				sigs[i] = [][32]byte{sig}
+			}
+		}
+	}
+
 	reply.SettleTxHash, reply.ClaimTxHash, err = r.Node.SettleContract(
-		args.CIdx, args.OracleValue, args.OracleSig)
+		args.CIdx, args.OracleValue, sigs)
 	if err != nil {
 		return err
 	}
@@ -393,3 +598,49 @@ func (r *LitRPC) SettleContract(args SettleContractArgs,
 	reply.Success = true
 	return nil
 }
+
+// parseFundingPsbt parses the PSBT funding our side of a contract, if there
+// is one.  It can't be given along with inputs.
+func parseFundingPsbt(s string, ins []wire.OutPoint) (*psbt.Packet, error) {
+	if s == "" {
+		return nil, nil
+	}
+	if len(ins) > 0 {
+		return nil, fmt.Errorf("give inputs or a funding PSBT, not both")
+	}
+	return parsePsbt(s)
+}
+
+// GetContractFundingPsbt gives the funding tx of a contract as a PSBT, when
+// it's waiting for our inputs to be signed outside lit.
+func (r *LitRPC) GetContractFundingPsbt(args GetContractArgs,
+	reply *PsbtReply) error {
+
+	p, err := r.Node.ContractFundingPsbt(args.Idx)
+	if err != nil {
+		return err
+	}
+	return reply.set(p)
+}
+
+type SignContractFundingArgs struct {
+	CIdx uint64
+	Psbt string
+}
+
+// SignContractFunding takes the funding PSBT of a contract back with our
+// inputs signed, and carries on funding the contract.
+func (r *LitRPC) SignContractFunding(args SignContractFundingArgs,
+	reply *StatusReply) error {
+
+	p, err := parsePsbt(args.Psbt)
+	if err != nil {
+		return err
+	}
+	err = r.Node.SignContractFunding(args.CIdx, p)
+	if err != nil {
+		return err
+	}
+	reply.Status = fmt.Sprintf("signed funding of contract %d", args.CIdx)
+	return nil
+}
@@ -0,0 +1,148 @@
+package litrpc
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/mit-dci/lit/bech32"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+type InvoiceInfo struct {
+	Invoice     string
+	Payee       string // ln address
+	CoinType    uint32
+	Amt         int64 // 0 if the payer picks
+	PaymentHash string
+	Timestamp   int64
+	Expiry      uint32 // seconds after Timestamp
+	Description string
+	RouteHints  []string // from:cointype:capacity
+	Expired     bool
+
+	// only for invoices we made
+	PreImage string
+	Paid     bool
+}
+
+func newInvoiceInfo(inv *lnutil.Invoice) (InvoiceInfo, error) {
+	var i InvoiceInfo
+	var err error
+	i.Invoice, err = inv.Encode()
+	if err != nil {
+		return i, err
+	}
+	i.Payee = inv.PayeeAdr()
+	i.CoinType = inv.CoinType
+	i.Amt = inv.Amount
+	i.PaymentHash = hex.EncodeToString(inv.PaymentHash[:])
+	i.Timestamp = inv.Timestamp
+	i.Expiry = inv.Expiry
+	i.Description = inv.Description
+	for _, h := range inv.RouteHints {
+		i.RouteHints = append(i.RouteHints, fmt.Sprintf("%s:%d:%d",
+			bech32.Encode("ln", h.From[:]), h.CoinType, h.Capacity))
+	}
+	i.Expired = inv.Expired()
+	return i, nil
+}
+
+// ------------------------- create
+type CreateInvoiceArgs struct {
+	CoinType    uint32
+	Amt         int64  // 0 lets the payer pick
+	Expiry      uint32 // seconds; 0 for the default of an hour
+	Description string
+}
+
+type CreateInvoiceReply struct {
+	Invoice     string
+	PaymentHash string
+}
+
+// CreateInvoice makes a signed invoice for a multihop payment to us.
+func (r *LitRPC) CreateInvoice(args CreateInvoiceArgs, reply *CreateInvoiceReply) error {
+	if args.CoinType == 0 {
+		args.CoinType = r.Node.DefaultCoin
+	}
+	inv, err := r.Node.CreateInvoice(
+		args.CoinType, args.Amt, args.Expiry, args.Description)
+	if err != nil {
+		return err
+	}
+	reply.Invoice, err = inv.Encode()
+	if err != nil {
+		return err
+	}
+	reply.PaymentHash = hex.EncodeToString(inv.PaymentHash[:])
+	return nil
+}
+
+// ------------------------- decode
+type DecodeInvoiceArgs struct {
+	Invoice string
+}
+
+type DecodeInvoiceReply struct {
+	Invoice InvoiceInfo
+}
+
+// DecodeInvoice checks the sig of an invoice and shows what's in it.
+func (r *LitRPC) DecodeInvoice(args DecodeInvoiceArgs, reply *DecodeInvoiceReply) error {
+	inv, err := lnutil.DecodeInvoice(strings.TrimSpace(args.Invoice))
+	if err != nil {
+		return err
+	}
+	reply.Invoice, err = newInvoiceInfo(inv)
+	return err
+}
+
+// ------------------------- pay
+type PayInvoiceArgs struct {
+	Invoice        string
+	OriginCoinType uint32 // 0 for the invoice's coin type
+	Amt            int64  // only for invoices without an amount
+}
+
+// PayInvoice pays an invoice along a multihop route.  The payee doesn't
+// have to be reachable.
+func (r *LitRPC) PayInvoice(args PayInvoiceArgs, reply *StatusReply) error {
+	inv, err := lnutil.DecodeInvoice(strings.TrimSpace(args.Invoice))
+	if err != nil {
+		return err
+	}
+	if args.OriginCoinType == 0 {
+		args.OriginCoinType = inv.CoinType
+	}
+	err = r.Node.PayInvoice(inv, args.OriginCoinType, args.Amt)
+	if err != nil {
+		return err
+	}
+	reply.Status = fmt.Sprintf("paying invoice %x to %s",
+		inv.PaymentHash, inv.PayeeAdr())
+	return nil
+}
+
+// ------------------------- list
+type ListInvoicesReply struct {
+	Invoices []InvoiceInfo
+}
+
+// ListInvoices shows the invoices we've made, and whether they're paid.
+func (r *LitRPC) ListInvoices(args NoArgs, reply *ListInvoicesReply) error {
+	invoices, err := r.Node.GetAllInvoices()
+	if err != nil {
+		return err
+	}
+	for _, ii := range invoices {
+		i, err := newInvoiceInfo(ii.Invoice)
+		if err != nil {
+			return err
+		}
+		i.PreImage = hex.EncodeToString(ii.PreImage[:])
+		i.Paid = r.Node.InvoicePaid(ii.Invoice.PaymentHash)
+		reply.Invoices = append(reply.Invoices, i)
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+package litrpc
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+
+	"golang.org/x/net/websocket"
+
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/logging"
+)
+
+/*
+When lit is started with --unlock and the key file is encrypted, nothing
+else can come up until we have the key: the node identity, LNDC and the
+wallets all derive from it.  So we serve a tiny RPC surface with only
+LitRPC.Unlock on the usual rpc port.  There's no RPC auth yet at that point,
+so it only listens on localhost, whatever the rpc host is.  Once the right
+passphrase shows up that server shuts down and startup carries on as normal.
+*/
+
+type UnlockArgs struct {
+	Passphrase string
+}
+
+type ChangePassphraseArgs struct {
+	OldPassphrase string
+	NewPassphrase string
+	// set to store the key unencrypted; NewPassphrase has to be empty
+	RemoveEncryption bool
+}
+
+// lockedRPC is registered as "LitRPC" while the node is locked
+type lockedRPC struct {
+	keyFile  string
+	unlocked chan *[32]byte
+}
+
+// Unlock decrypts the key file with the given passphrase and hands the key
+// to the waiting startup code
+func (l *lockedRPC) Unlock(args UnlockArgs, reply *StatusReply) error {
+	key, err := lnutil.LoadKeyFromFileArg(l.keyFile, []byte(args.Passphrase))
+	if err != nil {
+		return err
+	}
+	select {
+	case l.unlocked <- key:
+		reply.Status = "Unlocked lit node"
+	default:
+		// someone else got there first
+		reply.Status = "Already unlocked"
+	}
+	return nil
+}
+
+// UnlockListen serves LitRPC.Unlock on localhost:port and blocks until the
+// key file at keyFile has been decrypted.
+func UnlockListen(keyFile string, port uint16) (*[32]byte, error) {
+	l := &lockedRPC{
+		keyFile:  keyFile,
+		unlocked: make(chan *[32]byte, 1),
+	}
+
+	server := rpc.NewServer()
+	err := server.RegisterName("LitRPC", l)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/ws", websocket.Handler(func(ws *websocket.Conn) {
+		server.ServeCodec(jsonrpc.NewServerCodec(ws))
+	}))
+	mux.HandleFunc("/oneoff", func(rw http.ResponseWriter, req *http.Request) {
+		o := OiOoReadWriter{
+			from: req.Body,
+			to:   rw,
+		}
+		server.ServeCodec(jsonrpc.NewServerCodec(o))
+	})
+
+	srv := &http.Server{
+		Addr:    fmt.Sprintf("127.0.0.1:%d", port),
+		Handler: mux,
+	}
+	listenErr := make(chan error, 1)
+	go func() {
+		listenErr <- srv.ListenAndServe()
+	}()
+	logging.Infof("Node locked, waiting for LitRPC.Unlock on %s\n", srv.Addr)
+
+	select {
+	case key := <-l.unlocked:
+		err = srv.Shutdown(context.Background())
+		if err != nil {
+			logging.Errorf("Error stopping unlock listener: %s", err.Error())
+		}
+		return key, nil
+	case err = <-listenErr:
+		return nil, err
+	}
+}
+
+// Unlock only does something while the node is locked; by the time the
+// full LitRPC is registered we already have the key.
+func (r *LitRPC) Unlock(args UnlockArgs, reply *StatusReply) error {
+	return fmt.Errorf("lit node is already unlocked")
+}
+
+// ChangePassphrase re-encrypts the key file with a new passphrase.  The key
+// file is only stored unencrypted if RemoveEncryption is set.
+func (r *LitRPC) ChangePassphrase(args ChangePassphraseArgs, reply *StatusReply) error {
+	if r.KeyFile == "" {
+		return fmt.Errorf("no key file known to this node")
+	}
+	err := r.checkPassphrase([]byte(args.OldPassphrase))
+	if err != nil {
+		return err
+	}
+	if args.RemoveEncryption {
+		if args.NewPassphrase != "" {
+			return fmt.Errorf("can't remove encryption and set a new passphrase")
+		}
+		err := lnutil.RemoveSeedKeyPassphrase(r.KeyFile, r.SeedFile,
+			[]byte(args.OldPassphrase))
+		if err != nil {
+			return err
+		}
+		reply.Status = "Removed key file encryption"
+		return nil
+	}
+	if args.NewPassphrase == "" {
+		return fmt.Errorf("empty new passphrase; set RemoveEncryption to" +
+			" store the key unencrypted")
+	}
+	err = lnutil.ChangeSeedKeyPassphrase(r.KeyFile, r.SeedFile,
+		[]byte(args.OldPassphrase), []byte(args.NewPassphrase))
+	if err != nil {
+		return err
+	}
+	reply.Status = "Changed key file passphrase"
+	return nil
+}
+
+type ShowSeedArgs struct {
+	Passphrase string
+}
+
+type ShowSeedReply struct {
+	Mnemonic string
+}
+
+// ShowSeed returns the BIP39 seed words the key was made from.  It wants the
+// key file passphrase again even though the node is unlocked; whoever can
+// see the words can take everything.
+func (r *LitRPC) ShowSeed(args ShowSeedArgs, reply *ShowSeedReply) error {
+	if r.SeedFile == "" || !fileExists(r.SeedFile) {
+		return fmt.Errorf("no seed file; key was not made from seed words")
+	}
+	if r.KeyFile == "" {
+		return fmt.Errorf("no key file known to this node")
+	}
+	if args.Passphrase == "" {
+		// an unencrypted node has no passphrase to ask for
+		return fmt.Errorf("need the key file passphrase to show the seed;" +
+			" an unencrypted seed can only be read from its file")
+	}
+	err := r.checkPassphrase([]byte(args.Passphrase))
+	if err != nil {
+		return err
+	}
+	mnemonic, err := lnutil.LoadMnemonicFromFileArg(
+		r.SeedFile, []byte(args.Passphrase))
+	if err != nil {
+		return err
+	}
+	reply.Mnemonic = mnemonic
+	return nil
+}
+
+// checkPassphrase makes sure pass opens the key file, or failing that the
+// seed file.  Loading an unencrypted file works with any passphrase at all, so
+// if neither is encrypted there's nothing to check it against: changing an
+// unencrypted key file then needs an empty old passphrase, and the seed words
+// aren't shown over RPC.
+func (r *LitRPC) checkPassphrase(pass []byte) error {
+	for _, name := range []string{r.KeyFile, r.SeedFile} {
+		if name == "" || !fileExists(name) {
+			continue
+		}
+		encrypted, err := lnutil.KeyFileEncrypted(name)
+		if err != nil {
+			return err
+		}
+		if encrypted {
+			_, err = lnutil.LoadKeyFromFileArg(name, pass)
+			return err
+		}
+	}
+	if len(pass) != 0 {
+		return fmt.Errorf("key file isn't encrypted, but got a passphrase")
+	}
+	return nil
+}
+
+func fileExists(name string) bool {
+	_, err := os.Stat(name)
+	return !os.IsNotExist(err)
+}
@@ -31,6 +31,8 @@ It ends up being the root of ~everything in the executable.
 type LitRPC struct {
 	Node      *qln.LitNode
 	OffButton chan bool
+	KeyFile   string
+	SeedFile  string
 }
 
 func serveWS(ws *websocket.Conn) {
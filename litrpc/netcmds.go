@@ -1,6 +1,7 @@
 package litrpc
 
 import (
+	"encoding/hex"
 	"fmt"
 	"strconv"
 	"strings"
@@ -188,13 +189,347 @@ func (r *LitRPC) GetChannelMap(args NoArgs, reply *ChannelGraphReply) error {
 	return nil
 }
 
+// ------------ Query the channel graph
+type GraphNodeInfo struct {
+	LNAdr      string
+	PubKey     string // hex; empty if it hasn't advertised any links
+	Links      int    // links it's advertised
+	LastUpdate int64  // unix time of its last advert
+}
+
+type GraphNodesReply struct {
+	Nodes []GraphNodeInfo
+}
+
+// GetGraphNodes gives the nodes in the channel graph.
+func (r *LitRPC) GetGraphNodes(args NoArgs, reply *GraphNodesReply) error {
+	var nullPub [33]byte
+	for _, n := range r.Node.GraphNodes() {
+		i := GraphNodeInfo{
+			LNAdr:      bech32.Encode("ln", n.PKH[:]),
+			Links:      n.Links,
+			LastUpdate: n.LastUpdate,
+		}
+		if n.Pub != nullPub {
+			i.PubKey = hex.EncodeToString(n.Pub[:])
+		}
+		reply.Nodes = append(reply.Nodes, i)
+	}
+	return nil
+}
+
+type GraphEdgesArgs struct {
+	LNAdr string // only links to or from this node, if given
+}
+
+type GraphEdgeInfo struct {
+	From            string
+	To              string
+	CoinType        uint32
+	Capacity        int64 // what From can send To over it
+	FundingOutPoint string
+	Seq             uint32
+	LastUpdate      int64 // unix time of its last advert
+	Rates           []lnutil.RateDesc
+
+	// what From asks to forward over it
+	FeeBase   int64
+	FeeRate   uint32
+	CLTVDelta uint32
+	MinHTLC   int64
+	MaxHTLC   int64
+}
+
+type GraphEdgesReply struct {
+	Edges []GraphEdgeInfo
+}
+
+// GetGraphEdges gives the links in the channel graph.
+func (r *LitRPC) GetGraphEdges(args GraphEdgesArgs, reply *GraphEdgesReply) error {
+	var pkh *[20]byte
+	if args.LNAdr != "" {
+		_, adr, err := bech32.Decode(args.LNAdr)
+		if err != nil {
+			return err
+		}
+		if len(adr) != 20 {
+			return fmt.Errorf("%s isn't an ln address", args.LNAdr)
+		}
+		pkh = new([20]byte)
+		copy(pkh[:], adr)
+	}
+
+	for _, l := range r.Node.GraphEdges(pkh) {
+		reply.Edges = append(reply.Edges, GraphEdgeInfo{
+			From:            bech32.Encode("ln", l.APKH[:]),
+			To:              bech32.Encode("ln", l.BPKH[:]),
+			CoinType:        l.CoinType,
+			Capacity:        l.ACapacity,
+			FundingOutPoint: l.FundingOp.String(),
+			Seq:             l.Seq,
+			LastUpdate:      l.Timestamp,
+			Rates:           l.Rates,
+			FeeBase:         l.Policy.FeeBase,
+			FeeRate:         l.Policy.FeeRate,
+			CLTVDelta:       l.Policy.CLTVDelta,
+			MinHTLC:         l.Policy.MinHTLC,
+			MaxHTLC:         l.Policy.MaxHTLC,
+		})
+	}
+	return nil
+}
+
+type QueryRoutesArgs struct {
+	DestLNAdr      string
+	DestCoinType   uint32
+	OriginCoinType uint32
+	Amt            int64  // what has to get to the destination
+	Count          uint32 // most routes to give; 0 for 3
+}
+
+type RouteHopInfo struct {
+	LNAdr    string
+	CoinType uint32 // coin it sends on in
+	Amt      int64  // what it sends on; at the destination, what it gets
+	CLTV     uint32 // blocks the HTLC it sends on is locked for
+}
+
+type RouteInfo struct {
+	Hops    []RouteHopInfo
+	Send    int64 // what we send
+	Deliver int64 // what gets to the destination
+}
+
+type QueryRoutesReply struct {
+	Routes []RouteInfo
+}
+
+// QueryRoutes gives the routes we could pay a node over, best first, with
+// what each hop would send on after fees.
+func (r *LitRPC) QueryRoutes(args QueryRoutesArgs, reply *QueryRoutesReply) error {
+	_, adr, err := bech32.Decode(args.DestLNAdr)
+	if err != nil {
+		return err
+	}
+	if len(adr) != 20 {
+		return fmt.Errorf("%s isn't an ln address", args.DestLNAdr)
+	}
+	var target [20]byte
+	copy(target[:], adr)
+
+	if args.OriginCoinType == 0 {
+		args.OriginCoinType = r.Node.DefaultCoin
+	}
+	if args.DestCoinType == 0 {
+		args.DestCoinType = args.OriginCoinType
+	}
+	if args.Count == 0 {
+		args.Count = 3
+	}
+
+	routes, err := r.Node.RouteCandidates(target, args.DestCoinType,
+		args.OriginCoinType, args.Amt, int(args.Count))
+	if err != nil {
+		return err
+	}
+
+	for _, rc := range routes {
+		var ri RouteInfo
+		for k, hop := range rc.Path {
+			h := RouteHopInfo{
+				LNAdr:    bech32.Encode("ln", hop.Node[:]),
+				CoinType: hop.CoinType,
+			}
+			if k < len(rc.Amts) {
+				h.Amt = rc.Amts[k]
+				h.CLTV = rc.CLTVs[k]
+			} else {
+				h.Amt = rc.Deliver
+			}
+			ri.Hops = append(ri.Hops, h)
+		}
+		ri.Send = rc.Amts[0]
+		ri.Deliver = rc.Deliver
+		reply.Routes = append(reply.Routes, ri)
+	}
+	return nil
+}
+
+type GraphPruneRulesArgs struct {
+	MaxAge      int64 // seconds without an advert before a link is pruned
+	MinCapacity int64 // links with less capacity are pruned
+}
+
+// SetGraphPruneRules sets when links are pruned from the channel graph.
+func (r *LitRPC) SetGraphPruneRules(args GraphPruneRulesArgs, reply *StatusReply) error {
+	err := r.Node.SetGraphPruneRules(qln.GraphPruneRules{
+		MaxAge:      args.MaxAge,
+		MinCapacity: args.MinCapacity,
+	})
+	if err != nil {
+		return err
+	}
+	reply.Status = fmt.Sprintf("pruning links after %d seconds, or under %d capacity",
+		args.MaxAge, args.MinCapacity)
+	return nil
+}
+
+type GraphPruneRulesReply struct {
+	MaxAge      int64
+	MinCapacity int64
+}
+
+// GetGraphPruneRules gives when links are pruned from the channel graph.
+func (r *LitRPC) GetGraphPruneRules(args NoArgs, reply *GraphPruneRulesReply) error {
+	rules := r.Node.GetGraphPruneRules()
+	reply.MaxAge = rules.MaxAge
+	reply.MinCapacity = rules.MinCapacity
+	return nil
+}
+
+type EdgeFailureInfo struct {
+	From     string // ln address of the node which couldn't send on
+	To       string // and of the one it couldn't send to
+	CoinType uint32
+	Failures uint32
+	LastFail int64 // unix time
+}
+
+type MissionControlReply struct {
+	Edges []EdgeFailureInfo
+}
+
+// GetMissionControl gives the links routing is going round because
+// payments have failed over them lately.
+func (r *LitRPC) GetMissionControl(args NoArgs, reply *MissionControlReply) error {
+	for _, f := range r.Node.MissionControl() {
+		reply.Edges = append(reply.Edges, EdgeFailureInfo{
+			From:     bech32.Encode("ln", f.From[:]),
+			To:       bech32.Encode("ln", f.To[:]),
+			CoinType: f.CoinType,
+			Failures: f.Count,
+			LastFail: f.LastFail,
+		})
+	}
+	return nil
+}
+
+// ResetMissionControl forgets the links payments have failed over, so
+// routing uses them again.
+func (r *LitRPC) ResetMissionControl(args NoArgs, reply *StatusReply) error {
+	err := r.Node.ResetMissionControl()
+	if err != nil {
+		return err
+	}
+	reply.Status = "forgot all failing links"
+	return nil
+}
+
+// ------------ Exchange rates
+type ExchangeRateInfo struct {
+	CoinType     uint32 // coin we send on in
+	FromCoinType uint32 // coin we take for it
+	Rate         int64
+	Reciprocal   bool
+	Source       string // the provider, or "manual"
+	Spread       uint32 // millionths taken off the provider's rate
+}
+
+type ExchangeRatesReply struct {
+	Rates []ExchangeRateInfo
+}
+
+// GetExchangeRates gives the rates we advertise for forwarding payments
+// between coins.
+func (r *LitRPC) GetExchangeRates(args NoArgs, reply *ExchangeRatesReply) error {
+	for _, ri := range r.Node.ExchangeRateInfo() {
+		reply.Rates = append(reply.Rates, ExchangeRateInfo{
+			CoinType:     ri.CoinType,
+			FromCoinType: ri.Rate.CoinType,
+			Rate:         ri.Rate.Rate,
+			Reciprocal:   ri.Rate.Reciprocal,
+			Source:       ri.Source,
+			Spread:       ri.Spread,
+		})
+	}
+	return nil
+}
+
+type ExchangeRateArgs struct {
+	CoinType     uint32 // coin we send on in
+	FromCoinType uint32 // coin we take for it
+	Rate         int64  // 0 to go back to the providers' rate
+	Reciprocal   bool
+}
+
+// SetExchangeRate sets the rate we take one coin at for sending on in
+// another, over what the rate providers say.  It's advertised straight away.
+func (r *LitRPC) SetExchangeRate(args ExchangeRateArgs, reply *StatusReply) error {
+	err := r.Node.SetExchangeRate(args.CoinType, lnutil.RateDesc{
+		CoinType:   args.FromCoinType,
+		Rate:       args.Rate,
+		Reciprocal: args.Reciprocal,
+	})
+	if err != nil {
+		return err
+	}
+	if args.Rate == 0 {
+		reply.Status = fmt.Sprintf("using provided rate for %d to %d",
+			args.FromCoinType, args.CoinType)
+	} else {
+		reply.Status = fmt.Sprintf("set rate for %d to %d", args.FromCoinType,
+			args.CoinType)
+	}
+	return nil
+}
+
+type RateSpreadArgs struct {
+	CoinType     uint32 // coin we send on in
+	FromCoinType uint32 // coin we take for it
+	Spread       uint32 // millionths to take off the rate; 0 for none
+}
+
+// SetRateSpread sets the margin we take off the providers' rate for
+// exchanging one coin for another.
+func (r *LitRPC) SetRateSpread(args RateSpreadArgs, reply *StatusReply) error {
+	err := r.Node.SetRateSpread(args.CoinType, args.FromCoinType, args.Spread)
+	if err != nil {
+		return err
+	}
+	reply.Status = fmt.Sprintf("spread for %d to %d is %d millionths",
+		args.FromCoinType, args.CoinType, args.Spread)
+	return nil
+}
+
 // ------------ Show multihop payments
+type PaymentAttemptInfo struct {
+	Path    []string
+	Amt     int64  // what we sent
+	Deliver int64  // what was to get to the payee
+	Start   int64  // unix time
+	End     int64  // 0 if we haven't heard how it went
+	Failure string // why it failed, if it did
+}
+
 type MultihopPaymentInfo struct {
 	RHash     [32]byte
 	R         [16]byte
 	Amt       int64
 	Path      []string
 	Succeeded bool
+	Failure   string               // why it failed, for payments we sent
+	PartIdx   uint32               // which part, for payments split over several routes
+	TotalAmt  int64                // what all the parts come to, or 0 if it isn't split
+	State     string               // in flight, waiting to retry, succeeded, failed or timed out
+	Attempts  []PaymentAttemptInfo // routes we tried, for payments we sent
+}
+
+func routeStrings(path []lnutil.RouteHop) []string {
+	var s []string
+	for _, hop := range path {
+		s = append(s, fmt.Sprintf("%s:%d", bech32.Encode("ln", hop.Node[:]), hop.CoinType))
+	}
+	return s
 }
 
 type MultihopPaymentsReply struct {
@@ -205,17 +540,29 @@ func (r *LitRPC) ListMultihopPayments(args NoArgs, reply *MultihopPaymentsReply)
 	r.Node.MultihopMutex.Lock()
 	defer r.Node.MultihopMutex.Unlock()
 	for _, p := range r.Node.InProgMultihop {
-		var path []string
-		for _, hop := range p.Path {
-			path = append(path, fmt.Sprintf("%s:%d", bech32.Encode("ln", hop.Node[:]), hop.CoinType))
+		var attempts []PaymentAttemptInfo
+		for _, a := range p.Attempts {
+			attempts = append(attempts, PaymentAttemptInfo{
+				routeStrings(a.Path),
+				a.Amt,
+				a.Deliver,
+				a.Start,
+				a.End,
+				a.Failure,
+			})
 		}
 
 		i := MultihopPaymentInfo{
 			p.HHash,
 			p.PreImage,
 			p.Amt,
-			path,
+			routeStrings(p.Path),
 			p.Succeeded,
+			p.Failure,
+			p.PartIdx,
+			p.TotalAmt,
+			p.State.String(),
+			attempts,
 		}
 
 		reply.Payments = append(reply.Payments, i)
@@ -0,0 +1,274 @@
+package litrpc
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/mit-dci/lit/bech32"
+	"github.com/mit-dci/lit/btcutil/psbt"
+	"github.com/mit-dci/lit/consts"
+	"github.com/mit-dci/lit/wire"
+)
+
+// PSBTs go over RPC in base64, the way other wallets pass them around.
+
+func parsePsbt(s string) (*psbt.Packet, error) {
+	return psbt.NewFromRawBytes(strings.NewReader(strings.TrimSpace(s)), true)
+}
+
+type PsbtArgs struct {
+	Psbt     string
+	CoinType uint32
+}
+
+type PsbtReply struct {
+	Psbt     string
+	Complete bool // every input is finalized
+}
+
+func (reply *PsbtReply) set(p *psbt.Packet) error {
+	var err error
+	reply.Psbt, err = p.B64Encode()
+	reply.Complete = p.IsComplete()
+	return err
+}
+
+// ------------------------- create
+type CreatePsbtArgs struct {
+	DestAddrs  []string
+	Amts       []int64
+	Inputs     []string // outpoints to spend; empty lets the wallet pick
+	ConfTarget uint32   // blocks to confirm in; 0 for default
+}
+
+// CreatePsbt makes an unsigned PSBT from the wallet, like Send but without
+// signing or broadcasting.  The inputs are locked until they're spent,
+// unlocked with UnlockUtxos, or consts.PsbtLockTime is up.
+func (r *LitRPC) CreatePsbt(args CreatePsbtArgs, reply *PsbtReply) error {
+	nOutputs := len(args.DestAddrs)
+	if nOutputs < 1 {
+		return fmt.Errorf("No destination address specified")
+	}
+	if nOutputs != len(args.Amts) {
+		return fmt.Errorf("%d addresses but %d amounts specified",
+			nOutputs, len(args.Amts))
+	}
+	coinType := CoinTypeFromAdr(args.DestAddrs[0])
+	wal, ok := r.Node.SubWallet[coinType]
+	if !ok {
+		return fmt.Errorf("no connnected wallet for address %s type %d",
+			args.DestAddrs[0], coinType)
+	}
+
+	txOuts := make([]*wire.TxOut, nOutputs)
+	for i, s := range args.DestAddrs {
+		if CoinTypeFromAdr(s) != coinType {
+			return fmt.Errorf("Coin type mismatch for address %s, %s",
+				s, args.DestAddrs[0])
+		}
+		if args.Amts[i] < consts.MinSendAmt {
+			return fmt.Errorf("Amt %d less than minimum send amount %d",
+				args.Amts[i], consts.MinSendAmt)
+		}
+		outScript, err := AdrStringToOutscript(s)
+		if err != nil {
+			return err
+		}
+		txOuts[i] = wire.NewTxOut(args.Amts[i], outScript)
+	}
+
+	if args.ConfTarget == 0 {
+		args.ConfTarget = consts.DefaultConfTarget
+	}
+	ins, err := parseOutPoints(args.Inputs)
+	if err != nil {
+		return err
+	}
+
+	p, err := wal.CreatePsbt(txOuts, ins, wal.EstimateFee(args.ConfTarget))
+	if err != nil {
+		return err
+	}
+	return reply.set(p)
+}
+
+// ------------------------- sign
+// SignPsbt signs and finalizes the inputs of a PSBT which are in the wallet.
+func (r *LitRPC) SignPsbt(args PsbtArgs, reply *PsbtReply) error {
+	if args.CoinType == 0 {
+		args.CoinType = r.Node.DefaultCoin
+	}
+	wal, ok := r.Node.SubWallet[args.CoinType]
+	if !ok {
+		return fmt.Errorf("no connnected wallet for coin type %d", args.CoinType)
+	}
+	p, err := parsePsbt(args.Psbt)
+	if err != nil {
+		return err
+	}
+	p, err = wal.SignMyPsbtInputs(p)
+	if err != nil {
+		return err
+	}
+	return reply.set(p)
+}
+
+// ------------------------- combine
+type CombinePsbtArgs struct {
+	Psbts []string
+}
+
+// CombinePsbt merges PSBTs for the same tx which were signed separately.
+func (r *LitRPC) CombinePsbt(args CombinePsbtArgs, reply *PsbtReply) error {
+	if len(args.Psbts) < 2 {
+		return fmt.Errorf("need at least 2 PSBTs to combine")
+	}
+	p, err := parsePsbt(args.Psbts[0])
+	if err != nil {
+		return err
+	}
+	for _, s := range args.Psbts[1:] {
+		p2, err := parsePsbt(s)
+		if err != nil {
+			return err
+		}
+		p, err = psbt.Combine(p, p2)
+		if err != nil {
+			return err
+		}
+	}
+	return reply.set(p)
+}
+
+// ------------------------- finalize
+type FinalizePsbtArgs struct {
+	Psbt      string
+	CoinType  uint32
+	Broadcast bool // send the tx out if it's complete
+}
+
+type FinalizePsbtReply struct {
+	Psbt     string
+	Complete bool
+	Tx       string // hex signed tx, if complete
+	Txid     string
+}
+
+// FinalizePsbt finalizes whatever inputs it can.  If that's all of them it
+// gives the signed tx, and broadcasts it if asked.
+func (r *LitRPC) FinalizePsbt(args FinalizePsbtArgs, reply *FinalizePsbtReply) error {
+	p, err := parsePsbt(args.Psbt)
+	if err != nil {
+		return err
+	}
+	reply.Complete = psbt.MaybeFinalizeAll(p)
+	reply.Psbt, err = p.B64Encode()
+	if err != nil {
+		return err
+	}
+	if !reply.Complete {
+		if args.Broadcast {
+			return fmt.Errorf("PSBT is not fully signed, can't broadcast")
+		}
+		return nil
+	}
+
+	tx, err := psbt.Extract(p)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	err = tx.Serialize(&buf)
+	if err != nil {
+		return err
+	}
+	reply.Tx = hex.EncodeToString(buf.Bytes())
+	reply.Txid = tx.TxHash().String()
+
+	if args.Broadcast {
+		if args.CoinType == 0 {
+			args.CoinType = r.Node.DefaultCoin
+		}
+		wal, ok := r.Node.SubWallet[args.CoinType]
+		if !ok {
+			return fmt.Errorf("no connnected wallet for coin type %d", args.CoinType)
+		}
+		err = wal.PushTx(tx)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ------------------------- channel funding
+type FundPsbtArgs struct {
+	Peer        uint32 // who to make the channel with
+	CoinType    uint32 // what coin to use
+	Capacity    int64
+	InitialSend int64
+	Data        [32]byte
+}
+
+type FundPsbtReply struct {
+	Address  string // what the funding tx has to pay
+	PkScript string // hex
+	Amount   int64
+}
+
+// FundChannelPsbt starts opening a channel whose funding tx is signed
+// outside lit.  Gives what the funding tx has to pay; send a signed PSBT
+// of it to FinishFundChannelPsbt.
+func (r *LitRPC) FundChannelPsbt(args FundPsbtArgs, reply *FundPsbtReply) error {
+	if r.Node.InProg != nil && r.Node.InProg.PeerIdx != 0 {
+		return fmt.Errorf("channel with peer %d not done yet", r.Node.InProg.PeerIdx)
+	}
+	wal, ok := r.Node.SubWallet[args.CoinType]
+	if !ok {
+		return fmt.Errorf("No wallet of cointype %d linked", args.CoinType)
+	}
+
+	txo, err := r.Node.FundChannelPsbt(args.Peer, args.CoinType,
+		args.Capacity, args.InitialSend, args.Data)
+	if err != nil {
+		return err
+	}
+
+	// P2WSH, so the program is everything after OP_0 and the push
+	reply.Address, err = bech32.SegWitV0Encode(
+		wal.Params().Bech32Prefix, txo.PkScript[2:])
+	if err != nil {
+		return err
+	}
+	reply.PkScript = hex.EncodeToString(txo.PkScript)
+	reply.Amount = txo.Value
+	return nil
+}
+
+// FinishFundChannelPsbt takes the signed funding tx for the channel started
+// with FundChannelPsbt, and opens the channel.
+func (r *LitRPC) FinishFundChannelPsbt(args PsbtArgs, reply *FundReply) error {
+	p, err := parsePsbt(args.Psbt)
+	if err != nil {
+		return err
+	}
+	idx, err := r.Node.FinishFundChannelPsbt(p)
+	if err != nil {
+		return err
+	}
+	reply.Status = fmt.Sprintf("funded channel %d", idx)
+	reply.ChanIdx = idx
+	return nil
+}
+
+// CancelFundChannelPsbt gives up on a channel started with FundChannelPsbt.
+func (r *LitRPC) CancelFundChannelPsbt(args NoArgs, reply *StatusReply) error {
+	err := r.Node.CancelFundChannelPsbt()
+	if err != nil {
+		return err
+	}
+	reply.Status = "cancelled PSBT channel funding"
+	return nil
+}
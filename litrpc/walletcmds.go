@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	"github.com/mit-dci/lit/bech32"
+	"github.com/mit-dci/lit/btcutil/chaincfg/chainhash"
 	"github.com/mit-dci/lit/consts"
 	"github.com/mit-dci/lit/lnutil"
 	"github.com/mit-dci/lit/logging"
@@ -26,6 +27,19 @@ type CoinArgs struct {
 	CoinType uint32
 }
 
+// parseOutPoints reads outpoints given as "txid;index" strings.
+func parseOutPoints(strs []string) ([]wire.OutPoint, error) {
+	ops := make([]wire.OutPoint, len(strs))
+	for i, s := range strs {
+		op, err := lnutil.OutPointFromString(s)
+		if err != nil {
+			return nil, err
+		}
+		ops[i] = *op
+	}
+	return ops, nil
+}
+
 // ------------------------- balance
 // BalReply is the reply when the user asks about their balance.
 type CoinBalReply struct {
@@ -91,6 +105,8 @@ type TxoInfo struct {
 	Delay    int32
 	CoinType string
 	Witty    bool
+	Locked   bool   // left out of coin selection
+	Label    string // set with SetLabel
 
 	KeyPath string
 }
@@ -110,6 +126,15 @@ func (r *LitRPC) TxoList(args *NoArgs, reply *TxoListReply) error {
 
 		syncHeight := wal.CurrentHeight()
 
+		lockedOps, err := wal.LockedUtxos()
+		if err != nil {
+			return err
+		}
+		locked := make(map[wire.OutPoint]bool)
+		for _, op := range lockedOps {
+			locked[op] = true
+		}
+
 		theseTxos := make([]TxoInfo, len(walTxos))
 		for i, u := range walTxos {
 			theseTxos[i].OutPoint = u.Op.String()
@@ -122,6 +147,11 @@ func (r *LitRPC) TxoList(args *NoArgs, reply *TxoListReply) error {
 			}
 			theseTxos[i].Witty = u.Mode&portxo.FlagTxoWitness != 0
 			theseTxos[i].KeyPath = u.KeyGen.String()
+			theseTxos[i].Locked = locked[u.Op]
+			theseTxos[i].Label, err = wal.UtxoLabel(u.Op)
+			if err != nil {
+				return err
+			}
 		}
 
 		reply.Txos = append(reply.Txos, theseTxos...)
@@ -131,8 +161,10 @@ func (r *LitRPC) TxoList(args *NoArgs, reply *TxoListReply) error {
 
 // ------------------------- send
 type SendArgs struct {
-	DestAddrs []string
-	Amts      []int64
+	DestAddrs  []string
+	Amts       []int64
+	ConfTarget uint32   // blocks to confirm in; 0 for default
+	Inputs     []string // outpoints to spend; empty lets the wallet pick
 }
 
 func (r *LitRPC) Send(args SendArgs, reply *TxidsReply) error {
@@ -177,8 +209,17 @@ func (r *LitRPC) Send(args SendArgs, reply *TxidsReply) error {
 		txOuts[i] = wire.NewTxOut(args.Amts[i], outScript)
 	}
 
+	if args.ConfTarget == 0 {
+		args.ConfTarget = consts.DefaultConfTarget
+	}
+
+	ins, err := parseOutPoints(args.Inputs)
+	if err != nil {
+		return err
+	}
+
 	// we don't care if it's witness or not
-	ops, err := wal.MaybeSend(txOuts, false)
+	ops, err := wal.MaybeSend(txOuts, ins, wal.EstimateFee(args.ConfTarget), false)
 	if err != nil {
 		return err
 	}
@@ -269,7 +310,7 @@ func (r *LitRPC) Fanout(args FanArgs, reply *TxidsReply) error {
 	}
 
 	// don't care if inputs are witty or not
-	ops, err := wal.MaybeSend(txos, false)
+	ops, err := wal.MaybeSend(txos, nil, wal.Fee(), false)
 	if err != nil {
 		return err
 	}
@@ -282,6 +323,148 @@ func (r *LitRPC) Fanout(args FanArgs, reply *TxidsReply) error {
 	return nil
 }
 
+// ------------------------- bump
+type BumpFeeArgs struct {
+	Txid       string
+	CoinType   uint32 // 0 for the default coin
+	FeePerByte int64  // new fee rate; 0 to estimate from ConfTarget
+	ConfTarget uint32 // 0 for consts.BumpConfTarget
+	CPFP       bool   // spend an output instead of replacing
+}
+
+// BumpFee speeds up one of our unconfirmed txs.  It's replaced with a higher
+// fee version if it can be, otherwise a child tx spends one of its outputs.
+func (r *LitRPC) BumpFee(args BumpFeeArgs, reply *TxidsReply) error {
+	// if cointype is 0, use the node's default coin
+	if args.CoinType == 0 {
+		args.CoinType = r.Node.DefaultCoin
+	}
+	wal, ok := r.Node.SubWallet[args.CoinType]
+	if !ok {
+		return fmt.Errorf("no connnected wallet for coin type %d", args.CoinType)
+	}
+	txid, err := chainhash.NewHashFromStr(args.Txid)
+	if err != nil {
+		return err
+	}
+	if args.FeePerByte < 0 {
+		return fmt.Errorf("Invalid fee rate %d", args.FeePerByte)
+	}
+	if args.FeePerByte == 0 {
+		if args.ConfTarget == 0 {
+			args.ConfTarget = consts.BumpConfTarget
+		}
+		args.FeePerByte = wal.EstimateFee(args.ConfTarget)
+	}
+
+	newTxid, err := wal.BumpFee(*txid, args.FeePerByte, args.CPFP)
+	if err != nil {
+		return err
+	}
+
+	reply.Txids = append(reply.Txids, newTxid.String())
+	return nil
+}
+
+// ------------------------- coin control
+type OutPointsArgs struct {
+	OutPoints []string // "txid;index"
+	CoinType  uint32   // 0 for the default coin
+}
+
+// LockUtxos keeps utxos out of automatic coin selection.  They can still be
+// spent by giving them as inputs explicitly.
+func (r *LitRPC) LockUtxos(args OutPointsArgs, reply *StatusReply) error {
+	return r.lockUtxos(args, reply, true)
+}
+
+// UnlockUtxos lets coin selection use locked utxos again.
+func (r *LitRPC) UnlockUtxos(args OutPointsArgs, reply *StatusReply) error {
+	return r.lockUtxos(args, reply, false)
+}
+
+func (r *LitRPC) lockUtxos(args OutPointsArgs, reply *StatusReply, lock bool) error {
+	// if cointype is 0, use the node's default coin
+	if args.CoinType == 0 {
+		args.CoinType = r.Node.DefaultCoin
+	}
+	wal, ok := r.Node.SubWallet[args.CoinType]
+	if !ok {
+		return fmt.Errorf("no connnected wallet for coin type %d", args.CoinType)
+	}
+	ops, err := parseOutPoints(args.OutPoints)
+	if err != nil {
+		return err
+	}
+	for _, op := range ops {
+		if lock {
+			err = wal.LockUtxo(op)
+		} else {
+			err = wal.UnlockUtxo(op)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	verb := "unlocked"
+	if lock {
+		verb = "locked"
+	}
+	reply.Status = fmt.Sprintf("%s %d utxos", verb, len(ops))
+	return nil
+}
+
+type SetLabelArgs struct {
+	// Target is an outpoint ("txid;index") or one of our addresses
+	Target   string
+	Label    string // empty to remove
+	CoinType uint32 // for outpoints; 0 for the default coin
+}
+
+// SetLabel puts a free text label on a utxo or address.
+func (r *LitRPC) SetLabel(args SetLabelArgs, reply *StatusReply) error {
+	op, opErr := lnutil.OutPointFromString(args.Target)
+	if opErr == nil {
+		if args.CoinType == 0 {
+			args.CoinType = r.Node.DefaultCoin
+		}
+		wal, ok := r.Node.SubWallet[args.CoinType]
+		if !ok {
+			return fmt.Errorf("no connnected wallet for coin type %d", args.CoinType)
+		}
+		err := wal.SetUtxoLabel(*op, args.Label)
+		if err != nil {
+			return err
+		}
+		reply.Status = fmt.Sprintf("labeled %s", op.String())
+		return nil
+	}
+
+	// not an outpoint, so try it as an address
+	coinType := CoinTypeFromAdr(args.Target)
+	wal, ok := r.Node.SubWallet[coinType]
+	if !ok {
+		return fmt.Errorf("%s is not an outpoint or address of a connected wallet",
+			args.Target)
+	}
+	outScript, err := AdrStringToOutscript(args.Target)
+	if err != nil {
+		return err
+	}
+	keyHash := lnutil.KeyHashFromPkScript(outScript)
+	if len(keyHash) != 20 {
+		return fmt.Errorf("%s is not a pubkey hash address", args.Target)
+	}
+	var pkh [20]byte
+	copy(pkh[:], keyHash)
+	err = wal.SetAdrLabel(pkh, args.Label)
+	if err != nil {
+		return err
+	}
+	reply.Status = fmt.Sprintf("labeled %s", args.Target)
+	return nil
+}
+
 // set fee
 type SetFeeArgs struct {
 	Fee      int64
@@ -290,13 +473,15 @@ type SetFeeArgs struct {
 
 // get fee
 type FeeArgs struct {
-	CoinType uint32
+	CoinType   uint32
+	ConfTarget uint32 // 0 for default
 }
 type FeeReply struct {
 	CurrentFee int64
 }
 
-// SetFee allows you to set a fee rate for a wallet.
+// SetFee allows you to set a fixed fee rate for a wallet, instead of
+// estimating one.  Setting 0 goes back to estimating.
 func (r *LitRPC) SetFee(args *SetFeeArgs, reply *FeeReply) error {
 	// if cointype is 0, use the node's default coin
 	if args.CoinType == 0 {
@@ -325,7 +510,10 @@ func (r *LitRPC) GetFee(args *FeeArgs, reply *FeeReply) error {
 	if !ok {
 		return fmt.Errorf("no connnected wallet for coin type %d", args.CoinType)
 	}
-	reply.CurrentFee = wal.Fee()
+	if args.ConfTarget == 0 {
+		args.ConfTarget = consts.DefaultConfTarget
+	}
+	reply.CurrentFee = wal.EstimateFee(args.ConfTarget)
 	return nil
 }
 
@@ -340,6 +528,7 @@ type AddressReply struct {
 	CoinTypes       []uint32
 	WitAddresses    []string
 	LegacyAddresses []string
+	Labels          []string
 }
 
 func (r *LitRPC) Address(args *AddressArgs, reply *AddressReply) error {
@@ -390,6 +579,7 @@ func (r *LitRPC) Address(args *AddressArgs, reply *AddressReply) error {
 	reply.CoinTypes = make([]uint32, len(allAdr))
 	reply.WitAddresses = make([]string, len(allAdr))
 	reply.LegacyAddresses = make([]string, len(allAdr))
+	reply.Labels = make([]string, len(allAdr))
 
 	for i, a := range allAdr {
 
@@ -409,6 +599,11 @@ func (r *LitRPC) Address(args *AddressArgs, reply *AddressReply) error {
 			return err
 		}
 		reply.WitAddresses[i] = bech32adr
+
+		reply.Labels[i], err = r.Node.SubWallet[ctypesPerAdr[i]].AdrLabel(a)
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -423,6 +618,7 @@ func (r *LitRPC) GetAddresses(args *NoArgs, reply *AddressReply) error {
 	cts := make([]uint32, 0)
 	was := make([]string, 0)
 	las := make([]string, 0)
+	lbs := make([]string, 0)
 
 	for cointype, wal := range r.Node.SubWallet {
 
@@ -444,6 +640,12 @@ func (r *LitRPC) GetAddresses(args *NoArgs, reply *AddressReply) error {
 			was = append(was, b32)
 			las = append(las, lnutil.OldAddressFromPKH(pubkey, param.PubKeyHashAddrID))
 
+			label, err := wal.AdrLabel(pubkey)
+			if err != nil {
+				return err
+			}
+			lbs = append(lbs, label)
+
 			ri++
 		}
 
@@ -452,6 +654,7 @@ func (r *LitRPC) GetAddresses(args *NoArgs, reply *AddressReply) error {
 	reply.CoinTypes = cts
 	reply.WitAddresses = was
 	reply.LegacyAddresses = las
+	reply.Labels = lbs
 
 	return nil
 }
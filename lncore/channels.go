@@ -1,5 +1,9 @@
 package lncore
 
+import (
+	"github.com/mit-dci/lit/portxo"
+)
+
 // LitChannelStorage .
 type LitChannelStorage interface {
 	GetChannel(handle ChannelHandle) (*ChannelInfo, error)
@@ -42,12 +46,105 @@ const (
 	CstateError = 255
 )
 
-// ChannelInfo .
+// ChannelInfo is everything we need to write down about a channel to be able
+// to rebuild it later.  Keys that can be derived from the KeyGen path in the
+// txo aren't stored.
 type ChannelInfo struct {
 	PeerAddr   string       `json:"peeraddr"`
 	CoinType   int32        `json:"cointype"`
 	State      ChannelState `json:"state"`
 	OpenTx     []byte       `json:"opentx"`     // should this be here?
 	OpenHeight int32        `json:"openheight"` // -1 if unconfirmed
-	// TODO More.
+
+	// Underlying utxo data, includes the KeyGen path for our keys.
+	Txo       portxo.PorTxo    `json:"txo"`
+	CloseData ChannelCloseData `json:"closedata"`
+
+	TheirPub       [33]byte `json:"rpub"`
+	TheirRefundPub [33]byte `json:"rrefpub"`
+	TheirHAKDBase  [33]byte `json:"rhakdbase"`
+
+	// Serialized elkrem receiver for their revocation hashes.
+	ElkRecv []byte `json:"elkrecv"`
+
+	Delay uint16 `json:"delay"` // blocks for timeout
+
+	Commitment ChannelStateCommitment `json:"statecom"`
+
+	LastUpdate uint64 `json:"updateunix"` // unix timestamp (milliseconds)
+}
+
+// ChannelCloseData is where and when a channel's funding output was spent.
+type ChannelCloseData struct {
+	CloseTxid   [32]byte `json:"txid"`
+	CloseHeight int32    `json:"height"`
+	Closed      bool     `json:"closed"`
+}
+
+// ChannelStateCommitment is the stored form of the current state commitment
+// of a channel.
+type ChannelStateCommitment struct {
+	StateIdx  uint64 `json:"idx"`
+	WatchUpTo uint64 `json:"watchupto"`
+
+	MyAmt int64 `json:"amt"`
+	Fee   int64 `json:"fee"`
+
+	// JusticeFee is what our justice txs pay; 0 for old channels.
+	JusticeFee int64 `json:"justicefee"`
+
+	Data [32]byte `json:"miscdata"`
+
+	Delta     int32 `json:"delta"`
+	Collision int32 `json:"collision"`
+
+	ElkPoint     [33]byte `json:"elkp0"`
+	NextElkPoint [33]byte `json:"elkp1"`
+	N2ElkPoint   [33]byte `json:"elkp2"`
+
+	Sig [64]byte `json:"sig"`
+
+	HTLCIdx       uint32       `json:"htlcidx"`
+	InProgHTLC    *ChannelHTLC `json:"iphtlc"`
+	CollidingHTLC *ChannelHTLC `json:"collhtlc"`
+
+	CollidingHashDelta     bool `json:"colhd"`
+	CollidingHashPreimage  bool `json:"colhp"`
+	CollidingPreimages     bool `json:"colpp"`
+	CollidingPreimageDelta bool `json:"colpd"`
+
+	NextHTLCBase   [33]byte `json:"rnexthtlcbase"`
+	N2HTLCBase     [33]byte `json:"rnexthtlcbase2"`
+	MyNextHTLCBase [33]byte `json:"mnexthtlcbase"`
+	MyN2HTLCBase   [33]byte `json:"mnexthtlcbase2"`
+
+	HTLCs []ChannelHTLC `json:"htlcs"`
+
+	Failed bool `json:"failed"`
+
+	// Recovered means the channel came from a static backup and we don't
+	// have its state; all we can do is wait for the peer to break it.
+	Recovered bool `json:"recovered"`
+}
+
+// ChannelHTLC is the stored form of an HTLC in a channel state.
+type ChannelHTLC struct {
+	Idx uint32 `json:"idx"`
+
+	Incoming bool     `json:"incoming"`
+	Amt      int64    `json:"amt"`
+	RHash    [32]byte `json:"hash"`
+	Locktime uint32   `json:"locktime"`
+
+	MyHTLCBase    [33]byte `json:"mhtlcbase"`
+	TheirHTLCBase [33]byte `json:"rhtlcbase"`
+
+	KeyGen portxo.KeyGen `json:"keygen"`
+
+	Sig [64]byte `json:"sig"`
+
+	R              [16]byte `json:"preimage"`
+	Clearing       bool     `json:"clearing"`
+	Cleared        bool     `json:"cleared"`
+	ClearedOnChain bool     `json:"clearedoc"`
 }
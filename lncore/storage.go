@@ -1,5 +1,11 @@
 package lncore
 
+import (
+	"github.com/mit-dci/lit/btcutil/chaincfg/chainhash"
+	"github.com/mit-dci/lit/portxo"
+	"github.com/mit-dci/lit/wire"
+)
+
 // CoinSpecific is a meta-interface for coin-specific types.
 type CoinSpecific interface {
 	GetCoinTypeId() int32
@@ -19,22 +25,78 @@ type LitStorage interface {
 	Check() error
 }
 
-// LitWalletStorage is storage for wallet data.
+// LitWalletStorage is storage for wallet data.  There's one of these for each
+// coin type.
 type LitWalletStorage interface {
 	CoinSpecific
 
+	// Addresses we watch for incoming coins, and how we make their keys.
 	GetAddresses() ([]CoinAddress, error)
+	GetAddress(pkh [20]byte) (*CoinAddress, error)
+	AddAddress(CoinAddress) error
+
+	// Number of wallet keys we've handed out so far.
+	GetNumKeys() (uint32, error)
+	SetNumKeys(uint32) error
 
 	GetUtxos() ([]Utxo, error)
+	GetUtxo(op wire.OutPoint) (*Utxo, error)
 	AddUtxo(Utxo) error
 	RemoveUtxo(Utxo) error
 
-	// TODO More
+	// Outpoints we don't own but watch for someone else (channels, mostly).
+	GetWatchedOutPoints() ([]wire.OutPoint, error)
+	IsWatchedOutPoint(op wire.OutPoint) (bool, error)
+	AddWatchedOutPoint(op wire.OutPoint) error
+	RemoveWatchedOutPoint(op wire.OutPoint) error
+
+	// Utxos the user has locked; coin selection leaves them alone.  Locks
+	// added with an expiry (unix time) lapse after it.  Removing a utxo
+	// removes its lock too.
+	GetLockedOutPoints() ([]wire.OutPoint, error)
+	IsLockedOutPoint(op wire.OutPoint) (bool, error)
+	AddLockedOutPoint(op wire.OutPoint) error
+	AddLockedOutPointUntil(op wire.OutPoint, expiry int64) error
+	RemoveLockedOutPoint(op wire.OutPoint) error
+
+	// Free text labels on outpoints and addresses.  Setting an empty label
+	// removes it.
+	GetOutPointLabel(op wire.OutPoint) (string, error)
+	SetOutPointLabel(op wire.OutPoint, label string) error
+	GetAddressLabel(pkh [20]byte) (string, error)
+	SetAddressLabel(pkh [20]byte, label string) error
+
+	GetStxos() ([]Stxo, error)
+	GetStxo(op wire.OutPoint) (*Stxo, error)
+	AddStxo(Stxo) error
+
+	GetFrozenTxs() ([]FrozenTx, error)
+	AddFrozenTx(FrozenTx) error
+	RemoveFrozenTx(txid chainhash.Hash) error
+
+	// Fee bumps of unconfirmed txs, keyed by the new txid.
+	GetReplacements() ([]Replacement, error)
+	AddReplacement(Replacement) error
+	RemoveReplacement(newTxid chainhash.Hash) error
+
+	// Transactions we care about, for replays.
+	GetTx(txid chainhash.Hash) (*wire.MsgTx, error)
+	AddTx(tx *wire.MsgTx) error
+
+	// Height we've ingested all the blocks up to.
+	GetSyncHeight() (int32, error)
+	SetSyncHeight(int32) error
+
+	// Batch calls the function with a storage that does all of its writes
+	// atomically.  If the function returns an error none of them happen.
+	Batch(func(LitWalletStorage) error) error
 }
 
+// CoinAddress is an address in a wallet and the path to its key.
 type CoinAddress struct {
-	cointype int32
-	addr     []byte
+	CoinType int32         `json:"cointype"`
+	PKH      [20]byte      `json:"pkh"`
+	KeyGen   portxo.KeyGen `json:"keygen"`
 }
 
 // AreCoinsCompatible checks to see if two coin-specific objects are for the same coin.
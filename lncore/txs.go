@@ -1,5 +1,15 @@
 package lncore
 
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/mit-dci/lit/btcutil/chaincfg/chainhash"
+	"github.com/mit-dci/lit/portxo"
+	"github.com/mit-dci/lit/wire"
+)
+
 // Txid represents a transaction on the blockchain.
 type Txid struct {
 	cointype int32
@@ -8,5 +18,106 @@ type Txid struct {
 
 // Utxo is an unspent transaction output that we could be able to spend.
 type Utxo struct {
-	Txid
+	portxo.PorTxo
+}
+
+// Stxo is a utxo that has moved on.
+type Stxo struct {
+	PorTxo      portxo.PorTxo  `json:"txo"`    // when it used to be a utxo
+	SpendHeight int32          `json:"height"` // height at which it met its demise
+	SpendTxid   chainhash.Hash `json:"txid"`   // the tx that consumed it
+}
+
+// FrozenTx is a tx we've built but not signed yet.  Its inputs shouldn't be
+// used for anything else until it's either sent or cancelled.
+type FrozenTx struct {
+	Ins       []*portxo.PorTxo `json:"ins"`
+	Outs      []*wire.TxOut    `json:"outs"`
+	ChangeOut *wire.TxOut      `json:"changeout"`
+	Nlock     uint32           `json:"nlocktime"`
+	Txid      chainhash.Hash   `json:"txid"`
+}
+
+// Replacement is a tx we made to speed up one of our unconfirmed txs.  With
+// RBF it double spends the original; with CPFP it spends one of the
+// original's outputs.  Kept until one of the two confirms.
+type Replacement struct {
+	Orig       chainhash.Hash `json:"orig"`
+	New        chainhash.Hash `json:"new"`
+	CPFP       bool           `json:"cpfp"`
+	FeePerByte int64          `json:"feerate"` // rate the bump was for
+}
+
+/*----- serialization for stxos ------- */
+/* Stxo serialization:
+bytelength   desc   at offset
+
+53			portxo		0
+4			sheight		53
+32			stxid		57
+
+end len 	89
+*/
+
+// ToBytes turns an Stxo into some bytes.
+// prevUtxo serialization, then spendheight [4], spendtxid [32]
+func (s *Stxo) ToBytes() ([]byte, error) {
+	var buf bytes.Buffer
+
+	// serialize the utxo part
+	uBytes, err := s.PorTxo.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	// write that into the buffer
+	_, err = buf.Write(uBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	// write 4 byte height where the txo was spent
+	err = binary.Write(&buf, binary.BigEndian, s.SpendHeight)
+	if err != nil {
+		return nil, err
+	}
+	// write 32 byte txid of the spending transaction
+	_, err = buf.Write(s.SpendTxid.CloneBytes())
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// StxoFromBytes turns bytes into a Stxo.
+// it's a portxo with a spendHeight and spendTxid at the end.
+func StxoFromBytes(b []byte) (Stxo, error) {
+	var s Stxo
+
+	l := len(b)
+	if l < 96 {
+		return s, fmt.Errorf("Got %d bytes for stxo, expect a bunch", len(b))
+	}
+
+	// last 36 bytes are height & spend txid.
+	u, err := portxo.PorTxoFromBytes(b[:l-36])
+	if err != nil {
+		return s, err
+	}
+
+	buf := bytes.NewBuffer(b[l-36:])
+	// read 4 byte spend height
+	err = binary.Read(buf, binary.BigEndian, &s.SpendHeight)
+	if err != nil {
+		return s, err
+	}
+	// read 32 byte txid
+	err = s.SpendTxid.SetBytes(buf.Next(32))
+	if err != nil {
+		return s, err
+	}
+
+	s.PorTxo = *u // assign the utxo
+
+	return s, nil
 }
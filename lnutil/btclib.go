@@ -4,9 +4,12 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"strconv"
+	"strings"
 
 	"github.com/mit-dci/lit/btcutil"
 	"github.com/mit-dci/lit/btcutil/blockchain"
+	"github.com/mit-dci/lit/btcutil/chaincfg/chainhash"
 	"github.com/mit-dci/lit/btcutil/txscript"
 	"github.com/mit-dci/lit/crypto/fastsha256"
 	"github.com/mit-dci/lit/wire"
@@ -73,6 +76,26 @@ func OutPointFromBytes(b [36]byte) *wire.OutPoint {
 	return op
 }
 
+// OutPointFromString parses an outpoint in the "txid;index" form that
+// OutPoint.String() gives.  Also takes "txid:index".
+func OutPointFromString(s string) (*wire.OutPoint, error) {
+	parts := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ';' || r == ':'
+	})
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("bad outpoint %s, need txid;index", s)
+	}
+	hash, err := chainhash.NewHashFromStr(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	idx, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	return wire.NewOutPoint(hash, uint32(idx)), nil
+}
+
 // P2WSHify takes a script and turns it into a 34 byte long P2WSH PkScript
 func P2WSHify(scriptBytes []byte) []byte {
 	bldr := txscript.NewScriptBuilder()
@@ -271,3 +271,32 @@ func TestDirectWPKHScriptFromPKH(t *testing.T) {
 
 	// TODO: one more test case
 }
+
+// OutPointFromString
+// round trip through OutPoint.String(), and some bad ones
+func TestOutPointFromString(t *testing.T) {
+	var op wire.OutPoint
+	op.Hash[0] = 0xab
+	op.Hash[31] = 0x01
+	op.Index = 7
+
+	got, err := OutPointFromString(op.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !OutPointsEqual(*got, op) {
+		t.Fatalf("got %s, expected %s", got.String(), op.String())
+	}
+
+	_, err = OutPointFromString(op.Hash.String() + ":7")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, bad := range []string{"", op.Hash.String(), "zz;1", op.Hash.String() + ";-1"} {
+		_, err = OutPointFromString(bad)
+		if err == nil {
+			t.Fatalf("parsed bad outpoint %q", bad)
+		}
+	}
+}
@@ -5,9 +5,11 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"math"
 	"math/big"
 
 	"github.com/mit-dci/lit/btcutil/chaincfg/chainhash"
+	"github.com/mit-dci/lit/btcutil/txsort"
 	"github.com/mit-dci/lit/consts"
 	"github.com/mit-dci/lit/crypto/koblitz"
 	"github.com/mit-dci/lit/logging"
@@ -19,17 +21,20 @@ import (
 type DlcContractStatus int
 
 const (
-	ContractStatusDraft        DlcContractStatus = 0
-	ContractStatusOfferedByMe  DlcContractStatus = 1
-	ContractStatusOfferedToMe  DlcContractStatus = 2
-	ContractStatusDeclined     DlcContractStatus = 3
-	ContractStatusAccepted     DlcContractStatus = 4
-	ContractStatusAcknowledged DlcContractStatus = 5
-	ContractStatusActive       DlcContractStatus = 6
-	ContractStatusSettling     DlcContractStatus = 7
-	ContractStatusClosed       DlcContractStatus = 8
-	ContractStatusError        DlcContractStatus = 9
-	ContractStatusAccepting    DlcContractStatus = 10
+	ContractStatusDraft          DlcContractStatus = 0
+	ContractStatusOfferedByMe    DlcContractStatus = 1
+	ContractStatusOfferedToMe    DlcContractStatus = 2
+	ContractStatusDeclined       DlcContractStatus = 3
+	ContractStatusAccepted       DlcContractStatus = 4
+	ContractStatusAcknowledged   DlcContractStatus = 5
+	ContractStatusActive         DlcContractStatus = 6
+	ContractStatusSettling       DlcContractStatus = 7
+	ContractStatusClosed         DlcContractStatus = 8
+	ContractStatusError          DlcContractStatus = 9
+	ContractStatusAccepting      DlcContractStatus = 10
+	ContractStatusFundingPsbt    DlcContractStatus = 11 // our funding inputs are being signed elsewhere
+	ContractStatusRefunding      DlcContractStatus = 12 // the oracle never signed; refund tx sent
+	ContractStatusAwaitingOracle DlcContractStatus = 13 // past the settlement time, the oracle hasn't published
 )
 
 // scalarSize is the size of an encoded big endian scalar.
@@ -52,7 +57,8 @@ type DlcContract struct {
 	OracleA, OracleR [33]byte
 	// The time we expect the oracle to publish
 	OracleTimestamp uint64
-	// The payout specification
+	// The payout specification.  For contracts on digits, each division
+	// pays from its OracleValue up to the next one's
 	Division []DlcContractDivision
 	// The amounts either side are funding
 	OurFundingAmount, TheirFundingAmount int64
@@ -69,11 +75,49 @@ type DlcContract struct {
 	Status DlcContractStatus
 	// Outpoints used to fund the contract
 	OurFundingInputs, TheirFundingInputs []DlcContractFundingInput
-	// Signatures for the settlement transactions
+	// Signatures for the settlement transactions: one for each outcome and
+	// set of oracles, in the order of OracleSets
 	TheirSettlementSignatures []DlcContractSettlementSignature
 	// The outpoint of the funding TX we want to spend in the settlement
 	// for easier monitoring
 	FundingOutpoint wire.OutPoint
+	// Fee rate for the settlement tx, set by the offerer.  0 for contracts
+	// from before it was negotiated, which pay DlcSettlementTxFee
+	FeePerByte int64
+	// Absolute locktime (unix time) of the refund tx, which gives both sides
+	// back what they funded if the oracle never signs.  0 for contracts from
+	// before refunds
+	RefundLockTime uint32
+	// Signature for the refund transaction
+	TheirRefundSignature [64]byte
+	// Oracles besides OracleA the contract settles on, for contracts settled
+	// by any OracleThreshold of them signing the same outcome
+	Oracles []DlcContractOracle
+	// How many of the oracles have to sign an outcome to settle on it; 0
+	// for contracts on one oracle
+	OracleThreshold uint32
+	// For contracts on numbers too big to sign each value of, the oracles
+	// sign the value digit by digit, most significant first, each with its
+	// own R-point.  0 digits for contracts on a value signed whole
+	OracleDigits, OracleBase uint32
+	// The R-points OracleA signs the digits with
+	OracleDigitR [][33]byte
+}
+
+// DlcContractOracle is an oracle a contract settles on, and the R-point it
+// signs the outcome with, or the R-points of each digit
+type DlcContractOracle struct {
+	A, R   [33]byte
+	DigitR [][33]byte
+}
+
+// DlcContractOutcome is an outcome of a contract there's a settlement tx
+// for: an oracle value, or for contracts on digits, all the values starting
+// with Digits, the lowest of which is OracleValue.  We receive ValueOurs.
+type DlcContractOutcome struct {
+	OracleValue int64
+	Digits      []int
+	ValueOurs   int64
 }
 
 // DlcContractDivision describes a single division of the contract. If the
@@ -199,6 +243,10 @@ func DlcContractFromBytes(b []byte) (*DlcContract, error) {
 		return nil, err
 	}
 
+	// each division is at least two bytes
+	if divisionLen > uint64(buf.Len()/2) {
+		return nil, fmt.Errorf("%d divisions in %d bytes", divisionLen, buf.Len())
+	}
 	c.Division = make([]DlcContractDivision, divisionLen)
 	for i := uint64(0); i < divisionLen; i++ {
 		oracleValue, err := wire.ReadVarInt(buf, 0)
@@ -232,6 +280,67 @@ func DlcContractFromBytes(b []byte) (*DlcContract, error) {
 	copy(op[:], buf.Next(36))
 	c.FundingOutpoint = *OutPointFromBytes(op)
 
+	// older contracts end here
+	if buf.Len() > 0 {
+		feePerByte, err := wire.ReadVarInt(buf, 0)
+		if err != nil {
+			return nil, err
+		}
+		c.FeePerByte = int64(feePerByte)
+	}
+
+	// and these before refunds
+	if buf.Len() > 0 {
+		err = binary.Read(buf, binary.BigEndian, &c.RefundLockTime)
+		if err != nil {
+			return nil, err
+		}
+		copy(c.TheirRefundSignature[:], buf.Next(64))
+	}
+
+	// and these before contracts on several oracles
+	if buf.Len() > 0 {
+		oracleCount, err := wire.ReadVarInt(buf, 0)
+		if err != nil {
+			return nil, err
+		}
+		if oracleCount > uint64(buf.Len())/66 {
+			return nil, fmt.Errorf("%d oracles in %d bytes", oracleCount, buf.Len())
+		}
+		c.Oracles = make([]DlcContractOracle, oracleCount)
+		for i := range c.Oracles {
+			copy(c.Oracles[i].A[:], buf.Next(33))
+			copy(c.Oracles[i].R[:], buf.Next(33))
+		}
+		threshold, err := wire.ReadVarInt(buf, 0)
+		if err != nil {
+			return nil, err
+		}
+		c.OracleThreshold = uint32(threshold)
+	}
+
+	// and these before contracts on digits
+	if buf.Len() > 0 {
+		digits, err := wire.ReadVarInt(buf, 0)
+		if err != nil {
+			return nil, err
+		}
+		base, err := wire.ReadVarInt(buf, 0)
+		if err != nil {
+			return nil, err
+		}
+		if digits*uint64(len(c.Oracles)+1) > uint64(buf.Len())/33 {
+			return nil, fmt.Errorf("%d digit R-points in %d bytes", digits,
+				buf.Len())
+		}
+		c.OracleDigits = uint32(digits)
+		c.OracleBase = uint32(base)
+		c.OracleDigitR = readRPoints(buf, digits)
+		for i := range c.Oracles {
+			c.Oracles[i].DigitR = readRPoints(buf, digits)
+		}
+	}
+
 	return c, nil
 }
 
@@ -299,9 +408,332 @@ func (self *DlcContract) Bytes() []byte {
 	opArr := OutPointToBytes(self.FundingOutpoint)
 	buf.Write(opArr[:])
 
+	wire.WriteVarInt(&buf, 0, uint64(self.FeePerByte))
+
+	binary.Write(&buf, binary.BigEndian, self.RefundLockTime)
+	buf.Write(self.TheirRefundSignature[:])
+
+	wire.WriteVarInt(&buf, 0, uint64(len(self.Oracles)))
+	for _, o := range self.Oracles {
+		buf.Write(o.A[:])
+		buf.Write(o.R[:])
+	}
+	wire.WriteVarInt(&buf, 0, uint64(self.OracleThreshold))
+
+	wire.WriteVarInt(&buf, 0, uint64(self.OracleDigits))
+	wire.WriteVarInt(&buf, 0, uint64(self.OracleBase))
+	for _, o := range self.AllOracles() {
+		for i := 0; i < int(self.OracleDigits); i++ {
+			var r [33]byte
+			if i < len(o.DigitR) {
+				r = o.DigitR[i]
+			}
+			buf.Write(r[:])
+		}
+	}
+
 	return buf.Bytes()
 }
 
+// readRPoints reads n R-points, or nil for none
+func readRPoints(buf *bytes.Buffer, n uint64) [][33]byte {
+	if n == 0 {
+		return nil
+	}
+	rPoints := make([][33]byte, n)
+	for i := range rPoints {
+		copy(rPoints[i][:], buf.Next(33))
+	}
+	return rPoints
+}
+
+// SettlementFee is the total fee the settlement tx pays, split between both
+// sides.
+func (c *DlcContract) SettlementFee() int64 {
+	if c.FeePerByte == 0 {
+		return consts.DlcSettlementTxFee
+	}
+	return c.FeePerByte * consts.DlcSettlementTxSize
+}
+
+// AllOracles gives all the oracles the contract settles on, OracleA first.
+func (c *DlcContract) AllOracles() []DlcContractOracle {
+	return append([]DlcContractOracle{{c.OracleA, c.OracleR, c.OracleDigitR}},
+		c.Oracles...)
+}
+
+// Threshold gives how many oracles have to sign an outcome to settle on it.
+func (c *DlcContract) Threshold() int {
+	if c.OracleThreshold == 0 {
+		return 1
+	}
+	return int(c.OracleThreshold)
+}
+
+// OracleSets gives each set of Threshold of the contract's oracles, as
+// indexes into AllOracles.  There's a settlement tx for each division and
+// set, in this order.  It's empty if there aren't enough oracles.
+func (c *DlcContract) OracleSets() [][]int {
+	var sets [][]int
+	var pick func(set []int, from int)
+	pick = func(set []int, from int) {
+		if len(set) == c.Threshold() {
+			sets = append(sets, append([]int(nil), set...))
+			return
+		}
+		for i := from; i < len(c.Oracles)+1; i++ {
+			pick(append(set, i), i+1)
+		}
+	}
+	pick(nil, 0)
+	return sets
+}
+
+// SettlementCount gives how many settlement txs the contract has: one for
+// each outcome and set of oracles.
+func (c *DlcContract) SettlementCount() int {
+	return len(c.Outcomes()) * len(c.OracleSets())
+}
+
+// OracleSetIdx gives where a set of oracles is in OracleSets.
+func (c *DlcContract) OracleSetIdx(set []int) (int, error) {
+	for i, s := range c.OracleSets() {
+		same := len(s) == len(set)
+		for j := 0; same && j < len(s); j++ {
+			same = s[j] == set[j]
+		}
+		if same {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("oracles %v aren't a set the contract settles on", set)
+}
+
+// OracleSetSigPub gives the sum of the pubkeys of the signatures the oracles
+// in set publish outcome o with; for contracts on digits, of each of the
+// digits o starts with.  A settlement on their say pays to it combined with
+// the payout base, and it's claimed with the sum of their signatures.
+func (c *DlcContract) OracleSetSigPub(set []int,
+	o DlcContractOutcome) ([33]byte, error) {
+
+	var sum [33]byte
+	oracles := c.AllOracles()
+	curve := koblitz.S256()
+	P := new(koblitz.PublicKey)
+	add := func(A, R [33]byte, value int64) error {
+		sigPub, err := DlcCalcOracleSignaturePubKey(DlcOracleMessage(value),
+			A, R)
+		if err != nil {
+			return err
+		}
+		pub, err := koblitz.ParsePubKey(sigPub[:], curve)
+		if err != nil {
+			return err
+		}
+		if P.X == nil {
+			P.X, P.Y = pub.X, pub.Y
+		} else {
+			P.X, P.Y = curve.Add(P.X, P.Y, pub.X, pub.Y)
+		}
+		return nil
+	}
+	for _, i := range set {
+		if i < 0 || i >= len(oracles) {
+			return sum, fmt.Errorf("no oracle %d in contract", i)
+		}
+		if c.OracleDigits == 0 {
+			err := add(oracles[i].A, oracles[i].R, o.OracleValue)
+			if err != nil {
+				return sum, err
+			}
+			continue
+		}
+		if len(o.Digits) > len(oracles[i].DigitR) {
+			return sum, fmt.Errorf("oracle %d has %d digit R-points, need %d",
+				i, len(oracles[i].DigitR), len(o.Digits))
+		}
+		for j, digit := range o.Digits {
+			err := add(oracles[i].A, oracles[i].DigitR[j], int64(digit))
+			if err != nil {
+				return sum, err
+			}
+		}
+	}
+	if P.X == nil {
+		return sum, fmt.Errorf("no oracles in set")
+	}
+	copy(sum[:], P.SerializeCompressed())
+	return sum, nil
+}
+
+// MaxOracleValue gives the highest value the oracles can sign the digits of,
+// or -1 if the contract isn't on digits or they're more than an int64 holds.
+func (c *DlcContract) MaxOracleValue() int64 {
+	if c.OracleDigits == 0 || c.OracleBase < 2 {
+		return -1
+	}
+	max := int64(1)
+	for i := uint32(0); i < c.OracleDigits; i++ {
+		if max > math.MaxInt64/int64(c.OracleBase) {
+			return -1
+		}
+		max *= int64(c.OracleBase)
+	}
+	return max - 1
+}
+
+// ValueDigits gives the digits the oracles sign value in, most significant
+// first.
+func (c *DlcContract) ValueDigits(value int64) ([]int, error) {
+	max := c.MaxOracleValue()
+	if max < 0 {
+		return nil, fmt.Errorf("contract %d isn't on digits", c.Idx)
+	}
+	if value < 0 || value > max {
+		return nil, fmt.Errorf("value %d isn't between 0 and %d", value, max)
+	}
+	digits := make([]int, c.OracleDigits)
+	for i := len(digits) - 1; i >= 0; i-- {
+		digits[i] = int(value % int64(c.OracleBase))
+		value /= int64(c.OracleBase)
+	}
+	return digits, nil
+}
+
+// Outcomes gives the outcomes of the contract there's a settlement tx for.
+// For contracts on a value signed whole, it's one for each division.  For
+// contracts on digits, the divisions are taken as steps, and each range of
+// values paying the same is covered by as few digit prefixes as will do.
+// The first division pays for the values below it too.
+func (c *DlcContract) Outcomes() []DlcContractOutcome {
+	var outcomes []DlcContractOutcome
+	if c.OracleDigits == 0 {
+		for _, d := range c.Division {
+			outcomes = append(outcomes,
+				DlcContractOutcome{OracleValue: d.OracleValue, ValueOurs: d.ValueOurs})
+		}
+		return outcomes
+	}
+
+	max := c.MaxOracleValue()
+	if max < 0 {
+		return nil
+	}
+	for i := 0; i < len(c.Division); i++ {
+		lo := c.Division[i].OracleValue
+		if i == 0 || lo < 0 {
+			lo = 0
+		}
+		if lo > max {
+			break
+		}
+		// steps paying the same are one range
+		j := i + 1
+		for j < len(c.Division) &&
+			c.Division[j].ValueOurs == c.Division[i].ValueOurs {
+			j++
+		}
+		hi := max
+		if j < len(c.Division) && c.Division[j].OracleValue-1 < max {
+			hi = c.Division[j].OracleValue - 1
+		}
+		if hi >= lo {
+			outcomes = c.coverRange(outcomes, nil, 0, max+1, lo, hi,
+				c.Division[i].ValueOurs)
+		}
+		i = j - 1
+	}
+	return outcomes
+}
+
+// coverRange adds the outcomes covering the values lo to hi which start with
+// prefix, the values of which are from start up to before end.
+func (c *DlcContract) coverRange(outcomes []DlcContractOutcome, prefix []int,
+	start, end, lo, hi, valueOurs int64) []DlcContractOutcome {
+
+	if end-1 < lo || start > hi {
+		return outcomes
+	}
+	// the settlement has to be on at least one digit
+	if len(prefix) > 0 && start >= lo && end-1 <= hi {
+		return append(outcomes, DlcContractOutcome{
+			OracleValue: start,
+			Digits:      append([]int(nil), prefix...),
+			ValueOurs:   valueOurs,
+		})
+	}
+	step := (end - start) / int64(c.OracleBase)
+	for digit := 0; digit < int(c.OracleBase); digit++ {
+		from := start + int64(digit)*step
+		outcomes = c.coverRange(outcomes, append(prefix, digit), from,
+			from+step, lo, hi, valueOurs)
+	}
+	return outcomes
+}
+
+// GetOutcome gives the outcome of the contract the oracles signing value
+// settles it on.
+func (c *DlcContract) GetOutcome(value int64) (*DlcContractOutcome, error) {
+	if c.OracleDigits == 0 {
+		d, err := c.GetDivision(value)
+		if err != nil {
+			return nil, err
+		}
+		return &DlcContractOutcome{OracleValue: d.OracleValue,
+			ValueOurs: d.ValueOurs}, nil
+	}
+
+	digits, err := c.ValueDigits(value)
+	if err != nil {
+		return nil, err
+	}
+	for _, o := range c.Outcomes() {
+		match := len(o.Digits) <= len(digits)
+		for i := 0; match && i < len(o.Digits); i++ {
+			match = o.Digits[i] == digits[i]
+		}
+		if match {
+			return &o, nil
+		}
+	}
+	return nil, fmt.Errorf("Outcome not found in contract")
+}
+
+// VerifyOracleSigs checks the signatures the oracle at idx in AllOracles
+// published value with: one on the whole value, or one on each of its
+// digits.
+func (c *DlcContract) VerifyOracleSigs(idx int, value int64,
+	sigs [][32]byte) error {
+
+	oracles := c.AllOracles()
+	if idx < 0 || idx >= len(oracles) {
+		return fmt.Errorf("no oracle %d in contract", idx)
+	}
+	o := oracles[idx]
+	if c.OracleDigits == 0 {
+		if len(sigs) != 1 {
+			return fmt.Errorf("got %d signatures for a whole value", len(sigs))
+		}
+		return DlcVerifyOracleSig(value, sigs[0], o.A, o.R)
+	}
+
+	digits, err := c.ValueDigits(value)
+	if err != nil {
+		return err
+	}
+	if len(sigs) != len(digits) || len(o.DigitR) != len(digits) {
+		return fmt.Errorf("got %d signatures for %d digits", len(sigs),
+			len(digits))
+	}
+	for i, digit := range digits {
+		err = DlcVerifyOracleSig(int64(digit), sigs[i], o.A, o.DigitR[i])
+		if err != nil {
+			return fmt.Errorf("digit %d: %s", i, err.Error())
+		}
+	}
+	return nil
+}
+
 // GetDivision loops over all division specifications inside the contract and
 // returns the one matching the requested oracle value
 func (c DlcContract) GetDivision(value int64) (*DlcContractDivision, error) {
@@ -315,13 +747,19 @@ func (c DlcContract) GetDivision(value int64) (*DlcContractDivision, error) {
 }
 
 // GetTheirSettlementSignature loops over all stored settlement signatures from
-// the counter party and returns the one matching the requested oracle value
-func (c DlcContract) GetTheirSettlementSignature(val int64) ([64]byte, error) {
+// the counter party and returns the one matching the requested oracle value,
+// for the set of oracles at setIdx in OracleSets
+func (c DlcContract) GetTheirSettlementSignature(val int64,
+	setIdx int) ([64]byte, error) {
 
 	for _, s := range c.TheirSettlementSignatures {
-		if s.Outcome == val {
+		if s.Outcome != val {
+			continue
+		}
+		if setIdx == 0 {
 			return s.Signature, nil
 		}
+		setIdx--
 	}
 
 	return [64]byte{}, fmt.Errorf("Signature not found in contract")
@@ -392,6 +830,33 @@ func DlcCalcOracleSignaturePubKey(msg []byte, oracleA,
 	return computePubKey(oracleA, oracleR, msg)
 }
 
+// DlcOracleMessage gives the message an oracle signs to publish value.
+func DlcOracleMessage(value int64) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint64(0))
+	binary.Write(&buf, binary.BigEndian, uint64(0))
+	binary.Write(&buf, binary.BigEndian, uint64(0))
+	binary.Write(&buf, binary.BigEndian, value)
+	return buf.Bytes()
+}
+
+// DlcVerifyOracleSig checks oracleSig is the signature of the oracle with
+// pubkey oracleA on value, using R-point oracleR.
+func DlcVerifyOracleSig(value int64, oracleSig [32]byte, oracleA,
+	oracleR [33]byte) error {
+
+	expected, err := DlcCalcOracleSignaturePubKey(DlcOracleMessage(value),
+		oracleA, oracleR)
+	if err != nil {
+		return err
+	}
+	_, pub := koblitz.PrivKeyFromBytes(koblitz.S256(), oracleSig[:])
+	if !bytes.Equal(pub.SerializeCompressed(), expected[:]) {
+		return fmt.Errorf("oracle signature isn't for value %d", value)
+	}
+	return nil
+}
+
 // calculates P = pubR - h(msg, pubR)pubA
 func computePubKey(pubA, pubR [33]byte, msg []byte) ([33]byte, error) {
 	var returnValue [33]byte
@@ -435,10 +900,10 @@ func computePubKey(pubA, pubR [33]byte, msg []byte) ([33]byte, error) {
 	return returnValue, nil
 }
 
-// SettlementTx returns the transaction to settle the contract. ours = the one
-// we generate & sign. Theirs (ours = false) = the one they generated, so we can
-// use their sigs
-func SettlementTx(c *DlcContract, d DlcContractDivision,
+// SettlementTx returns the transaction to settle the contract on the say of
+// the oracles in set. ours = the one we generate & sign. Theirs (ours = false)
+// = the one they generated, so we can use their sigs
+func SettlementTx(c *DlcContract, d DlcContractOutcome, set []int,
 	ours bool) (*wire.MsgTx, error) {
 
 	tx := wire.NewMsgTx()
@@ -447,7 +912,7 @@ func SettlementTx(c *DlcContract, d DlcContractDivision,
 
 	tx.AddTxIn(wire.NewTxIn(&c.FundingOutpoint, nil, nil))
 
-	totalFee := int64(consts.DlcSettlementTxFee) // TODO: Calculate
+	totalFee := c.SettlementFee()
 	feeEach := int64(float64(totalFee) / float64(2))
 	feeOurs := feeEach
 	feeTheirs := feeEach
@@ -472,13 +937,7 @@ func SettlementTx(c *DlcContract, d DlcContractDivision,
 		valueTheirs -= feeTheirs
 	}
 
-	var buf bytes.Buffer
-	binary.Write(&buf, binary.BigEndian, uint64(0))
-	binary.Write(&buf, binary.BigEndian, uint64(0))
-	binary.Write(&buf, binary.BigEndian, uint64(0))
-	binary.Write(&buf, binary.BigEndian, d.OracleValue)
-	oracleSigPub, err := DlcCalcOracleSignaturePubKey(buf.Bytes(),
-		c.OracleA, c.OracleR)
+	oracleSigPub, err := c.OracleSetSigPub(set, d)
 	if err != nil {
 		return nil, err
 	}
@@ -509,3 +968,52 @@ func SettlementTx(c *DlcContract, d DlcContractDivision,
 
 	return tx, nil
 }
+
+// RefundTx returns the transaction giving both sides back what they funded
+// the contract with, for if the oracle never signs.  It can't be mined before
+// RefundLockTime.  Both sides build the same tx, so either can publish it.
+func RefundTx(c *DlcContract) (*wire.MsgTx, error) {
+	if c.RefundLockTime == 0 {
+		return nil, fmt.Errorf("contract %d has no refund", c.Idx)
+	}
+
+	tx := wire.NewMsgTx()
+	tx.Version = 2
+	tx.LockTime = c.RefundLockTime
+
+	// the locktime only holds if the input isn't final
+	in := wire.NewTxIn(&c.FundingOutpoint, nil, nil)
+	in.Sequence = wire.MaxTxInSequenceNum - 1
+	tx.AddTxIn(in)
+
+	// it's no bigger than a settlement tx, so pays the same fee, split
+	// between both sides.  If one side can't pay its half the other pays
+	// the rest.
+	totalFee := c.SettlementFee()
+	valueOurs := c.OurFundingAmount - totalFee/2
+	valueTheirs := c.TheirFundingAmount - (totalFee - totalFee/2)
+	if valueOurs < 0 {
+		valueTheirs += valueOurs
+		valueOurs = 0
+	}
+	if valueTheirs < 0 {
+		valueOurs += valueTheirs
+		valueTheirs = 0
+	}
+	if valueOurs <= 0 && valueTheirs <= 0 {
+		return nil, fmt.Errorf("contract %d can't pay the refund fee of %d",
+			c.Idx, totalFee)
+	}
+
+	if valueOurs > 0 {
+		tx.AddTxOut(wire.NewTxOut(valueOurs,
+			DirectWPKHScriptFromPKH(c.OurPayoutPKH)))
+	}
+	if valueTheirs > 0 {
+		tx.AddTxOut(wire.NewTxOut(valueTheirs,
+			DirectWPKHScriptFromPKH(c.TheirPayoutPKH)))
+	}
+	txsort.InPlaceSort(tx)
+
+	return tx, nil
+}
@@ -0,0 +1,282 @@
+package lnutil
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/mit-dci/lit/btcutil/chaincfg/chainhash"
+	"github.com/mit-dci/lit/consts"
+	"github.com/mit-dci/lit/crypto/koblitz"
+)
+
+func TestDlcContractFeeRate(t *testing.T) {
+	c := new(DlcContract)
+	c.Idx = 3
+	c.OurFundingAmount = 100000
+	c.TheirFundingAmount = 50000
+	c.Division = []DlcContractDivision{{OracleValue: 1, ValueOurs: 150000}}
+	c.FeePerByte = 25
+
+	b := c.Bytes()
+	c2, err := DlcContractFromBytes(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c2.FeePerByte != 25 {
+		t.Fatalf("got fee rate %d, expected 25", c2.FeePerByte)
+	}
+	if !bytes.Equal(c2.Bytes(), b) {
+		t.Fatalf("from bytes mismatch:\n%x\n%x\n", b, c2.Bytes())
+	}
+	if c2.SettlementFee() != 25*consts.DlcSettlementTxSize {
+		t.Fatalf("settlement fee %d", c2.SettlementFee())
+	}
+
+	// contracts stored before the fee rate was added don't have it, or the
+	// refund, extra oracles and digits after it, at the end
+	c.FeePerByte = 0
+	b = c.Bytes()
+	c3, err := DlcContractFromBytes(b[:len(b)-1-4-64-2-2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c3.SettlementFee() != consts.DlcSettlementTxFee {
+		t.Fatalf("old contract settlement fee %d", c3.SettlementFee())
+	}
+}
+
+func TestDlcRefundTx(t *testing.T) {
+	c := new(DlcContract)
+	c.Idx = 4
+	c.OurFundingAmount = 100000
+	c.TheirFundingAmount = 50000
+	c.OurPayoutPKH[0] = 0x01
+	c.TheirPayoutPKH[0] = 0x02
+	c.FundingOutpoint.Index = 1
+	c.FeePerByte = 10
+	c.TheirRefundSignature[0] = 0x30
+
+	_, err := RefundTx(c)
+	if err == nil {
+		t.Fatalf("refund tx without a locktime")
+	}
+
+	c.RefundLockTime = 1600000000
+	c2, err := DlcContractFromBytes(c.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c2.RefundLockTime != c.RefundLockTime ||
+		c2.TheirRefundSignature != c.TheirRefundSignature {
+		t.Fatalf("refund didn't round trip")
+	}
+
+	tx, err := RefundTx(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx.LockTime != c.RefundLockTime {
+		t.Fatalf("locktime %d, expected %d", tx.LockTime, c.RefundLockTime)
+	}
+	if len(tx.TxIn) != 1 || tx.TxIn[0].Sequence == 0xffffffff {
+		t.Fatalf("refund input doesn't enforce the locktime")
+	}
+
+	fee := c.SettlementFee()
+	got := make(map[int64]bool)
+	for _, out := range tx.TxOut {
+		got[out.Value] = true
+	}
+	if len(tx.TxOut) != 2 || !got[100000-fee/2] || !got[50000-fee/2] {
+		t.Fatalf("refund outputs %v, fee %d", got, fee)
+	}
+
+	// the other side builds the same tx
+	c.OurFundingAmount, c.TheirFundingAmount = c.TheirFundingAmount, c.OurFundingAmount
+	c.OurPayoutPKH, c.TheirPayoutPKH = c.TheirPayoutPKH, c.OurPayoutPKH
+	tx2, err := RefundTx(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx2.TxHash() != tx.TxHash() {
+		t.Fatalf("sides build different refund txs")
+	}
+
+	// a side that can't cover its half of the fee gets nothing
+	c.OurFundingAmount = fee / 4
+	tx, err = RefundTx(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tx.TxOut) != 1 || tx.TxOut[0].Value != 100000+fee/4-fee {
+		t.Fatalf("refund with one side short: %v", tx.TxOut)
+	}
+}
+
+func TestDlcVerifyOracleSig(t *testing.T) {
+	curve := koblitz.S256()
+	a, pubA := koblitz.PrivKeyFromBytes(curve, bytes.Repeat([]byte{0x11}, 32))
+	k, pubR := koblitz.PrivKeyFromBytes(curve, bytes.Repeat([]byte{0x22}, 32))
+	var oracleA, oracleR [33]byte
+	copy(oracleA[:], pubA.SerializeCompressed())
+	copy(oracleR[:], pubR.SerializeCompressed())
+
+	// s = k - h(m, R)a
+	e := new(big.Int).SetBytes(chainhash.HashB(
+		append(DlcOracleMessage(15161), pubR.X.Bytes()...)))
+	s := new(big.Int).Mul(e, a.D)
+	s.Sub(k.D, s)
+	s.Mod(s, curve.N)
+	sig := *BigIntToEncodedBytes(s)
+
+	err := DlcVerifyOracleSig(15161, sig, oracleA, oracleR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = DlcVerifyOracleSig(15162, sig, oracleA, oracleR)
+	if err == nil {
+		t.Fatalf("signature verified for the wrong value")
+	}
+}
+
+func TestDlcOracleSets(t *testing.T) {
+	curve := koblitz.S256()
+	c := new(DlcContract)
+	var as, ks []*koblitz.PrivateKey
+	for i := byte(1); i <= 3; i++ {
+		a, pubA := koblitz.PrivKeyFromBytes(curve, bytes.Repeat([]byte{i}, 32))
+		k, pubR := koblitz.PrivKeyFromBytes(curve, bytes.Repeat([]byte{i + 0x10}, 32))
+		var o DlcContractOracle
+		copy(o.A[:], pubA.SerializeCompressed())
+		copy(o.R[:], pubR.SerializeCompressed())
+		if i == 1 {
+			c.OracleA, c.OracleR = o.A, o.R
+		} else {
+			c.Oracles = append(c.Oracles, o)
+		}
+		as = append(as, a)
+		ks = append(ks, k)
+	}
+	c.OracleThreshold = 2
+
+	sets := c.OracleSets()
+	if len(sets) != 3 {
+		t.Fatalf("got %d sets of 2 of 3 oracles, expected 3", len(sets))
+	}
+	c.Division = []DlcContractDivision{{0, 0}, {10, 500}}
+	if c.SettlementCount() != 6 {
+		t.Fatalf("%d settlements for 2 divisions and 3 sets, expected 6",
+			c.SettlementCount())
+	}
+	idx, err := c.OracleSetIdx([]int{1, 2})
+	if err != nil || idx != 2 {
+		t.Fatalf("oracles 1 and 2 at set %d (%v), expected 2", idx, err)
+	}
+
+	// the set's sig pub is the pubkey of the sum of their signatures
+	sum := new(big.Int)
+	for _, i := range sets[idx] {
+		e := new(big.Int).SetBytes(chainhash.HashB(
+			append(DlcOracleMessage(15161), ks[i].PubKey().X.Bytes()...)))
+		s := new(big.Int).Mul(e, as[i].D)
+		s.Sub(ks[i].D, s)
+		sum.Add(sum, s)
+	}
+	sum.Mod(sum, curve.N)
+	_, pub := koblitz.PrivKeyFromBytes(curve, BigIntToEncodedBytes(sum)[:])
+
+	sigPub, err := c.OracleSetSigPub(sets[idx],
+		DlcContractOutcome{OracleValue: 15161})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sigPub[:], pub.SerializeCompressed()) {
+		t.Fatalf("set sig pub %x, expected %x", sigPub, pub.SerializeCompressed())
+	}
+}
+
+func TestDlcDigitOutcomes(t *testing.T) {
+	curve := koblitz.S256()
+	a, pubA := koblitz.PrivKeyFromBytes(curve, bytes.Repeat([]byte{0x11}, 32))
+	c := new(DlcContract)
+	copy(c.OracleA[:], pubA.SerializeCompressed())
+	c.OracleDigits = 4
+	c.OracleBase = 2
+	var ks []*koblitz.PrivateKey
+	for i := byte(0); i < 4; i++ {
+		k, pubR := koblitz.PrivKeyFromBytes(curve,
+			bytes.Repeat([]byte{0x20 + i}, 32))
+		var r [33]byte
+		copy(r[:], pubR.SerializeCompressed())
+		c.OracleDigitR = append(c.OracleDigitR, r)
+		ks = append(ks, k)
+	}
+	c.Division = []DlcContractDivision{
+		{OracleValue: 0, ValueOurs: 0},
+		{OracleValue: 5, ValueOurs: 100},
+		{OracleValue: 8, ValueOurs: 100},
+		{OracleValue: 11, ValueOurs: 200},
+	}
+
+	c2, err := DlcContractFromBytes(c.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(c2.Bytes(), c.Bytes()) {
+		t.Fatalf("from bytes mismatch:\n%x\n%x\n", c.Bytes(), c2.Bytes())
+	}
+
+	// 0-4 is 00xx and 0100, 5-10 is 0101, 011x, 100x and 1010, and 11-15
+	// is 1011 and 11xx
+	outcomes := c.Outcomes()
+	if len(outcomes) != 8 {
+		t.Fatalf("got %d outcomes, expected 8: %v", len(outcomes), outcomes)
+	}
+	o, err := c.GetOutcome(9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.OracleValue != 8 || o.ValueOurs != 100 || len(o.Digits) != 3 {
+		t.Fatalf("outcome of 9 is %v, expected 100x paying 100", o)
+	}
+
+	// the oracle signs each digit of 9, 1001
+	digits, err := c.ValueDigits(9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sigs := make([][32]byte, len(digits))
+	sum := new(big.Int)
+	for i, digit := range digits {
+		e := new(big.Int).SetBytes(chainhash.HashB(
+			append(DlcOracleMessage(int64(digit)), ks[i].PubKey().X.Bytes()...)))
+		s := new(big.Int).Mul(e, a.D)
+		s.Sub(ks[i].D, s)
+		s.Mod(s, curve.N)
+		sigs[i] = *BigIntToEncodedBytes(s)
+		if i < len(o.Digits) {
+			sum.Add(sum, s)
+		}
+	}
+	err = c.VerifyOracleSigs(0, 9, sigs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.VerifyOracleSigs(0, 13, sigs) == nil {
+		t.Fatalf("signatures verified for the wrong value")
+	}
+
+	// the outcome's sig pub is the pubkey of the sum of the signatures on
+	// the digits it starts with
+	sum.Mod(sum, curve.N)
+	_, pub := koblitz.PrivKeyFromBytes(curve, BigIntToEncodedBytes(sum)[:])
+	sigPub, err := c.OracleSetSigPub([]int{0}, *o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sigPub[:], pub.SerializeCompressed()) {
+		t.Fatalf("outcome sig pub %x, expected %x", sigPub,
+			pub.SerializeCompressed())
+	}
+}
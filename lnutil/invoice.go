@@ -0,0 +1,283 @@
+package lnutil
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/mit-dci/lit/bech32"
+	"github.com/mit-dci/lit/btcutil/chaincfg/chainhash"
+	"github.com/mit-dci/lit/crypto/fastsha256"
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/lit/wire"
+)
+
+/*
+Invoices are payment requests for multihop payments, made by the payee and
+handed to the payer any way they like.  With one the payer doesn't need to
+reach the payee to get the payment hash; the payee can be offline.
+
+An invoice is bech32 with the InvoiceHRP prefix, no length limit, and data:
+
+version (1) | payee (20) | coin type (4) | amount (8) | payment hash (32) |
+timestamp (8) | expiry (4) | description (varint len + bytes) |
+route hints (varint count, then 61 bytes each) | sig (65)
+
+The sig is a compact sig by the payee's identity key over the double sha256
+of everything before it, so the payer can recover the key and check it
+matches the payee's ln address.
+*/
+
+const (
+	InvoiceHRP = "lni"
+
+	invoiceVersion = 0
+
+	// MaxInvoiceDescLen limits the description so invoices stay pasteable
+	MaxInvoiceDescLen = 640
+
+	// MaxInvoiceRouteHints limits the route hints in an invoice
+	MaxInvoiceRouteHints = 8
+
+	invoiceSigLen = 65
+)
+
+// RouteHint is a channel into the payee which the payer may not know about,
+// so the payer can find a route to a payee whose links aren't advertised.
+type RouteHint struct {
+	From     [20]byte // ln address (pkh) of the node with a channel to the payee
+	FromPub  [33]byte // identity pubkey of From, to build the onion with
+	CoinType uint32
+	Capacity int64 // how much From can send to the payee over it
+
+	// what From asks to forward over it
+	FeeBase   int64
+	FeeRate   uint32
+	CLTVDelta uint32
+}
+
+// Invoice is a signed request to be paid over a multihop route.
+type Invoice struct {
+	Payee       [20]byte // ln address (pkh) of the payee
+	CoinType    uint32   // coin the payee wants to receive
+	Amount      int64    // 0 lets the payer pick the amount
+	PaymentHash [32]byte
+	Timestamp   int64  // unix seconds when it was made
+	Expiry      uint32 // seconds after Timestamp it's good for
+	Description string
+	RouteHints  []RouteHint
+
+	Sig [invoiceSigLen]byte // compact sig by the payee's identity key
+}
+
+// PayeeAdr gives the ln address of the payee.
+func (inv *Invoice) PayeeAdr() string {
+	return bech32.Encode("ln", inv.Payee[:])
+}
+
+// ExpiresAt gives when the invoice is no longer good.
+func (inv *Invoice) ExpiresAt() time.Time {
+	return time.Unix(inv.Timestamp+int64(inv.Expiry), 0)
+}
+
+// Expired says whether the invoice is no longer good.
+func (inv *Invoice) Expired() bool {
+	return time.Now().After(inv.ExpiresAt())
+}
+
+// unsignedBytes serializes everything the sig covers.
+func (inv *Invoice) unsignedBytes() ([]byte, error) {
+	if len(inv.Description) > MaxInvoiceDescLen {
+		return nil, fmt.Errorf("description %d bytes, max %d",
+			len(inv.Description), MaxInvoiceDescLen)
+	}
+	if len(inv.RouteHints) > MaxInvoiceRouteHints {
+		return nil, fmt.Errorf("%d route hints, max %d",
+			len(inv.RouteHints), MaxInvoiceRouteHints)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte(invoiceVersion)
+	buf.Write(inv.Payee[:])
+	binary.Write(&buf, binary.BigEndian, inv.CoinType)
+	binary.Write(&buf, binary.BigEndian, inv.Amount)
+	buf.Write(inv.PaymentHash[:])
+	binary.Write(&buf, binary.BigEndian, inv.Timestamp)
+	binary.Write(&buf, binary.BigEndian, inv.Expiry)
+
+	wire.WriteVarInt(&buf, 0, uint64(len(inv.Description)))
+	buf.WriteString(inv.Description)
+
+	wire.WriteVarInt(&buf, 0, uint64(len(inv.RouteHints)))
+	for _, h := range inv.RouteHints {
+		buf.Write(h.FromPub[:])
+		binary.Write(&buf, binary.BigEndian, h.CoinType)
+		binary.Write(&buf, binary.BigEndian, h.Capacity)
+		binary.Write(&buf, binary.BigEndian, h.FeeBase)
+		binary.Write(&buf, binary.BigEndian, h.FeeRate)
+		binary.Write(&buf, binary.BigEndian, h.CLTVDelta)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Sign signs the invoice with the payee's identity key, which has to be
+// the one the Payee address is made from.
+func (inv *Invoice) Sign(priv *koblitz.PrivateKey) error {
+	idHash := fastsha256.Sum256(priv.PubKey().SerializeCompressed())
+	if !bytes.Equal(idHash[:20], inv.Payee[:]) {
+		return fmt.Errorf("key doesn't match payee %s", inv.PayeeAdr())
+	}
+
+	b, err := inv.unsignedBytes()
+	if err != nil {
+		return err
+	}
+	sig, err := koblitz.SignCompact(
+		koblitz.S256(), priv, chainhash.DoubleHashB(b), true)
+	if err != nil {
+		return err
+	}
+	copy(inv.Sig[:], sig)
+	return nil
+}
+
+// Verify checks the invoice is signed by its payee.
+func (inv *Invoice) Verify() error {
+	_, err := inv.PayeeKey()
+	return err
+}
+
+// PayeeKey gives the payee's identity pubkey, from the sig, after checking
+// it matches the payee's ln address.
+func (inv *Invoice) PayeeKey() (*koblitz.PublicKey, error) {
+	b, err := inv.unsignedBytes()
+	if err != nil {
+		return nil, err
+	}
+	pub, _, err := koblitz.RecoverCompact(
+		koblitz.S256(), inv.Sig[:], chainhash.DoubleHashB(b))
+	if err != nil {
+		return nil, fmt.Errorf("bad invoice sig: %s", err.Error())
+	}
+	idHash := fastsha256.Sum256(pub.SerializeCompressed())
+	if !bytes.Equal(idHash[:20], inv.Payee[:]) {
+		return nil, fmt.Errorf("invoice not signed by payee %s", inv.PayeeAdr())
+	}
+	return pub, nil
+}
+
+// Encode gives the bech32 string of a signed invoice.
+func (inv *Invoice) Encode() (string, error) {
+	b, err := inv.unsignedBytes()
+	if err != nil {
+		return "", err
+	}
+	return bech32.Encode(InvoiceHRP, append(b, inv.Sig[:]...)), nil
+}
+
+// DecodeInvoice parses an invoice string and checks its sig.  It doesn't
+// check whether it has expired.
+func DecodeInvoice(s string) (*Invoice, error) {
+	hrp, data, err := bech32.Decode(s)
+	if err != nil {
+		return nil, err
+	}
+	if hrp != InvoiceHRP {
+		return nil, fmt.Errorf("prefix %s is not an invoice", hrp)
+	}
+	if len(data) < invoiceSigLen+1 {
+		return nil, fmt.Errorf("invoice too short")
+	}
+
+	inv := new(Invoice)
+	buf := bytes.NewBuffer(data[:len(data)-invoiceSigLen])
+	copy(inv.Sig[:], data[len(data)-invoiceSigLen:])
+
+	version, _ := buf.ReadByte()
+	if version != invoiceVersion {
+		return nil, fmt.Errorf("unknown invoice version %d", version)
+	}
+	_, err = io.ReadFull(buf, inv.Payee[:])
+	if err != nil {
+		return nil, err
+	}
+	err = binary.Read(buf, binary.BigEndian, &inv.CoinType)
+	if err != nil {
+		return nil, err
+	}
+	err = binary.Read(buf, binary.BigEndian, &inv.Amount)
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.ReadFull(buf, inv.PaymentHash[:])
+	if err != nil {
+		return nil, err
+	}
+	err = binary.Read(buf, binary.BigEndian, &inv.Timestamp)
+	if err != nil {
+		return nil, err
+	}
+	err = binary.Read(buf, binary.BigEndian, &inv.Expiry)
+	if err != nil {
+		return nil, err
+	}
+
+	descLen, err := wire.ReadVarInt(buf, 0)
+	if err != nil {
+		return nil, err
+	}
+	if descLen > MaxInvoiceDescLen || descLen > uint64(buf.Len()) {
+		return nil, fmt.Errorf("bad description length %d", descLen)
+	}
+	inv.Description = string(buf.Next(int(descLen)))
+
+	nHints, err := wire.ReadVarInt(buf, 0)
+	if err != nil {
+		return nil, err
+	}
+	if nHints > MaxInvoiceRouteHints {
+		return nil, fmt.Errorf("%d route hints, max %d", nHints, MaxInvoiceRouteHints)
+	}
+	for i := uint64(0); i < nHints; i++ {
+		var h RouteHint
+		_, err = io.ReadFull(buf, h.FromPub[:])
+		if err != nil {
+			return nil, err
+		}
+		idHash := fastsha256.Sum256(h.FromPub[:])
+		copy(h.From[:], idHash[:20])
+		err = binary.Read(buf, binary.BigEndian, &h.CoinType)
+		if err != nil {
+			return nil, err
+		}
+		err = binary.Read(buf, binary.BigEndian, &h.Capacity)
+		if err != nil {
+			return nil, err
+		}
+		err = binary.Read(buf, binary.BigEndian, &h.FeeBase)
+		if err != nil {
+			return nil, err
+		}
+		err = binary.Read(buf, binary.BigEndian, &h.FeeRate)
+		if err != nil {
+			return nil, err
+		}
+		err = binary.Read(buf, binary.BigEndian, &h.CLTVDelta)
+		if err != nil {
+			return nil, err
+		}
+		inv.RouteHints = append(inv.RouteHints, h)
+	}
+	if buf.Len() != 0 {
+		return nil, fmt.Errorf("%d extra bytes in invoice", buf.Len())
+	}
+
+	err = inv.Verify()
+	if err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
@@ -0,0 +1,98 @@
+package lnutil
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mit-dci/lit/btcutil/chaincfg/chainhash"
+	"github.com/mit-dci/lit/crypto/fastsha256"
+	"github.com/mit-dci/lit/crypto/koblitz"
+)
+
+func testInvoice(t *testing.T) (*Invoice, *koblitz.PrivateKey) {
+	priv, _ := koblitz.PrivKeyFromBytes(koblitz.S256(),
+		chainhash.DoubleHashB([]byte("invoice test key")))
+
+	inv := new(Invoice)
+	idHash := fastsha256.Sum256(priv.PubKey().SerializeCompressed())
+	copy(inv.Payee[:], idHash[:20])
+	inv.CoinType = 257
+	inv.Amount = 150000
+	inv.PaymentHash = fastsha256.Sum256([]byte("preimage"))
+	inv.Timestamp = time.Now().Unix()
+	inv.Expiry = 3600
+	inv.Description = "order 1234: two coffees"
+	hop, _ := koblitz.PrivKeyFromBytes(koblitz.S256(),
+		chainhash.DoubleHashB([]byte("invoice test hop")))
+	var h RouteHint
+	copy(h.FromPub[:], hop.PubKey().SerializeCompressed())
+	hopHash := fastsha256.Sum256(h.FromPub[:])
+	copy(h.From[:], hopHash[:20])
+	h.CoinType = 257
+	h.Capacity = 500000
+	h.FeeBase = 1000
+	h.FeeRate = 100
+	h.CLTVDelta = 144
+	inv.RouteHints = []RouteHint{h}
+
+	err := inv.Sign(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return inv, priv
+}
+
+func TestInvoiceRoundTrip(t *testing.T) {
+	inv, _ := testInvoice(t)
+
+	s, err := inv.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(s, InvoiceHRP+"1") {
+		t.Fatalf("invoice %s has the wrong prefix", s)
+	}
+
+	inv2, err := DecodeInvoice(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inv2.Payee != inv.Payee || inv2.CoinType != inv.CoinType ||
+		inv2.Amount != inv.Amount || inv2.PaymentHash != inv.PaymentHash ||
+		inv2.Timestamp != inv.Timestamp || inv2.Expiry != inv.Expiry ||
+		inv2.Description != inv.Description || inv2.Sig != inv.Sig {
+		t.Fatalf("decoded invoice differs:\n%+v\n%+v", inv, inv2)
+	}
+	if len(inv2.RouteHints) != 1 || inv2.RouteHints[0] != inv.RouteHints[0] {
+		t.Fatalf("route hints differ: %v %v", inv.RouteHints, inv2.RouteHints)
+	}
+	if inv2.Expired() {
+		t.Fatalf("new invoice already expired")
+	}
+}
+
+func TestInvoiceTampered(t *testing.T) {
+	inv, _ := testInvoice(t)
+
+	inv.Amount = 1
+	s, err := inv.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = DecodeInvoice(s)
+	if err == nil {
+		t.Fatalf("tampered invoice decoded")
+	}
+}
+
+func TestInvoiceWrongKey(t *testing.T) {
+	inv, _ := testInvoice(t)
+
+	other, _ := koblitz.PrivKeyFromBytes(koblitz.S256(),
+		chainhash.DoubleHashB([]byte("someone else")))
+	err := inv.Sign(other)
+	if err == nil {
+		t.Fatalf("signed for a payee with someone else's key")
+	}
+}
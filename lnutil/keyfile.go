@@ -2,15 +2,18 @@ package lnutil
 
 import (
 	"crypto/rand"
+	"encoding/binary"
 	"encoding/hex"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/mit-dci/lit/logging"
 
 	"github.com/howeyc/gopass"
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/nacl/secretbox"
 	"golang.org/x/crypto/scrypt"
 )
@@ -25,6 +28,36 @@ for seeds for bip32, for individual secp256k1 priv keys, and so on.
 32 bytes is enough for anyone.
 If you want fewer bytes, put some zeroes at the end */
 
+/* Encrypted key files used to be 72 bytes: 24 bytes of scrypt salt (which is
+also the secretbox nonce) followed by the 48 byte box.  Those still load, but
+new files are written in a versioned format that stretches the passphrase with
+argon2id and records the cost parameters, so we can raise them later without
+breaking old files:
+
+version (1) | time (4) | memory KiB (4) | threads (1) | salt/nonce (24) | box (48)
+*/
+
+const (
+	keyFileVersionArgon2id = 0x01
+
+	// argon2id cost for newly written key files.  64MiB and 3 passes takes
+	// on the order of a second or less on a desktop.
+	keyFileArgonTime    = 3
+	keyFileArgonMemory  = 64 * 1024
+	keyFileArgonThreads = 4
+
+	// most a key file can ask of us, so a corrupt one can't hang lit or
+	// run it out of memory.  Well above what we write.
+	keyFileArgonMaxTime   = 100
+	keyFileArgonMaxMemory = 1024 * 1024
+
+	keyFileLegacyLen  = 72
+	keyFileArgon2Len  = 82
+	keyFileHeaderLen  = 10
+	keyFilePlainLen   = 32
+	keyFileSaltLength = 24
+)
+
 // LoadKeyFromFileInteractive opens the file 'filename' and presents a
 // keyboard prompt for the passphrase to decrypt it.  It returns the
 // key if decryption works, or errors out.
@@ -70,27 +103,9 @@ func LoadKeyFromFileArg(filename string, pass []byte) (*[32]byte, error) {
 		copy(priv32[:], enckey[:])
 		return priv32, nil
 	}
-	// enckey should be 72 bytes.  24 for scrypt salt/box nonce,
-	// 16 for box auth
-	if len(enckey) != 72 {
-		return priv32, fmt.Errorf("Key length error for %s ", filename)
-	}
-	// enckey is actually encrypted, get derived key from pass and salt
-	// first extract salt
-	salt := new([24]byte)      // salt (also nonce for secretbox)
-	dk32 := new([32]byte)      // derived key array
-	copy(salt[:], enckey[:24]) // first 24 bytes are scrypt salt/box nonce
-
-	dk, err := scrypt.Key(pass, salt[:], 16384, 8, 1, 32) // derive key
+	priv, err := decryptKey(enckey, pass)
 	if err != nil {
-		return priv32, err
-	}
-	copy(dk32[:], dk[:]) // copy into fixed size array
-
-	// nonce for secretbox is the same as scrypt salt.  Seems fine.  Really.
-	priv, worked := secretbox.Open(nil, enckey[24:], salt, dk32)
-	if worked != true {
-		return priv32, fmt.Errorf("Decryption failed for %s ", filename)
+		return priv32, fmt.Errorf("%s for %s ", err.Error(), filename)
 	}
 	copy(priv32[:], priv[:]) //copy decrypted private key into array
 
@@ -98,23 +113,83 @@ func LoadKeyFromFileArg(filename string, pass []byte) (*[32]byte, error) {
 	return priv32, nil
 }
 
+// decryptKey opens an encrypted key in either the legacy scrypt format or
+// the versioned argon2id format.
+func decryptKey(enckey, pass []byte) ([]byte, error) {
+	salt := new([24]byte) // salt (also nonce for secretbox)
+	dk32 := new([32]byte) // derived key array
+	var box []byte
+
+	switch len(enckey) {
+	case keyFileLegacyLen:
+		// 24 for scrypt salt/box nonce, 16 for box auth
+		copy(salt[:], enckey[:keyFileSaltLength])
+		dk, err := scrypt.Key(pass, salt[:], 16384, 8, 1, 32) // derive key
+		if err != nil {
+			return nil, err
+		}
+		copy(dk32[:], dk[:])
+		box = enckey[keyFileSaltLength:]
+
+	case keyFileArgon2Len:
+		if enckey[0] != keyFileVersionArgon2id {
+			return nil, fmt.Errorf("Unknown key file version %d", enckey[0])
+		}
+		time := binary.BigEndian.Uint32(enckey[1:5])
+		memory := binary.BigEndian.Uint32(enckey[5:9])
+		threads := enckey[9]
+		if time < 1 || time > keyFileArgonMaxTime {
+			return nil, fmt.Errorf("Key file argon2 time %d out of range", time)
+		}
+		if memory > keyFileArgonMaxMemory {
+			return nil, fmt.Errorf("Key file argon2 memory %d KiB, can't be"+
+				" more than %d", memory, keyFileArgonMaxMemory)
+		}
+		if threads < 1 {
+			return nil, fmt.Errorf("Key file argon2 threads can't be 0")
+		}
+		copy(salt[:], enckey[keyFileHeaderLen:keyFileHeaderLen+keyFileSaltLength])
+		copy(dk32[:], argon2.IDKey(pass, salt[:], time, memory, threads, 32))
+		box = enckey[keyFileHeaderLen+keyFileSaltLength:]
+
+	default:
+		return nil, fmt.Errorf("Key length error")
+	}
+
+	// nonce for secretbox is the same as the KDF salt.  Seems fine.  Really.
+	priv, worked := secretbox.Open(nil, box, salt, dk32)
+	if !worked {
+		return nil, fmt.Errorf("Decryption failed")
+	}
+	return priv, nil
+}
+
 // saves a 32 byte key to file, prompting for passphrase.
 // if user enters empty passphrase (hits enter twice), will be saved
 // in the clear.
 func SaveKeyToFileInteractive(filename string, priv32 *[32]byte) error {
+	pass, err := promptNewPassphrase("passphrase")
+	if err != nil {
+		return err
+	}
+	return SaveKeyToFileArg(filename, priv32, pass)
+}
+
+// promptNewPassphrase asks for a passphrase twice until both match.
+func promptNewPassphrase(prompt string) ([]byte, error) {
 	var match bool
 	var err error
 	var pass1, pass2 []byte
 	for match != true {
-		fmt.Printf("passphrase: ")
+		fmt.Printf("%s: ", prompt)
 		pass1, err = gopass.GetPasswd()
 		if err != nil {
-			return err
+			return nil, err
 		}
-		fmt.Printf("repeat passphrase: ")
+		fmt.Printf("repeat %s: ", prompt)
 		pass2, err = gopass.GetPasswd()
 		if err != nil {
-			return err
+			return nil, err
 		}
 		if string(pass1) == string(pass2) {
 			match = true
@@ -123,50 +198,113 @@ func SaveKeyToFileInteractive(filename string, priv32 *[32]byte) error {
 		}
 	}
 	fmt.Printf("\n")
-	return SaveKeyToFileArg(filename, priv32, pass1)
+	return pass1, nil
 }
 
 // saves a 32 byte key to a file, encrypting with pass.
 // if pass is nil or zero length, doesn't encrypt and just saves in hex.
 func SaveKeyToFileArg(filename string, priv32 *[32]byte, pass []byte) error {
-	if len(pass) == 0 { // zero-length pass, save unencrypted
-		keyhex := fmt.Sprintf("%x\n", priv32[:])
-		err := ioutil.WriteFile(filename, []byte(keyhex), 0600)
-		if err != nil {
-			return err
-		}
+	keyhex, err := encodeKeyFile(priv32, pass)
+	if err != nil {
+		return err
+	}
+	err = writeKeyFile(filename, keyhex)
+	if err != nil {
+		return err
+	}
+	logSavedKey(filename, pass)
+	return nil
+}
+
+// logSavedKey says where a key was saved, and warns if it's unencrypted.
+func logSavedKey(filename string, pass []byte) {
+	if len(pass) == 0 {
 		logging.Warnf("WARNING!! Key file not encrypted!!\n")
 		logging.Warnf("Anyone who can read the key file can take everything!\n")
 		logging.Warnf("You should start over and use a good passphrase!\n")
 		logging.Warnf("Saved unencrypted key at %s\n", filename)
-		return nil
+		return
 	}
+	logging.Infof("Wrote encrypted key to %s\n", filename)
+}
 
-	salt := new([24]byte) // salt for scrypt / nonce for secretbox
-	dk32 := new([32]byte) // derived key from scrypt
+// encodeKeyFile gives the contents of a key file holding priv32, encrypted
+// with pass, or in the clear if pass is empty.
+func encodeKeyFile(priv32 *[32]byte, pass []byte) ([]byte, error) {
+	if len(pass) == 0 { // zero-length pass, save unencrypted
+		return []byte(fmt.Sprintf("%x\n", priv32[:])), nil
+	}
 
-	//get 24 random bytes for scrypt salt (and secretbox nonce)
+	salt := new([24]byte) // salt for argon2 / nonce for secretbox
+	dk32 := new([32]byte) // derived key from argon2
+
+	//get 24 random bytes for argon2 salt (and secretbox nonce)
 	_, err := rand.Read(salt[:])
 	if err != nil {
-		return err
+		return nil, err
 	}
 	// next use the pass and salt to make a 32-byte derived key
-	dk, err := scrypt.Key(pass, salt[:], 16384, 8, 1, 32)
+	copy(dk32[:], argon2.IDKey(pass, salt[:],
+		keyFileArgonTime, keyFileArgonMemory, keyFileArgonThreads, 32))
+
+	enckey := make([]byte, keyFileHeaderLen, keyFileArgon2Len)
+	enckey[0] = keyFileVersionArgon2id
+	binary.BigEndian.PutUint32(enckey[1:5], keyFileArgonTime)
+	binary.BigEndian.PutUint32(enckey[5:9], keyFileArgonMemory)
+	enckey[9] = keyFileArgonThreads
+	enckey = append(enckey, salt[:]...)
+	enckey = append(enckey, secretbox.Seal(nil, priv32[:], salt, dk32)...)
+	return []byte(fmt.Sprintf("%x\n", enckey)), nil
+}
+
+// writeKeyFile replaces filename with data.  It goes to a temp file first,
+// which is synced to disk before being renamed over the old one, so a crash
+// while changing the passphrase can't leave us with half a key file.
+func writeKeyFile(filename string, data []byte) error {
+	tmpname, err := writeTempKeyFile(filename, data)
 	if err != nil {
 		return err
 	}
-	copy(dk32[:], dk[:])
+	return renameKeyFile(tmpname, filename)
+}
 
-	enckey := append(salt[:], secretbox.Seal(nil, priv32[:], salt, dk32)...)
-	//	enckey = append(salt, enckey...)
-	keyhex := fmt.Sprintf("%x\n", enckey)
+// writeTempKeyFile writes data, synced to disk, to a temp file next to
+// filename, and gives its name.
+func writeTempKeyFile(filename string, data []byte) (string, error) {
+	tmpname := filename + ".tmp"
+	f, err := os.OpenFile(tmpname, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpname)
+		return "", err
+	}
+	return tmpname, nil
+}
 
-	err = ioutil.WriteFile(filename, []byte(keyhex), 0600)
+// renameKeyFile moves temp file tmpname over filename.
+func renameKeyFile(tmpname, filename string) error {
+	err := os.Rename(tmpname, filename)
 	if err != nil {
+		os.Remove(tmpname)
 		return err
 	}
-	logging.Infof("Wrote encrypted key to %s\n", filename)
-	return nil
+	// sync the directory too so the rename itself sticks
+	dir, err := os.Open(filepath.Dir(filename))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
 }
 
 // ReadKeyFile returns an 32 byte key from a file.
@@ -197,3 +335,79 @@ func ReadKeyFile(filename string) (*[32]byte, error) {
 	}
 	return LoadKeyFromFileInteractive(filename)
 }
+
+// KeyFileEncrypted reports whether the key file at filename needs a
+// passphrase to be opened.
+func KeyFileEncrypted(filename string) (bool, error) {
+	keyhex, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return false, err
+	}
+	enckey, err := hex.DecodeString(strings.TrimSpace(string(keyhex)))
+	if err != nil {
+		return false, err
+	}
+	return len(enckey) != keyFilePlainLen, nil
+}
+
+// ChangeKeyFilePassphrase decrypts the key file with oldpass and writes it
+// back encrypted with newpass.  Key files in the old scrypt format get
+// upgraded along the way.  newpass can't be empty; taking the encryption off
+// is RemoveKeyFilePassphrase.
+func ChangeKeyFilePassphrase(filename string, oldpass, newpass []byte) error {
+	if len(newpass) == 0 {
+		return fmt.Errorf("Empty new passphrase; won't store key unencrypted")
+	}
+	return rekeyFiles([]string{filename}, oldpass, newpass)
+}
+
+// RemoveKeyFilePassphrase decrypts the key file with oldpass and writes it
+// back unencrypted.
+func RemoveKeyFilePassphrase(filename string, oldpass []byte) error {
+	return rekeyFiles([]string{filename}, oldpass, nil)
+}
+
+// rekeyFiles decrypts each key file with oldpass and writes it back encrypted
+// with newpass, or unencrypted if that's empty.  All the new files are
+// written out before any is renamed into place, so one failing can't leave
+// them under different passphrases.
+func rekeyFiles(filenames []string, oldpass, newpass []byte) error {
+	var tmpnames []string
+	removeTemps := func() {
+		for _, tmpname := range tmpnames {
+			os.Remove(tmpname)
+		}
+	}
+
+	for _, filename := range filenames {
+		priv32, err := LoadKeyFromFileArg(filename, oldpass)
+		if err != nil {
+			removeTemps()
+			return err
+		}
+		keyhex, err := encodeKeyFile(priv32, newpass)
+		if err != nil {
+			removeTemps()
+			return err
+		}
+		tmpname, err := writeTempKeyFile(filename, keyhex)
+		if err != nil {
+			removeTemps()
+			return err
+		}
+		tmpnames = append(tmpnames, tmpname)
+	}
+
+	for i, filename := range filenames {
+		err := renameKeyFile(tmpnames[i], filename)
+		if err != nil {
+			// can't be undone for those already renamed, but the rest
+			// shouldn't be left lying around
+			tmpnames = tmpnames[i+1:]
+			removeTemps()
+			return err
+		}
+		logSavedKey(filename, newpass)
+	}
+	return nil
+}
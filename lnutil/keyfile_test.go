@@ -0,0 +1,200 @@
+package lnutil
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestKeyFilePassphrase saves an encrypted key, checks that it only opens
+// with the right passphrase, then changes the passphrase and checks again.
+func TestKeyFilePassphrase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keyfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "privkey.hex")
+
+	key := new([32]byte)
+	_, err = rand.Read(key[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = SaveKeyToFileArg(filename, key, []byte("hunter2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	enc, err := KeyFileEncrypted(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !enc {
+		t.Fatalf("key file saved with passphrase reports unencrypted")
+	}
+
+	_, err = LoadKeyFromFileArg(filename, []byte("hunter3"))
+	if err == nil {
+		t.Fatalf("key file opened with wrong passphrase")
+	}
+
+	loaded, err := LoadKeyFromFileArg(filename, []byte("hunter2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *loaded != *key {
+		t.Fatalf("loaded key %x, expected %x", loaded, key)
+	}
+
+	err = ChangeKeyFilePassphrase(filename, []byte("hunter3"), []byte("correct horse"))
+	if err == nil {
+		t.Fatalf("passphrase changed with wrong old passphrase")
+	}
+	err = ChangeKeyFilePassphrase(filename, []byte("hunter2"), []byte("correct horse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err = LoadKeyFromFileArg(filename, []byte("correct horse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *loaded != *key {
+		t.Fatalf("loaded key %x, expected %x", loaded, key)
+	}
+
+	err = ChangeKeyFilePassphrase(filename, []byte("correct horse"), nil)
+	if err == nil {
+		t.Fatalf("passphrase changed to empty")
+	}
+	err = RemoveKeyFilePassphrase(filename, []byte("correct horse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, err = KeyFileEncrypted(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if enc {
+		t.Fatalf("key file still encrypted after removing passphrase")
+	}
+	_, err = os.Stat(filename + ".tmp")
+	if !os.IsNotExist(err) {
+		t.Fatalf("temp key file left behind")
+	}
+}
+
+// TestKeyFileArgonParams checks key files asking for argon2 costs we can't
+// or won't pay are refused rather than crashing us.
+func TestKeyFileArgonParams(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keyfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "privkey.hex")
+
+	for _, params := range []struct {
+		time, memory uint32
+		threads      byte
+	}{
+		{0, keyFileArgonMemory, keyFileArgonThreads},
+		{keyFileArgonMaxTime + 1, keyFileArgonMemory, keyFileArgonThreads},
+		{keyFileArgonTime, keyFileArgonMaxMemory + 1, keyFileArgonThreads},
+		{keyFileArgonTime, 0xffffffff, keyFileArgonThreads},
+		{keyFileArgonTime, keyFileArgonMemory, 0},
+	} {
+		enckey := make([]byte, keyFileArgon2Len)
+		enckey[0] = keyFileVersionArgon2id
+		binary.BigEndian.PutUint32(enckey[1:5], params.time)
+		binary.BigEndian.PutUint32(enckey[5:9], params.memory)
+		enckey[9] = params.threads
+		err = ioutil.WriteFile(filename, []byte(hex.EncodeToString(enckey)), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = LoadKeyFromFileArg(filename, []byte("hunter2"))
+		if err == nil {
+			t.Fatalf("key file with argon2 params %v opened", params)
+		}
+	}
+}
+
+// TestSeedKeyPassphrase checks the key and seed files change passphrase
+// together, and that neither does if one can't.
+func TestSeedKeyPassphrase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keyfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keyFilename := filepath.Join(dir, "privkey.hex")
+	seedFilename := filepath.Join(dir, "seed.hex")
+
+	key, seed := new([32]byte), new([32]byte)
+	key[0], seed[0] = 1, 2
+	err = SaveKeyToFileArg(keyFilename, key, []byte("hunter2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a seed file which won't open with the key file's passphrase
+	err = SaveKeyToFileArg(seedFilename, seed, []byte("hunter3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ChangeSeedKeyPassphrase(keyFilename, seedFilename,
+		[]byte("hunter2"), []byte("correct horse"))
+	if err == nil {
+		t.Fatalf("passphrase changed with the seed file not opening")
+	}
+	_, err = LoadKeyFromFileArg(keyFilename, []byte("hunter2"))
+	if err != nil {
+		t.Fatalf("key file changed when the seed file couldn't be: %s", err)
+	}
+	_, err = os.Stat(keyFilename + ".tmp")
+	if !os.IsNotExist(err) {
+		t.Fatalf("temp key file left behind")
+	}
+
+	err = SaveKeyToFileArg(seedFilename, seed, []byte("hunter2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ChangeSeedKeyPassphrase(keyFilename, seedFilename,
+		[]byte("hunter2"), []byte("correct horse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for filename, want := range map[string]*[32]byte{
+		keyFilename: key, seedFilename: seed} {
+
+		loaded, err := LoadKeyFromFileArg(filename, []byte("correct horse"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *loaded != *want {
+			t.Fatalf("loaded %x from %s, expected %x", loaded, filename, want)
+		}
+	}
+
+	// no seed file is fine
+	err = RemoveSeedKeyPassphrase(keyFilename, filepath.Join(dir, "none"),
+		[]byte("correct horse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, err := KeyFileEncrypted(keyFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if enc {
+		t.Fatalf("key file still encrypted after removing passphrase")
+	}
+}
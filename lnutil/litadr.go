@@ -67,8 +67,16 @@ func LitFullAdrDecode(in string) ([33]byte, error) {
 }
 
 func LitAdrFromPubkey(in [33]byte) string {
+	pkh := LitAdrPKH(in)
+	return bech32.Encode("ln", pkh[:])
+}
+
+// LitAdrPKH gives the 20 bytes a pubkey's lit address encodes.
+func LitAdrPKH(in [33]byte) [20]byte {
+	var pkh [20]byte
 	doubleSha := fastsha256.Sum256(in[:])
-	return bech32.Encode("ln", doubleSha[:20])
+	copy(pkh[:], doubleSha[:20])
+	return pkh
 }
 
 // LitAdrOK make sure the address is OK.  Either it has a valid checksum, or
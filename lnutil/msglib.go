@@ -6,7 +6,12 @@ import (
 	"encoding/binary"
 	"fmt"
 
+	"github.com/mit-dci/lit/bech32"
 	"github.com/mit-dci/lit/btcutil/chaincfg/chainhash"
+	"github.com/mit-dci/lit/crypto/fastsha256"
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/lit/sig64"
+	"github.com/mit-dci/lit/sphinx"
 	"github.com/mit-dci/lit/wire"
 )
 
@@ -22,8 +27,9 @@ const (
 	MSGID_SIGPROOF  = 0x14
 
 	//Channel destruction messages
-	MSGID_CLOSEREQ  = 0x20 // close channel
-	MSGID_CLOSERESP = 0x21
+	MSGID_CLOSEREQ      = 0x20 // close channel
+	MSGID_CLOSERESP     = 0x21
+	MSGID_FORCECLOSEREQ = 0x22 // lost my state; please break the channel
 
 	//Push Pull Messages
 	MSGID_DELTASIG  = 0x30 // pushing funds in channel; request to send
@@ -48,12 +54,14 @@ const (
 	MSGID_WATCH_DELETE   = 0x62 // Watch_clear marks a channel as ok to delete.  No further updates possible.
 
 	//Routing messages
-	MSGID_LINK_DESC = 0x70 // Describes a new channel for routing
+	MSGID_LINK_DESC  = 0x70 // Describes a new channel for routing
+	MSGID_LINK_PROOF = 0x71 // Signs our end of a channel, for the peer's adverts of it
 
 	//Multihop payment messages
 	MSGID_PAY_REQ   = 0x75 // Request payment
 	MSGID_PAY_ACK   = 0x76 // Acknowledge payment (share preimage hash)
 	MSGID_PAY_SETUP = 0x77 // Setup a payment route
+	MSGID_PAY_FAIL  = 0x78 // Payment failed at some hop (error onion)
 
 	//Discreet log contracts messages
 	MSGID_DLC_OFFER               = 0x90 // Offer a contract
@@ -114,6 +122,8 @@ func LitMsgFromBytes(b []byte, peerid uint32) (LitMsg, error) {
 
 	case MSGID_CLOSEREQ:
 		return NewCloseReqMsgFromBytes(b, peerid)
+	case MSGID_FORCECLOSEREQ:
+		return NewForceCloseReqMsgFromBytes(b, peerid)
 	/* not implemented
 	case MSGID_CLOSERESP:
 	*/
@@ -148,6 +158,8 @@ func LitMsgFromBytes(b []byte, peerid uint32) (LitMsg, error) {
 
 	case MSGID_LINK_DESC:
 		return NewLinkMsgFromBytes(b, peerid)
+	case MSGID_LINK_PROOF:
+		return NewLinkProofMsgFromBytes(b, peerid)
 
 	case MSGID_PAY_REQ:
 		return NewMultihopPaymentRequestMsgFromBytes(b, peerid)
@@ -155,6 +167,8 @@ func LitMsgFromBytes(b []byte, peerid uint32) (LitMsg, error) {
 		return NewMultihopPaymentAckMsgFromBytes(b, peerid)
 	case MSGID_PAY_SETUP:
 		return NewMultihopPaymentSetupMsgFromBytes(b, peerid)
+	case MSGID_PAY_FAIL:
+		return NewMultihopPaymentFailMsgFromBytes(b, peerid)
 
 	case MSGID_DUALFUNDINGREQ:
 		return NewDualFundingReqMsgFromBytes(b, peerid)
@@ -578,6 +592,48 @@ func (self CloseReqMsg) MsgType() uint8 { return MSGID_CLOSEREQ }
 
 //----------
 
+// ForceCloseReqMsg is sent by a node that restored a channel from a static
+// backup.  It no longer has the channel state, so it can't close or break
+// the channel itself, and asks the peer to broadcast their latest state.
+type ForceCloseReqMsg struct {
+	PeerIdx  uint32
+	Outpoint wire.OutPoint
+}
+
+func NewForceCloseReqMsg(peerid uint32, OP wire.OutPoint) ForceCloseReqMsg {
+	fc := new(ForceCloseReqMsg)
+	fc.PeerIdx = peerid
+	fc.Outpoint = OP
+	return *fc
+}
+
+func NewForceCloseReqMsgFromBytes(b []byte, peerid uint32) (ForceCloseReqMsg, error) {
+	fcm := new(ForceCloseReqMsg)
+	fcm.PeerIdx = peerid
+
+	if len(b) < 37 {
+		return *fcm, fmt.Errorf("got %d byte forceclosereq, expect 37\n", len(b))
+	}
+
+	var op [36]byte
+	copy(op[:], b[1:37]) // get rid of messageType
+	fcm.Outpoint = *OutPointFromBytes(op)
+	return *fcm, nil
+}
+
+func (self ForceCloseReqMsg) Bytes() []byte {
+	var msg []byte
+	msg = append(msg, self.MsgType())
+	opArr := OutPointToBytes(self.Outpoint)
+	msg = append(msg, opArr[:]...)
+	return msg
+}
+
+func (self ForceCloseReqMsg) Peer() uint32   { return self.PeerIdx }
+func (self ForceCloseReqMsg) MsgType() uint8 { return MSGID_FORCECLOSEREQ }
+
+//----------
+
 //message for sending an amount with the signature
 type DeltaSigMsg struct {
 	PeerIdx   uint32
@@ -1234,6 +1290,11 @@ func (rd *RateDesc) Bytes() []byte {
 	return buf.Bytes()
 }
 
+// LinkMsg advertises a channel from A to B.  It's signed by A's identity
+// key, and by A's key in the channel's funding output so anyone can check
+// the channel is really there on chain.  It also carries B's signatures on
+// the channel's proof (see LinkProofHash), with B's identity key and B's key
+// in the funding output, so A can't claim a channel to a made up B.
 type LinkMsg struct {
 	PeerIdx   uint32
 	APKH      [20]byte // APKH (A's LN address)
@@ -1243,6 +1304,17 @@ type LinkMsg struct {
 	Seq       uint32   // seq (Link state sequence #)
 	Timestamp int64
 	Rates     []RateDesc
+	Policy    ChanPolicy // what A asks to forward over the link
+
+	APub      [33]byte      // A's identity pubkey, which APKH is made from
+	FundingOp wire.OutPoint // funding outpoint of the channel
+	AChanPub  [33]byte      // A's pubkey in the funding output
+	BChanPub  [33]byte      // B's pubkey in the funding output
+	BPub      [33]byte      // B's identity pubkey, which BPKH is made from
+	BSig      [64]byte      // on the proof, by BPub
+	BChanSig  [64]byte      // on the proof, by BChanPub
+	Sig       [64]byte      // by APub
+	ChanSig   [64]byte      // by AChanPub
 }
 
 func NewLinkMsgFromBytes(b []byte, peerIDX uint32) (LinkMsg, error) {
@@ -1285,6 +1357,25 @@ func NewLinkMsgFromBytes(b []byte, peerIDX uint32) (LinkMsg, error) {
 		sm.Rates = append(sm.Rates, rd)
 	}
 
+	sm.Policy, err = ChanPolicyFromBytes(buf.Next(ChanPolicyLen))
+	if err != nil {
+		return *sm, err
+	}
+
+	if buf.Len() < 33+36+33+33+33+64+64+64+64 {
+		return *sm, fmt.Errorf("LinkMsg missing funding proof")
+	}
+	copy(sm.APub[:], buf.Next(33))
+	copy(sm.FundingOp.Hash[:], buf.Next(32))
+	sm.FundingOp.Index = BtU32(buf.Next(4))
+	copy(sm.AChanPub[:], buf.Next(33))
+	copy(sm.BChanPub[:], buf.Next(33))
+	copy(sm.BPub[:], buf.Next(33))
+	copy(sm.BSig[:], buf.Next(64))
+	copy(sm.BChanSig[:], buf.Next(64))
+	copy(sm.Sig[:], buf.Next(64))
+	copy(sm.ChanSig[:], buf.Next(64))
+
 	return *sm, nil
 }
 
@@ -1309,12 +1400,192 @@ func (self LinkMsg) Bytes() []byte {
 		buf.Write(rate.Bytes())
 	}
 
+	buf.Write(self.Policy.Bytes())
+
+	buf.Write(self.APub[:])
+	buf.Write(self.FundingOp.Hash[:])
+	buf.Write(U32tB(self.FundingOp.Index))
+	buf.Write(self.AChanPub[:])
+	buf.Write(self.BChanPub[:])
+	buf.Write(self.BPub[:])
+	buf.Write(self.BSig[:])
+	buf.Write(self.BChanSig[:])
+	buf.Write(self.Sig[:])
+	buf.Write(self.ChanSig[:])
+
 	return buf.Bytes()
 }
 
+// SigHash gives the digest both of A's sigs on a LinkMsg sign: the sha256 of
+// the message with those sigs blank.
+func (self LinkMsg) SigHash() []byte {
+	self.Sig = [64]byte{}
+	self.ChanSig = [64]byte{}
+	hash := fastsha256.Sum256(self.Bytes())
+	return hash[:]
+}
+
+// Sign signs a LinkMsg with A's identity key and A's funding key.
+// B's proof sigs must already be in place, as A's sigs cover them.
+func (self *LinkMsg) Sign(idPriv, chanPriv *koblitz.PrivateKey) error {
+	var err error
+	self.Sig, self.ChanSig, err = SignLinkProof(self.SigHash(), idPriv, chanPriv)
+	return err
+}
+
+// ProofHash gives the digest B's sigs on a LinkMsg sign.
+func (self LinkMsg) ProofHash() []byte {
+	return LinkProofHash(self.FundingOp, self.CoinType,
+		self.APub, self.AChanPub, self.BPub, self.BChanPub)
+}
+
+// VerifySigs checks a LinkMsg is signed by the node in APKH and by its key in
+// the funding output, and that the proof is signed by the node in BPKH and by
+// its key in the funding output.  It doesn't check the funding output is on
+// chain.
+func (self LinkMsg) VerifySigs() error {
+	if LitAdrPKH(self.APub) != self.APKH {
+		return fmt.Errorf("link pubkey doesn't match %s", bech32.Encode("ln", self.APKH[:]))
+	}
+	if LitAdrPKH(self.BPub) != self.BPKH {
+		return fmt.Errorf("link pubkey doesn't match %s", bech32.Encode("ln", self.BPKH[:]))
+	}
+
+	err := VerifyLinkProof(self.ProofHash(),
+		self.BPub, self.BChanPub, self.BSig, self.BChanSig)
+	if err != nil {
+		return fmt.Errorf("%s from %s", err.Error(), bech32.Encode("ln", self.BPKH[:]))
+	}
+	err = VerifyLinkProof(self.SigHash(),
+		self.APub, self.AChanPub, self.Sig, self.ChanSig)
+	if err != nil {
+		return fmt.Errorf("%s from %s", err.Error(), bech32.Encode("ln", self.APKH[:]))
+	}
+	return nil
+}
+
+// LinkProofHash gives the digest both ends of a channel sign, with their
+// identity keys and their keys in the funding output, to show they're the
+// two nodes of that channel.  It comes out the same whichever end is first,
+// so one signature does for the adverts in both directions.
+func LinkProofHash(op wire.OutPoint, coinType uint32,
+	pub1, chanPub1, pub2, chanPub2 [33]byte) []byte {
+
+	if bytes.Compare(pub1[:], pub2[:]) > 0 {
+		pub1, chanPub1, pub2, chanPub2 = pub2, chanPub2, pub1, chanPub1
+	}
+	var buf bytes.Buffer
+	buf.WriteString("lit link proof")
+	buf.Write(op.Hash[:])
+	buf.Write(U32tB(op.Index))
+	buf.Write(U32tB(coinType))
+	buf.Write(pub1[:])
+	buf.Write(chanPub1[:])
+	buf.Write(pub2[:])
+	buf.Write(chanPub2[:])
+	hash := fastsha256.Sum256(buf.Bytes())
+	return hash[:]
+}
+
+// SignLinkProof signs digest with a node's identity key and its key in a
+// channel's funding output.
+func SignLinkProof(digest []byte,
+	idPriv, chanPriv *koblitz.PrivateKey) ([64]byte, [64]byte, error) {
+
+	var sigs [2][64]byte
+	for i, priv := range []*koblitz.PrivateKey{idPriv, chanPriv} {
+		sig, err := priv.Sign(digest)
+		if err != nil {
+			return sigs[0], sigs[1], err
+		}
+		sigs[i], err = sig64.SigCompress(sig.Serialize())
+		if err != nil {
+			return sigs[0], sigs[1], err
+		}
+	}
+	return sigs[0], sigs[1], nil
+}
+
+// VerifyLinkProof checks sig and chanSig on digest are by pub and chanPub.
+func VerifyLinkProof(digest []byte,
+	pub, chanPub [33]byte, sig, chanSig [64]byte) error {
+
+	for _, ps := range []struct {
+		pub [33]byte
+		sig [64]byte
+	}{{pub, sig}, {chanPub, chanSig}} {
+		pub, err := koblitz.ParsePubKey(ps.pub[:], koblitz.S256())
+		if err != nil {
+			return err
+		}
+		sig, err := koblitz.ParseDERSignature(sig64.SigDecompress(ps.sig), koblitz.S256())
+		if err != nil {
+			return err
+		}
+		if !sig.Verify(digest, pub) {
+			return fmt.Errorf("bad link sig")
+		}
+	}
+	return nil
+}
+
+// FundPkScript gives the output script the link's funding outpoint has to
+// have.
+func (self LinkMsg) FundPkScript() ([]byte, error) {
+	pre, _, err := FundTxScript(self.AChanPub, self.BChanPub)
+	if err != nil {
+		return nil, err
+	}
+	return P2WSHify(pre), nil
+}
+
 func (self LinkMsg) Peer() uint32   { return self.PeerIdx }
 func (self LinkMsg) MsgType() uint8 { return MSGID_LINK_DESC }
 
+// LinkProofMsg gives the peer our sigs on the proof of a channel we have
+// with it (see LinkProofHash), which it needs to advertise the channel.
+type LinkProofMsg struct {
+	PeerIdx   uint32
+	FundingOp wire.OutPoint
+	Sig       [64]byte // by our identity key
+	ChanSig   [64]byte // by our key in the funding output
+}
+
+func NewLinkProofMsg(peerIdx uint32, op wire.OutPoint,
+	sig, chanSig [64]byte) LinkProofMsg {
+	return LinkProofMsg{PeerIdx: peerIdx, FundingOp: op, Sig: sig, ChanSig: chanSig}
+}
+
+func NewLinkProofMsgFromBytes(b []byte, peerIdx uint32) (LinkProofMsg, error) {
+	sm := new(LinkProofMsg)
+	sm.PeerIdx = peerIdx
+
+	if len(b) < 165 {
+		return *sm, fmt.Errorf("LinkProofMsg %d bytes, expect 165", len(b))
+	}
+	buf := bytes.NewBuffer(b[1:]) // get rid of messageType
+
+	var op [36]byte
+	copy(op[:], buf.Next(36))
+	sm.FundingOp = *OutPointFromBytes(op)
+	copy(sm.Sig[:], buf.Next(64))
+	copy(sm.ChanSig[:], buf.Next(64))
+	return *sm, nil
+}
+
+func (self LinkProofMsg) Bytes() []byte {
+	var msg []byte
+	msg = append(msg, self.MsgType())
+	op := OutPointToBytes(self.FundingOp)
+	msg = append(msg, op[:]...)
+	msg = append(msg, self.Sig[:]...)
+	msg = append(msg, self.ChanSig[:]...)
+	return msg
+}
+
+func (self LinkProofMsg) Peer() uint32   { return self.PeerIdx }
+func (self LinkProofMsg) MsgType() uint8 { return MSGID_LINK_PROOF }
+
 // Dual funding messages
 
 type DualFundingReqMsg struct {
@@ -1745,12 +2016,15 @@ type DlcOfferAcceptMsg struct {
 	FundingInputs []DlcContractFundingInput
 	// The signatures for settling the contract at various values
 	SettlementSignatures []DlcContractSettlementSignature
+	// The signature for the refund transaction
+	RefundSignature [64]byte
 }
 
 // NewDlcOfferAcceptMsg generates a new DlcOfferAcceptMsg struct based on the
 // passed contract and signatures
 func NewDlcOfferAcceptMsg(contract *DlcContract,
-	signatures []DlcContractSettlementSignature) DlcOfferAcceptMsg {
+	signatures []DlcContractSettlementSignature,
+	refundSig [64]byte) DlcOfferAcceptMsg {
 
 	msg := new(DlcOfferAcceptMsg)
 	msg.PeerIdx = contract.PeerIdx
@@ -1762,6 +2036,7 @@ func NewDlcOfferAcceptMsg(contract *DlcContract,
 	msg.OurPayoutBase = contract.OurPayoutBase
 	msg.OurPayoutPKH = contract.OurPayoutPKH
 	msg.SettlementSignatures = signatures
+	msg.RefundSignature = refundSig
 	return *msg
 }
 
@@ -1808,6 +2083,9 @@ func NewDlcOfferAcceptMsgFromBytes(b []byte,
 		copy(msg.SettlementSignatures[i].Signature[:], buf.Next(64))
 	}
 
+	// peers from before refunds don't send one
+	copy(msg.RefundSignature[:], buf.Next(64))
+
 	return *msg, nil
 }
 
@@ -1841,6 +2119,7 @@ func (msg DlcOfferAcceptMsg) Bytes() []byte {
 		wire.WriteVarInt(&buf, 0, uint64(msg.SettlementSignatures[i].Outcome))
 		buf.Write(msg.SettlementSignatures[i].Signature[:])
 	}
+	buf.Write(msg.RefundSignature[:])
 	return buf.Bytes()
 }
 
@@ -1864,17 +2143,21 @@ type DlcContractAckMsg struct {
 	Idx uint64
 	// The settlement signatures of the party acknowledging
 	SettlementSignatures []DlcContractSettlementSignature
+	// The refund signature of the party acknowledging
+	RefundSignature [64]byte
 }
 
 // NewDlcContractAckMsg generates a new DlcContractAckMsg struct based on the
 // passed contract and signatures
 func NewDlcContractAckMsg(contract *DlcContract,
-	signatures []DlcContractSettlementSignature) DlcContractAckMsg {
+	signatures []DlcContractSettlementSignature,
+	refundSig [64]byte) DlcContractAckMsg {
 
 	msg := new(DlcContractAckMsg)
 	msg.PeerIdx = contract.PeerIdx
 	msg.Idx = contract.TheirIdx
 	msg.SettlementSignatures = signatures
+	msg.RefundSignature = refundSig
 	return *msg
 }
 
@@ -1903,6 +2186,8 @@ func NewDlcContractAckMsgFromBytes(b []byte,
 		binary.Read(buf, binary.BigEndian, &msg.SettlementSignatures[i].Outcome)
 		copy(msg.SettlementSignatures[i].Signature[:], buf.Next(64))
 	}
+	// peers from before refunds don't send one
+	copy(msg.RefundSignature[:], buf.Next(64))
 	return *msg, nil
 }
 
@@ -1921,6 +2206,7 @@ func (msg DlcContractAckMsg) Bytes() []byte {
 		binary.Write(&buf, binary.BigEndian, outcome)
 		buf.Write(msg.SettlementSignatures[i].Signature[:])
 	}
+	buf.Write(msg.RefundSignature[:])
 	return buf.Bytes()
 }
 
@@ -2284,25 +2570,26 @@ func NewRouteHopFromBytes(b []byte) (*RouteHop, error) {
 }
 
 // MultihopPaymentSetupMsg forms a new multihop payment. It is sent to
-// the next-in-line peer, which will forward it to the next hop until
-// the target is reached
+// the next-in-line peer along with the HTLC offer, and each hop peels its
+// layer off the onion to learn where to forward it, until the target is
+// reached
 type MultihopPaymentSetupMsg struct {
 	// The index of the peer we're communicating with
 	PeerIdx uint32
 	// The hash to the preimage we use to clear out the HTLCs
 	HHash [32]byte
-	// The PKHs (in order) of the nodes we're using.
-	NodeRoute []RouteHop
+	// The onion with the route, a layer for each hop
+	Onion [sphinx.PacketSize]byte
 	// Data associated with the payment
 	Data [32]byte
 }
 
 // NewMultihopPaymentSetupMsg does...
-func NewMultihopPaymentSetupMsg(peerIdx uint32, hHash [32]byte, nodeRoute []RouteHop, data [32]byte) MultihopPaymentSetupMsg {
+func NewMultihopPaymentSetupMsg(peerIdx uint32, hHash [32]byte, onion [sphinx.PacketSize]byte, data [32]byte) MultihopPaymentSetupMsg {
 	msg := new(MultihopPaymentSetupMsg)
 	msg.PeerIdx = peerIdx
 	msg.HHash = hHash
-	msg.NodeRoute = nodeRoute
+	msg.Onion = onion
 	msg.Data = data
 	return *msg
 }
@@ -2312,19 +2599,15 @@ func NewMultihopPaymentSetupMsgFromBytes(b []byte,
 
 	msg := new(MultihopPaymentSetupMsg)
 	msg.PeerIdx = peerIdx
-	buf := bytes.NewBuffer(b[1:]) // get rid of messageType
-	copy(msg.HHash[:], buf.Next(32))
 
-	hops, _ := wire.ReadVarInt(buf, 0)
-	for i := uint64(0); i < hops; i++ {
-		rh, err := NewRouteHopFromBytes(buf.Next(24))
-		if err != nil {
-			return *msg, err
-		}
-
-		msg.NodeRoute = append(msg.NodeRoute, *rh)
+	if len(b) < 1+32+sphinx.PacketSize+32 {
+		return *msg, fmt.Errorf("got %d byte MultihopPaymentSetup, expect %d",
+			len(b), 1+32+sphinx.PacketSize+32)
 	}
 
+	buf := bytes.NewBuffer(b[1:]) // get rid of messageType
+	copy(msg.HHash[:], buf.Next(32))
+	copy(msg.Onion[:], buf.Next(sphinx.PacketSize))
 	copy(msg.Data[:], buf.Next(32))
 
 	return *msg, nil
@@ -2336,11 +2619,7 @@ func (msg MultihopPaymentSetupMsg) Bytes() []byte {
 
 	buf.WriteByte(msg.MsgType())
 	buf.Write(msg.HHash[:])
-	wire.WriteVarInt(&buf, 0, uint64(len(msg.NodeRoute)))
-	for _, nd := range msg.NodeRoute {
-		buf.Write(nd.Bytes())
-	}
-
+	buf.Write(msg.Onion[:])
 	buf.Write(msg.Data[:])
 
 	return buf.Bytes()
@@ -2355,6 +2634,65 @@ func (msg MultihopPaymentSetupMsg) MsgType() uint8 {
 	return MSGID_PAY_SETUP
 }
 
+// MultihopPaymentFailMsg says a multihop payment couldn't be forwarded.  It
+// goes back along the route to the sender, each hop adding its layer of
+// encryption to the error onion, so only the sender can read which hop
+// failed and why
+type MultihopPaymentFailMsg struct {
+	// The index of the peer we're communicating with
+	PeerIdx uint32
+	// The hash of the payment that failed
+	HHash [32]byte
+	// The error onion
+	Reason [sphinx.ErrorPacketSize]byte
+}
+
+func NewMultihopPaymentFailMsg(peerIdx uint32, hHash [32]byte, reason [sphinx.ErrorPacketSize]byte) MultihopPaymentFailMsg {
+	msg := new(MultihopPaymentFailMsg)
+	msg.PeerIdx = peerIdx
+	msg.HHash = hHash
+	msg.Reason = reason
+	return *msg
+}
+
+func NewMultihopPaymentFailMsgFromBytes(b []byte,
+	peerIdx uint32) (MultihopPaymentFailMsg, error) {
+
+	msg := new(MultihopPaymentFailMsg)
+	msg.PeerIdx = peerIdx
+
+	if len(b) < 1+32+sphinx.ErrorPacketSize {
+		return *msg, fmt.Errorf("got %d byte MultihopPaymentFail, expect %d",
+			len(b), 1+32+sphinx.ErrorPacketSize)
+	}
+
+	buf := bytes.NewBuffer(b[1:]) // get rid of messageType
+	copy(msg.HHash[:], buf.Next(32))
+	copy(msg.Reason[:], buf.Next(sphinx.ErrorPacketSize))
+
+	return *msg, nil
+}
+
+// Bytes serializes a MultihopPaymentFailMsg into a byte array
+func (msg MultihopPaymentFailMsg) Bytes() []byte {
+	var buf bytes.Buffer
+
+	buf.WriteByte(msg.MsgType())
+	buf.Write(msg.HHash[:])
+	buf.Write(msg.Reason[:])
+
+	return buf.Bytes()
+}
+
+func (msg MultihopPaymentFailMsg) Peer() uint32 {
+	return msg.PeerIdx
+}
+
+// MsgType returns the type of this message
+func (msg MultihopPaymentFailMsg) MsgType() uint8 {
+	return MSGID_PAY_FAIL
+}
+
 // RemoteControlRpcResponseMsg is sent in response to a request message
 // and contains the output of the command that was executed
 type RemoteControlRpcResponseMsg struct {
@@ -1,10 +1,14 @@
 package lnutil
 
 import (
+	"bytes"
 	"math/rand"
 	"testing"
 
 	"github.com/mit-dci/lit/btcutil/chaincfg/chainhash"
+	"github.com/mit-dci/lit/crypto/fastsha256"
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/lit/sphinx"
 )
 
 func TestChatMsg(t *testing.T) {
@@ -318,6 +322,44 @@ func TestCloseReqMsg(t *testing.T) {
 	}
 }
 
+func TestForceCloseReqMsg(t *testing.T) {
+	peerid := rand.Uint32()
+	var outPoint [36]byte
+
+	_, _ = rand.Read(outPoint[:])
+
+	op := *OutPointFromBytes(outPoint)
+
+	msg := NewForceCloseReqMsg(peerid, op)
+	b := msg.Bytes()
+
+	msg2, err := NewForceCloseReqMsgFromBytes(b, peerid)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !LitMsgEqual(msg, msg2) {
+		t.Fatalf("from bytes mismatch:\n%x\n%x\n", msg.Bytes(), msg2.Bytes())
+	}
+
+	msg3, err := LitMsgFromBytes(b, peerid)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !LitMsgEqual(msg2, msg3) {
+		t.Fatalf("interface mismatch:\n%x\n%x\n", msg2.Bytes(), msg3.Bytes())
+	}
+
+	_, err = LitMsgFromBytes(b[:36], peerid) //purposely error to check working by not sending enough bytes
+
+	if err == nil {
+		t.Fatalf("Should have errored, but didn't")
+	}
+}
+
 func TestDeltaSigMsg(t *testing.T) {
 	peerid := rand.Uint32()
 	var outPoint [36]byte
@@ -595,3 +637,159 @@ func TestComMsg(t *testing.T) {
 		t.Fatalf("Should have errored, but didn't")
 	}
 }
+
+func TestLinkMsg(t *testing.T) {
+	peerid := rand.Uint32()
+
+	idPriv, _ := koblitz.PrivKeyFromBytes(koblitz.S256(),
+		chainhash.DoubleHashB([]byte("link id key")))
+	chanPriv, _ := koblitz.PrivKeyFromBytes(koblitz.S256(),
+		chainhash.DoubleHashB([]byte("link chan key")))
+	theirIdPriv, _ := koblitz.PrivKeyFromBytes(koblitz.S256(),
+		chainhash.DoubleHashB([]byte("their id key")))
+	theirChanPriv, _ := koblitz.PrivKeyFromBytes(koblitz.S256(),
+		chainhash.DoubleHashB([]byte("their chan key")))
+
+	var msg LinkMsg
+	msg.PeerIdx = peerid
+	copy(msg.APub[:], idPriv.PubKey().SerializeCompressed())
+	idHash := fastsha256.Sum256(msg.APub[:])
+	copy(msg.APKH[:], idHash[:20])
+	msg.ACapacity = 500000
+	msg.CoinType = 257
+	msg.Seq = 12
+	msg.Rates = []RateDesc{{CoinType: 1, Rate: 100}}
+	msg.Policy = ChanPolicy{FeeBase: 1000, FeeRate: 250, CLTVDelta: 144, MinHTLC: 10000}
+	msg.FundingOp.Hash = chainhash.DoubleHashH([]byte("funding tx"))
+	msg.FundingOp.Index = 1
+	copy(msg.AChanPub[:], chanPriv.PubKey().SerializeCompressed())
+	copy(msg.BChanPub[:], theirChanPriv.PubKey().SerializeCompressed())
+	copy(msg.BPub[:], theirIdPriv.PubKey().SerializeCompressed())
+	msg.BPKH = LitAdrPKH(msg.BPub)
+
+	// B signs the proof, which is the same from either end
+	proof := LinkProofHash(msg.FundingOp, msg.CoinType,
+		msg.BPub, msg.BChanPub, msg.APub, msg.AChanPub)
+	if !bytes.Equal(proof, msg.ProofHash()) {
+		t.Fatalf("link proof depends on order")
+	}
+	var err error
+	msg.BSig, msg.BChanSig, err = SignLinkProof(proof, theirIdPriv, theirChanPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pm := NewLinkProofMsg(peerid, msg.FundingOp, msg.BSig, msg.BChanSig)
+	pm2, err := LitMsgFromBytes(pm.Bytes(), peerid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !LitMsgEqual(pm, pm2) {
+		t.Fatalf("from bytes mismatch:\n%x\n%x\n", pm.Bytes(), pm2.Bytes())
+	}
+
+	err = msg.Sign(idPriv, chanPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := msg.Bytes()
+	msg2, err := NewLinkMsgFromBytes(b, peerid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !LitMsgEqual(msg, msg2) {
+		t.Fatalf("from bytes mismatch:\n%x\n%x\n", msg.Bytes(), msg2.Bytes())
+	}
+	err = msg2.VerifySigs()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg2.ACapacity++
+	if msg2.VerifySigs() == nil {
+		t.Fatalf("Should have failed with changed capacity, but didn't")
+	}
+	msg2.ACapacity--
+	msg2.Policy.FeeRate = 0
+	if msg2.VerifySigs() == nil {
+		t.Fatalf("Should have failed with changed policy, but didn't")
+	}
+
+	msg2.Policy.FeeRate = 250
+	// B's proof signed by someone not in the channel
+	msg2.BSig, msg2.BChanSig, _ = SignLinkProof(msg2.ProofHash(), theirIdPriv, idPriv)
+	err = msg2.Sign(idPriv, chanPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg2.VerifySigs() == nil {
+		t.Fatalf("Should have failed with wrong B funding key, but didn't")
+	}
+
+	// signed by someone not in the channel
+	err = msg.Sign(idPriv, idPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.VerifySigs() == nil {
+		t.Fatalf("Should have failed with wrong funding key, but didn't")
+	}
+
+	_, err = NewLinkMsgFromBytes(b[:len(b)-64], peerid)
+	if err == nil {
+		t.Fatalf("Should have errored, but didn't")
+	}
+}
+
+func TestMultihopPaymentSetupMsg(t *testing.T) {
+	peerid := rand.Uint32()
+	var hash [32]byte
+	var onion [sphinx.PacketSize]byte
+	var data [32]byte
+
+	_, _ = rand.Read(hash[:])
+	_, _ = rand.Read(onion[:])
+	_, _ = rand.Read(data[:])
+
+	msg := NewMultihopPaymentSetupMsg(peerid, hash, onion, data)
+	b := msg.Bytes()
+
+	msg2, err := LitMsgFromBytes(b, peerid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !LitMsgEqual(msg, msg2) {
+		t.Fatalf("from bytes mismatch:\n%x\n%x\n", msg.Bytes(), msg2.Bytes())
+	}
+
+	_, err = LitMsgFromBytes(b[:len(b)-1], peerid)
+	if err == nil {
+		t.Fatalf("Should have errored, but didn't")
+	}
+}
+
+func TestMultihopPaymentFailMsg(t *testing.T) {
+	peerid := rand.Uint32()
+	var hash [32]byte
+	var reason [sphinx.ErrorPacketSize]byte
+
+	_, _ = rand.Read(hash[:])
+	_, _ = rand.Read(reason[:])
+
+	msg := NewMultihopPaymentFailMsg(peerid, hash, reason)
+	b := msg.Bytes()
+
+	msg2, err := LitMsgFromBytes(b, peerid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !LitMsgEqual(msg, msg2) {
+		t.Fatalf("from bytes mismatch:\n%x\n%x\n", msg.Bytes(), msg2.Bytes())
+	}
+
+	_, err = LitMsgFromBytes(b[:len(b)-1], peerid)
+	if err == nil {
+		t.Fatalf("Should have errored, but didn't")
+	}
+}
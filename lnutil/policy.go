@@ -0,0 +1,111 @@
+package lnutil
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/big"
+)
+
+// ChanPolicyLen is the size of a serialized ChanPolicy
+const ChanPolicyLen = 32
+
+// ChanPolicy is what a node asks to forward payments over one of its
+// channels.  Fees are in the channel's coin, and taken from what's sent on.
+type ChanPolicy struct {
+	FeeBase   int64  // flat fee for each payment forwarded
+	FeeRate   uint32 // fee in millionths of the amount sent on
+	CLTVDelta uint32 // blocks the incoming HTLC has to be locked past the outgoing one
+	MinHTLC   int64  // smallest amount it'll send on
+	MaxHTLC   int64  // biggest amount it'll send on; 0 for no max
+}
+
+// Fee gives the fee for sending amt on.  Policies come from peers, so it's
+// worked out in big ints, and a fee too big for an int64 comes out as the
+// biggest one.
+func (p ChanPolicy) Fee(amt int64) int64 {
+	fee := new(big.Int).Mul(big.NewInt(amt), big.NewInt(int64(p.FeeRate)))
+	fee.Quo(fee, big.NewInt(1000000))
+	fee.Add(fee, big.NewInt(p.FeeBase))
+	return clampInt64(fee)
+}
+
+// AmtAfterFee gives the most that can be sent on out of amt, after the fee.
+// It's 0 or less if amt doesn't cover the fee.
+func (p ChanPolicy) AmtAfterFee(amt int64) int64 {
+	est := new(big.Int).Sub(big.NewInt(amt), big.NewInt(p.FeeBase))
+	est.Mul(est, big.NewInt(1000000))
+	est.Quo(est, big.NewInt(1000000+int64(p.FeeRate)))
+	out := clampInt64(est)
+	// rounding can leave it a satoshi off either way
+	max := big.NewInt(amt)
+	for out > 0 && p.amtWithFee(out).Cmp(max) > 0 {
+		out--
+	}
+	for out > 0 && out < math.MaxInt64 && p.amtWithFee(out+1).Cmp(max) <= 0 {
+		out++
+	}
+	return out
+}
+
+// AmtWithFee gives amt plus the fee for sending it on, or the biggest int64
+// if that's more.
+func (p ChanPolicy) AmtWithFee(amt int64) int64 {
+	return clampInt64(p.amtWithFee(amt))
+}
+
+func (p ChanPolicy) amtWithFee(amt int64) *big.Int {
+	sum := new(big.Int).Mul(big.NewInt(amt), big.NewInt(int64(p.FeeRate)))
+	sum.Quo(sum, big.NewInt(1000000))
+	sum.Add(sum, big.NewInt(p.FeeBase))
+	return sum.Add(sum, big.NewInt(amt))
+}
+
+// clampInt64 gives x, or the nearest int64 to it.
+func clampInt64(x *big.Int) int64 {
+	if x.IsInt64() {
+		return x.Int64()
+	}
+	if x.Sign() < 0 {
+		return math.MinInt64
+	}
+	return math.MaxInt64
+}
+
+// CheckAmt checks amt is within the min and max this policy sends on.
+func (p ChanPolicy) CheckAmt(amt int64) error {
+	if amt < p.MinHTLC {
+		return fmt.Errorf("amount %d less than min %d", amt, p.MinHTLC)
+	}
+	if p.MaxHTLC != 0 && amt > p.MaxHTLC {
+		return fmt.Errorf("amount %d more than max %d", amt, p.MaxHTLC)
+	}
+	return nil
+}
+
+// Bytes serializes a ChanPolicy.
+func (p ChanPolicy) Bytes() []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, p.FeeBase)
+	binary.Write(&buf, binary.BigEndian, p.FeeRate)
+	binary.Write(&buf, binary.BigEndian, p.CLTVDelta)
+	binary.Write(&buf, binary.BigEndian, p.MinHTLC)
+	binary.Write(&buf, binary.BigEndian, p.MaxHTLC)
+	return buf.Bytes()
+}
+
+// ChanPolicyFromBytes parses a ChanPolicy.
+func ChanPolicyFromBytes(b []byte) (ChanPolicy, error) {
+	var p ChanPolicy
+	if len(b) < ChanPolicyLen {
+		return p, fmt.Errorf("got %d byte policy, expect %d", len(b), ChanPolicyLen)
+	}
+	buf := bytes.NewBuffer(b)
+	binary.Read(buf, binary.BigEndian, &p.FeeBase)
+	binary.Read(buf, binary.BigEndian, &p.FeeRate)
+	binary.Read(buf, binary.BigEndian, &p.CLTVDelta)
+	binary.Read(buf, binary.BigEndian, &p.MinHTLC)
+	binary.Read(buf, binary.BigEndian, &p.MaxHTLC)
+	return p, nil
+}
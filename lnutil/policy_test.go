@@ -0,0 +1,57 @@
+package lnutil
+
+import (
+	"math"
+	"testing"
+)
+
+func TestChanPolicyFee(t *testing.T) {
+	p := ChanPolicy{FeeBase: 1000, FeeRate: 2500, MinHTLC: 50000, MaxHTLC: 2000000}
+
+	if p.Fee(1000000) != 3500 {
+		t.Fatalf("fee %d on 1000000, expect 3500", p.Fee(1000000))
+	}
+
+	for _, in := range []int64{1000, 1001, 50000, 123457, 1003500, 99999999} {
+		out := p.AmtAfterFee(in)
+		if out+p.Fee(out) > in {
+			t.Fatalf("%d in: sending on %d with fee %d is more", in, out, p.Fee(out))
+		}
+		if out > 0 && (out+1)+p.Fee(out+1) <= in {
+			t.Fatalf("%d in: could send on %d, not just %d", in, out+1, out)
+		}
+	}
+	if p.AmtAfterFee(1000000+3500) != 1000000 {
+		t.Fatalf("expect 1000000 sent on after fee")
+	}
+
+	if p.CheckAmt(10000) == nil || p.CheckAmt(3000000) == nil {
+		t.Fatalf("amounts outside min/max allowed")
+	}
+	if p.CheckAmt(1000000) != nil {
+		t.Fatalf("amount within min/max not allowed")
+	}
+
+	// a peer's policy can have any rate, and amounts can be huge
+	big := ChanPolicy{FeeBase: 1 << 62, FeeRate: 0xffffffff}
+	if big.Fee(math.MaxInt64) != math.MaxInt64 {
+		t.Fatalf("fee %d on max amount, expect max", big.Fee(math.MaxInt64))
+	}
+	if big.AmtWithFee(1<<62) != math.MaxInt64 {
+		t.Fatalf("amount with fee %d, expect max", big.AmtWithFee(1<<62))
+	}
+	if out := big.AmtAfterFee(math.MaxInt64); out <= 0 || big.AmtWithFee(out+1) != math.MaxInt64 {
+		t.Fatalf("sending on %d out of max amount", out)
+	}
+	if big.AmtAfterFee(1<<61) > 0 {
+		t.Fatalf("amount less than base fee sends something on")
+	}
+
+	p2, err := ChanPolicyFromBytes(p.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p2 != p {
+		t.Fatalf("from bytes mismatch: %v %v", p, p2)
+	}
+}
@@ -0,0 +1,165 @@
+package lnutil
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/mit-dci/lit/bip39"
+	"github.com/mit-dci/lit/logging"
+)
+
+/* Keys made from a BIP39 mnemonic.  The root key in the key file is the
+first 32 bytes of the BIP39 seed, so it depends on the words and the
+(optional) seed passphrase.  The 32 bytes of entropy behind the 24 words go
+in a separate seed file, encrypted the same way as the key file, so the
+words can be shown again later.  The seed passphrase is never stored. */
+
+// RootKeyFromMnemonic checks the mnemonic and derives the 32 byte root key
+// from it and the seed passphrase.
+func RootKeyFromMnemonic(mnemonic, seedPass string) (*[32]byte, error) {
+	_, err := bip39.EntropyFromMnemonic(mnemonic)
+	if err != nil {
+		return nil, err
+	}
+	key32 := new([32]byte)
+	copy(key32[:], bip39.NewSeed(mnemonic, seedPass)[:32])
+	return key32, nil
+}
+
+// LoadMnemonicFromFileArg opens the seed file and returns the mnemonic.
+func LoadMnemonicFromFileArg(filename string, pass []byte) (string, error) {
+	entropy, err := LoadKeyFromFileArg(filename, pass)
+	if err != nil {
+		return "", err
+	}
+	return bip39.NewMnemonic(entropy[:])
+}
+
+// NewSeedInteractive makes a new 24 word mnemonic, shows it, and saves the
+// derived root key and the seed, prompting for the passphrases.
+func NewSeedInteractive(keyFilename, seedFilename string) (*[32]byte, error) {
+	entropy, err := bip39.NewEntropy(bip39.MaxEntropyBits)
+	if err != nil {
+		return nil, err
+	}
+	mnemonic, err := bip39.NewMnemonic(entropy)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("Your new seed words are:\n\n")
+	words := strings.Fields(mnemonic)
+	for i := 0; i < len(words); i += 6 {
+		fmt.Printf("\t%s\n", strings.Join(words[i:i+6], " "))
+	}
+	fmt.Printf("\nWrite them down and keep them somewhere safe.  They are the only\n")
+	fmt.Printf("way to get your funds back if this machine is lost.\n\n")
+
+	return saveSeedInteractive(keyFilename, seedFilename, mnemonic)
+}
+
+// RestoreSeedInteractive reads a mnemonic from the terminal and saves the
+// root key and seed it gives, prompting for the passphrases.
+func RestoreSeedInteractive(keyFilename, seedFilename string) (*[32]byte, error) {
+	fmt.Printf("seed words: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	mnemonic := strings.Join(strings.Fields(strings.ToLower(line)), " ")
+	_, err = bip39.EntropyFromMnemonic(mnemonic)
+	if err != nil {
+		return nil, err
+	}
+	return saveSeedInteractive(keyFilename, seedFilename, mnemonic)
+}
+
+func saveSeedInteractive(
+	keyFilename, seedFilename, mnemonic string) (*[32]byte, error) {
+
+	fmt.Printf("Optional seed passphrase; leave empty for none.  If you set one\n")
+	fmt.Printf("you need it along with the words to restore.  It is not stored.\n")
+	seedPass, err := promptNewPassphrase("seed passphrase")
+	if err != nil {
+		return nil, err
+	}
+	key32, err := RootKeyFromMnemonic(mnemonic, string(seedPass))
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("Key file passphrase, needed every time lit starts.\n")
+	pass, err := promptNewPassphrase("passphrase")
+	if err != nil {
+		return nil, err
+	}
+	err = SaveKeyToFileArg(keyFilename, key32, pass)
+	if err != nil {
+		return nil, err
+	}
+
+	entropy, err := bip39.EntropyFromMnemonic(mnemonic)
+	if err != nil {
+		return nil, err
+	}
+	if len(entropy) != 32 {
+		// the seed file holds exactly 32 bytes, like the key file.
+		// Shorter mnemonics still work, they just can't be shown later.
+		logging.Warnf("%d word mnemonic, not saving seed file\n",
+			len(strings.Fields(mnemonic)))
+		return key32, nil
+	}
+	seed32 := new([32]byte)
+	copy(seed32[:], entropy)
+	err = SaveKeyToFileArg(seedFilename, seed32, pass)
+	if err != nil {
+		return nil, err
+	}
+	return key32, nil
+}
+
+// ReadOrCreateSeedKeyFile is ReadKeyFile for the node key: if there's no key
+// file yet it makes one from a fresh mnemonic instead of random bytes.
+func ReadOrCreateSeedKeyFile(keyFilename, seedFilename string) (*[32]byte, error) {
+	_, err := os.Stat(keyFilename)
+	if os.IsNotExist(err) {
+		logging.Infof("No file %s, generating new seed.\n", keyFilename)
+		return NewSeedInteractive(keyFilename, seedFilename)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return LoadKeyFromFileInteractive(keyFilename)
+}
+
+// ChangeSeedKeyPassphrase re-encrypts the key file and the seed file, if
+// there is one, together: either both end up under newpass or neither does.
+func ChangeSeedKeyPassphrase(keyFilename, seedFilename string,
+	oldpass, newpass []byte) error {
+
+	if len(newpass) == 0 {
+		return fmt.Errorf("Empty new passphrase; won't store key unencrypted")
+	}
+	return rekeyFiles(withSeedFile(keyFilename, seedFilename), oldpass, newpass)
+}
+
+// RemoveSeedKeyPassphrase stores the key file and the seed file, if there is
+// one, unencrypted together.
+func RemoveSeedKeyPassphrase(keyFilename, seedFilename string,
+	oldpass []byte) error {
+
+	return rekeyFiles(withSeedFile(keyFilename, seedFilename), oldpass, nil)
+}
+
+// withSeedFile gives the key file, and the seed file too if there is one.
+func withSeedFile(keyFilename, seedFilename string) []string {
+	filenames := []string{keyFilename}
+	if seedFilename != "" {
+		_, err := os.Stat(seedFilename)
+		if !os.IsNotExist(err) {
+			filenames = append(filenames, seedFilename)
+		}
+	}
+	return filenames
+}
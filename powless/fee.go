@@ -0,0 +1,72 @@
+package powless
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// how long a fee estimate from the indexer stays good
+const feeCacheTime = time.Minute
+
+// VFeeResponse is the json that comes back from the /estimateFee query to the
+// indexer.  Same units as bitcoind's estimatesmartfee: coins per kilobyte.
+type VFeeResponse struct {
+	FeeRate float64
+	Blocks  uint32
+}
+
+type cachedFee struct {
+	rate int64
+	at   time.Time
+}
+
+// feeCache keeps recent estimates per conf target, so asking for the fee
+// doesn't mean a web request every time.
+type feeCache struct {
+	mtx   sync.Mutex
+	rates map[uint32]cachedFee
+}
+
+// EstimateFee asks the indexer for a fee rate, in sat/byte, for confirming
+// within confTarget blocks.
+func (a *APILink) EstimateFee(confTarget uint32) (int64, error) {
+	if confTarget < 1 {
+		confTarget = 1
+	}
+
+	a.fees.mtx.Lock()
+	defer a.fees.mtx.Unlock()
+	if a.fees.rates == nil {
+		a.fees.rates = make(map[uint32]cachedFee)
+	}
+	c, ok := a.fees.rates[confTarget]
+	if ok && time.Since(c.at) < feeCacheTime {
+		return c.rate, nil
+	}
+
+	apiurl := fmt.Sprintf("%sestimateFee/%d", a.apiUrl, confTarget)
+	response, err := a.client.Get(apiurl)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	var feejson VFeeResponse
+	err = json.NewDecoder(response.Body).Decode(&feejson)
+	if err != nil {
+		return 0, err
+	}
+	if feejson.FeeRate <= 0 {
+		return 0, fmt.Errorf("indexer has no fee estimate for %d blocks", confTarget)
+	}
+
+	// coins per kB to sat per byte
+	rate := int64(feejson.FeeRate * 1e8 / 1000)
+	if rate < 1 {
+		rate = 1
+	}
+	a.fees.rates[confTarget] = cachedFee{rate: rate, at: time.Now()}
+	return rate, nil
+}
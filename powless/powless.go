@@ -103,6 +103,9 @@ type APILink struct {
 
 	client http.Client
 
+	// recent fee estimates from the indexer
+	fees feeCache
+
 	p *coinparam.Params
 }
 
@@ -0,0 +1,40 @@
+package powless
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/mit-dci/lit/wire"
+)
+
+// GetUtxo asks the indexer for the output at op, and whether it's spent.
+func (a *APILink) GetUtxo(op wire.OutPoint) (*wire.TxOut, error) {
+	tx, err := a.VGetRawTx(op.Hash.String())
+	if err != nil {
+		return nil, err
+	}
+	if int(op.Index) >= len(tx.TxOut) {
+		return nil, fmt.Errorf("tx %s has no output %d", op.Hash.String(), op.Index)
+	}
+
+	opstring := strings.Replace(op.String(), ";", "/", 1)
+	response, err := a.client.Get(a.apiUrl + "outpointSpend/" + opstring)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	var txr VSpendResponse
+	err = json.NewDecoder(response.Body).Decode(&txr)
+	if err != nil {
+		return nil, err
+	}
+	if txr.Error {
+		return nil, fmt.Errorf("indexer doesn't know %s", op.String())
+	}
+	if txr.Spent {
+		return nil, fmt.Errorf("%s spent by %s", op.String(), txr.Spender)
+	}
+	return tx.TxOut[op.Index], nil
+}
@@ -0,0 +1,471 @@
+package qln
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/nacl/secretbox"
+
+	"github.com/mit-dci/lit/btcutil"
+	"github.com/mit-dci/lit/lncore"
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/logging"
+	"github.com/mit-dci/lit/portxo"
+)
+
+/*
+Static channel backups.
+
+If ln.db is lost we no longer have the channel states, so we can't safely
+close or break any of our channels ourselves.  What we can do is remember,
+in a file that only changes when channels open or close, enough about each
+channel to recognize it on chain and derive our keys for it: the outpoint,
+the keygen path, the pubkeys and who the peer is.
+
+To recover, the backup is imported (with the same root key), every channel in
+it is stored as "recovered", and we ask each peer to break the channel
+(MSGID_FORCECLOSEREQ).  Their break tx pays us to the plain PKH of our refund
+pubkey, which GetCloseTxos picks up like for any other close.
+
+The backup is encrypted with a key derived from the node identity key, so it
+can be kept somewhere less trusted than the key file.
+*/
+
+const (
+	channelBackupFileName = "channels.backup"
+	channelBackupVersion  = 1
+)
+
+// StaticChannelBackup is everything we back up about one channel.
+type StaticChannelBackup struct {
+	Outpoint [36]byte      `json:"outpoint"`
+	Value    int64         `json:"value"`
+	KeyGen   portxo.KeyGen `json:"keygen"`
+
+	PeerAddr string `json:"peeraddr"`
+	NetAddr  string `json:"netaddr"`
+
+	MyPub          [33]byte `json:"mpub"`
+	MyRefundPub    [33]byte `json:"mrefpub"`
+	MyHAKDBase     [33]byte `json:"mhakdbase"`
+	TheirPub       [33]byte `json:"rpub"`
+	TheirRefundPub [33]byte `json:"rrefpub"`
+	TheirHAKDBase  [33]byte `json:"rhakdbase"`
+}
+
+type staticBackupFile struct {
+	Version  uint8                 `json:"version"`
+	Channels []StaticChannelBackup `json:"channels"`
+	// highest channel index used, closed channels included, so a restored
+	// node doesn't derive keys for new channels it's used before
+	MaxChannelIdx uint32 `json:"maxchanidx"`
+}
+
+// ChannelBackupPath is where the static channel backup gets written.
+func (nd *LitNode) ChannelBackupPath() string {
+	return filepath.Join(nd.LitFolder, channelBackupFileName)
+}
+
+// channelBackupKey derives the backup encryption key from the identity key.
+func (nd *LitNode) channelBackupKey() *[32]byte {
+	key := sha256.Sum256(append(
+		[]byte("lit static channel backup"), nd.IdentityKey.Serialize()...))
+	return &key
+}
+
+// peerAddrsForIdx finds the ln address and last known network address of the
+// peer with the given index in the peer db.
+func (nd *LitNode) peerAddrsForIdx(idx uint32) (string, string, error) {
+	pis, err := nd.NewLitDB.GetPeerDB().GetPeerInfos()
+	if err != nil {
+		return "", "", err
+	}
+	for addr, pi := range pis {
+		if pi.PeerIdx != idx {
+			continue
+		}
+		var netAddr string
+		if pi.NetAddr != nil {
+			netAddr = *pi.NetAddr
+		}
+		return string(addr), netAddr, nil
+	}
+	return "", "", nil
+}
+
+// WriteChannelBackup rewrites the static channel backup with every channel
+// that hasn't been closed.  Called whenever a channel opens or closes.
+func (nd *LitNode) WriteChannelBackup() error {
+	nd.backupMtx.Lock()
+	defer nd.backupMtx.Unlock()
+
+	qcs, err := nd.GetAllQchans()
+	if err != nil {
+		return err
+	}
+
+	sf := staticBackupFile{Version: channelBackupVersion}
+	sf.MaxChannelIdx, err = nd.maxChannelIdx()
+	if err != nil {
+		return err
+	}
+	for _, q := range qcs {
+		if q.CloseData.Closed {
+			continue
+		}
+		scb := StaticChannelBackup{
+			Outpoint:       lnutil.OutPointToBytes(q.Op),
+			Value:          q.Value,
+			KeyGen:         q.KeyGen,
+			MyPub:          q.MyPub,
+			MyRefundPub:    q.MyRefundPub,
+			MyHAKDBase:     q.MyHAKDBase,
+			TheirPub:       q.TheirPub,
+			TheirRefundPub: q.TheirRefundPub,
+			TheirHAKDBase:  q.TheirHAKDBase,
+		}
+		// never back up key material
+		scb.KeyGen.PrivKey = [32]byte{}
+
+		scb.PeerAddr, scb.NetAddr, err = nd.peerAddrsForIdx(q.Peer())
+		if err != nil {
+			return err
+		}
+		sf.Channels = append(sf.Channels, scb)
+	}
+
+	plain, err := json.Marshal(sf)
+	if err != nil {
+		return err
+	}
+
+	var nonce [24]byte
+	_, err = rand.Read(nonce[:])
+	if err != nil {
+		return err
+	}
+	enc := secretbox.Seal(nonce[:], plain, &nonce, nd.channelBackupKey())
+
+	// write then rename, so there's always a complete backup on disk
+	filename := nd.ChannelBackupPath()
+	err = ioutil.WriteFile(filename+".tmp", enc, 0600)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(filename+".tmp", filename)
+	if err != nil {
+		return err
+	}
+
+	logging.Infof("Wrote backup of %d channels to %s\n", len(sf.Channels), filename)
+	return nil
+}
+
+// updateChannelBackup writes the backup, logging rather than returning
+// errors; a failed backup shouldn't fail the channel operation.
+func (nd *LitNode) updateChannelBackup() {
+	err := nd.WriteChannelBackup()
+	if err != nil {
+		logging.Errorf("Couldn't write channel backup: %s\n", err.Error())
+	}
+}
+
+// DecryptChannelBackup opens a backup written by WriteChannelBackup.
+func (nd *LitNode) DecryptChannelBackup(enc []byte) ([]StaticChannelBackup, error) {
+	sf, err := nd.decryptBackupFile(enc)
+	if err != nil {
+		return nil, err
+	}
+	return sf.Channels, nil
+}
+
+// decryptBackupFile opens the whole of a backup written by
+// WriteChannelBackup.
+func (nd *LitNode) decryptBackupFile(enc []byte) (*staticBackupFile, error) {
+	if len(enc) < 24+secretbox.Overhead {
+		return nil, fmt.Errorf("channel backup too short")
+	}
+	var nonce [24]byte
+	copy(nonce[:], enc[:24])
+	plain, ok := secretbox.Open(nil, enc[24:], &nonce, nd.channelBackupKey())
+	if !ok {
+		return nil, fmt.Errorf("can't decrypt channel backup; different key?")
+	}
+
+	var sf staticBackupFile
+	err := json.Unmarshal(plain, &sf)
+	if err != nil {
+		return nil, err
+	}
+	if sf.Version != channelBackupVersion {
+		return nil, fmt.Errorf("unknown channel backup version %d", sf.Version)
+	}
+	return &sf, nil
+}
+
+// ImportChannelBackup restores the channels in a static backup that we don't
+// already know about, and asks their peers to break them.  Returns the
+// number of channels restored.
+func (nd *LitNode) ImportChannelBackup(enc []byte) (int, error) {
+	sf, err := nd.decryptBackupFile(enc)
+	if err != nil {
+		return 0, err
+	}
+
+	// backups written before they kept the index only have the open
+	// channels' ones, in their keygen paths
+	maxChanIdx := sf.MaxChannelIdx
+	for _, scb := range sf.Channels {
+		if idx := scb.KeyGen.Step[4] & 0x7fffffff; idx > maxChanIdx {
+			maxChanIdx = idx
+		}
+	}
+	err = nd.raiseChannelIdx(maxChanIdx)
+	if err != nil {
+		return 0, err
+	}
+
+	cdb := nd.NewLitDB.GetChannelDB()
+	pdb := nd.NewLitDB.GetPeerDB()
+
+	// The peer index counter has already gone past every index a peer had
+	// before this import, so it only has to move on for peers we add with
+	// higher ones.  Re-importing a backup adds no peers and uses up nothing.
+	pis, err := pdb.GetPeerInfos()
+	if err != nil {
+		return 0, err
+	}
+	var maxKnownIdx uint32
+	for _, pi := range pis {
+		if pi.PeerIdx > maxKnownIdx {
+			maxKnownIdx = pi.PeerIdx
+		}
+	}
+
+	var restored int
+	var maxPeerIdx uint32
+	peers := make(map[uint32]string)
+
+	for _, scb := range sf.Channels {
+		op := lnutil.OutPointFromBytes(scb.Outpoint)
+		h := ChanHandleFromOp(scb.Outpoint)
+
+		old, err := cdb.GetChannel(h)
+		if err != nil {
+			return restored, err
+		}
+		if old != nil {
+			logging.Infof("ImportChannelBackup: already have channel %s\n", op.String())
+			continue
+		}
+
+		q, err := nd.qchanFromBackup(&scb)
+		if err != nil {
+			logging.Errorf("ImportChannelBackup: channel %s: %s\n",
+				op.String(), err.Error())
+			continue
+		}
+
+		peerIdx := q.Peer()
+		lnaddr, err := lncore.ParseLnAddr(scb.PeerAddr)
+		if err != nil {
+			logging.Errorf("ImportChannelBackup: channel %s: bad peer address %s\n",
+				op.String(), scb.PeerAddr)
+			continue
+		}
+
+		// The keygen path has the peer index baked in, so the peer has to
+		// get the same index it had before.
+		pi, err := pdb.GetPeerInfo(lnaddr)
+		if err != nil {
+			return restored, err
+		}
+		if pi == nil {
+			taken, _, err := nd.peerAddrsForIdx(peerIdx)
+			if err != nil {
+				return restored, err
+			}
+			if taken != "" {
+				logging.Errorf("ImportChannelBackup: channel %s: peer index %d already used by %s\n",
+					op.String(), peerIdx, taken)
+				continue
+			}
+			netAddr := scb.NetAddr
+			err = pdb.AddPeer(lnaddr, lncore.PeerInfo{
+				LnAddr:  &lnaddr,
+				NetAddr: &netAddr,
+				PeerIdx: peerIdx,
+			})
+			if err != nil {
+				return restored, err
+			}
+			if peerIdx > maxPeerIdx {
+				maxPeerIdx = peerIdx
+			}
+		} else if pi.PeerIdx != peerIdx {
+			logging.Errorf("ImportChannelBackup: channel %s: peer %s is now index %d, was %d\n",
+				op.String(), lnaddr, pi.PeerIdx, peerIdx)
+			continue
+		}
+
+		info, err := NewChannelInfoFromQchan(q)
+		if err != nil {
+			return restored, err
+		}
+		info.PeerAddr = scb.PeerAddr
+		err = cdb.AddChannel(h, *info)
+		if err != nil {
+			return restored, err
+		}
+
+		// watch for the peer's break tx, and our refund output in it
+		wal := nd.SubWallet[q.Coin()]
+		var pkh [20]byte
+		copy(pkh[:], btcutil.Hash160(q.MyRefundPub[:]))
+		wal.ExportHook().RegisterAddress(pkh)
+		err = wal.WatchThis(*op)
+		if err != nil {
+			return restored, err
+		}
+
+		peers[peerIdx] = scb.PeerAddr
+		if scb.NetAddr != "" {
+			peers[peerIdx] = scb.PeerAddr + "@" + scb.NetAddr
+		}
+		restored++
+		logging.Infof("ImportChannelBackup: restored channel %s with peer %d\n",
+			op.String(), peerIdx)
+	}
+
+	// new peers shouldn't be handed an index a restored peer already has
+	for maxPeerIdx > maxKnownIdx {
+		idx, err := pdb.GetUniquePeerIdx()
+		if err != nil {
+			return restored, err
+		}
+		if idx >= maxPeerIdx {
+			break
+		}
+	}
+
+	if restored != 0 {
+		nd.updateChannelBackup()
+	}
+
+	// Connecting runs the new peer handler, which sends the force close
+	// requests.  Already connected peers get them right away.
+	for peerIdx, addr := range peers {
+		if nd.ConnectedToPeer(peerIdx) {
+			nd.requestRecoveredBreaks(peerIdx)
+			continue
+		}
+		go func(addr string) {
+			err := nd.DialPeer(addr)
+			if err != nil {
+				logging.Errorf("ImportChannelBackup: can't connect to %s: %s\n",
+					addr, err.Error())
+			}
+		}(addr)
+	}
+
+	return restored, nil
+}
+
+// qchanFromBackup makes a stateless qchan from a backup entry, checking that
+// our keys for it come out the same as when the backup was written.
+func (nd *LitNode) qchanFromBackup(scb *StaticChannelBackup) (*Qchan, error) {
+	q := new(Qchan)
+	q.Op = *lnutil.OutPointFromBytes(scb.Outpoint)
+	q.Value = scb.Value
+	q.KeyGen = scb.KeyGen
+	q.Mode = portxo.TxoP2WSHComp
+	q.TheirPub = scb.TheirPub
+	q.TheirRefundPub = scb.TheirRefundPub
+	q.TheirHAKDBase = scb.TheirHAKDBase
+
+	if nd.SubWallet[q.Coin()] == nil {
+		return nil, fmt.Errorf("coin type %d not linked", q.Coin())
+	}
+
+	var err error
+	q.MyPub, err = nd.GetUsePub(q.KeyGen, UseChannelFund)
+	if err != nil {
+		return nil, err
+	}
+	q.MyRefundPub, err = nd.GetUsePub(q.KeyGen, UseChannelRefund)
+	if err != nil {
+		return nil, err
+	}
+	q.MyHAKDBase, err = nd.GetUsePub(q.KeyGen, UseChannelHAKDBase)
+	if err != nil {
+		return nil, err
+	}
+	if q.MyPub != scb.MyPub || q.MyRefundPub != scb.MyRefundPub ||
+		q.MyHAKDBase != scb.MyHAKDBase {
+		return nil, fmt.Errorf("derived keys don't match backup; different root key?")
+	}
+
+	// no state, so nothing we could sign or accept on this channel
+	q.State = new(StatCom)
+	q.State.Failed = true
+	q.State.Recovered = true
+
+	return q, nil
+}
+
+// requestRecoveredBreaks asks a peer to break every channel we have with it
+// that was restored from a backup and isn't closed yet.
+func (nd *LitNode) requestRecoveredBreaks(peerIdx uint32) {
+	qcs, err := nd.GetAllQchans()
+	if err != nil {
+		logging.Errorf("requestRecoveredBreaks: %s\n", err.Error())
+		return
+	}
+	for _, q := range qcs {
+		if q.Peer() != peerIdx || !q.State.Recovered || q.CloseData.Closed {
+			continue
+		}
+		logging.Infof("Asking peer %d to break recovered channel %s\n",
+			peerIdx, q.Op.String())
+		nd.tmpSendLitMsg(lnutil.NewForceCloseReqMsg(peerIdx, q.Op))
+	}
+}
+
+// ForceCloseReqHandler breaks a channel because the peer has lost its state
+// and asked us to.  Breaking only ever uses our latest state, so the worst
+// this can do to us is make us wait out the timeout.
+func (nd *LitNode) ForceCloseReqHandler(msg lnutil.ForceCloseReqMsg) {
+	q, err := nd.GetQchan(lnutil.OutPointToBytes(msg.Outpoint))
+	if err != nil {
+		logging.Errorf("ForceCloseReqHandler GetQchan err %s", err.Error())
+		return
+	}
+	if q.Peer() != msg.Peer() {
+		logging.Errorf("ForceCloseReqHandler: peer %d asked to break channel %s with peer %d",
+			msg.Peer(), msg.Outpoint.String(), q.Peer())
+		return
+	}
+	if q.CloseData.Closed {
+		logging.Infof("ForceCloseReqHandler: channel %s already closed\n",
+			msg.Outpoint.String())
+		return
+	}
+	if q.State.Recovered {
+		// both sides restored from backups; neither can break it
+		logging.Errorf("ForceCloseReqHandler: channel %s is recovered on our side too",
+			msg.Outpoint.String())
+		return
+	}
+
+	logging.Infof("Peer %d lost its state, breaking channel %s\n",
+		msg.Peer(), msg.Outpoint.String())
+	err = nd.BreakChannel(q)
+	if err != nil {
+		logging.Errorf("ForceCloseReqHandler BreakChannel err %s", err.Error())
+	}
+}
@@ -0,0 +1,66 @@
+package qln
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/mit-dci/lit/db/lnbolt"
+	"github.com/mit-dci/lit/lncore"
+)
+
+// TestNextChannelIdx checks new channels get an index past every channel's,
+// closed ones and ones a restored backup knew of included.
+func TestNextChannelIdx(t *testing.T) {
+	nd, cleanup := newTestNode(t)
+	defer cleanup()
+	db2 := &lnbolt.LitBoltDB{}
+	err := db2.Open(filepath.Join(filepath.Dir(nd.LitDB.Path()), "db2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db2.Close()
+	nd.NewLitDB = db2
+
+	expect := func(want uint32) {
+		t.Helper()
+		idx, err := nd.NextChannelIdx()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if idx != want {
+			t.Fatalf("next channel index %d, expect %d", idx, want)
+		}
+	}
+	expect(1)
+
+	cdb := db2.GetChannelDB()
+	for i, idx := range []uint32{3, 7} {
+		var h lncore.ChannelHandle
+		h[0] = byte(i)
+		var info lncore.ChannelInfo
+		info.Txo.KeyGen.Step[4] = idx | 1<<31
+		err = cdb.AddChannel(h, info)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the later one's closed
+		if idx == 7 {
+			err = cdb.ArchiveChannel(h)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	expect(8)
+
+	err = nd.raiseChannelIdx(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect(11)
+	err = nd.raiseChannelIdx(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect(11)
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	"github.com/mit-dci/lit/btcutil/chaincfg/chainhash"
+	"github.com/mit-dci/lit/btcutil/psbt"
 	"github.com/mit-dci/lit/coinparam"
 	"github.com/mit-dci/lit/crypto/koblitz"
 	"github.com/mit-dci/lit/lnutil"
@@ -43,7 +44,11 @@ type UWallet interface {
 	// Retruns the txid, and then the txout indexes of the specified txos.
 	// The outpoints returned will all have the same hash (txid)
 	// So if you (as usual) just give one txo, you basically get back an outpoint.
-	MaybeSend(txos []*wire.TxOut, onlyWit bool) ([]*wire.OutPoint, error)
+	// Pays feePerByte, which usually comes from EstimateFee.
+	// If ins isn't empty, those are the inputs rather than the wallet
+	// picking them.
+	MaybeSend(txos []*wire.TxOut, ins []wire.OutPoint, feePerByte int64,
+		onlyWit bool) ([]*wire.OutPoint, error)
 
 	// ReallySend really sends the transaction specified previously in MaybeSend.
 	// Underlying wallet does all needed signing.
@@ -91,12 +96,43 @@ type UWallet interface {
 	// Ask for network parameters
 	Params() *coinparam.Params
 
-	// Get current fee rate.
+	// Get current fee rate, for the default confirmation target.
 	Fee() int64
 
-	// Set fee rate
+	// EstimateFee gets a fee rate to confirm within confTarget blocks.
+	EstimateFee(confTarget uint32) int64
+
+	// Set a fixed fee rate, or 0 to go back to estimating
 	SetFee(int64) int64
 
+	// BumpFee speeds up an unconfirmed tx of ours to feePerByte, by RBF if
+	// possible or CPFP if not (or if cpfp is set).  Returns the new txid.
+	BumpFee(txid chainhash.Hash, feePerByte int64, cpfp bool) (*chainhash.Hash, error)
+
+	// Coin control: locked utxos are skipped by PickUtxos.
+	LockUtxo(op wire.OutPoint) error
+	UnlockUtxo(op wire.OutPoint) error
+	LockedUtxos() ([]wire.OutPoint, error)
+
+	// Labels for utxos and addresses.  An empty label removes it.
+	SetUtxoLabel(op wire.OutPoint, label string) error
+	UtxoLabel(op wire.OutPoint) (string, error)
+	SetAdrLabel(pkh [20]byte, label string) error
+	AdrLabel(pkh [20]byte) (string, error)
+
+	// CreatePsbt makes an unsigned PSBT paying txos, with inputs from ins or
+	// picked by the wallet, and change.  The inputs get locked.
+	CreatePsbt(txos []*wire.TxOut, ins []wire.OutPoint,
+		feePerByte int64) (*psbt.Packet, error)
+
+	// SignMyPsbtInputs signs and finalizes the inputs in a PSBT which are
+	// ours, leaving the rest.
+	SignMyPsbtInputs(p *psbt.Packet) (*psbt.Packet, error)
+
+	// GetUtxo looks up an unspent output anywhere on chain.  Gives
+	// uspv.ErrCantCheckUtxo if the chain hook can't.
+	GetUtxo(op wire.OutPoint) (*wire.TxOut, error)
+
 	// ===== TESTING / SPAMMING ONLY, these funcs will not be in the real interface
 	// Sweep sends lots of txs (uint32 of them) to the specified address.
 	Sweep([]byte, uint32) ([]*chainhash.Hash, error)
@@ -104,6 +140,10 @@ type UWallet interface {
 	PickUtxos(amtWanted, outputByteSize,
 		feePerByte int64, ow bool) (portxo.TxoSliceByBip69, int64, error)
 
+	// SelectUtxos is PickUtxos with the inputs chosen by the user.
+	SelectUtxos(ops []wire.OutPoint, amtWanted, outputByteSize,
+		feePerByte int64, ow bool) (portxo.TxoSliceByBip69, int64, error)
+
 	SignMyInputs(tx *wire.MsgTx) error
 
 	DirectSendTx(tx *wire.MsgTx) error
@@ -1,11 +1,17 @@
 package qln
 
 import (
+	"bytes"
 	"fmt"
+	"math"
+	"math/big"
+	"time"
 
 	"github.com/mit-dci/lit/btcutil"
+	"github.com/mit-dci/lit/btcutil/psbt"
 	"github.com/mit-dci/lit/btcutil/txscript"
 	"github.com/mit-dci/lit/btcutil/txsort"
+	"github.com/mit-dci/lit/consts"
 	"github.com/mit-dci/lit/crypto/koblitz"
 	"github.com/mit-dci/lit/dlc"
 	"github.com/mit-dci/lit/lnutil"
@@ -25,7 +31,11 @@ func (nd *LitNode) AddContract() (*lnutil.DlcContract, error) {
 	return c, nil
 }
 
-func (nd *LitNode) OfferDlc(peerIdx uint32, cIdx uint64) error {
+// OfferDlc offers a draft contract to a peer.  Our side is funded from ins
+// if given, or from fundPsbt if it's funded outside lit, otherwise the
+// wallet picks.
+func (nd *LitNode) OfferDlc(peerIdx uint32, cIdx uint64, ins []wire.OutPoint,
+	fundPsbt *psbt.Packet) error {
 	c, err := nd.DlcManager.LoadContract(cIdx)
 	if err != nil {
 		return err
@@ -45,28 +55,64 @@ func (nd *LitNode) OfferDlc(peerIdx uint32, cIdx uint64) error {
 		return fmt.Errorf("You need to set an oracle for the contract before offering it")
 	}
 
-	if c.OracleR == nullBytes {
-		return fmt.Errorf("You need to set an R-point for the contract before offering it")
-	}
-
 	if c.OracleTimestamp == 0 {
 		return fmt.Errorf("You need to set a settlement time for the contract before offering it")
 	}
 
+	for i, o := range c.AllOracles() {
+		if c.OracleDigits > 0 {
+			if len(o.DigitR) != int(c.OracleDigits) {
+				return fmt.Errorf("You need to set the R-points of each digit for oracle %d of the contract before offering it", i)
+			}
+			continue
+		}
+		if o.R == nullBytes {
+			return fmt.Errorf("You need to set an R-point for oracle %d of the contract before offering it", i)
+		}
+	}
+
+	if c.OracleDigits > 0 && c.MaxOracleValue() < 0 {
+		return fmt.Errorf("The oracles can't sign values of %d digits in base %d", c.OracleDigits, c.OracleBase)
+	}
+
+	if len(c.Oracles)+1 > consts.MaxDlcOracles {
+		return fmt.Errorf("A contract can't settle on more than %d oracles", consts.MaxDlcOracles)
+	}
+
+	if len(c.OracleSets()) == 0 {
+		return fmt.Errorf("The contract needs %d oracles to agree but only has %d",
+			c.Threshold(), len(c.Oracles)+1)
+	}
+
 	if c.CoinType == dlc.COINTYPE_NOT_SET {
 		return fmt.Errorf("You need to set a coin type for the contract before offering it")
 	}
 
-	if c.Division == nil {
+	if len(c.Division) == 0 {
 		return fmt.Errorf("You need to set a payout division for the contract before offering it")
 	}
 
+	if c.SettlementCount() > consts.MaxDlcSettlements {
+		return fmt.Errorf("The contract has %d settlements, one for each outcome and set of oracles; the peer won't take more than %d",
+			c.SettlementCount(), consts.MaxDlcSettlements)
+	}
+
 	if c.OurFundingAmount+c.TheirFundingAmount == 0 {
 		return fmt.Errorf("You need to set a funding amount for the peers in contract before offering it")
 	}
 
 	c.PeerIdx = peerIdx
 
+	wal, ok := nd.SubWallet[c.CoinType]
+	if !ok {
+		return fmt.Errorf("No wallet of type %d connected", c.CoinType)
+	}
+	c.FeePerByte = wal.EstimateFee(consts.DlcSettleConfTarget)
+	if c.FeePerByte > consts.MaxDlcFeePerByte {
+		// the peer wouldn't take it
+		c.FeePerByte = consts.MaxDlcFeePerByte
+	}
+
 	var kg portxo.KeyGen
 	kg.Depth = 5
 	kg.Step[0] = 44 | 1<<31
@@ -85,8 +131,27 @@ func (nd *LitNode) OfferDlc(peerIdx uint32, cIdx uint64) error {
 		return err
 	}
 
+	ourPayoutPKHKey, err := nd.GetUsePub(kg, UseContractPayoutPKH)
+	if err != nil {
+		return err
+	}
+	copy(c.OurPayoutPKH[:], btcutil.Hash160(ourPayoutPKHKey[:]))
+
+	// if the oracle never signs, we both get our funds back a while after
+	// it should have.  Not before a while from now though, or a contract
+	// on a value that's already out could be refunded instead of settled.
+	refundFrom := c.OracleTimestamp
+	if now := uint64(time.Now().Unix()); now > refundFrom {
+		refundFrom = now
+	}
+	if refundFrom+consts.DlcRefundDelay > math.MaxUint32 {
+		return fmt.Errorf("Settlement time %d is too far off to refund the contract after",
+			c.OracleTimestamp)
+	}
+	c.RefundLockTime = uint32(refundFrom + consts.DlcRefundDelay)
+
 	// Fund the contract
-	err = nd.FundContract(c)
+	err = nd.FundContract(c, ins, fundPsbt)
 	if err != nil {
 		return err
 	}
@@ -131,7 +196,10 @@ func (nd *LitNode) DeclineDlc(cIdx uint64, reason uint8) error {
 	return nil
 }
 
-func (nd *LitNode) AcceptDlc(cIdx uint64) error {
+// AcceptDlc accepts a contract offered to us, funding our side from ins or
+// fundPsbt if given.
+func (nd *LitNode) AcceptDlc(cIdx uint64, ins []wire.OutPoint,
+	fundPsbt *psbt.Packet) error {
 	c, err := nd.DlcManager.LoadContract(cIdx)
 	if err != nil {
 		return err
@@ -145,6 +213,11 @@ func (nd *LitNode) AcceptDlc(cIdx uint64) error {
 		return fmt.Errorf("You are not connected to peer %d, do that first", c.PeerIdx)
 	}
 
+	if c.FeePerByte < 0 || c.FeePerByte > consts.MaxDlcFeePerByte {
+		return fmt.Errorf("Contract fee rate %d is over the %d sat/vbyte limit",
+			c.FeePerByte, consts.MaxDlcFeePerByte)
+	}
+
 	// Preconditions checked - Go execute the acceptance in a separate go routine
 	// while returning the status back to the client
 	go func(nd *LitNode, c *lnutil.DlcContract) {
@@ -152,7 +225,7 @@ func (nd *LitNode) AcceptDlc(cIdx uint64) error {
 		nd.DlcManager.SaveContract(c)
 
 		// Fund the contract
-		err = nd.FundContract(c)
+		err = nd.FundContract(c, ins, fundPsbt)
 		if err != nil {
 			c.Status = lnutil.ContractStatusError
 			nd.DlcManager.SaveContract(c)
@@ -201,7 +274,15 @@ func (nd *LitNode) AcceptDlc(cIdx uint64) error {
 			return
 		}
 
-		msg := lnutil.NewDlcOfferAcceptMsg(c, sigs)
+		refundSig, err := nd.SignRefund(c)
+		if err != nil {
+			logging.Errorf("Error signing refund: %s", err.Error())
+			c.Status = lnutil.ContractStatusError
+			nd.DlcManager.SaveContract(c)
+			return
+		}
+
+		msg := lnutil.NewDlcOfferAcceptMsg(c, sigs, refundSig)
 		c.Status = lnutil.ContractStatusAccepted
 
 		nd.DlcManager.SaveContract(c)
@@ -224,6 +305,7 @@ func (nd *LitNode) DlcOfferHandler(msg lnutil.DlcOfferMsg, peer *RemotePeer) {
 	c.TheirFundMultisigPub = msg.Contract.OurFundMultisigPub
 	c.OurPayoutBase = msg.Contract.TheirPayoutBase
 	c.TheirPayoutBase = msg.Contract.OurPayoutBase
+	c.TheirPayoutPKH = msg.Contract.OurPayoutPKH
 	c.OurChangePKH = msg.Contract.TheirChangePKH
 	c.TheirChangePKH = msg.Contract.OurChangePKH
 	c.TheirIdx = msg.Contract.Idx
@@ -239,6 +321,13 @@ func (nd *LitNode) DlcOfferHandler(msg lnutil.DlcOfferMsg, peer *RemotePeer) {
 	c.OracleA = msg.Contract.OracleA
 	c.OracleR = msg.Contract.OracleR
 	c.OracleTimestamp = msg.Contract.OracleTimestamp
+	c.FeePerByte = msg.Contract.FeePerByte
+	c.RefundLockTime = msg.Contract.RefundLockTime
+	c.Oracles = msg.Contract.Oracles
+	c.OracleThreshold = msg.Contract.OracleThreshold
+	c.OracleDigits = msg.Contract.OracleDigits
+	c.OracleBase = msg.Contract.OracleBase
+	c.OracleDigitR = msg.Contract.OracleDigitR
 
 	err := nd.DlcManager.SaveContract(c)
 	if err != nil {
@@ -250,6 +339,54 @@ func (nd *LitNode) DlcOfferHandler(msg lnutil.DlcOfferMsg, peer *RemotePeer) {
 	if !ok {
 		// We don't have this coin type, automatically decline
 		nd.DeclineDlc(c.Idx, 0x02)
+		return
+	}
+
+	// Without a division there's nothing to settle on
+	if len(c.Division) == 0 {
+		nd.DeclineDlc(c.Idx, 0x08)
+		return
+	}
+
+	// Without a refund after the oracle's due, our funds could be stuck
+	// for good, so decline that too.  Nor can it be so long after (or
+	// after now, if the oracle's already due) that it's as good as none.
+	refundBy := c.OracleTimestamp
+	if now := uint64(time.Now().Unix()); now > refundBy {
+		refundBy = now
+	}
+	if c.RefundLockTime < txscript.LockTimeThreshold ||
+		uint64(c.RefundLockTime) <= c.OracleTimestamp ||
+		uint64(c.RefundLockTime) > refundBy+consts.MaxDlcRefundDelay {
+		nd.DeclineDlc(c.Idx, 0x03)
+		return
+	}
+
+	// We'd sign a settlement for each set of oracles, so there can't be
+	// too many
+	if len(c.Oracles)+1 > consts.MaxDlcOracles || len(c.OracleSets()) == 0 {
+		nd.DeclineDlc(c.Idx, 0x04)
+		return
+	}
+
+	// or digits
+	if c.OracleDigits > consts.MaxDlcOracleDigits ||
+		(c.OracleDigits > 0 && c.MaxOracleValue() < 0) {
+		nd.DeclineDlc(c.Idx, 0x05)
+		return
+	}
+
+	// The settlement fee comes out of both our payouts, so the peer
+	// can't pick any rate it likes
+	if c.FeePerByte < 0 || c.FeePerByte > consts.MaxDlcFeePerByte {
+		nd.DeclineDlc(c.Idx, 0x06)
+		return
+	}
+
+	// We sign a settlement for each outcome and set of oracles
+	if c.SettlementCount() > consts.MaxDlcSettlements {
+		nd.DeclineDlc(c.Idx, 0x07)
+		return
 	}
 
 }
@@ -299,7 +436,22 @@ func (nd *LitNode) DlcAcceptHandler(msg lnutil.DlcOfferAcceptMsg, peer *RemotePe
 		return err
 	}
 
-	outMsg := lnutil.NewDlcContractAckMsg(c, sigs)
+	// don't go on unless we can get our funds back if the oracle never signs
+	c.TheirRefundSignature = msg.RefundSignature
+	err = nd.verifyRefundSig(c)
+	if err != nil {
+		logging.Errorf("DlcAcceptHandler verifyRefundSig err %s\n", err.Error())
+		c.Status = lnutil.ContractStatusError
+		nd.DlcManager.SaveContract(c)
+		return err
+	}
+
+	refundSig, err := nd.SignRefund(c)
+	if err != nil {
+		return err
+	}
+
+	outMsg := lnutil.NewDlcContractAckMsg(c, sigs, refundSig)
 	c.Status = lnutil.ContractStatusAcknowledged
 
 	err = nd.DlcManager.SaveContract(c)
@@ -321,6 +473,18 @@ func (nd *LitNode) DlcContractAckHandler(msg lnutil.DlcContractAckMsg, peer *Rem
 	}
 
 	// TODO: Check signatures
+	c.TheirSettlementSignatures = msg.SettlementSignatures
+
+	// don't sign the funding tx unless we can get our funds back if the
+	// oracle never signs
+	c.TheirRefundSignature = msg.RefundSignature
+	err = nd.verifyRefundSig(c)
+	if err != nil {
+		logging.Errorf("DlcContractAckHandler verifyRefundSig err %s\n", err.Error())
+		c.Status = lnutil.ContractStatusError
+		nd.DlcManager.SaveContract(c)
+		return
+	}
 
 	c.Status = lnutil.ContractStatusAcknowledged
 
@@ -343,6 +507,16 @@ func (nd *LitNode) DlcContractAckHandler(msg lnutil.DlcContractAckMsg, peer *Rem
 		return
 	}
 
+	// if our inputs get signed outside lit, that picks up from here
+	ext, err := nd.externalDlcFunding(c, &tx)
+	if err != nil {
+		logging.Errorf("DlcContractAckHandler externalDlcFunding err %s\n", err.Error())
+		return
+	}
+	if ext {
+		return
+	}
+
 	err = wal.SignMyInputs(&tx)
 	if err != nil {
 		logging.Errorf("DlcContractAckHandler SignMyInputs err %s\n", err.Error())
@@ -370,26 +544,49 @@ func (nd *LitNode) DlcFundingSigsHandler(msg lnutil.DlcContractFundingSigsMsg, p
 		return
 	}
 
-	wal.SignMyInputs(msg.SignedFundingTx)
+	ext, err := nd.externalDlcFunding(c, msg.SignedFundingTx)
+	if err != nil {
+		logging.Errorf("DlcFundingSigsHandler externalDlcFunding err %s\n", err.Error())
+		return
+	}
+	if ext {
+		return
+	}
 
-	wal.DirectSendTx(msg.SignedFundingTx)
+	wal.SignMyInputs(msg.SignedFundingTx)
 
-	err = wal.WatchThis(c.FundingOutpoint)
+	err = nd.publishDlcFunding(c, msg.SignedFundingTx)
 	if err != nil {
-		logging.Errorf("DlcFundingSigsHandler WatchThis err %s\n", err.Error())
+		logging.Errorf("DlcFundingSigsHandler %s\n", err.Error())
 		return
 	}
+}
+
+// publishDlcFunding broadcasts the fully signed funding tx of a contract,
+// and sends the peer proof of it.
+func (nd *LitNode) publishDlcFunding(c *lnutil.DlcContract, tx *wire.MsgTx) error {
+	wal, ok := nd.SubWallet[c.CoinType]
+	if !ok {
+		return fmt.Errorf("No wallet for cointype %d", c.CoinType)
+	}
+
+	wal.DirectSendTx(tx)
+
+	err := wal.WatchThis(c.FundingOutpoint)
+	if err != nil {
+		return fmt.Errorf("WatchThis err %s", err.Error())
+	}
 
 	c.Status = lnutil.ContractStatusActive
 	err = nd.DlcManager.SaveContract(c)
 	if err != nil {
-		logging.Errorf("DlcFundingSigsHandler SaveContract err %s\n", err.Error())
-		return
+		return fmt.Errorf("SaveContract err %s", err.Error())
 	}
 
-	outMsg := lnutil.NewDlcContractSigProofMsg(c, msg.SignedFundingTx)
+	outMsg := lnutil.NewDlcContractSigProofMsg(c, tx)
 
 	nd.tmpSendLitMsg(outMsg)
+	return nil
 }
 
 func (nd *LitNode) DlcSigProofHandler(msg lnutil.DlcContractSigProofMsg, peer *RemotePeer) {
@@ -446,18 +643,25 @@ func (nd *LitNode) SignSettlementDivisions(c *lnutil.DlcContract) ([]lnutil.DlcC
 
 	c.FundingOutpoint = wire.OutPoint{Hash: fundingTx.TxHash(), Index: 0}
 
-	returnValue := make([]lnutil.DlcContractSettlementSignature, len(c.Division))
-	for i, d := range c.Division {
-		tx, err := lnutil.SettlementTx(c, d, true)
-		if err != nil {
-			return nil, err
+	sets := c.OracleSets()
+	outcomes := c.Outcomes()
+	returnValue := make([]lnutil.DlcContractSettlementSignature, 0,
+		len(outcomes)*len(sets))
+	for _, d := range outcomes {
+		for _, set := range sets {
+			tx, err := lnutil.SettlementTx(c, d, set, true)
+			if err != nil {
+				return nil, err
+			}
+			sig, err := nd.SignSettlementTx(c, tx, priv)
+			if err != nil {
+				return nil, err
+			}
+			returnValue = append(returnValue, lnutil.DlcContractSettlementSignature{
+				Outcome:   d.OracleValue,
+				Signature: sig,
+			})
 		}
-		sig, err := nd.SignSettlementTx(c, tx, priv)
-		if err != nil {
-			return nil, err
-		}
-		returnValue[i].Outcome = d.OracleValue
-		returnValue[i].Signature = sig
 	}
 
 	return returnValue, nil
@@ -483,9 +687,26 @@ func (nd *LitNode) BuildDlcFundingTransaction(c *lnutil.DlcContract) (wire.MsgTx
 		theirInputTotal += u.Value
 	}
 
+	theirChange, err := dlcFundingChange(
+		theirInputTotal, c.TheirFundingAmount, c.FeePerByte)
+	if err != nil {
+		return *tx, err
+	}
+	ourChange, err := dlcFundingChange(
+		ourInputTotal, c.OurFundingAmount, c.FeePerByte)
+	if err != nil {
+		return *tx, err
+	}
+
 	// add change and sort
-	tx.AddTxOut(wire.NewTxOut(theirInputTotal-c.TheirFundingAmount-500, lnutil.DirectWPKHScriptFromPKH(c.TheirChangePKH)))
-	tx.AddTxOut(wire.NewTxOut(ourInputTotal-c.OurFundingAmount-500, lnutil.DirectWPKHScriptFromPKH(c.OurChangePKH)))
+	if theirChange > 0 {
+		tx.AddTxOut(wire.NewTxOut(theirChange,
+			lnutil.DirectWPKHScriptFromPKH(c.TheirChangePKH)))
+	}
+	if ourChange > 0 {
+		tx.AddTxOut(wire.NewTxOut(ourChange,
+			lnutil.DirectWPKHScriptFromPKH(c.OurChangePKH)))
+	}
 
 	txsort.InPlaceSort(tx)
 
@@ -505,13 +726,44 @@ func (nd *LitNode) BuildDlcFundingTransaction(c *lnutil.DlcContract) (wire.MsgTx
 
 }
 
-func (nd *LitNode) FundContract(c *lnutil.DlcContract) error {
+// dlcFundingChange gives the change one side of a contract gets in the
+// funding tx, from inputs adding up to inputTotal.  Each side pays a flat 500
+// sat fee, and the same rule as MaybeSend: change that's not worth its own
+// output goes to the miners, and comes back as 0.
+func dlcFundingChange(inputTotal, fundingAmount, feePerByte int64) (int64, error) {
+	change := inputTotal - fundingAmount - 500
+	if change < 0 {
+		return 0, fmt.Errorf("funding inputs have %d, need %d plus 500 fee",
+			inputTotal, fundingAmount)
+	}
+	changeOutFee := 30 * feePerByte
+	if change <= consts.DustCutoff+changeOutFee {
+		return 0, nil
+	}
+	return change - changeOutFee, nil
+}
+
+// FundContract picks the inputs for our side of a contract: ins if given,
+// the inputs of fundPsbt if it's funded outside lit, or whatever the wallet
+// picks.
+func (nd *LitNode) FundContract(c *lnutil.DlcContract, ins []wire.OutPoint,
+	fundPsbt *psbt.Packet) error {
 	wal, ok := nd.SubWallet[c.CoinType]
 	if !ok {
 		return fmt.Errorf("No wallet of type %d connected", c.CoinType)
 	}
 
-	utxos, _, err := wal.PickUtxos(int64(c.OurFundingAmount), 500, wal.Fee(), true)
+	if fundPsbt != nil {
+		return nd.fundContractPsbt(c, fundPsbt)
+	}
+
+	var utxos portxo.TxoSliceByBip69
+	var err error
+	if len(ins) > 0 {
+		utxos, _, err = wal.SelectUtxos(ins, int64(c.OurFundingAmount), 500, wal.Fee(), true)
+	} else {
+		utxos, _, err = wal.PickUtxos(int64(c.OurFundingAmount), 500, wal.Fee(), true)
+	}
 	if err != nil {
 		return err
 	}
@@ -529,7 +781,12 @@ func (nd *LitNode) FundContract(c *lnutil.DlcContract) error {
 	return nil
 }
 
-func (nd *LitNode) SettleContract(cIdx uint64, oracleValue int64, oracleSig [32]byte) ([32]byte, [32]byte, error) {
+// SettleContract settles a contract on oracleValue, with the signatures on it
+// of the contract's oracles in the order of AllOracles: one each, or for
+// contracts on digits, one on each digit.  Those that haven't signed are left
+// empty; it takes Threshold of them.
+func (nd *LitNode) SettleContract(cIdx uint64, oracleValue int64,
+	oracleSigs [][][32]byte) ([32]byte, [32]byte, error) {
 
 	c, err := nd.DlcManager.LoadContract(cIdx)
 	if err != nil {
@@ -537,16 +794,56 @@ func (nd *LitNode) SettleContract(cIdx uint64, oracleValue int64, oracleSig [32]
 		return [32]byte{}, [32]byte{}, err
 	}
 
-	c.Status = lnutil.ContractStatusSettling
-	err = nd.DlcManager.SaveContract(c)
+	// with the wrong signatures we couldn't claim our output, and the peer
+	// could take it after the timeout
+	oracles := c.AllOracles()
+	if len(oracleSigs) != len(oracles) {
+		return [32]byte{}, [32]byte{}, fmt.Errorf(
+			"got %d oracle signatures, contract has %d oracles",
+			len(oracleSigs), len(oracles))
+	}
+	d, err := c.GetOutcome(oracleValue)
+	if err != nil {
+		logging.Errorf("SettleContract GetOutcome err %s\n", err.Error())
+		return [32]byte{}, [32]byte{}, err
+	}
+	// the settlement pays to the signatures on the digits the outcome
+	// starts with, or on the whole value
+	signed := len(d.Digits)
+	if c.OracleDigits == 0 {
+		signed = 1
+	}
+	var set []int
+	oracleScalar := new(big.Int)
+	for i, sigs := range oracleSigs {
+		if len(sigs) == 0 || len(set) == c.Threshold() {
+			continue
+		}
+		// any Threshold oracles which signed it will do
+		err = c.VerifyOracleSigs(i, oracleValue, sigs)
+		if err != nil {
+			logging.Warnf("SettleContract: skipping oracle %d: %s", i, err.Error())
+			continue
+		}
+		set = append(set, i)
+		for _, sig := range sigs[:signed] {
+			oracleScalar.Add(oracleScalar, new(big.Int).SetBytes(sig[:]))
+		}
+		oracleScalar.Mod(oracleScalar, koblitz.S256().N)
+	}
+	if len(set) < c.Threshold() {
+		return [32]byte{}, [32]byte{}, fmt.Errorf(
+			"contract needs %d good oracle signatures, got %d", c.Threshold(), len(set))
+	}
+	setIdx, err := c.OracleSetIdx(set)
 	if err != nil {
-		logging.Errorf("SettleContract SaveContract err %s\n", err.Error())
 		return [32]byte{}, [32]byte{}, err
 	}
 
-	d, err := c.GetDivision(oracleValue)
+	c.Status = lnutil.ContractStatusSettling
+	err = nd.DlcManager.SaveContract(c)
 	if err != nil {
-		logging.Errorf("SettleContract GetDivision err %s\n", err.Error())
+		logging.Errorf("SettleContract SaveContract err %s\n", err.Error())
 		return [32]byte{}, [32]byte{}, err
 	}
 
@@ -568,7 +865,7 @@ func (nd *LitNode) SettleContract(cIdx uint64, oracleValue int64, oracleSig [32]
 		return [32]byte{}, [32]byte{}, fmt.Errorf("SettleContract Could not get private key for contract %d", c.Idx)
 	}
 
-	settleTx, err := lnutil.SettlementTx(c, *d, false)
+	settleTx, err := lnutil.SettlementTx(c, *d, set, false)
 	if err != nil {
 		logging.Errorf("SettleContract SettlementTx err %s\n", err.Error())
 		return [32]byte{}, [32]byte{}, err
@@ -582,7 +879,10 @@ func (nd *LitNode) SettleContract(cIdx uint64, oracleValue int64, oracleSig [32]
 
 	myBigSig := sig64.SigDecompress(mySig)
 
-	theirSig, err := c.GetTheirSettlementSignature(oracleValue)
+	theirSig, err := c.GetTheirSettlementSignature(d.OracleValue, setIdx)
+	if err != nil {
+		return [32]byte{}, [32]byte{}, err
+	}
 	theirBigSig := sig64.SigDecompress(theirSig)
 
 	// put the sighash all byte on the end of both signatures
@@ -609,7 +909,29 @@ func (nd *LitNode) SettleContract(cIdx uint64, oracleValue int64, oracleSig [32]
 		return [32]byte{}, [32]byte{}, err
 	}
 
-	// TODO: Claim the contract settlement output back to our wallet - otherwise the peer can claim it after locktime.
+	// the claim pays for itself out of our settlement output; if we got
+	// nothing, or too little to be worth the fee, there's nothing to claim
+	claimFee := wal.EstimateFee(consts.DlcSettleConfTarget) * consts.DlcClaimTxSize
+	var ourValue int64
+	if len(settleTx.TxOut) > 0 && !bytes.Equal(settleTx.TxOut[0].PkScript,
+		lnutil.DirectWPKHScriptFromPKH(c.TheirPayoutPKH)) {
+		ourValue = settleTx.TxOut[0].Value
+	}
+	if ourValue-claimFee < consts.DustCutoff {
+		logging.Infof("SettleContract: not claiming contract %d settlement"+
+			" output, %d left after fees is below dust\n", c.Idx,
+			ourValue-claimFee)
+		c.Status = lnutil.ContractStatusClosed
+		err = nd.DlcManager.SaveContract(c)
+		if err != nil {
+			return [32]byte{}, [32]byte{}, err
+		}
+		nd.publishDlcSettled(c, settleTx.TxHash(), [32]byte{})
+		return settleTx.TxHash(), [32]byte{}, nil
+	}
+
+	// Claim the contract settlement output back to our wallet - otherwise
+	// the peer can claim it after locktime.
 	txClaim := wire.NewMsgTx()
 	txClaim.Version = 2
 
@@ -617,13 +939,22 @@ func (nd *LitNode) SettleContract(cIdx uint64, oracleValue int64, oracleSig [32]
 	txClaim.AddTxIn(wire.NewTxIn(&settleOutpoint, nil, nil))
 
 	addr, err := wal.NewAdr()
-	txClaim.AddTxOut(wire.NewTxOut(d.ValueOurs-1000, lnutil.DirectWPKHScriptFromPKH(addr))) // todo calc fee - fee is double here because the contract output already had the fee deducted in the settlement TX
+	if err != nil {
+		logging.Errorf("SettleContract NewAdr err %s", err.Error())
+		return [32]byte{}, [32]byte{}, err
+	}
+	// the settlement output already had our share of the settlement fee taken
+	// off, this pays for the claim tx itself
+	txClaim.AddTxOut(wire.NewTxOut(settleTx.TxOut[0].Value-claimFee,
+		lnutil.DirectWPKHScriptFromPKH(addr)))
 
 	kg.Step[2] = UseContractPayoutBase
 	privSpend, _ := wal.GetPriv(kg)
 
 	pubSpend := wal.GetPub(kg)
-	privOracle, pubOracle := koblitz.PrivKeyFromBytes(koblitz.S256(), oracleSig[:])
+	// the oracles' signatures add up to the key their sig pubkeys add up to
+	privOracle, pubOracle := koblitz.PrivKeyFromBytes(koblitz.S256(),
+		lnutil.BigIntToEncodedBytes(oracleScalar)[:])
 	privContractOutput := lnutil.CombinePrivateKeys(privSpend, privOracle)
 
 	var pubOracleBytes [33]byte
@@ -650,5 +981,7 @@ func (nd *LitNode) SettleContract(cIdx uint64, oracleValue int64, oracleSig [32]
 	if err != nil {
 		return [32]byte{}, [32]byte{}, err
 	}
+
+	nd.publishDlcSettled(c, settleTx.TxHash(), txClaim.TxHash())
 	return settleTx.TxHash(), txClaim.TxHash(), nil
 }
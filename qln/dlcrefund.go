@@ -0,0 +1,173 @@
+package qln
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mit-dci/lit/btcutil/txscript"
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/logging"
+	"github.com/mit-dci/lit/portxo"
+	"github.com/mit-dci/lit/sig64"
+	"github.com/mit-dci/lit/wire"
+)
+
+// contractFundPriv gives our private key in the funding multisig of a
+// contract.
+func (nd *LitNode) contractFundPriv(c *lnutil.DlcContract) (*koblitz.PrivateKey, error) {
+	wal, ok := nd.SubWallet[c.CoinType]
+	if !ok {
+		return nil, fmt.Errorf("Wallet of type %d not found", c.CoinType)
+	}
+
+	var kg portxo.KeyGen
+	kg.Depth = 5
+	kg.Step[0] = 44 | 1<<31
+	kg.Step[1] = c.CoinType | 1<<31
+	kg.Step[2] = UseContractFundMultisig
+	kg.Step[3] = c.PeerIdx | 1<<31
+	kg.Step[4] = uint32(c.Idx) | 1<<31
+
+	priv, err := wal.GetPriv(kg)
+	if err != nil {
+		return nil, fmt.Errorf("Could not get private key for contract %d", c.Idx)
+	}
+	return priv, nil
+}
+
+// SignRefund gives our signature for the refund tx of a contract.  The
+// funding outpoint has to be known, so it's signed after the settlements.
+func (nd *LitNode) SignRefund(c *lnutil.DlcContract) ([64]byte, error) {
+	priv, err := nd.contractFundPriv(c)
+	if err != nil {
+		return [64]byte{}, err
+	}
+	tx, err := lnutil.RefundTx(c)
+	if err != nil {
+		return [64]byte{}, err
+	}
+	return nd.SignSettlementTx(c, tx, priv)
+}
+
+// verifyRefundSig checks the peer's signature for the refund tx of a
+// contract.
+func (nd *LitNode) verifyRefundSig(c *lnutil.DlcContract) error {
+	tx, err := lnutil.RefundTx(c)
+	if err != nil {
+		return err
+	}
+
+	pre, _, err := lnutil.FundTxScript(c.OurFundMultisigPub,
+		c.TheirFundMultisigPub)
+	if err != nil {
+		return err
+	}
+	parsed, err := txscript.ParseScript(pre)
+	if err != nil {
+		return err
+	}
+	hCache := txscript.NewTxSigHashes(tx)
+	hash := txscript.CalcWitnessSignatureHash(parsed, hCache,
+		txscript.SigHashAll, tx, 0, c.OurFundingAmount+c.TheirFundingAmount)
+
+	pSig, err := koblitz.ParseDERSignature(
+		sig64.SigDecompress(c.TheirRefundSignature), koblitz.S256())
+	if err != nil {
+		return err
+	}
+	theirPub, err := koblitz.ParsePubKey(c.TheirFundMultisigPub[:],
+		koblitz.S256())
+	if err != nil {
+		return err
+	}
+	if !pSig.Verify(hash, theirPub) {
+		return fmt.Errorf("invalid refund signature for contract %d", c.Idx)
+	}
+	return nil
+}
+
+// signedRefundTx gives the refund tx of a contract, signed by both of us.
+func (nd *LitNode) signedRefundTx(c *lnutil.DlcContract) (*wire.MsgTx, error) {
+	tx, err := lnutil.RefundTx(c)
+	if err != nil {
+		return nil, err
+	}
+	priv, err := nd.contractFundPriv(c)
+	if err != nil {
+		return nil, err
+	}
+	mySig, err := nd.SignSettlementTx(c, tx, priv)
+	if err != nil {
+		return nil, err
+	}
+
+	// put the sighash all byte on the end of both signatures
+	myBigSig := append(sig64.SigDecompress(mySig), byte(txscript.SigHashAll))
+	theirBigSig := append(sig64.SigDecompress(c.TheirRefundSignature),
+		byte(txscript.SigHashAll))
+
+	pre, swap, err := lnutil.FundTxScript(c.OurFundMultisigPub,
+		c.TheirFundMultisigPub)
+	if err != nil {
+		return nil, err
+	}
+	if swap {
+		tx.TxIn[0].Witness = SpendMultiSigWitStack(pre, theirBigSig, myBigSig)
+	} else {
+		tx.TxIn[0].Witness = SpendMultiSigWitStack(pre, myBigSig, theirBigSig)
+	}
+	return tx, nil
+}
+
+// RefundContracts sends out the refund tx of each contract in coinType the
+// oracle hasn't signed for by its refund locktime.  It's sent again with each
+// block until it's in one, as it's turned away until the median time of the
+// last blocks passes the locktime.  Once it's in, the contract is closed
+// like a settled one, by claiming our output.
+func (nd *LitNode) RefundContracts(coinType uint32) ([][32]byte, error) {
+	wal, ok := nd.SubWallet[coinType]
+	if !ok {
+		return nil, fmt.Errorf("no wallet of type %d", coinType)
+	}
+
+	contracts, err := nd.DlcManager.ListContracts()
+	if err != nil {
+		return nil, err
+	}
+
+	var txids [][32]byte
+	now := time.Now().Unix()
+	for _, c := range contracts {
+		if c.CoinType != coinType || c.RefundLockTime == 0 ||
+			now < int64(c.RefundLockTime) {
+			continue
+		}
+		if c.Status != lnutil.ContractStatusActive &&
+			c.Status != lnutil.ContractStatusAwaitingOracle &&
+			c.Status != lnutil.ContractStatusRefunding {
+			continue
+		}
+
+		tx, err := nd.signedRefundTx(c)
+		if err != nil {
+			logging.Errorf("can't refund contract %d: %s", c.Idx, err.Error())
+			continue
+		}
+		err = wal.DirectSendTx(tx)
+		if err != nil {
+			logging.Warnf("sending refund of contract %d: %s", c.Idx, err.Error())
+			continue
+		}
+		txids = append(txids, tx.TxHash())
+
+		if c.Status != lnutil.ContractStatusRefunding {
+			c.Status = lnutil.ContractStatusRefunding
+			err = nd.DlcManager.SaveContract(c)
+			if err != nil {
+				return txids, err
+			}
+		}
+	}
+	return txids, nil
+}
@@ -0,0 +1,156 @@
+package qln
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/mit-dci/lit/consts"
+	"github.com/mit-dci/lit/dlc"
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/logging"
+)
+
+// watchContracts settles contracts by itself.  Once a contract's settlement
+// time passes it waits on the oracle, and if the oracle was imported from the
+// web, polls it for what it published.  Contracts whose oracle isn't on the
+// web wait to be settled by hand, or refunded.
+func (nd *LitNode) watchContracts() {
+	for {
+		time.Sleep(consts.OraclePollInterval * time.Second)
+
+		contracts, err := nd.DlcManager.ListContracts()
+		if err != nil {
+			logging.Errorf("watchContracts ListContracts err %s", err.Error())
+			continue
+		}
+
+		now := time.Now().Unix()
+		for _, c := range contracts {
+			if c.Status == lnutil.ContractStatusActive &&
+				now >= int64(c.OracleTimestamp) {
+				c.Status = lnutil.ContractStatusAwaitingOracle
+				err = nd.DlcManager.SaveContract(c)
+				if err != nil {
+					logging.Errorf("watchContracts SaveContract err %s", err.Error())
+					continue
+				}
+			}
+			if c.Status != lnutil.ContractStatusAwaitingOracle {
+				continue
+			}
+
+			err = nd.autoSettle(c)
+			if err != nil {
+				logging.Warnf("can't settle contract %d: %s", c.Idx, err.Error())
+			}
+		}
+	}
+}
+
+// autoSettle settles a contract with what its oracles published, once
+// enough of them have published the same value.  If enough have published
+// each of more than one value, it doesn't: which is right is for the user
+// to say.
+func (nd *LitNode) autoSettle(c *lnutil.DlcContract) error {
+	oracles := c.AllOracles()
+	// signatures on each value published, in the order of the oracles
+	published := make(map[int64][][][32]byte)
+	for i, co := range oracles {
+		o, err := nd.DlcManager.FindOracleByKey(co.A)
+		if err != nil || len(o.Url) == 0 {
+			// nothing to poll
+			continue
+		}
+
+		value, sigs, err := fetchOracleValue(c, o, co)
+		if err != nil {
+			logging.Debugf("oracle %s hasn't published for contract %d: %s",
+				o.Name, c.Idx, err.Error())
+			continue
+		}
+
+		err = c.VerifyOracleSigs(i, value, sigs)
+		if err != nil {
+			logging.Warnf("oracle %s published a bad signature for contract %d: %s",
+				o.Name, c.Idx, err.Error())
+			continue
+		}
+
+		if published[value] == nil {
+			published[value] = make([][][32]byte, len(oracles))
+		}
+		published[value][i] = sigs
+	}
+
+	var values []int64
+	for value, sigs := range published {
+		signed := 0
+		for _, s := range sigs {
+			if len(s) > 0 {
+				signed++
+			}
+		}
+		if signed >= c.Threshold() {
+			values = append(values, value)
+		}
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	if len(values) > 1 {
+		return fmt.Errorf("oracles published conflicting values %v", values)
+	}
+
+	value := values[0]
+	logging.Infof("at least %d of %d oracles published %d, settling contract %d",
+		c.Threshold(), len(oracles), value, c.Idx)
+	_, _, err := nd.SettleContract(c.Idx, value, published[value])
+	return err
+}
+
+// fetchOracleValue gets the value an oracle of a contract published and its
+// signatures on it: on the whole value, or on each digit, which make up the
+// value.
+func fetchOracleValue(c *lnutil.DlcContract, o *dlc.DlcOracle,
+	co lnutil.DlcContractOracle) (int64, [][32]byte, error) {
+
+	if c.OracleDigits == 0 {
+		value, sig, err := o.FetchPublication(co.R)
+		return value, [][32]byte{sig}, err
+	}
+
+	var value int64
+	sigs := make([][32]byte, len(co.DigitR))
+	for i, r := range co.DigitR {
+		digit, sig, err := o.FetchPublication(r)
+		if err != nil {
+			return 0, nil, err
+		}
+		if digit < 0 || digit >= int64(c.OracleBase) {
+			return 0, nil, fmt.Errorf("digit %d is %d, not in base %d", i,
+				digit, c.OracleBase)
+		}
+		value = value*int64(c.OracleBase) + digit
+		sigs[i] = sig
+	}
+	return value, sigs, nil
+}
+
+// publishDlcSettled lets whoever's listening know a contract's settled.
+func (nd *LitNode) publishDlcSettled(c *lnutil.DlcContract,
+	settleTxid, claimTxid [32]byte) {
+
+	settled := DlcSettledEvent{
+		CIdx:       c.Idx,
+		CoinType:   c.CoinType,
+		SettleTxid: settleTxid,
+		ClaimTxid:  claimTxid,
+	}
+	if succeed, err := nd.Events.Publish(settled); err != nil {
+		logging.Errorf("DlcSettled publish err %s", err)
+	} else if !succeed {
+		logging.Errorf("DlcSettled publish did not succeed")
+	}
+}
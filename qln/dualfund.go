@@ -437,9 +437,8 @@ func (nd *LitNode) DualFundingAcceptHandler(msg lnutil.DualFundingAcceptMsg) {
 	q.State = new(StatCom)
 	q.State.StateIdx = 0
 	q.State.MyAmt = nd.InProgDual.OurAmount
-	// get fee from sub wallet.  Later should make fee per channel and update state
-	// based on size
-	q.State.Fee = nd.SubWallet[q.Coin()].Fee() * consts.QcStateFee
+	q.State.Fee = nd.stateFee(q.Coin())
+	q.State.JusticeFee = nd.justiceFee(q.Coin())
 	q.Value = nd.InProgDual.OurAmount + nd.InProgDual.TheirAmount
 
 	q.State.NextHTLCBase = msg.OurNextHTLCBase
@@ -548,11 +547,18 @@ func (nd *LitNode) BuildDualFundingTransaction() (*wire.MsgTx, error) {
 		counterPartyChange = ourInputTotal - nd.InProgDual.OurAmount - consts.DualFundFee
 	}
 
-	changeScriptInitiator := lnutil.DirectWPKHScriptFromPKH(initiatorChangeAddress)
-	tx.AddTxOut(wire.NewTxOut(initiatorChange, changeScriptInitiator))
+	// Coin selection may have found inputs which don't need change; what's
+	// left under the dust cutoff goes to the miners, as in MaybeSend.  Both
+	// sides see the same inputs, so they agree on which outputs exist.
+	if initiatorChange >= consts.DustCutoff {
+		changeScriptInitiator := lnutil.DirectWPKHScriptFromPKH(initiatorChangeAddress)
+		tx.AddTxOut(wire.NewTxOut(initiatorChange, changeScriptInitiator))
+	}
 
-	changeScriptCounterParty := lnutil.DirectWPKHScriptFromPKH(counterPartyChangeAddress)
-	tx.AddTxOut(wire.NewTxOut(counterPartyChange, changeScriptCounterParty))
+	if counterPartyChange >= consts.DustCutoff {
+		changeScriptCounterParty := lnutil.DirectWPKHScriptFromPKH(counterPartyChangeAddress)
+		tx.AddTxOut(wire.NewTxOut(counterPartyChange, changeScriptCounterParty))
+	}
 
 	txsort.InPlaceSort(tx)
 
@@ -624,8 +630,8 @@ func (nd *LitNode) DualFundChanDescHandler(msg lnutil.ChanDescMsg) error {
 	qc.State = new(StatCom)
 	// similar to SIGREV in pushpull
 
-	// TODO assumes both parties use same fee
-	qc.State.Fee = wal.Fee() * consts.QcStateFee
+	qc.State.Fee = nd.stateFee(qc.Coin())
+	qc.State.JusticeFee = nd.justiceFee(qc.Coin())
 	qc.State.MyAmt = msg.InitPayment
 
 	qc.State.Data = msg.Data
@@ -43,6 +43,12 @@ func makeTmpNewPeerHandler(nd *LitNode) func(eventbus.Event) eventbus.EventHandl
 			rpeer.OpMap[opArr] = q.Idx()
 		}
 
+		// if we lost state for any channels with this peer, ask it to break them
+		go nd.requestRecoveredBreaks(peerIdx)
+
+		// and fail back parts of payments we were holding from it
+		go nd.failStaleParts(peerIdx)
+
 		return eventbus.EHANDLE_OK
 	}
 }
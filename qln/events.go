@@ -34,3 +34,23 @@ func (e ChannelStateUpdateEvent) Name() string {
 func (e ChannelStateUpdateEvent) Flags() uint8 {
 	return eventbus.EFLAG_ASYNC
 }
+
+// DlcSettledEvent is published once a contract is settled on chain and
+// we've claimed what it paid us.
+type DlcSettledEvent struct {
+	CIdx     uint64
+	CoinType uint32
+
+	// the tx which spent the contract funding, and ours claiming from it
+	SettleTxid, ClaimTxid [32]byte
+}
+
+// Name returns the name of the contract settled event
+func (e DlcSettledEvent) Name() string {
+	return "qln.dlc.settled"
+}
+
+// Flags returns the flags for the event
+func (e DlcSettledEvent) Flags() uint8 {
+	return eventbus.EFLAG_ASYNC
+}
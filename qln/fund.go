@@ -100,14 +100,41 @@ an exact timing for the payment.
 
 */
 
+// stateFee is the fee channel states pay.  Both ends have to build the same
+// commitment txs, so it's the coin's fixed rate rather than a fee estimate,
+// which differs from node to node.
+func (nd *LitNode) stateFee(coin uint32) int64 {
+	return nd.SubWallet[coin].Params().FeePerByte * consts.QcStateFee
+}
+
 // FundChannel opens a channel with a peer.  Doesn't return until the channel
 // has been created.  Maybe timeout if it takes too long?
-func (nd *LitNode) FundChannel(
-	peerIdx, cointype uint32, ccap, initSend int64, data [32]byte) (uint32, error) {
+// The funding tx pays a fee rate to confirm within confTarget blocks, and
+// spends ins if given, otherwise whatever the wallet picks.
+func (nd *LitNode) FundChannel(peerIdx, cointype uint32, ccap, initSend int64,
+	confTarget uint32, ins []wire.OutPoint, data [32]byte) (uint32, error) {
+
+	err := nd.startFund(peerIdx, cointype, ccap, initSend, confTarget, ins,
+		false, data)
+	if err != nil {
+		return 0, err
+	}
+
+	// wait until it's done!
+	idx := <-nd.InProg.done
+
+	return idx, nil
+}
+
+// startFund checks that a channel can be made, sets up InProg and asks the
+// peer for points.  If external, the funding tx will come from a PSBT, and
+// confTarget and ins aren't used.
+func (nd *LitNode) startFund(peerIdx, cointype uint32, ccap, initSend int64,
+	confTarget uint32, ins []wire.OutPoint, external bool, data [32]byte) error {
 
 	_, ok := nd.SubWallet[cointype]
 	if !ok {
-		return 0, fmt.Errorf("No wallet of type %d connected", cointype)
+		return fmt.Errorf("No wallet of type %d connected", cointype)
 	}
 
 	nd.InProg.mtx.Lock()
@@ -116,49 +143,49 @@ func (nd *LitNode) FundChannel(
 	_, ok = nd.ConnectedCoinTypes[cointype]
 	if !ok {
 		nd.InProg.mtx.Unlock()
-		return 0, fmt.Errorf("No daemon of type %d connected. Can't fund, only receive", cointype)
+		return fmt.Errorf("No daemon of type %d connected. Can't fund, only receive", cointype)
 	}
 
 	fee := nd.SubWallet[cointype].Fee() * 1000
 
 	if nd.InProg.PeerIdx != 0 {
 		nd.InProg.mtx.Unlock()
-		return 0, fmt.Errorf("fund with peer %d not done yet", nd.InProg.PeerIdx)
+		return fmt.Errorf("fund with peer %d not done yet", nd.InProg.PeerIdx)
 	}
 
 	if initSend < 0 || ccap < 0 {
 		nd.InProg.mtx.Unlock()
-		return 0, fmt.Errorf("Can't have negative send or capacity")
+		return fmt.Errorf("Can't have negative send or capacity")
 	}
 	if ccap < consts.MinChanCapacity { // limit for now
 		nd.InProg.mtx.Unlock()
-		return 0, fmt.Errorf("Min channel capacity 1M sat")
+		return fmt.Errorf("Min channel capacity 1M sat")
 	}
 	if initSend > ccap {
 		nd.InProg.mtx.Unlock()
-		return 0, fmt.Errorf("Can't send %d in %d capacity channel", initSend, ccap)
+		return fmt.Errorf("Can't send %d in %d capacity channel", initSend, ccap)
 	}
 
 	if initSend != 0 && initSend < consts.MinOutput+fee {
 		nd.InProg.mtx.Unlock()
-		return 0, fmt.Errorf("Can't send %d as initial send because MinOutput is %d", initSend, consts.MinOutput+fee)
+		return fmt.Errorf("Can't send %d as initial send because MinOutput is %d", initSend, consts.MinOutput+fee)
 	}
 
 	if ccap-initSend < consts.MinOutput+fee {
 		nd.InProg.mtx.Unlock()
-		return 0, fmt.Errorf("Can't send %d as initial send because MinOutput is %d and you would only have %d", initSend, consts.MinOutput+fee, ccap-initSend)
+		return fmt.Errorf("Can't send %d as initial send because MinOutput is %d and you would only have %d", initSend, consts.MinOutput+fee, ccap-initSend)
 	}
 
 	// TODO - would be convenient if it auto connected to the peer huh
 	if !nd.ConnectedToPeer(peerIdx) {
 		nd.InProg.mtx.Unlock()
-		return 0, fmt.Errorf("Not connected to peer %d. Do that yourself.", peerIdx)
+		return fmt.Errorf("Not connected to peer %d. Do that yourself.", peerIdx)
 	}
 
 	cIdx, err := nd.NextChannelIdx()
 	if err != nil {
 		nd.InProg.mtx.Unlock()
-		return 0, err
+		return err
 	}
 
 	logging.Infof("next channel idx: %d", cIdx)
@@ -168,6 +195,9 @@ func (nd *LitNode) FundChannel(
 	nd.InProg.Amt = ccap
 	nd.InProg.InitSend = initSend
 	nd.InProg.Data = data
+	nd.InProg.FeePerByte = nd.SubWallet[cointype].EstimateFee(confTarget)
+	nd.InProg.Ins = ins
+	nd.InProg.External = external
 
 	nd.InProg.Coin = cointype
 	nd.InProg.mtx.Unlock() // switch to defer
@@ -176,10 +206,7 @@ func (nd *LitNode) FundChannel(
 
 	nd.tmpSendLitMsg(outMsg)
 
-	// wait until it's done!
-	idx := <-nd.InProg.done
-
-	return idx, nil
+	return nil
 }
 
 // RECIPIENT
@@ -279,6 +306,42 @@ func (nd *LitNode) PointRespHandler(msg lnutil.PointRespMsg) error {
 		return fmt.Errorf("Not connected to coin type %d\n", nd.InProg.Coin)
 	}
 
+	q, txo, err := nd.fundingQchan(msg)
+	if err != nil {
+		return err
+	}
+
+	if nd.InProg.External {
+		// the funding tx comes from outside; say what it has to pay, and
+		// wait for FinishFundChannelPsbt
+		nd.InProg.extResp = &msg
+		nd.InProg.extTxo <- txo
+		return nil
+	}
+
+	// call MaybeSend, freezing inputs and learning the txid of the channel
+	// here, we require only witness inputs
+	outPoints, err := nd.SubWallet[q.Coin()].MaybeSend(
+		[]*wire.TxOut{txo}, nd.InProg.Ins, nd.InProg.FeePerByte, true)
+	if err != nil {
+		return err
+	}
+
+	// should only have 1 txout index from MaybeSend, which we use
+	if len(outPoints) != 1 {
+		return fmt.Errorf("got %d OPs from MaybeSend (expect 1)", len(outPoints))
+	}
+
+	// save fund outpoint to inProg
+	nd.InProg.op = outPoints[0]
+
+	return nd.sendChanDesc(q, msg)
+}
+
+// fundingQchan makes the channel (not in db) for a point response, just for
+// keys / elk, and the output the funding tx pays.  InProg must be locked.
+func (nd *LitNode) fundingQchan(msg lnutil.PointRespMsg) (*Qchan, *wire.TxOut, error) {
+	var err error
 	// make channel (not in db) just for keys / elk
 	q := new(Qchan)
 
@@ -306,15 +369,15 @@ func (nd *LitNode) PointRespHandler(msg lnutil.PointRespMsg) error {
 	// make sure their pubkeys are real pubkeys
 	_, err = koblitz.ParsePubKey(q.TheirPub[:], koblitz.S256())
 	if err != nil {
-		return fmt.Errorf("PubRespHandler TheirPub err %s", err.Error())
+		return nil, nil, fmt.Errorf("PubRespHandler TheirPub err %s", err.Error())
 	}
 	_, err = koblitz.ParsePubKey(q.TheirRefundPub[:], koblitz.S256())
 	if err != nil {
-		return fmt.Errorf("PubRespHandler TheirRefundPub err %s", err.Error())
+		return nil, nil, fmt.Errorf("PubRespHandler TheirRefundPub err %s", err.Error())
 	}
 	_, err = koblitz.ParsePubKey(q.TheirHAKDBase[:], koblitz.S256())
 	if err != nil {
-		return fmt.Errorf("PubRespHandler TheirHAKDBase err %s", err.Error())
+		return nil, nil, fmt.Errorf("PubRespHandler TheirHAKDBase err %s", err.Error())
 	}
 
 	// derive elkrem sender root from HD keychain
@@ -327,33 +390,25 @@ func (nd *LitNode) PointRespHandler(msg lnutil.PointRespMsg) error {
 	// get txo for channel
 	txo, err := lnutil.FundTxOut(q.MyPub, q.TheirPub, nd.InProg.Amt)
 	if err != nil {
-		return err
-	}
-
-	// call MaybeSend, freezing inputs and learning the txid of the channel
-	// here, we require only witness inputs
-	outPoints, err := nd.SubWallet[q.Coin()].MaybeSend([]*wire.TxOut{txo}, true)
-	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
-	// should only have 1 txout index from MaybeSend, which we use
-	if len(outPoints) != 1 {
-		return fmt.Errorf("got %d OPs from MaybeSend (expect 1)", len(outPoints))
-	}
+	return q, txo, nil
+}
 
-	// save fund outpoint to inProg
-	nd.InProg.op = outPoints[0]
-	// also set outpoint in channel
+// sendChanDesc saves the channel being funded, now that InProg has the
+// funding outpoint, and describes it to the peer.  InProg must be locked.
+func (nd *LitNode) sendChanDesc(q *Qchan, msg lnutil.PointRespMsg) error {
+	var err error
+	// set outpoint in channel
 	q.Op = *nd.InProg.op
 
 	// create initial state for elkrem points
 	q.State = new(StatCom)
 	q.State.StateIdx = 0
 	q.State.MyAmt = nd.InProg.Amt - nd.InProg.InitSend
-	// get fee from sub wallet.  Later should make fee per channel and update state
-	// based on size
-	q.State.Fee = nd.SubWallet[q.Coin()].Fee() * consts.QcStateFee
+	q.State.Fee = nd.stateFee(q.Coin())
+	q.State.JusticeFee = nd.justiceFee(q.Coin())
 
 	q.State.Data = nd.InProg.Data
 
@@ -433,7 +488,7 @@ func (nd *LitNode) PointRespHandler(msg lnutil.PointRespMsg) error {
 // saves it to the local db, and returns a channel acknowledgement
 func (nd *LitNode) QChanDescHandler(msg lnutil.ChanDescMsg) error {
 
-	wal, ok := nd.SubWallet[msg.CoinType]
+	_, ok := nd.SubWallet[msg.CoinType]
 	if !ok {
 		return fmt.Errorf("QChanDescHandler err no wallet for type %d", msg.CoinType)
 	}
@@ -482,8 +537,8 @@ func (nd *LitNode) QChanDescHandler(msg lnutil.ChanDescMsg) error {
 	qc.State = new(StatCom)
 	// similar to SIGREV in pushpull
 
-	// TODO assumes both parties use same fee
-	qc.State.Fee = wal.Fee() * consts.QcStateFee
+	qc.State.Fee = nd.stateFee(qc.Coin())
+	qc.State.JusticeFee = nd.justiceFee(qc.Coin())
 	qc.State.MyAmt = msg.InitPayment
 
 	qc.State.Data = msg.Data
@@ -629,8 +684,15 @@ func (nd *LitNode) QChanAckHandler(msg lnutil.ChanAckMsg, peer *RemotePeer) {
 		return
 	}
 
-	// OK to fund.
-	err = nd.SubWallet[qc.Coin()].ReallySend(&qc.Op.Hash)
+	// OK to fund.  If the funding tx came signed from outside, just send it.
+	nd.InProg.mtx.Lock()
+	extTx := nd.InProg.extTx
+	nd.InProg.mtx.Unlock()
+	if extTx != nil && extTx.TxHash() == qc.Op.Hash {
+		err = nd.SubWallet[qc.Coin()].DirectSendTx(extTx)
+	} else {
+		err = nd.SubWallet[qc.Coin()].ReallySend(&qc.Op.Hash)
+	}
 	if err != nil {
 		nd.FailChannel(qc)
 		logging.Errorf("QChanAckHandler ReallySend err %s", err.Error())
@@ -0,0 +1,268 @@
+package qln
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/consts"
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/logging"
+)
+
+// GraphPruneRules say when links are dropped from the channel graph.
+type GraphPruneRules struct {
+	MaxAge      int64 // seconds since the last advert of a link
+	MinCapacity int64 // links with less capacity than this are dropped
+}
+
+// DefaultGraphPruneRules are the rules the graph is pruned by until set.
+func DefaultGraphPruneRules() GraphPruneRules {
+	return GraphPruneRules{MaxAge: consts.ChannelAdvTimeout}
+}
+
+// SetGraphPruneRules sets when links are dropped from the channel graph.
+// They're applied from the next time it's cleaned.
+func (nd *LitNode) SetGraphPruneRules(rules GraphPruneRules) error {
+	if rules.MaxAge <= 0 {
+		return fmt.Errorf("max link age %d, has to be more than 0", rules.MaxAge)
+	}
+	if rules.MinCapacity < 0 {
+		return fmt.Errorf("min link capacity %d is negative", rules.MinCapacity)
+	}
+	nd.ChannelMapMtx.Lock()
+	nd.graphPrune = rules
+	nd.ChannelMapMtx.Unlock()
+	return nil
+}
+
+// GetGraphPruneRules gives when links are dropped from the channel graph.
+func (nd *LitNode) GetGraphPruneRules() GraphPruneRules {
+	nd.ChannelMapMtx.Lock()
+	defer nd.ChannelMapMtx.Unlock()
+	return nd.graphPrune
+}
+
+// pruned says whether a link should be dropped from the graph at now.
+func (r GraphPruneRules) pruned(l lnutil.LinkMsg, now int64) bool {
+	return l.Timestamp+r.MaxAge < now || l.ACapacity < r.MinCapacity
+}
+
+// graphKey is what a link is saved under: A and the channel's funding
+// outpoint.  There's one link each way for a channel.
+func graphKey(l lnutil.LinkMsg) []byte {
+	op := lnutil.OutPointToBytes(l.FundingOp)
+	return append(l.APKH[:], op[:]...)
+}
+
+// linkKey identifies the link from a to b in coinType.
+func linkKey(a, b [20]byte, coinType uint32) [44]byte {
+	var key [44]byte
+	copy(key[:20], a[:])
+	copy(key[20:40], b[:])
+	binary.BigEndian.PutUint32(key[40:], coinType)
+	return key
+}
+
+// saveLink saves a link advert in the graph on disk, with when we got it.
+func (nd *LitNode) saveLink(l lnutil.LinkMsg) error {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, l.Timestamp)
+	buf.Write(l.Bytes())
+
+	return nd.LitDB.Update(func(btx *bolt.Tx) error {
+		bkt := btx.Bucket(BKTGraph)
+		if bkt == nil {
+			return fmt.Errorf("saveLink: no graph bucket")
+		}
+		return bkt.Put(graphKey(l), buf.Bytes())
+	})
+}
+
+// deleteLinks drops links from the graph on disk.
+func (nd *LitNode) deleteLinks(links []lnutil.LinkMsg) error {
+	if len(links) == 0 {
+		return nil
+	}
+	return nd.LitDB.Update(func(btx *bolt.Tx) error {
+		bkt := btx.Bucket(BKTGraph)
+		if bkt == nil {
+			return fmt.Errorf("deleteLinks: no graph bucket")
+		}
+		for _, l := range links {
+			err := bkt.Delete(graphKey(l))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// loadGraph puts the links saved on disk in the channel map, so we can
+// route before we've heard any adverts.  They were checked when we got
+// them, and get pruned along with the rest.  The caller holds
+// ChannelMapMtx.
+func (nd *LitNode) loadGraph() error {
+	// keys of links we can't read, copied out of the tx
+	var bad [][]byte
+
+	err := nd.LitDB.View(func(btx *bolt.Tx) error {
+		bkt := btx.Bucket(BKTGraph)
+		if bkt == nil {
+			return fmt.Errorf("loadGraph: no graph bucket")
+		}
+		return bkt.ForEach(func(k, v []byte) error {
+			if len(v) < 8 {
+				bad = append(bad, append([]byte(nil), k...))
+				return nil
+			}
+			l, err := lnutil.NewLinkMsgFromBytes(v[8:], 0)
+			if err != nil {
+				logging.Warnf("dropping saved link %x: %s", k, err.Error())
+				bad = append(bad, append([]byte(nil), k...))
+				return nil
+			}
+			l.Timestamp = int64(binary.BigEndian.Uint64(v[:8]))
+			nd.ChannelMap[l.APKH] = append(nd.ChannelMap[l.APKH], LinkDesc{l, false})
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(bad) > 0 {
+		err = nd.LitDB.Update(func(btx *bolt.Tx) error {
+			bkt := btx.Bucket(BKTGraph)
+			for _, k := range bad {
+				err := bkt.Delete(k)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	logging.Infof("loaded links of %d nodes from the channel graph",
+		len(nd.ChannelMap))
+	return nil
+}
+
+// GraphNode is a node in the channel graph.
+type GraphNode struct {
+	PKH        [20]byte // ln address
+	Pub        [33]byte // identity pubkey, if we've had an advert from it
+	Links      int      // links it's advertised
+	LastUpdate int64    // when we last got an advert from it
+}
+
+// GraphNodes gives the nodes in the channel graph, both those which
+// advertise links and those which are only linked to.
+func (nd *LitNode) GraphNodes() []GraphNode {
+	nodes := make(map[[20]byte]*GraphNode)
+	get := func(pkh [20]byte) *GraphNode {
+		n, ok := nodes[pkh]
+		if !ok {
+			n = &GraphNode{PKH: pkh}
+			nodes[pkh] = n
+		}
+		return n
+	}
+
+	nd.ChannelMapMtx.Lock()
+	for pkh, links := range nd.ChannelMap {
+		n := get(pkh)
+		for _, l := range links {
+			n.Links++
+			if l.Link.APub != n.Pub && l.Link.Timestamp >= n.LastUpdate {
+				n.Pub = l.Link.APub
+			}
+			if l.Link.Timestamp > n.LastUpdate {
+				n.LastUpdate = l.Link.Timestamp
+			}
+			get(l.Link.BPKH)
+		}
+	}
+	nd.ChannelMapMtx.Unlock()
+
+	var list []GraphNode
+	for _, n := range nodes {
+		list = append(list, *n)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return bytes.Compare(list[i].PKH[:], list[j].PKH[:]) < 0
+	})
+	return list
+}
+
+// GraphEdges gives the links in the channel graph, all of them or just those
+// to or from the node with ln address pkh.
+func (nd *LitNode) GraphEdges(pkh *[20]byte) []lnutil.LinkMsg {
+	var edges []lnutil.LinkMsg
+	nd.ChannelMapMtx.Lock()
+	for _, links := range nd.ChannelMap {
+		for _, l := range links {
+			if pkh != nil && l.Link.APKH != *pkh && l.Link.BPKH != *pkh {
+				continue
+			}
+			edges = append(edges, l.Link)
+		}
+	}
+	nd.ChannelMapMtx.Unlock()
+
+	sort.Slice(edges, func(i, j int) bool {
+		return bytes.Compare(graphKey(edges[i]), graphKey(edges[j])) < 0
+	})
+	return edges
+}
+
+// RouteCandidate is a route we could pay over, with what each node on it
+// sends on: Amts[k] and CLTVs[k] are the amount and locktime (in blocks
+// from now) of the HTLC the k'th node offers the next.
+type RouteCandidate struct {
+	Path    []lnutil.RouteHop
+	Amts    []int64
+	CLTVs   []uint32
+	Deliver int64 // what gets to the payee
+}
+
+// RouteCandidates gives up to count routes which get amt to the node with ln
+// address target, best first.  Each goes through different nodes from the
+// ones before it.
+func (nd *LitNode) RouteCandidates(target [20]byte, destCoinType,
+	originCoinType uint32, amt int64, count int) ([]RouteCandidate, error) {
+
+	var routes []RouteCandidate
+	exclude := make(map[[20]byte]bool)
+	for len(routes) < count {
+		path, send, err := nd.findPathWithFees(target, destCoinType,
+			originCoinType, amt, exclude)
+		if err != nil {
+			if len(routes) == 0 {
+				return nil, err
+			}
+			break
+		}
+		amts, cltvs, err := nd.routeTerms(path, send)
+		if err != nil {
+			return nil, err
+		}
+		routes = append(routes, RouteCandidate{path, amts, cltvs, amts[len(amts)-1]})
+
+		// direct to the target, there's nothing to go round
+		if len(path) <= 2 {
+			break
+		}
+		for _, hop := range path[1 : len(path)-1] {
+			exclude[hop.Node] = true
+		}
+	}
+	return routes, nil
+}
@@ -0,0 +1,112 @@
+package qln
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mit-dci/lit/btcutil/chaincfg/chainhash"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+// newTestNode makes a node with just a db, in a temp dir which cleanup
+// removes.
+func newTestNode(t *testing.T) (*LitNode, func()) {
+	dir, err := ioutil.TempDir("", "qln")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nd := new(LitNode)
+	err = nd.OpenDB(filepath.Join(dir, "ln.db"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	nd.ChannelMap = make(map[[20]byte][]LinkDesc)
+	nd.graphPrune = DefaultGraphPruneRules()
+	return nd, func() {
+		nd.LitDB.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func testLink(a, b byte, capacity, timestamp int64) lnutil.LinkMsg {
+	var l lnutil.LinkMsg
+	l.APKH[0] = a
+	l.BPKH[0] = b
+	l.CoinType = 257
+	l.ACapacity = capacity
+	l.Timestamp = timestamp
+	l.FundingOp.Hash = chainhash.DoubleHashH([]byte{a, b})
+	return l
+}
+
+func TestGraphSaveLoadPrune(t *testing.T) {
+	nd, cleanup := newTestNode(t)
+	defer cleanup()
+
+	now := time.Now().Unix()
+	fresh := testLink(1, 2, 100000, now)
+	back := testLink(2, 1, 5000, now)
+	old := testLink(1, 3, 100000, now-DefaultGraphPruneRules().MaxAge-1)
+	for _, l := range []lnutil.LinkMsg{fresh, back, old} {
+		err := nd.saveLink(l)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a restarted node has all the links, with when they were advertised
+	err := nd.loadGraph()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nd.ChannelMap[fresh.APKH]) != 2 || len(nd.ChannelMap[back.APKH]) != 1 {
+		t.Fatalf("loaded %d and %d links, expect 2 and 1",
+			len(nd.ChannelMap[fresh.APKH]), len(nd.ChannelMap[back.APKH]))
+	}
+	for _, l := range nd.ChannelMap[fresh.APKH] {
+		if l.Link.BPKH == old.BPKH && l.Link.Timestamp != old.Timestamp {
+			t.Fatalf("loaded timestamp %d, expect %d", l.Link.Timestamp, old.Timestamp)
+		}
+	}
+
+	edges := nd.GraphEdges(&old.BPKH)
+	if len(edges) != 1 || edges[0].BPKH != old.BPKH {
+		t.Fatalf("got %d edges to node 3, expect 1", len(edges))
+	}
+	if len(nd.GraphEdges(nil)) != 3 {
+		t.Fatalf("got %d edges, expect 3", len(nd.GraphEdges(nil)))
+	}
+	if len(nd.GraphNodes()) != 3 {
+		t.Fatalf("got %d nodes, expect 3", len(nd.GraphNodes()))
+	}
+
+	// the old one's pruned, on disk too
+	nd.cleanStaleChannels()
+	if len(nd.GraphEdges(nil)) != 2 {
+		t.Fatalf("got %d edges after pruning, expect 2", len(nd.GraphEdges(nil)))
+	}
+
+	// and with a min capacity, so is the small one
+	if nd.SetGraphPruneRules(GraphPruneRules{MaxAge: 0}) == nil {
+		t.Fatalf("prune rules with no max age allowed")
+	}
+	err = nd.SetGraphPruneRules(GraphPruneRules{MaxAge: 3600, MinCapacity: 10000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nd.cleanStaleChannels()
+
+	nd.ChannelMap = make(map[[20]byte][]LinkDesc)
+	err = nd.loadGraph()
+	if err != nil {
+		t.Fatal(err)
+	}
+	edges = nd.GraphEdges(nil)
+	if len(edges) != 1 || edges[0].APKH != fresh.APKH || edges[0].BPKH != fresh.BPKH {
+		t.Fatalf("got %d edges after reloading, expect just 1 -> 2", len(edges))
+	}
+}
@@ -68,6 +68,14 @@ func (nd *LitNode) OfferHTLC(qc *Qchan, amt uint32, RHash [32]byte, locktime uin
 			"height %d; must wait min 1 conf for non-test coin\n", qc.Height)
 	}
 
+	// the peer has to have time to claim it on chain if it has to
+	if int32(locktime) < wal.CurrentHeight()+consts.HTLCClaimMargin {
+		qc.ClearToSend <- true
+		qc.ChanMtx.Unlock()
+		return fmt.Errorf("locktime %d is less than %d blocks past height %d",
+			locktime, consts.HTLCClaimMargin, wal.CurrentHeight())
+	}
+
 	myAmt, _ := qc.GetChannelBalances()
 	myAmt -= qc.State.Fee + int64(amt)
 
@@ -816,18 +824,20 @@ func (nd *LitNode) ClaimHTLC(R [16]byte) ([][32]byte, error) {
 				}
 			}
 
+			// there can be more than one HTLC to claim, for the parts of a
+			// multi-path payment, so don't hold the lock past this one
 			nd.MultihopMutex.Lock()
-			defer nd.MultihopMutex.Unlock()
 			for idx, mu := range nd.InProgMultihop {
 				if bytes.Equal(mu.HHash[:], RHash[:]) && !mu.Succeeded {
-					nd.InProgMultihop[idx].Succeeded = true
-					nd.InProgMultihop[idx].PreImage = R
+					nd.InProgMultihop[idx].succeed(R)
 					err = nd.SaveMultihopPayment(nd.InProgMultihop[idx])
 					if err != nil {
+						nd.MultihopMutex.Unlock()
 						return txids, err
 					}
 				}
 			}
+			nd.MultihopMutex.Unlock()
 		}
 	}
 	return txids, nil
@@ -879,6 +889,117 @@ func (nd *LitNode) ClaimHTLCTimeouts(coinType uint32, height int32) ([][32]byte,
 	return txids, nil
 }
 
+// GoToChainForHTLCs breaks channels whose peers aren't clearing HTLCs in
+// time, and claims the incoming HTLCs of broken channels we know the
+// preimages of.  An incoming HTLC has to be claimed before it expires, or
+// the peer can take it back, so if we know its preimage and it's within
+// HTLCClaimMargin blocks of expiring we go to chain.  We give the peer
+// HTLCTimeoutGrace blocks past the expiry of an outgoing HTLC to time it
+// out with us before doing the same; ClaimHTLCTimeouts takes it back once
+// the channel's broken.  Gives the TXIDs of the claim transactions.
+func (nd *LitNode) GoToChainForHTLCs(coinType uint32, height int32) ([][32]byte, error) {
+	txids := make([][32]byte, 0)
+	qcs, err := nd.GetAllQchans()
+	if err != nil {
+		return nil, err
+	}
+	for _, q := range qcs {
+		if q.Coin() != coinType {
+			continue
+		}
+		err := nd.ReloadQchanState(q)
+		if err != nil {
+			return nil, err
+		}
+
+		var breakIt bool
+		for _, h := range q.State.HTLCs {
+			if h.Cleared || h.ClearedOnChain {
+				continue
+			}
+			if !h.Incoming {
+				if !q.CloseData.Closed && htlcBreaksChannel(h, false, height) {
+					logging.Warnf("HTLC %d in channel %d timed out at %d and the peer hasn't cleared it",
+						h.Idx, q.Idx(), h.Locktime)
+					breakIt = true
+				}
+				continue
+			}
+
+			R, ok := nd.knownPreimage(h.RHash)
+			if !ok {
+				continue
+			}
+			if !q.CloseData.Closed {
+				if htlcBreaksChannel(h, true, height) {
+					logging.Warnf("HTLC %d in channel %d expires at %d and the peer hasn't let us claim it",
+						h.Idx, q.Idx(), h.Locktime)
+					breakIt = true
+				}
+				continue
+			}
+			// wait for the close to confirm, so we know which state it was
+			if q.CloseData.CloseHeight == 0 {
+				continue
+			}
+			h.R = R
+			tx, err := nd.ClaimHTLCOnChain(q, h)
+			if err != nil {
+				logging.Errorf("Error claiming HTLC: %s", err.Error())
+				continue
+			}
+			nd.SetHTLCClearedOnChain(q, h)
+			txids = append(txids, tx.TxHash())
+		}
+
+		if breakIt {
+			logging.Infof("breaking channel %d to settle its HTLCs on chain", q.Idx())
+			err = nd.BreakChannel(q)
+			if err != nil {
+				logging.Errorf("Error breaking channel %d: %s", q.Idx(), err.Error())
+				continue
+			}
+			// so we don't break it again while the break tx confirms
+			err = nd.SaveQchanUtxoData(q)
+			if err != nil {
+				logging.Errorf("Error saving channel %d: %s", q.Idx(), err.Error())
+			}
+		}
+	}
+	return txids, nil
+}
+
+// htlcBreaksChannel says whether HTLC h in a channel that's still open means
+// we go to chain at height: it's outgoing and HTLCTimeoutGrace blocks past
+// its expiry, or it's incoming, we know its preimage (haveR) and it's within
+// HTLCClaimMargin blocks of expiring.
+func htlcBreaksChannel(h HTLC, haveR bool, height int32) bool {
+	if h.Cleared || h.ClearedOnChain {
+		return false
+	}
+	if !h.Incoming {
+		return height >= int32(h.Locktime)+consts.HTLCTimeoutGrace
+	}
+	return haveR && int32(h.Locktime)-height <= consts.HTLCClaimMargin
+}
+
+// knownPreimage gives the preimage of hash, if it's for a multihop payment
+// to us we've taken, or one through us the next hop has claimed.  We have
+// the preimages of payments to us we've turned down too, but mustn't claim
+// them.
+func (nd *LitNode) knownPreimage(hash [32]byte) ([16]byte, bool) {
+	var nullBytes [16]byte
+	nd.MultihopMutex.Lock()
+	defer nd.MultihopMutex.Unlock()
+	for _, mh := range nd.InProgMultihop {
+		if mh.HHash == hash && mh.State == PaymentSucceeded && mh.PreImage != nullBytes &&
+			fastsha256.Sum256(mh.PreImage[:]) == hash {
+			return mh.PreImage, true
+		}
+	}
+	return nullBytes, false
+}
+
 func (nd *LitNode) FindHTLCsByTimeoutHeight(coinType uint32, height int32) ([]HTLC, []*Qchan, error) {
 	htlcs := make([]HTLC, 0)
 	channels := make([]*Qchan, 0)
@@ -893,7 +1014,7 @@ func (nd *LitNode) FindHTLCsByTimeoutHeight(coinType uint32, height int32) ([]HT
 		}
 		if q.Coin() == coinType {
 			for _, h := range q.State.HTLCs {
-				if !h.Incoming && !h.Cleared {
+				if !h.Incoming && !h.Cleared && !h.ClearedOnChain {
 					if height >= int32(h.Locktime) {
 						htlcs = append(htlcs, h)
 						channels = append(channels, q)
@@ -0,0 +1,41 @@
+package qln
+
+import (
+	"testing"
+
+	"github.com/mit-dci/lit/consts"
+)
+
+func TestHTLCBreaksChannel(t *testing.T) {
+	const locktime = 1000
+	out := HTLC{Locktime: locktime}
+	in := HTLC{Incoming: true, Locktime: locktime}
+
+	// the peer gets HTLCTimeoutGrace blocks to time out an outgoing one
+	if htlcBreaksChannel(out, false, locktime+consts.HTLCTimeoutGrace-1) {
+		t.Fatalf("broke for outgoing HTLC inside the grace")
+	}
+	if !htlcBreaksChannel(out, false, locktime+consts.HTLCTimeoutGrace) {
+		t.Fatalf("didn't break for outgoing HTLC past the grace")
+	}
+
+	// we go for an incoming one HTLCClaimMargin blocks before it expires,
+	// if we can claim it
+	if htlcBreaksChannel(in, true, locktime-consts.HTLCClaimMargin-1) {
+		t.Fatalf("broke for incoming HTLC before the margin")
+	}
+	if !htlcBreaksChannel(in, true, locktime-consts.HTLCClaimMargin) {
+		t.Fatalf("didn't break for incoming HTLC inside the margin")
+	}
+	if htlcBreaksChannel(in, false, locktime) {
+		t.Fatalf("broke for incoming HTLC we can't claim")
+	}
+
+	// cleared ones are done with
+	out.Cleared = true
+	in.ClearedOnChain = true
+	if htlcBreaksChannel(out, false, locktime+consts.HTLCTimeoutGrace) ||
+		htlcBreaksChannel(in, true, locktime) {
+		t.Fatalf("broke for cleared HTLC")
+	}
+}
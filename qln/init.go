@@ -18,6 +18,7 @@ import (
 	"github.com/mit-dci/lit/portxo"
 	"github.com/mit-dci/lit/wallit"
 	"github.com/mit-dci/lit/watchtower"
+	"github.com/mit-dci/lit/wire"
 )
 
 // NewLitNode starts up a lit node.  Needs priv key, and a path.
@@ -55,6 +56,12 @@ func NewLitNode(privKey *[32]byte, path string, trackerURL string, proxyURL stri
 		return nil, err
 	}
 
+	// Channels used to live in ln.db, move any old ones over.
+	err = nd.migrateLegacyChannels()
+	if err != nil {
+		return nil, err
+	}
+
 	// Event system setup.
 	ebus := eventbus.NewEventBus()
 	nd.Events = &ebus
@@ -111,12 +118,14 @@ func NewLitNode(privKey *[32]byte, path string, trackerURL string, proxyURL stri
 	if err != nil {
 		return nil, err
 	}
+	go nd.watchContracts()
 
 	// make maps and channels
 	nd.UserMessageBox = make(chan string, 32)
 
 	nd.InProg = new(InFlightFund)
 	nd.InProg.done = make(chan uint32, 1)
+	nd.InProg.extTxo = make(chan *wire.TxOut, 1)
 
 	nd.InProgDual = new(InFlightDualFund)
 	nd.InProgDual.done = make(chan *DualFundingResult, 1)
@@ -125,6 +134,22 @@ func NewLitNode(privKey *[32]byte, path string, trackerURL string, proxyURL stri
 	if err != nil {
 		return nil, err
 	}
+	nd.heldParts = make(map[[32]byte]*heldPayment)
+	nd.staleParts, err = nd.loadHeldParts()
+	if err != nil {
+		return nil, err
+	}
+	nd.watchPayments()
+
+	err = nd.loadPolicies()
+	if err != nil {
+		return nil, err
+	}
+
+	err = nd.loadMissionControl()
+	if err != nil {
+		return nil, err
+	}
 
 	nd.RemoteMtx.Lock()
 	nd.RemoteCons = make(map[uint32]*RemotePeer)
@@ -171,7 +196,8 @@ func (nd *LitNode) LinkBaseWallet(
 	// be the first & default
 	var cointype int
 	nd.SubWallet[WallitIdx], cointype, err = wallit.NewWallit(
-		rootpriv, birthHeight, resync, host, nd.LitFolder, proxy, param)
+		rootpriv, birthHeight, resync, host, nd.LitFolder, proxy, param,
+		nd.NewLitDB.GetWalletDB(param.HDCoinType))
 
 	if err != nil {
 		logging.Error(err)
@@ -270,6 +296,36 @@ func (nd *LitNode) OpenDB(filename string) error {
 			return err
 		}
 
+		_, err = btx.CreateBucketIfNotExists(BKTInvoices)
+		if err != nil {
+			return err
+		}
+
+		_, err = btx.CreateBucketIfNotExists(BKTPolicies)
+		if err != nil {
+			return err
+		}
+
+		_, err = btx.CreateBucketIfNotExists(BKTGraph)
+		if err != nil {
+			return err
+		}
+
+		_, err = btx.CreateBucketIfNotExists(BKTMission)
+		if err != nil {
+			return err
+		}
+
+		_, err = btx.CreateBucketIfNotExists(BKTRates)
+		if err != nil {
+			return err
+		}
+
+		_, err = btx.CreateBucketIfNotExists(BKTHeld)
+		if err != nil {
+			return err
+		}
+
 		return nil
 	})
 	if err != nil {
@@ -0,0 +1,407 @@
+package qln
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/consts"
+	"github.com/mit-dci/lit/crypto/fastsha256"
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/logging"
+)
+
+// IssuedInvoice is an invoice we made, with the preimage of its hash.
+type IssuedInvoice struct {
+	Invoice  *lnutil.Invoice
+	PreImage [16]byte
+}
+
+// Bytes serializes an issued invoice for the db: preimage, then the
+// encoded invoice.
+func (ii *IssuedInvoice) Bytes() ([]byte, error) {
+	s, err := ii.Invoice.Encode()
+	if err != nil {
+		return nil, err
+	}
+	return append(ii.PreImage[:], []byte(s)...), nil
+}
+
+func IssuedInvoiceFromBytes(b []byte) (*IssuedInvoice, error) {
+	if len(b) < 16 {
+		return nil, fmt.Errorf("issued invoice too short")
+	}
+	ii := new(IssuedInvoice)
+	copy(ii.PreImage[:], b[:16])
+	var err error
+	ii.Invoice, err = lnutil.DecodeInvoice(string(b[16:]))
+	if err != nil {
+		return nil, err
+	}
+	return ii, nil
+}
+
+// myPKH gives the ln address (as a pkh) of this node.
+func (nd *LitNode) myPKH() [20]byte {
+	var idPub [33]byte
+	copy(idPub[:], nd.IdKey().PubKey().SerializeCompressed())
+	return lnutil.LitAdrPKH(idPub)
+}
+
+// CreateInvoice makes and saves a signed invoice to be paid amt in
+// coinType.  It's good for expiry seconds.  Route hints are put in for our
+// channels with the most the peer can send us.
+func (nd *LitNode) CreateInvoice(coinType uint32, amt int64, expiry uint32,
+	desc string) (*lnutil.Invoice, error) {
+
+	if _, ok := nd.SubWallet[coinType]; !ok {
+		return nil, fmt.Errorf("not connected to cointype %d", coinType)
+	}
+	if amt != 0 && amt < consts.MinSendAmt {
+		return nil, fmt.Errorf("amount %d less than minimum send amount %d",
+			amt, consts.MinSendAmt)
+	}
+	if expiry == 0 {
+		expiry = consts.DefaultInvoiceExpiry
+	}
+
+	inFlight := new(InFlightMultihop)
+	_, err := rand.Read(inFlight.PreImage[:])
+	if err != nil {
+		return nil, err
+	}
+	inFlight.HHash = fastsha256.Sum256(inFlight.PreImage[:])
+	inFlight.Amt = amt
+	inFlight.Path = []lnutil.RouteHop{{Node: nd.myPKH(), CoinType: coinType}}
+
+	inv := new(lnutil.Invoice)
+	inv.Payee = nd.myPKH()
+	inv.CoinType = coinType
+	inv.Amount = amt
+	inv.PaymentHash = inFlight.HHash
+	inv.Timestamp = time.Now().Unix()
+	inv.Expiry = expiry
+	inv.Description = desc
+	inv.RouteHints = nd.invoiceRouteHints(coinType, amt)
+
+	err = inv.Sign(nd.IdKey())
+	if err != nil {
+		return nil, err
+	}
+
+	err = nd.SaveInvoice(&IssuedInvoice{inv, inFlight.PreImage})
+	if err != nil {
+		return nil, err
+	}
+
+	// keep the preimage where incoming multihops look for it
+	nd.MultihopMutex.Lock()
+	defer nd.MultihopMutex.Unlock()
+	err = nd.SaveMultihopPayment(inFlight)
+	if err != nil {
+		return nil, err
+	}
+	nd.InProgMultihop = append(nd.InProgMultihop, inFlight)
+
+	return inv, nil
+}
+
+// invoiceRouteHints gives hints for the channels in coinType our peers can
+// pay us amt over, most capacity first.  The fees and CLTV delta are what the
+// peer advertises for its link to us, or the defaults if we haven't heard.
+func (nd *LitNode) invoiceRouteHints(coinType uint32, amt int64) []lnutil.RouteHint {
+	var hints []lnutil.RouteHint
+
+	nd.RemoteMtx.Lock()
+	for _, peer := range nd.RemoteCons {
+		if peer.Con == nil {
+			continue
+		}
+		var pub [33]byte
+		copy(pub[:], peer.Con.RemotePub().SerializeCompressed())
+		for _, q := range peer.QCs {
+			if q.Coin() != coinType || q.CloseData.Closed || q.State.Failed {
+				continue
+			}
+			capacity := q.Value - q.State.MyAmt - consts.MinOutput - q.State.Fee
+			if capacity <= 0 || capacity < amt {
+				continue
+			}
+			var h lnutil.RouteHint
+			h.From = lnutil.LitAdrPKH(pub)
+			h.FromPub = pub
+			h.CoinType = coinType
+			h.Capacity = capacity
+			hints = append(hints, h)
+		}
+	}
+	nd.RemoteMtx.Unlock()
+
+	me := nd.myPKH()
+	for i, h := range hints {
+		policy, err := nd.linkPolicy(h.From, me, coinType)
+		if err != nil {
+			policy = defaultChanPolicy()
+			policy.FeeBase, policy.FeeRate = 0, 0
+		}
+		hints[i].FeeBase = policy.FeeBase
+		hints[i].FeeRate = policy.FeeRate
+		hints[i].CLTVDelta = policy.CLTVDelta
+	}
+
+	sort.Slice(hints, func(i, j int) bool {
+		return hints[i].Capacity > hints[j].Capacity
+	})
+	if len(hints) > lnutil.MaxInvoiceRouteHints {
+		hints = hints[:lnutil.MaxInvoiceRouteHints]
+	}
+	return hints
+}
+
+// PayInvoice pays an invoice over a multihop route, from originCoinType.
+// amt is only used if the invoice lets the payer pick the amount.  The payee
+// doesn't have to be reachable.  We send enough more than amt to cover the
+// fees of the hops on the way.
+func (nd *LitNode) PayInvoice(inv *lnutil.Invoice, originCoinType uint32,
+	amt int64) error {
+
+	payeeKey, err := inv.PayeeKey()
+	if err != nil {
+		return err
+	}
+	if inv.Expired() {
+		return fmt.Errorf("invoice expired at %s", inv.ExpiresAt().String())
+	}
+	if inv.Payee == nd.myPKH() {
+		return fmt.Errorf("can't pay our own invoice")
+	}
+	if inv.Amount != 0 {
+		if amt != 0 && amt != inv.Amount {
+			return fmt.Errorf("invoice is for %d, not %d", inv.Amount, amt)
+		}
+		amt = inv.Amount
+	}
+	if amt == 0 {
+		return fmt.Errorf("invoice has no amount, give one")
+	}
+
+	wal, ok := nd.SubWallet[originCoinType]
+	if !ok {
+		return fmt.Errorf("not connected to cointype %d", originCoinType)
+	}
+	fee := wal.Fee() * 1000
+	if amt < consts.MinOutput+fee {
+		return fmt.Errorf("cannot send %d because it's less than minOutput + fee: %d", amt, consts.MinOutput+fee)
+	}
+
+	// path finding can take a while, so it's done without holding up
+	// everything else multihop; the hash is checked again after
+	nd.MultihopMutex.Lock()
+	err = nd.checkNewPaymentHash(inv.PaymentHash)
+	nd.MultihopMutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	nd.addRouteHints(inv)
+
+	logging.Infof("Finding route to %s", inv.PayeeAdr())
+	routes, err := nd.findRoutes(inv.Payee, inv.CoinType, originCoinType, amt, true)
+	if err != nil {
+		return err
+	}
+
+	nd.MultihopMutex.Lock()
+	defer nd.MultihopMutex.Unlock()
+	err = nd.checkNewPaymentHash(inv.PaymentHash)
+	if err != nil {
+		return err
+	}
+
+	used := make(map[*Qchan]bool)
+	for _, inFlight := range routesInFlight(routes) {
+		copy(inFlight.PayeeKey[:], payeeKey.SerializeCompressed())
+		err = nd.offerMultihop(inFlight, inv.PaymentHash, payeeKey, used)
+		if err != nil {
+			return err
+		}
+		nd.InProgMultihop = append(nd.InProgMultihop, inFlight)
+	}
+	return nil
+}
+
+// checkNewPaymentHash errors if we already have a payment with hash.  The
+// caller holds MultihopMutex.
+func (nd *LitNode) checkNewPaymentHash(hash [32]byte) error {
+	for _, mh := range nd.InProgMultihop {
+		if mh.HHash == hash {
+			return fmt.Errorf("already have a payment with hash %x", hash)
+		}
+	}
+	return nil
+}
+
+// findPathWithFees finds a route which gets amt to the payee after the fees
+// of the hops on the way, avoiding the nodes in exclude.  It gives the route
+// and how much to send on it.  Adding the fees can change which route is
+// best, so it takes a few goes.
+func (nd *LitNode) findPathWithFees(payee [20]byte, destCoinType,
+	originCoinType uint32, amt int64,
+	exclude map[[20]byte]bool) ([]lnutil.RouteHop, int64, error) {
+
+	send := amt
+	for i := 0; i < 5; i++ {
+		path, err := nd.findPath(payee, destCoinType, originCoinType, send, exclude)
+		if err != nil {
+			return nil, 0, err
+		}
+		amts, _, err := nd.routeTerms(path, send)
+		if err != nil {
+			return nil, 0, err
+		}
+		got := amts[len(amts)-1]
+		if got >= amt {
+			return path, send, nil
+		}
+		if got <= 0 {
+			return nil, 0, fmt.Errorf("sending %d gets nothing to the payee", send)
+		}
+		send += (amt-got)*send/got + 1
+	}
+	return nil, 0, fmt.Errorf("can't find a route which gets %d to the payee after fees", amt)
+}
+
+// addRouteHints puts the route hints of an invoice in the channel map, for
+// links we haven't heard about.  They're sequence 0, so adverts replace
+// them, and they get cleaned out as stale like any other link.
+func (nd *LitNode) addRouteHints(inv *lnutil.Invoice) {
+	nd.ChannelMapMtx.Lock()
+	defer nd.ChannelMapMtx.Unlock()
+
+	for _, h := range inv.RouteHints {
+		if h.CoinType != inv.CoinType {
+			continue
+		}
+		known := false
+		for _, l := range nd.ChannelMap[h.From] {
+			if l.Link.BPKH == inv.Payee && l.Link.CoinType == h.CoinType {
+				known = true
+				break
+			}
+		}
+		if known {
+			continue
+		}
+
+		var link lnutil.LinkMsg
+		link.APKH = h.From
+		link.BPKH = inv.Payee
+		link.CoinType = h.CoinType
+		link.ACapacity = h.Capacity
+		link.Policy = lnutil.ChanPolicy{
+			FeeBase:   h.FeeBase,
+			FeeRate:   h.FeeRate,
+			CLTVDelta: h.CLTVDelta,
+		}
+		if checkChanPolicy(link.Policy) != nil {
+			continue
+		}
+		link.Timestamp = time.Now().Unix()
+		nd.ChannelMap[h.From] = append(nd.ChannelMap[h.From], LinkDesc{link, false})
+	}
+}
+
+// checkInvoicePayment checks an incoming payment against the invoice for
+// its hash, if there is one.
+func (nd *LitNode) checkInvoicePayment(hash [32]byte, amt int64) error {
+	ii, err := nd.GetInvoice(hash)
+	if err != nil || ii == nil {
+		return err
+	}
+	if ii.Invoice.Expired() {
+		return fmt.Errorf("payment for invoice %x which expired at %s",
+			hash, ii.Invoice.ExpiresAt().String())
+	}
+	if amt < ii.Invoice.Amount {
+		return fmt.Errorf("payment of %d for invoice %x of %d",
+			amt, hash, ii.Invoice.Amount)
+	}
+	return nil
+}
+
+// InvoicePaid says whether we've been paid for the invoice with the hash.
+func (nd *LitNode) InvoicePaid(hash [32]byte) bool {
+	nd.MultihopMutex.Lock()
+	defer nd.MultihopMutex.Unlock()
+	for _, mh := range nd.InProgMultihop {
+		if mh.HHash == hash && mh.Succeeded {
+			return true
+		}
+	}
+	return false
+}
+
+// SaveInvoice saves an invoice we made, keyed by its payment hash.
+func (nd *LitNode) SaveInvoice(ii *IssuedInvoice) error {
+	b, err := ii.Bytes()
+	if err != nil {
+		return err
+	}
+	return nd.LitDB.Update(func(btx *bolt.Tx) error {
+		bkt := btx.Bucket(BKTInvoices)
+		if bkt == nil {
+			return fmt.Errorf("SaveInvoice: no invoices bucket")
+		}
+		return bkt.Put(ii.Invoice.PaymentHash[:], b)
+	})
+}
+
+// GetInvoice gives the invoice we made with the payment hash, or nil if we
+// didn't make one.
+func (nd *LitNode) GetInvoice(hash [32]byte) (*IssuedInvoice, error) {
+	var ii *IssuedInvoice
+	err := nd.LitDB.View(func(btx *bolt.Tx) error {
+		bkt := btx.Bucket(BKTInvoices)
+		if bkt == nil {
+			return fmt.Errorf("GetInvoice: no invoices bucket")
+		}
+		b := bkt.Get(hash[:])
+		if b == nil {
+			return nil
+		}
+		var err error
+		ii, err = IssuedInvoiceFromBytes(b)
+		return err
+	})
+	return ii, err
+}
+
+// GetAllInvoices gives all the invoices we've made, oldest first.
+func (nd *LitNode) GetAllInvoices() ([]*IssuedInvoice, error) {
+	var invoices []*IssuedInvoice
+	err := nd.LitDB.View(func(btx *bolt.Tx) error {
+		bkt := btx.Bucket(BKTInvoices)
+		if bkt == nil {
+			return fmt.Errorf("GetAllInvoices: no invoices bucket")
+		}
+		return bkt.ForEach(func(k, v []byte) error {
+			ii, err := IssuedInvoiceFromBytes(v)
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(k, ii.Invoice.PaymentHash[:]) {
+				return fmt.Errorf("invoice %x stored under %x",
+					ii.Invoice.PaymentHash, k)
+			}
+			invoices = append(invoices, ii)
+			return nil
+		})
+	})
+	sort.Slice(invoices, func(i, j int) bool {
+		return invoices[i].Invoice.Timestamp < invoices[j].Invoice.Timestamp
+	})
+	return invoices, err
+}
@@ -73,6 +73,23 @@ func JusticeTxFromBytes(jte []byte) (JusticeTx, error) {
 	return r, nil
 }
 
+// justiceFee is the absolute fee justice txs on a new channel will pay.  It
+// has to stay the same for the life of the channel, as the watchtower gets
+// it once and all the justice sigs commit to it.
+func (nd *LitNode) justiceFee(coin uint32) int64 {
+	return nd.SubWallet[coin].EstimateFee(consts.JusticeConfTarget) *
+		consts.JusticeTxBump
+}
+
+// JusticeFee is the fee the channel's justice txs pay.
+func (q *Qchan) JusticeFee() int64 {
+	if q.State.JusticeFee == 0 {
+		// channel from before fee estimation
+		return consts.JusticeFee
+	}
+	return q.State.JusticeFee
+}
+
 // BuildWatchTxidSig builds the partial txid and signature pair which can
 // be exported to the watchtower.
 // This get a channel that is 1 state old.  So we can produce a signature.
@@ -85,7 +102,7 @@ func (nd *LitNode) BuildJusticeSig(q *Qchan) error {
 	// in this function, "bad" refers to the hypothetical transaction spending the
 	// com tx.  "justice" is the tx spending the bad tx
 
-	fee := int64(consts.JusticeTxBump * nd.SubWallet[q.Coin()].Fee())
+	fee := q.JusticeFee()
 
 	// first we need the keys in the bad script.  Start by getting the elk-scalar
 	// we should have it at the "current" state number
@@ -323,7 +340,7 @@ func (nd *LitNode) SyncWatch(qc *Qchan, watchPeer uint32) error {
 	// send initial description if we haven't sent anything yet
 	if qc.State.WatchUpTo == 0 {
 		desc := lnutil.NewWatchDescMsg(watchPeer, qc.Coin(),
-			qc.WatchRefundAdr, qc.Delay, consts.JusticeFee, qc.TheirHAKDBase, qc.MyHAKDBase)
+			qc.WatchRefundAdr, qc.Delay, qc.JusticeFee(), qc.TheirHAKDBase, qc.MyHAKDBase)
 
 		nd.tmpSendLitMsg(desc)
 		// after sending description, must send at least states 0 and 1.
@@ -0,0 +1,58 @@
+package qln
+
+import (
+	"fmt"
+
+	"github.com/mit-dci/lit/crypto/fastsha256"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+// SetAcceptKeysend sets whether we take spontaneous payments: ones we
+// didn't give a hash for, where the payer picks the preimage and sends it
+// to us in the onion.
+func (nd *LitNode) SetAcceptKeysend(accept bool) {
+	nd.MultihopMutex.Lock()
+	nd.acceptKeysend = accept
+	nd.MultihopMutex.Unlock()
+}
+
+// AcceptsKeysend says whether we take spontaneous payments.
+func (nd *LitNode) AcceptsKeysend() bool {
+	nd.MultihopMutex.Lock()
+	defer nd.MultihopMutex.Unlock()
+	return nd.acceptKeysend
+}
+
+// takeKeysend makes a record of a spontaneous payment to us with the hash,
+// which came from the node with ln address from in coinType, with the
+// preimage the payer put in the onion.  If we've got a part of it already
+// there's one.  The caller holds MultihopMutex.
+func (nd *LitNode) takeKeysend(hash [32]byte, preimage [16]byte,
+	from [20]byte, coinType uint32) error {
+
+	if !nd.acceptKeysend {
+		return fmt.Errorf("not taking spontaneous payments")
+	}
+	if fastsha256.Sum256(preimage[:]) != hash {
+		return fmt.Errorf("preimage in the onion isn't for hash %x", hash)
+	}
+
+	for _, mh := range nd.InProgMultihop {
+		if mh.HHash == hash && len(mh.Path) == 1 {
+			return nil
+		}
+	}
+
+	inFlight := new(InFlightMultihop)
+	inFlight.Path = []lnutil.RouteHop{{Node: from, CoinType: coinType}}
+	inFlight.HHash = hash
+	inFlight.PreImage = preimage
+	inFlight.Keysend = true
+
+	err := nd.SaveMultihopPayment(inFlight)
+	if err != nil {
+		return err
+	}
+	nd.InProgMultihop = append(nd.InProgMultihop, inFlight)
+	return nil
+}
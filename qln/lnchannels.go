@@ -83,6 +83,8 @@ type StatCom struct {
 
 	Fee int64 `json:"fee"` // symmetric fee in absolute satoshis
 
+	JusticeFee int64 `json:"justicefee"` // fee for our justice txs, fixed at funding
+
 	Data [32]byte `json:"miscdata"`
 
 	// their Amt is the utxo.Value minus this
@@ -125,6 +127,8 @@ type StatCom struct {
 
 	Failed bool `json:"failed"` // S there was a fatal error with the channel
 	// meaning it cannot be used safely
+
+	Recovered bool `json:"recovered"` // S restored from a static backup, no state
 }
 
 // QCloseData is the output resulting from an un-cooperative close
@@ -41,9 +41,9 @@ PeerMap
 |
 |-peerIdx(4) : peerPubkey(33)
 
-ChannelMap
-|
-|-chanIdx(4) : channelID (36 byte outpoint)
+Channels themselves aren't in here anymore, they're in the LitChannelStorage
+(NewLitDB.GetChannelDB()), keyed by their funding outpoint.  The old
+"channels" and "cmp" buckets are only read to migrate old channels over.
 
 
 Right now these buckets are all in one boltDB.  This limits it to one db write
@@ -133,6 +133,14 @@ type LitNode struct {
 	ChannelMapMtx sync.Mutex
 	AdvTimeout    *time.Ticker
 
+	// funding outputs of other nodes' links we've found on chain
+	linkChecks    map[wire.OutPoint]linkCheck
+	linkChecksMtx sync.Mutex
+
+	// peers' sigs on the proofs of our channels with them, which our
+	// adverts of those channels carry.  Behind ChannelMapMtx.
+	linkProofs map[wire.OutPoint]lnutil.LinkProofMsg
+
 	RPC interface{}
 
 	// Contains the URL string to connect to a SOCKS5 proxy, if provided
@@ -142,7 +150,40 @@ type LitNode struct {
 	InProgMultihop []*InFlightMultihop
 	MultihopMutex  sync.Mutex
 
+	// parts of multi-path payments to us we're holding until the rest come,
+	// by payment hash.  Behind MultihopMutex.
+	heldParts map[[32]byte]*heldPayment
+	// parts we were holding when we last stopped, by payment hash.  The
+	// rest of those payments are gone, so they're failed back once their
+	// peers connect.  Behind MultihopMutex.
+	staleParts map[[32]byte][]heldPart
+	// whether we take spontaneous payments.  Behind MultihopMutex.
+	acceptKeysend bool
+
+	// the rates we advertise for exchanging between coins, by the coin we
+	// send on in, and where they come from.  Behind ratesMtx.
 	ExchangeRates map[uint32][]lnutil.RateDesc
+	providerRates map[string]map[uint32][]lnutil.RateDesc
+	rateOrder     []string
+	manualRates   map[ratePair]lnutil.RateDesc
+	rateSpreads   map[ratePair]uint32
+	ratesChanged  chan bool // to advertise new rates straight away
+	ratesMtx      sync.Mutex
+
+	// when links are dropped from the channel graph.  Behind ChannelMapMtx.
+	graphPrune GraphPruneRules
+
+	// forwarding policies of channels which have their own, and the default
+	policies      map[wire.OutPoint]lnutil.ChanPolicy
+	defaultPolicy lnutil.ChanPolicy
+	policyMtx     sync.Mutex
+
+	// links payments have failed over lately, which routing steers round
+	edgeFailures map[[44]byte]EdgeFailure
+	missionMtx   sync.Mutex
+
+	// serializes writes of the static channel backup
+	backupMtx sync.Mutex
 
 	// REFACTORING FIELDS
 	PeerMap    map[*lnp2p.Peer]*RemotePeer // we never remove things from here, so this is a memory leak
@@ -160,6 +201,43 @@ type InFlightMultihop struct {
 	HHash     [32]byte
 	PreImage  [16]byte
 	Succeeded bool
+
+	// Shared secrets from the onion: one for each hop if we sent the
+	// payment, or ours if we forwarded it.  They're for error onions.
+	OnionSecrets [][32]byte
+	// The peer we got the payment from, if we forwarded it
+	FromPeer uint32
+	// Why the payment failed, if we sent it and heard back
+	Failure string
+
+	// For a payment split over several routes: which part this is, and
+	// the total all the parts get to the payee.  TotalAmt is zero if the
+	// payment isn't split.
+	PartIdx  uint32
+	TotalAmt int64
+
+	// Where the payment is in its life, and each route we've tried it
+	// over if we sent it.  PayeeKey is the payee's key if we only know it
+	// from an invoice, for when we try another route.
+	State    PaymentState
+	Attempts []PaymentAttempt
+	PayeeKey [33]byte
+
+	// Whether it's a spontaneous payment: the sender picked the preimage
+	// and sent it to the payee in the onion, so PreImage is set from the
+	// start.
+	Keysend bool
+}
+
+// key is what the payment is saved under: its hash, with the part index
+// after it for parts other than the first.
+func (p *InFlightMultihop) key() []byte {
+	if p.PartIdx == 0 {
+		return p.HHash[:]
+	}
+	var idx [4]byte
+	binary.BigEndian.PutUint32(idx[:], p.PartIdx)
+	return append(p.HHash[:], idx[:]...)
 }
 
 func (p *InFlightMultihop) Bytes() []byte {
@@ -177,6 +255,29 @@ func (p *InFlightMultihop) Bytes() []byte {
 
 	binary.Write(&buf, binary.BigEndian, p.Succeeded)
 
+	wire.WriteVarInt(&buf, 0, uint64(len(p.OnionSecrets)))
+	for _, s := range p.OnionSecrets {
+		buf.Write(s[:])
+	}
+	binary.Write(&buf, binary.BigEndian, p.FromPeer)
+	wire.WriteVarString(&buf, 0, p.Failure)
+	binary.Write(&buf, binary.BigEndian, p.PartIdx)
+	binary.Write(&buf, binary.BigEndian, p.TotalAmt)
+
+	buf.WriteByte(byte(p.State))
+	wire.WriteVarInt(&buf, 0, uint64(len(p.Attempts)))
+	for _, a := range p.Attempts {
+		buf.Write(a.Bytes())
+	}
+	buf.Write(p.PayeeKey[:])
+	binary.Write(&buf, binary.BigEndian, p.Keysend)
+
+	// where each attempt's HTLC is, after the rest so older payments load
+	for _, a := range p.Attempts {
+		buf.Write(a.Chan[:])
+		binary.Write(&buf, binary.BigEndian, a.Locktime)
+	}
+
 	return buf.Bytes()
 }
 
@@ -205,6 +306,99 @@ func InFlightMultihopFromBytes(b []byte) (*InFlightMultihop, error) {
 		return mh, err
 	}
 
+	// payments from before onions end here
+	if buf.Len() == 0 {
+		mh.State = mh.oldState()
+		return mh, nil
+	}
+
+	secrets, err := wire.ReadVarInt(buf, 0)
+	if err != nil {
+		return mh, err
+	}
+	if secrets > uint64(buf.Len()/32) {
+		return mh, fmt.Errorf("%d onion secrets in %d bytes", secrets, buf.Len())
+	}
+	for i := uint64(0); i < secrets; i++ {
+		var s [32]byte
+		copy(s[:], buf.Next(32))
+		mh.OnionSecrets = append(mh.OnionSecrets, s)
+	}
+	err = binary.Read(buf, binary.BigEndian, &mh.FromPeer)
+	if err != nil {
+		return mh, err
+	}
+	mh.Failure, err = wire.ReadVarString(buf, 0)
+	if err != nil {
+		return mh, err
+	}
+
+	// and payments from before multi-path here
+	if buf.Len() == 0 {
+		mh.State = mh.oldState()
+		return mh, nil
+	}
+
+	err = binary.Read(buf, binary.BigEndian, &mh.PartIdx)
+	if err != nil {
+		return mh, err
+	}
+	err = binary.Read(buf, binary.BigEndian, &mh.TotalAmt)
+	if err != nil {
+		return mh, err
+	}
+
+	// and payments from before retries here
+	if buf.Len() == 0 {
+		mh.State = mh.oldState()
+		return mh, nil
+	}
+
+	state, err := buf.ReadByte()
+	if err != nil {
+		return mh, err
+	}
+	mh.State = PaymentState(state)
+	attempts, err := wire.ReadVarInt(buf, 0)
+	if err != nil {
+		return mh, err
+	}
+	for i := uint64(0); i < attempts; i++ {
+		a, err := paymentAttemptFromBuf(buf)
+		if err != nil {
+			return mh, err
+		}
+		mh.Attempts = append(mh.Attempts, a)
+	}
+	if buf.Len() < 33 {
+		return mh, fmt.Errorf("no payee key in %d bytes", buf.Len())
+	}
+	copy(mh.PayeeKey[:], buf.Next(33))
+
+	// and payments from before keysend here
+	if buf.Len() == 0 {
+		return mh, nil
+	}
+
+	err = binary.Read(buf, binary.BigEndian, &mh.Keysend)
+	if err != nil {
+		return mh, err
+	}
+
+	// and payments from before we kept where their HTLCs are here
+	if buf.Len() == 0 {
+		return mh, nil
+	}
+
+	if buf.Len() != len(mh.Attempts)*40 {
+		return mh, fmt.Errorf("%d bytes of HTLCs for %d attempts",
+			buf.Len(), len(mh.Attempts))
+	}
+	for i := range mh.Attempts {
+		copy(mh.Attempts[i].Chan[:], buf.Next(36))
+		mh.Attempts[i].Locktime = binary.BigEndian.Uint32(buf.Next(4))
+	}
+
 	return mh, nil
 }
 
@@ -220,9 +414,18 @@ type RemotePeer struct {
 type InFlightFund struct {
 	PeerIdx, ChanIdx, Coin uint32
 	Amt, InitSend          int64
+	FeePerByte             int64           // for the funding tx
+	Ins                    []wire.OutPoint // funding inputs, if chosen
 
 	op *wire.OutPoint
 
+	// External funding: the funding tx comes from a PSBT signed outside
+	// lit.  extTxo gets the output it has to pay once the peer responds.
+	External bool
+	extResp  *lnutil.PointRespMsg
+	extTxo   chan *wire.TxOut
+	extTx    *wire.MsgTx
+
 	done chan uint32
 	// use this to avoid crashiness
 	mtx sync.Mutex
@@ -236,6 +439,11 @@ func (inff *InFlightFund) Clear() {
 
 	inff.Amt = 0
 	inff.InitSend = 0
+	inff.FeePerByte = 0
+	inff.Ins = nil
+	inff.External = false
+	inff.extResp = nil
+	inff.extTx = nil
 }
 
 // InFlightDualFund is a dual funding transaction that has not yet been broadcast
@@ -319,23 +527,75 @@ func (nd *LitNode) GetNicknameFromPeerIdx(idx uint32) string {
 	return nickname
 }
 
-// NextIdx returns the next channel index to use.
+// NextIdx returns the next channel index to use.  It's after every channel
+// we have, open or closed, and every channel a restored backup knew of, as
+// the index picks the keys of the channel.
 func (nd *LitNode) NextChannelIdx() (uint32, error) {
-	var cIdx uint32
-	err := nd.LitDB.View(func(btx *bolt.Tx) error {
+	maxIdx, err := nd.maxChannelIdx()
+	if err != nil {
+		return 0, err
+	}
+	return maxIdx + 1, nil
+}
+
+// maxChannelIdx gives the highest channel index used so far.
+func (nd *LitNode) maxChannelIdx() (uint32, error) {
+	cdb := nd.NewLitDB.GetChannelDB()
+
+	infos, err := cdb.GetChannels()
+	if err != nil {
+		return 0, err
+	}
+	ahs, err := cdb.GetArchivedChannelHandles()
+	if err != nil {
+		return 0, err
+	}
+	for _, h := range ahs {
+		info, err := cdb.GetChannel(h)
+		if err != nil {
+			return 0, err
+		}
+		infos = append(infos, *info)
+	}
+
+	var maxIdx uint32
+	err = nd.LitDB.View(func(btx *bolt.Tx) error {
 		cmp := btx.Bucket(BKTChanMap)
 		if cmp == nil {
-			return fmt.Errorf("NextIdxForPeer: no ChanMap")
+			return nil
+		}
+		if idxBytes := cmp.Get(KEYIdx); idxBytes != nil {
+			maxIdx = lnutil.BtU32(idxBytes)
 		}
-
-		cIdx = uint32(cmp.Stats().KeyN + 1)
 		return nil
 	})
 	if err != nil {
 		return 0, err
 	}
 
-	return cIdx, nil
+	for _, info := range infos {
+		idx := info.Txo.KeyGen.Step[4] & 0x7fffffff
+		if idx > maxIdx {
+			maxIdx = idx
+		}
+	}
+	return maxIdx, nil
+}
+
+// raiseChannelIdx makes sure channel indexes up to idx aren't used again,
+// for channels we only know of from a backup.
+func (nd *LitNode) raiseChannelIdx(idx uint32) error {
+	return nd.LitDB.Update(func(btx *bolt.Tx) error {
+		cmp := btx.Bucket(BKTChanMap)
+		if cmp == nil {
+			return fmt.Errorf("no channel map bucket")
+		}
+		if idxBytes := cmp.Get(KEYIdx); idxBytes != nil &&
+			lnutil.BtU32(idxBytes) >= idx {
+			return nil
+		}
+		return cmp.Put(KEYIdx, lnutil.U32tB(idx))
+	})
 }
 
 // SaveNicknameForPeerIdx saves/overwrites a nickname for a given peer idx
@@ -353,25 +613,73 @@ func (nd *LitNode) SaveNicknameForPeerIdx(nickname string, idx uint32) error {
 	return err // same as if err != nil { return err } ; return nil
 }
 
+// ChanHandleFromOp makes the storage handle for a channel from its outpoint.
+func ChanHandleFromOp(opArr [36]byte) lncore.ChannelHandle {
+	var h lncore.ChannelHandle
+	copy(h[:], opArr[:])
+	return h
+}
+
+// channelInfoForQchan makes the channel info we store for a qchan.
+func (nd *LitNode) channelInfoForQchan(q *Qchan) (*lncore.ChannelInfo, error) {
+	info, err := NewChannelInfoFromQchan(q)
+	if err != nil {
+		return nil, err
+	}
+
+	// Not all of our peers are connected, so this isn't always available.
+	peer := nd.PeerMan.GetPeerByIdx(int32(q.Peer()))
+	if peer != nil {
+		info.PeerAddr = string(peer.GetLnAddr())
+	}
+
+	return info, nil
+}
+
 // SaveQchanUtxoData saves utxo data such as outpoint and close tx / status.
 func (nd *LitNode) SaveQchanUtxoData(q *Qchan) error {
-	logging.Warnln("someone tried to SaveQchanUtxoData, doing some hacks to make it save only parts of it")
+	cdb := nd.NewLitDB.GetChannelDB()
+	h := ChanHandleFromOp(lnutil.OutPointToBytes(q.Op))
 
-	// XXX This is a horrible hack and we need to change other code to not be
-	// dependent on the way this data is saved/loaded.
-	opArr := lnutil.OutPointToBytes(q.Op)
-	fq, err := nd.GetQchan(opArr)
+	info, err := cdb.GetChannel(h)
 	if err != nil {
-		return nil
+		return err
+	}
+	if info == nil {
+		return fmt.Errorf("SaveQchanUtxoData: channel %s not in db", q.Op.String())
 	}
-	fq.PorTxo = q.PorTxo
 
-	// we also quietly save close data when we call this function
-	if q.CloseData.Closed {
-		fq.CloseData = q.CloseData
+	qinfo, err := NewChannelInfoFromQchan(q)
+	if err != nil {
+		return err
 	}
 
-	return nd.SaveQChan(fq)
+	info.Txo = qinfo.Txo
+	info.OpenHeight = qinfo.OpenHeight
+
+	// we also quietly save close data when we call this function.  Don't
+	// un-close a channel that's already stored as closed though.
+	closing := q.CloseData.Closed && !info.CloseData.Closed
+	if q.CloseData.Closed || !info.CloseData.Closed {
+		info.CloseData = qinfo.CloseData
+		info.State = qinfo.State
+	}
+
+	err = cdb.UpdateChannel(h, *info)
+	if err != nil {
+		return err
+	}
+	// closed channels go to the archive, and drop out of the backup.  They
+	// can still be loaded and updated from there while their outputs get
+	// swept.
+	if closing {
+		err = cdb.ArchiveChannel(h)
+		if err != nil {
+			return err
+		}
+		nd.updateChannelBackup()
+	}
+	return nil
 }
 
 // register a new Qchan in the db
@@ -380,159 +688,147 @@ func (nd *LitNode) SaveQChan(q *Qchan) error {
 		return fmt.Errorf("SaveQChan: nil qchan")
 	}
 
-	opArr := lnutil.OutPointToBytes(q.Op)
-	cIdBytes := lnutil.U32tB(q.Idx())
-
-	qdata := nd.QchanSerializeToBytes(q)
-
-	// save channel to db.  It has no state, and has no outpoint yet
-	err := nd.LitDB.Update(func(btx *bolt.Tx) error {
-		var err error
+	cdb := nd.NewLitDB.GetChannelDB()
+	h := ChanHandleFromOp(lnutil.OutPointToBytes(q.Op))
 
-		cdb := btx.Bucket(BKTChannelData)
-		if cdb == nil {
-			return fmt.Errorf("channel data bucket not found")
-		}
-
-		cmb := btx.Bucket(BKTChanMap)
-		if cmb == nil {
-			return fmt.Errorf("channel map bucket not found")
-		}
+	info, err := nd.channelInfoForQchan(q)
+	if err != nil {
+		return err
+	}
 
-		// Save both the channel...
-		err = cdb.Put(opArr[:], qdata)
-		if err != nil {
-			return err
-		}
+	old, err := cdb.GetChannel(h)
+	if err != nil {
+		return err
+	}
 
-		// ...and the channel ID mapping.
-		err = cmb.Put(cIdBytes[:], opArr[:])
+	// save channel to db.  It has no state, and has no outpoint yet
+	if old == nil {
+		err = cdb.AddChannel(h, *info)
 		if err != nil {
 			return err
 		}
-
+		nd.updateChannelBackup()
 		return nil
-	})
-	if err != nil {
-		return err
 	}
 
-	return nil
+	// Don't forget the peer's address if they're offline right now.
+	if info.PeerAddr == "" {
+		info.PeerAddr = old.PeerAddr
+	}
+
+	return cdb.UpdateChannel(h, *info)
 }
 
 // ReloadQchan loads updated data from the db into the qchan.  Loads elkrem
 // and state, but does not change qchan info itself.  Faster than GetQchan()
 // also reload the channel close state
 func (nd *LitNode) ReloadQchanState(qc *Qchan) error {
-	opArr := lnutil.OutPointToBytes(qc.Op)
+	h := ChanHandleFromOp(lnutil.OutPointToBytes(qc.Op))
 
-	return nd.LitDB.View(func(btx *bolt.Tx) error {
-		b := btx.Bucket(BKTChannelData)
-		if b == nil {
-			return fmt.Errorf("channel data bucket not found")
-		}
+	info, err := nd.NewLitDB.GetChannelDB().GetChannel(h)
+	if err != nil {
+		return err
+	}
+	if info == nil {
+		return fmt.Errorf("channel not found in DB")
+	}
 
-		buf := b.Get(opArr[:])
-		if buf == nil {
-			return fmt.Errorf("channel not found in DB")
-		}
-		return nd.QchanUpdateFromBytes(qc, buf)
-	})
+	return nd.ApplyChannelInfoToQchan(info, qc)
 }
 
-// Save / overwrite state of qChan in db
-// the descent into the qchan bucket is boilerplate and it'd be nice
-// if we can make that it's own function.  Get channel bucket maybe?  But then
-// you have to close it...
+// Save / overwrite state of qChan in db.  This also writes the elkrem receiver,
+// since it changes along with the state.
 func (nd *LitNode) SaveQchanState(q *Qchan) error {
-	logging.Warnln("someone called SaveQchanState, but this is deprecated.  doing some hacks to make it save just that.")
+	cdb := nd.NewLitDB.GetChannelDB()
+	h := ChanHandleFromOp(lnutil.OutPointToBytes(q.Op))
 
-	// XXX This is a horrible hack and we need to change other code to not be
-	// dependent on the way this data is saved/loaded.
-	opArr := lnutil.OutPointToBytes(q.Op)
-	fq, err := nd.GetQchan(opArr)
+	info, err := cdb.GetChannel(h)
 	if err != nil {
-		return nil
+		return err
+	}
+	if info == nil {
+		return fmt.Errorf("SaveQchanState: channel %s not in db", q.Op.String())
+	}
+
+	qinfo, err := NewChannelInfoFromQchan(q)
+	if err != nil {
+		return err
+	}
+
+	info.Commitment = qinfo.Commitment
+	info.ElkRecv = qinfo.ElkRecv
+	info.LastUpdate = qinfo.LastUpdate
+	if qinfo.State == lncore.CstateError {
+		info.State = lncore.CstateError
 	}
-	fq.State = q.State
 
-	return nd.SaveQChan(fq)
+	return cdb.UpdateChannel(h, *info)
 }
 
-// GetAllQchans returns a slice of all channels. empty slice is OK.
+// GetAllQchans returns a slice of all channels, including archived ones.
+// empty slice is OK.
 func (nd *LitNode) GetAllQchans() ([]*Qchan, error) {
-	var qChans []*Qchan
-	err := nd.LitDB.View(func(btx *bolt.Tx) error {
-		b := btx.Bucket(BKTChannelData)
-		if b == nil {
-			return fmt.Errorf("channel data bucket not found")
-		}
-		return b.ForEach(func(_, buf []byte) error {
-			newQc, err := nd.QchanDeserializeFromBytes(buf)
-			if err != nil {
-				return err // should we not return this?
-			}
+	cdb := nd.NewLitDB.GetChannelDB()
 
-			qChans = append(qChans, newQc)
-			return nil
-		})
-	})
+	infos, err := cdb.GetChannels()
 	if err != nil {
 		return nil, err
 	}
-	return qChans, nil
-}
-
-// GetQchan returns a single channel.
-// pubkey and outpoint bytes.
-func (nd *LitNode) GetQchan(opArr [36]byte) (*Qchan, error) {
-
-	var qc *Qchan
-	var err error
-	err = nd.LitDB.View(func(btx *bolt.Tx) error {
-
-		var err error
-
-		b := btx.Bucket(BKTChannelData)
-		if b == nil {
-			return fmt.Errorf("channel data bucket not found")
-		}
 
-		buf := b.Get(opArr[:])
-		if buf == nil {
-			return fmt.Errorf("channel not found in DB")
+	ahs, err := cdb.GetArchivedChannelHandles()
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range ahs {
+		info, err := cdb.GetChannel(h)
+		if err != nil {
+			return nil, err
 		}
+		infos = append(infos, *info)
+	}
 
-		// Go has weird scoping rules, I hope this doesn't break things.
-		qc, err = nd.QchanDeserializeFromBytes(buf)
+	var qChans []*Qchan
+	for i := range infos {
+		newQc, err := nd.NewQchanFromChannelInfo(&infos[i])
 		if err != nil {
-			return err
+			return nil, err // should we not return this?
 		}
 
-		return nil
-	})
+		qChans = append(qChans, newQc)
+	}
+
+	return qChans, nil
+}
+
+// GetQchan returns a single channel.
+// pubkey and outpoint bytes.
+func (nd *LitNode) GetQchan(opArr [36]byte) (*Qchan, error) {
+	info, err := nd.NewLitDB.GetChannelDB().GetChannel(ChanHandleFromOp(opArr))
 	if err != nil {
 		return nil, err
 	}
+	if info == nil {
+		return nil, fmt.Errorf("channel not found in DB")
+	}
 
-	return qc, nil
+	return nd.NewQchanFromChannelInfo(info)
 }
 
 func (nd *LitNode) GetQchanOPfromIdx(cIdx uint32) ([36]byte, error) {
 	var rOp [36]byte
-	err := nd.LitDB.View(func(btx *bolt.Tx) error {
-		cmp := btx.Bucket(BKTChanMap)
-		if cmp == nil {
-			return fmt.Errorf("no channel map")
-		}
-		op := cmp.Get(lnutil.U32tB(cIdx))
-		if op == nil {
-			return fmt.Errorf("no channel %d in db", cIdx)
+
+	qcs, err := nd.GetAllQchans()
+	if err != nil {
+		return rOp, err
+	}
+
+	for _, qc := range qcs {
+		if qc.Idx() == cIdx {
+			return lnutil.OutPointToBytes(qc.Op), nil
 		}
-		copy(rOp[:], op)
-		return nil
-	})
-	return rOp, err
+	}
+
+	return rOp, fmt.Errorf("no channel %d in db", cIdx)
 }
 
 // GetQchanByIdx is a gets the channel when you don't know the peer bytes and
@@ -550,6 +846,51 @@ func (nd *LitNode) GetQchanByIdx(cIdx uint32) (*Qchan, error) {
 	return qc, nil
 }
 
+// migrateLegacyChannels copies any channels still sitting in the old ln.db
+// buckets into the channel storage.  Channels that are already there are left
+// alone, so this is safe to run on every startup.
+func (nd *LitNode) migrateLegacyChannels() error {
+	var legacy []*ChanData
+	err := nd.LitDB.View(func(btx *bolt.Tx) error {
+		b := btx.Bucket(BKTChannelData)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(_, buf []byte) error {
+			cd, err := ChanDataFromBytes(buf)
+			if err != nil {
+				return err
+			}
+			legacy = append(legacy, cd)
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	cdb := nd.NewLitDB.GetChannelDB()
+	for _, cd := range legacy {
+		h := ChanHandleFromOp(lnutil.OutPointToBytes(cd.Txo.Op))
+
+		old, err := cdb.GetChannel(h)
+		if err != nil {
+			return err
+		}
+		if old != nil {
+			continue
+		}
+
+		logging.Infof("migrating channel %s to new channel storage", cd.Txo.Op.String())
+		err = cdb.AddChannel(h, NewChannelInfoFromChanData(cd))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 // SaveMultihopPayment saves a new (or updates an existing) multihop payment in the database
 func (nd *LitNode) SaveMultihopPayment(p *InFlightMultihop) error {
 	err := nd.LitDB.Update(func(btx *bolt.Tx) error {
@@ -558,8 +899,8 @@ func (nd *LitNode) SaveMultihopPayment(p *InFlightMultihop) error {
 			return fmt.Errorf("SaveMultihopPayment: no payments bucket")
 		}
 
-		// save hash : payment
-		err := cmp.Put(p.HHash[:], p.Bytes())
+		// save hash (and part) : payment
+		err := cmp.Put(p.key(), p.Bytes())
 		if err != nil {
 			return err
 		}
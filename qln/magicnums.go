@@ -34,21 +34,29 @@ const (
 )
 
 var (
-	BKTChannelData = []byte("channels")
+	BKTChannelData = []byte("channels") // legacy, channels are in LitChannelStorage now
 
 	//BKTChannel  = []byte("chn") // all channel data is in this bucket.
 	BKTPeers    = []byte("pir") // all peer data is in this bucket.
 	BKTPeerMap  = []byte("pmp") // map of peer index to pubkey
-	BKTChanMap  = []byte("cmp") // map of channel index to outpoint
+	BKTChanMap  = []byte("cmp") // legacy map of channel index to outpoint, and KEYIdx
 	BKTWatch    = []byte("wch") // txids & signatures for export to watchtowers
 	BKTHTLCOPs  = []byte("hlo") // htlc outpoints to watch
 	BKTPayments = []byte("pym") // array of multihop payments
 	BKTRCAuth   = []byte("rca") // Remote control authorization
+	BKTInvoices = []byte("inv") // invoices we've made, with their preimages
+	BKTPolicies = []byte("pol") // forwarding policies, by channel outpoint
+	BKTGraph    = []byte("grf") // channel graph links, by A and funding outpoint
+	BKTMission  = []byte("msn") // links payments have failed over, by A, B and coin type
+	BKTRates    = []byte("rts") // exchange rates and spreads set by hand, by coin pair
+	BKTHeld     = []byte("hld") // parts of multi-path payments to us we're holding, by hash and HTLC
 
-	KEYIdx      = []byte("idx")  // index for key derivation
+	KEYIdx      = []byte("idx")  // index for key derivation; in BKTChanMap the highest channel index used
 	KEYhost     = []byte("hst")  // hostname where peer lives
 	KEYnickname = []byte("nick") // nickname where peer lives
 
+	KEYDefaultPolicy = []byte("dft") // forwarding policy of channels without their own
+
 	KEYutxo    = []byte("utx") // serialized utxo for the channel
 	KEYState   = []byte("now") // channel state
 	KEYElkRecv = []byte("elk") // elkrem receiver
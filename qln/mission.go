@@ -0,0 +1,177 @@
+package qln
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/consts"
+	"github.com/mit-dci/lit/logging"
+)
+
+// edgeFailCost is what FindPath weighs each recent failure over a link as,
+// against fees: about as much as a fee of two thirds of the payment, so
+// routes go round the link unless there's no other way.
+const edgeFailCost = 1.0
+
+// EdgeFailure is mission control's memory of payments failing over a link.
+// Routing steers round the link until it's gone EdgePenaltyTime without
+// failing again.
+type EdgeFailure struct {
+	From     [20]byte // ln address of the node which couldn't send on
+	To       [20]byte // and of the node it couldn't send to
+	CoinType uint32
+	Count    uint32 // failures since it was last forgiven
+	LastFail int64  // unix time of the last one
+}
+
+// penalized says whether routing is still steering round the link at now.
+func (f EdgeFailure) penalized(now int64) bool {
+	return now-f.LastFail < consts.EdgePenaltyTime
+}
+
+func (f EdgeFailure) bytes() []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, f.Count)
+	binary.Write(&buf, binary.BigEndian, f.LastFail)
+	return buf.Bytes()
+}
+
+func edgeFailureFromBytes(k, v []byte) (EdgeFailure, error) {
+	var f EdgeFailure
+	if len(k) != 44 || len(v) != 12 {
+		return f, fmt.Errorf("bad edge failure %x: %x", k, v)
+	}
+	copy(f.From[:], k[:20])
+	copy(f.To[:], k[20:40])
+	f.CoinType = binary.BigEndian.Uint32(k[40:])
+	f.Count = binary.BigEndian.Uint32(v[:4])
+	f.LastFail = int64(binary.BigEndian.Uint64(v[4:]))
+	return f, nil
+}
+
+// loadMissionControl reads the links payments have failed over from the db,
+// and forgets the ones which failed long enough ago.
+func (nd *LitNode) loadMissionControl() error {
+	nd.missionMtx.Lock()
+	defer nd.missionMtx.Unlock()
+
+	nd.edgeFailures = make(map[[44]byte]EdgeFailure)
+	now := time.Now().Unix()
+
+	return nd.LitDB.Update(func(btx *bolt.Tx) error {
+		bkt := btx.Bucket(BKTMission)
+		if bkt == nil {
+			return fmt.Errorf("loadMissionControl: no mission control bucket")
+		}
+		var old [][]byte
+		err := bkt.ForEach(func(k, v []byte) error {
+			f, err := edgeFailureFromBytes(k, v)
+			if err != nil {
+				logging.Warnf("dropping %s", err.Error())
+				old = append(old, append([]byte(nil), k...))
+				return nil
+			}
+			if !f.penalized(now) {
+				old = append(old, append([]byte(nil), k...))
+				return nil
+			}
+			nd.edgeFailures[linkKey(f.From, f.To, f.CoinType)] = f
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range old {
+			err = bkt.Delete(k)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// failEdge tells mission control a payment failed because the node with ln
+// address from couldn't send on to to in coinType.
+func (nd *LitNode) failEdge(from, to [20]byte, coinType uint32) error {
+	nd.missionMtx.Lock()
+	defer nd.missionMtx.Unlock()
+
+	key := linkKey(from, to, coinType)
+	now := time.Now().Unix()
+	f, ok := nd.edgeFailures[key]
+	if !ok || !f.penalized(now) {
+		f = EdgeFailure{From: from, To: to, CoinType: coinType}
+	}
+	f.Count++
+	f.LastFail = now
+
+	err := nd.LitDB.Update(func(btx *bolt.Tx) error {
+		bkt := btx.Bucket(BKTMission)
+		if bkt == nil {
+			return fmt.Errorf("failEdge: no mission control bucket")
+		}
+		return bkt.Put(key[:], f.bytes())
+	})
+	if err != nil {
+		return err
+	}
+	nd.edgeFailures[key] = f
+	return nil
+}
+
+// edgePenalty gives what FindPath adds to the weight of the link from a to b
+// in coinType for the payments which have failed over it lately.
+func (nd *LitNode) edgePenalty(a, b [20]byte, coinType uint32) float64 {
+	nd.missionMtx.Lock()
+	defer nd.missionMtx.Unlock()
+	f, ok := nd.edgeFailures[linkKey(a, b, coinType)]
+	if !ok || !f.penalized(time.Now().Unix()) {
+		return 0
+	}
+	return float64(f.Count) * edgeFailCost
+}
+
+// MissionControl gives the links routing is steering round because payments
+// have failed over them lately, most recent first.
+func (nd *LitNode) MissionControl() []EdgeFailure {
+	now := time.Now().Unix()
+	var list []EdgeFailure
+	nd.missionMtx.Lock()
+	for _, f := range nd.edgeFailures {
+		if f.penalized(now) {
+			list = append(list, f)
+		}
+	}
+	nd.missionMtx.Unlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].LastFail > list[j].LastFail
+	})
+	return list
+}
+
+// ResetMissionControl forgets all the links payments have failed over, so
+// routing uses them again.
+func (nd *LitNode) ResetMissionControl() error {
+	nd.missionMtx.Lock()
+	defer nd.missionMtx.Unlock()
+
+	err := nd.LitDB.Update(func(btx *bolt.Tx) error {
+		err := btx.DeleteBucket(BKTMission)
+		if err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+		_, err = btx.CreateBucket(BKTMission)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	nd.edgeFailures = make(map[[44]byte]EdgeFailure)
+	return nil
+}
@@ -0,0 +1,82 @@
+package qln
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mit-dci/lit/consts"
+)
+
+func TestMissionControl(t *testing.T) {
+	nd, cleanup := newTestNode(t)
+	defer cleanup()
+
+	err := nd.loadMissionControl()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var a, b [20]byte
+	a[0], b[0] = 1, 2
+
+	if nd.edgePenalty(a, b, 257) != 0 {
+		t.Fatalf("penalty on a link which hasn't failed")
+	}
+	for i := 0; i < 2; i++ {
+		err = nd.failEdge(a, b, 257)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if nd.edgePenalty(a, b, 257) != 2*edgeFailCost {
+		t.Fatalf("penalty %f after 2 failures, expect %f",
+			nd.edgePenalty(a, b, 257), 2*edgeFailCost)
+	}
+	// only that way, and only in that coin
+	if nd.edgePenalty(b, a, 257) != 0 || nd.edgePenalty(a, b, 1) != 0 {
+		t.Fatalf("penalty on other links")
+	}
+	if len(nd.MissionControl()) != 1 {
+		t.Fatalf("%d failing links, expect 1", len(nd.MissionControl()))
+	}
+
+	// a restarted node remembers it
+	err = nd.loadMissionControl()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nd.edgePenalty(a, b, 257) != 2*edgeFailCost {
+		t.Fatalf("penalty %f after reloading, expect %f",
+			nd.edgePenalty(a, b, 257), 2*edgeFailCost)
+	}
+
+	// it's forgiven once it's gone EdgePenaltyTime without failing
+	key := linkKey(a, b, 257)
+	f := nd.edgeFailures[key]
+	f.LastFail = time.Now().Unix() - consts.EdgePenaltyTime
+	nd.edgeFailures[key] = f
+	if nd.edgePenalty(a, b, 257) != 0 || len(nd.MissionControl()) != 0 {
+		t.Fatalf("link still penalized after EdgePenaltyTime")
+	}
+
+	// and failing again starts the count over
+	err = nd.failEdge(a, b, 257)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nd.MissionControl()[0].Count != 1 {
+		t.Fatalf("count %d after failing again, expect 1", nd.MissionControl()[0].Count)
+	}
+
+	err = nd.ResetMissionControl()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = nd.loadMissionControl()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nd.edgePenalty(a, b, 257) != 0 {
+		t.Fatalf("penalty after resetting")
+	}
+}
@@ -0,0 +1,320 @@
+package qln
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/bech32"
+	"github.com/mit-dci/lit/consts"
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/logging"
+	"github.com/mit-dci/lit/wire"
+)
+
+// payRoute is one part of a payment we're sending: its route, how much we
+// send on it, and how much of that gets to the payee.
+type payRoute struct {
+	path    []lnutil.RouteHop
+	send    int64
+	deliver int64
+}
+
+// findRoutes finds routes to send a payment over.  It's one route if one
+// can carry it all; if not it's split evenly over as few routes as will do,
+// up to MaxPaymentParts.  The routes don't share any nodes on the way, and
+// leave us over different channels.  With coverFees, amt is what has to get
+// to the payee and we send more to cover the hops' fees; without, amt is
+// what we send.
+func (nd *LitNode) findRoutes(target [20]byte, destCoinType,
+	originCoinType uint32, amt int64, coverFees bool) ([]payRoute, error) {
+
+	var err error
+	for parts := int64(1); parts <= consts.MaxPaymentParts; parts++ {
+		var routes []payRoute
+		routes, err = nd.splitRoutes(target, destCoinType, originCoinType,
+			amt, parts, coverFees)
+		if err == nil {
+			return routes, nil
+		}
+		logging.Debugf("can't send %d in %d parts: %s", amt, parts, err.Error())
+	}
+	return nil, fmt.Errorf("can't send %d over up to %d routes: %s",
+		amt, consts.MaxPaymentParts, err.Error())
+}
+
+// splitRoutes finds routes for amt split into parts, as for findRoutes.
+func (nd *LitNode) splitRoutes(target [20]byte, destCoinType,
+	originCoinType uint32, amt, parts int64, coverFees bool) ([]payRoute, error) {
+
+	exclude := make(map[[20]byte]bool)
+	used := make(map[*Qchan]bool)
+	routes := make([]payRoute, parts)
+
+	for i := range routes {
+		partAmt := amt / parts
+		if i == 0 {
+			partAmt += amt % parts
+		}
+
+		r := &routes[i]
+		var err error
+		if coverFees {
+			r.path, r.send, err = nd.findPathWithFees(target, destCoinType,
+				originCoinType, partAmt, exclude)
+		} else {
+			r.send = partAmt
+			r.path, err = nd.findPath(target, destCoinType, originCoinType,
+				partAmt, exclude)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		amts, _, err := nd.routeTerms(r.path, r.send)
+		if err != nil {
+			return nil, err
+		}
+		r.deliver = amts[len(amts)-1]
+
+		qc, _, err := nd.firstHopChan(r.path[1].Node, originCoinType, r.send, used)
+		if err != nil {
+			return nil, err
+		}
+		used[qc] = true
+
+		// the rest go round the nodes this one goes through
+		for _, hop := range r.path[1 : len(r.path)-1] {
+			exclude[hop.Node] = true
+		}
+	}
+
+	return routes, nil
+}
+
+// firstHopChan finds one of our channels to the node with ln address pkh
+// which can send amt in coinType, and isn't in used.  It gives the peer
+// index of the node too.
+func (nd *LitNode) firstHopChan(pkh [20]byte, coinType uint32, amt int64,
+	used map[*Qchan]bool) (*Qchan, uint32, error) {
+
+	peerIdx, err := nd.FindPeerIndexByAddress(bech32.Encode("ln", pkh[:]))
+	if err != nil {
+		return nil, 0, fmt.Errorf("not connected to first hop in route")
+	}
+
+	nd.RemoteMtx.Lock()
+	defer nd.RemoteMtx.Unlock()
+	peer, ok := nd.RemoteCons[peerIdx]
+	if !ok {
+		return nil, 0, fmt.Errorf("not connected to first hop in route")
+	}
+	for _, ch := range peer.QCs {
+		if ch.Coin() == coinType && !used[ch] && ch.State.MyAmt-consts.MinOutput-ch.State.Fee >= amt && !ch.CloseData.Closed && !ch.State.Failed {
+			return ch, peerIdx, nil
+		}
+	}
+	return nil, 0, fmt.Errorf("could not find suitable channel to route payment")
+}
+
+// heldPayment is the parts of a multi-path payment to us we've got so far.
+// We hold on to them until they add up to the total, then claim them all.
+type heldPayment struct {
+	total int64
+	got   int64
+	parts []heldPart
+	timer *time.Timer
+}
+
+// heldPart is a part of a payment we're holding: where its HTLC is, and
+// what we need to send an error back for it.
+type heldPart struct {
+	op      wire.OutPoint
+	htlcIdx uint32
+	peerIdx uint32
+	secret  [32]byte
+}
+
+// heldPartKey is what a part is saved under: the payment hash, then the
+// channel and index of its HTLC.
+func heldPartKey(hash [32]byte, p heldPart) []byte {
+	op := lnutil.OutPointToBytes(p.op)
+	key := append(hash[:], op[:]...)
+	return append(key, lnutil.U32tB(p.htlcIdx)...)
+}
+
+// saveHeldParts saves parts we're holding, so if we stop before the rest of
+// their payment comes we can fail them back when we start again.
+func (nd *LitNode) saveHeldParts(hash [32]byte, parts []heldPart) error {
+	return nd.LitDB.Update(func(btx *bolt.Tx) error {
+		bkt := btx.Bucket(BKTHeld)
+		if bkt == nil {
+			return fmt.Errorf("saveHeldParts: no held parts bucket")
+		}
+		for _, p := range parts {
+			v := append(lnutil.U32tB(p.peerIdx), p.secret[:]...)
+			err := bkt.Put(heldPartKey(hash, p), v)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// deleteHeldParts drops parts we're done with from the db.
+func (nd *LitNode) deleteHeldParts(hash [32]byte, parts []heldPart) error {
+	return nd.LitDB.Update(func(btx *bolt.Tx) error {
+		bkt := btx.Bucket(BKTHeld)
+		if bkt == nil {
+			return fmt.Errorf("deleteHeldParts: no held parts bucket")
+		}
+		for _, p := range parts {
+			err := bkt.Delete(heldPartKey(hash, p))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// loadHeldParts reads the parts we were holding when we stopped.
+func (nd *LitNode) loadHeldParts() (map[[32]byte][]heldPart, error) {
+	held := make(map[[32]byte][]heldPart)
+	err := nd.LitDB.View(func(btx *bolt.Tx) error {
+		bkt := btx.Bucket(BKTHeld)
+		if bkt == nil {
+			return fmt.Errorf("loadHeldParts: no held parts bucket")
+		}
+		return bkt.ForEach(func(k, v []byte) error {
+			if len(k) != 72 || len(v) != 36 {
+				return fmt.Errorf("bad held part %x", k)
+			}
+			var hash [32]byte
+			var op [36]byte
+			var p heldPart
+			copy(hash[:], k[:32])
+			copy(op[:], k[32:68])
+			p.op = *lnutil.OutPointFromBytes(op)
+			p.htlcIdx = lnutil.BtU32(k[68:])
+			p.peerIdx = lnutil.BtU32(v[:4])
+			copy(p.secret[:], v[4:])
+			held[hash] = append(held[hash], p)
+			return nil
+		})
+	})
+	return held, err
+}
+
+// failStaleParts fails back the parts from a peer we were holding when we
+// last stopped.  We've lost the rest of their payments, so they'd only sit
+// there until they time out.
+func (nd *LitNode) failStaleParts(peerIdx uint32) {
+	nd.MultihopMutex.Lock()
+	stale := make(map[[32]byte][]heldPart)
+	for hash, parts := range nd.staleParts {
+		var rest []heldPart
+		for _, p := range parts {
+			if p.peerIdx == peerIdx {
+				stale[hash] = append(stale[hash], p)
+			} else {
+				rest = append(rest, p)
+			}
+		}
+		if len(rest) == 0 {
+			delete(nd.staleParts, hash)
+		} else {
+			nd.staleParts[hash] = rest
+		}
+	}
+	nd.MultihopMutex.Unlock()
+
+	for hash, parts := range stale {
+		nd.failParts(hash, parts, fmt.Errorf("restarted while holding the payment"))
+		err := nd.deleteHeldParts(hash, parts)
+		if err != nil {
+			logging.Errorf("failStaleParts: %s", err.Error())
+		}
+	}
+}
+
+// partHeld says whether HTLC idx in the channel with funding outpoint op is
+// a part we're holding of the payment with the hash.
+func (nd *LitNode) partHeld(hash [32]byte, op wire.OutPoint, idx uint32) bool {
+	nd.MultihopMutex.Lock()
+	defer nd.MultihopMutex.Unlock()
+	hp, ok := nd.heldParts[hash]
+	if !ok {
+		return false
+	}
+	for _, p := range hp.parts {
+		if p.op == op && p.htlcIdx == idx {
+			return true
+		}
+	}
+	return false
+}
+
+// holdPart holds on to a part of amt of a payment with the hash, which the
+// sender says comes to total.  Once the parts add up to it, it gives them
+// all and what they come to; until then the parts are nil.  If they don't
+// all come in MultiPathHoldTime we send errors back for them.  The caller
+// holds MultihopMutex.
+func (nd *LitNode) holdPart(hash [32]byte, total, amt int64,
+	part heldPart) ([]heldPart, int64, error) {
+
+	hp, ok := nd.heldParts[hash]
+	if !ok {
+		hp = &heldPayment{total: total}
+		nd.heldParts[hash] = hp
+		hp.timer = time.AfterFunc(consts.MultiPathHoldTime*time.Second, func() {
+			nd.MultihopMutex.Lock()
+			defer nd.MultihopMutex.Unlock()
+			hp, ok := nd.heldParts[hash]
+			if !ok {
+				return
+			}
+			delete(nd.heldParts, hash)
+			nd.failParts(hash, hp.parts, fmt.Errorf(
+				"only got %d of %d before timing out", hp.got, hp.total))
+			err := nd.deleteHeldParts(hash, hp.parts)
+			if err != nil {
+				logging.Errorf("holdPart: %s", err.Error())
+			}
+		})
+	}
+	if total != hp.total {
+		return nil, 0, fmt.Errorf("part says the payment is %d, others said %d",
+			total, hp.total)
+	}
+
+	hp.parts = append(hp.parts, part)
+	hp.got += amt
+	logging.Infof("got %d of %d for multi-path payment %x", hp.got, hp.total, hash)
+	if hp.got < hp.total {
+		err := nd.saveHeldParts(hash, []heldPart{part})
+		if err != nil {
+			logging.Errorf("holdPart: %s", err.Error())
+		}
+		return nil, hp.got, nil
+	}
+
+	hp.timer.Stop()
+	delete(nd.heldParts, hash)
+	err := nd.deleteHeldParts(hash, hp.parts)
+	if err != nil {
+		logging.Errorf("holdPart: %s", err.Error())
+	}
+	return hp.parts, hp.got, nil
+}
+
+// failParts sends errors back for the parts of a payment we can't take,
+// and gives back the failure.
+func (nd *LitNode) failParts(hash [32]byte, parts []heldPart, failure error) error {
+	logging.Warnf("failing multi-path payment %x: %s", hash, failure.Error())
+	for _, p := range parts {
+		nd.failMultihop(p.peerIdx, hash, p.secret, failure)
+	}
+	return failure
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/mit-dci/lit/logging"
 
 	"github.com/mit-dci/lit/btcutil/txscript"
+	"github.com/mit-dci/lit/consts"
 	"github.com/mit-dci/lit/lnutil"
 	"github.com/mit-dci/lit/portxo"
 	"github.com/mit-dci/lit/wire"
@@ -28,6 +29,7 @@ func (nd *LitNode) registerHandlers() {
 	mp.DefineMessage(lnutil.MSGID_SIGPROOF, makeNeoOmniParser(lnutil.MSGID_SIGPROOF), hf)
 	mp.DefineMessage(lnutil.MSGID_CLOSEREQ, makeNeoOmniParser(lnutil.MSGID_CLOSEREQ), hf)
 	mp.DefineMessage(lnutil.MSGID_CLOSERESP, makeNeoOmniParser(lnutil.MSGID_CLOSERESP), hf)
+	mp.DefineMessage(lnutil.MSGID_FORCECLOSEREQ, makeNeoOmniParser(lnutil.MSGID_FORCECLOSEREQ), hf)
 	mp.DefineMessage(lnutil.MSGID_DELTASIG, makeNeoOmniParser(lnutil.MSGID_DELTASIG), hf)
 	mp.DefineMessage(lnutil.MSGID_SIGREV, makeNeoOmniParser(lnutil.MSGID_SIGREV), hf)
 	mp.DefineMessage(lnutil.MSGID_GAPSIGREV, makeNeoOmniParser(lnutil.MSGID_GAPSIGREV), hf)
@@ -41,6 +43,7 @@ func (nd *LitNode) registerHandlers() {
 	mp.DefineMessage(lnutil.MSGID_WATCH_STATEMSG, makeNeoOmniParser(lnutil.MSGID_WATCH_STATEMSG), hf)
 	mp.DefineMessage(lnutil.MSGID_WATCH_DELETE, makeNeoOmniParser(lnutil.MSGID_WATCH_DELETE), hf)
 	mp.DefineMessage(lnutil.MSGID_LINK_DESC, makeNeoOmniParser(lnutil.MSGID_LINK_DESC), hf)
+	mp.DefineMessage(lnutil.MSGID_LINK_PROOF, makeNeoOmniParser(lnutil.MSGID_LINK_PROOF), hf)
 	mp.DefineMessage(lnutil.MSGID_DLC_OFFER, makeNeoOmniParser(lnutil.MSGID_DLC_OFFER), hf)
 	mp.DefineMessage(lnutil.MSGID_DLC_ACCEPTOFFER, makeNeoOmniParser(lnutil.MSGID_DLC_ACCEPTOFFER), hf)
 	mp.DefineMessage(lnutil.MSGID_DLC_DECLINEOFFER, makeNeoOmniParser(lnutil.MSGID_DLC_DECLINEOFFER), hf)
@@ -56,6 +59,7 @@ func (nd *LitNode) registerHandlers() {
 	mp.DefineMessage(lnutil.MSGID_PAY_REQ, makeNeoOmniParser(lnutil.MSGID_PAY_REQ), hf)
 	mp.DefineMessage(lnutil.MSGID_PAY_ACK, makeNeoOmniParser(lnutil.MSGID_PAY_ACK), hf)
 	mp.DefineMessage(lnutil.MSGID_PAY_SETUP, makeNeoOmniParser(lnutil.MSGID_PAY_SETUP), hf)
+	mp.DefineMessage(lnutil.MSGID_PAY_FAIL, makeNeoOmniParser(lnutil.MSGID_PAY_FAIL), hf)
 
 }
 
@@ -108,6 +112,9 @@ func (nd *LitNode) PeerHandler(msg lnutil.LitMsg, q *Qchan, peer *RemotePeer) er
 		if msg.MsgType() == lnutil.MSGID_LINK_DESC {
 			nd.LinkMsgHandler(msg.(lnutil.LinkMsg))
 		}
+		if msg.MsgType() == lnutil.MSGID_LINK_PROOF {
+			return nd.LinkProofHandler(msg.(lnutil.LinkProofMsg))
+		}
 		if msg.MsgType() == lnutil.MSGID_PAY_REQ {
 			return nd.MultihopPaymentRequestHandler(msg.(lnutil.MultihopPaymentRequestMsg))
 		}
@@ -117,6 +124,9 @@ func (nd *LitNode) PeerHandler(msg lnutil.LitMsg, q *Qchan, peer *RemotePeer) er
 		if msg.MsgType() == lnutil.MSGID_PAY_SETUP {
 			return nd.MultihopPaymentSetupHandler(msg.(lnutil.MultihopPaymentSetupMsg))
 		}
+		if msg.MsgType() == lnutil.MSGID_PAY_FAIL {
+			return nd.MultihopPaymentFailHandler(msg.(lnutil.MultihopPaymentFailMsg))
+		}
 
 	case 0xA0: // Dual Funding messages
 		return nd.DualFundingHandler(msg, peer)
@@ -259,6 +269,11 @@ func (nd *LitNode) CloseHandler(msg lnutil.LitMsg) error {
 		nd.CloseReqHandler(message)
 		return nil
 
+	case lnutil.ForceCloseReqMsg:
+		logging.Infof("Got force close request from %x\n", msg.Peer())
+		nd.ForceCloseReqHandler(message)
+		return nil
+
 	/* - not yet implemented
 	case lnutil.MSGID_CLOSERESP: // CLOSE RESP
 		logging.Infof("Got close response from %x\n", from)
@@ -529,6 +544,23 @@ func (nd *LitNode) HeightEventHandler(HeightEventChan chan lnutil.HeightEvent) {
 				logging.Infof("Claimed timeout HTLC using TXID %x\n", tx)
 			}
 		}
+		txs, err = nd.GoToChainForHTLCs(event.CoinType, event.Height)
+		if err != nil {
+			logging.Errorf("Error while settling HTLCs on chain for coin %d at height %d : %s\n", event.CoinType, event.Height, err.Error())
+		} else {
+			for _, tx := range txs {
+				logging.Infof("Claimed HTLC using TXID %x\n", tx)
+			}
+		}
+		txs, err = nd.RefundContracts(event.CoinType)
+		if err != nil {
+			logging.Errorf("Error while refunding contracts for coin %d : %s\n", event.CoinType, err.Error())
+		} else {
+			for _, tx := range txs {
+				logging.Infof("Sent contract refund TXID %x\n", tx)
+			}
+		}
+		nd.retryWaitingPayments()
 	}
 }
 
@@ -563,7 +595,23 @@ func (nd *LitNode) HandleContractOPEvent(c *lnutil.DlcContract,
 				return err
 			}
 
-			// We need to claim this.
+			// We need to claim this, unless it's too little to be worth
+			// the fee
+			claimFee := wal.EstimateFee(consts.DlcSettleConfTarget) *
+				consts.DlcClaimTxSize
+			if value-claimFee < consts.DustCutoff {
+				logging.Infof("HandleContractOPEvent: not claiming contract"+
+					" %d settlement output, %d left after fees is below"+
+					" dust\n", c.Idx, value-claimFee)
+				c.Status = lnutil.ContractStatusClosed
+				err = nd.DlcManager.SaveContract(c)
+				if err != nil {
+					return err
+				}
+				nd.publishDlcSettled(c, opEvent.Tx.TxHash(), [32]byte{})
+				return nil
+			}
+
 			txClaim := wire.NewMsgTx()
 			txClaim.Version = 2
 
@@ -574,8 +622,8 @@ func (nd *LitNode) HandleContractOPEvent(c *lnutil.DlcContract,
 			if err != nil {
 				return err
 			}
-			txClaim.AddTxOut(wire.NewTxOut(value-500,
-				lnutil.DirectWPKHScriptFromPKH(addr))) // todo calc fee
+			txClaim.AddTxOut(wire.NewTxOut(value-claimFee,
+				lnutil.DirectWPKHScriptFromPKH(addr)))
 
 			var kg portxo.KeyGen
 			kg.Depth = 5
@@ -603,6 +651,7 @@ func (nd *LitNode) HandleContractOPEvent(c *lnutil.DlcContract,
 			if err != nil {
 				return err
 			}
+			nd.publishDlcSettled(c, opEvent.Tx.TxHash(), txClaim.TxHash())
 		}
 
 	}
@@ -8,11 +8,18 @@ import (
 	"github.com/mit-dci/lit/bech32"
 	"github.com/mit-dci/lit/consts"
 	"github.com/mit-dci/lit/crypto/fastsha256"
+	"github.com/mit-dci/lit/crypto/koblitz"
 	"github.com/mit-dci/lit/lnutil"
 	"github.com/mit-dci/lit/logging"
+	"github.com/mit-dci/lit/sphinx"
+	"github.com/mit-dci/lit/wire"
 )
 
-func (nd *LitNode) PayMultihop(dstLNAdr string, originCoinType uint32, destCoinType uint32, amount int64) (bool, error) {
+// PayMultihop pays amount to the node dstLNAdr over a multihop route.  It
+// asks the node for a payment hash first, unless it's a keysend payment: then
+// we pick the preimage and send it to the node in the onion, and it doesn't
+// have to hear from us first.
+func (nd *LitNode) PayMultihop(dstLNAdr string, originCoinType uint32, destCoinType uint32, amount int64, keysend bool) (bool, error) {
 	var targetAdr [20]byte
 	_, adr, err := bech32.Decode(dstLNAdr)
 	if err != nil {
@@ -30,32 +37,58 @@ func (nd *LitNode) PayMultihop(dstLNAdr string, originCoinType uint32, destCoinT
 		return false, fmt.Errorf("cannot send %d because it's less than minOutput + fee: %d", amount, consts.MinOutput+fee)
 	}
 
-	// Connect to the node
-	if _, err := nd.FindPeerIndexByAddress(dstLNAdr); err != nil {
-		err = nd.DialPeer(dstLNAdr)
-		if err != nil {
-			return false, fmt.Errorf("error connected to destination node for multihop: %s", err.Error())
+	copy(targetAdr[:], adr)
+
+	// Connect to the node.  A keysend payment only needs its key, which
+	// we may have from its link adverts.
+	_, keyErr := nd.nodeKey(targetAdr)
+	if !keysend || keyErr != nil {
+		if _, err := nd.FindPeerIndexByAddress(dstLNAdr); err != nil {
+			err = nd.DialPeer(dstLNAdr)
+			if err != nil {
+				return false, fmt.Errorf("error connected to destination node for multihop: %s", err.Error())
+			}
 		}
 	}
 
-	copy(targetAdr[:], adr)
 	logging.Infof("Finding route to %s", dstLNAdr)
-	path, err := nd.FindPath(targetAdr, destCoinType, originCoinType, amount)
+	routes, err := nd.findRoutes(targetAdr, destCoinType, originCoinType, amount, false)
 	if err != nil {
 		return false, err
 	}
-	logging.Debugf("Done route to %s", dstLNAdr)
+	logging.Debugf("Done route to %s, in %d parts", dstLNAdr, len(routes))
+
+	if keysend {
+		var preimage [16]byte
+		_, err = rand.Read(preimage[:])
+		if err != nil {
+			return false, fmt.Errorf("can't make keysend preimage: %s", err.Error())
+		}
+		hash := fastsha256.Sum256(preimage[:])
+
+		nd.MultihopMutex.Lock()
+		defer nd.MultihopMutex.Unlock()
+		used := make(map[*Qchan]bool)
+		for _, inFlight := range routesInFlight(routes) {
+			inFlight.PreImage = preimage
+			inFlight.Keysend = true
+			err = nd.offerMultihop(inFlight, hash, nil, used)
+			if err != nil {
+				return false, err
+			}
+			nd.InProgMultihop = append(nd.InProgMultihop, inFlight)
+		}
+		logging.Infof("Sent keysend payment %x to %s", hash, dstLNAdr)
+		return true, nil
+	}
 
 	idx, err := nd.FindPeerIndexByAddress(dstLNAdr)
 	if err != nil {
 		return false, err
 	}
 
-	inFlight := new(InFlightMultihop)
-	inFlight.Path = path
-	inFlight.Amt = amount
 	nd.MultihopMutex.Lock()
-	nd.InProgMultihop = append(nd.InProgMultihop, inFlight)
+	nd.InProgMultihop = append(nd.InProgMultihop, routesInFlight(routes)...)
 	nd.MultihopMutex.Unlock()
 
 	logging.Infof("Sending payment request to %s", dstLNAdr)
@@ -76,14 +109,17 @@ func (nd *LitNode) MultihopPaymentRequestHandler(msg lnutil.MultihopPaymentReque
 	copy(pkh[:], idHash[:20])
 	inFlight.Path = []lnutil.RouteHop{{pkh, msg.Cointype}}
 
-	rand.Read(inFlight.PreImage[:])
+	_, err := rand.Read(inFlight.PreImage[:])
+	if err != nil {
+		return fmt.Errorf("can't make payment preimage: %s", err.Error())
+	}
 	hash := fastsha256.Sum256(inFlight.PreImage[:])
 
 	inFlight.HHash = hash
 
 	nd.MultihopMutex.Lock()
 	nd.InProgMultihop = append(nd.InProgMultihop, inFlight)
-	err := nd.SaveMultihopPayment(inFlight)
+	err = nd.SaveMultihopPayment(inFlight)
 	if err != nil {
 		nd.MultihopMutex.Unlock()
 		return err
@@ -100,7 +136,11 @@ func (nd *LitNode) MultihopPaymentAckHandler(msg lnutil.MultihopPaymentAckMsg) e
 
 	nd.MultihopMutex.Lock()
 	defer nd.MultihopMutex.Unlock()
-	for idx, mh := range nd.InProgMultihop {
+
+	// the payment, or all the parts of it if it's split, which are waiting
+	// for this hash
+	var parts []*InFlightMultihop
+	for _, mh := range nd.InProgMultihop {
 		var nullHash [32]byte
 		if !mh.Succeeded && bytes.Equal(nullHash[:], mh.HHash[:]) {
 			targetNode := mh.Path[len(mh.Path)-1]
@@ -108,289 +148,637 @@ func (nd *LitNode) MultihopPaymentAckHandler(msg lnutil.MultihopPaymentAckMsg) e
 			if err != nil {
 				return fmt.Errorf("not connected to destination peer")
 			}
-			if msg.Peer() == targetIdx {
-				logging.Debugf("Found the right pending multihop. Sending setup msg to first hop\n")
-				// found the right one. Set this up
-				firstHop := mh.Path[1]
-				ourHop := mh.Path[0]
-				firstHopIdx, err := nd.FindPeerIndexByAddress(bech32.Encode("ln", firstHop.Node[:]))
-				if err != nil {
-					return fmt.Errorf("not connected to first hop in route")
+			if msg.Peer() != targetIdx {
+				continue
+			}
+			if len(parts) == 0 {
+				parts = append(parts, mh)
+				if mh.TotalAmt == 0 {
+					break
 				}
+			} else if mh.TotalAmt == parts[0].TotalAmt && mh.PartIdx != 0 {
+				parts = append(parts, mh)
+			}
+		}
+	}
 
-				nd.RemoteMtx.Lock()
-				var qc *Qchan
-				for _, ch := range nd.RemoteCons[firstHopIdx].QCs {
-					if ch.Coin() == ourHop.CoinType && ch.State.MyAmt-consts.MinOutput-ch.State.Fee >= mh.Amt && !ch.CloseData.Closed && !ch.State.Failed {
-						qc = ch
-						break
-					}
-				}
+	if len(parts) > 0 {
+		logging.Debugf("Found the right pending multihop. Sending setup msg to first hop\n")
+	}
+	used := make(map[*Qchan]bool)
+	for _, mh := range parts {
+		// found the right one. Set this up
+		err := nd.offerMultihop(mh, msg.HHash, nil, used)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-				if qc == nil {
-					nd.RemoteMtx.Unlock()
-					return fmt.Errorf("could not find suitable channel to route payment")
-				}
+// routesInFlight gives the multihop payments to send a payment over routes,
+// one for each part.  If there's more than one, each has the total the
+// parts get to the payee, so it knows when it's got them all.
+func routesInFlight(routes []payRoute) []*InFlightMultihop {
+	var total int64
+	for _, r := range routes {
+		total += r.deliver
+	}
 
-				nd.RemoteMtx.Unlock()
+	var parts []*InFlightMultihop
+	for i, r := range routes {
+		mh := new(InFlightMultihop)
+		mh.Path = r.path
+		mh.Amt = r.send
+		mh.PartIdx = uint32(i)
+		if len(routes) > 1 {
+			mh.TotalAmt = total
+		}
+		parts = append(parts, mh)
+	}
+	return parts
+}
 
-				nd.InProgMultihop[idx].HHash = msg.HHash
-				err = nd.SaveMultihopPayment(nd.InProgMultihop[idx])
-				if err != nil {
-					return err
-				}
+// routeTerms works out what each node along path sends on if we send amt:
+// amts[k] and cltvs[k] are the amount and locktime (in blocks from now) of
+// the HTLC the k'th node offers the next.  Each node on the way exchanges
+// what it gets at its advertised rate and keeps the fee from its link's
+// policy, and wants the HTLC it gets locked its policy's CLTV delta longer
+// than the one it sends on.  The last node wants DefaultLockTime blocks left
+// to claim on chain in.  There's 5 blocks of leeway at each hop in case
+// people's wallets are out of sync.  Hops won't lock funds up for more than
+// MaxRouteCLTV blocks, so routes which add up to more don't work.
+func (nd *LitNode) routeTerms(path []lnutil.RouteHop, amt int64) (
+	amts []int64, cltvs []uint32, err error) {
+
+	n := len(path)
+	if n < 2 {
+		return nil, nil, fmt.Errorf("route of %d hops", n-1)
+	}
 
-				// Calculate what initial locktime we need
-				wal, ok := nd.SubWallet[ourHop.CoinType]
-				if !ok {
-					return fmt.Errorf("not connected to wallet for cointype %d", ourHop.CoinType)
-				}
+	amts = make([]int64, n-1)
+	cltvs = make([]uint32, n-1)
+	policies := make([]lnutil.ChanPolicy, n-1)
 
-				height := wal.CurrentHeight()
+	amts[0] = amt
+	for k := 1; k < n-1; k++ {
+		policies[k], err = nd.linkPolicy(path[k].Node, path[k+1].Node, path[k].CoinType)
+		if err != nil {
+			return nil, nil, err
+		}
+		got, err := nd.exchangeAmt(path[k].Node, path[k-1].CoinType, path[k].CoinType, amts[k-1])
+		if err != nil {
+			return nil, nil, err
+		}
+		amts[k] = policies[k].AmtAfterFee(got)
+		if amts[k] <= 0 {
+			return nil, nil, fmt.Errorf("%d isn't enough to cover the fee of %s",
+				got, bech32.Encode("ln", path[k].Node[:]))
+		}
+	}
 
-				// Allow 5 blocks of leeway per hop in case people's wallets are out of sync
-				locktime := height + int32(len(mh.Path)*(consts.DefaultLockTime+5))
+	cltvs[n-2] = consts.DefaultLockTime + 5
+	for k := n - 2; k > 0; k-- {
+		cltvs[k-1] = cltvs[k] + policies[k].CLTVDelta + 5
+	}
+	if cltvs[0] > consts.MaxRouteCLTV {
+		return nil, nil, fmt.Errorf("route needs HTLCs locked for %d blocks, max %d",
+			cltvs[0], consts.MaxRouteCLTV)
+	}
+	return amts, cltvs, nil
+}
 
-				// This handler needs to return before OfferHTLC can work
-				go func() {
-					logging.Infof("offering HTLC with RHash: %x", msg.HHash)
-					err = nd.OfferHTLC(qc, uint32(mh.Amt), msg.HHash, uint32(locktime), [32]byte{})
-					if err != nil {
-						logging.Errorf("error offering HTLC: %s", err.Error())
-						return
-					}
+// nodeKey finds the identity pubkey of the node with ln address pkh, from
+// its link adverts or our connection to it.
+func (nd *LitNode) nodeKey(pkh [20]byte) (*koblitz.PublicKey, error) {
+	var nullPub [33]byte
+	nd.ChannelMapMtx.Lock()
+	for _, l := range nd.ChannelMap[pkh] {
+		if l.Link.APub != nullPub {
+			pub := l.Link.APub
+			nd.ChannelMapMtx.Unlock()
+			return koblitz.ParsePubKey(pub[:], koblitz.S256())
+		}
+	}
+	nd.ChannelMapMtx.Unlock()
 
-					// Set the dirty flag on each of the nodes' channels we used
-					nd.ChannelMapMtx.Lock()
-					for _, hop := range nd.InProgMultihop[idx].Path {
-						for i, channel := range nd.ChannelMap[hop.Node] {
-							if channel.Link.CoinType == hop.CoinType {
-								nd.ChannelMap[hop.Node][i].Dirty = true
-								break
-							}
-						}
-					}
-					nd.ChannelMapMtx.Unlock()
+	nd.RemoteMtx.Lock()
+	defer nd.RemoteMtx.Unlock()
+	for _, peer := range nd.RemoteCons {
+		if peer.Con == nil {
+			continue
+		}
+		pub := peer.Con.RemotePub()
+		idHash := fastsha256.Sum256(pub.SerializeCompressed())
+		if bytes.Equal(idHash[:20], pkh[:]) {
+			return pub, nil
+		}
+	}
+	return nil, fmt.Errorf("don't know the key of %s", bech32.Encode("ln", pkh[:]))
+}
 
-					var data [32]byte
-					outMsg := lnutil.NewMultihopPaymentSetupMsg(firstHopIdx, msg.HHash, mh.Path, data)
-					logging.Debugf("Sending multihoppaymentsetup to peer %d\n", firstHopIdx)
-					nd.tmpSendLitMsg(outMsg)
-				}()
+// exchangeAmt gives what amt of fromCoin is worth in toCoin, at the rates
+// the node with ln address pkh advertises for its links in toCoin.
+func (nd *LitNode) exchangeAmt(pkh [20]byte, fromCoin, toCoin uint32,
+	amt int64) (int64, error) {
 
-				break
+	if fromCoin == toCoin {
+		return amt, nil
+	}
+
+	var rates []lnutil.RateDesc
+	nd.ChannelMapMtx.Lock()
+	for _, link := range nd.ChannelMap[pkh] {
+		if link.Link.CoinType == toCoin {
+			rates = link.Link.Rates
+			break
+		}
+	}
+	nd.ChannelMapMtx.Unlock()
+
+	for _, rate := range rates {
+		if rate.CoinType == fromCoin && rate.Rate > 0 {
+			if rate.Reciprocal {
+				// prior hop coin type is worth less than this one
+				return amt / rate.Rate, nil
 			}
+			// prior hop coin type is worth more than this one
+			return amt * rate.Rate, nil
 		}
 	}
-	return nil
+
+	// it's not possible to exchange these two coin types
+	return 0, fmt.Errorf("can't exchange %d for %d via %s",
+		fromCoin, toCoin, bech32.Encode("ln", pkh[:]))
 }
 
-func (nd *LitNode) MultihopPaymentSetupHandler(msg lnutil.MultihopPaymentSetupMsg) error {
-	logging.Infof("Received multihop payment setup from peer %d, hash %x\n", msg.Peer(), msg.HHash)
+// buildOnion makes the onion for a payment along mh.Path, telling each hop
+// where to send it on, how much and with what locktime.  dstKey is the
+// destination's key if we know it some other way than our connections and
+// the channel map.  It keeps the shared secrets in mh, for error onions, and
+// gives how many blocks our HTLC to the first hop has to be locked for.
+func (nd *LitNode) buildOnion(mh *InFlightMultihop, hash [32]byte,
+	dstKey *koblitz.PublicKey) (*sphinx.OnionPacket, uint32, error) {
+
+	path := mh.Path
+	n := len(path)
+	if n < 2 || n-1 > sphinx.NumMaxHops {
+		return nil, 0, fmt.Errorf("route of %d hops, can do 1 to %d", n-1, sphinx.NumMaxHops)
+	}
 
-	inFlight := new(InFlightMultihop)
-	inFlight.Path = msg.NodeRoute
-	inFlight.HHash = msg.HHash
+	amts, cltvs, err := nd.routeTerms(path, mh.Amt)
+	if err != nil {
+		return nil, 0, err
+	}
 
-	// Forward
-	var pkh [20]byte
-	id := nd.IdKey().PubKey().SerializeCompressed()
-	idHash := fastsha256.Sum256(id[:])
-	copy(pkh[:], idHash[:20])
-	var nextHop, ourHop, incomingHop *lnutil.RouteHop
-	for i, node := range inFlight.Path {
-		if bytes.Equal(pkh[:], node.Node[:]) {
-			if i == 0 {
-				return fmt.Errorf("path is invalid")
+	keys := make([]*koblitz.PublicKey, n-1)
+	payloads := make([]sphinx.HopPayload, n-1)
+	for k := 1; k < n; k++ {
+		if k == n-1 && dstKey != nil {
+			keys[k-1] = dstKey
+		} else {
+			keys[k-1], err = nd.nodeKey(path[k].Node)
+			if err != nil {
+				return nil, 0, err
 			}
-			if i+1 < len(inFlight.Path) {
-				nextHop = &inFlight.Path[i+1]
+		}
+
+		p := &payloads[k-1]
+		p.CoinType = path[k].CoinType
+		if k < n-1 {
+			p.NextNode = path[k+1].Node
+			p.Amt = amts[k]
+			p.CLTV = cltvs[k]
+		} else {
+			p.Amt = amts[n-2]
+			p.CLTV = consts.DefaultLockTime
+			p.TotalAmt = mh.TotalAmt
+			if mh.Keysend {
+				p.Preimage = mh.PreImage
+			}
+		}
+	}
+
+	sessionKey, err := koblitz.NewPrivateKey(koblitz.S256())
+	if err != nil {
+		return nil, 0, err
+	}
+	pkt, secrets, err := sphinx.NewPacket(sessionKey, keys, payloads, hash[:])
+	if err != nil {
+		return nil, 0, err
+	}
+	mh.OnionSecrets = secrets
+	return pkt, cltvs[0], nil
+}
+
+// offerMultihop offers the HTLC for a multihop payment to the first hop in
+// its path, and sends the onion on along it.  dstKey is as for buildOnion.
+// It doesn't use the channels in used, and adds the one it uses, so the
+// parts of a payment go over different channels.  The caller holds
+// MultihopMutex.
+func (nd *LitNode) offerMultihop(mh *InFlightMultihop, hash [32]byte,
+	dstKey *koblitz.PublicKey, used map[*Qchan]bool) error {
+
+	firstHop := mh.Path[1]
+	ourHop := mh.Path[0]
+	qc, firstHopIdx, err := nd.firstHopChan(firstHop.Node, ourHop.CoinType, mh.Amt, used)
+	if err != nil {
+		return err
+	}
+	if used != nil {
+		used[qc] = true
+	}
+
+	pkt, cltv, err := nd.buildOnion(mh, hash, dstKey)
+	if err != nil {
+		return err
+	}
+	var onion [sphinx.PacketSize]byte
+	copy(onion[:], pkt.Bytes())
+
+	// Calculate what initial locktime we need
+	wal, ok := nd.SubWallet[ourHop.CoinType]
+	if !ok {
+		return fmt.Errorf("not connected to wallet for cointype %d", ourHop.CoinType)
+	}
+
+	locktime := uint32(wal.CurrentHeight()) + cltv
+
+	mh.HHash = hash
+	err = nd.startAttempt(mh)
+	if err != nil {
+		return err
+	}
+	// so a retry can wait for this HTLC to go
+	a := mh.attempt()
+	a.Chan = lnutil.OutPointToBytes(qc.Op)
+	a.Locktime = locktime
+	err = nd.SaveMultihopPayment(mh)
+	if err != nil {
+		return err
+	}
+
+	// This handler needs to return before OfferHTLC can work
+	go func() {
+		logging.Infof("offering HTLC with RHash: %x", hash)
+		err = nd.OfferHTLC(qc, uint32(mh.Amt), hash, locktime, [32]byte{})
+		if err != nil {
+			logging.Errorf("error offering HTLC: %s", err.Error())
+			nd.MultihopMutex.Lock()
+			nd.attemptFailed(mh, 0, err.Error())
+			nd.MultihopMutex.Unlock()
+			return
+		}
+
+		// Set the dirty flag on each of the nodes' channels we used
+		nd.ChannelMapMtx.Lock()
+		for _, hop := range mh.Path {
+			for i, channel := range nd.ChannelMap[hop.Node] {
+				if channel.Link.CoinType == hop.CoinType {
+					nd.ChannelMap[hop.Node][i].Dirty = true
+					break
+				}
 			}
-			ourHop = &inFlight.Path[i]
-			incomingHop = &inFlight.Path[i-1]
-			break
 		}
+		nd.ChannelMapMtx.Unlock()
+
+		var data [32]byte
+		outMsg := lnutil.NewMultihopPaymentSetupMsg(firstHopIdx, hash, onion, data)
+		logging.Debugf("Sending multihoppaymentsetup to peer %d\n", firstHopIdx)
+		nd.tmpSendLitMsg(outMsg)
+	}()
+
+	return nil
+}
+
+// failMultihop sends an error onion for a payment we can't take or forward
+// back to the peer it came from, and gives back the failure.
+func (nd *LitNode) failMultihop(peerIdx uint32, hash [32]byte, secret [32]byte,
+	failure error) error {
+
+	var reason [sphinx.ErrorPacketSize]byte
+	copy(reason[:], sphinx.NewErrorPacket(secret, failure.Error()))
+	nd.tmpSendLitMsg(lnutil.NewMultihopPaymentFailMsg(peerIdx, hash, reason))
+	return failure
+}
+
+func (nd *LitNode) MultihopPaymentSetupHandler(msg lnutil.MultihopPaymentSetupMsg) error {
+	logging.Infof("Received multihop payment setup from peer %d, hash %x\n", msg.Peer(), msg.HHash)
+
+	// Take our layer off the onion.  All we learn is where to send it next.
+	pkt, err := sphinx.PacketFromBytes(msg.Onion[:])
+	if err != nil {
+		return err
+	}
+	payload, nextPkt, secret, err := pkt.Peel(nd.IdKey(), msg.HHash[:])
+	if err != nil {
+		return fmt.Errorf("can't peel onion for RHash %x: %s", msg.HHash, err.Error())
+	}
+
+	fail := func(err error) error {
+		return nd.failMultihop(msg.Peer(), msg.HHash, secret, err)
 	}
 
 	// Check there is a corresponding incoming HTLC
 	HTLCs, chans, err := nd.FindHTLCsByHash(msg.HHash)
 	if err != nil {
-		return fmt.Errorf("error finding HTLCs: %s", err.Error())
+		return fail(fmt.Errorf("error finding HTLCs: %s", err.Error()))
 	}
 
-	var found bool
 	var prevHTLC *HTLC
+	var prevOp wire.OutPoint
+	var incomingCoin uint32
 	for idx, h := range HTLCs {
-		if h.Incoming && !h.Cleared && !h.Clearing && !h.ClearedOnChain && chans[idx].Coin() == incomingHop.CoinType {
-			found = true
-			prevHTLC = &h
+		// parts of a payment we're already holding have had their onions
+		if h.Incoming && !h.Cleared && !h.Clearing && !h.ClearedOnChain && chans[idx].Peer() == msg.Peer() && !nd.partHeld(msg.HHash, chans[idx].Op, h.Idx) {
+			prevHTLC = &HTLCs[idx]
+			prevOp = chans[idx].Op
+			incomingCoin = chans[idx].Coin()
 			break
 		}
 
 		// We already have an outgoing HTLC with this hash
 		if !h.Incoming && !h.Cleared && !h.ClearedOnChain {
-			return fmt.Errorf("we already have an uncleared offered HTLC with RHash: %x", msg.HHash)
+			return fail(fmt.Errorf("we already have an uncleared offered HTLC with RHash: %x", msg.HHash))
 		}
 	}
 
-	if !found {
-		return fmt.Errorf("no corresponding incoming HTLC found for multihop payment with RHash: %x", msg.HHash)
+	if prevHTLC == nil {
+		return fail(fmt.Errorf("no corresponding incoming HTLC found for multihop payment with RHash: %x", msg.HHash))
 	}
 
-	var nullBytes [16]byte
-	nd.MultihopMutex.Lock()
-	defer nd.MultihopMutex.Unlock()
-	for _, mh := range nd.InProgMultihop {
-		hash := fastsha256.Sum256(mh.PreImage[:])
-
-		if !bytes.Equal(mh.PreImage[:], nullBytes[:]) && bytes.Equal(msg.HHash[:], hash[:]) && mh.Path[len(mh.Path)-1].CoinType == incomingHop.CoinType {
-			// We already know this. If we have a Preimage, then we're the receiving
-			// end and we should send a settlement message to the
-			// predecessor
-			go func() {
-				_, err := nd.ClaimHTLC(mh.PreImage)
+	wal, ok := nd.SubWallet[incomingCoin]
+	if !ok {
+		return fail(fmt.Errorf("not connected to wallet for cointype %d", incomingCoin))
+	}
+	lockLeft := int64(prevHTLC.Locktime) - int64(wal.CurrentHeight())
+	if lockLeft > consts.MaxRouteCLTV {
+		return fail(fmt.Errorf("HTLC locked for %d blocks, max %d", lockLeft, consts.MaxRouteCLTV))
+	}
+
+	if nextPkt.IsLast() {
+		// We're the end of the route.  The HTLC has to be what the sender
+		// says they sent, so no hop can have kept any of it back.
+		if payload.CoinType != incomingCoin {
+			return fail(fmt.Errorf("paid in cointype %d, sender says %d", incomingCoin, payload.CoinType))
+		}
+		if prevHTLC.Amt < payload.Amt {
+			return fail(fmt.Errorf("paid %d, sender says %d", prevHTLC.Amt, payload.Amt))
+		}
+		if lockLeft < int64(payload.CLTV) {
+			return fail(fmt.Errorf("locktime of preceeding hop is too close for comfort: %d blocks left", lockLeft))
+		}
+
+		var nullBytes [16]byte
+		nd.MultihopMutex.Lock()
+		defer nd.MultihopMutex.Unlock()
+
+		// the sender picked the preimage, and we didn't ask for it
+		if payload.Preimage != nullBytes {
+			var prevPKH [20]byte
+			id, _ := nd.GetPubHostFromPeerIdx(msg.Peer())
+			idHash := fastsha256.Sum256(id[:])
+			copy(prevPKH[:], idHash[:20])
+			err = nd.takeKeysend(msg.HHash, payload.Preimage, prevPKH, incomingCoin)
+			if err != nil {
+				return fail(err)
+			}
+		}
+
+		for _, mh := range nd.InProgMultihop {
+			hash := fastsha256.Sum256(mh.PreImage[:])
+
+			// only payments to us: keysend payments we sent have their
+			// preimages too
+			if len(mh.Path) == 1 && !bytes.Equal(mh.PreImage[:], nullBytes[:]) && bytes.Equal(msg.HHash[:], hash[:]) && mh.Path[len(mh.Path)-1].CoinType == incomingCoin {
+				amt := prevHTLC.Amt
+				if payload.TotalAmt != 0 {
+					// it's a part of a multi-path payment.  Hold on to it
+					// until the rest come, then claim them all.
+					part := heldPart{prevOp, prevHTLC.Idx, msg.Peer(), secret}
+					parts, got, err := nd.holdPart(msg.HHash, payload.TotalAmt, amt, part)
+					if err != nil {
+						return fail(err)
+					}
+					if parts == nil {
+						return nil
+					}
+					err = nd.checkInvoicePayment(msg.HHash, got)
+					if err != nil {
+						return nd.failParts(msg.HHash, parts, err)
+					}
+				} else {
+					err = nd.checkInvoicePayment(msg.HHash, amt)
+					if err != nil {
+						return fail(err)
+					}
+				}
+
+				// We have the preimage, so we should send a settlement
+				// message to the predecessor.  It's ours now, and if they
+				// don't let us claim it we go to chain for it.
+				mh.State = PaymentSucceeded
+				err = nd.SaveMultihopPayment(mh)
 				if err != nil {
-					logging.Errorf("error claiming HTLC: %s", err.Error())
+					return err
 				}
-			}()
 
-			return nil
-		}
-	}
+				go func() {
+					_, err := nd.ClaimHTLC(mh.PreImage)
+					if err != nil {
+						logging.Errorf("error claiming HTLC: %s", err.Error())
+					}
+				}()
 
-	if nextHop == nil {
-		return fmt.Errorf("route is invalid")
+				return nil
+			}
+		}
+		return fail(fmt.Errorf("unknown payment hash %x", msg.HHash))
 	}
 
-	wal, ok := nd.SubWallet[incomingHop.CoinType]
+	// Forward
+	wal, ok = nd.SubWallet[payload.CoinType]
 	if !ok {
-		return fmt.Errorf("not connected to wallet for cointype %d", incomingHop.CoinType)
+		return fail(fmt.Errorf("not connected to wallet for cointype %d", payload.CoinType))
 	}
 
-	height := wal.CurrentHeight()
-	if prevHTLC.Locktime-consts.DefaultLockTime < uint32(height+consts.DefaultLockTime) {
-		return fmt.Errorf("locktime of preceeding hop is too close for comfort: %d, height: %d", prevHTLC.Locktime-consts.DefaultLockTime, height)
+	fee := wal.Fee() * 1000
+
+	newLocktime := uint32(wal.CurrentHeight()) + payload.CLTV
+
+	pkh := nd.myPKH()
+
+	// do we need to exchange?  Don't send on more than we got.
+	amtRqd, err := nd.exchangeAmt(pkh, incomingCoin, payload.CoinType, prevHTLC.Amt)
+	if err != nil {
+		return fail(err)
+	}
+	if payload.Amt > amtRqd {
+		return fail(fmt.Errorf("asked to send on %d, only got %d worth", payload.Amt, amtRqd))
 	}
 
-	wal, ok = nd.SubWallet[ourHop.CoinType]
-	if !ok {
-		return fmt.Errorf("not connected to wallet for cointype %d", ourHop.CoinType)
+	if payload.Amt < consts.MinOutput+fee {
+		// exchanging to this point has pushed the amount too low
+		return fail(fmt.Errorf("exchanging %d for %d via us pushes the amount too low: %d", incomingCoin, payload.CoinType, payload.Amt))
 	}
 
-	fee := wal.Fee() * 1000
+	// all we know of the route is who's before and after us
+	var prevPKH [20]byte
+	id, _ := nd.GetPubHostFromPeerIdx(msg.Peer())
+	idHash := fastsha256.Sum256(id[:])
+	copy(prevPKH[:], idHash[:20])
 
-	newLocktime := ((((prevHTLC.Locktime - uint32(height)) / consts.DefaultLockTime) - 1) * consts.DefaultLockTime) + uint32(wal.CurrentHeight())
+	inFlight := new(InFlightMultihop)
+	inFlight.Path = []lnutil.RouteHop{
+		{Node: prevPKH, CoinType: incomingCoin},
+		{Node: pkh, CoinType: payload.CoinType},
+		{Node: payload.NextNode, CoinType: payload.CoinType},
+	}
+	inFlight.Amt = payload.Amt
+	inFlight.HHash = msg.HHash
+	inFlight.OnionSecrets = [][32]byte{secret}
+	inFlight.FromPeer = msg.Peer()
 
+	nd.MultihopMutex.Lock()
 	nd.InProgMultihop = append(nd.InProgMultihop, inFlight)
-
 	err = nd.SaveMultihopPayment(inFlight)
+	nd.MultihopMutex.Unlock()
 	if err != nil {
-		return err
+		return fail(err)
 	}
 
-	lnAdr := bech32.Encode("ln", nextHop.Node[:])
+	lnAdr := bech32.Encode("ln", payload.NextNode[:])
 
 	// Connect to the node
 	if _, err := nd.FindPeerIndexByAddress(lnAdr); err != nil {
 		err = nd.DialPeer(lnAdr)
 		if err != nil {
-			return fmt.Errorf("error connecting to node for multihop: %s", err.Error())
+			return fail(fmt.Errorf("error connecting to node for multihop: %s", err.Error()))
 		}
 	}
 
 	sendToIdx, err := nd.FindPeerIndexByAddress(lnAdr)
 	if err != nil {
-		return fmt.Errorf("not connected to peer in route")
+		return fail(fmt.Errorf("not connected to peer in route"))
 	}
 
-	amtRqd := prevHTLC.Amt
-
-	// do we need to exchange?
-	// is the last hop coin type the same as this one?
-	if incomingHop.CoinType != ourHop.CoinType {
-		// we need to exchange, but is it possible?
-		var rd *lnutil.RateDesc
-		var rates []lnutil.RateDesc
-		nd.ChannelMapMtx.Lock()
-		defer nd.ChannelMapMtx.Unlock()
-		for _, link := range nd.ChannelMap[pkh] {
-			if link.Link.CoinType == ourHop.CoinType {
-				rates = link.Link.Rates
-				break
-			}
+	// find a channel whose policy the payment meets: it has to leave us our
+	// fee, and the HTLC we got has to be locked our CLTV delta longer than
+	// the one we send on
+	nd.RemoteMtx.Lock()
+	var qc *Qchan
+	failure := fmt.Errorf("could not find suitable channel to route payment")
+	for _, ch := range nd.RemoteCons[sendToIdx].QCs {
+		if ch.Coin() != payload.CoinType || ch.CloseData.Closed || ch.State.Failed {
+			continue
 		}
-
-		for _, rate := range rates {
-			if rate.CoinType == incomingHop.CoinType && rate.Rate > 0 {
-				rd = &rate
-				break
-			}
+		if ch.State.MyAmt-consts.MinOutput-fee < payload.Amt {
+			failure = fmt.Errorf("not enough capacity to send on %d", payload.Amt)
+			continue
 		}
-
-		// it's not possible to exchange these two coin types
-		if rd == nil {
-			return fmt.Errorf("can't exchange %d for %d via us", incomingHop.CoinType, ourHop.CoinType)
+		policy := nd.ChanPolicy(ch.Op)
+		err = policy.CheckAmt(payload.Amt)
+		if err != nil {
+			failure = err
+			continue
 		}
-
-		// required capacity is last hop amt * rate
-		if rd.Reciprocal {
-			// prior hop coin type is worth less than this one
-			amtRqd /= rd.Rate
-		} else {
-			// prior hop coin type is worth more than this one
-			amtRqd *= rd.Rate
+		if policy.AmtWithFee(payload.Amt) > amtRqd {
+			failure = fmt.Errorf("got %d worth, need %d to send on %d with fee",
+				amtRqd, policy.AmtWithFee(payload.Amt), payload.Amt)
+			continue
 		}
-	}
-
-	if amtRqd < consts.MinOutput+fee {
-		// exchanging to this point has pushed the amount too low
-		return fmt.Errorf("exchanging %d for %d via us pushes the amount too low: %d", incomingHop.CoinType, ourHop.CoinType, amtRqd)
-	}
-
-	nd.RemoteMtx.Lock()
-	var qc *Qchan
-	for _, ch := range nd.RemoteCons[sendToIdx].QCs {
-		if ch.Coin() == ourHop.CoinType && ch.State.MyAmt-consts.MinOutput-fee >= amtRqd && !ch.CloseData.Closed && !ch.State.Failed {
-			qc = ch
-			break
+		if lockLeft < int64(payload.CLTV)+int64(policy.CLTVDelta) {
+			failure = fmt.Errorf("locktime of preceeding hop is too close for comfort: %d blocks left, need %d",
+				lockLeft, int64(payload.CLTV)+int64(policy.CLTVDelta))
+			continue
 		}
+		qc = ch
+		break
 	}
 
 	if qc == nil {
 		nd.RemoteMtx.Unlock()
-		return fmt.Errorf("could not find suitable channel to route payment")
+		return fail(failure)
 	}
 
 	nd.RemoteMtx.Unlock()
 
+	var onion [sphinx.PacketSize]byte
+	copy(onion[:], nextPkt.Bytes())
+
 	// This handler needs to return so run this in a goroutine
 	go func() {
 		logging.Infof("offering HTLC with RHash: %x", msg.HHash)
-		err = nd.OfferHTLC(qc, uint32(amtRqd), msg.HHash, newLocktime, [32]byte{})
+		err = nd.OfferHTLC(qc, uint32(payload.Amt), msg.HHash, newLocktime, [32]byte{})
 		if err != nil {
 			logging.Errorf("error offering HTLC: %s", err.Error())
+			fail(err)
 			return
 		}
 
-		// Set the dirty flag on the nodes' channels in this route so we
-		// don't attempt to use them for routing before we get an link update
+		// Set the dirty flag on our channel so we don't attempt to use it
+		// for routing before we get an link update
 		nd.ChannelMapMtx.Lock()
-		for _, hop := range msg.NodeRoute {
-			if _, ok := nd.ChannelMap[hop.Node]; ok {
-				for idx, channel := range nd.ChannelMap[hop.Node] {
-					if channel.Link.CoinType == hop.CoinType {
-						nd.ChannelMap[hop.Node][idx].Dirty = true
-						break
-					}
-				}
+		for idx, channel := range nd.ChannelMap[pkh] {
+			if channel.Link.CoinType == payload.CoinType && channel.Link.BPKH == payload.NextNode {
+				nd.ChannelMap[pkh][idx].Dirty = true
+				break
 			}
 		}
 		nd.ChannelMapMtx.Unlock()
 
-		msg.PeerIdx = sendToIdx
-		msg.NodeRoute = msg.NodeRoute[1:]
-		nd.tmpSendLitMsg(msg)
+		nd.tmpSendLitMsg(lnutil.NewMultihopPaymentSetupMsg(sendToIdx, msg.HHash, onion, msg.Data))
 	}()
 
 	return nil
 }
+
+// MultihopPaymentFailHandler takes an error onion for a payment we sent or
+// forwarded.  If we forwarded it we add our layer and pass it back; if we
+// sent it we can read which hop it failed at, and why, and try another
+// route.
+func (nd *LitNode) MultihopPaymentFailHandler(msg lnutil.MultihopPaymentFailMsg) error {
+	logging.Infof("Received multihop payment failure from peer %d, hash %x\n", msg.Peer(), msg.HHash)
+
+	id, _ := nd.GetPubHostFromPeerIdx(msg.Peer())
+	idHash := fastsha256.Sum256(id[:])
+	pkh := nd.myPKH()
+
+	nd.MultihopMutex.Lock()
+	defer nd.MultihopMutex.Unlock()
+	for _, mh := range nd.InProgMultihop {
+		if mh.HHash != msg.HHash || mh.State != PaymentInFlight || len(mh.OnionSecrets) == 0 || len(mh.Path) < 2 {
+			continue
+		}
+
+		sent := mh.Path[0].Node == pkh
+		next := mh.Path[1]
+		if !sent {
+			if len(mh.Path) < 3 {
+				continue
+			}
+			next = mh.Path[2]
+		}
+		// only the hop we sent it on to can fail it
+		if !bytes.Equal(next.Node[:], idHash[:20]) {
+			continue
+		}
+
+		if !sent {
+			var reason [sphinx.ErrorPacketSize]byte
+			copy(reason[:], sphinx.WrapError(mh.OnionSecrets[0], msg.Reason[:]))
+			nd.tmpSendLitMsg(lnutil.NewMultihopPaymentFailMsg(mh.FromPeer, mh.HHash, reason))
+			return nil
+		}
+
+		// parts of a split payment can go to the same first hop, so it's
+		// the one whose secrets the error decrypts with
+		hop, failure, err := sphinx.DecryptError(mh.OnionSecrets, msg.Reason[:])
+		if err != nil {
+			continue
+		}
+		return nd.attemptFailed(mh, hop+1, failure)
+	}
+
+	return fmt.Errorf("no multihop payment %x sent on to peer %d", msg.HHash, msg.Peer())
+}
@@ -0,0 +1,375 @@
+package qln
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/mit-dci/lit/bech32"
+	"github.com/mit-dci/lit/consts"
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/logging"
+	"github.com/mit-dci/lit/wire"
+)
+
+// PaymentState is where a multihop payment is in its life.  Payments start
+// in flight and end up succeeded, failed or timed out.  One whose route
+// failed waits to retry until its HTLC is gone.  One which failed or timed
+// out can still turn out to have succeeded, if an HTLC of it we'd given up
+// on gets claimed.
+type PaymentState uint8
+
+const (
+	PaymentInFlight PaymentState = iota
+	PaymentSucceeded
+	PaymentFailed
+	PaymentTimedOut
+	PaymentRetryWaiting
+)
+
+func (s PaymentState) String() string {
+	switch s {
+	case PaymentInFlight:
+		return "in flight"
+	case PaymentSucceeded:
+		return "succeeded"
+	case PaymentFailed:
+		return "failed"
+	case PaymentTimedOut:
+		return "timed out"
+	case PaymentRetryWaiting:
+		return "waiting to retry"
+	}
+	return fmt.Sprintf("unknown state %d", uint8(s))
+}
+
+// PaymentAttempt is one route we tried a payment we sent over.
+type PaymentAttempt struct {
+	Path    []lnutil.RouteHop
+	Amt     int64  // what we sent
+	Deliver int64  // what was to get to the payee
+	Start   int64  // unix time we sent it
+	End     int64  // and when we heard how it went; 0 until we have
+	Failure string // why it failed, if it did
+
+	// The channel we offered its HTLC in, and the HTLC's locktime
+	Chan     [36]byte
+	Locktime uint32
+}
+
+// Bytes serializes a PaymentAttempt.
+func (a PaymentAttempt) Bytes() []byte {
+	var buf bytes.Buffer
+	wire.WriteVarInt(&buf, 0, uint64(len(a.Path)))
+	for _, hop := range a.Path {
+		buf.Write(hop.Bytes())
+	}
+	binary.Write(&buf, binary.BigEndian, a.Amt)
+	binary.Write(&buf, binary.BigEndian, a.Deliver)
+	binary.Write(&buf, binary.BigEndian, a.Start)
+	binary.Write(&buf, binary.BigEndian, a.End)
+	wire.WriteVarString(&buf, 0, a.Failure)
+	return buf.Bytes()
+}
+
+// paymentAttemptFromBuf reads a PaymentAttempt off the front of buf.
+func paymentAttemptFromBuf(buf *bytes.Buffer) (PaymentAttempt, error) {
+	var a PaymentAttempt
+	hops, err := wire.ReadVarInt(buf, 0)
+	if err != nil {
+		return a, err
+	}
+	if hops > uint64(buf.Len()/24) {
+		return a, fmt.Errorf("%d hops in %d bytes", hops, buf.Len())
+	}
+	for i := uint64(0); i < hops; i++ {
+		hop, err := lnutil.NewRouteHopFromBytes(buf.Next(24))
+		if err != nil {
+			return a, err
+		}
+		a.Path = append(a.Path, *hop)
+	}
+	for _, x := range []*int64{&a.Amt, &a.Deliver, &a.Start, &a.End} {
+		err = binary.Read(buf, binary.BigEndian, x)
+		if err != nil {
+			return a, err
+		}
+	}
+	a.Failure, err = wire.ReadVarString(buf, 0)
+	return a, err
+}
+
+// oldState works out the state of a payment saved before payments had one.
+func (p *InFlightMultihop) oldState() PaymentState {
+	if p.Succeeded {
+		return PaymentSucceeded
+	}
+	if p.Failure != "" {
+		return PaymentFailed
+	}
+	return PaymentInFlight
+}
+
+// attempt gives the route we're trying the payment over now, or nil if we
+// didn't send it.
+func (p *InFlightMultihop) attempt() *PaymentAttempt {
+	if len(p.Attempts) == 0 {
+		return nil
+	}
+	return &p.Attempts[len(p.Attempts)-1]
+}
+
+// succeed marks the payment paid, with the preimage R which paid it.
+func (p *InFlightMultihop) succeed(R [16]byte) {
+	p.Succeeded = true
+	p.PreImage = R
+	p.State = PaymentSucceeded
+	a := p.attempt()
+	if a != nil && a.End == 0 {
+		a.End = time.Now().Unix()
+	}
+}
+
+// startAttempt records that we're sending the payment over mh.Path, and
+// times it out if we don't hear how it went.  The caller holds
+// MultihopMutex.
+func (nd *LitNode) startAttempt(mh *InFlightMultihop) error {
+	amts, _, err := nd.routeTerms(mh.Path, mh.Amt)
+	if err != nil {
+		return err
+	}
+	mh.Attempts = append(mh.Attempts, PaymentAttempt{
+		Path:    append([]lnutil.RouteHop(nil), mh.Path...),
+		Amt:     mh.Amt,
+		Deliver: amts[len(amts)-1],
+		Start:   time.Now().Unix(),
+	})
+	mh.State = PaymentInFlight
+	nd.watchPayment(mh, len(mh.Attempts)-1)
+	return nil
+}
+
+// attemptFailed marks the route we're trying a payment we sent over failed
+// at the node mh.Path[at], and why.  We tell mission control the link from
+// that node on is failing, and try another route, unless it's the payee
+// which turned the payment down or we've tried MaxPaymentAttempts routes
+// already.  The caller holds MultihopMutex.
+func (nd *LitNode) attemptFailed(mh *InFlightMultihop, at int, reason string) error {
+	mh.Failure = fmt.Sprintf("failed at %s: %s",
+		bech32.Encode("ln", mh.Path[at].Node[:]), reason)
+	logging.Warnf("multihop payment %x %s", mh.HHash, mh.Failure)
+
+	a := mh.attempt()
+	if a != nil {
+		a.End = time.Now().Unix()
+		a.Failure = mh.Failure
+	}
+
+	if at == len(mh.Path)-1 {
+		// another route won't change the payee's mind
+		mh.State = PaymentFailed
+		return nd.SaveMultihopPayment(mh)
+	}
+
+	err := nd.failEdge(mh.Path[at].Node, mh.Path[at+1].Node, mh.Path[at].CoinType)
+	if err != nil {
+		logging.Errorf("can't save failing link: %s", err.Error())
+	}
+
+	if len(mh.Attempts) >= consts.MaxPaymentAttempts {
+		mh.State = PaymentFailed
+		return nd.SaveMultihopPayment(mh)
+	}
+
+	mh.State = PaymentRetryWaiting
+	err = nd.SaveMultihopPayment(mh)
+	if err != nil {
+		return err
+	}
+	go nd.retryPayment(mh)
+	return nil
+}
+
+// retryPayment sends a payment we sent whose last route failed over another
+// one, once the HTLC we offered for that route is gone.  Until then the hops
+// of the failed route can still claim theirs if the payee gives up the
+// preimage, so paying over another route could have us pay twice.  It goes
+// round the links mission control knows are failing, and the nodes the
+// payment's routes went through before: they can still have its HTLCs, and
+// would only fail it again.  It keeps getting the same amount to the payee.
+func (nd *LitNode) retryPayment(mh *InFlightMultihop) {
+	nd.MultihopMutex.Lock()
+	defer nd.MultihopMutex.Unlock()
+
+	if mh.State != PaymentRetryWaiting || len(mh.Path) < 2 {
+		return
+	}
+
+	live, err := nd.attemptHTLCLive(mh.attempt(), mh.HHash)
+	if err != nil {
+		logging.Errorf("can't check HTLC of payment %x: %s", mh.HHash, err.Error())
+		return
+	}
+	if live {
+		logging.Infof("multihop payment %x waits for its HTLC to time out"+
+			" before trying another route", mh.HHash)
+		return
+	}
+
+	exclude := retryExclusions(mh, nd.InProgMultihop, nd.myPKH())
+
+	deliver := mh.Amt
+	if a := mh.attempt(); a != nil {
+		deliver = a.Deliver
+	}
+	origin := mh.Path[0]
+	payee := mh.Path[len(mh.Path)-1]
+
+	giveUp := func(err error) {
+		mh.State = PaymentFailed
+		mh.Failure = fmt.Sprintf("no route to try after %d attempts: %s",
+			len(mh.Attempts), err.Error())
+		logging.Warnf("multihop payment %x %s", mh.HHash, mh.Failure)
+		err = nd.SaveMultihopPayment(mh)
+		if err != nil {
+			logging.Errorf("can't save payment %x: %s", mh.HHash, err.Error())
+		}
+	}
+
+	path, send, err := nd.findPathWithFees(payee.Node, payee.CoinType,
+		origin.CoinType, deliver, exclude)
+	if err != nil {
+		giveUp(err)
+		return
+	}
+
+	var dstKey *koblitz.PublicKey
+	var nullPub [33]byte
+	if mh.PayeeKey != nullPub {
+		dstKey, err = koblitz.ParsePubKey(mh.PayeeKey[:], koblitz.S256())
+		if err != nil {
+			giveUp(err)
+			return
+		}
+	}
+
+	logging.Infof("retrying multihop payment %x over %d hops", mh.HHash, len(path)-1)
+	prevPath, prevAmt := mh.Path, mh.Amt
+	mh.Path, mh.Amt = path, send
+	err = nd.offerMultihop(mh, mh.HHash, dstKey, nil)
+	if err != nil {
+		mh.Path, mh.Amt = prevPath, prevAmt
+		giveUp(err)
+	}
+}
+
+// attemptHTLCLive says whether the HTLC we offered for attempt a of the
+// payment at hash is still in its channel, not yet timed out or claimed.
+func (nd *LitNode) attemptHTLCLive(a *PaymentAttempt, hash [32]byte) (bool, error) {
+	var nullOp [36]byte
+	if a == nil || a.Chan == nullOp {
+		// we never offered one
+		return false, nil
+	}
+	qc, err := nd.GetQchan(a.Chan)
+	if err != nil {
+		return false, err
+	}
+	err = nd.ReloadQchanState(qc)
+	if err != nil {
+		return false, err
+	}
+	return htlcLive(qc.State.HTLCs, a, hash), nil
+}
+
+// htlcLive says whether htlcs has the one we offered for attempt a of the
+// payment at hash, not yet cleared.  Parts of a split payment can have the
+// same terms, so we can wait on another part's HTLC too, but never retry
+// while ours is there.
+func htlcLive(htlcs []HTLC, a *PaymentAttempt, hash [32]byte) bool {
+	for _, h := range htlcs {
+		if !h.Incoming && h.RHash == hash && h.Amt == a.Amt &&
+			h.Locktime == a.Locktime && !h.Cleared && !h.ClearedOnChain {
+			return true
+		}
+	}
+	return false
+}
+
+// retryWaitingPayments tries the payments waiting to retry again, which
+// goes ahead for those whose HTLCs have timed out since.  Called each block.
+func (nd *LitNode) retryWaitingPayments() {
+	nd.MultihopMutex.Lock()
+	defer nd.MultihopMutex.Unlock()
+	for _, mh := range nd.InProgMultihop {
+		if mh.State == PaymentRetryWaiting {
+			go nd.retryPayment(mh)
+		}
+	}
+}
+
+// retryExclusions gives the nodes a retry of payment mh goes round: the ones
+// its routes went through before, and the ones the other parts of it we're
+// sending (in payments, which are from the node me) are going through.
+func retryExclusions(mh *InFlightMultihop, payments []*InFlightMultihop,
+	me [20]byte) map[[20]byte]bool {
+
+	exclude := make(map[[20]byte]bool)
+	avoid := func(path []lnutil.RouteHop) {
+		if len(path) > 2 {
+			for _, hop := range path[1 : len(path)-1] {
+				exclude[hop.Node] = true
+			}
+		}
+	}
+	for _, a := range mh.Attempts {
+		avoid(a.Path)
+	}
+	for _, other := range payments {
+		if other != mh && other.HHash == mh.HHash && len(other.Path) > 0 &&
+			other.Path[0].Node == me {
+			avoid(other.Path)
+		}
+	}
+	return exclude
+}
+
+// watchPayment times out the attempt at a payment we sent which is
+// mh.Attempts[idx] if we haven't heard how it went PaymentTimeout seconds
+// after it started.  We don't try another route then, as this one may yet
+// go through.
+func (nd *LitNode) watchPayment(mh *InFlightMultihop, idx int) {
+	end := time.Unix(mh.Attempts[idx].Start+consts.PaymentTimeout, 0)
+	time.AfterFunc(time.Until(end), func() {
+		nd.MultihopMutex.Lock()
+		defer nd.MultihopMutex.Unlock()
+		if mh.State != PaymentInFlight || len(mh.Attempts) != idx+1 ||
+			mh.Attempts[idx].End != 0 {
+			return
+		}
+		mh.Attempts[idx].End = time.Now().Unix()
+		mh.Attempts[idx].Failure = "timed out"
+		mh.State = PaymentTimedOut
+		mh.Failure = fmt.Sprintf("heard nothing back in %d seconds", consts.PaymentTimeout)
+		logging.Warnf("multihop payment %x %s", mh.HHash, mh.Failure)
+		err := nd.SaveMultihopPayment(mh)
+		if err != nil {
+			logging.Errorf("can't save payment %x: %s", mh.HHash, err.Error())
+		}
+	})
+}
+
+// watchPayments times out the payments we sent which were in flight when we
+// last stopped, once they've had PaymentTimeout seconds.
+func (nd *LitNode) watchPayments() {
+	nd.MultihopMutex.Lock()
+	defer nd.MultihopMutex.Unlock()
+	for _, mh := range nd.InProgMultihop {
+		a := mh.attempt()
+		if mh.State == PaymentInFlight && a != nil && a.End == 0 {
+			nd.watchPayment(mh, len(mh.Attempts)-1)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package qln
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/mit-dci/lit/lnutil"
+)
+
+func TestPaymentAttemptBytes(t *testing.T) {
+	var hops [3]lnutil.RouteHop
+	for i := range hops {
+		hops[i].Node[0] = byte(i + 1)
+		hops[i].CoinType = 257
+	}
+	attempts := []PaymentAttempt{
+		{Path: hops[:], Amt: 10100, Deliver: 10000, Start: 1500000000,
+			End: 1500000005, Failure: "failed at ln1...: no capacity"},
+		{Path: hops[:2], Amt: 5000, Deliver: 5000, Start: 1500000010},
+	}
+
+	var buf bytes.Buffer
+	for _, a := range attempts {
+		buf.Write(a.Bytes())
+	}
+	for _, a := range attempts {
+		a2, err := paymentAttemptFromBuf(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(a, a2) {
+			t.Fatalf("from bytes mismatch:\n%v\n%v", a, a2)
+		}
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("%d bytes left over", buf.Len())
+	}
+
+	// cut short, or with more hops than bytes
+	b := attempts[0].Bytes()
+	_, err := paymentAttemptFromBuf(bytes.NewBuffer(b[:len(b)-10]))
+	if err == nil {
+		t.Fatalf("Should have errored, but didn't")
+	}
+	b[0] = 0xfc
+	_, err = paymentAttemptFromBuf(bytes.NewBuffer(b))
+	if err == nil {
+		t.Fatalf("Should have errored, but didn't")
+	}
+}
+
+func TestRetryExclusions(t *testing.T) {
+	node := func(b byte) lnutil.RouteHop {
+		var h lnutil.RouteHop
+		h.Node[0] = b
+		return h
+	}
+	me, payee := node(1), node(9)
+
+	var hash, otherHash [32]byte
+	hash[0], otherHash[0] = 0xaa, 0xbb
+
+	mh := &InFlightMultihop{HHash: hash}
+	mh.Path = []lnutil.RouteHop{me, node(2), node(3), payee}
+	mh.Attempts = []PaymentAttempt{
+		{Path: []lnutil.RouteHop{me, node(4), payee}},
+		{Path: mh.Path},
+	}
+	// another part of the same payment, one of another payment, and one
+	// of the same payment we're only forwarding
+	part := &InFlightMultihop{HHash: hash,
+		Path: []lnutil.RouteHop{me, node(5), payee}}
+	other := &InFlightMultihop{HHash: otherHash,
+		Path: []lnutil.RouteHop{me, node(6), payee}}
+	fwd := &InFlightMultihop{HHash: hash,
+		Path: []lnutil.RouteHop{node(7), node(8), payee}}
+	direct := &InFlightMultihop{HHash: hash,
+		Path: []lnutil.RouteHop{me, payee}}
+
+	exclude := retryExclusions(mh,
+		[]*InFlightMultihop{mh, part, other, fwd, direct}, me.Node)
+
+	for _, b := range []byte{2, 3, 4, 5} {
+		if !exclude[node(b).Node] {
+			t.Fatalf("node %d not excluded", b)
+		}
+	}
+	for _, b := range []byte{1, 6, 7, 8, 9} {
+		if exclude[node(b).Node] {
+			t.Fatalf("node %d excluded", b)
+		}
+	}
+}
+
+func TestPaymentHTLCs(t *testing.T) {
+	var hop lnutil.RouteHop
+	hop.CoinType = 257
+	mh := &InFlightMultihop{Path: []lnutil.RouteHop{hop, hop}, Amt: 10000,
+		State: PaymentRetryWaiting}
+	mh.HHash[0] = 0xaa
+	mh.Attempts = []PaymentAttempt{
+		{Path: mh.Path, Amt: 10000, Deliver: 10000, Start: 1500000000,
+			End: 1500000005, Failure: "no capacity", Locktime: 600},
+		{Path: mh.Path, Amt: 10000, Deliver: 10000, Start: 1500000010},
+	}
+	mh.Attempts[0].Chan[0] = 1
+
+	// where the HTLCs are comes back, and payments from before it was
+	// kept still load
+	mh2, err := InFlightMultihopFromBytes(mh.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(mh.Attempts, mh2.Attempts) || mh2.State != mh.State {
+		t.Fatalf("from bytes mismatch:\n%v\n%v", mh.Attempts, mh2.Attempts)
+	}
+	b := mh.Bytes()
+	mh2, err = InFlightMultihopFromBytes(b[:len(b)-80])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mh2.Attempts[0].Locktime != 0 || len(mh2.Attempts) != 2 {
+		t.Fatalf("old payment loaded with HTLC locktime %d",
+			mh2.Attempts[0].Locktime)
+	}
+	_, err = InFlightMultihopFromBytes(b[:len(b)-1])
+	if err == nil {
+		t.Fatalf("Should have errored, but didn't")
+	}
+
+	a := &mh.Attempts[0]
+	ours := HTLC{RHash: mh.HHash, Amt: a.Amt, Locktime: a.Locktime}
+	incoming := ours
+	incoming.Incoming = true
+	other := ours
+	other.Locktime++
+	if !htlcLive([]HTLC{incoming, other, ours}, a, mh.HHash) {
+		t.Fatalf("live HTLC not found")
+	}
+	ours.Cleared = true
+	if htlcLive([]HTLC{incoming, other, ours}, a, mh.HHash) {
+		t.Fatalf("cleared HTLC live")
+	}
+	ours.Cleared, ours.ClearedOnChain = false, true
+	if htlcLive([]HTLC{ours}, a, mh.HHash) {
+		t.Fatalf("HTLC cleared on chain live")
+	}
+}
@@ -0,0 +1,142 @@
+package qln
+
+import (
+	"fmt"
+
+	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/consts"
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/wire"
+)
+
+// defaultChanPolicy is the policy channels have before anything's set
+func defaultChanPolicy() lnutil.ChanPolicy {
+	return lnutil.ChanPolicy{
+		FeeBase:   consts.DefaultFeeBase,
+		FeeRate:   consts.DefaultFeeRate,
+		CLTVDelta: consts.DefaultCLTVDelta,
+	}
+}
+
+// checkChanPolicy checks a policy is one we can forward under, or route
+// over when a peer advertises it.
+func checkChanPolicy(p lnutil.ChanPolicy) error {
+	if p.FeeBase < 0 {
+		return fmt.Errorf("base fee %d is negative", p.FeeBase)
+	}
+	if p.FeeRate > consts.MaxFeeRate {
+		return fmt.Errorf("fee rate %d more than max %d", p.FeeRate, consts.MaxFeeRate)
+	}
+	if p.CLTVDelta < consts.MinCLTVDelta {
+		return fmt.Errorf("CLTV delta %d less than min %d", p.CLTVDelta, consts.MinCLTVDelta)
+	}
+	if p.MinHTLC < 0 || p.MaxHTLC < 0 {
+		return fmt.Errorf("min and max HTLC can't be negative")
+	}
+	if p.MaxHTLC != 0 && p.MaxHTLC < p.MinHTLC {
+		return fmt.Errorf("max HTLC %d less than min %d", p.MaxHTLC, p.MinHTLC)
+	}
+	return nil
+}
+
+// loadPolicies reads the forwarding policies from the db.
+func (nd *LitNode) loadPolicies() error {
+	nd.policyMtx.Lock()
+	defer nd.policyMtx.Unlock()
+
+	nd.policies = make(map[wire.OutPoint]lnutil.ChanPolicy)
+	nd.defaultPolicy = defaultChanPolicy()
+
+	return nd.LitDB.View(func(btx *bolt.Tx) error {
+		bkt := btx.Bucket(BKTPolicies)
+		if bkt == nil {
+			return fmt.Errorf("loadPolicies: no policies bucket")
+		}
+		return bkt.ForEach(func(k, v []byte) error {
+			p, err := lnutil.ChanPolicyFromBytes(v)
+			if err != nil {
+				return err
+			}
+			if string(k) == string(KEYDefaultPolicy) {
+				nd.defaultPolicy = p
+				return nil
+			}
+			if len(k) != 36 {
+				return fmt.Errorf("bad policy key %x", k)
+			}
+			var opArr [36]byte
+			copy(opArr[:], k)
+			nd.policies[*lnutil.OutPointFromBytes(opArr)] = p
+			return nil
+		})
+	})
+}
+
+// ChanPolicy gives the forwarding policy of the channel with funding
+// outpoint op: its own if it has one, or the default.
+func (nd *LitNode) ChanPolicy(op wire.OutPoint) lnutil.ChanPolicy {
+	nd.policyMtx.Lock()
+	defer nd.policyMtx.Unlock()
+	if p, ok := nd.policies[op]; ok {
+		return p
+	}
+	return nd.defaultPolicy
+}
+
+// DefaultChanPolicy gives the policy of channels without their own.
+func (nd *LitNode) DefaultChanPolicy() lnutil.ChanPolicy {
+	nd.policyMtx.Lock()
+	defer nd.policyMtx.Unlock()
+	return nd.defaultPolicy
+}
+
+// SetChanPolicy sets the forwarding policy of the channel with funding
+// outpoint op, or the default for all channels without their own if op is
+// nil.  It goes out in our next link adverts.
+func (nd *LitNode) SetChanPolicy(op *wire.OutPoint, p lnutil.ChanPolicy) error {
+	err := checkChanPolicy(p)
+	if err != nil {
+		return err
+	}
+
+	key := KEYDefaultPolicy
+	if op != nil {
+		opArr := lnutil.OutPointToBytes(*op)
+		key = opArr[:]
+	}
+
+	nd.policyMtx.Lock()
+	defer nd.policyMtx.Unlock()
+
+	err = nd.LitDB.Update(func(btx *bolt.Tx) error {
+		bkt := btx.Bucket(BKTPolicies)
+		if bkt == nil {
+			return fmt.Errorf("SetChanPolicy: no policies bucket")
+		}
+		return bkt.Put(key, p.Bytes())
+	})
+	if err != nil {
+		return err
+	}
+
+	if op == nil {
+		nd.defaultPolicy = p
+	} else {
+		nd.policies[*op] = p
+	}
+	return nil
+}
+
+// linkPolicy gives the policy the node with ln address from advertises for
+// its link to the node to in coinType.
+func (nd *LitNode) linkPolicy(from, to [20]byte, coinType uint32) (lnutil.ChanPolicy, error) {
+	nd.ChannelMapMtx.Lock()
+	defer nd.ChannelMapMtx.Unlock()
+	for _, l := range nd.ChannelMap[from] {
+		if l.Link.BPKH == to && l.Link.CoinType == coinType {
+			return l.Link.Policy, nil
+		}
+	}
+	return lnutil.ChanPolicy{}, fmt.Errorf("no link %s -> %s in cointype %d",
+		lnutil.White(from), lnutil.White(to), coinType)
+}
@@ -0,0 +1,350 @@
+package qln
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/mit-dci/lit/btcutil/psbt"
+	"github.com/mit-dci/lit/btcutil/txscript"
+	"github.com/mit-dci/lit/consts"
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/logging"
+	"github.com/mit-dci/lit/wire"
+)
+
+/*
+External funding: channels and contracts funded by a tx signed outside lit,
+such as by a hardware wallet, handed over as a PSBT.
+
+Channels take two steps.  FundChannelPsbt gets the points from the peer and
+gives the output the funding tx has to pay.  Once a tx paying it is signed,
+FinishFundChannelPsbt takes it and carries on with the channel as usual,
+broadcasting it when the peer has signed the first state.
+
+Contracts are offered or accepted with a PSBT whose inputs fund our side,
+and whose one output (if any) is the change.  When it's our turn to sign the
+funding tx, the contract goes to ContractStatusFundingPsbt and the tx is
+kept as a PSBT; ContractFundingPsbt gives it to be signed, and
+SignContractFunding takes it back and carries on.
+
+Funding txs are spent before they confirm, so their txids can't change:
+every input has to be segwit.
+*/
+
+// FundChannelPsbt starts opening a channel funded from outside lit.  Returns
+// the output the funding tx has to pay.
+func (nd *LitNode) FundChannelPsbt(peerIdx, cointype uint32, ccap, initSend int64,
+	data [32]byte) (*wire.TxOut, error) {
+
+	// throw out anything left from a start that timed out
+	select {
+	case <-nd.InProg.extTxo:
+	default:
+	}
+
+	err := nd.startFund(peerIdx, cointype, ccap, initSend,
+		consts.DefaultConfTarget, nil, true, data)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := time.NewTimer(time.Second * consts.ChannelTimeout)
+	select {
+	case txo := <-nd.InProg.extTxo:
+		timeout.Stop()
+		return txo, nil
+	case <-timeout.C:
+		nd.CancelFundChannelPsbt()
+		return nil, fmt.Errorf("no response from peer %d", peerIdx)
+	}
+}
+
+// FinishFundChannelPsbt takes the signed funding tx for the channel started
+// with FundChannelPsbt.  Like FundChannel, doesn't return until the channel
+// has been created.
+func (nd *LitNode) FinishFundChannelPsbt(p *psbt.Packet) (uint32, error) {
+	nd.InProg.mtx.Lock()
+
+	if !nd.InProg.External || nd.InProg.extResp == nil {
+		nd.InProg.mtx.Unlock()
+		return 0, fmt.Errorf("no channel waiting for a funding PSBT")
+	}
+	if nd.InProg.op != nil {
+		nd.InProg.mtx.Unlock()
+		return 0, fmt.Errorf("already have a funding tx for channel %d",
+			nd.InProg.ChanIdx)
+	}
+
+	tx, err := signedFundingTx(p)
+	if err != nil {
+		nd.InProg.mtx.Unlock()
+		return 0, err
+	}
+
+	q, txo, err := nd.fundingQchan(*nd.InProg.extResp)
+	if err != nil {
+		nd.InProg.mtx.Unlock()
+		return 0, err
+	}
+
+	var op *wire.OutPoint
+	for i, out := range tx.TxOut {
+		if out.Value == txo.Value && bytes.Equal(out.PkScript, txo.PkScript) {
+			if op != nil {
+				nd.InProg.mtx.Unlock()
+				return 0, fmt.Errorf("funding tx pays the channel twice")
+			}
+			txid := tx.TxHash()
+			op = wire.NewOutPoint(&txid, uint32(i))
+		}
+	}
+	if op == nil {
+		nd.InProg.mtx.Unlock()
+		return 0, fmt.Errorf("funding tx doesn't pay %d to the channel", txo.Value)
+	}
+
+	nd.InProg.op = op
+	nd.InProg.extTx = tx
+	err = nd.sendChanDesc(q, *nd.InProg.extResp)
+	if err != nil {
+		nd.InProg.Clear()
+		nd.InProg.mtx.Unlock()
+		return 0, err
+	}
+	nd.InProg.mtx.Unlock()
+
+	// wait until it's done!
+	idx := <-nd.InProg.done
+
+	return idx, nil
+}
+
+// CancelFundChannelPsbt gives up on a channel started with FundChannelPsbt,
+// if its funding tx hasn't been given yet.
+func (nd *LitNode) CancelFundChannelPsbt() error {
+	nd.InProg.mtx.Lock()
+	defer nd.InProg.mtx.Unlock()
+
+	if !nd.InProg.External {
+		return fmt.Errorf("no channel waiting for a funding PSBT")
+	}
+	if nd.InProg.op != nil {
+		return fmt.Errorf("channel %d already has its funding tx", nd.InProg.ChanIdx)
+	}
+	logging.Infof("Cancelling PSBT funding with peer %d\n", nd.InProg.PeerIdx)
+	nd.InProg.Clear()
+	return nil
+}
+
+// signedFundingTx finalizes a PSBT and gives the tx, which has to be fully
+// signed and segwit only.
+func signedFundingTx(p *psbt.Packet) (*wire.MsgTx, error) {
+	psbt.MaybeFinalizeAll(p)
+	tx, err := psbt.Extract(p)
+	if err != nil {
+		return nil, err
+	}
+	for _, txin := range tx.TxIn {
+		if len(txin.Witness) == 0 {
+			return nil, fmt.Errorf("funding input %s is not segwit",
+				txin.PreviousOutPoint.String())
+		}
+	}
+	return tx, nil
+}
+
+// fundContractPsbt takes our inputs and change for a contract from a PSBT,
+// and keeps the PSBT so our inputs can be signed with it later.
+func (nd *LitNode) fundContractPsbt(c *lnutil.DlcContract, p *psbt.Packet) error {
+	err := p.SanityCheck()
+	if err != nil {
+		return err
+	}
+	if len(p.Inputs) == 0 {
+		return fmt.Errorf("funding PSBT has no inputs")
+	}
+
+	var sum int64
+	c.OurFundingInputs = make([]lnutil.DlcContractFundingInput, len(p.Inputs))
+	for i, txin := range p.UnsignedTx.TxIn {
+		prevOut := p.PrevOut(i)
+		if prevOut == nil {
+			return fmt.Errorf("funding PSBT input %s has no utxo info",
+				txin.PreviousOutPoint.String())
+		}
+		pkScript := prevOut.PkScript
+		if txscript.IsPayToScriptHash(pkScript) {
+			pkScript = p.Inputs[i].RedeemScript
+		}
+		if !txscript.IsWitnessProgram(pkScript) {
+			return fmt.Errorf("funding PSBT input %s is not segwit",
+				txin.PreviousOutPoint.String())
+		}
+		c.OurFundingInputs[i] = lnutil.DlcContractFundingInput{
+			Outpoint: txin.PreviousOutPoint, Value: prevOut.Value}
+		sum += prevOut.Value
+	}
+	// the funding tx pays the change it works out for itself, which can't
+	// be less than what the PSBT's change output asks for
+	change, err := dlcFundingChange(sum, c.OurFundingAmount, c.FeePerByte)
+	if err != nil {
+		return err
+	}
+
+	// change goes to the PSBT's output, or the wallet if it has none
+	switch len(p.UnsignedTx.TxOut) {
+	case 0:
+		c.OurChangePKH, err = nd.SubWallet[c.CoinType].NewAdr()
+		if err != nil {
+			return err
+		}
+	case 1:
+		txo := p.UnsignedTx.TxOut[0]
+		if !txscript.IsPayToWitnessPubKeyHash(txo.PkScript) {
+			return fmt.Errorf("funding PSBT change output has to be P2WPKH")
+		}
+		if txo.Value > change {
+			return fmt.Errorf("funding PSBT change output is %d, inputs"+
+				" only leave %d after the contract and fees", txo.Value, change)
+		}
+		copy(c.OurChangePKH[:], txo.PkScript[2:])
+	default:
+		return fmt.Errorf("funding PSBT can have at most one (change) output")
+	}
+
+	b, err := p.Bytes()
+	if err != nil {
+		return err
+	}
+	return nd.DlcManager.SaveFundingPsbt(c.Idx, b)
+}
+
+// externalDlcFunding checks whether our side of a contract is signed
+// outside lit.  If it is, it keeps the funding tx as a PSBT to be signed,
+// with what we know about our inputs, and puts the contract in
+// ContractStatusFundingPsbt.
+func (nd *LitNode) externalDlcFunding(c *lnutil.DlcContract, tx *wire.MsgTx) (bool, error) {
+	b, err := nd.DlcManager.LoadFundingPsbt(c.Idx)
+	if err != nil || b == nil {
+		return false, err
+	}
+	ours, err := psbt.NewFromRawBytes(bytes.NewReader(b), false)
+	if err != nil {
+		return false, err
+	}
+
+	if tx.TxHash() != c.FundingOutpoint.Hash {
+		return false, fmt.Errorf("funding tx %s is not the one we signed for",
+			tx.TxHash().String())
+	}
+
+	p, err := psbt.NewFromPartialTx(tx)
+	if err != nil {
+		return false, err
+	}
+	for i, txin := range p.UnsignedTx.TxIn {
+		for j, ourIn := range ours.UnsignedTx.TxIn {
+			if txin.PreviousOutPoint == ourIn.PreviousOutPoint {
+				p.Inputs[i] = ours.Inputs[j]
+			}
+		}
+	}
+	for i, txo := range p.UnsignedTx.TxOut {
+		for j, ourOut := range ours.UnsignedTx.TxOut {
+			if bytes.Equal(txo.PkScript, ourOut.PkScript) {
+				p.Outputs[i] = ours.Outputs[j]
+			}
+		}
+	}
+
+	b, err = p.Bytes()
+	if err != nil {
+		return false, err
+	}
+	err = nd.DlcManager.SaveFundingPsbt(c.Idx, b)
+	if err != nil {
+		return false, err
+	}
+
+	c.Status = lnutil.ContractStatusFundingPsbt
+	err = nd.DlcManager.SaveContract(c)
+	if err != nil {
+		return false, err
+	}
+	logging.Infof("Contract %d funding tx waiting for PSBT signatures\n", c.Idx)
+	return true, nil
+}
+
+// ContractFundingPsbt gives the funding tx of a contract waiting for our
+// inputs to be signed outside lit.
+func (nd *LitNode) ContractFundingPsbt(cIdx uint64) (*psbt.Packet, error) {
+	c, err := nd.DlcManager.LoadContract(cIdx)
+	if err != nil {
+		return nil, err
+	}
+	if c.Status != lnutil.ContractStatusFundingPsbt {
+		return nil, fmt.Errorf("contract %d is not waiting for funding signatures", cIdx)
+	}
+	b, err := nd.DlcManager.LoadFundingPsbt(cIdx)
+	if err != nil {
+		return nil, err
+	}
+	if b == nil {
+		return nil, fmt.Errorf("contract %d has no funding PSBT", cIdx)
+	}
+	return psbt.NewFromRawBytes(bytes.NewReader(b), false)
+}
+
+// SignContractFunding takes the funding PSBT of a contract with our inputs
+// signed.  If the peer has signed already, the funding tx is broadcast;
+// otherwise our signatures go to the peer.
+func (nd *LitNode) SignContractFunding(cIdx uint64, signed *psbt.Packet) error {
+	c, err := nd.DlcManager.LoadContract(cIdx)
+	if err != nil {
+		return err
+	}
+	pending, err := nd.ContractFundingPsbt(cIdx)
+	if err != nil {
+		return err
+	}
+	if !nd.ConnectedToPeer(c.PeerIdx) {
+		return fmt.Errorf("You are not connected to peer %d, do that first", c.PeerIdx)
+	}
+
+	p, err := psbt.Combine(pending, signed)
+	if err != nil {
+		return err
+	}
+	psbt.MaybeFinalizeAll(p)
+
+	for _, u := range c.OurFundingInputs {
+		for i, txin := range p.UnsignedTx.TxIn {
+			if txin.PreviousOutPoint == u.Outpoint && !p.Inputs[i].IsFinalized() {
+				return fmt.Errorf("funding input %s is not signed", u.Outpoint.String())
+			}
+		}
+	}
+
+	tx, err := psbt.ExtractPartial(p)
+	if err != nil {
+		return err
+	}
+	err = nd.DlcManager.DeleteFundingPsbt(cIdx)
+	if err != nil {
+		return err
+	}
+
+	if p.IsComplete() {
+		// the peer's inputs were signed already, so ours were the last
+		return nd.publishDlcFunding(c, tx)
+	}
+
+	c.Status = lnutil.ContractStatusAcknowledged
+	err = nd.DlcManager.SaveContract(c)
+	if err != nil {
+		return err
+	}
+	nd.tmpSendLitMsg(lnutil.NewDlcContractFundingSigsMsg(c, tx))
+	return nil
+}
@@ -1013,9 +1013,10 @@ func (nd *LitNode) RevHandler(msg lnutil.RevMsg, qc *Qchan) error {
 			nd.MultihopMutex.Lock()
 			defer nd.MultihopMutex.Unlock()
 			for i, mu := range nd.InProgMultihop {
-				if bytes.Equal(mu.HHash[:], h.RHash[:]) && !mu.Succeeded {
-					nd.InProgMultihop[i].Succeeded = true
-					nd.InProgMultihop[i].PreImage = h.R
+				// cleared without the preimage is timed out, not paid
+				if bytes.Equal(mu.HHash[:], h.RHash[:]) && !mu.Succeeded &&
+					h.R != [16]byte{} {
+					nd.InProgMultihop[i].succeed(h.R)
 					err = nd.SaveMultihopPayment(nd.InProgMultihop[i])
 					if err != nil {
 						return err
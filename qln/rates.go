@@ -0,0 +1,467 @@
+package qln
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/logging"
+)
+
+// RateProvider is a source of the exchange rates we forward payments
+// between coins at.  Rates are by the coin we send on in, as they go out in
+// LinkMsg.Rates: each says what we take another coin at.  Run calls update
+// with the provider's rates when it starts, and again whenever they change.
+// It doesn't return.
+type RateProvider interface {
+	Name() string
+	Run(update func(map[uint32][]lnutil.RateDesc))
+}
+
+// FileRateProvider reads rates from a JSON file in the form of rates.json,
+// and again whenever the file changes.
+type FileRateProvider struct {
+	Path     string
+	Interval time.Duration // how often to look for changes
+}
+
+func (p *FileRateProvider) Name() string {
+	return "file"
+}
+
+func (p *FileRateProvider) Run(update func(map[uint32][]lnutil.RateDesc)) {
+	var modTime time.Time
+	for ; ; time.Sleep(p.Interval) {
+		info, err := os.Stat(p.Path)
+		if err != nil {
+			// it's been taken away, so its rates go too
+			if os.IsNotExist(err) && !modTime.IsZero() {
+				modTime = time.Time{}
+				update(nil)
+			}
+			continue
+		}
+		if info.ModTime().Equal(modTime) {
+			continue
+		}
+
+		rates := make(map[uint32][]lnutil.RateDesc)
+		b, err := ioutil.ReadFile(p.Path)
+		if err == nil {
+			err = json.Unmarshal(b, &rates)
+		}
+		if err != nil {
+			logging.Warnf("can't read exchange rates from %s: %s", p.Path, err.Error())
+			continue
+		}
+		modTime = info.ModTime()
+		logging.Infof("read exchange rates from %s", p.Path)
+		update(rates)
+	}
+}
+
+// HTTPRateProvider polls a JSON price feed: an object giving the price of
+// each coin, by coin type, in any one unit, like {"1": 1, "28": 0.0183}.
+// We take one coin for another at the ratio of their prices.
+type HTTPRateProvider struct {
+	URL      string
+	Interval time.Duration // how often to poll it
+}
+
+func (p *HTTPRateProvider) Name() string {
+	return "feed"
+}
+
+func (p *HTTPRateProvider) Run(update func(map[uint32][]lnutil.RateDesc)) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	var last map[uint32]float64
+	for ; ; time.Sleep(p.Interval) {
+		prices, err := p.fetch(client)
+		if err != nil {
+			logging.Warnf("can't get prices from %s: %s", p.URL, err.Error())
+			continue
+		}
+		if pricesEqual(prices, last) {
+			continue
+		}
+		last = prices
+		update(ratesFromPrices(prices))
+	}
+}
+
+func (p *HTTPRateProvider) fetch(client *http.Client) (map[uint32]float64, error) {
+	resp, err := client.Get(p.URL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("got %s", resp.Status)
+	}
+
+	var feed map[string]float64
+	err = json.NewDecoder(resp.Body).Decode(&feed)
+	if err != nil {
+		return nil, err
+	}
+	prices := make(map[uint32]float64)
+	for k, price := range feed {
+		coin, err := strconv.ParseUint(k, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%q isn't a coin type", k)
+		}
+		if price <= 0 || math.IsInf(price, 0) || math.IsNaN(price) {
+			return nil, fmt.Errorf("price %v of coin %d", price, coin)
+		}
+		prices[uint32(coin)] = price
+	}
+	return prices, nil
+}
+
+func pricesEqual(a, b map[uint32]float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for coin, price := range a {
+		if b[coin] != price {
+			return false
+		}
+	}
+	return true
+}
+
+// ratesFromPrices gives the rates between each pair of coins priced in
+// the same unit, each coin with itself included.
+func ratesFromPrices(prices map[uint32]float64) map[uint32][]lnutil.RateDesc {
+	rates := make(map[uint32][]lnutil.RateDesc)
+	for to, toPrice := range prices {
+		for from, fromPrice := range prices {
+			rd, ok := rateFromRatio(from, fromPrice/toPrice)
+			if ok {
+				rates[to] = append(rates[to], rd)
+			}
+		}
+	}
+	return rates
+}
+
+// rateSlack is how far off a whole number a ratio can be and still be taken
+// as it, so float error in prices doesn't cost a whole unit of rate.
+const rateSlack = 1e-6
+
+// rateFromRatio gives the rate for taking coin from at ratio of the coin we
+// send on for each of it.  Rates are whole numbers on the wire, so it's
+// rounded our way: we never give more than ratio.
+func rateFromRatio(from uint32, ratio float64) (lnutil.RateDesc, bool) {
+	if ratio <= 0 || math.IsInf(ratio, 0) || math.IsNaN(ratio) {
+		return lnutil.RateDesc{}, false
+	}
+	if ratio >= 1 {
+		return lnutil.RateDesc{CoinType: from,
+			Rate: int64(math.Floor(ratio + rateSlack))}, true
+	}
+	return lnutil.RateDesc{CoinType: from,
+		Rate: int64(math.Ceil(1/ratio - rateSlack)), Reciprocal: true}, true
+}
+
+// spreadRate gives rd with spread millionths taken off.  It's an error if
+// the rate comes out the same once it's rounded, as then the spread does
+// nothing.
+func spreadRate(rd lnutil.RateDesc, spread uint32) (lnutil.RateDesc, error) {
+	out, ok := rateFromRatio(rd.CoinType,
+		rateRatio(rd)*(1-float64(spread)/1000000))
+	if !ok {
+		return rd, fmt.Errorf("spread %d leaves no rate", spread)
+	}
+	if rateRatio(out) >= rateRatio(rd) {
+		return rd, fmt.Errorf("spread %d doesn't change rate %d (reciprocal %t) once rounded",
+			spread, rd.Rate, rd.Reciprocal)
+	}
+	return out, nil
+}
+
+// rateRatio gives how much of the coin we send on a rate gives for each of
+// the coin it takes.
+func rateRatio(rd lnutil.RateDesc) float64 {
+	if rd.Reciprocal {
+		return 1 / float64(rd.Rate)
+	}
+	return float64(rd.Rate)
+}
+
+// ratePair is the coin we send on in, and one we take for it.
+type ratePair struct {
+	CoinType uint32
+	From     uint32
+}
+
+func (p ratePair) key(kind byte) []byte {
+	var k [9]byte
+	k[0] = kind
+	binary.BigEndian.PutUint32(k[1:5], p.CoinType)
+	binary.BigEndian.PutUint32(k[5:], p.From)
+	return k[:]
+}
+
+// keys in BKTRates start with what they are
+const (
+	rateKeyManual = 'r'
+	rateKeySpread = 's'
+)
+
+// RateInfo is an exchange rate we advertise, where it came from, and the
+// spread taken off it.
+type RateInfo struct {
+	CoinType uint32 // coin we send on in
+	Rate     lnutil.RateDesc
+	Source   string // the provider, or "manual"
+	Spread   uint32 // millionths taken off the provider's rate
+}
+
+// AddRateProvider starts getting exchange rates from p.  Where providers
+// give rates for the same coins, the one added later wins; rates set with
+// SetExchangeRate win over them all.
+func (nd *LitNode) AddRateProvider(p RateProvider) {
+	nd.ratesMtx.Lock()
+	nd.rateOrder = append(nd.rateOrder, p.Name())
+	nd.ratesMtx.Unlock()
+
+	go p.Run(func(rates map[uint32][]lnutil.RateDesc) {
+		nd.ratesMtx.Lock()
+		nd.providerRates[p.Name()] = rates
+		nd.mergeRates()
+		nd.ratesMtx.Unlock()
+	})
+}
+
+// loadRateSettings reads the rates and spreads set by hand from the db.
+func (nd *LitNode) loadRateSettings() error {
+	nd.ratesMtx.Lock()
+	defer nd.ratesMtx.Unlock()
+
+	nd.providerRates = make(map[string]map[uint32][]lnutil.RateDesc)
+	nd.manualRates = make(map[ratePair]lnutil.RateDesc)
+	nd.rateSpreads = make(map[ratePair]uint32)
+	nd.ratesChanged = make(chan bool, 1)
+
+	err := nd.LitDB.View(func(btx *bolt.Tx) error {
+		bkt := btx.Bucket(BKTRates)
+		if bkt == nil {
+			return fmt.Errorf("loadRateSettings: no rates bucket")
+		}
+		return bkt.ForEach(func(k, v []byte) error {
+			if len(k) != 9 {
+				return fmt.Errorf("bad rate key %x", k)
+			}
+			pair := ratePair{binary.BigEndian.Uint32(k[1:5]),
+				binary.BigEndian.Uint32(k[5:])}
+			switch k[0] {
+			case rateKeyManual:
+				rd, err := lnutil.NewRateDescFromBytes(v)
+				if err != nil {
+					return err
+				}
+				nd.manualRates[pair] = rd
+			case rateKeySpread:
+				if len(v) != 4 {
+					return fmt.Errorf("bad spread %x", v)
+				}
+				nd.rateSpreads[pair] = binary.BigEndian.Uint32(v)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+	nd.mergeRates()
+	return nil
+}
+
+// mergeRates works out the rates we advertise from the providers', the ones
+// set by hand and the spreads, and advertises them if they've changed.  The
+// caller holds ratesMtx.
+func (nd *LitNode) mergeRates() {
+	infos := nd.rateInfos()
+	rates := make(map[uint32][]lnutil.RateDesc)
+	for _, ri := range infos {
+		rates[ri.CoinType] = append(rates[ri.CoinType], ri.Rate)
+	}
+
+	same := len(rates) == len(nd.ExchangeRates)
+	for coin, rds := range rates {
+		old := nd.ExchangeRates[coin]
+		if len(old) != len(rds) {
+			same = false
+			break
+		}
+		for i := range rds {
+			if rds[i] != old[i] {
+				same = false
+			}
+		}
+	}
+	if same {
+		return
+	}
+
+	nd.ExchangeRates = rates
+	select {
+	case nd.ratesChanged <- true:
+	default:
+	}
+}
+
+// rateInfos gives the rates we advertise, sorted by coin.  The caller holds
+// ratesMtx.
+func (nd *LitNode) rateInfos() []RateInfo {
+	rates := make(map[ratePair]RateInfo)
+	for _, name := range nd.rateOrder {
+		for coin, rds := range nd.providerRates[name] {
+			for _, rd := range rds {
+				rates[ratePair{coin, rd.CoinType}] = RateInfo{coin, rd, name, 0}
+			}
+		}
+	}
+
+	var infos []RateInfo
+	for pair, ri := range rates {
+		if _, ok := nd.manualRates[pair]; ok {
+			continue
+		}
+		if spread, ok := nd.rateSpreads[pair]; ok {
+			rd, err := spreadRate(ri.Rate, spread)
+			if err != nil {
+				logging.Warnf("rate for %d from %d: %s",
+					pair.CoinType, pair.From, err.Error())
+				continue
+			}
+			ri.Rate, ri.Spread = rd, spread
+		}
+		if ri.Rate.Rate > 0 {
+			infos = append(infos, ri)
+		}
+	}
+	for pair, rd := range nd.manualRates {
+		infos = append(infos, RateInfo{pair.CoinType, rd, "manual", 0})
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].CoinType != infos[j].CoinType {
+			return infos[i].CoinType < infos[j].CoinType
+		}
+		return infos[i].Rate.CoinType < infos[j].Rate.CoinType
+	})
+	return infos
+}
+
+// ExchangeRateInfo gives the exchange rates we advertise, with where they
+// came from.
+func (nd *LitNode) ExchangeRateInfo() []RateInfo {
+	nd.ratesMtx.Lock()
+	defer nd.ratesMtx.Unlock()
+	return nd.rateInfos()
+}
+
+// linkRates gives the rates we advertise for our links in coinType.
+func (nd *LitNode) linkRates(coinType uint32) []lnutil.RateDesc {
+	nd.ratesMtx.Lock()
+	defer nd.ratesMtx.Unlock()
+	return nd.ExchangeRates[coinType]
+}
+
+// SetExchangeRate sets the rate we take rd.CoinType at for sending on in
+// coinType, over what the providers say.  A rate of 0 goes back to theirs.
+// It's advertised straight away.
+func (nd *LitNode) SetExchangeRate(coinType uint32, rd lnutil.RateDesc) error {
+	if rd.Rate < 0 {
+		return fmt.Errorf("rate %d is negative", rd.Rate)
+	}
+	pair := ratePair{coinType, rd.CoinType}
+
+	nd.ratesMtx.Lock()
+	defer nd.ratesMtx.Unlock()
+
+	err := nd.LitDB.Update(func(btx *bolt.Tx) error {
+		bkt := btx.Bucket(BKTRates)
+		if bkt == nil {
+			return fmt.Errorf("SetExchangeRate: no rates bucket")
+		}
+		if rd.Rate == 0 {
+			return bkt.Delete(pair.key(rateKeyManual))
+		}
+		return bkt.Put(pair.key(rateKeyManual), rd.Bytes())
+	})
+	if err != nil {
+		return err
+	}
+
+	if rd.Rate == 0 {
+		delete(nd.manualRates, pair)
+	} else {
+		nd.manualRates[pair] = rd
+	}
+	nd.mergeRates()
+	return nil
+}
+
+// SetRateSpread sets the millionths we take off the providers' rate for
+// taking from for sending on in coinType: our margin for exchanging.  It
+// doesn't apply to rates set by hand.  A spread of 0 takes none off.
+func (nd *LitNode) SetRateSpread(coinType, from uint32, spread uint32) error {
+	if spread >= 1000000 {
+		return fmt.Errorf("spread %d is all of the rate or more", spread)
+	}
+	pair := ratePair{coinType, from}
+
+	nd.ratesMtx.Lock()
+	defer nd.ratesMtx.Unlock()
+
+	// check it does something to the rate the providers give now
+	if spread != 0 {
+		for _, name := range nd.rateOrder {
+			for _, rd := range nd.providerRates[name][coinType] {
+				if rd.CoinType != from {
+					continue
+				}
+				_, err := spreadRate(rd, spread)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	err := nd.LitDB.Update(func(btx *bolt.Tx) error {
+		bkt := btx.Bucket(BKTRates)
+		if bkt == nil {
+			return fmt.Errorf("SetRateSpread: no rates bucket")
+		}
+		if spread == 0 {
+			return bkt.Delete(pair.key(rateKeySpread))
+		}
+		var v [4]byte
+		binary.BigEndian.PutUint32(v[:], spread)
+		return bkt.Put(pair.key(rateKeySpread), v[:])
+	})
+	if err != nil {
+		return err
+	}
+
+	if spread == 0 {
+		delete(nd.rateSpreads, pair)
+	} else {
+		nd.rateSpreads[pair] = spread
+	}
+	nd.mergeRates()
+	return nil
+}
@@ -0,0 +1,104 @@
+package qln
+
+import (
+	"math"
+	"testing"
+
+	"github.com/mit-dci/lit/lnutil"
+)
+
+func TestRateFromRatio(t *testing.T) {
+	for _, c := range []struct {
+		ratio float64
+		rate  int64
+		recip bool
+	}{
+		{1, 1, false},
+		{2.7, 2, false},       // not 3: we'd give more than the ratio
+		{0.3 / 0.1, 3, false}, // 2.9999999999999996 is 3
+		{1 / 3.5, 4, true},    // giving 1/4, not 1/3
+		{0.1, 10, true},
+		{1 / 10.000000000000002, 10, true},
+	} {
+		rd, ok := rateFromRatio(7, c.ratio)
+		if !ok {
+			t.Fatalf("no rate for ratio %v", c.ratio)
+		}
+		if rd.CoinType != 7 || rd.Rate != c.rate || rd.Reciprocal != c.recip {
+			t.Fatalf("ratio %v gives rate %d reciprocal %t, expect %d %t",
+				c.ratio, rd.Rate, rd.Reciprocal, c.rate, c.recip)
+		}
+		if rateRatio(rd) > c.ratio*(1+rateSlack) {
+			t.Fatalf("ratio %v gives more, %v", c.ratio, rateRatio(rd))
+		}
+	}
+	for _, ratio := range []float64{0, -1, math.NaN(), math.Inf(1)} {
+		if _, ok := rateFromRatio(7, ratio); ok {
+			t.Fatalf("got a rate for ratio %v", ratio)
+		}
+	}
+}
+
+func TestRateSpread(t *testing.T) {
+	for _, c := range []struct {
+		rd     lnutil.RateDesc
+		spread uint32
+		rate   int64
+	}{
+		{lnutil.RateDesc{CoinType: 2, Rate: 1000}, 5000, 995},
+		{lnutil.RateDesc{CoinType: 2, Rate: 1000}, 1, 999},
+		{lnutil.RateDesc{CoinType: 2, Rate: 100, Reciprocal: true}, 10000, 102},
+	} {
+		rd, err := spreadRate(c.rd, c.spread)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rd.Rate != c.rate || rd.Reciprocal != c.rd.Reciprocal {
+			t.Fatalf("spread %d on %d gives %d, expect %d",
+				c.spread, c.rd.Rate, rd.Rate, c.rate)
+		}
+	}
+	if _, err := spreadRate(lnutil.RateDesc{CoinType: 2, Rate: 1000}, 0); err == nil {
+		t.Fatalf("Should have errored on a spread which does nothing, but didn't")
+	}
+
+	nd, cleanup := newTestNode(t)
+	defer cleanup()
+	err := nd.loadRateSettings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nd.rateOrder = []string{"test"}
+	nd.providerRates["test"] = map[uint32][]lnutil.RateDesc{
+		1: {{CoinType: 2, Rate: 1000}},
+	}
+
+	if nd.SetRateSpread(1, 2, 1000000) == nil {
+		t.Fatalf("Should have errored on a spread of all the rate, but didn't")
+	}
+	err = nd.SetRateSpread(1, 2, 5000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	infos := nd.ExchangeRateInfo()
+	if len(infos) != 1 || infos[0].Rate.Rate != 995 || infos[0].Spread != 5000 {
+		t.Fatalf("got rates %v, expect 995 with spread 5000", infos)
+	}
+
+	// it's kept, and rates set by hand don't get it
+	err = nd.loadRateSettings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nd.rateSpreads[ratePair{1, 2}] != 5000 {
+		t.Fatalf("spread not saved")
+	}
+	err = nd.SetExchangeRate(1, lnutil.RateDesc{CoinType: 2, Rate: 900})
+	if err != nil {
+		t.Fatal(err)
+	}
+	infos = nd.ExchangeRateInfo()
+	if len(infos) != 1 || infos[0].Rate.Rate != 900 || infos[0].Source != "manual" {
+		t.Fatalf("got rates %v, expect 900 set by hand", infos)
+	}
+}
@@ -3,11 +3,8 @@ package qln
 import (
 	"bytes"
 	"container/heap"
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"math"
-	"os"
 	"path/filepath"
 	"strconv"
 	"time"
@@ -19,32 +16,49 @@ import (
 	"github.com/mit-dci/lit/crypto/fastsha256"
 	"github.com/mit-dci/lit/lnutil"
 	"github.com/mit-dci/lit/logging"
+	"github.com/mit-dci/lit/uspv"
+	"github.com/mit-dci/lit/wire"
 )
 
 func (nd *LitNode) InitRouting() {
 	nd.ChannelMapMtx.Lock()
 	defer nd.ChannelMapMtx.Unlock()
 	nd.ChannelMap = make(map[[20]byte][]LinkDesc)
-	nd.ExchangeRates = make(map[uint32][]lnutil.RateDesc)
+	nd.linkChecks = make(map[wire.OutPoint]linkCheck)
+	nd.linkProofs = make(map[wire.OutPoint]lnutil.LinkProofMsg)
+	nd.graphPrune = DefaultGraphPruneRules()
 
-	err := nd.PopulateRates()
+	// route with the graph we had before, until adverts come
+	err := nd.loadGraph()
+	if err != nil {
+		logging.Warnf("failure loading channel graph: %s", err.Error())
+	}
+
+	err = nd.loadRateSettings()
 	if err != nil {
-		if os.IsNotExist(err) {
-			logging.Infof("Rates file not found.")
-		}
 		logging.Warnf("failure loading exchange rates: %s", err.Error())
 	}
+	nd.AddRateProvider(&FileRateProvider{
+		Path:     filepath.Join(nd.LitFolder, "rates.json"),
+		Interval: consts.RatesFileInterval * time.Second,
+	})
 
 	nd.AdvTimeout = time.NewTicker(15 * time.Second)
 
 	go func() {
-		seq := uint32(0)
+		// start from the time so adverts after a restart aren't taken as old
+		seq := uint32(time.Now().Unix())
 
+		// clean after waiting, so the prune rules set when we start
+		// apply to the graph we loaded.  New rates go out straight away.
 		for {
-			nd.cleanStaleChannels()
 			nd.advertiseLinks(seq)
 			seq++
-			<-nd.AdvTimeout.C
+			select {
+			case <-nd.AdvTimeout.C:
+				nd.cleanStaleChannels()
+			case <-nd.ratesChanged:
+			}
 		}
 	}()
 }
@@ -86,8 +100,22 @@ func (nd *LitNode) VisualiseGraph() string {
 	return "di" + graph.String()
 }
 
+// lockBlockCost is what FindPath weighs each block of CLTV delta a route asks
+// for as, against fees: a block locked up costs about as much as a fee of a
+// millionth of the payment.
+const lockBlockCost = 1e-6
+
 // FindPath uses Bellman-Ford and Dijkstra to find the path with the best price that has enough capacity to route the payment
 func (nd *LitNode) FindPath(targetPkh [20]byte, destCoinType uint32, originCoinType uint32, amount int64) ([]lnutil.RouteHop, error) {
+	return nd.findPath(targetPkh, destCoinType, originCoinType, amount, nil)
+}
+
+// findPath is FindPath, avoiding the nodes in exclude.  The parts of a
+// multi-path payment go through different nodes.
+func (nd *LitNode) findPath(targetPkh [20]byte, destCoinType uint32,
+	originCoinType uint32, amount int64,
+	exclude map[[20]byte]bool) ([]lnutil.RouteHop, error) {
+
 	var myIdPkh [20]byte
 	idHash := fastsha256.Sum256(nd.IdKey().PubKey().SerializeCompressed())
 	copy(myIdPkh[:], idHash[:20])
@@ -104,6 +132,8 @@ func (nd *LitNode) FindPath(targetPkh [20]byte, destCoinType uint32, originCoinT
 		V        routeHop
 		Rate     lnutil.RateDesc
 		Capacity int64
+		Policy   lnutil.ChanPolicy
+		Forward  bool // false when the edge is from us, so there's no fee
 	}
 
 	type channelEdgeLight struct {
@@ -112,6 +142,8 @@ func (nd *LitNode) FindPath(targetPkh [20]byte, destCoinType uint32, originCoinT
 		V        int
 		Rate     lnutil.RateDesc
 		Capacity int64
+		Policy   lnutil.ChanPolicy
+		Forward  bool
 	}
 
 	// set up initial graph
@@ -132,6 +164,29 @@ func (nd *LitNode) FindPath(targetPkh [20]byte, destCoinType uint32, originCoinT
 		logging.Debugf("processing channels from %s", bech32.Encode("ln", pkh[:]))
 
 		for _, channel := range channels {
+			if exclude[channel.Link.APKH] || exclude[channel.Link.BPKH] {
+				logging.Debugf("...ignoring channel because it's through an excluded node")
+				continue
+			}
+
+			// a node forwarding over this link takes its fee and wants the
+			// HTLC locked its CLTV delta longer.  We don't charge ourselves.
+			forward := channel.Link.APKH != myIdPkh
+			policy := channel.Link.Policy
+			var feeWeight float64
+			if forward {
+				if policy.FeeBase >= amount || policy.FeeRate >= 1000000 {
+					logging.Debugf("...ignoring channel because its fees are more than the payment")
+					continue
+				}
+				feeWeight = -math.Log(1-float64(policy.FeeRate)/1000000) -
+					math.Log(1-float64(policy.FeeBase)/float64(amount)) +
+					float64(policy.CLTVDelta)*lockBlockCost
+			}
+			// and go round links payments have failed over lately
+			feeWeight += nd.edgePenalty(channel.Link.APKH, channel.Link.BPKH,
+				channel.Link.CoinType)
+
 			logging.Debugf("...processing channel %s:%d", bech32.Encode("ln", channel.Link.BPKH[:]), channel.Link.CoinType)
 			var newEdges []channelEdge
 			origin := routeHop{
@@ -174,7 +229,7 @@ func (nd *LitNode) FindPath(targetPkh [20]byte, destCoinType uint32, originCoinT
 						price = float64(rd.Rate)
 					}
 
-					weight := -math.Log(price)
+					weight := -math.Log(price) + feeWeight
 
 					edge := channelEdge{
 						weight,
@@ -182,6 +237,8 @@ func (nd *LitNode) FindPath(targetPkh [20]byte, destCoinType uint32, originCoinT
 						vertex,
 						*rd,
 						channel.Link.ACapacity,
+						policy,
+						forward,
 					}
 
 					logging.Debugf(".........adding edge: %s:%d->%s:%d", bech32.Encode("ln", edge.U.Node[:]), edge.U.CoinType, bech32.Encode("ln", edge.V.Node[:]), edge.V.CoinType)
@@ -201,7 +258,7 @@ func (nd *LitNode) FindPath(targetPkh [20]byte, destCoinType uint32, originCoinT
 			verticesMap[vertex] = -1
 
 			edge := channelEdge{
-				0,
+				feeWeight,
 				origin,
 				vertex,
 				lnutil.RateDesc{
@@ -210,6 +267,8 @@ func (nd *LitNode) FindPath(targetPkh [20]byte, destCoinType uint32, originCoinT
 					false,
 				},
 				channel.Link.ACapacity,
+				policy,
+				forward,
 			}
 
 			logging.Debugf("...adding sink: %s:%d->%s:%d", bech32.Encode("ln", edge.U.Node[:]), edge.U.CoinType, bech32.Encode("ln", edge.V.Node[:]), edge.V.CoinType)
@@ -239,6 +298,8 @@ func (nd *LitNode) FindPath(targetPkh [20]byte, destCoinType uint32, originCoinT
 			verticesMap[edge.V],
 			edge.Rate,
 			edge.Capacity,
+			edge.Policy,
+			edge.Forward,
 		})
 		U := vertices[edgesLight[len(edgesLight)-1].U]
 		V := vertices[edgesLight[len(edgesLight)-1].V]
@@ -298,6 +359,8 @@ func (nd *LitNode) FindPath(targetPkh [20]byte, destCoinType uint32, originCoinT
 				idx,
 				lnutil.RateDesc{},
 				0,
+				lnutil.ChanPolicy{},
+				false,
 			})
 		}
 	}
@@ -378,6 +441,16 @@ func (nd *LitNode) FindPath(targetPkh [20]byte, destCoinType uint32, originCoinT
 
 			amtRqd := partialPath.Amt
 
+			if edge.Forward {
+				// what's left to send on after this hop's fee
+				amtRqd = edge.Policy.AmtAfterFee(amtRqd)
+				err := edge.Policy.CheckAmt(amtRqd)
+				if err != nil {
+					logging.Debugf("ignoring %x:%d->%x:%d: %s", vertices[edge.U].Node, vertices[edge.U].CoinType, vertices[edge.V].Node, vertices[edge.V].CoinType, err.Error())
+					continue
+				}
+			}
+
 			if amtRqd < consts.MinOutput+fee {
 				// this amount is too small to route
 				logging.Debugf("ignoring %x:%d->%x:%d because amount rqd: %d less than minOutput+fee: %d", vertices[edge.U].Node, vertices[edge.U].CoinType, vertices[edge.V].Node, vertices[edge.V].CoinType, amtRqd, consts.MinOutput+fee)
@@ -451,41 +524,73 @@ func (nd *LitNode) cleanStaleChannels() {
 	defer nd.ChannelMapMtx.Unlock()
 
 	newChannelMap := make(map[[20]byte][]LinkDesc)
+	var pruned []lnutil.LinkMsg
 
 	now := time.Now().Unix()
 
 	for pkh, node := range nd.ChannelMap {
 		for _, channel := range node {
-			if channel.Link.Timestamp+consts.ChannelAdvTimeout >= now {
+			if nd.graphPrune.pruned(channel.Link, now) {
+				pruned = append(pruned, channel.Link)
+			} else {
 				newChannelMap[pkh] = append(newChannelMap[pkh], channel)
 			}
 		}
 	}
 
 	nd.ChannelMap = newChannelMap
+
+	err := nd.deleteLinks(pruned)
+	if err != nil {
+		logging.Errorf("can't prune channel graph: %s", err.Error())
+	}
+
+	nd.linkChecksMtx.Lock()
+	for op, lc := range nd.linkChecks {
+		if time.Since(lc.at) > linkRecheckTime {
+			delete(nd.linkChecks, op)
+		}
+	}
+	nd.linkChecksMtx.Unlock()
 }
 
 func (nd *LitNode) advertiseLinks(seq uint32) {
 	caps := make(map[[20]byte]map[uint32]int64)
+	// the channel each capacity comes from, to prove the link with
+	chans := make(map[[20]byte]map[uint32]*Qchan)
+	pubs := make(map[[20]byte][33]byte)
+	// every open channel, which the peers need our proof sigs for
+	var open []*Qchan
+
+	var APub [33]byte
+	copy(APub[:], nd.IdKey().PubKey().SerializeCompressed())
+	APKH := lnutil.LitAdrPKH(APub)
 
 	nd.RemoteMtx.Lock()
 	for _, peer := range nd.RemoteCons {
 		for _, q := range peer.QCs {
+			if !q.CloseData.Closed && !q.State.Failed {
+				open = append(open, q)
+			}
 			if !q.CloseData.Closed && q.State.MyAmt >= 2*(consts.MinOutput+q.State.Fee) && !q.State.Failed {
-				outHash := fastsha256.Sum256(peer.Con.RemotePub().SerializeCompressed())
-				var BPKH [20]byte
-				copy(BPKH[:], outHash[:20])
+				var BPub [33]byte
+				copy(BPub[:], peer.Con.RemotePub().SerializeCompressed())
+				BPKH := lnutil.LitAdrPKH(BPub)
+				pubs[BPKH] = BPub
 
 				if _, ok := caps[BPKH]; !ok {
 					caps[BPKH] = make(map[uint32]int64)
+					chans[BPKH] = make(map[uint32]*Qchan)
 				}
 
 				amt := q.State.MyAmt - consts.MinOutput - q.State.Fee
 
-				// Since we don't yet perform multi-path routing, the capacity
-				// is the maximum available single channel capacity
+				// Each part of a payment has to fit in one channel, so the
+				// capacity is the maximum available single channel
+				// capacity.  Bigger payments get split over several routes.
 				if caps[BPKH][q.Coin()] < amt {
 					caps[BPKH][q.Coin()] = amt
+					chans[BPKH][q.Coin()] = q
 				}
 			}
 		}
@@ -493,11 +598,35 @@ func (nd *LitNode) advertiseLinks(seq uint32) {
 
 	nd.RemoteMtx.Unlock()
 
+	// give each peer our proof sigs, so it can advertise its side of our
+	// channels.  They're the same each time, but the peer may have
+	// restarted since we last sent them.
+	for _, q := range open {
+		nd.sendLinkProof(APub, q)
+	}
+
 	var msgs []lnutil.LinkMsg
 
-	outHash := fastsha256.Sum256(nd.IdKey().PubKey().SerializeCompressed())
-	var APKH [20]byte
-	copy(APKH[:], outHash[:20])
+	// our links we don't advertise any more are gone, however long ago we
+	// last did
+	var gone []lnutil.LinkMsg
+	var ours []LinkDesc
+	nd.ChannelMapMtx.Lock()
+	for _, l := range nd.ChannelMap[APKH] {
+		if q, ok := chans[l.Link.BPKH][l.Link.CoinType]; ok && q.Op == l.Link.FundingOp {
+			ours = append(ours, l)
+		} else {
+			gone = append(gone, l.Link)
+		}
+	}
+	if len(gone) > 0 {
+		nd.ChannelMap[APKH] = ours
+	}
+	nd.ChannelMapMtx.Unlock()
+	err := nd.deleteLinks(gone)
+	if err != nil {
+		logging.Errorf("can't prune channel graph: %s", err.Error())
+	}
 
 	for BPKH, node := range caps {
 		for coin, capacity := range node {
@@ -510,8 +639,42 @@ func (nd *LitNode) advertiseLinks(seq uint32) {
 
 			outmsg.ACapacity = capacity
 
-			if rates, ok := nd.ExchangeRates[coin]; ok {
-				outmsg.Rates = rates
+			outmsg.Rates = nd.linkRates(coin)
+
+			q := chans[BPKH][coin]
+			outmsg.APub = APub
+			outmsg.FundingOp = q.Op
+			outmsg.AChanPub = q.MyPub
+			outmsg.BChanPub = q.TheirPub
+			outmsg.BPub = pubs[BPKH]
+			outmsg.Policy = nd.ChanPolicy(q.Op)
+
+			nd.ChannelMapMtx.Lock()
+			proof, ok := nd.linkProofs[q.Op]
+			nd.ChannelMapMtx.Unlock()
+			if !ok {
+				logging.Debugf("no proof from %s for channel %s yet, not advertising it",
+					bech32.Encode("ln", BPKH[:]), q.Op.String())
+				continue
+			}
+			outmsg.BSig = proof.Sig
+			outmsg.BChanSig = proof.ChanSig
+
+			wal, ok := nd.SubWallet[coin]
+			if !ok {
+				continue
+			}
+			chanPriv, err := wal.GetPriv(q.KeyGen)
+			if err != nil {
+				logging.Errorf("can't sign link to %s: %s",
+					bech32.Encode("ln", BPKH[:]), err.Error())
+				continue
+			}
+			err = outmsg.Sign(nd.IdKey(), chanPriv)
+			if err != nil {
+				logging.Errorf("can't sign link to %s: %s",
+					bech32.Encode("ln", BPKH[:]), err.Error())
+				continue
 			}
 
 			outmsg.PeerIdx = math.MaxUint32
@@ -525,33 +688,132 @@ func (nd *LitNode) advertiseLinks(seq uint32) {
 	}
 }
 
+// sendLinkProof signs the proof of channel q with our identity key and our
+// key in its funding output, and sends it to the peer.
+func (nd *LitNode) sendLinkProof(myPub [33]byte, q *Qchan) {
+	wal, ok := nd.SubWallet[q.Coin()]
+	if !ok {
+		return
+	}
+	chanPriv, err := wal.GetPriv(q.KeyGen)
+	if err != nil {
+		logging.Errorf("can't sign proof of channel %s: %s", q.Op.String(), err.Error())
+		return
+	}
+	var theirPub [33]byte
+	nd.RemoteMtx.Lock()
+	peer, ok := nd.RemoteCons[q.Peer()]
+	if ok {
+		copy(theirPub[:], peer.Con.RemotePub().SerializeCompressed())
+	}
+	nd.RemoteMtx.Unlock()
+	if !ok {
+		return
+	}
+
+	proof := lnutil.LinkProofHash(q.Op, q.Coin(), myPub, q.MyPub, theirPub, q.TheirPub)
+	sig, chanSig, err := lnutil.SignLinkProof(proof, nd.IdKey(), chanPriv)
+	if err != nil {
+		logging.Errorf("can't sign proof of channel %s: %s", q.Op.String(), err.Error())
+		return
+	}
+	nd.tmpSendLitMsg(lnutil.NewLinkProofMsg(q.Peer(), q.Op, sig, chanSig))
+}
+
+// LinkProofHandler takes a peer's sigs on the proof of a channel we have with
+// it, and keeps them for our adverts of the channel.
+func (nd *LitNode) LinkProofHandler(msg lnutil.LinkProofMsg) error {
+	var q *Qchan
+	var theirPub [33]byte
+	nd.RemoteMtx.Lock()
+	peer, ok := nd.RemoteCons[msg.Peer()]
+	if ok {
+		copy(theirPub[:], peer.Con.RemotePub().SerializeCompressed())
+		for _, qc := range peer.QCs {
+			if qc.Op == msg.FundingOp {
+				q = qc
+			}
+		}
+	}
+	nd.RemoteMtx.Unlock()
+	if q == nil {
+		return fmt.Errorf("link proof from peer %d for unknown channel %s",
+			msg.Peer(), msg.FundingOp.String())
+	}
+
+	var myPub [33]byte
+	copy(myPub[:], nd.IdKey().PubKey().SerializeCompressed())
+	proof := lnutil.LinkProofHash(q.Op, q.Coin(), myPub, q.MyPub, theirPub, q.TheirPub)
+	err := lnutil.VerifyLinkProof(proof, theirPub, q.TheirPub, msg.Sig, msg.ChanSig)
+	if err != nil {
+		return fmt.Errorf("link proof from peer %d for channel %s: %s",
+			msg.Peer(), msg.FundingOp.String(), err.Error())
+	}
+
+	nd.ChannelMapMtx.Lock()
+	nd.linkProofs[q.Op] = msg
+	nd.ChannelMapMtx.Unlock()
+	return nil
+}
+
+// LinkMsgHandler takes in a link advert, ours or a peer's, and passes it on
+// if it's new.  Adverts which aren't signed by both ends, whose channel we
+// can't find on chain, or whose channel's already in the graph between other
+// nodes, are dropped.  A node has one link to each node in each coin, so an
+// advert replaces the last one for the same channel or the same B and coin.
 func (nd *LitNode) LinkMsgHandler(msg lnutil.LinkMsg) {
+	err := nd.checkLink(msg)
+	if err != nil {
+		logging.Debugf("dropping link %s -> %s: %s",
+			bech32.Encode("ln", msg.APKH[:]), bech32.Encode("ln", msg.BPKH[:]),
+			err.Error())
+		return
+	}
+
 	nd.ChannelMapMtx.Lock()
 	defer nd.ChannelMapMtx.Unlock()
 	nd.RemoteMtx.Lock()
 	defer nd.RemoteMtx.Unlock()
 
+	// a channel is between two nodes, whichever way it's advertised
+	for _, links := range nd.ChannelMap {
+		for _, v := range links {
+			if v.Link.FundingOp != msg.FundingOp {
+				continue
+			}
+			if !(v.Link.APKH == msg.APKH && v.Link.BPKH == msg.BPKH) &&
+				!(v.Link.APKH == msg.BPKH && v.Link.BPKH == msg.APKH) {
+				logging.Debugf("dropping link %s -> %s: channel %s already linked between other nodes",
+					bech32.Encode("ln", msg.APKH[:]), bech32.Encode("ln", msg.BPKH[:]),
+					msg.FundingOp.String())
+				return
+			}
+		}
+	}
+
 	msg.Timestamp = time.Now().Unix()
 	newChan := true
+	var replaced []lnutil.LinkMsg
+
+	// Check if link state is most recent (seq)
+	for i, v := range nd.ChannelMap[msg.APKH] {
+		if v.Link.FundingOp == msg.FundingOp ||
+			(v.Link.BPKH == msg.BPKH && v.Link.CoinType == msg.CoinType) {
+			// This is the link we've been looking for
+			if msg.Seq <= v.Link.Seq {
+				// Old advert
+				return
+			}
 
-	// Check if node exists as a router
-	if _, ok := nd.ChannelMap[msg.APKH]; ok {
-		// Check if link state is most recent (seq)
-		for i, v := range nd.ChannelMap[msg.APKH] {
-			if bytes.Equal(v.Link.BPKH[:], msg.BPKH[:]) && v.Link.CoinType == msg.CoinType {
-				// This is the link we've been looking for
-				if msg.Seq <= v.Link.Seq {
-					// Old advert
-					return
-				}
-
-				// Update channel map
-				nd.ChannelMap[msg.APKH][i].Link = msg
-				nd.ChannelMap[msg.APKH][i].Dirty = false
-
-				newChan = false
-				break
+			// Update channel map
+			if v.Link.FundingOp != msg.FundingOp {
+				replaced = append(replaced, v.Link)
 			}
+			nd.ChannelMap[msg.APKH][i].Link = msg
+			nd.ChannelMap[msg.APKH][i].Dirty = false
+
+			newChan = false
+			break
 		}
 	}
 
@@ -560,6 +822,15 @@ func (nd *LitNode) LinkMsgHandler(msg lnutil.LinkMsg) {
 		nd.ChannelMap[msg.APKH] = append(nd.ChannelMap[msg.APKH], LinkDesc{msg, false})
 	}
 
+	err = nd.deleteLinks(replaced)
+	if err != nil {
+		logging.Errorf("can't prune channel graph: %s", err.Error())
+	}
+	err = nd.saveLink(msg)
+	if err != nil {
+		logging.Errorf("can't save link in channel graph: %s", err.Error())
+	}
+
 	// Rebroadcast
 	origIdx := msg.PeerIdx
 
@@ -574,23 +845,108 @@ func (nd *LitNode) LinkMsgHandler(msg lnutil.LinkMsg) {
 	}
 }
 
-func (nd *LitNode) PopulateRates() error {
-	ratesPath := filepath.Join(nd.LitFolder, "rates.json")
+// linkCheck is a funding output of a link we've looked up on chain.  When
+// our chain hook can't look up utxos, pkScript is nil and value -1.
+type linkCheck struct {
+	pkScript []byte
+	value    int64
+	at       time.Time
+}
 
-	jsonFile, err := os.Open(ratesPath)
+// how long before we look up a link's funding output again, to see if it's
+// been spent
+const linkRecheckTime = 10 * time.Minute
+
+// checkLink checks a link advert is signed by both its nodes and by their
+// keys in the channel, and that the channel's funding output is there with
+// at least the advertised capacity.  Our own channels are checked against
+// our db.  Other nodes' channels are looked up on chain when the chain hook
+// can look up utxos (like powless).  SPV only nodes can't, so they take
+// those on the sigs alone: a made up channel needs both nodes in on it, and
+// payments routed over it just fail.
+func (nd *LitNode) checkLink(msg lnutil.LinkMsg) error {
+	err := checkChanPolicy(msg.Policy)
 	if err != nil {
 		return err
 	}
-	defer jsonFile.Close()
-
-	byteValue, err := ioutil.ReadAll(jsonFile)
+	err = msg.VerifySigs()
 	if err != nil {
 		return err
 	}
-	err = json.Unmarshal(byteValue, &nd.ExchangeRates)
+	pkScript, err := msg.FundPkScript()
 	if err != nil {
 		return err
 	}
 
+	var mine *Qchan
+	nd.RemoteMtx.Lock()
+	for _, peer := range nd.RemoteCons {
+		for _, q := range peer.QCs {
+			if q.Op == msg.FundingOp {
+				mine = q
+			}
+		}
+	}
+	nd.RemoteMtx.Unlock()
+
+	if mine != nil {
+		if mine.CloseData.Closed {
+			return fmt.Errorf("channel %s closed", msg.FundingOp.String())
+		}
+		if mine.Coin() != msg.CoinType {
+			return fmt.Errorf("channel %s is coin type %d, not %d",
+				msg.FundingOp.String(), mine.Coin(), msg.CoinType)
+		}
+		pre, _, err := lnutil.FundTxScript(mine.MyPub, mine.TheirPub)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(lnutil.P2WSHify(pre), pkScript) {
+			return fmt.Errorf("wrong keys for channel %s", msg.FundingOp.String())
+		}
+		if msg.ACapacity > mine.Value {
+			return fmt.Errorf("capacity %d more than channel %s of %d",
+				msg.ACapacity, msg.FundingOp.String(), mine.Value)
+		}
+		return nil
+	}
+
+	nd.linkChecksMtx.Lock()
+	lc, ok := nd.linkChecks[msg.FundingOp]
+	nd.linkChecksMtx.Unlock()
+
+	if !ok || time.Since(lc.at) > linkRecheckTime {
+		wal, ok := nd.SubWallet[msg.CoinType]
+		if !ok {
+			return fmt.Errorf("not connected to cointype %d", msg.CoinType)
+		}
+		txo, err := wal.GetUtxo(msg.FundingOp)
+		if err == uspv.ErrCantCheckUtxo {
+			lc = linkCheck{nil, -1, time.Now()}
+		} else if err != nil {
+			nd.linkChecksMtx.Lock()
+			delete(nd.linkChecks, msg.FundingOp)
+			nd.linkChecksMtx.Unlock()
+			return err
+		} else {
+			lc = linkCheck{txo.PkScript, txo.Value, time.Now()}
+		}
+
+		nd.linkChecksMtx.Lock()
+		nd.linkChecks[msg.FundingOp] = lc
+		nd.linkChecksMtx.Unlock()
+	}
+
+	if lc.pkScript == nil {
+		return nil
+	}
+	if !bytes.Equal(lc.pkScript, pkScript) {
+		return fmt.Errorf("funding output %s doesn't match link keys",
+			msg.FundingOp.String())
+	}
+	if msg.ACapacity > lc.value {
+		return fmt.Errorf("capacity %d more than funding output %s of %d",
+			msg.ACapacity, msg.FundingOp.String(), lc.value)
+	}
 	return nil
 }
@@ -4,7 +4,9 @@ import (
 	"encoding/json"
 	"sync"
 
+	"github.com/mit-dci/lit/btcutil/chaincfg/chainhash"
 	"github.com/mit-dci/lit/elkrem"
+	"github.com/mit-dci/lit/lncore"
 	"github.com/mit-dci/lit/portxo"
 
 	"github.com/getlantern/deepcopy"
@@ -20,9 +22,9 @@ TODO Does it make sense to move this comment somewhere else?  It's not really
 relevant here anymore.
 */
 
-// ChanData is a struct used as a surrogate for going between Qchan and the JSON
-// representation stored on disk.  There's an annoying layer in between that
-// make it all work out with hopefully little friction.
+// ChanData is the old JSON representation of a channel that used to be stored
+// directly in the ln.db channel bucket.  It's only kept around so that we can
+// migrate channels into the LitChannelStorage.
 type ChanData struct {
 	Txo       portxo.PorTxo `json:"txo"`
 	CloseData QCloseData    `json:"closedata"`
@@ -36,41 +38,73 @@ type ChanData struct {
 	LastUpdate uint64 `json:"updateunix"`
 }
 
-// NewQchanFromChanData creates a new qchan from a chandata.
-func (nd *LitNode) NewQchanFromChanData(data *ChanData) (*Qchan, error) {
+// NewChannelInfoFromChanData converts a legacy chandata into a channel info.
+func NewChannelInfoFromChanData(data *ChanData) lncore.ChannelInfo {
 
 	sc := new(StatCom)
 	deepcopy.Copy(sc, data.State)
 
+	q := &Qchan{
+		PorTxo:         data.Txo,
+		CloseData:      data.CloseData,
+		TheirPub:       data.TheirPub,
+		TheirRefundPub: data.TheirRefundPub,
+		TheirHAKDBase:  data.TheirHAKDBase,
+		State:          sc,
+		LastUpdate:     data.LastUpdate,
+	}
+
+	// Never had an elkrem receiver stored, so this can't fail.
+	info, _ := NewChannelInfoFromQchan(q)
+	return *info
+
+}
+
+// NewQchanFromChannelInfo creates a new qchan from a stored channel info.
+func (nd *LitNode) NewQchanFromChannelInfo(info *lncore.ChannelInfo) (*Qchan, error) {
+
+	elkRcv := elkrem.NewElkremReceiver()
+	if len(info.ElkRecv) != 0 {
+		var err error
+		elkRcv, err = elkrem.ElkremReceiverFromBytes(info.ElkRecv)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// I don't know if these errors are supposed to be ignored but that's what
 	// other code does so I'm just copying that.
-	mp, _ := nd.GetUsePub(data.Txo.KeyGen, UseChannelFund)
-	mrp, _ := nd.GetUsePub(data.Txo.KeyGen, UseChannelRefund)
-	mhb, _ := nd.GetUsePub(data.Txo.KeyGen, UseChannelHAKDBase)
-	elkroot, _ := nd.GetElkremRoot(data.Txo.KeyGen)
+	mp, _ := nd.GetUsePub(info.Txo.KeyGen, UseChannelFund)
+	mrp, _ := nd.GetUsePub(info.Txo.KeyGen, UseChannelRefund)
+	mhb, _ := nd.GetUsePub(info.Txo.KeyGen, UseChannelHAKDBase)
+	elkroot, _ := nd.GetElkremRoot(info.Txo.KeyGen)
 
 	qc := &Qchan{
-		PorTxo:    data.Txo,
-		CloseData: data.CloseData,
+		PorTxo: info.Txo,
+		CloseData: QCloseData{
+			CloseTxid:   chainhash.Hash(info.CloseData.CloseTxid),
+			CloseHeight: info.CloseData.CloseHeight,
+			Closed:      info.CloseData.Closed,
+		},
 
 		MyPub:          mp,
-		TheirPub:       data.TheirPub,
+		TheirPub:       info.TheirPub,
 		MyRefundPub:    mrp,
-		TheirRefundPub: data.TheirRefundPub,
+		TheirRefundPub: info.TheirRefundPub,
 		MyHAKDBase:     mhb,
-		TheirHAKDBase:  data.TheirHAKDBase,
+		TheirHAKDBase:  info.TheirHAKDBase,
 
 		ElkSnd: elkrem.NewElkremSender(elkroot),
-		ElkRcv: elkrem.NewElkremReceiver(),
+		ElkRcv: elkRcv,
 
 		Delay: 5, // This is defined to just be 5.
 
-		State: sc,
+		State: commitmentToStatCom(&info.Commitment),
 
 		ClearToSend: make(chan bool, 1),
 		ChanMtx:     sync.Mutex{},
 
-		LastUpdate: data.LastUpdate,
+		LastUpdate: info.LastUpdate,
 	}
 
 	// I think this might fix the problem?
@@ -82,31 +116,54 @@ func (nd *LitNode) NewQchanFromChanData(data *ChanData) (*Qchan, error) {
 
 }
 
-// NewChanDataFromQchan extracts the chandata from the qchan.
-func (nd *LitNode) NewChanDataFromQchan(qc *Qchan) *ChanData {
+// NewChannelInfoFromQchan extracts everything we store about a channel from the
+// qchan.  It doesn't know about the peer's address, the caller has to fill that
+// in if it wants it.
+func NewChannelInfoFromQchan(qc *Qchan) (*lncore.ChannelInfo, error) {
+
+	var elkBytes []byte
+	if qc.ElkRcv != nil {
+		var err error
+		elkBytes, err = qc.ElkRcv.ToBytes()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	info := &lncore.ChannelInfo{
+		CoinType:   int32(qc.Coin()),
+		State:      qchanStorageState(qc),
+		OpenHeight: qc.Height,
 
-	// We have to make copies of some thing because it's weird.
-	sc := new(StatCom)
-	deepcopy.Copy(sc, qc.State)
+		Txo: qc.PorTxo,
+		CloseData: lncore.ChannelCloseData{
+			CloseTxid:   qc.CloseData.CloseTxid,
+			CloseHeight: qc.CloseData.CloseHeight,
+			Closed:      qc.CloseData.Closed,
+		},
 
-	cd := &ChanData{
-		Txo:            qc.PorTxo,
-		CloseData:      qc.CloseData,
 		TheirPub:       qc.TheirPub,
 		TheirRefundPub: qc.TheirRefundPub,
 		TheirHAKDBase:  qc.TheirHAKDBase,
-		State:          sc,
-		LastUpdate:     qc.LastUpdate,
+
+		ElkRecv: elkBytes,
+
+		LastUpdate: qc.LastUpdate,
 	}
 
-	return cd
+	if qc.State != nil {
+		info.Commitment = statComToCommitment(qc.State)
+	}
+
+	return info, nil
 
 }
 
-// ApplyChanDataToQchan applies the chandata to the qchan without destroying it.
-func (nd *LitNode) ApplyChanDataToQchan(data *ChanData, qc *Qchan) error {
+// ApplyChannelInfoToQchan applies the channel info to the qchan without
+// destroying it.
+func (nd *LitNode) ApplyChannelInfoToQchan(info *lncore.ChannelInfo, qc *Qchan) error {
 
-	fake, err := nd.NewQchanFromChanData(data)
+	fake, err := nd.NewQchanFromChannelInfo(info)
 	if err != nil {
 		return err
 	}
@@ -131,43 +188,164 @@ func (nd *LitNode) ApplyChanDataToQchan(data *ChanData, qc *Qchan) error {
 
 }
 
-func (nd *LitNode) QchanSerializeToBytes(qc *Qchan) []byte {
-	cd := nd.NewChanDataFromQchan(qc)
-	data, _ := json.Marshal(cd)
-	return data
+// qchanStorageState figures out the coarse lncore state of the channel.
+func qchanStorageState(qc *Qchan) lncore.ChannelState {
+	switch {
+	case qc.State != nil && qc.State.Failed:
+		return lncore.CstateError
+	case qc.CloseData.Closed && qc.CloseData.CloseHeight > 0:
+		return lncore.CstateClosed
+	case qc.CloseData.Closed:
+		return lncore.CstateClosing
+	case qc.Height > 0:
+		return lncore.CstateOK
+	case qc.Op.Hash != chainhash.Hash{}:
+		return lncore.CstateUnconfirmed
+	default:
+		return lncore.CstateInit
+	}
 }
 
-func (nd *LitNode) QchanDeserializeFromBytes(buf []byte) (*Qchan, error) {
+func htlcToChannelHTLC(h *HTLC) lncore.ChannelHTLC {
+	return lncore.ChannelHTLC{
+		Idx:            h.Idx,
+		Incoming:       h.Incoming,
+		Amt:            h.Amt,
+		RHash:          h.RHash,
+		Locktime:       h.Locktime,
+		MyHTLCBase:     h.MyHTLCBase,
+		TheirHTLCBase:  h.TheirHTLCBase,
+		KeyGen:         h.KeyGen,
+		Sig:            h.Sig,
+		R:              h.R,
+		Clearing:       h.Clearing,
+		Cleared:        h.Cleared,
+		ClearedOnChain: h.ClearedOnChain,
+	}
+}
 
-	var cd ChanData
-	err := json.Unmarshal(buf, &cd)
-	if err != nil {
-		return nil, err
+func channelHTLCToHTLC(h *lncore.ChannelHTLC) HTLC {
+	return HTLC{
+		Idx:            h.Idx,
+		Incoming:       h.Incoming,
+		Amt:            h.Amt,
+		RHash:          h.RHash,
+		Locktime:       h.Locktime,
+		MyHTLCBase:     h.MyHTLCBase,
+		TheirHTLCBase:  h.TheirHTLCBase,
+		KeyGen:         h.KeyGen,
+		Sig:            h.Sig,
+		R:              h.R,
+		Clearing:       h.Clearing,
+		Cleared:        h.Cleared,
+		ClearedOnChain: h.ClearedOnChain,
 	}
+}
 
-	qc, err := nd.NewQchanFromChanData(&cd)
-	if err != nil {
-		return nil, err
+func statComToCommitment(sc *StatCom) lncore.ChannelStateCommitment {
+
+	com := lncore.ChannelStateCommitment{
+		StateIdx:               sc.StateIdx,
+		WatchUpTo:              sc.WatchUpTo,
+		MyAmt:                  sc.MyAmt,
+		Fee:                    sc.Fee,
+		JusticeFee:             sc.JusticeFee,
+		Data:                   sc.Data,
+		Delta:                  sc.Delta,
+		Collision:              sc.Collision,
+		ElkPoint:               sc.ElkPoint,
+		NextElkPoint:           sc.NextElkPoint,
+		N2ElkPoint:             sc.N2ElkPoint,
+		Sig:                    sc.Sig,
+		HTLCIdx:                sc.HTLCIdx,
+		CollidingHashDelta:     sc.CollidingHashDelta,
+		CollidingHashPreimage:  sc.CollidingHashPreimage,
+		CollidingPreimages:     sc.CollidingPreimages,
+		CollidingPreimageDelta: sc.CollidingPreimageDelta,
+		NextHTLCBase:           sc.NextHTLCBase,
+		N2HTLCBase:             sc.N2HTLCBase,
+		MyNextHTLCBase:         sc.MyNextHTLCBase,
+		MyN2HTLCBase:           sc.MyN2HTLCBase,
+		Failed:                 sc.Failed,
+		Recovered:              sc.Recovered,
 	}
 
-	return qc, nil
+	if sc.InProgHTLC != nil {
+		h := htlcToChannelHTLC(sc.InProgHTLC)
+		com.InProgHTLC = &h
+	}
+
+	if sc.CollidingHTLC != nil {
+		h := htlcToChannelHTLC(sc.CollidingHTLC)
+		com.CollidingHTLC = &h
+	}
+
+	if sc.HTLCs != nil {
+		com.HTLCs = make([]lncore.ChannelHTLC, len(sc.HTLCs))
+		for i := range sc.HTLCs {
+			com.HTLCs[i] = htlcToChannelHTLC(&sc.HTLCs[i])
+		}
+	}
+
+	return com
 
 }
 
-func (nd *LitNode) QchanUpdateFromBytes(qc *Qchan, buf []byte) error {
+func commitmentToStatCom(com *lncore.ChannelStateCommitment) *StatCom {
+
+	sc := &StatCom{
+		StateIdx:               com.StateIdx,
+		WatchUpTo:              com.WatchUpTo,
+		MyAmt:                  com.MyAmt,
+		Fee:                    com.Fee,
+		JusticeFee:             com.JusticeFee,
+		Data:                   com.Data,
+		Delta:                  com.Delta,
+		Collision:              com.Collision,
+		ElkPoint:               com.ElkPoint,
+		NextElkPoint:           com.NextElkPoint,
+		N2ElkPoint:             com.N2ElkPoint,
+		Sig:                    com.Sig,
+		HTLCIdx:                com.HTLCIdx,
+		CollidingHashDelta:     com.CollidingHashDelta,
+		CollidingHashPreimage:  com.CollidingHashPreimage,
+		CollidingPreimages:     com.CollidingPreimages,
+		CollidingPreimageDelta: com.CollidingPreimageDelta,
+		NextHTLCBase:           com.NextHTLCBase,
+		N2HTLCBase:             com.N2HTLCBase,
+		MyNextHTLCBase:         com.MyNextHTLCBase,
+		MyN2HTLCBase:           com.MyN2HTLCBase,
+		Failed:                 com.Failed,
+		Recovered:              com.Recovered,
+	}
 
-	var err error
-	var cd ChanData
-	err = json.Unmarshal(buf, &cd)
-	if err != nil {
-		return err
+	if com.InProgHTLC != nil {
+		h := channelHTLCToHTLC(com.InProgHTLC)
+		sc.InProgHTLC = &h
 	}
 
-	err = nd.ApplyChanDataToQchan(&cd, qc)
-	if err != nil {
-		return err
+	if com.CollidingHTLC != nil {
+		h := channelHTLCToHTLC(com.CollidingHTLC)
+		sc.CollidingHTLC = &h
 	}
 
-	return nil
+	if com.HTLCs != nil {
+		sc.HTLCs = make([]HTLC, len(com.HTLCs))
+		for i := range com.HTLCs {
+			sc.HTLCs[i] = channelHTLCToHTLC(&com.HTLCs[i])
+		}
+	}
+
+	return sc
 
 }
+
+// ChanDataFromBytes parses a legacy channel record from the old ln.db bucket.
+func ChanDataFromBytes(buf []byte) (*ChanData, error) {
+	var cd ChanData
+	err := json.Unmarshal(buf, &cd)
+	if err != nil {
+		return nil, err
+	}
+	return &cd, nil
+}
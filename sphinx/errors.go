@@ -0,0 +1,71 @@
+package sphinx
+
+import (
+	"crypto/hmac"
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	// MaxFailureLen limits the failure message in an error packet
+	MaxFailureLen = 256
+
+	// ErrorPacketSize is the size of an error packet: HMAC, message length,
+	// and the message padded to MaxFailureLen, so the length doesn't give
+	// away anything.
+	ErrorPacketSize = HMACSize + 2 + MaxFailureLen
+)
+
+// NewErrorPacket makes an error packet for a failure at our hop, to go back
+// to the sender.  secret is the one Peel gave us.
+func NewErrorPacket(secret [32]byte, failure string) []byte {
+	if len(failure) > MaxFailureLen {
+		failure = failure[:MaxFailureLen]
+	}
+
+	pkt := make([]byte, ErrorPacketSize)
+	msg := pkt[HMACSize:]
+	binary.BigEndian.PutUint16(msg[:2], uint16(len(failure)))
+	copy(msg[2:], failure)
+
+	umKey := generateKey("um", secret[:])
+	copy(pkt[:HMACSize], calcMac(umKey[:], msg))
+
+	return WrapError(secret, pkt)
+}
+
+// WrapError adds our layer of encryption to an error packet on its way back
+// from a hop after us.
+func WrapError(secret [32]byte, pkt []byte) []byte {
+	out := make([]byte, len(pkt))
+	copy(out, pkt)
+	ammagKey := generateKey("ammag", secret[:])
+	xor(out, generateCipherStream(ammagKey, len(out)))
+	return out
+}
+
+// DecryptError takes the layers off an error packet with the shared secrets
+// NewPacket gave us, and says which hop it came from and what it says.
+func DecryptError(secrets [][32]byte, pkt []byte) (int, string, error) {
+	if len(pkt) != ErrorPacketSize {
+		return 0, "", fmt.Errorf("error packet %d bytes, expect %d",
+			len(pkt), ErrorPacketSize)
+	}
+
+	for i, secret := range secrets {
+		pkt = WrapError(secret, pkt)
+
+		msg := pkt[HMACSize:]
+		umKey := generateKey("um", secret[:])
+		if !hmac.Equal(calcMac(umKey[:], msg), pkt[:HMACSize]) {
+			continue
+		}
+
+		n := int(binary.BigEndian.Uint16(msg[:2]))
+		if n > MaxFailureLen {
+			return i, "", fmt.Errorf("bad failure length %d", n)
+		}
+		return i, string(msg[2 : 2+n]), nil
+	}
+	return 0, "", fmt.Errorf("error packet from no hop in the route")
+}
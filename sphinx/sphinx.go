@@ -0,0 +1,312 @@
+// Package sphinx makes and peels onion packets for source routed multihop
+// payments, after the Sphinx mix format (Danezis & Goldberg) as used in
+// BOLT 4.
+//
+// The sender wraps a payload for each hop in a layer encrypted to that hop's
+// identity key.  A hop can only read its own payload, which says where to
+// send the payment next, and learns nothing else about the route: not how
+// long it is, nor where it is in it.  Every hop sees a packet of the same
+// size, and a different ephemeral key, so packets can't be linked across
+// hops.
+package sphinx
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"golang.org/x/crypto/chacha20"
+)
+
+const (
+	// NumMaxHops is the longest route an onion can carry
+	NumMaxHops = 20
+
+	// HopPayloadSize is the size of a serialized hop payload, padded
+	HopPayloadSize = 64
+
+	// HMACSize is the size of the HMACs over each layer
+	HMACSize = 32
+
+	// hopDataSize is the room each hop takes in the routing info
+	hopDataSize = HopPayloadSize + HMACSize
+
+	routingInfoSize = NumMaxHops * hopDataSize
+
+	// PacketSize is the size of a serialized onion packet
+	PacketSize = 1 + 33 + routingInfoSize + HMACSize
+
+	packetVersion = 0
+)
+
+// HopPayload is what a hop reads from its layer of the onion: where to send
+// the payment on to, and how much with what locktime.  At the last hop
+// NextNode is zero and the rest is what it should be getting.
+type HopPayload struct {
+	NextNode [20]byte // ln address (pkh) of the next hop
+	CoinType uint32   // coin to send on in
+	Amt      int64    // amount to send on
+	CLTV     uint32   // blocks the HTLC sent on has to be locked for
+
+	// At the last hop of a payment split over several routes, the total
+	// of all the parts.  Zero when the payment isn't split.
+	TotalAmt int64
+
+	// At the last hop of a spontaneous payment, the preimage the sender
+	// picked, so the payee can claim it without having asked for it.  Zero
+	// otherwise.
+	Preimage [16]byte
+}
+
+// Bytes serializes a hop payload, padded to HopPayloadSize.
+func (p *HopPayload) Bytes() []byte {
+	var b [HopPayloadSize]byte
+	copy(b[:20], p.NextNode[:])
+	binary.BigEndian.PutUint32(b[20:24], p.CoinType)
+	binary.BigEndian.PutUint64(b[24:32], uint64(p.Amt))
+	binary.BigEndian.PutUint32(b[32:36], p.CLTV)
+	binary.BigEndian.PutUint64(b[36:44], uint64(p.TotalAmt))
+	copy(b[44:60], p.Preimage[:])
+	return b[:]
+}
+
+// HopPayloadFromBytes parses a hop payload.
+func HopPayloadFromBytes(b []byte) (*HopPayload, error) {
+	if len(b) < HopPayloadSize {
+		return nil, fmt.Errorf("hop payload %d bytes, expect %d", len(b), HopPayloadSize)
+	}
+	p := new(HopPayload)
+	copy(p.NextNode[:], b[:20])
+	p.CoinType = binary.BigEndian.Uint32(b[20:24])
+	p.Amt = int64(binary.BigEndian.Uint64(b[24:32]))
+	p.CLTV = binary.BigEndian.Uint32(b[32:36])
+	p.TotalAmt = int64(binary.BigEndian.Uint64(b[36:44]))
+	copy(p.Preimage[:], b[44:60])
+	return p, nil
+}
+
+// OnionPacket is the onion as it's passed from hop to hop.
+type OnionPacket struct {
+	Version      byte
+	EphemeralKey [33]byte // blinded anew at each hop
+	RoutingInfo  [routingInfoSize]byte
+	HMAC         [HMACSize]byte // zero when this hop is the last
+}
+
+// Bytes serializes an onion packet.
+func (p *OnionPacket) Bytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(p.Version)
+	buf.Write(p.EphemeralKey[:])
+	buf.Write(p.RoutingInfo[:])
+	buf.Write(p.HMAC[:])
+	return buf.Bytes()
+}
+
+// PacketFromBytes parses an onion packet.
+func PacketFromBytes(b []byte) (*OnionPacket, error) {
+	if len(b) != PacketSize {
+		return nil, fmt.Errorf("onion packet %d bytes, expect %d", len(b), PacketSize)
+	}
+	p := new(OnionPacket)
+	p.Version = b[0]
+	if p.Version != packetVersion {
+		return nil, fmt.Errorf("unknown onion version %d", p.Version)
+	}
+	copy(p.EphemeralKey[:], b[1:34])
+	copy(p.RoutingInfo[:], b[34:34+routingInfoSize])
+	copy(p.HMAC[:], b[34+routingInfoSize:])
+	return p, nil
+}
+
+// IsLast says whether the hop which peeled this packet is the last one.
+func (p *OnionPacket) IsLast() bool {
+	var zero [HMACSize]byte
+	return p.HMAC == zero
+}
+
+// NewPacket wraps the payloads for the hops in an onion.  sessionKey has to
+// be fresh for every packet.  assocData, the payment hash, is covered by the
+// HMACs so the onion can't be used with any other HTLC.  It gives the shared
+// secret with each hop, which are needed to read error packets.
+func NewPacket(sessionKey *koblitz.PrivateKey, hops []*koblitz.PublicKey,
+	payloads []HopPayload, assocData []byte) (*OnionPacket, [][32]byte, error) {
+
+	numHops := len(hops)
+	if numHops == 0 || numHops > NumMaxHops {
+		return nil, nil, fmt.Errorf("%d hops, need 1 to %d", numHops, NumMaxHops)
+	}
+	if len(payloads) != numHops {
+		return nil, nil, fmt.Errorf("%d payloads for %d hops", len(payloads), numHops)
+	}
+
+	secrets, err := sharedSecrets(sessionKey, hops)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	filler := generateFiller(secrets)
+
+	// start with noise from the session key, so there's nothing to tell the
+	// length of the route from at the last hop
+	padKey := generateKey("pad", sessionKey.Serialize())
+	var mixHeader [routingInfoSize]byte
+	copy(mixHeader[:], generateCipherStream(padKey, routingInfoSize))
+
+	var nextHMAC [HMACSize]byte
+	for i := numHops - 1; i >= 0; i-- {
+		rhoKey := generateKey("rho", secrets[i][:])
+		muKey := generateKey("mu", secrets[i][:])
+
+		copy(mixHeader[hopDataSize:], mixHeader[:routingInfoSize-hopDataSize])
+		copy(mixHeader[:HopPayloadSize], payloads[i].Bytes())
+		copy(mixHeader[HopPayloadSize:hopDataSize], nextHMAC[:])
+
+		xor(mixHeader[:], generateCipherStream(rhoKey, routingInfoSize))
+
+		if i == numHops-1 {
+			copy(mixHeader[routingInfoSize-len(filler):], filler)
+		}
+
+		copy(nextHMAC[:], calcMac(muKey[:], append(mixHeader[:], assocData...)))
+	}
+
+	p := new(OnionPacket)
+	p.Version = packetVersion
+	copy(p.EphemeralKey[:], sessionKey.PubKey().SerializeCompressed())
+	p.RoutingInfo = mixHeader
+	p.HMAC = nextHMAC
+	return p, secrets, nil
+}
+
+// Peel takes our layer off the onion with our identity key.  It gives our
+// payload, the packet to send on to the next hop, and our shared secret with
+// the sender, for wrapping error packets.
+func (p *OnionPacket) Peel(priv *koblitz.PrivateKey, assocData []byte) (
+	*HopPayload, *OnionPacket, [32]byte, error) {
+
+	var secret [32]byte
+
+	ephKey, err := koblitz.ParsePubKey(p.EphemeralKey[:], koblitz.S256())
+	if err != nil {
+		return nil, nil, secret, err
+	}
+	secret = sharedSecret(priv.D, ephKey)
+
+	muKey := generateKey("mu", secret[:])
+	mac := calcMac(muKey[:], append(p.RoutingInfo[:], assocData...))
+	if !hmac.Equal(mac, p.HMAC[:]) {
+		return nil, nil, secret, fmt.Errorf("onion HMAC mismatch")
+	}
+
+	rhoKey := generateKey("rho", secret[:])
+	var padded [routingInfoSize + hopDataSize]byte
+	copy(padded[:], p.RoutingInfo[:])
+	xor(padded[:], generateCipherStream(rhoKey, len(padded)))
+
+	payload, err := HopPayloadFromBytes(padded[:HopPayloadSize])
+	if err != nil {
+		return nil, nil, secret, err
+	}
+
+	next := new(OnionPacket)
+	next.Version = p.Version
+	nextEph := blindPub(ephKey, blindingFactor(ephKey, secret))
+	copy(next.EphemeralKey[:], nextEph.SerializeCompressed())
+	copy(next.HMAC[:], padded[HopPayloadSize:hopDataSize])
+	copy(next.RoutingInfo[:], padded[hopDataSize:])
+
+	return payload, next, secret, nil
+}
+
+// sharedSecrets gives the shared secret with each hop, blinding the session
+// key from one hop to the next.
+func sharedSecrets(sessionKey *koblitz.PrivateKey,
+	hops []*koblitz.PublicKey) ([][32]byte, error) {
+
+	curve := koblitz.S256()
+	secrets := make([][32]byte, len(hops))
+
+	ephPriv := new(big.Int).Set(sessionKey.D)
+	for i, hop := range hops {
+		if hop == nil {
+			return nil, fmt.Errorf("no key for hop %d", i)
+		}
+		secrets[i] = sharedSecret(ephPriv, hop)
+
+		x, y := curve.ScalarBaseMult(ephPriv.Bytes())
+		ephPub := &koblitz.PublicKey{Curve: curve, X: x, Y: y}
+		b := blindingFactor(ephPub, secrets[i])
+
+		ephPriv.Mul(ephPriv, new(big.Int).SetBytes(b[:]))
+		ephPriv.Mod(ephPriv, curve.N)
+	}
+	return secrets, nil
+}
+
+// sharedSecret is the sha256 of the ECDH point, compressed.
+func sharedSecret(priv *big.Int, pub *koblitz.PublicKey) [32]byte {
+	x, y := koblitz.S256().ScalarMult(pub.X, pub.Y, priv.Bytes())
+	point := &koblitz.PublicKey{Curve: koblitz.S256(), X: x, Y: y}
+	return sha256.Sum256(point.SerializeCompressed())
+}
+
+func blindingFactor(ephPub *koblitz.PublicKey, secret [32]byte) [32]byte {
+	return sha256.Sum256(append(ephPub.SerializeCompressed(), secret[:]...))
+}
+
+func blindPub(pub *koblitz.PublicKey, b [32]byte) *koblitz.PublicKey {
+	x, y := koblitz.S256().ScalarMult(pub.X, pub.Y, b[:])
+	return &koblitz.PublicKey{Curve: koblitz.S256(), X: x, Y: y}
+}
+
+// generateFiller makes the bytes which the hops shift into the end of the
+// routing info, so the last hop's HMAC covers what it will see.
+func generateFiller(secrets [][32]byte) []byte {
+	numHops := len(secrets)
+	filler := make([]byte, (NumMaxHops+1)*hopDataSize)
+
+	for i := 0; i < numHops-1; i++ {
+		copy(filler, filler[hopDataSize:])
+		for j := len(filler) - hopDataSize; j < len(filler); j++ {
+			filler[j] = 0
+		}
+		rhoKey := generateKey("rho", secrets[i][:])
+		xor(filler, generateCipherStream(rhoKey, len(filler)))
+	}
+
+	return filler[(NumMaxHops-numHops+2)*hopDataSize:]
+}
+
+// generateKey derives a key for one use from a shared secret.
+func generateKey(keyType string, secret []byte) [32]byte {
+	var key [32]byte
+	copy(key[:], calcMac([]byte(keyType), secret))
+	return key
+}
+
+// generateCipherStream gives n bytes of chacha20 keystream.
+func generateCipherStream(key [32]byte, n int) []byte {
+	var nonce [chacha20.NonceSize]byte
+	c, _ := chacha20.NewUnauthenticatedCipher(key[:], nonce[:])
+	stream := make([]byte, n)
+	c.XORKeyStream(stream, stream)
+	return stream
+}
+
+func calcMac(key, msg []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(msg)
+	return h.Sum(nil)
+}
+
+// xor xors src into dst, up to the length of dst.
+func xor(dst, src []byte) {
+	for i := range dst {
+		dst[i] ^= src[i]
+	}
+}
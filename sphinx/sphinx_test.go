@@ -0,0 +1,139 @@
+package sphinx
+
+import (
+	"testing"
+
+	"github.com/mit-dci/lit/btcutil/chaincfg/chainhash"
+	"github.com/mit-dci/lit/crypto/koblitz"
+)
+
+func testKey(s string) *koblitz.PrivateKey {
+	priv, _ := koblitz.PrivKeyFromBytes(koblitz.S256(), chainhash.DoubleHashB([]byte(s)))
+	return priv
+}
+
+func testRoute(n int) ([]*koblitz.PrivateKey, []*koblitz.PublicKey, []HopPayload) {
+	var privs []*koblitz.PrivateKey
+	var pubs []*koblitz.PublicKey
+	var payloads []HopPayload
+	for i := 0; i < n; i++ {
+		priv := testKey(string([]byte{'h', byte(i)}))
+		privs = append(privs, priv)
+		pubs = append(pubs, priv.PubKey())
+
+		var p HopPayload
+		if i < n-1 {
+			p.NextNode[0] = byte(i + 1)
+		} else {
+			p.TotalAmt = 250000
+			copy(p.Preimage[:], "sender's preimag")
+		}
+		p.CoinType = 257
+		p.Amt = int64(100000 - i)
+		p.CLTV = uint32(500 * (n - i))
+		payloads = append(payloads, p)
+	}
+	return privs, pubs, payloads
+}
+
+func TestOnionPeel(t *testing.T) {
+	for _, n := range []int{1, 3, NumMaxHops} {
+		privs, pubs, payloads := testRoute(n)
+		hash := []byte("payment hash")
+
+		pkt, secrets, err := NewPacket(testKey("session"), pubs, payloads, hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < n; i++ {
+			// every hop gets it off the wire
+			pkt, err = PacketFromBytes(pkt.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var payload *HopPayload
+			var secret [32]byte
+			payload, pkt, secret, err = pkt.Peel(privs[i], hash)
+			if err != nil {
+				t.Fatalf("%d hops, hop %d: %s", n, i, err.Error())
+			}
+			if *payload != payloads[i] {
+				t.Fatalf("%d hops, hop %d got payload %v, expect %v",
+					n, i, payload, payloads[i])
+			}
+			if secret != secrets[i] {
+				t.Fatalf("%d hops, hop %d secret doesn't match the sender's", n, i)
+			}
+			if pkt.IsLast() != (i == n-1) {
+				t.Fatalf("%d hops, hop %d: last %t", n, i, pkt.IsLast())
+			}
+		}
+	}
+}
+
+func TestOnionTampered(t *testing.T) {
+	privs, pubs, payloads := testRoute(3)
+	hash := []byte("payment hash")
+
+	pkt, _, err := NewPacket(testKey("session"), pubs, payloads, hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, _, err = pkt.Peel(privs[0], []byte("other hash"))
+	if err == nil {
+		t.Fatalf("peeled onion for a different payment hash")
+	}
+
+	_, _, _, err = pkt.Peel(privs[1], hash)
+	if err == nil {
+		t.Fatalf("peeled onion with the wrong hop's key")
+	}
+
+	pkt.RoutingInfo[100] ^= 1
+	_, _, _, err = pkt.Peel(privs[0], hash)
+	if err == nil {
+		t.Fatalf("peeled tampered onion")
+	}
+}
+
+func TestErrorPacket(t *testing.T) {
+	privs, pubs, payloads := testRoute(4)
+	hash := []byte("payment hash")
+
+	pkt, secrets, err := NewPacket(testKey("session"), pubs, payloads, hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// peel down to hop 2, which fails
+	var hopSecrets [][32]byte
+	for i := 0; i < 3; i++ {
+		var secret [32]byte
+		_, pkt, secret, err = pkt.Peel(privs[i], hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hopSecrets = append(hopSecrets, secret)
+	}
+
+	errPkt := NewErrorPacket(hopSecrets[2], "no channel to next hop")
+	errPkt = WrapError(hopSecrets[1], errPkt)
+	errPkt = WrapError(hopSecrets[0], errPkt)
+
+	idx, failure, err := DecryptError(secrets, errPkt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx != 2 || failure != "no channel to next hop" {
+		t.Fatalf("error from hop %d: %q", idx, failure)
+	}
+
+	errPkt[50] ^= 1
+	_, _, err = DecryptError(secrets, errPkt)
+	if err == nil {
+		t.Fatalf("decrypted tampered error packet")
+	}
+}
@@ -1,6 +1,7 @@
 package uspv
 
 import (
+	"errors"
 	"path/filepath"
 
 	"github.com/mit-dci/lit/logging"
@@ -81,6 +82,19 @@ type ChainHook interface {
 	// TODO -- reorgs.  Oh and doublespends and stuff.
 }
 
+// UtxoChecker looks up outputs anywhere on chain, not just ones the wallit
+// registered.  SPV can't do that; ChainHooks which can (like indexers)
+// implement it.
+type UtxoChecker interface {
+	// GetUtxo gives the output at op, or an error if it isn't there or has
+	// been spent.
+	GetUtxo(op wire.OutPoint) (*wire.TxOut, error)
+}
+
+// ErrCantCheckUtxo is what the wallit gives when its ChainHook isn't a
+// UtxoChecker.
+var ErrCantCheckUtxo = errors.New("chain hook can't look up utxos")
+
 /*
 type ChainHook interface {
 
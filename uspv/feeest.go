@@ -0,0 +1,121 @@
+package uspv
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/mit-dci/lit/btcutil"
+	"github.com/mit-dci/lit/btcutil/blockchain"
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/lit/wire"
+)
+
+// FeeEstimator gives a fee rate, in satoshis per byte, which should get a tx
+// confirmed within confTarget blocks.  ChainHooks can implement this if they
+// have some way to tell; the wallit falls back to a StaticFeeEstimator.
+type FeeEstimator interface {
+	EstimateFee(confTarget uint32) (int64, error)
+}
+
+// StaticFeeEstimator always gives the same rate, whatever the target.
+type StaticFeeEstimator struct {
+	FeePerByte int64
+}
+
+// EstimateFee returns the fixed rate.
+func (s *StaticFeeEstimator) EstimateFee(confTarget uint32) (int64, error) {
+	if s.FeePerByte <= 0 {
+		return 0, fmt.Errorf("no static fee rate set")
+	}
+	return s.FeePerByte, nil
+}
+
+const (
+	// how many recent blocks to keep fee rates for
+	feeWindow = 144
+	// don't estimate from fewer blocks than this
+	minFeeSamples = 6
+)
+
+/*
+The SPVCon estimator only sees full blocks (in hard mode), and doesn't know
+the values of the inputs, so it can't tell the fee of any one tx.  What it can
+tell is the total fee in a block: whatever the coinbase claims beyond the
+subsidy.  Divided by the size of the rest of the block, that's the average fee
+rate miners took.
+
+Those per-block rates go in a rolling window, and the estimate is a
+percentile of them: high for a target of 1 block, down towards the median for
+long targets.
+*/
+
+// rollingFees is a window of the average fee rates of recent blocks.
+type rollingFees struct {
+	mtx   sync.Mutex
+	rates []int64
+}
+
+// add puts a new block's rate in the window, dropping the oldest if full.
+func (r *rollingFees) add(rate int64) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.rates = append(r.rates, rate)
+	if len(r.rates) > feeWindow {
+		r.rates = r.rates[len(r.rates)-feeWindow:]
+	}
+}
+
+// estimate picks the rate at the percentile for confTarget.
+func (r *rollingFees) estimate(confTarget uint32) (int64, error) {
+	r.mtx.Lock()
+	sorted := make([]int64, len(r.rates))
+	copy(sorted, r.rates)
+	r.mtx.Unlock()
+
+	if len(sorted) < minFeeSamples {
+		return 0, fmt.Errorf("only %d blocks seen, need %d to estimate fee",
+			len(sorted), minFeeSamples)
+	}
+	if confTarget < 1 {
+		confTarget = 1
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	// 90th percentile for next block, 70th for 2, heading to 50th
+	pct := 0.5 + 0.4/float64(confTarget)
+	idx := int(pct * float64(len(sorted)-1))
+	return sorted[idx], nil
+}
+
+// blockFeeRate works out the average fee rate of a block, in sat/vbyte.
+// Returns 0 for blocks with nothing but the coinbase.
+func blockFeeRate(m *wire.MsgBlock, height int32, p *coinparam.Params) int64 {
+	if len(m.Transactions) < 2 {
+		return 0
+	}
+	var claimed int64
+	for _, out := range m.Transactions[0].TxOut {
+		claimed += out.Value
+	}
+	fees := claimed - blockchain.CalcBlockSubsidy(height, p)
+	if fees <= 0 {
+		return 0
+	}
+
+	var vsize int64
+	for _, tx := range m.Transactions[1:] {
+		vsize += blockchain.GetTxVirtualSize(btcutil.NewTx(tx))
+	}
+	rate := fees / vsize
+	if rate < 1 {
+		rate = 1
+	}
+	return rate
+}
+
+// EstimateFee estimates from the blocks the SPVCon has ingested.  Only works
+// in hard mode, as merkle blocks don't have the coinbase.
+func (s *SPVCon) EstimateFee(confTarget uint32) (int64, error) {
+	return s.feeRates.estimate(confTarget)
+}
@@ -0,0 +1,50 @@
+package uspv
+
+import (
+	"testing"
+)
+
+func TestRollingFees(t *testing.T) {
+	var r rollingFees
+
+	_, err := r.estimate(1)
+	if err == nil {
+		t.Fatalf("estimated with no blocks")
+	}
+
+	// rates 1 through 200; only the last 144 (57-200) stay in the window
+	for i := int64(1); i <= 200; i++ {
+		r.add(i)
+	}
+	if len(r.rates) != feeWindow {
+		t.Fatalf("window has %d rates, expected %d", len(r.rates), feeWindow)
+	}
+
+	fast, err := r.estimate(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slow, err := r.estimate(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fast <= slow {
+		t.Fatalf("1 block estimate %d not above 100 block estimate %d", fast, slow)
+	}
+	if fast > 200 || slow < 57 {
+		t.Fatalf("estimates %d, %d outside window", fast, slow)
+	}
+}
+
+func TestStaticFeeEstimator(t *testing.T) {
+	s := StaticFeeEstimator{FeePerByte: 80}
+	rate, err := s.EstimateFee(1)
+	if err != nil || rate != 80 {
+		t.Fatalf("got %d, %v", rate, err)
+	}
+	s.FeePerByte = 0
+	_, err = s.EstimateFee(1)
+	if err == nil {
+		t.Fatalf("no error with no rate set")
+	}
+}
@@ -195,6 +195,12 @@ func (s *SPVCon) IngestBlock(m *wire.MsgBlock) {
 		return
 	}
 
+	// note what miners got paid, for fee estimation
+	rate := blockFeeRate(m, hah.height, s.Param)
+	if rate > 0 {
+		s.feeRates.add(rate)
+	}
+
 	// iterate through all txs in the block, looking for matches.
 	for _, tx := range m.Transactions {
 		if s.MatchTx(tx) {
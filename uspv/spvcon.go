@@ -77,6 +77,9 @@ type SPVCon struct {
 	// send height events
 	HeightDistribute []chan int32
 
+	// average fee rates of recently ingested blocks, for EstimateFee
+	feeRates rollingFees
+
 	// for internal use -------------------------
 
 	// mBlockQueue is for keeping track of what height we've requested.
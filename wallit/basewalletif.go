@@ -6,6 +6,7 @@ import (
 
 	"github.com/mit-dci/lit/btcutil/chaincfg/chainhash"
 	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/lit/consts"
 	"github.com/mit-dci/lit/crypto/koblitz"
 	"github.com/mit-dci/lit/lnutil"
 	"github.com/mit-dci/lit/logging"
@@ -22,7 +23,7 @@ type UWallet interface {
 
 	PushTx(tx *wire.MsgTx) error
 	ExportUtxo(txo *portxo.PorTxo)
-	MaybeSend(txos []*wire.TxOut) ([]*wire.OutPoint, error)
+	MaybeSend(txos []*wire.TxOut, feePerByte int64) ([]*wire.OutPoint, error)
 	ReallySend(txid *chainhash.Hash) error
 	NahDontSend(txid *chainhash.Hash) error
 	WatchThis(wire.OutPoint) error
@@ -145,13 +146,45 @@ func (w *Wallit) StopWatchingThis(op wire.OutPoint) error {
 	return nil
 }
 
+// GetUtxo looks up an output on chain, if the Hook can.
+func (w *Wallit) GetUtxo(op wire.OutPoint) (*wire.TxOut, error) {
+	uc, ok := w.Hook.(uspv.UtxoChecker)
+	if !ok {
+		return nil, uspv.ErrCantCheckUtxo
+	}
+	return uc.GetUtxo(op)
+}
+
+// Fee is the fee rate to use when there's no particular hurry.
 func (w *Wallit) Fee() int64 {
-	return w.FeeRate
+	return w.EstimateFee(consts.DefaultConfTarget)
+}
+
+// EstimateFee gives a fee rate in sat/byte to confirm within confTarget
+// blocks.  A rate set with SetFee wins; otherwise ask the estimator, and if
+// it doesn't know, use the coin's default rate.
+func (w *Wallit) EstimateFee(confTarget uint32) int64 {
+	if w.FeeRate != 0 {
+		return w.FeeRate
+	}
+	if w.FeeEstimator != nil {
+		rate, err := w.FeeEstimator.EstimateFee(confTarget)
+		if err == nil && rate > 0 {
+			return rate
+		}
+		if err != nil {
+			logging.Debugf("EstimateFee: %s, using static fee\n", err.Error())
+		}
+	}
+	rate, _ := w.StaticFee.EstimateFee(confTarget)
+	return rate
 }
 
+// SetFee sets a fixed fee rate, overriding estimation.  0 goes back to
+// estimating.  Returns the rate now in use.
 func (w *Wallit) SetFee(set int64) int64 {
 	w.FeeRate = set
-	return set
+	return w.Fee()
 }
 
 // ********* sweep is for testing / spamming, remove for real use
@@ -0,0 +1,364 @@
+package wallit
+
+import (
+	"fmt"
+
+	"github.com/mit-dci/lit/btcutil"
+	"github.com/mit-dci/lit/btcutil/blockchain"
+	"github.com/mit-dci/lit/btcutil/chaincfg/chainhash"
+	"github.com/mit-dci/lit/consts"
+	"github.com/mit-dci/lit/lncore"
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/logging"
+	"github.com/mit-dci/lit/portxo"
+	"github.com/mit-dci/lit/wire"
+)
+
+/*
+Fee bumping for our own unconfirmed txs.
+
+RBF: txs from BuildAndSign signal replaceability (BIP125) on every input
+without a relative timelock.  Bumping with RBF signs the same inputs and
+outputs again, taking the extra fee out of our change.  Txs whose txid
+someone depends on, like channel funding, can't be replaced; we watch their
+outputs, so those are easy to spot.
+
+CPFP: spend one of the tx's outputs back to ourselves, paying enough that
+parent and child together get the new rate.  Works on anything that pays us.
+
+Either way the bump is stored as a Replacement of the original until one of
+them confirms.
+*/
+
+// RBFSequence is the input sequence for txs we might want to replace later.
+// Anything below 0xfffffffe signals; this is the highest that does, and
+// leaves relative timelocks disabled.
+const RBFSequence = wire.MaxTxInSequenceNum - 2
+
+// BumpFee speeds up one of our unconfirmed txs, so that it pays feePerByte.
+// Replaces it if it can, unless cpfp is set; otherwise spends its change.
+// Returns the txid of the new tx.
+func (w *Wallit) BumpFee(
+	txid chainhash.Hash, feePerByte int64, cpfp bool) (*chainhash.Hash, error) {
+
+	w.FreezeMutex.Lock()
+	defer w.FreezeMutex.Unlock()
+
+	tx, err := w.WalletDB.GetTx(txid)
+	if err != nil {
+		return nil, err
+	}
+	if tx == nil {
+		return nil, fmt.Errorf("tx %s not in wallet", txid.String())
+	}
+
+	// find our inputs to it, and see if it's confirmed
+	stxos, err := w.WalletDB.GetStxos()
+	if err != nil {
+		return nil, err
+	}
+	var ins []*portxo.PorTxo
+	var inSum int64
+	var children []chainhash.Hash
+	for i := range stxos {
+		if stxos[i].PorTxo.Op.Hash.IsEqual(&txid) {
+			// one of our txs already spends an output of it
+			children = append(children, stxos[i].SpendTxid)
+		}
+		if !stxos[i].SpendTxid.IsEqual(&txid) {
+			continue
+		}
+		if stxos[i].SpendHeight > 0 {
+			return nil, fmt.Errorf("tx %s already confirmed at height %d",
+				txid.String(), stxos[i].SpendHeight)
+		}
+		ins = append(ins, &stxos[i].PorTxo)
+		inSum += stxos[i].PorTxo.Value
+	}
+	outs, err := w.utxosFromTx(txid)
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range outs {
+		if u.Height > 0 {
+			return nil, fmt.Errorf("tx %s already confirmed at height %d",
+				txid.String(), u.Height)
+		}
+	}
+
+	// only know what it pays if all the inputs are ours
+	parentFee := int64(-1)
+	if len(ins) == len(tx.TxIn) {
+		var outSum int64
+		for _, out := range tx.TxOut {
+			outSum += out.Value
+		}
+		parentFee = inSum - outSum
+	}
+
+	var newTx *wire.MsgTx
+	if !cpfp {
+		newTx, err = w.buildRBF(tx, ins, children, parentFee, feePerByte)
+		if err != nil {
+			logging.Infof("BumpFee: can't replace %s (%s), trying CPFP\n",
+				txid.String(), err.Error())
+			cpfp = true
+		}
+	}
+	if cpfp {
+		newTx, err = w.buildCPFP(tx, outs, parentFee, feePerByte)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = w.NewOutgoingTx(newTx)
+	if err != nil {
+		return nil, err
+	}
+
+	newTxid := newTx.TxHash()
+	err = w.WalletDB.Batch(func(wdb lncore.LitWalletStorage) error {
+		if !cpfp {
+			// the original's outputs are gone unless it confirms after all,
+			// and its inputs are now spent by the replacement
+			for _, u := range outs {
+				err := wdb.RemoveUtxo(lncore.Utxo{PorTxo: *u})
+				if err != nil {
+					return err
+				}
+			}
+			for _, in := range ins {
+				err := wdb.AddStxo(lncore.Stxo{PorTxo: *in, SpendTxid: newTxid})
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return wdb.AddReplacement(lncore.Replacement{
+			Orig:       txid,
+			New:        newTxid,
+			CPFP:       cpfp,
+			FeePerByte: feePerByte,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	logging.Infof("BumpFee: %s bumped by %s (cpfp %t)\n",
+		txid.String(), newTxid.String(), cpfp)
+	return &newTxid, nil
+}
+
+// utxosFromTx gives our utxos which are outputs of the given tx.
+func (w *Wallit) utxosFromTx(txid chainhash.Hash) ([]*portxo.PorTxo, error) {
+	utxos, err := w.GetAllUtxos()
+	if err != nil {
+		return nil, err
+	}
+	var outs []*portxo.PorTxo
+	for _, u := range utxos {
+		if u.Op.Hash.IsEqual(&txid) {
+			outs = append(outs, u)
+		}
+	}
+	return outs, nil
+}
+
+// buildRBF makes a replacement for tx paying feePerByte, with the extra fee
+// coming out of the largest output to us.  children are our txs spending its
+// outputs; replacing it would knock those out of the mempool too, so it
+// won't.
+func (w *Wallit) buildRBF(tx *wire.MsgTx, ins []*portxo.PorTxo,
+	children []chainhash.Hash, parentFee, feePerByte int64) (*wire.MsgTx, error) {
+
+	if parentFee < 0 {
+		return nil, fmt.Errorf("not all inputs are ours")
+	}
+	if len(children) > 0 {
+		return nil, fmt.Errorf("output spent by %s, which would be dropped",
+			children[0].String())
+	}
+	signals := false
+	for _, in := range tx.TxIn {
+		if in.Sequence < wire.MaxTxInSequenceNum-1 {
+			signals = true
+		}
+	}
+	if !signals {
+		return nil, fmt.Errorf("doesn't signal replaceability")
+	}
+
+	txid := tx.TxHash()
+	change := -1
+	newOuts := make([]*wire.TxOut, len(tx.TxOut))
+	for i, out := range tx.TxOut {
+		watched, err := w.WalletDB.IsWatchedOutPoint(wire.OutPoint{Hash: txid, Index: uint32(i)})
+		if err != nil {
+			return nil, err
+		}
+		if watched {
+			return nil, fmt.Errorf("output %d is watched, txid has to stay", i)
+		}
+
+		newOuts[i] = wire.NewTxOut(out.Value, out.PkScript)
+
+		var pkh [20]byte
+		copy(pkh[:], lnutil.KeyHashFromPkScript(out.PkScript))
+		adr, err := w.WalletDB.GetAddress(pkh)
+		if err != nil {
+			return nil, err
+		}
+		if adr != nil && (change == -1 || out.Value > tx.TxOut[change].Value) {
+			change = i
+		}
+	}
+	if change == -1 {
+		return nil, fmt.Errorf("no change output")
+	}
+
+	// BIP125 wants more fee in total, and more by at least the relay rate
+	vsize := blockchain.GetTxVirtualSize(btcutil.NewTx(tx))
+	newFee := feePerByte * vsize
+	if newFee < parentFee+vsize {
+		newFee = parentFee + vsize
+	}
+	newOuts[change].Value -= newFee - parentFee
+	if newOuts[change].Value < consts.DustCutoff {
+		return nil, fmt.Errorf("change of %d can't pay %d more fee",
+			tx.TxOut[change].Value, newFee-parentFee)
+	}
+
+	return w.BuildAndSign(ins, newOuts, tx.LockTime)
+}
+
+// buildCPFP makes a child of tx spending our largest output of it, paying
+// enough that the two together pay feePerByte.
+func (w *Wallit) buildCPFP(tx *wire.MsgTx, outs []*portxo.PorTxo,
+	parentFee, feePerByte int64) (*wire.MsgTx, error) {
+
+	var u *portxo.PorTxo
+	for _, o := range outs {
+		_, frozen := w.FreezeSet[o.Op]
+		if frozen {
+			continue
+		}
+		if u == nil || o.Value > u.Value {
+			u = o
+		}
+	}
+	if u == nil {
+		return nil, fmt.Errorf("tx %s has no outputs for us to spend",
+			tx.TxHash().String())
+	}
+
+	if parentFee < 0 {
+		// don't know what it pays; assume nothing
+		parentFee = 0
+	}
+	parentSize := blockchain.GetTxVirtualSize(btcutil.NewTx(tx))
+	childSize := EstFee([]*portxo.PorTxo{u}, 0, 1)
+
+	fee := feePerByte*(parentSize+childSize) - parentFee
+	if fee < feePerByte*childSize {
+		fee = feePerByte * childSize
+	}
+	if u.Value-fee < consts.DustCutoff {
+		return nil, fmt.Errorf("output %s of %d can't pay %d fee",
+			u.Op.String(), u.Value, fee)
+	}
+
+	adr160, err := w.NewAdr160()
+	if err != nil {
+		return nil, err
+	}
+	out := wire.NewTxOut(u.Value-fee, lnutil.DirectWPKHScriptFromPKH(adr160))
+
+	return w.BuildAndSign(
+		[]*portxo.PorTxo{u}, []*wire.TxOut{out}, uint32(w.CurrentHeight()))
+}
+
+// settleReplacements is called with txs that just confirmed.  Bumps whose
+// new tx confirmed are done.  If the original confirmed instead of an RBF
+// replacement, the replacement's outputs were never real.
+func (w *Wallit) settleReplacements(txids []*chainhash.Hash, height int32) error {
+	reps, err := w.WalletDB.GetReplacements()
+	if err != nil {
+		return err
+	}
+	if len(reps) == 0 {
+		return nil
+	}
+
+	return w.WalletDB.Batch(func(wdb lncore.LitWalletStorage) error {
+		for _, r := range reps {
+			for _, txid := range txids {
+				if txid.IsEqual(&r.New) {
+					logging.Infof("bump %s of %s confirmed\n",
+						r.New.String(), r.Orig.String())
+					if !r.CPFP {
+						err := setStxoSpend(wdb, r.New, r.New, height)
+						if err != nil {
+							return err
+						}
+					}
+					err := wdb.RemoveReplacement(r.New)
+					if err != nil {
+						return err
+					}
+				}
+
+				// CPFP children stay valid when the parent confirms
+				if txid.IsEqual(&r.Orig) && !r.CPFP {
+					logging.Infof("%s confirmed, dropping replacement %s\n",
+						r.Orig.String(), r.New.String())
+					utxos, err := wdb.GetUtxos()
+					if err != nil {
+						return err
+					}
+					for _, u := range utxos {
+						if u.Op.Hash.IsEqual(&r.New) && u.Height == 0 {
+							err = wdb.RemoveUtxo(u)
+							if err != nil {
+								return err
+							}
+						}
+					}
+					err = setStxoSpend(wdb, r.New, r.Orig, height)
+					if err != nil {
+						return err
+					}
+					err = wdb.RemoveReplacement(r.New)
+					if err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// setStxoSpend points the stxos spent by from at the tx that actually spent
+// them, and its height.
+func setStxoSpend(wdb lncore.LitWalletStorage,
+	from, to chainhash.Hash, height int32) error {
+
+	stxos, err := wdb.GetStxos()
+	if err != nil {
+		return err
+	}
+	for _, st := range stxos {
+		if !st.SpendTxid.IsEqual(&from) {
+			continue
+		}
+		st.SpendTxid = to
+		st.SpendHeight = height
+		err = wdb.AddStxo(st)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package wallit
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/mit-dci/lit/portxo"
+	"github.com/mit-dci/lit/wire"
+)
+
+/*
+Coin control.  Locked utxos are left out of PickUtxos, so they only get spent
+when asked for by outpoint.  Labels are just notes for the user, on utxos or
+addresses; lit doesn't look at them.
+*/
+
+// LockUtxo keeps one of our utxos out of automatic coin selection.
+func (w *Wallit) LockUtxo(op wire.OutPoint) error {
+	u, err := w.WalletDB.GetUtxo(op)
+	if err != nil {
+		return err
+	}
+	if u == nil {
+		return fmt.Errorf("%s is not a utxo in this wallet", op.String())
+	}
+	return w.WalletDB.AddLockedOutPoint(op)
+}
+
+// UnlockUtxo lets coin selection use a locked utxo again.
+func (w *Wallit) UnlockUtxo(op wire.OutPoint) error {
+	return w.WalletDB.RemoveLockedOutPoint(op)
+}
+
+// pruneLocks drops locks on outpoints which aren't our utxos any more.
+// Spending a utxo unlocks it, but wallets from before that may still have
+// some.
+func (w *Wallit) pruneLocks() error {
+	ops, err := w.WalletDB.GetLockedOutPoints()
+	if err != nil {
+		return err
+	}
+	for _, op := range ops {
+		u, err := w.WalletDB.GetUtxo(op)
+		if err != nil {
+			return err
+		}
+		if u != nil {
+			continue
+		}
+		err = w.WalletDB.RemoveLockedOutPoint(op)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LockedUtxos gives the outpoints which are locked.
+func (w *Wallit) LockedUtxos() ([]wire.OutPoint, error) {
+	return w.WalletDB.GetLockedOutPoints()
+}
+
+// SetUtxoLabel labels an outpoint; an empty label removes it.
+func (w *Wallit) SetUtxoLabel(op wire.OutPoint, label string) error {
+	return w.WalletDB.SetOutPointLabel(op, label)
+}
+
+// UtxoLabel gives the label of an outpoint, or "" if it has none.
+func (w *Wallit) UtxoLabel(op wire.OutPoint) (string, error) {
+	return w.WalletDB.GetOutPointLabel(op)
+}
+
+// SetAdrLabel labels one of our addresses; an empty label removes it.
+func (w *Wallit) SetAdrLabel(pkh [20]byte, label string) error {
+	adr, err := w.WalletDB.GetAddress(pkh)
+	if err != nil {
+		return err
+	}
+	if adr == nil {
+		return fmt.Errorf("%x is not an address in this wallet", pkh)
+	}
+	return w.WalletDB.SetAddressLabel(pkh, label)
+}
+
+// AdrLabel gives the label of an address, or "" if it has none.
+func (w *Wallit) AdrLabel(pkh [20]byte) (string, error) {
+	return w.WalletDB.GetAddressLabel(pkh)
+}
+
+// SelectUtxos is PickUtxos with the inputs given by the caller.  They can be
+// locked, but not frozen, and all have to be spendable.  Returns the same
+// overshoot as PickUtxos, or an error if they don't add up to enough.
+func (w *Wallit) SelectUtxos(ops []wire.OutPoint,
+	amtWanted, outputByteSize, feePerByte int64,
+	ow bool) (portxo.TxoSliceByBip69, int64, error) {
+
+	if len(ops) == 0 {
+		return nil, 0, fmt.Errorf("no inputs given")
+	}
+
+	curHeight, err := w.GetDBSyncHeight()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var rSlice portxo.TxoSliceByBip69
+	var sum int64
+	seen := make(map[wire.OutPoint]bool)
+	for _, op := range ops {
+		if seen[op] {
+			return nil, 0, fmt.Errorf("%s given twice", op.String())
+		}
+		seen[op] = true
+
+		u, err := w.WalletDB.GetUtxo(op)
+		if err != nil {
+			return nil, 0, err
+		}
+		if u == nil {
+			return nil, 0, fmt.Errorf("%s is not a utxo in this wallet", op.String())
+		}
+		_, frozen := w.FreezeSet[op]
+		if frozen {
+			return nil, 0, fmt.Errorf("%s is frozen, can't spend", op.String())
+		}
+		if !u.Mature(curHeight) {
+			return nil, 0, fmt.Errorf("%s is immature, can't spend", op.String())
+		}
+		if ow && u.Mode&portxo.FlagTxoWitness == 0 {
+			return nil, 0, fmt.Errorf("%s is not witness, can't use here", op.String())
+		}
+		txo := u.PorTxo
+		rSlice = append(rSlice, &txo)
+		sum += u.Value
+	}
+
+	fee := EstFee(rSlice, outputByteSize, feePerByte)
+	if sum < amtWanted+fee {
+		return nil, 0, fmt.Errorf("inputs have %d, need %d plus %d fee",
+			sum, amtWanted, fee)
+	}
+
+	sort.Sort(rSlice)
+	return rSlice, sum - amtWanted - fee, nil
+}
+
+// lockedSet gives the locked outpoints as a set, for skipping in PickUtxos.
+func (w *Wallit) lockedSet() (map[wire.OutPoint]bool, error) {
+	ops, err := w.WalletDB.GetLockedOutPoints()
+	if err != nil {
+		return nil, err
+	}
+	locked := make(map[wire.OutPoint]bool, len(ops))
+	for _, op := range ops {
+		locked[op] = true
+	}
+	return locked, nil
+}
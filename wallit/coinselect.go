@@ -0,0 +1,248 @@
+package wallit
+
+import (
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/mit-dci/lit/consts"
+	"github.com/mit-dci/lit/portxo"
+)
+
+/*
+Coin selection works on effective values: what a utxo is worth once the fee
+to spend it is paid.  That depends on the kind of input (a P2PKH input is
+twice the size of a P2WPKH one) so small non-witness utxos can be worth less
+than nothing at high fee rates, and are left out.
+
+First branch and bound looks for a set of inputs which covers the amount and
+fee with so little left over that a change output isn't worth it: anything
+over is less than a change output would cost to make and later spend.  Those
+txs have no change at all.
+
+If there's no such set, knapsack looks for one with enough left over for
+change above the dust cutoff, preferring less left over.
+*/
+
+const (
+	// give up on branch and bound after trying this many branches
+	maxBnBTries = 100000
+	// random subsets knapsack tries
+	knapsackIterations = 1000
+	// vsize of a P2WPKH change output, as in MaybeSend
+	changeOutSize = 30
+	// vsize of the input spending that change later
+	changeSpendSize = 66
+)
+
+// coinCand is a utxo which could go in a tx, and its effective value.
+type coinCand struct {
+	u   *portxo.PorTxo
+	eff int64
+}
+
+// inputFees gives the fee to add an input of each mode in utxos, from EstFee.
+func inputFees(utxos []*portxo.PorTxo, feePerByte int64) map[portxo.TxoMode]int64 {
+	base := EstFee(nil, 0, feePerByte)
+	fees := make(map[portxo.TxoMode]int64)
+	for _, u := range utxos {
+		_, ok := fees[u.Mode]
+		if !ok {
+			fees[u.Mode] = EstFee([]*portxo.PorTxo{u}, 0, feePerByte) - base
+		}
+	}
+	return fees
+}
+
+// selectCoins picks inputs from utxos to pay amtWanted into outputs of
+// outputByteSize, at feePerByte.  Returns the inputs and the overshoot, which
+// is how much is left after the fee; 0 or close to it if no change is needed.
+// ok is false if the utxos aren't enough.
+func selectCoins(utxos []*portxo.PorTxo, amtWanted, outputByteSize,
+	feePerByte int64) (rSlice portxo.TxoSliceByBip69, overshoot int64, ok bool) {
+
+	fees := inputFees(utxos, feePerByte)
+	var cands []coinCand
+	for _, u := range utxos {
+		eff := u.Value - fees[u.Mode]
+		if eff > 0 {
+			cands = append(cands, coinCand{u: u, eff: eff})
+		}
+	}
+	// biggest first; confirmed before unconfirmed when equal
+	sort.Slice(cands, func(i, j int) bool {
+		if cands[i].eff != cands[j].eff {
+			return cands[i].eff > cands[j].eff
+		}
+		return cands[i].u.Height > cands[j].u.Height
+	})
+
+	target := amtWanted + EstFee(nil, outputByteSize, feePerByte)
+	costOfChange := feePerByte * (changeOutSize + changeSpendSize)
+	minChange := consts.DustCutoff + feePerByte*changeOutSize
+
+	picked := branchAndBound(cands, target, costOfChange)
+	if picked == nil {
+		rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+		picked = knapsack(cands, target, minChange, rng)
+	}
+	if picked == nil {
+		return nil, 0, false
+	}
+
+	var effSum int64
+	for _, i := range picked {
+		rSlice = append(rSlice, cands[i].u)
+		effSum += cands[i].eff
+	}
+	sort.Sort(rSlice)
+	return rSlice, effSum - target, true
+}
+
+// branchAndBound looks for a subset of cands (sorted biggest first) with
+// effective value between target and target+window, as close to target as
+// it can find.  Returns the indexes, or nil if it found nothing.
+func branchAndBound(cands []coinCand, target, window int64) []int {
+	// avail[i] is everything from i on; if that's not enough, stop
+	avail := make([]int64, len(cands)+1)
+	for i := len(cands) - 1; i >= 0; i-- {
+		avail[i] = avail[i+1] + cands[i].eff
+	}
+
+	var best, cur []int
+	bestWaste := window + 1
+	tries := 0
+
+	var search func(i int, val int64)
+	search = func(i int, val int64) {
+		tries++
+		if tries > maxBnBTries || bestWaste == 0 {
+			return
+		}
+		if val+avail[i] < target || val > target+window {
+			return
+		}
+		if val >= target {
+			if val-target < bestWaste {
+				bestWaste = val - target
+				best = append([]int(nil), cur...)
+			}
+			return
+		}
+
+		// with cands[i]
+		cur = append(cur, i)
+		search(i+1, val+cands[i].eff)
+		cur = cur[:len(cur)-1]
+
+		// without it.  Leaving out one of a run of equal values and putting
+		// in a later one is the same as what we just tried, so skip the run.
+		next := i + 1
+		for next < len(cands) && cands[next].eff == cands[i].eff {
+			next++
+		}
+		search(next, val)
+	}
+	search(0, 0)
+
+	return best
+}
+
+// knapsack finds a subset of cands (sorted biggest first) covering target
+// and leaving at least minChange, or covering target exactly.  Tries to keep
+// the total low.  Returns nil if cands don't add up to target.
+func knapsack(cands []coinCand, target, minChange int64, rng *rand.Rand) []int {
+	// one utxo that's just right
+	for i, c := range cands {
+		if c.eff == target {
+			return []int{i}
+		}
+	}
+
+	// the smallest one that's enough by itself, and everything smaller
+	larger := -1
+	var lower []int
+	var lowerSum int64
+	for i, c := range cands {
+		if c.eff < target+minChange {
+			lower = append(lower, i)
+			lowerSum += c.eff
+		} else {
+			larger = i // sorted, so the last one is the smallest
+		}
+	}
+
+	if lowerSum == target || lowerSum == target+minChange {
+		return lower
+	}
+	if lowerSum < target {
+		if larger == -1 {
+			return nil
+		}
+		return []int{larger}
+	}
+
+	best, bestSum := approxBestSubset(cands, lower, target, rng)
+	if bestSum != target && lowerSum >= target+minChange {
+		best, bestSum = approxBestSubset(cands, lower, target+minChange, rng)
+	}
+
+	// one big utxo beats a subset which leaves dust, or costs more
+	if larger != -1 &&
+		((bestSum != target && bestSum < target+minChange) ||
+			cands[larger].eff <= bestSum) {
+		return []int{larger}
+	}
+	return best
+}
+
+// approxBestSubset tries random subsets of cands[idx...], keeping the one
+// with the lowest total at or above target.  Starts with all of them, which
+// the caller knows is enough.
+func approxBestSubset(cands []coinCand, idx []int, target int64,
+	rng *rand.Rand) ([]int, int64) {
+
+	best := make([]bool, len(idx))
+	var bestSum int64
+	for i, j := range idx {
+		best[i] = true
+		bestSum += cands[j].eff
+	}
+
+	incl := make([]bool, len(idx))
+	for rep := 0; rep < knapsackIterations && bestSum != target; rep++ {
+		for i := range incl {
+			incl[i] = false
+		}
+		var total int64
+		reached := false
+		// first pass at random, second pass adds whatever's left
+		for pass := 0; pass < 2 && !reached; pass++ {
+			for i, j := range idx {
+				if pass == 0 && rng.Intn(2) == 0 || incl[i] {
+					continue
+				}
+				total += cands[j].eff
+				incl[i] = true
+				if total >= target {
+					reached = true
+					if total < bestSum {
+						bestSum = total
+						copy(best, incl)
+					}
+					// see if a smaller one after this does it
+					total -= cands[j].eff
+					incl[i] = false
+				}
+			}
+		}
+	}
+
+	var subset []int
+	for i, j := range idx {
+		if best[i] {
+			subset = append(subset, j)
+		}
+	}
+	return subset, bestSum
+}
@@ -0,0 +1,85 @@
+package wallit
+
+import (
+	"testing"
+
+	"github.com/mit-dci/lit/consts"
+	"github.com/mit-dci/lit/portxo"
+)
+
+func testUtxos(mode portxo.TxoMode, values ...int64) []*portxo.PorTxo {
+	utxos := make([]*portxo.PorTxo, len(values))
+	for i, v := range values {
+		utxos[i] = new(portxo.PorTxo)
+		utxos[i].Op.Hash[0] = byte(i)
+		utxos[i].Op.Index = uint32(mode)
+		utxos[i].Value = v
+		utxos[i].Height = 100
+		utxos[i].Mode = mode
+	}
+	return utxos
+}
+
+func TestSelectCoinsChangeless(t *testing.T) {
+	const rate = 10
+	inFee := EstFee(testUtxos(portxo.TxoP2WPKHComp, 1), 0, rate) - EstFee(nil, 0, rate)
+	base := EstFee(nil, 50, rate)
+
+	// 300k and 200k (after their fees) pay 500k exactly; nothing else does
+	utxos := testUtxos(portxo.TxoP2WPKHComp,
+		1000000, 300000+inFee, 200000+inFee, 120000)
+
+	picked, overshoot, ok := selectCoins(utxos, 500000-base, 50, rate)
+	if !ok {
+		t.Fatalf("no selection")
+	}
+	if len(picked) != 2 || overshoot != 0 {
+		t.Fatalf("picked %d inputs, overshoot %d; expected 2 and 0",
+			len(picked), overshoot)
+	}
+}
+
+func TestSelectCoinsKnapsack(t *testing.T) {
+	const rate = 5
+	utxos := testUtxos(portxo.TxoP2WPKHComp, 70000, 90000, 150000, 400000)
+
+	// nothing lands close enough to skip change
+	picked, overshoot, ok := selectCoins(utxos, 200000, 50, rate)
+	if !ok {
+		t.Fatalf("no selection")
+	}
+	if overshoot < consts.DustCutoff+rate*changeOutSize {
+		t.Fatalf("overshoot %d too small for change", overshoot)
+	}
+	var sum int64
+	for _, u := range picked {
+		sum += u.Value
+	}
+	fee := EstFee(picked, 50, rate)
+	if sum-200000-fee != overshoot {
+		t.Fatalf("inputs %d, fee %d, but overshoot %d", sum, fee, overshoot)
+	}
+}
+
+func TestSelectCoinsInputTypes(t *testing.T) {
+	const rate = 10
+	// a non-witness input costs more to spend than it's worth at this rate
+	tiny := testUtxos(portxo.TxoP2PKHComp, 1400)
+	_, _, ok := selectCoins(tiny, 1, 0, rate)
+	if ok {
+		t.Fatalf("spent a utxo worth less than its fee")
+	}
+
+	// a little more is fine as witness, and chosen over the other
+	utxos := append(testUtxos(portxo.TxoP2WPKHComp, 5000), tiny...)
+	picked, _, ok := selectCoins(utxos, 1, 0, rate)
+	if !ok || len(picked) != 1 || picked[0].Mode != portxo.TxoP2WPKHComp {
+		t.Fatalf("expected the witness utxo, got %v", picked)
+	}
+
+	// not enough at all
+	_, _, ok = selectCoins(utxos, 1000000, 0, rate)
+	if ok {
+		t.Fatalf("selected more than there is")
+	}
+}
@@ -1,40 +1,20 @@
 package wallit
 
 import (
-	"bytes"
-	"encoding/binary"
 	"fmt"
 
 	"github.com/mit-dci/lit/logging"
 
-	"github.com/boltdb/bolt"
 	"github.com/mit-dci/lit/btcutil"
 	"github.com/mit-dci/lit/btcutil/blockchain"
 	"github.com/mit-dci/lit/btcutil/chaincfg/chainhash"
 	"github.com/mit-dci/lit/consts"
+	"github.com/mit-dci/lit/lncore"
 	"github.com/mit-dci/lit/lnutil"
 	"github.com/mit-dci/lit/portxo"
 	"github.com/mit-dci/lit/wire"
 )
 
-// const strings for db usage
-var (
-	// storage of all utxos. top level is outpoints.
-	BKToutpoint = []byte("DuffelBag")
-	// storage of all addresses being watched.  top level is pkscripts
-	BKTadr = []byte("adr")
-
-	BKTStxos = []byte("SpentTxs")  // for bookkeeping / not sure
-	BKTTxns  = []byte("Txns")      // all txs we care about, for replays
-	BKTState = []byte("MiscState") // misc states of DB
-
-	//	BKTWatch = []byte("watch") // outpoints we're watching for someone else
-	// these are in the state bucket
-	KEYNumKeys = []byte("NumKeys") // number of p2pkh keys used
-
-	KEYTipHeight = []byte("TipHeight") // height synced to
-)
-
 // make a new change output.  I guess this is supposed to be on a different
 // branch than regular addresses...
 func (w *Wallit) NewChangeOut(amt int64) (*wire.TxOut, error) {
@@ -52,17 +32,13 @@ func (w *Wallit) NewChangeOut(amt int64) (*wire.TxOut, error) {
 // AddPorTxoAdr adds an externally sourced address to the db.  Looks at the keygen
 // to derive hash160.
 func (w *Wallit) AddPorTxoAdr(kg portxo.KeyGen) error {
-	// write to db file
-	return w.StateDB.Update(func(btx *bolt.Tx) error {
-		adrb := btx.Bucket(BKTadr)
-		if adrb == nil {
-			return fmt.Errorf("no adr bucket")
-		}
-
-		adr160 := w.PathPubHash160(kg)
-		logging.Infof("adding addr %x\n", adr160)
-		// add the 20-byte key-hash into the db
-		return adrb.Put(adr160[:], kg.Bytes())
+	adr160 := w.PathPubHash160(kg)
+	logging.Infof("adding addr %x\n", adr160)
+	// add the 20-byte key-hash into the db
+	return w.WalletDB.AddAddress(lncore.CoinAddress{
+		CoinType: int32(w.Param.HDCoinType),
+		PKH:      adr160,
+		KeyGen:   kg,
 	})
 }
 
@@ -70,20 +46,11 @@ func (w *Wallit) AddPorTxoAdr(kg portxo.KeyGen) error {
 // currently returns 20 byte arrays, which
 // can then be converted somewhere else into bech32 addresses (or old base58)
 func (w *Wallit) AdrDump() ([][20]byte, error) {
-	var i, last uint32 // number of addresses made so far
+	var i uint32
 	var adrSlice [][20]byte
 
-	err := w.StateDB.View(func(btx *bolt.Tx) error {
-		sta := btx.Bucket(BKTState)
-		if sta == nil {
-			return fmt.Errorf("no state bucket")
-		}
-
-		oldNBytes := sta.Get(KEYNumKeys)
-		last = lnutil.BtU32(oldNBytes)
-		// update the db with number of created keys
-		return nil
-	})
+	// number of addresses made so far
+	last, err := w.WalletDB.GetNumKeys()
 	if err != nil {
 		return nil, err
 	}
@@ -112,19 +79,11 @@ func (w *Wallit) NewAdr160() ([20]byte, error) {
 		return empty160, fmt.Errorf("NewAdr error: nil param")
 	}
 
-	var n uint32 // number of addresses made so far
-
-	err = w.StateDB.View(func(btx *bolt.Tx) error {
-		sta := btx.Bucket(BKTState)
-		if sta == nil {
-			return fmt.Errorf("no state bucket")
-		}
-
-		oldNBytes := sta.Get(KEYNumKeys)
-		n = lnutil.BtU32(oldNBytes)
-		// update the db with number of created keys
-		return nil
-	})
+	// number of addresses made so far
+	n, err := w.WalletDB.GetNumKeys()
+	if err != nil {
+		return empty160, err
+	}
 	if n > consts.MaxKeyLimit {
 		return empty160, fmt.Errorf("Got %d keys stored, expect something reasonable", n)
 	}
@@ -137,30 +96,20 @@ func (w *Wallit) NewAdr160() ([20]byte, error) {
 	}
 	logging.Infof("adr %d hash is %x\n", n, nAdr160)
 
-	kgBytes := nKg.Bytes()
-
-	// total number of keys (now +1) into 4 bytes
-	nKeyNumBytes := lnutil.U32tB(n + 1)
-
 	// write to db file
-	err = w.StateDB.Update(func(btx *bolt.Tx) error {
-		adrb := btx.Bucket(BKTadr)
-		if adrb == nil {
-			return fmt.Errorf("no adr bucket")
-		}
-		sta := btx.Bucket(BKTState)
-		if sta == nil {
-			return fmt.Errorf("no state bucket")
-		}
-
+	err = w.WalletDB.Batch(func(wdb lncore.LitWalletStorage) error {
 		// add the 20-byte key-hash into the db
-		err = adrb.Put(nAdr160[:], kgBytes)
+		err := wdb.AddAddress(lncore.CoinAddress{
+			CoinType: int32(w.Param.HDCoinType),
+			PKH:      nAdr160,
+			KeyGen:   nKg,
+		})
 		if err != nil {
 			return err
 		}
 
 		// update the db with number of created keys
-		return sta.Put(KEYNumKeys, nKeyNumBytes)
+		return wdb.SetNumKeys(n + 1)
 	})
 	if err != nil {
 		return empty160, err
@@ -177,56 +126,17 @@ func (w *Wallit) NewAdr160() ([20]byte, error) {
 // SetDBSyncHeight sets sync height of the db, indicated the latest block
 // of which it has ingested all the transactions.
 func (w *Wallit) SetDBSyncHeight(n int32) error {
-	var buf bytes.Buffer
-	_ = binary.Write(&buf, binary.BigEndian, n)
-
-	return w.StateDB.Update(func(btx *bolt.Tx) error {
-		sta := btx.Bucket(BKTState)
-		return sta.Put(KEYTipHeight, buf.Bytes())
-	})
+	return w.WalletDB.SetSyncHeight(n)
 }
 
 // SyncHeight returns the chain height to which the db has synced
 func (w *Wallit) GetDBSyncHeight() (int32, error) {
-	var n int32
-	err := w.StateDB.View(func(btx *bolt.Tx) error {
-		sta := btx.Bucket(BKTState)
-		if sta == nil {
-			return fmt.Errorf("no state")
-		}
-		t := sta.Get(KEYTipHeight)
-
-		if t == nil { // no height written, so 0
-			return nil
-		}
-
-		// read 4 byte tip height to n
-		err := binary.Read(bytes.NewBuffer(t), binary.BigEndian, &n)
-		if err != nil {
-			return err
-		}
-		return nil
-	})
-	if err != nil {
-		return 0, err
-	}
-	return n, nil
+	return w.WalletDB.GetSyncHeight()
 }
 
 // SaveTx unconditionally saves a tx in the DB, usually for sending out to nodes
 func (w *Wallit) SaveTx(tx *wire.MsgTx) error {
-	// open db
-	return w.StateDB.Update(func(btx *bolt.Tx) error {
-		// get the outpoint watch bucket
-		txbkt := btx.Bucket(BKTTxns)
-		if txbkt == nil {
-			return fmt.Errorf("tx bucket not in db")
-		}
-		var buf bytes.Buffer
-		tx.Serialize(&buf)
-		txid := tx.TxHash()
-		return txbkt.Put(txid[:], buf.Bytes())
-	})
+	return w.WalletDB.AddTx(tx)
 }
 
 func (w *Wallit) UtxoDump() ([]*portxo.PorTxo, error) {
@@ -236,67 +146,26 @@ func (w *Wallit) UtxoDump() ([]*portxo.PorTxo, error) {
 // GetAllUtxos returns a slice of all portxos in the db. empty slice is OK.
 // Doesn't return watch only outpoints
 func (w *Wallit) GetAllUtxos() ([]*portxo.PorTxo, error) {
-	var utxos []*portxo.PorTxo
-	err := w.StateDB.View(func(btx *bolt.Tx) error {
-		dufb := btx.Bucket(BKToutpoint)
-		if dufb == nil {
-			return fmt.Errorf("no duffel bag")
-		}
-		return dufb.ForEach(func(k, v []byte) error {
-
-			// 0 len v means it's a watch-only utxo, not spendable
-			if len(v) == 0 {
-				// logging.Infof("not nil, 0 len slice\n")
-				return nil
-			}
-
-			// have to copy k and v here, otherwise append will crash it.
-			// not quite sure why but append does weird stuff I guess.
-			// create a new utxo
-			x := make([]byte, len(k)+len(v))
-			copy(x, k)
-			copy(x[len(k):], v)
-			newU, err := portxo.PorTxoFromBytes(x)
-			if err != nil {
-				return err
-			}
-			// and add it to ram
-			utxos = append(utxos, newU)
-			return nil
-		})
-	})
+	us, err := w.WalletDB.GetUtxos()
 	if err != nil {
 		return nil, err
 	}
+
+	var utxos []*portxo.PorTxo
+	for i := range us {
+		utxos = append(utxos, &us[i].PorTxo)
+	}
 	return utxos, nil
 }
 
 // RegisterWatchOP registers an outpoint to watch.  Called from ReallySend()
 func (w *Wallit) RegisterWatchOP(op wire.OutPoint) error {
-	opArr := lnutil.OutPointToBytes(op)
-	// open db
-	return w.StateDB.Update(func(btx *bolt.Tx) error {
-		// get the outpoint watch bucket
-		dufb := btx.Bucket(BKToutpoint)
-		if dufb == nil {
-			return fmt.Errorf("watch bucket not in db")
-		}
-		return dufb.Put(opArr[:], nil)
-	})
+	return w.WalletDB.AddWatchedOutPoint(op)
 }
 
 // UnregisterWatchOP unregisters an outpoint to watch. Used to remove watched HTLC OPs if we claim them ourselves.
 func (w *Wallit) UnregisterWatchOP(op wire.OutPoint) error {
-	opArr := lnutil.OutPointToBytes(op)
-	// open db
-	return w.StateDB.Update(func(btx *bolt.Tx) error {
-		// get the outpoint watch bucket
-		dufb := btx.Bucket(BKToutpoint)
-		if dufb == nil {
-			return fmt.Errorf("watch bucket not in db")
-		}
-		return dufb.Delete(opArr[:])
-	})
+	return w.WalletDB.RemoveWatchedOutPoint(op)
 }
 
 // GainUtxo registers the utxo in the duffel bag
@@ -304,23 +173,7 @@ func (w *Wallit) UnregisterWatchOP(op wire.OutPoint) error {
 func (w *Wallit) GainUtxo(u portxo.PorTxo) error {
 	logging.Infof("gaining exported utxo %s at height %d\n",
 		u.Op.String(), u.Height)
-	// serialize porTxo
-	utxoBytes, err := u.Bytes()
-	if err != nil {
-		return err
-	}
-
-	// open db
-	return w.StateDB.Update(func(btx *bolt.Tx) error {
-		// get the outpoint watch bucket
-		dufb := btx.Bucket(BKToutpoint)
-		if dufb == nil {
-			return fmt.Errorf("duffel bag not in db")
-		}
-
-		// add utxo itself
-		return dufb.Put(utxoBytes[:36], utxoBytes[36:])
-	})
+	return w.WalletDB.AddUtxo(lncore.Utxo{PorTxo: u})
 }
 
 func NewPorTxo(tx *wire.MsgTx, idx uint32, height int32,
@@ -337,83 +190,34 @@ func NewPorTxo(tx *wire.MsgTx, idx uint32, height int32,
 	return ptxo, nil
 }
 
-// NewPorTxoBytesFromKGBytes just calls NewPorTxo() and de/serializes
-// quick shortcut for use in the ingest() function
-func NewPorTxoBytesFromKGBytes(
-	tx *wire.MsgTx, idx uint32, height int32, kgb []byte) ([]byte, error) {
-
-	if len(kgb) != 53 {
-		return nil, fmt.Errorf("keygen %d bytes, expect 53", len(kgb))
-	}
-
-	var kgarr [53]byte
-	copy(kgarr[:], kgb)
-
-	kg := portxo.KeyGenFromBytes(kgarr)
-
-	ptxo, err := NewPorTxo(tx, idx, height, kg)
-	if err != nil {
-		return nil, err
-	}
-	return ptxo.Bytes()
-}
-
 // Rollback rewinds the wallet state to a previous height.  It removes new UTXOs
 func (w *Wallit) RollBack(rollHeight int32) error {
 	// Assume this is an actual reord / rewind.  If you supply a height *greater*
 	// than the current height, all bets are off.  ( probably nothing will
 	// happen; but don't do it)
 
-	// I still don't 100% get how these bolt tx things get encapsulated.
-	return w.StateDB.Update(func(btx *bolt.Tx) error {
+	return w.WalletDB.Batch(func(wdb lncore.LitWalletStorage) error {
 		// range through utxos and remove all above target height
 		logging.Infof("Rollback height %d\n", rollHeight)
 
-		dufb := btx.Bucket(BKToutpoint)
-
-		if dufb == nil {
-			return fmt.Errorf("no duffel bag")
-		}
-
-		// build slice of stuff to delete
-		var killOPs [][]byte
-
-		err := dufb.ForEach(func(k, v []byte) error {
-			var txHeight int32
-			// 0 len v means it's a watch-only utxo, not spendable
-			// we have no way of getting rid of these.  Maybe should!
-			if len(v) == 0 {
-				return nil
-			}
-
-			// all we care about is the height, which starts 8 btyes in to the v
-			buf := bytes.NewBuffer(v)
-
-			// drop first 8 bytes (amt)
-			_ = buf.Next(8)
-
-			err := binary.Read(buf, binary.BigEndian, &txHeight)
-			if err != nil {
-				return err
-			}
-
-			logging.Infof("tx height %d\n", txHeight)
-			if txHeight > rollHeight {
-				// need to kill this TX.  we could save it somewhere else?
-				// just mark to get rid of it for now.
-				killOPs = append(killOPs, k)
-			}
-			return nil
-		})
+		utxos, err := wdb.GetUtxos()
 		if err != nil {
 			return err
 		}
 
-		// now delete em all
-		for _, op := range killOPs {
-			err = dufb.Delete(op)
-			if err != nil {
-				return err
+		// watch-only outpoints aren't in here, and we have no way of getting
+		// rid of those.  Maybe should!
+		var killed int
+		for _, u := range utxos {
+			logging.Infof("tx height %d\n", u.Height)
+			if u.Height > rollHeight {
+				// need to kill this TX.  we could save it somewhere else?
+				// just get rid of it for now.
+				err = wdb.RemoveUtxo(u)
+				if err != nil {
+					return err
+				}
+				killed++
 			}
 		}
 
@@ -424,7 +228,7 @@ func (w *Wallit) RollBack(rollHeight int32) error {
 		// where if the stored txs above the reorg height aren't re-confirmed,
 		// then it will attempt to rebroadcast them.
 
-		logging.Infof("Rollback db.  %d utxos lost\n", len(killOPs))
+		logging.Infof("Rollback db.  %d utxos lost\n", killed)
 
 		return nil
 	})
@@ -453,9 +257,8 @@ func (w *Wallit) IngestMany(txs []*wire.MsgTx, height int32) (uint32, error) {
 
 	// not worth making a struct but these 2 go together
 
-	// spentOPs are all the outpoints being spent by this
-	// batch of txs, serialized into 36 byte arrays
-	spentOPs := make([][36]byte, 0, len(txs)) // at least 1 txin per tx
+	// spentOPs are all the outpoints being spent by this batch of txs
+	spentOPs := make([]wire.OutPoint, 0, len(txs)) // at least 1 txin per tx
 	// spendTxIdx tells which tx (in the txs slice) the utxo loss came from
 	spentTxIdx := make([]uint32, 0, len(txs))
 
@@ -474,94 +277,95 @@ func (w *Wallit) IngestMany(txs []*wire.MsgTx, height int32) (uint32, error) {
 		}
 		// cache all txids
 		cachedShas[i] = utilTx.Hash()
-		// before entering into db, serialize all inputs of ingested txs
+		// before entering into db, collect all inputs of ingested txs
 		for _, txin := range tx.TxIn {
-			spentOPs = append(spentOPs, lnutil.OutPointToBytes(txin.PreviousOutPoint))
+			spentOPs = append(spentOPs, txin.PreviousOutPoint)
 			spentTxIdx = append(spentTxIdx, uint32(i)) // save tx it came from
 		}
 	}
 
 	// now do the db write (this is the expensive / slow part)
-	err = w.StateDB.Update(func(btx *bolt.Tx) error {
-		// get all 4 buckets
-		dufb := btx.Bucket(BKToutpoint)
-		adrb := btx.Bucket(BKTadr)
-		old := btx.Bucket(BKTStxos)
-		txns := btx.Bucket(BKTTxns)
+	err = w.WalletDB.Batch(func(wdb lncore.LitWalletStorage) error {
 
 		// first gain utxos.
 		// for each txout, see if the pkscript matches something we're watching.
 		for i, tx := range txs {
 			for j, out := range tx.TxOut {
-				// Don't try to Get() a nil.  I think? works ok though?
-				keygenBytes := adrb.Get(lnutil.KeyHashFromPkScript(out.PkScript))
-				if keygenBytes != nil {
-					// address matches something we're watching, cool.
-					// logging.Infof("txout script:%x matched kg: %x\n", out.PkScript, keygenBytes)
-
-					// build new portxo
-					txob, err := NewPorTxoBytesFromKGBytes(
-						tx, uint32(j), height, keygenBytes)
-					if err != nil {
-						return err
-					}
+				var kh [20]byte
+				keyHash := lnutil.KeyHashFromPkScript(out.PkScript)
+				if len(keyHash) != 20 {
+					continue // not something we'd have an address for
+				}
+				copy(kh[:], keyHash)
 
-					// Make sure this isn't a duplicate / already been spent
-					// the first 36 bytes of the serialized portxo is the outpoint
-					spendTx := old.Get(txob[:36])
-					if spendTx != nil {
-						// this outpoint has already been spent
-						continue
-					}
+				adr, err := wdb.GetAddress(kh)
+				if err != nil {
+					return err
+				}
+				if adr == nil {
+					continue
+				}
+				// address matches something we're watching, cool.
 
-					// if we've never seen this outpoint before, register it
-					// with the chainhook.  If we've already seen it (maybe getting
-					// confirmed now) we don't need to re-register.
-					existing := dufb.Get(txob[:36])
-					if existing == nil {
-						err = w.Hook.RegisterOutPoint(wire.OutPoint{Hash: tx.TxHash(), Index: uint32(j)})
-						if err != nil {
-							return err
-						}
-					}
+				// build new portxo
+				txo, err := NewPorTxo(tx, uint32(j), height, adr.KeyGen)
+				if err != nil {
+					return err
+				}
+
+				// Make sure this isn't a duplicate / already been spent
+				spent, err := wdb.GetStxo(txo.Op)
+				if err != nil {
+					return err
+				}
+				if spent != nil {
+					// this outpoint has already been spent
+					continue
+				}
 
-					// add hits now though
-					hits++
-					hitTxs[i] = true
-					err = dufb.Put(txob[:36], txob[36:])
+				// if we've never seen this outpoint before, register it
+				// with the chainhook.  If we've already seen it (maybe getting
+				// confirmed now) we don't need to re-register.
+				existing, err := wdb.GetUtxo(txo.Op)
+				if err != nil {
+					return err
+				}
+				if existing == nil {
+					err = w.Hook.RegisterOutPoint(txo.Op)
 					if err != nil {
 						return err
 					}
 				}
+
+				// add hits now though
+				hits++
+				hitTxs[i] = true
+				err = wdb.AddUtxo(lncore.Utxo{PorTxo: *txo})
+				if err != nil {
+					return err
+				}
 			}
 		}
 
-		// iterate through txids, then outpoint bucket to see if height changes
-		// use seek prefix as we know the txid which could match any outpoint
-		// with that hash (in practice usually just 0, 1, 2)
-		for i, txid := range cachedShas {
-			cur := dufb.Cursor()
-			pre := txid.CloneBytes()
-			// iterate through all outpoints that start with the txid (if any)
-			// k is first 36 bytes of portxo, which is the outpoint.
-			// v is the rest of the portxo data, or nothing if it's watch only
-			for k, v := cur.Seek(pre); bytes.HasPrefix(k, pre); k, v = cur.Next() {
-				// note if v is not empty, we'll get back the exported portxo
-				// a second time, so we don't need to do the detection here.
-				// only do this if OPEventChan has been initialized
-				if len(v) == 0 && cap(w.OPEventChan) != 0 {
-					// confirmation of unknown / watch only outpoint, send up to ln
-					// confirmation match detected; return OP event with nil tx
-					// logging.Infof("|||| zomg match  ")
-					hitTxs[i] = true // flag to save tx in db
+		// only do this if OPEventChan has been initialized
+		if cap(w.OPEventChan) != 0 {
+			watched, err := wdb.GetWatchedOutPoints()
+			if err != nil {
+				return err
+			}
 
-					var opArr [36]byte
-					copy(opArr[:], k)
-					op := lnutil.OutPointFromBytes(opArr)
+			// look through txids for watched outpoints with that hash; those
+			// are confirmations of watch only outpoints, send up to ln
+			for i, txid := range cachedShas {
+				for _, op := range watched {
+					if !op.Hash.IsEqual(txid) {
+						continue
+					}
+					hitTxs[i] = true // flag to save tx in db
 
-					// build new outpoint event
+					// build new outpoint event with nil tx
 					var ev lnutil.OutPointEvent
-					ev.Op = *op         // assign outpoint
+					ev.Op = op          // assign outpoint
 					ev.Height = height  // assign height (may be 0)
 					ev.Tx = nil         // doesn't do anything but... for clarity
 					w.OPEventChan <- ev // send into the channel...
@@ -569,63 +373,58 @@ func (w *Wallit) IngestMany(txs []*wire.MsgTx, height int32) (uint32, error) {
 			}
 		}
 
-		// iterate through spent outpoints, then outpoint bucket and look for matches
+		// iterate through spent outpoints, and look for matches
 		// this makes us lose money, which is regrettable, but we need to know.
 		// could lose stuff we just gained, that's OK.
 		for i, curOP := range spentOPs {
-			v := dufb.Get(curOP[:])
-			if v != nil && len(v) == 0 && cap(w.OPEventChan) != 0 {
-				// logging.Infof("|||watch only here zomg\n")
-				hitTxs[spentTxIdx[i]] = true // just save everything
-				op := lnutil.OutPointFromBytes(curOP)
-				// build new outpoint event
-				var ev lnutil.OutPointEvent
-				ev.Op = *op
-				ev.Height = height
-				ev.Tx = txs[spentTxIdx[i]]
-				w.OPEventChan <- ev
-			}
-			if v != nil && len(v) > 0 {
-				hitTxs[spentTxIdx[i]] = true
-				// do all this just to figure out value we lost
-				x := make([]byte, len(curOP)+len(v))
-				copy(x, curOP[:])
-				copy(x[len(curOP):], v)
-				lostTxo, err := portxo.PorTxoFromBytes(x)
-				if err != nil {
-					return err
-				}
-				// print lost portxo
-				logging.Infof(lostTxo.String())
-
-				// after marking for deletion, save stxo to old bucket
-				var st Stxo                               // generate spent txo
-				st.PorTxo = *lostTxo                      // assign outpoint
-				st.SpendHeight = height                   // spent at height
-				st.SpendTxid = *cachedShas[spentTxIdx[i]] // spent by txid
-				stxb, err := st.ToBytes()                 // serialize
+			if cap(w.OPEventChan) != 0 {
+				watched, err := wdb.IsWatchedOutPoint(curOP)
 				if err != nil {
 					return err
 				}
-				// stxos are saved in the DB like portxos, with k:op, v:the rest
-				err = old.Put(stxb[:36], stxb[36:]) // write stxo
-				if err != nil {
-					return err
-				}
-				err = dufb.Delete(curOP[:])
-				if err != nil {
-					return err
+				if watched {
+					hitTxs[spentTxIdx[i]] = true // just save everything
+					// build new outpoint event
+					var ev lnutil.OutPointEvent
+					ev.Op = curOP
+					ev.Height = height
+					ev.Tx = txs[spentTxIdx[i]]
+					w.OPEventChan <- ev
 				}
 			}
+
+			lostTxo, err := wdb.GetUtxo(curOP)
+			if err != nil {
+				return err
+			}
+			if lostTxo == nil {
+				continue
+			}
+			hitTxs[spentTxIdx[i]] = true
+
+			// print lost portxo
+			logging.Infof(lostTxo.String())
+
+			// save stxo, then get rid of the utxo
+			var st lncore.Stxo                        // generate spent txo
+			st.PorTxo = lostTxo.PorTxo                // assign outpoint
+			st.SpendHeight = height                   // spent at height
+			st.SpendTxid = *cachedShas[spentTxIdx[i]] // spent by txid
+			err = wdb.AddStxo(st)
+			if err != nil {
+				return err
+			}
+			err = wdb.RemoveUtxo(*lostTxo)
+			if err != nil {
+				return err
+			}
 		}
 
 		// save all txs with hits
 		for i, tx := range txs {
 			if hitTxs[i] == true {
 				hits++
-				var buf bytes.Buffer
-				tx.Serialize(&buf) // always store witness version
-				err = txns.Put(cachedShas[i].CloneBytes(), buf.Bytes())
+				err = wdb.AddTx(tx)
 				if err != nil {
 					return err
 				}
@@ -633,6 +432,14 @@ func (w *Wallit) IngestMany(txs []*wire.MsgTx, height int32) (uint32, error) {
 		}
 		return nil
 	})
+	if err != nil {
+		return hits, err
+	}
+
+	// confirmations may settle fee bumps
+	if height > 0 {
+		err = w.settleReplacements(cachedShas, height)
+	}
 
 	logging.Infof("ingest %d txs, %d hits\n", len(txs), hits)
 	return hits, err
@@ -6,9 +6,9 @@ import (
 	"path/filepath"
 	"strings"
 
-	"github.com/boltdb/bolt"
 	"github.com/mit-dci/lit/btcutil/hdkeychain"
 	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/lit/lncore"
 	"github.com/mit-dci/lit/lnutil"
 	"github.com/mit-dci/lit/logging"
 	"github.com/mit-dci/lit/powless"
@@ -18,14 +18,16 @@ import (
 
 func NewWallit(
 	rootkey *hdkeychain.ExtendedKey, birthHeight int32, resync bool,
-	spvhost, path string, proxyURL string, p *coinparam.Params) (*Wallit, int, error) {
+	spvhost, path string, proxyURL string, p *coinparam.Params,
+	wdb lncore.LitWalletStorage) (*Wallit, int, error) {
 
 	var w Wallit
 	w.rootPrivKey = rootkey
 	w.Param = p
-	w.FreezeSet = make(map[wire.OutPoint]*FrozenTx)
+	w.WalletDB = wdb
+	w.FreezeSet = make(map[wire.OutPoint]*lncore.FrozenTx)
 
-	w.FeeRate = w.Param.FeePerByte
+	w.StaticFee.FeePerByte = w.Param.FeePerByte
 
 	wallitpath := filepath.Join(path, p.Name)
 
@@ -48,12 +50,30 @@ func NewWallit(
 		// no https; use uSPV for chainhook
 		w.Hook = new(uspv.SPVCon)
 	}
+	// use the hook for fee estimates if it can do them
+	est, ok := w.Hook.(uspv.FeeEstimator)
+	if ok {
+		w.FeeEstimator = est
+	}
+
+	// wallets used to have their own db file, bring anything in there over.
+	// If that doesn't work, don't start on a wallet that's missing some of it
+	err = w.migrateLegacyDB(filepath.Join(wallitpath, "utxo.db"))
+	if err != nil {
+		return nil, 0, fmt.Errorf("can't migrate wallet db: %s", err.Error())
+	}
 
-	wallitdbname := filepath.Join(wallitpath, "utxo.db")
-	err = w.OpenDB(wallitdbname)
+	// any frozen txs left over are from funding that didn't survive the
+	// restart, so nothing is ever going to send them.  Let the coins go.
+	err = w.dropFrozenTxs()
 	if err != nil {
 		logging.Errorf("NewWallit crash  %s ", err.Error())
 	}
+	err = w.pruneLocks()
+	if err != nil {
+		logging.Errorf("NewWallit crash  %s ", err.Error())
+	}
+
 	// get height
 	height := w.CurrentHeight()
 	logging.Infof("DB current height %d\n", height)
@@ -150,58 +170,3 @@ func (w *Wallit) HeightHandler(incomingHeight chan int32) {
 		prevHeight = h
 	}
 }
-
-// OpenDB starts up the database.  Creates the file if it doesn't exist.
-func (w *Wallit) OpenDB(filename string) error {
-	var err error
-	var numKeys uint32
-	w.StateDB, err = bolt.Open(filename, 0644, nil)
-	if err != nil {
-		return err
-	}
-	// create buckets if they're not already there
-	err = w.StateDB.Update(func(btx *bolt.Tx) error {
-		_, err = btx.CreateBucketIfNotExists(BKToutpoint)
-		if err != nil {
-			return err
-		}
-		_, err = btx.CreateBucketIfNotExists(BKTadr)
-		if err != nil {
-			return err
-		}
-		_, err = btx.CreateBucketIfNotExists(BKTStxos)
-		if err != nil {
-			return err
-		}
-		_, err = btx.CreateBucketIfNotExists(BKTTxns)
-		if err != nil {
-			return err
-		}
-
-		sta, err := btx.CreateBucketIfNotExists(BKTState)
-		if err != nil {
-			return err
-		}
-
-		numKeysBytes := sta.Get(KEYNumKeys)
-		if numKeysBytes != nil { // NumKeys exists, read into uint32
-			numKeys = lnutil.BtU32(numKeysBytes)
-			logging.Infof("db says %d keys\n", numKeys)
-		} else { // no adrs yet, make it 0.  Then make an address.
-			logging.Infof("NumKeys not in DB, must be new DB. 0 Keys\n")
-			numKeys = 0
-			b0 := lnutil.U32tB(numKeys)
-			err = sta.Put(KEYNumKeys, b0)
-			if err != nil {
-				return err
-			}
-		}
-
-		return nil
-	})
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
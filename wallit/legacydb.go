@@ -0,0 +1,167 @@
+package wallit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+
+	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/lncore"
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/logging"
+	"github.com/mit-dci/lit/portxo"
+	"github.com/mit-dci/lit/wire"
+)
+
+// buckets in the old per-coin utxo.db files, before wallets were kept in the
+// LitWalletStorage.
+var (
+	// storage of all utxos. top level is outpoints.
+	BKToutpoint = []byte("DuffelBag")
+	// storage of all addresses being watched.  top level is pkscripts
+	BKTadr = []byte("adr")
+
+	BKTStxos = []byte("SpentTxs")  // for bookkeeping / not sure
+	BKTTxns  = []byte("Txns")      // all txs we care about, for replays
+	BKTState = []byte("MiscState") // misc states of DB
+
+	// these are in the state bucket
+	KEYNumKeys = []byte("NumKeys") // number of p2pkh keys used
+
+	KEYTipHeight = []byte("TipHeight") // height synced to
+)
+
+// migrateLegacyDB copies everything out of an old utxo.db file into the
+// wallet storage, then moves the old file out of the way so we don't do it
+// again.  Does nothing if there's no old file.
+func (w *Wallit) migrateLegacyDB(filename string) error {
+	_, err := os.Stat(filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	logging.Infof("migrating old wallet db %s\n", filename)
+
+	old, err := bolt.Open(filename, 0644, nil)
+	if err != nil {
+		return err
+	}
+
+	err = old.View(func(btx *bolt.Tx) error {
+		return w.WalletDB.Batch(func(wdb lncore.LitWalletStorage) error {
+			return copyLegacyBuckets(btx, wdb)
+		})
+	})
+	old.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(filename, filename+".migrated")
+}
+
+func copyLegacyBuckets(btx *bolt.Tx, wdb lncore.LitWalletStorage) error {
+	if dufb := btx.Bucket(BKToutpoint); dufb != nil {
+		err := dufb.ForEach(func(k, v []byte) error {
+			var opArr [36]byte
+			copy(opArr[:], k)
+
+			// 0 len v means it's a watch-only utxo
+			if len(v) == 0 {
+				return wdb.AddWatchedOutPoint(*lnutil.OutPointFromBytes(opArr))
+			}
+
+			u, err := portxo.PorTxoFromBytes(append(opArr[:], v...))
+			if err != nil {
+				return err
+			}
+			return wdb.AddUtxo(lncore.Utxo{PorTxo: *u})
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if adrb := btx.Bucket(BKTadr); adrb != nil {
+		err := adrb.ForEach(func(k, v []byte) error {
+			if len(k) != 20 || len(v) != 53 {
+				return nil
+			}
+			var a lncore.CoinAddress
+			var kgarr [53]byte
+			copy(a.PKH[:], k)
+			copy(kgarr[:], v)
+			a.KeyGen = portxo.KeyGenFromBytes(kgarr)
+			a.CoinType = wdb.GetCoinTypeId()
+			return wdb.AddAddress(a)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if old := btx.Bucket(BKTStxos); old != nil {
+		err := old.ForEach(func(k, v []byte) error {
+			st, err := lncore.StxoFromBytes(append(append([]byte{}, k...), v...))
+			if err != nil {
+				return err
+			}
+			return wdb.AddStxo(st)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if txns := btx.Bucket(BKTTxns); txns != nil {
+		err := txns.ForEach(func(_, v []byte) error {
+			tx := wire.NewMsgTx()
+			err := tx.Deserialize(bytes.NewReader(v))
+			if err != nil {
+				return err
+			}
+			return wdb.AddTx(tx)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if sta := btx.Bucket(BKTState); sta != nil {
+		if nk := sta.Get(KEYNumKeys); nk != nil {
+			err := wdb.SetNumKeys(lnutil.BtU32(nk))
+			if err != nil {
+				return err
+			}
+		}
+		if t := sta.Get(KEYTipHeight); t != nil {
+			var n int32
+			err := binary.Read(bytes.NewBuffer(t), binary.BigEndian, &n)
+			if err != nil {
+				return err
+			}
+			err = wdb.SetSyncHeight(n)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// dropFrozenTxs clears out any frozen txs stored from a previous run.
+func (w *Wallit) dropFrozenTxs() error {
+	ftxs, err := w.WalletDB.GetFrozenTxs()
+	if err != nil {
+		return err
+	}
+	for _, ftx := range ftxs {
+		logging.Infof("dropping frozen tx %s from before restart\n", ftx.Txid.String())
+		err = w.WalletDB.RemoveFrozenTx(ftx.Txid)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package wallit
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mit-dci/lit/btcutil/psbt"
+	"github.com/mit-dci/lit/btcutil/txscript"
+	"github.com/mit-dci/lit/consts"
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/logging"
+	"github.com/mit-dci/lit/portxo"
+	"github.com/mit-dci/lit/wire"
+)
+
+/*
+PSBTs (BIP 174) let a tx be built in one place and signed in others.  Lit can
+make them out of its own utxos, and sign its inputs in ones made elsewhere.
+Nobody else signs our inputs, so they're finalized as soon as they're signed.
+*/
+
+// psbtInput gives what a signer needs to know to spend one of our utxos.
+// Witness inputs only need the output; others need the whole previous tx,
+// which we have if we saw it come in.
+func (w *Wallit) psbtInput(u *portxo.PorTxo) (psbt.PInput, error) {
+	var in psbt.PInput
+	switch u.Mode {
+	case portxo.TxoP2WPKHComp:
+		in.WitnessUtxo = wire.NewTxOut(u.Value, u.PkScript)
+	case portxo.TxoP2WSHComp:
+		in.WitnessUtxo = wire.NewTxOut(u.Value, lnutil.P2WSHify(u.PkScript))
+		in.WitnessScript = u.PkScript
+	default:
+		prevTx, err := w.WalletDB.GetTx(u.Op.Hash)
+		if err != nil {
+			return in, err
+		}
+		in.NonWitnessUtxo = prevTx
+	}
+	return in, nil
+}
+
+// BuildDontSignPsbt is BuildDontSign, but gives a PSBT with what's needed to
+// sign each input filled in.
+func (w *Wallit) BuildDontSignPsbt(
+	utxos []*portxo.PorTxo, txos []*wire.TxOut) (*psbt.Packet, error) {
+
+	tx, err := w.BuildDontSign(utxos, txos)
+	if err != nil {
+		return nil, err
+	}
+	p, err := psbt.NewFromUnsignedTx(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	// BuildDontSign sorted the inputs, so match them back up
+	byOp := make(map[wire.OutPoint]*portxo.PorTxo, len(utxos))
+	for _, u := range utxos {
+		byOp[u.Op] = u
+	}
+	for i, txin := range tx.TxIn {
+		p.Inputs[i], err = w.psbtInput(byOp[txin.PreviousOutPoint])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// CreatePsbt makes an unsigned PSBT paying txos, from ins if given or
+// inputs picked by the wallet otherwise, with change back to us.  The
+// inputs are locked so nothing else spends them; unlock them if the PSBT
+// never gets sent.
+func (w *Wallit) CreatePsbt(txos []*wire.TxOut, ins []wire.OutPoint,
+	feePerByte int64) (*psbt.Packet, error) {
+
+	var totalSend, outputByteSize int64
+	for _, txo := range txos {
+		if txo.Value < consts.DustCutoff {
+			return nil, fmt.Errorf("output of %d is below dust cutoff", txo.Value)
+		}
+		totalSend += txo.Value
+		outputByteSize += 8 + int64(len(txo.PkScript))
+	}
+
+	w.FreezeMutex.Lock()
+	defer w.FreezeMutex.Unlock()
+
+	var utxos portxo.TxoSliceByBip69
+	var overshoot int64
+	var err error
+	if len(ins) > 0 {
+		utxos, overshoot, err =
+			w.SelectUtxos(ins, totalSend, outputByteSize, feePerByte, false)
+	} else {
+		utxos, overshoot, err =
+			w.PickUtxos(totalSend, outputByteSize, feePerByte, false)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// same change rule as MaybeSend
+	changeOutFee := 30 * feePerByte
+	if overshoot > consts.DustCutoff+changeOutFee {
+		changeOut, err := w.NewChangeOut(overshoot - changeOutFee)
+		if err != nil {
+			return nil, err
+		}
+		txos = append(txos, changeOut)
+	}
+
+	p, err := w.BuildDontSignPsbt(utxos, txos)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, u := range utxos {
+		// if the PSBT is abandoned, the coins free up on their own
+		err = w.WalletDB.AddLockedOutPointUntil(
+			u.Op, time.Now().Unix()+consts.PsbtLockTime)
+		if err != nil {
+			return nil, err
+		}
+	}
+	logging.Infof("CreatePsbt: %d inputs, %d outputs\n",
+		len(p.UnsignedTx.TxIn), len(p.UnsignedTx.TxOut))
+	return p, nil
+}
+
+// SignMyPsbtInputs is SignMyInputs for a PSBT.  Inputs that are ours get
+// signed and finalized, and their utxo info filled in; the rest are left
+// alone.  Returns the same packet.
+func (w *Wallit) SignMyPsbtInputs(p *psbt.Packet) (*psbt.Packet, error) {
+	err := p.SanityCheck()
+	if err != nil {
+		return nil, err
+	}
+
+	// sign a copy of the tx, and see which inputs got signed
+	tx := p.UnsignedTx.Copy()
+	err = w.SignMyInputs(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, txin := range tx.TxIn {
+		if txin.SignatureScript == nil && txin.Witness == nil {
+			continue // not ours
+		}
+		in := &p.Inputs[i]
+		if in.IsFinalized() {
+			continue
+		}
+		if in.SighashType != 0 && in.SighashType != txscript.SigHashAll {
+			return nil, fmt.Errorf("input %d wants sighash type %d, can only do ALL",
+				i, in.SighashType)
+		}
+
+		u, err := w.WalletDB.GetUtxo(txin.PreviousOutPoint)
+		if err != nil {
+			return nil, err
+		}
+		if u != nil && in.WitnessUtxo == nil && in.NonWitnessUtxo == nil {
+			info, err := w.psbtInput(&u.PorTxo)
+			if err != nil {
+				return nil, err
+			}
+			in.WitnessUtxo = info.WitnessUtxo
+			in.NonWitnessUtxo = info.NonWitnessUtxo
+		}
+
+		in.FinalScriptSig = txin.SignatureScript
+		if txin.Witness != nil {
+			in.FinalScriptWitness, err = psbt.SerializeWitness(txin.Witness)
+			if err != nil {
+				return nil, err
+			}
+		}
+		in.PartialSigs = nil
+		in.SighashType = 0
+		in.RedeemScript = nil
+		in.WitnessScript = nil
+		in.Bip32Derivation = nil
+	}
+	return p, nil
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/mit-dci/lit/btcutil/txscript"
 	"github.com/mit-dci/lit/btcutil/txsort"
 	"github.com/mit-dci/lit/consts"
+	"github.com/mit-dci/lit/lncore"
 	"github.com/mit-dci/lit/lnutil"
 	"github.com/mit-dci/lit/portxo"
 	"github.com/mit-dci/lit/wire"
@@ -23,13 +24,14 @@ import (
 // Bunch of redundancy with SendMany, maybe move that to a shared function...
 //NOTE this does not support multiple txouts with identical pkscripts in one tx.
 // The code would be trivial; it's not supported on purpose.  Use unique pkscripts.
-func (w *Wallit) MaybeSend(txos []*wire.TxOut, ow bool) ([]*wire.OutPoint, error) {
+// feePerByte is usually from EstimateFee.  If ins is given, those are the
+// inputs; otherwise PickUtxos chooses.
+func (w *Wallit) MaybeSend(txos []*wire.TxOut, ins []wire.OutPoint,
+	feePerByte int64, ow bool) ([]*wire.OutPoint, error) {
 	var err error
 	var totalSend int64
 	dustCutoff := consts.DustCutoff // below this amount, just give to miners
 
-	feePerByte := w.FeeRate
-
 	// make an initial txo copy so we can find where the outputs end up in final tx
 
 	initTxos := make([]*wire.TxOut, len(txos))
@@ -52,8 +54,15 @@ func (w *Wallit) MaybeSend(txos []*wire.TxOut, ow bool) ([]*wire.OutPoint, error
 	defer w.FreezeMutex.Unlock()
 
 	// get inputs for this tx.  Only segwit if needed
-	utxos, overshoot, err :=
-		w.PickUtxos(totalSend, outputByteSize, feePerByte, ow)
+	var utxos portxo.TxoSliceByBip69
+	var overshoot int64
+	if len(ins) > 0 {
+		utxos, overshoot, err =
+			w.SelectUtxos(ins, totalSend, outputByteSize, feePerByte, ow)
+	} else {
+		utxos, overshoot, err =
+			w.PickUtxos(totalSend, outputByteSize, feePerByte, ow)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -72,7 +81,7 @@ func (w *Wallit) MaybeSend(txos []*wire.TxOut, ow bool) ([]*wire.OutPoint, error
 	}
 
 	// build frozen tx for later broadcast
-	fTx := new(FrozenTx)
+	fTx := new(lncore.FrozenTx)
 	fTx.Ins = utxos
 	fTx.Outs = txos
 	fTx.ChangeOut = changeOut
@@ -91,6 +100,12 @@ func (w *Wallit) MaybeSend(txos []*wire.TxOut, ow bool) ([]*wire.OutPoint, error
 	fTx.Nlock = tx.LockTime
 	fTx.Txid = tx.TxHash()
 
+	// write it down so the freeze is visible outside of ram too
+	err = w.WalletDB.AddFrozenTx(*fTx)
+	if err != nil {
+		return nil, err
+	}
+
 	for _, utxo := range utxos {
 		w.FreezeSet[utxo.Op] = fTx
 	}
@@ -127,6 +142,10 @@ func (w *Wallit) ReallySend(txid *chainhash.Hash) error {
 		logging.Infof("\t remove %s from frozen outpoints\n", txin.Op.String())
 		delete(w.FreezeSet, txin.Op)
 	}
+	err = w.WalletDB.RemoveFrozenTx(frozenTx.Txid)
+	if err != nil {
+		return err
+	}
 
 	allOuts := frozenTx.Outs
 
@@ -159,11 +178,11 @@ func (w *Wallit) NahDontSend(txid *chainhash.Hash) error {
 		logging.Infof("\t remove %s from frozen outpoints\n", txin.Op.String())
 		delete(w.FreezeSet, txin.Op)
 	}
-	return nil
+	return w.WalletDB.RemoveFrozenTx(frozenTx.Txid)
 }
 
 // FindFreezeTx looks through the frozen map to find a tx.  Error if it can't find it
-func (w *Wallit) FindFreezeTx(txid *chainhash.Hash) (*FrozenTx, error) {
+func (w *Wallit) FindFreezeTx(txid *chainhash.Hash) (*lncore.FrozenTx, error) {
 	for op := range w.FreezeSet {
 		frozenTxid := w.FreezeSet[op].Txid
 		if frozenTxid.IsEqual(txid) {
@@ -229,7 +248,8 @@ func (w *Wallit) NewOutgoingTx(tx *wire.MsgTx) error {
 // It returns a tx-sortable utxoslice, and the overshoot amount.  Also errors.
 // if "ow" is true, only gives witness utxos (for channel funding)
 // The overshoot amount is *after* fees, so can be used directly for a
-// change output.
+// change output.  It's small (under the dust cutoff) if selectCoins found a
+// set of inputs which doesn't need change.
 func (w *Wallit) PickUtxos(
 	amtWanted, outputByteSize, feePerByte int64,
 	ow bool) (portxo.TxoSliceByBip69, int64, error) {
@@ -239,74 +259,24 @@ func (w *Wallit) PickUtxos(
 		return nil, 0, err
 	}
 
-	var allUtxos portxo.TxoSliceByAmt
-	allUtxos, err = w.GetAllUtxos()
+	allUtxos, err := w.GetAllUtxos()
 	if err != nil {
 		return nil, 0, err
 	}
 
-	// remove frozen utxos from allUtxo slice.  Iterate backwards / trailing delete
-	for i := len(allUtxos) - 1; i >= 0; i-- {
-		_, frozen := w.FreezeSet[allUtxos[i].Op]
-		if frozen {
-			// faster than append, and we're sorting a few lines later anyway
-			allUtxos[i] = allUtxos[len(allUtxos)-1] // redundant if at last index
-			allUtxos = allUtxos[:len(allUtxos)-1]   // trim last element
-		}
-	}
-
-	// start with utxos sorted by value and pop off utxos which are greater
-	// than the send amount... as long as the next 2 are greater.
-	// simple / straightforward coin selection optimization, which tends to make
-	// 2 in 2 out
-
-	// smallest and unconfirmed last (because it's reversed)
-	sort.Sort(sort.Reverse(allUtxos))
-
-	// guessing that txs won't be more than 10K here...
-	maxFeeGuess := feePerByte * consts.MaxTxCount
-
-	// first pass of removing candidate utxos; if the next one is bigger than
-	// we need, remove the top one.
-	for len(allUtxos) > 1 &&
-		allUtxos[1].Value > amtWanted+maxFeeGuess &&
-		allUtxos[1].Height > 100 &&
-		!(ow && allUtxos[1].Mode&portxo.FlagTxoWitness == 0) {
-		allUtxos = allUtxos[1:]
-	}
-
-	// if we've got 2 or more confirmed utxos, and the next one is
-	// more than enough, pop off the first one.
-	// Note that there are probably all sorts of edge cases where this will
-	// result in not being able to send money when you should be able to.
-	// Thus the handwavey "maxFeeGuess"
-	for len(allUtxos) > 2 &&
-		allUtxos[2].Height > 100 && // since sorted, don't need to check [1]
-		allUtxos[1].Mature(curHeight) &&
-		allUtxos[2].Mature(curHeight) &&
-		allUtxos[1].Value+allUtxos[2].Value > amtWanted+maxFeeGuess &&
-		!(ow && allUtxos[2].Mode&portxo.FlagTxoWitness == 0) &&
-		!(ow && allUtxos[1].Mode&portxo.FlagTxoWitness == 0) {
-		logging.Infof("remaining utxo list, in order:\n")
-		for _, u := range allUtxos {
-			logging.Infof("\t h: %d amt: %d\n", u.Height, u.Value)
-		}
-		allUtxos = allUtxos[1:]
+	locked, err := w.lockedSet()
+	if err != nil {
+		return nil, 0, err
 	}
 
-	// coin selection is super complex, and we can definitely do a lot better
-	// here!
-	// TODO: anyone who wants to: implement more advanced coin selection algo
-
-	// rSlice is the return slice of the utxos which are going into the tx
-	var rSlice portxo.TxoSliceByBip69
-	// add utxos until we've had enough
-	remaining := amtWanted // remaining is how much is needed on input side
+	// only the utxos we can use: not frozen or locked, mature, witness if
+	// needed.  Unconfirmed ones are only used if confirmed aren't enough.
+	var confirmed, all []*portxo.PorTxo
 	for _, utxo := range allUtxos {
-		// skip unconfirmed.  Or de-prioritize? Some option for this...
-		//		if utxo.AtHeight == 0 {
-		//			continue
-		//		}
+		_, frozen := w.FreezeSet[utxo.Op]
+		if frozen || locked[utxo.Op] {
+			continue
+		}
 		if !utxo.Mature(curHeight) {
 			continue // skip immature or unconfirmed time-locked sh outputs
 		}
@@ -317,31 +287,29 @@ func (w *Wallit) PickUtxos(
 		if utxo.Value < 1 {
 			continue
 		}
-		// yeah, lets add this utxo!
-		rSlice = append(rSlice, utxo)
-		remaining -= utxo.Value
-		// if remaining is positive, don't bother checking fee yet.
-		// if remaining is negative, calculate needed fee
-		if remaining <= 0 {
-			fee := EstFee(rSlice, outputByteSize, feePerByte)
-			// subtract fee from returned overshoot.
-			// (remaining is negative here)
-			remaining += fee
-
-			// done adding utxos if remaining below negative est fee
-			if remaining < -fee {
-				break
-			}
+		all = append(all, utxo)
+		if utxo.Height > 0 {
+			confirmed = append(confirmed, utxo)
 		}
 	}
 
-	if remaining > 0 {
-		return nil, 0, fmt.Errorf("wanted %d but %d available.",
-			amtWanted, amtWanted-remaining)
+	rSlice, overshoot, ok :=
+		selectCoins(confirmed, amtWanted, outputByteSize, feePerByte)
+	if !ok {
+		rSlice, overshoot, ok =
+			selectCoins(all, amtWanted, outputByteSize, feePerByte)
+	}
+	if !ok {
+		var available int64
+		for _, u := range all {
+			available += u.Value
+		}
+		return nil, 0, fmt.Errorf("wanted %d plus fee but %d available.",
+			amtWanted, available)
 	}
 
-	sort.Sort(rSlice) // send sorted.  This is probably redundant?
-	return rSlice, -remaining, nil
+	logging.Infof("PickUtxos: %d inputs, overshoot %d\n", len(rSlice), overshoot)
+	return rSlice, overshoot, nil
 }
 
 // SendOne is for the sweep function, and doesn't do change.
@@ -366,7 +334,7 @@ func (w *Wallit) SendOne(u portxo.PorTxo, outScript []byte) (*wire.MsgTx, error)
 		return nil, fmt.Errorf("Can't spend, immature")
 	}
 	// fixed fee
-	fee := w.FeeRate * 200
+	fee := w.Fee() * 200
 
 	sendAmt := u.Value - fee
 
@@ -398,6 +366,8 @@ func (w *Wallit) BuildDontSign(
 		// set sequence field if it's in the portxo
 		if u.Seq > 1 {
 			tx.TxIn[i].Sequence = u.Seq
+		} else {
+			tx.TxIn[i].Sequence = RBFSequence
 		}
 	}
 	// sort in place before signing
@@ -526,6 +496,8 @@ func (w *Wallit) BuildAndSign(
 		// set sequence field if it's in the portxo
 		if u.Seq > 1 {
 			tx.TxIn[i].Sequence = u.Seq
+		} else {
+			tx.TxIn[i].Sequence = RBFSequence
 		}
 	}
 	// sort txouts in place before signing.  txins are already sorted from above
@@ -1,20 +1,15 @@
 package wallit
 
 import (
-	"bytes"
-	"encoding/binary"
 	"fmt"
 	"sync"
 
-	"github.com/boltdb/bolt"
 	"github.com/mit-dci/lit/btcutil"
 	"github.com/mit-dci/lit/btcutil/blockchain"
-	"github.com/mit-dci/lit/btcutil/chaincfg/chainhash"
 	"github.com/mit-dci/lit/btcutil/hdkeychain"
 	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/lit/lncore"
 	"github.com/mit-dci/lit/lnutil"
-	"github.com/mit-dci/lit/logging"
-	"github.com/mit-dci/lit/portxo"
 	"github.com/mit-dci/lit/uspv"
 	"github.com/mit-dci/lit/wire"
 )
@@ -22,11 +17,11 @@ import (
 // The Wallit is lit's main wallet struct.  It's got the root key, the dbs, and
 // contains the SPVhooks into the network.
 type Wallit struct {
-	// could get rid of adr slice, it's just an in-ram cache...
-	StateDB *bolt.DB // place to write all this down
+	// place to write all this down
+	WalletDB lncore.LitWalletStorage
 
 	// Set of frozen utxos not to use... they point to the tx using em
-	FreezeSet   map[wire.OutPoint]*FrozenTx
+	FreezeSet   map[wire.OutPoint]*lncore.FrozenTx
 	FreezeMutex sync.Mutex
 
 	// OPEventChan sends events to the LN wallet.
@@ -45,28 +40,18 @@ type Wallit struct {
 	// like an interfaces library, ... lnutil?
 	Hook uspv.ChainHook
 
-	// current fee per byte
+	// FeeEstimator gives fee rates for a confirmation target.  Usually the
+	// Hook; StaticFee is used when it can't give one.
+	FeeEstimator uspv.FeeEstimator
+	StaticFee    uspv.StaticFeeEstimator
+
+	// fee per byte set by the user.  Overrides estimation when nonzero.
 	FeeRate int64
 
 	// From here, comes everything. It's a secret to everybody.
 	rootPrivKey *hdkeychain.ExtendedKey
 }
 
-type FrozenTx struct {
-	Ins       []*portxo.PorTxo `json:"ins"`
-	Outs      []*wire.TxOut    `json:"outs"`
-	ChangeOut *wire.TxOut      `json:"changeout"`
-	Nlock     uint32           `json:"nlocktime"`
-	Txid      chainhash.Hash   `json:"txid"`
-}
-
-// Stxo is a utxo that has moved on.
-type Stxo struct {
-	PorTxo      portxo.PorTxo  `json:"txo"`    // when it used to be a utxo
-	SpendHeight int32          `json:"height"` // height at which it met its demise
-	SpendTxid   chainhash.Hash `json:"txid"`   // the tx that consumed it
-}
-
 // TxToString prints out some info about a transaction. for testing / debugging
 func TxToString(tx *wire.MsgTx) string {
 	utx := btcutil.NewTx(tx)
@@ -91,78 +76,3 @@ func TxToString(tx *wire.MsgTx) string {
 	}
 	return str
 }
-
-/*----- serialization for stxos ------- */
-/* Stxo serialization:
-bytelength   desc   at offset
-
-53			portxo		0
-4			sheight		53
-32			stxid		57
-
-end len 	89
-*/
-
-// ToBytes turns an Stxo into some bytes.
-// prevUtxo serialization, then spendheight [4], spendtxid [32]
-func (s *Stxo) ToBytes() ([]byte, error) {
-	var buf bytes.Buffer
-
-	// serialize the utxo part
-	uBytes, err := s.PorTxo.Bytes()
-	if err != nil {
-		return nil, err
-	}
-	// write that into the buffer
-	_, err = buf.Write(uBytes)
-	if err != nil {
-		return nil, err
-	}
-
-	// write 4 byte height where the txo was spent
-	err = binary.Write(&buf, binary.BigEndian, s.SpendHeight)
-	if err != nil {
-		return nil, err
-	}
-	// write 32 byte txid of the spending transaction
-	_, err = buf.Write(s.SpendTxid.CloneBytes())
-	if err != nil {
-		return nil, err
-	}
-
-	return buf.Bytes(), nil
-}
-
-// StxoFromBytes turns bytes into a Stxo.
-// it's a portxo with a spendHeight and spendTxid at the end.
-func StxoFromBytes(b []byte) (Stxo, error) {
-	var s Stxo
-
-	l := len(b)
-	if l < 96 {
-		return s, fmt.Errorf("Got %d bytes for stxo, expect a bunch", len(b))
-	}
-
-	// last 36 bytes are height & spend txid.
-	u, err := portxo.PorTxoFromBytes(b[:l-36])
-	if err != nil {
-		logging.Errorf(" eof? ")
-		return s, err
-	}
-
-	buf := bytes.NewBuffer(b[l-36:])
-	// read 4 byte spend height
-	err = binary.Read(buf, binary.BigEndian, &s.SpendHeight)
-	if err != nil {
-		return s, err
-	}
-	// read 32 byte txid
-	err = s.SpendTxid.SetBytes(buf.Next(32))
-	if err != nil {
-		return s, err
-	}
-
-	s.PorTxo = *u // assign the utxo
-
-	return s, nil
-}